@@ -0,0 +1,93 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/utils"
+
+	"github.com/urfave/cli/v2"
+)
+
+func cmdBackfill() *cli.Command {
+	return &cli.Command{
+		Name:      "backfill",
+		Action:    backfill,
+		Category:  "ADMIN",
+		Usage:     "Copy the objects missing in some replicas of a replicated storage",
+		ArgsUsage: "META-URL",
+		Description: `
+It compares the objects in all the buckets of a volume formatted with "--storage replica", and copies
+the objects missing in some of them (e.g. written during an outage of that bucket) from the others.
+The objects failed to be deleted from some of the buckets are removed from the others instead.
+
+Examples:
+$ juicefs backfill redis://localhost
+
+# Use more threads to copy the objects
+$ juicefs backfill redis://localhost -p 50`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "threads",
+				Aliases: []string{"p"},
+				Value:   10,
+				Usage:   "number of threads to copy objects",
+			},
+		},
+	}
+}
+
+func backfill(ctx *cli.Context) error {
+	setup(ctx, 1)
+	removePassword(ctx.Args().Get(0))
+	m := meta.NewClient(ctx.Args().Get(0), nil)
+	format, err := m.Load(true)
+	if err != nil {
+		logger.Fatalf("load setting: %s", err)
+	}
+	if strings.ToLower(format.Storage) != "replica" {
+		logger.Fatalf("Storage of volume %s is %s, not replica", format.Name, format.Storage)
+	}
+	threads := ctx.Int("threads")
+	if threads <= 0 {
+		logger.Fatalf("threads should be greater than 0")
+	}
+	// the objects are copied as they are, no need to decrypt or decompress them
+	blob, err := object.NewReplicated(format.Bucket, format.AccessKey, format.SecretKey, format.SessionToken)
+	if err != nil {
+		logger.Fatalf("object storage: %s", err)
+	}
+	defer object.Shutdown(blob)
+	logger.Infof("Data use %s", blob)
+
+	progress := utils.NewProgress(false)
+	copied := progress.AddDoubleSpinner("Backfilled objects")
+	err = object.Backfill(ctx.Context, blob, format.Name+"/", threads, func(key string, size int64) {
+		copied.IncrInt64(size)
+	})
+	copied.Done()
+	progress.Done()
+	c, b := copied.Current()
+	logger.Infof("Backfilled %d objects (%d bytes)", c, b)
+	if err != nil {
+		logger.Fatalf("backfill: %s", err)
+	}
+	return nil
+}
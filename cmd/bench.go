@@ -18,10 +18,12 @@ package cmd
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -51,8 +53,18 @@ $ juicefs bench /mnt/jfs -p 4
 # Run benchmarks of only small files
 $ juicefs bench /mnt/jfs --big-file-size 0
 
+# Run benchmarks of the mixed workload, including the metadata benchmark (mdtest-like)
+$ juicefs bench /mnt/jfs -p 4 --profile mixed
+
+# Run only the metadata benchmark, creating/stating/unlinking 10000 files across 100 directories per thread
+$ juicefs bench /mnt/jfs -p 4 --big-file-size 0 --small-file-size 0 --meta-files 10000 --meta-dirs 100
+
 Details: https://juicefs.com/docs/community/performance_evaluation_guide#juicefs-bench`,
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "profile",
+				Usage: "name of the workload profile (small-random, large-sequential or mixed), which sets the defaults of the other options",
+			},
 			&cli.StringFlag{
 				Name:  "block-size",
 				Value: "1M",
@@ -73,6 +85,19 @@ Details: https://juicefs.com/docs/community/performance_evaluation_guide#juicefs
 				Value: 100,
 				Usage: "number of small files per thread",
 			},
+			&cli.BoolFlag{
+				Name:  "random-read",
+				Usage: "read the blocks of big file in random order",
+			},
+			&cli.UintFlag{
+				Name:  "meta-files",
+				Usage: "number of files per thread to create/stat/unlink in the metadata benchmark, 0 means disabled",
+			},
+			&cli.UintFlag{
+				Name:  "meta-dirs",
+				Value: 10,
+				Usage: "number of directories the files of the metadata benchmark are spread across",
+			},
 			&cli.UintFlag{
 				Name:    "threads",
 				Aliases: []string{"p"},
@@ -83,6 +108,30 @@ Details: https://juicefs.com/docs/community/performance_evaluation_guide#juicefs
 	}
 }
 
+// benchProfiles are the named workloads, so the results are comparable across deployments.
+var benchProfiles = map[string]map[string]string{
+	"small-random": {
+		"block-size":       "4K",
+		"big-file-size":    "256M",
+		"small-file-size":  "16K",
+		"small-file-count": "1000",
+		"random-read":      "true",
+	},
+	"large-sequential": {
+		"block-size":      "4M",
+		"big-file-size":   "4G",
+		"small-file-size": "0",
+	},
+	"mixed": {
+		"block-size":       "1M",
+		"big-file-size":    "1G",
+		"small-file-size":  "128K",
+		"small-file-count": "100",
+		"meta-files":       "1000",
+		"meta-dirs":        "10",
+	},
+}
+
 var resultRange = map[string][4]float64{
 	"bigwr":   {100, 200, 10, 50},
 	"bigrd":   {100, 200, 10, 50},
@@ -103,6 +152,7 @@ type benchCase struct {
 	name             string
 	fsize, bsize     int        // file/block size in Bytes
 	fcount, bcount   int        // file/block count
+	random           bool       // read the blocks in random order
 	wbar, rbar, sbar *utils.Bar // progress bar for write/read/stat
 }
 
@@ -140,8 +190,18 @@ func (bc *benchCase) readFiles(index int) {
 			logger.Fatalf("Failed to open file %s: %s", fname, err)
 		}
 		buf := make([]byte, bc.bsize)
+		var order []int
+		if bc.random {
+			order = rand.Perm(bc.bcount)
+		}
 		for j := 0; j < bc.bcount; j++ {
-			if n, err := fp.Read(buf); err != nil || n != bc.bsize {
+			var n int
+			if order != nil {
+				n, err = fp.ReadAt(buf, int64(order[j])*int64(bc.bsize))
+			} else {
+				n, err = fp.Read(buf)
+			}
+			if err != nil || n != bc.bsize {
 				logger.Fatalf("Failed to read file %s: %d %s", fname, n, err)
 			}
 			bc.rbar.Increment()
@@ -184,6 +244,85 @@ func (bc *benchCase) run(test string) float64 {
 	return time.Since(start).Seconds()
 }
 
+// metaBench creates/stats/unlinks the files spread across the directories, like mdtest.
+type metaBench struct {
+	bm     *benchmark
+	files  int // per thread
+	dirs   int
+	tmpdir string
+}
+
+func (mb *metaBench) fname(index, i int) string {
+	return filepath.Join(mb.tmpdir, fmt.Sprintf("dir.%d", i%mb.dirs), fmt.Sprintf("file.%d.%d", index, i))
+}
+
+func (mb *metaBench) prepare() {
+	for i := 0; i < mb.dirs; i++ {
+		dname := filepath.Join(mb.tmpdir, fmt.Sprintf("dir.%d", i))
+		if err := os.MkdirAll(dname, 0777); err != nil {
+			logger.Fatalf("Failed to create %s: %s", dname, err)
+		}
+	}
+}
+
+// run returns the time used and the sorted latencies of all the operations.
+func (mb *metaBench) run(test string, bar *utils.Bar) (float64, []time.Duration) {
+	var op func(string) error
+	switch test {
+	case "create":
+		op = func(fname string) error {
+			fp, err := os.OpenFile(fname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err == nil {
+				err = fp.Close()
+			}
+			return err
+		}
+	case "stat":
+		op = func(fname string) error {
+			_, err := os.Stat(fname)
+			return err
+		}
+	case "unlink":
+		op = os.Remove
+	} // default: fatal
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	lats := make([]time.Duration, 0, mb.files*mb.bm.threads)
+	start := time.Now()
+	for i := 0; i < mb.bm.threads; i++ {
+		index := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lat := make([]time.Duration, 0, mb.files)
+			for j := 0; j < mb.files; j++ {
+				fname := mb.fname(index, j)
+				st := time.Now()
+				if err := op(fname); err != nil {
+					logger.Fatalf("Failed to %s file %s: %s", test, fname, err)
+				}
+				lat = append(lat, time.Since(st))
+				bar.Increment()
+			}
+			mu.Lock()
+			lats = append(lats, lat...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	cost := time.Since(start).Seconds()
+	sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+	return cost, lats
+}
+
+// percentile returns the latency in ms at p (0~1) of the sorted ones.
+func percentile(lats []time.Duration, p float64) string {
+	if len(lats) == 0 {
+		return "0.00 ms"
+	}
+	return fmt.Sprintf("%.2f ms", float64(lats[int(float64(len(lats)-1)*p)].Microseconds())/1000)
+}
+
 func newBenchmark(tmpdir string, blockSize, bigSize, smallSize, smallCount, threads int) *benchmark {
 	bm := &benchmark{threads: threads, tmpdir: tmpdir}
 	if bigSize > 0 {
@@ -308,6 +447,19 @@ func printResult(result [][]string, leftAlign int, colorful bool) {
 func bench(ctx *cli.Context) error {
 	setup(ctx, 1)
 	/* --- Pre-check --- */
+	if name := ctx.String("profile"); name != "" {
+		profile, ok := benchProfiles[name]
+		if !ok {
+			logger.Fatalf("Invalid profile %s, expect small-random, large-sequential or mixed", name)
+		}
+		for flag, value := range profile {
+			if !ctx.IsSet(flag) {
+				if err := ctx.Set(flag, value); err != nil {
+					logger.Fatalf("Set %s to %s for profile %s: %s", flag, value, name, err)
+				}
+			}
+		}
+	}
 	blockSize := utils.ParseBytes(ctx, "block-size", 'M')
 	if blockSize == 0 || ctx.Uint("threads") == 0 {
 		return os.ErrInvalid
@@ -321,7 +473,17 @@ func bench(ctx *cli.Context) error {
 	tmpdir = filepath.Join(tmpdir, fmt.Sprintf("__juicefs_benchmark_%d__", time.Now().UnixNano()))
 	bm := newBenchmark(tmpdir, int(blockSize), int(bigSize), int(smallSize),
 		int(ctx.Uint("small-file-count")), int(ctx.Uint("threads")))
-	if bm.big == nil && bm.small == nil {
+	if bm.big != nil {
+		bm.big.random = ctx.Bool("random-read")
+	}
+	var mb *metaBench
+	if n := ctx.Uint("meta-files"); n > 0 {
+		if ctx.Uint("meta-dirs") == 0 {
+			return os.ErrInvalid
+		}
+		mb = &metaBench{bm: bm, files: int(n), dirs: int(ctx.Uint("meta-dirs")), tmpdir: filepath.Join(tmpdir, "mdtest")}
+	}
+	if bm.big == nil && bm.small == nil && mb == nil {
 		return os.ErrInvalid
 	}
 	var purgeArgs []string
@@ -386,6 +548,9 @@ func bench(ctx *cli.Context) error {
 		b.rbar.Done()
 		line = make([]string, 3)
 		line[0] = "Read big file"
+		if b.random {
+			line[0] = "Random read big file"
+		}
 		line[1], line[2] = bm.colorize("bigrd", float64(b.fsize)/1024/1024*float64(b.fcount*bm.threads)/cost, cost/float64(b.fcount), 2)
 		line[1] += " MiB/s"
 		line[2] += " s/file"
@@ -425,6 +590,22 @@ func bench(ctx *cli.Context) error {
 		line[2] += " ms/file"
 		result = append(result, line)
 	}
+	var metaResult [][]string
+	if mb != nil {
+		metaResult = append(metaResult, []string{"METADATA", "VALUE", "P50", "P90", "P99"})
+		mb.prepare()
+		total := int64(bm.threads * mb.files)
+		for _, test := range []struct{ name, title string }{{"create", "Create"}, {"stat", "Stat"}, {"unlink", "Unlink"}} {
+			if test.name == "stat" {
+				dropCaches()
+			}
+			bar := progress.AddCountBar(test.title+" files", total)
+			cost, lats := mb.run(test.name, bar)
+			bar.Done()
+			metaResult = append(metaResult, []string{test.title, fmt.Sprintf("%.1f ops/s", float64(total)/cost),
+				percentile(lats, 0.5), percentile(lats, 0.9), percentile(lats, 0.99)})
+		}
+	}
 	progress.Done()
 
 	/* --- Clean-up --- */
@@ -443,6 +624,12 @@ func bench(ctx *cli.Context) error {
 	fmt.Printf("BlockSize: %s, BigFileSize: %s, SmallFileSize: %s, SmallFileCount: %d, NumThreads: %d\n",
 		humanize.IBytes(blockSize), humanize.IBytes(bigSize), humanize.IBytes(smallSize),
 		ctx.Uint("small-file-count"), ctx.Uint("threads"))
+	if mb != nil {
+		fmt.Printf("MetaFiles: %d, MetaDirs: %d\n", mb.files, mb.dirs)
+	}
+	if name := ctx.String("profile"); name != "" {
+		fmt.Printf("Profile: %s\n", name)
+	}
 	if stats != nil {
 		stats2 := readStats(mp)
 		diff := func(item string) float64 {
@@ -478,6 +665,11 @@ func bench(ctx *cli.Context) error {
 		}
 		fmt.Printf(fmtString, diff("uptime"), diff("cpu_usage")*100/diff("uptime"), stats2["juicefs_memory"]/1024/1024)
 	}
-	printResult(result, -1, bm.colorful)
+	if len(result) > 1 {
+		printResult(result, -1, bm.colorful)
+	}
+	if metaResult != nil {
+		printResult(metaResult, -1, false)
+	}
 	return nil
 }
@@ -30,6 +30,9 @@ func TestBench(t *testing.T) {
 	if err := Main([]string{"", "bench", testMountPoint}); err != nil {
 		t.Fatalf("test bench failed: %s", err)
 	}
+	if err := Main([]string{"", "bench", testMountPoint, "--profile", "mixed", "--big-file-size", "16M", "--meta-files", "100"}); err != nil {
+		t.Fatalf("test bench with profile failed: %s", err)
+	}
 }
 
 func TestBenchForObject(t *testing.T) {
@@ -38,6 +38,12 @@ func cmdClone() *cli.Command {
 		Category:  "TOOL",
 		Description: `
 This command can clone a file or directory without copying the underlying data,similar to the cp command but very fast.
+
+With --src-meta and --dst-meta, it clones a file or directory from a volume into another volume in the same bucket,
+the SRC and DST are paths in the volumes (not the mount points). The metadata is created in the destination volume,
+and the blocks are copied by the object storage (server side if supported) without passing through the client,
+so unlike the clone within a volume, the data takes the storage space twice.
+
 Examples:
 # Clone a file
 $ juicefs clone /mnt/jfs/file1 /mnt/jfs/file2
@@ -46,18 +52,37 @@ $ juicefs clone /mnt/jfs/file1 /mnt/jfs/file2
 $ juicefs clone /mnt/jfs/dir1 /mnt/jfs/dir2
 
 # Clone with preserving the uid, gid, and mode of the file
-$ juicefs clone -p /mnt/jfs/file1 /mnt/jfs/file2`,
+$ juicefs clone -p /mnt/jfs/file1 /mnt/jfs/file2
+
+# Clone a directory from a volume into another one in the same bucket, the paths are in the volumes
+$ juicefs clone --src-meta redis://localhost/1 --dst-meta redis://localhost/2 /data/2024 /data/2024`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:    "preserve",
 				Aliases: []string{"p"},
 				Usage:   "preserve the uid, gid, and mode of the file. (This is forced on Windows)",
 			},
+			&cli.StringFlag{
+				Name:  "src-meta",
+				Usage: "META-URL of the source volume to clone from another volume in the same bucket (requires --dst-meta)",
+			},
+			&cli.StringFlag{
+				Name:  "dst-meta",
+				Usage: "META-URL of the destination volume to clone into (requires --src-meta)",
+			},
+			&cli.IntFlag{
+				Name:  "threads",
+				Value: 10,
+				Usage: "number of concurrent threads to copy the blocks across volumes",
+			},
 		},
 	}
 }
 
 func clone(ctx *cli.Context) error {
+	if ctx.IsSet("src-meta") || ctx.IsSet("dst-meta") {
+		return cloneAcrossVolumes(ctx)
+	}
 	setup(ctx, 2)
 	srcPath := ctx.Args().Get(0)
 	srcAbsPath, err := filepath.Abs(srcPath)
@@ -0,0 +1,167 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+func openTestVolume(t *testing.T, metaUrl string) *fs.FileSystem {
+	m := meta.NewClient(metaUrl, nil)
+	format, err := m.Load(true)
+	if err != nil {
+		t.Fatalf("load %s: %s", metaUrl, err)
+	}
+	blob, err := createStorage(*format)
+	if err != nil {
+		t.Fatalf("create storage: %s", err)
+	}
+	conf := &vfs.Config{
+		Meta:   meta.DefaultConf(),
+		Format: *format,
+		Chunk: &chunk.Config{
+			BlockSize:  format.BlockSize << 10,
+			Compress:   format.Compression,
+			HashPrefix: format.HashPrefix,
+			MaxUpload:  2,
+			BufferSize: 100 << 20,
+		},
+		AttrTimeout:  time.Millisecond,
+		EntryTimeout: time.Millisecond,
+	}
+	store := chunk.NewCachedStore(blob, *conf.Chunk, nil)
+	jfs, err := fs.NewFileSystem(conf, m, store, nil)
+	if err != nil {
+		t.Fatalf("new file system: %s", err)
+	}
+	return jfs
+}
+
+func TestCloneAcrossVolumes(t *testing.T) {
+	dir := t.TempDir()
+	bucket := dir + "/bucket/"
+	srcMeta, dstMeta := "sqlite3://"+dir+"/src.db", "sqlite3://"+dir+"/dst.db"
+	for _, args := range [][]string{
+		{"", "format", "--bucket", bucket, "--block-size", "1M", srcMeta, "staging"},
+		{"", "format", "--bucket", bucket, "--block-size", "1M", "--hash-prefix", dstMeta, "production"},
+	} {
+		if err := Main(args); err != nil {
+			t.Fatalf("format: %s", err)
+		}
+	}
+
+	ctx := meta.NewContext(1, 0, []uint32{0})
+	src := openTestVolume(t, srcMeta)
+	if st := src.Mkdir(ctx, "/data", 0755, 0); st != 0 {
+		t.Fatalf("mkdir: %s", st)
+	}
+	data := bytes.Repeat([]byte("juicefs"), 500000) // 3.3 MiB in 4 blocks
+	f, st := src.Create(ctx, "/data/file", 0640, 0)
+	if st != 0 {
+		t.Fatalf("create: %s", st)
+	}
+	if _, st = f.Write(ctx, data); st != 0 {
+		t.Fatalf("write: %s", st)
+	}
+	if st = f.Flush(ctx); st != 0 {
+		t.Fatalf("flush: %s", st)
+	}
+	// the first slice is visible in two ranges of the chunk after overwriting the middle of it
+	copy(data[1<<20:], "overwritten")
+	if _, st = f.Pwrite(ctx, []byte("overwritten"), 1<<20); st != 0 {
+		t.Fatalf("pwrite: %s", st)
+	}
+	if st = f.Close(ctx); st != 0 {
+		t.Fatalf("close: %s", st)
+	}
+	// the slices are shared by the cloned file in the source volume
+	if st = src.Clone(ctx, "/data/file", "/data/clone", true); st != 0 {
+		t.Fatalf("clone in the source volume: %s", st)
+	}
+	if st = src.SetXattr(ctx, "/data/file", "user.k", []byte("v"), 0); st != 0 {
+		t.Fatalf("setxattr: %s", st)
+	}
+	if st = src.Symlink(ctx, "file", "/data/link"); st != 0 {
+		t.Fatalf("symlink: %s", st)
+	}
+
+	if err := Main([]string{"", "clone", "--src-meta", dstMeta, "--dst-meta", dstMeta, "/data", "/data"}); err == nil {
+		t.Fatalf("clone within the same volume should fail")
+	}
+	if err := Main([]string{"", "clone", "-p", "--src-meta", srcMeta, "--dst-meta", dstMeta, "/data", "/"}); err != nil {
+		t.Fatalf("clone: %s", err)
+	}
+	if err := Main([]string{"", "clone", "--src-meta", srcMeta, "--dst-meta", dstMeta, "/data", "/data"}); err == nil {
+		t.Fatalf("clone into an existing path should fail")
+	}
+
+	dst := openTestVolume(t, dstMeta)
+	fi, st := dst.Stat(ctx, "/data/file")
+	if st != 0 || fi.Size() != int64(len(data)) || fi.Mode().Perm() != 0640 {
+		t.Fatalf("stat cloned file: %+v %s", fi, st)
+	}
+	f, st = dst.Open(ctx, "/data/file", meta.MODE_MASK_R)
+	if st != 0 {
+		t.Fatalf("open cloned file: %s", st)
+	}
+	buf := make([]byte, len(data)+10)
+	if n, err := f.Pread(ctx, buf, 0); n != len(data) || !bytes.Equal(buf[:n], data) {
+		t.Fatalf("read cloned file: %d %s", n, err)
+	}
+	_ = f.Close(ctx)
+	f, st = dst.Open(ctx, "/data/clone", meta.MODE_MASK_R)
+	if st != 0 {
+		t.Fatalf("open cloned file: %s", st)
+	}
+	if n, err := f.Pread(ctx, buf, 0); n != len(data) || !bytes.Equal(buf[:n], data) {
+		t.Fatalf("read the clone of the cloned file: %d %s", n, err)
+	}
+	_ = f.Close(ctx)
+	if v, st := dst.GetXattr(ctx, "/data/file", "user.k"); st != 0 || string(v) != "v" {
+		t.Fatalf("cloned xattr: %q %s", v, st)
+	}
+	if target, st := dst.Readlink(ctx, "/data/link"); st != 0 || string(target) != "file" {
+		t.Fatalf("cloned symlink: %q %s", target, st)
+	}
+
+	// the blocks are copied into the destination volume, so the volumes can be cleaned up independently
+	if n := getFileCount(bucket + "production/chunks"); n != 5 {
+		t.Fatalf("expect 5 blocks in the destination volume, but got %d", n)
+	}
+	// the copied slices should be referenced by all the ranges of both files, so they are not deleted
+	// while in use, and the slices shared in the source volume are copied only once
+	db, err := sql.Open("sqlite3", dir+"/dst.db")
+	if err != nil {
+		t.Fatalf("open %s: %s", dstMeta, err)
+	}
+	defer db.Close()
+	var slices, refs int
+	if err = db.QueryRow("SELECT COUNT(*), SUM(refs) FROM jfs_chunk_ref").Scan(&slices, &refs); err != nil {
+		t.Fatalf("query refs of slices: %s", err)
+	}
+	if slices != 2 || refs != 6 {
+		t.Fatalf("expect 2 slices with 6 refs in the destination volume, but got %d slices with %d refs", slices, refs)
+	}
+}
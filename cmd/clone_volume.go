@@ -0,0 +1,429 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// checkCloneFormats checks that the blocks of the source volume can be used by the destination volume as they are.
+func checkCloneFormats(src, dst *meta.Format) error {
+	if src.UUID == dst.UUID || src.Name == dst.Name {
+		return fmt.Errorf("the source and destination should be different volumes, use clone in the mount point instead")
+	}
+	if !strings.EqualFold(src.Storage, dst.Storage) || src.Bucket != dst.Bucket || src.Shards != dst.Shards {
+		return fmt.Errorf("the volumes should be in the same bucket: %s %s and %s %s", src.Storage, src.Bucket, dst.Storage, dst.Bucket)
+	}
+	if src.BlockSize != dst.BlockSize {
+		return fmt.Errorf("block size of the volumes are different: %d KiB and %d KiB", src.BlockSize, dst.BlockSize)
+	}
+	compression := func(f *meta.Format) string {
+		if c := strings.ToLower(f.Compression); c != "" {
+			return c
+		}
+		return "none"
+	}
+	if compression(src) != compression(dst) || !bytes.Equal(src.CompressDict, dst.CompressDict) {
+		return fmt.Errorf("compression of the volumes are different: %s and %s", compression(src), compression(dst))
+	}
+	if src.BlockChecksum != dst.BlockChecksum {
+		return fmt.Errorf("block checksum should be enabled or disabled in both volumes")
+	}
+	if err := src.Decrypt(); err != nil {
+		return fmt.Errorf("format decrypt: %s", err)
+	}
+	if err := dst.Decrypt(); err != nil {
+		return fmt.Errorf("format decrypt: %s", err)
+	}
+	sameKeys := len(src.EncryptDataKeys) == len(dst.EncryptDataKeys)
+	for i := 0; sameKeys && i < len(src.EncryptDataKeys); i++ {
+		sameKeys = bytes.Equal(src.EncryptDataKeys[i], dst.EncryptDataKeys[i])
+	}
+	if src.EncryptKey != dst.EncryptKey || src.EncryptAlgo != dst.EncryptAlgo || src.EncryptKMS != dst.EncryptKMS || !sameKeys {
+		return fmt.Errorf("the volumes should be encrypted with the same key, or both not encrypted")
+	}
+	return nil
+}
+
+func openCloneMeta(uri string) (meta.Meta, *meta.Format) {
+	removePassword(uri)
+	m := meta.NewClient(uri, nil)
+	format, err := m.Load(true)
+	if err != nil {
+		logger.Fatalf("load setting from %s: %s", utils.RemovePassword(uri), err)
+	}
+	return m, format
+}
+
+// volumeCloner copies a tree from one volume into another one in the same bucket, the metadata is
+// created in the destination volume, and the blocks are copied by the object storage (server side if
+// supported) into the destination volume with new slice IDs, so the volumes can be cleaned up (gc)
+// independently. The blocks can't be shared by the volumes, as the gc and compaction of a volume don't know
+// the references from the other one. A slice shared by several files in the source volume (cloned ones) is
+// copied once and shared by the files in the destination volume too.
+type volumeCloner struct {
+	src, dst       meta.Meta
+	srcFmt, dstFmt *meta.Format
+	blob           object.ObjectStorage
+	preserve       bool
+	uid, gid       uint32
+	umask          uint16
+	slices         map[uint64][]clonedRange // slice ID in the source volume -> where its copy is visible
+	copied         int                      // number of the slices copied
+	links          map[meta.Ino]meta.Ino
+	sem            chan struct{}
+	entries        *utils.Bar
+	blocks         *utils.Bar
+}
+
+// clonedRange is a range of a slice visible in a file of the destination volume.
+type clonedRange struct {
+	inode      meta.Ino
+	off        uint64 // offset in the file
+	sOff, sLen uint32 // range of the slice
+}
+
+// reuseRange references the copy of the source slice in the extent e at off of the file (by copying the
+// range from a file with it, which adds the references of the slices), it returns false if no file has
+// the range of the copy.
+func (v *volumeCloner) reuseRange(inode meta.Ino, off uint64, e meta.Extent) (bool, error) {
+	for _, r := range v.slices[e.Id] {
+		if r.sOff <= e.Off && e.Off+e.Len <= r.sOff+r.sLen {
+			var copied uint64
+			if st := v.dst.CopyFileRange(meta.Background(), r.inode, r.off+uint64(e.Off-r.sOff), inode, off, uint64(e.Len), 0, &copied, nil); st != 0 {
+				return false, fmt.Errorf("copy range of slice %d: %s", e.Id, st)
+			}
+			if copied != uint64(e.Len) {
+				return false, fmt.Errorf("copy range of slice %d: %d < %d", e.Id, copied, e.Len)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (v *volumeCloner) copyBlock(srcKey, dstKey string) error {
+	ctx := context.Background()
+	srcKey, dstKey = v.srcFmt.Name+"/"+srcKey, v.dstFmt.Name+"/"+dstKey
+	err := v.blob.Copy(ctx, dstKey, srcKey)
+	if err == nil {
+		return nil
+	}
+	logger.Debugf("copy %s to %s: %s, fallback to get and put", srcKey, dstKey, err)
+	in, err := v.blob.Get(ctx, srcKey, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return v.blob.Put(ctx, dstKey, in)
+}
+
+// copySlice copies the blocks of a slice into a new slice of the destination volume, and returns its ID.
+func (v *volumeCloner) copySlice(s meta.Slice) (uint64, error) {
+	var id uint64
+	if st := v.dst.NewSlice(meta.Background(), &id); st != 0 {
+		return 0, fmt.Errorf("new slice: %s", st)
+	}
+	bsize := uint32(v.srcFmt.BlockSize) << 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed error
+	for indx := 0; uint32(indx)*bsize < s.Size; indx++ {
+		size := int(min(bsize, s.Size-uint32(indx)*bsize))
+		srcKey := chunk.BlockKey(v.srcFmt.HashPrefix, s.Id, indx, size)
+		dstKey := chunk.BlockKey(v.dstFmt.HashPrefix, id, indx, size)
+		v.sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-v.sem
+				wg.Done()
+			}()
+			if err := v.copyBlock(srcKey, dstKey); err != nil {
+				mu.Lock()
+				failed = fmt.Errorf("copy block %s: %s", srcKey, err)
+				mu.Unlock()
+				return
+			}
+			v.blocks.Increment()
+		}()
+	}
+	wg.Wait()
+	if failed != nil {
+		return 0, failed
+	}
+	if v.dstFmt.BlockChecksum {
+		sums, err := v.src.LoadChecksums(s.Id)
+		if err == nil && sums != nil {
+			err = v.dst.SaveChecksums(id, sums)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("copy checksums of slice %d: %s", s.Id, err)
+		}
+	}
+	v.copied++
+	return id, nil
+}
+
+func (v *volumeCloner) cloneData(src, dst meta.Ino, attr *meta.Attr) error {
+	ctx := meta.Background()
+	for indx := uint32(0); uint64(indx)*meta.ChunkSize < attr.Length; indx++ {
+		var ss []meta.Slice
+		if st := v.src.Read(ctx, src, indx, &ss); st != 0 {
+			return fmt.Errorf("read chunk %d: %s", indx, st)
+		}
+		// an overwritten slice may be visible in several ranges of the chunk, they are written together
+		// with the copy of the slice, so it's referenced by all of them. The slices copied for the other
+		// files are referenced again instead of copied.
+		var ids []uint64
+		extents := make(map[uint64][]meta.Extent)
+		var pos uint32
+		for _, s := range ss {
+			if s.Id > 0 {
+				if _, ok := extents[s.Id]; !ok {
+					ids = append(ids, s.Id)
+				}
+				extents[s.Id] = append(extents[s.Id], meta.Extent{Pos: pos, Slice: s})
+			}
+			pos += s.Len
+		}
+		for _, sid := range ids {
+			var es []meta.Extent
+			for _, e := range extents[sid] {
+				if ok, err := v.reuseRange(dst, uint64(indx)*meta.ChunkSize+uint64(e.Pos), e); err != nil {
+					return err
+				} else if !ok {
+					es = append(es, e)
+				}
+			}
+			if len(es) == 0 {
+				continue
+			}
+			id, err := v.copySlice(es[0].Slice)
+			if err != nil {
+				return err
+			}
+			for i := range es {
+				v.slices[sid] = append(v.slices[sid], clonedRange{dst, uint64(indx)*meta.ChunkSize + uint64(es[i].Pos), es[i].Off, es[i].Len})
+				es[i].Id = id
+			}
+			if st := v.dst.WriteExtents(ctx, dst, indx, es, time.Now()); st != 0 {
+				return fmt.Errorf("write chunk %d: %s", indx, st)
+			}
+		}
+	}
+	var a meta.Attr
+	if st := v.dst.Truncate(ctx, dst, 0, attr.Length, &a, true); st != 0 {
+		return fmt.Errorf("truncate: %s", st)
+	}
+	return nil
+}
+
+func (v *volumeCloner) cloneXattrs(src, dst meta.Ino) error {
+	ctx := meta.Background()
+	var names []byte
+	if st := v.src.ListXattr(ctx, src, &names); st != 0 {
+		return fmt.Errorf("list xattr: %s", st)
+	}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		var value []byte
+		if st := v.src.GetXattr(ctx, src, string(name), &value); st != 0 {
+			return fmt.Errorf("get xattr %s: %s", name, st)
+		}
+		if st := v.dst.SetXattr(ctx, dst, string(name), value, 0); st != 0 {
+			return fmt.Errorf("set xattr %s: %s", name, st)
+		}
+	}
+	return nil
+}
+
+// cloneEntry clones the entry (and the children of a directory) into the parent of the destination volume.
+func (v *volumeCloner) cloneEntry(src meta.Ino, attr *meta.Attr, parent meta.Ino, name string, p string) error {
+	ctx := meta.Background()
+	if attr.Typ == meta.TypeFile && attr.Nlink > 1 {
+		if ino, ok := v.links[src]; ok {
+			var a meta.Attr
+			if st := v.dst.Link(ctx, ino, parent, name, &a); st != 0 {
+				return fmt.Errorf("link %s: %s", p, st)
+			}
+			v.entries.Increment()
+			return nil
+		}
+	}
+	mode, umask := attr.Mode, v.umask
+	if v.preserve {
+		umask = 0
+	}
+	var ino meta.Ino
+	var a meta.Attr
+	var st syscall.Errno
+	switch attr.Typ {
+	case meta.TypeDirectory:
+		st = v.dst.Mkdir(ctx, parent, name, mode, umask, 0, &ino, &a)
+	case meta.TypeSymlink:
+		var target []byte
+		if st = v.src.ReadLink(ctx, src, &target); st == 0 {
+			st = v.dst.Symlink(ctx, parent, name, string(target), &ino, &a)
+		}
+	default:
+		st = v.dst.Mknod(ctx, parent, name, attr.Typ, mode, umask, attr.Rdev, "", &ino, &a)
+	}
+	if st != 0 {
+		return fmt.Errorf("create %s: %s", p, st)
+	}
+	if err := v.cloneXattrs(src, ino); err != nil {
+		return fmt.Errorf("clone %s: %s", p, err)
+	}
+	if attr.Typ == meta.TypeFile {
+		if err := v.cloneData(src, ino, attr); err != nil {
+			return fmt.Errorf("clone %s: %s", p, err)
+		}
+		if attr.Nlink > 1 {
+			v.links[src] = ino
+		}
+	}
+	v.entries.Increment()
+	if attr.Typ == meta.TypeDirectory {
+		var entries []*meta.Entry
+		if st := v.src.Readdir(ctx, src, 1, &entries); st != 0 {
+			return fmt.Errorf("readdir %s: %s", p, st)
+		}
+		for _, e := range entries {
+			n := string(e.Name)
+			if n == "." || n == ".." || e.Inode.IsTrash() {
+				continue
+			}
+			if err := v.cloneEntry(e.Inode, e.Attr, ino, n, path.Join(p, n)); err != nil {
+				return err
+			}
+		}
+	}
+	if attr.Typ == meta.TypeSymlink {
+		return nil // symlinks can't be changed without following them
+	}
+	// the attributes are set at last, so the mtime is not changed by the children or the data
+	set := uint16(meta.SetAttrUID | meta.SetAttrGID)
+	a = meta.Attr{Uid: v.uid, Gid: v.gid}
+	if v.preserve {
+		set |= meta.SetAttrMode | meta.SetAttrAtime | meta.SetAttrMtime
+		a = *attr
+	}
+	if st := v.dst.SetAttr(ctx, ino, set, 0, &a); st != 0 {
+		return fmt.Errorf("set attributes of %s: %s", p, st)
+	}
+	return nil
+}
+
+func cloneAcrossVolumes(ctx *cli.Context) error {
+	setup(ctx, 2)
+	if !ctx.IsSet("src-meta") || !ctx.IsSet("dst-meta") {
+		return fmt.Errorf("both --src-meta and --dst-meta should be specified to clone across volumes")
+	}
+	srcM, srcFmt := openCloneMeta(ctx.String("src-meta"))
+	dstM, dstFmt := openCloneMeta(ctx.String("dst-meta"))
+	if err := checkCloneFormats(srcFmt, dstFmt); err != nil {
+		return err
+	}
+	blob, err := createBucket(*srcFmt)
+	if err != nil {
+		return fmt.Errorf("create storage: %s", err)
+	}
+
+	srcPath := path.Clean("/" + ctx.Args().Get(0))
+	srcIno, srcAttr, err := lookupVolumePath(srcM, srcPath)
+	if err != nil {
+		return fmt.Errorf("%s in the source volume: %s", srcPath, err)
+	}
+	if srcIno.IsTrash() || srcPath == "/"+meta.TrashName {
+		return fmt.Errorf("can't clone the trash")
+	}
+	dstPath := ctx.Args().Get(1)
+	if strings.HasSuffix(dstPath, "/") {
+		dstPath = path.Join(dstPath, path.Base(srcPath))
+	}
+	dstPath = path.Clean("/" + dstPath)
+	if dstPath == "/" {
+		return fmt.Errorf("the clone DST path should not be the root of the volume")
+	}
+	dstParent, dstParentAttr, err := lookupVolumePath(dstM, path.Dir(dstPath))
+	if err != nil {
+		return fmt.Errorf("%s in the destination volume: %s", path.Dir(dstPath), err)
+	}
+	if dstParentAttr.Typ != meta.TypeDirectory {
+		return fmt.Errorf("%s in the destination volume is not a directory", path.Dir(dstPath))
+	}
+	dstName := path.Base(dstPath)
+	var ino meta.Ino
+	var attr meta.Attr
+	if st := dstM.Lookup(meta.Background(), dstParent, dstName, &ino, &attr, false); st == 0 {
+		return fmt.Errorf("%s already exists in the destination volume", dstPath)
+	}
+
+	var sum meta.Summary
+	if st := srcM.GetSummary(meta.Background(), srcIno, &sum, true, false); st != 0 {
+		return fmt.Errorf("summary of %s: %s", srcPath, st)
+	}
+	if err = dstM.NewSession(false); err != nil {
+		logger.Warnf("running without sessions because fail to new session: %s", err)
+	} else {
+		defer func() {
+			_ = dstM.CloseSession()
+		}()
+	}
+	progress := utils.NewProgress(false)
+	v := &volumeCloner{
+		src:      srcM,
+		dst:      dstM,
+		srcFmt:   srcFmt,
+		dstFmt:   dstFmt,
+		blob:     blob,
+		preserve: ctx.Bool("preserve") || runtime.GOOS == "windows",
+		uid:      uint32(os.Getuid()),
+		gid:      uint32(os.Getgid()),
+		umask:    uint16(utils.GetUmask()),
+		slices:   make(map[uint64][]clonedRange),
+		links:    make(map[meta.Ino]meta.Ino),
+		sem:      make(chan struct{}, max(ctx.Int("threads"), 1)),
+		entries:  progress.AddCountBar("Cloned entries", int64(sum.Dirs+sum.Files)),
+		blocks:   progress.AddCountSpinner("Copied blocks"),
+	}
+	err = v.cloneEntry(srcIno, srcAttr, dstParent, dstName, dstPath)
+	progress.Done()
+	if err != nil {
+		return fmt.Errorf("clone failed: %s, %s in the destination volume should be removed", err, dstPath)
+	}
+	logger.Infof("Cloned %d entries with %d slices (%d blocks) from %s into %s", v.entries.Current(), v.copied, v.blocks.Current(), srcPath, dstPath)
+	return nil
+}
@@ -17,11 +17,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"path/filepath"
+	"strconv"
 	"syscall"
 
+	"github.com/dustin/go-humanize"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/urfave/cli/v2"
@@ -38,6 +41,9 @@ func cmdCompact() *cli.Command {
  Examples:
  # compact with path
  $ juicefs compact /mnt/jfs/foo
+
+ # show the fragmentation of the chunks without compacting them
+ $ juicefs compact --dry-run /mnt/jfs/foo /mnt/jfs/bar
  `,
 		Flags: []cli.Flag{
 			&cli.UintFlag{
@@ -46,6 +52,10 @@ func cmdCompact() *cli.Command {
 				Value:   10,
 				Usage:   "compact concurrency",
 			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the chunks and slices to be compacted under each path",
+			},
 		},
 	}
 }
@@ -62,6 +72,8 @@ func compact(ctx *cli.Context) error {
 		coCnt = math.MaxUint16
 	}
 
+	dryRun := ctx.Bool("dry-run")
+	result := [][]string{{"Path", "Files", "Chunks", "Slices", "Fragmented Chunks", "Slices to Compact", "Data to Rewrite"}}
 	paths := ctx.Args().Slice()
 	for i := 0; i < len(paths); i++ {
 		path, err := filepath.Abs(paths[i])
@@ -80,13 +92,63 @@ func compact(ctx *cli.Context) error {
 			logger.Fatalf("inode numbe %d not valid", inode)
 		}
 
-		if err = doCompact(inode, path, uint16(coCnt)); err != nil {
+		if dryRun {
+			frag, err := checkFragments(inode, path, uint16(coCnt))
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+			result = append(result, []string{path, strconv.FormatUint(frag.Files, 10), strconv.FormatUint(frag.Chunks, 10),
+				strconv.FormatUint(frag.Slices, 10), strconv.FormatUint(frag.FragmentedChunks, 10),
+				strconv.FormatUint(frag.FragmentedSlices, 10), humanize.IBytes(frag.CompactBytes)})
+		} else if err = doCompact(inode, path, uint16(coCnt)); err != nil {
 			logger.Error(err)
 		}
 	}
+	if dryRun && len(result) > 1 {
+		printResult(result, 1, false)
+	}
 	return nil
 }
 
+func checkFragments(inode meta.Ino, path string, coCnt uint16) (*meta.Fragments, error) {
+	f, err := openController(path)
+	if err != nil {
+		return nil, fmt.Errorf("open control file for [%d:%s]: %w", inode, path, err)
+	}
+	defer f.Close()
+
+	headerLen, bodyLen := uint32(8), uint32(8+2)
+	wb := utils.NewBuffer(headerLen + bodyLen)
+	wb.Put32(meta.CompactDryRun)
+	wb.Put32(bodyLen)
+	wb.Put64(uint64(inode))
+	wb.Put16(coCnt)
+	if _, err = f.Write(wb.Bytes()); err != nil {
+		logger.Fatalf("write message: %s", err)
+	}
+
+	progress := utils.NewProgress(false)
+	spin := progress.AddCountSpinner("Scanned chunks")
+	data, errno := readProgress(f, func(chunks, _ uint64) {
+		spin.SetCurrent(int64(chunks))
+	})
+	spin.Done()
+	progress.Done()
+
+	if errno == syscall.EINVAL {
+		logger.Fatalf("dry run of compact is not supported, please upgrade and mount again")
+	}
+	if errno != 0 {
+		return nil, fmt.Errorf("check fragments of [%d:%s] error: %s", inode, path, errno)
+	}
+	var frag meta.Fragments
+	if err = json.Unmarshal(data, &frag); err != nil {
+		return nil, fmt.Errorf("decode fragments of [%d:%s]: %s", inode, path, err)
+	}
+	return &frag, nil
+}
+
 func doCompact(inode meta.Ino, path string, coCnt uint16) error {
 	f, err := openController(path)
 	if err != nil {
@@ -25,6 +25,7 @@ import (
 
 	"github.com/dustin/go-humanize"
 	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/version"
 	"github.com/pkg/errors"
@@ -51,6 +52,12 @@ $ juicefs config redis://localhost --inodes 10000000 --capacity 1048576
 # Change maximum days before files in trash are deleted
 $ juicefs config redis://localhost --trash-days 7
 
+# Limit the removed files of each user in trash to 100 GiB
+$ juicefs config redis://localhost --trash-user-quota 100
+
+# Keep metadata changelog of the last 3 days for "juicefs watch"
+$ juicefs config redis://localhost --changelog-days 3
+
 # Limit client version that is allowed to connect
 $ juicefs config redis://localhost --min-client-version 1.0.0 --max-client-version 1.1.0`,
 		Flags: expandFlags(
@@ -85,6 +92,10 @@ func configManagementFlags() []cli.Flag {
 			Name:  "max-client-version",
 			Usage: "maximum client version allowed to connect",
 		},
+		&cli.BoolFlag{
+			Name:  "rotate-data-key",
+			Usage: "generate a new data key wrapped by the KMS for new objects, existing objects are still readable with the old keys",
+		},
 		&cli.BoolFlag{
 			Name:  "dir-stats",
 			Usage: "enable dir stats, which is necessary for fast summary and dir quota",
@@ -231,6 +242,38 @@ func config(ctx *cli.Context) error {
 				format.TrashDays = new
 				trash = true
 			}
+		case "trash-user-quota":
+			if new := utils.ParseBytes(ctx, flag, 'G'); new != format.TrashUserQuota {
+				msg.WriteString(fmt.Sprintf("%10s: %s -> %s\n", flag,
+					humanize.IBytes(format.TrashUserQuota), humanize.IBytes(new)))
+				format.TrashUserQuota = new
+			}
+		case "changelog-days":
+			if new := ctx.Int(flag); new != format.ChangelogDays {
+				if new < 0 {
+					return fmt.Errorf("Invalid changelog days: %d", new)
+				}
+				msg.WriteString(fmt.Sprintf("%10s: %d -> %d\n", flag, format.ChangelogDays, new))
+				format.ChangelogDays = new
+			}
+		case "rotate-data-key":
+			if !ctx.Bool(flag) {
+				break
+			}
+			if format.EncryptKMS == "" {
+				return fmt.Errorf("data key can only be rotated when it's wrapped by a KMS (--encrypt-kms)")
+			}
+			kms, err := object.NewKMS(format.EncryptKMS)
+			if err != nil {
+				return err
+			}
+			key, err := object.NewWrappedDataKey(kms)
+			if err != nil {
+				return fmt.Errorf("generate data key with %s: %s", kms, err)
+			}
+			msg.WriteString(fmt.Sprintf("%s: %d -> %d data keys\n", flag, len(format.EncryptDataKeys), len(format.EncryptDataKeys)+1))
+			format.EncryptDataKeys = append(format.EncryptDataKeys, key)
+			storage = true
 		case "dir-stats":
 			if new := ctx.Bool(flag); new != format.DirStats {
 				msg.WriteString(fmt.Sprintf("%10s: %t -> %t\n", flag, format.DirStats, new))
@@ -271,6 +314,18 @@ func config(ctx *cli.Context) error {
 					return errors.New("cannot disable acl")
 				}
 			}
+		case "block-checksum":
+			if blockChecksum := ctx.Bool(flag); blockChecksum != format.BlockChecksum {
+				if blockChecksum {
+					msg.WriteString(fmt.Sprintf("%s: %v -> %v\n", flag, format.BlockChecksum, true))
+					msg.WriteString(fmt.Sprintf("%s: %s -> %s\n", "min-client-version", format.MinClientVersion, "1.4.0-A"))
+					format.BlockChecksum = true
+					format.MinClientVersion = "1.4.0-A"
+					clientVer = true
+				} else {
+					return errors.New("cannot disable block checksum")
+				}
+			}
 		case "ranger-rest-url":
 			if newUrl := ctx.String(flag); newUrl != format.RangerRestUrl {
 				msg.WriteString(fmt.Sprintf("%s: %s -> %s\n", flag, format.RangerRestUrl, newUrl))
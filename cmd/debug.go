@@ -35,6 +35,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/vfs"
@@ -52,6 +53,9 @@ func cmdDebug() *cli.Command {
 		Usage:     "Collect and display system static and runtime information",
 		Description: `
 It collects and displays information from multiple dimensions such as the running environment and system logs, etc.
+All of them are packed into one zip file for troubleshooting: the system information, the config and metrics of the mount
+point (including the statistics of the meta engine and the pending FUSE requests), the recent logs and access logs, the
+usage of cache directories, the recent errors of object storage, and the profiles of the mount process from the debug agent.
 
 Examples:
 $ juicefs debug /mnt/jfs
@@ -78,6 +82,11 @@ $ juicefs debug --out-dir=/var/log --limit=1000 /mnt/jfs
 				Value: 5,
 				Usage: "stats sampling duration",
 			},
+			&cli.Uint64Flag{
+				Name:  "accesslog-sec",
+				Value: 5,
+				Usage: "access log sampling duration",
+			},
 			&cli.Uint64Flag{
 				Name:  "trace-sec",
 				Value: 5,
@@ -379,6 +388,15 @@ func collectPprof(ctx *cli.Context, cmd string, pid string, amp string, requireR
 		"profile":      {name: fmt.Sprintf("profile.%ds.pb.gz", profile), url: fmt.Sprintf("%sprofile?seconds=%d", baseUrl, profile)},
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		item := metricItem{name: "object-errors.txt", url: fmt.Sprintf("http://localhost:%d/debug/object-errors", port)}
+		if err := reqAndSaveMetric("object-errors", item, currDir, 3*time.Second); err != nil {
+			logger.Errorf("Failed to get recent errors of object storage: %v", err)
+		}
+	}()
+
 	pprofOutDir := filepath.Join(currDir, "pprof")
 	if err := os.Mkdir(pprofOutDir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create out directory: %v", err)
@@ -499,9 +517,116 @@ func collectSpecialFile(ctx *cli.Context, amp string, currDir string, requireRoo
 			logger.Errorf("Failed to get volume config %s: %v", statsName, err)
 		}
 	}()
+
+	logName := ".jfs.accesslog"
+	if !prefixed {
+		logName = logName[4:]
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := collectAccessLog(filepath.Join(amp, logName), filepath.Join(currDir, "accesslog.txt"), ctx.Uint64("accesslog-sec"), requireRootPrivileges); err != nil {
+			logger.Errorf("Failed to get access log %s: %v", logName, err)
+		}
+	}()
 	return nil
 }
 
+// collectAccessLog saves the access log of the mount point in the next few seconds.
+func collectAccessLog(srcPath, destPath string, seconds uint64, requireRootPrivileges bool) error {
+	logger.Infof("Access log is being sampled, sampling duration: %ds", seconds)
+	if requireRootPrivileges {
+		timeoutCtx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds+5)*time.Second)
+		defer cancel()
+		err := exec.CommandContext(timeoutCtx, "sudo", "/bin/sh", "-c", fmt.Sprintf("timeout %d cat %s > %s", seconds, srcPath, destPath)).Run()
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 124 { // killed by timeout
+			err = nil
+		}
+		return err
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer closeFile(src)
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer closeFile(dest)
+	writer := bufio.NewWriter(dest)
+	deadline := time.Now().Add(time.Duration(seconds) * time.Second)
+	scanner := bufio.NewScanner(src)
+	for time.Now().Before(deadline) && scanner.Scan() { // there is a "#" every second without any operation
+		if line := scanner.Text(); line != "#" {
+			_, _ = writer.WriteString(line + "\n")
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// collectCacheStats saves the number and size of cached and staging blocks in the cache directories of the
+// mount point, by the config collected from it.
+func collectCacheStats(currDir string) error {
+	data, err := os.ReadFile(filepath.Join(currDir, "config.txt"))
+	if err != nil {
+		return err
+	}
+	var conf vfs.Config
+	if err = json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+	if conf.Chunk == nil {
+		return fmt.Errorf("no chunk config is found")
+	}
+	var result strings.Builder
+	fmt.Fprintf(&result, "cache-size: %s, cache-dir: %s", humanize.IBytes(conf.Chunk.CacheSize), conf.Chunk.CacheDir)
+	if conf.Chunk.CacheDev != "" {
+		fmt.Fprintf(&result, ", cache-dev: %s", conf.Chunk.CacheDev)
+	}
+	result.WriteString("\n")
+	dirs := utils.SplitDir(conf.Chunk.CacheDir)
+	for _, t := range conf.Chunk.CacheTiers {
+		dirs = append(dirs, utils.SplitDir(t.Dir)...)
+	}
+	for _, d := range dirs {
+		if d == "memory" {
+			continue
+		}
+		matched, _ := filepath.Glob(d)
+		for _, dir := range matched {
+			fmt.Fprintf(&result, "%s:", dir)
+			for _, sub := range []string{"raw", "rawstaging"} {
+				var count, size uint64
+				root := filepath.Join(dir, sub)
+				err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						if path == root {
+							return err
+						}
+						return nil
+					}
+					if !info.IsDir() {
+						count++
+						size += uint64(info.Size())
+					}
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintf(&result, " %s: %s;", sub, err)
+				} else {
+					fmt.Fprintf(&result, " %s: %d blocks, %s;", sub, count, humanize.IBytes(size))
+				}
+			}
+			result.WriteString("\n")
+		}
+	}
+	return os.WriteFile(filepath.Join(currDir, "cache.txt"), []byte(result.String()), 0644)
+}
+
 func debug(ctx *cli.Context) error {
 	setup(ctx, 1)
 	mp := ctx.Args().First()
@@ -567,6 +692,9 @@ func debug(ctx *cli.Context) error {
 	}
 
 	wg.Wait()
+	if err := collectCacheStats(currDir); err != nil {
+		logger.Errorf("Failed to collect cache stats: %v", err)
+	}
 	abs, _ := filepath.Abs(currDir)
 	logger.Infof("All files are collected to %s", abs)
 	return geneZipFile(currDir, filepath.Join(outDir, fmt.Sprintf("%s-%s.zip", prefix, timestamp)))
@@ -17,9 +17,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/vfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -46,3 +51,20 @@ func TestDebug(t *testing.T) {
 		require.True(t, logArg.FindStringSubmatch(c.arg)[2] == c.val, fmt.Sprintf("valid log arg %d", i))
 	}
 }
+
+func TestCollectCacheStats(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache", "uuid")
+	require.Nil(t, os.MkdirAll(filepath.Join(cacheDir, "raw", "chunks", "0"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(cacheDir, "raw", "chunks", "0", "1_0_4096"), make([]byte, 4096), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(cacheDir, "raw", "chunks", "0", "2_0_1024"), make([]byte, 1024), 0644))
+	conf := vfs.Config{Chunk: &chunk.Config{CacheDir: cacheDir, CacheSize: 100 << 20}}
+	data, _ := json.Marshal(conf)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "config.txt"), data, 0644))
+
+	require.Nil(t, collectCacheStats(dir))
+	result, err := os.ReadFile(filepath.Join(dir, "cache.txt"))
+	require.Nil(t, err)
+	require.Contains(t, string(result), "cache-size: 100 MiB")
+	require.Contains(t, string(result), cacheDir+": raw: 2 blocks, 5.0 KiB;")
+}
@@ -146,6 +146,45 @@ func storageFlags() []cli.Flag {
 			Name:  "download-limit",
 			Usage: "bandwidth limit for download in Mbps",
 		},
+		&cli.Int64Flag{
+			Name:  "put-limit",
+			Usage: "limit of PUT and DELETE requests to object storage per second",
+		},
+		&cli.Int64Flag{
+			Name:  "get-limit",
+			Usage: "limit of GET requests to object storage per second",
+		},
+		&cli.Int64Flag{
+			Name:  "list-limit",
+			Usage: "limit of LIST requests to object storage per second",
+		},
+		&cli.StringFlag{
+			Name:  "max-read-bw",
+			Usage: "bandwidth limit for reading files of this mount in Mbps (0 means unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "max-write-bw",
+			Usage: "bandwidth limit for writing files of this mount in Mbps (0 means unlimited)",
+		},
+		&cli.Int64Flag{
+			Name:  "max-iops",
+			Usage: "limit of reads and writes of files of this mount per second (0 means unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "io-priority",
+			Value: "normal",
+			Usage: "priority of the IO (normal or low), the limits of a low priority mount only take effect when a normal priority one on the same host is busy",
+		},
+		&cli.StringFlag{
+			Name:  "scrub-interval",
+			Value: "0",
+			Usage: "interval to verify all the blocks in object storage and repair the damaged ones from the redundant copies (0 means disable scrubbing)",
+		},
+		&cli.StringFlag{
+			Name:  "scrub-limit",
+			Value: "100",
+			Usage: "bandwidth limit for scrubbing in Mbps",
+		},
 		&cli.BoolFlag{
 			Name: "check-storage",
 			// AK/SK should have been checked before creating volume, here checks client access to the storage
@@ -196,6 +235,18 @@ func dataCacheFlags() []cli.Flag {
 			Name:  "writeback",
 			Usage: "upload blocks in background",
 		},
+		&cli.BoolFlag{
+			Name:  "writeback-durable",
+			Usage: "fsync the staging blocks before the writes are acknowledged, so they survive a crash of the node",
+		},
+		&cli.BoolFlag{
+			Name:  "fsync-upload",
+			Usage: "fsync() waits for the staging blocks of the file to be uploaded to object storage",
+		},
+		&cli.BoolFlag{
+			Name:  "log-writes",
+			Usage: "append small random writes to a log slice of the chunk instead of a new slice for each of them, for overwrite-heavy workloads like databases and VM images",
+		},
 		&cli.StringFlag{
 			Name:  "upload-delay",
 			Value: "0s",
@@ -248,6 +299,46 @@ func dataCacheFlags() []cli.Flag {
 			Value: chunk.Eviction2Random,
 			Usage: fmt.Sprintf("cache eviction policy [%s, %s, %s]", chunk.EvictionNone, chunk.Eviction2Random, chunk.EvictionLRU),
 		},
+		&cli.StringFlag{
+			Name:  "cache-tiers",
+			Usage: "faster cache tiers above cache-dir that keep frequently read blocks, separated by comma from the fastest one, each as DIRS=SIZE[:EVICTION] (e.g. memory=4G,/nvme/jfscache=200G:lru)",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dev",
+			Usage: "raw block device (or a large file) to cache blocks instead of cache-dir",
+		},
+		&cli.BoolFlag{
+			Name:  "cache-dev-format",
+			Usage: "format cache-dev if it's not used by this volume before (devices holding a file system or partition table are refused)",
+		},
+		&cli.BoolFlag{
+			Name:  "cache-encrypt",
+			Usage: "encrypt the cached and staging blocks with a key generated for every mount (writeback requires data encryption of the volume)",
+		},
+		&cli.StringFlag{
+			Name:  "cache-group",
+			Usage: "share the cached blocks with the clients in the same group, every block is downloaded and cached by only one of them",
+		},
+		&cli.StringFlag{
+			Name:  "group-listen",
+			Usage: "address (IP:PORT) of a local interface to serve the cached blocks to the cache group, the port could be 0 for a random one",
+		},
+		&cli.StringFlag{
+			Name:  "group-secret",
+			Usage: "secret shared by the members of the cache group to authenticate the requests",
+		},
+		&cli.StringFlag{
+			Name:  "group-cert",
+			Usage: "certificate (PEM) shared by the members of the cache group for mutual TLS",
+		},
+		&cli.StringFlag{
+			Name:  "group-key",
+			Usage: "private key (PEM) of group-cert",
+		},
+		&cli.StringFlag{
+			Name:  "group-ca",
+			Usage: "CA (PEM) to verify the certificates of the members, group-cert itself is trusted by default",
+		},
 		&cli.StringFlag{
 			Name:  "cache-scan-interval",
 			Value: "1h",
@@ -258,6 +349,15 @@ func dataCacheFlags() []cli.Flag {
 			Value: "0s",
 			Usage: "cached blocks not accessed for longer than this option will be automatically evicted (0 means never)",
 		},
+		&cli.StringFlag{
+			Name:  "cache-max-file-size",
+			Value: "0",
+			Usage: "do not cache the blocks of the files larger than this (in MiB if no unit is specified, 0 means no limit)",
+		},
+		&cli.StringFlag{
+			Name:  "cache-prefixes",
+			Usage: "only cache the blocks of the files under these paths in the mount point, separated by comma (e.g. /models,/datasets/train)",
+		},
 	})
 }
 
@@ -276,6 +376,23 @@ func metaFlags() []cli.Flag {
 			Name:  "backup-skip-trash",
 			Usage: "skip files in trash when backup metadata",
 		},
+		&cli.IntFlag{
+			Name:  "backup-meta-keep",
+			Usage: "max number of metadata backups to keep in the object storage (0 means no limit besides the default rotation)",
+		},
+		&cli.StringFlag{
+			Name:  "events-sink",
+			Usage: "publish metadata changes of this client to kafka://host:port/topic, nats://host:port/subject or an HTTP webhook",
+		},
+		&cli.StringFlag{
+			Name:  "events-prefix",
+			Usage: "only publish events under these directories (separated by comma)",
+		},
+		&cli.IntFlag{
+			Name:  "events-batch",
+			Value: 100,
+			Usage: "max number of events in a batch sent to the events sink",
+		},
 		&cli.StringFlag{
 			Name:  "heartbeat",
 			Value: "12s",
@@ -289,6 +406,24 @@ func metaFlags() []cli.Flag {
 			Name:  "no-bgjob",
 			Usage: "disable background jobs (clean-up, backup, etc.)",
 		},
+		&cli.IntFlag{
+			Name:  "async-delete-rate",
+			Value: 1000,
+			Usage: "max number of entries deleted per second in background for rmr --async (0 for unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "compact-window",
+			Usage: "only compact chunks in background within this time of the day, e.g. \"0:00-6:00\" (default: any time)",
+		},
+		&cli.IntFlag{
+			Name:  "max-compactions",
+			Value: 10,
+			Usage: "max number of chunks compacted concurrently in background",
+		},
+		&cli.Int64Flag{
+			Name:  "compact-limit",
+			Usage: "bandwidth limit for compaction in Mbps (0 for unlimited)",
+		},
 		&cli.StringFlag{
 			Name:  "atime-mode",
 			Value: "noatime",
@@ -317,6 +452,15 @@ func metaFlags() []cli.Flag {
 			Name:  "network-interfaces",
 			Usage: "comma-separated list of network interfaces to use for IP discovery (e.g. eth0,en0), empty means all",
 		},
+		&cli.StringFlag{
+			Name:  "slow-op-threshold",
+			Value: "0",
+			Usage: "log metadata operations and transactions slower than this duration (0 means disabled)",
+		},
+		&cli.StringFlag{
+			Name:  "slow-op-log",
+			Usage: "path of the file to write slow metadata operations to (default: the client log)",
+		},
 	})
 }
 
@@ -349,6 +493,15 @@ func shareInfoFlags() []cli.Flag {
 			Name:  "no-usage-report",
 			Usage: "do not send usage report",
 		},
+		&cli.StringFlag{
+			Name:  "tracing-endpoint",
+			Usage: "OTLP/gRPC endpoint (HOST:PORT or https://HOST:PORT) to export traces of operations",
+		},
+		&cli.Float64Flag{
+			Name:  "tracing-sample-ratio",
+			Value: 0.01,
+			Usage: "ratio of operations to trace",
+		},
 	})
 }
 
@@ -377,6 +530,10 @@ func metaCacheFlags(defaultEntryCache float64) []cli.Flag {
 			Name:  "readdir-cache",
 			Usage: "enable kernel caching of readdir entries, with timeout controlled by attr-cache flag (require linux kernel 4.20+)",
 		},
+		&cli.BoolFlag{
+			Name:  "watch-changes",
+			Usage: "invalidate the kernel cache of the entries and files changed by other clients from the changelog, only their deletions and the old names of renamed files are seen by inotify (Linux only, require changelog of the volume)",
+		},
 		&cli.StringFlag{
 			Name:  "open-cache",
 			Value: "0s",
@@ -24,6 +24,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
@@ -155,12 +156,20 @@ func formatFlags() []cli.Flag {
 		&cli.StringFlag{
 			Name:  "compress",
 			Value: "none",
-			Usage: "compression algorithm (lz4, zstd, none)",
+			Usage: "compression algorithm (lz4, zstd, none), the level of zstd can be specified as zstd:LEVEL (1-22)",
+		},
+		&cli.StringFlag{
+			Name:  "compress-dict",
+			Usage: "a zstd dictionary, or a directory of sample files to train one from (only for new volumes)",
 		},
 		&cli.StringFlag{
 			Name:  "encrypt-rsa-key",
 			Usage: "a path to RSA private key (PEM)",
 		},
+		&cli.StringFlag{
+			Name:  "encrypt-kms",
+			Usage: "URI of the master key in an external KMS to wrap the data key (aws-kms://, gcp-kms:// or vault://)",
+		},
 		&cli.StringFlag{
 			Name:  "encrypt-algo",
 			Usage: "encrypt algorithm (aes256gcm-rsa, chacha20-rsa)",
@@ -174,6 +183,14 @@ func formatFlags() []cli.Flag {
 			Name:  "shards",
 			Usage: "store the blocks into N buckets by hash of key",
 		},
+		&cli.IntFlag{
+			Name:  "data-shards",
+			Usage: "number of data shards for erasure coding (--storage ec)",
+		},
+		&cli.IntFlag{
+			Name:  "parity-shards",
+			Usage: "number of parity shards for erasure coding (--storage ec)",
+		},
 	})
 }
 
@@ -192,10 +209,22 @@ func formatManagementFlags() []cli.Flag {
 			Value: 1,
 			Usage: "number of days after which removed files will be permanently deleted",
 		},
+		&cli.StringFlag{
+			Name:  "trash-user-quota",
+			Usage: "max size of the removed files of a user in trash in GiB, the oldest ones are deleted before they expire when it's exceeded (0 means unlimited)",
+		},
+		&cli.IntFlag{
+			Name:  "changelog-days",
+			Usage: "number of days to keep the metadata changelog for watchers (0 means disabled)",
+		},
 		&cli.BoolFlag{
 			Name:  "enable-acl",
 			Usage: "enable POSIX ACL (this flag is irreversible once enabled)",
 		},
+		&cli.BoolFlag{
+			Name:  "block-checksum",
+			Usage: "store the checksums of new blocks in the metadata and verify the blocks when reading them (this flag is irreversible once enabled)",
+		},
 		&cli.StringFlag{
 			Name:  "ranger-rest-url",
 			Usage: "URL of the RangerAdmin",
@@ -226,7 +255,33 @@ func fixObjectSize(s uint64) uint64 {
 }
 
 func createStorage(format meta.Format) (object.ObjectStorage, error) {
+	blob, err := createBucket(format)
+	if err != nil {
+		return nil, err
+	}
+	blob = object.WithPrefix(blob, format.Name+"/")
+	if format.StorageClass != "" {
+		if os, ok := blob.(object.SupportStorageClass); ok {
+			err := os.SetStorageClass(format.StorageClass)
+			if err != nil {
+				logger.Warnf("set storage class %q: %v", format.StorageClass, err)
+			}
+		} else {
+			logger.Warnf("Storage class is not supported by %q, will ignore", format.Storage)
+		}
+	}
+	encryptor, err := newEncryptor(format)
+	if err != nil {
+		return nil, err
+	}
+	if encryptor != nil {
+		blob = object.NewEncrypted(blob, encryptor)
+	}
+	return blob, nil
+}
 
+// createBucket returns the bucket of the volume, the objects of all the volumes in it are accessible.
+func createBucket(format meta.Format) (object.ObjectStorage, error) {
 	if err := format.Decrypt(); err != nil {
 		return nil, fmt.Errorf("format decrypt: %s", err)
 	}
@@ -269,24 +324,26 @@ func createStorage(format meta.Format) (object.ObjectStorage, error) {
 		}
 	}
 
-	if format.Shards > 1 {
+	switch storage := strings.ToLower(format.Storage); {
+	case storage == "ec":
+		blob, err = object.NewErasureCoded(format.Bucket, format.AccessKey, format.SecretKey, format.SessionToken, format.DataShards, format.ParityShards)
+	case storage == "replica":
+		blob, err = object.NewReplicated(format.Bucket, format.AccessKey, format.SecretKey, format.SessionToken)
+	case format.Shards > 1:
 		blob, err = object.NewSharded(strings.ToLower(format.Storage), format.Bucket, format.AccessKey, format.SecretKey, format.SessionToken, format.Shards)
-	} else {
+	default:
 		blob, err = object.CreateStorage(strings.ToLower(format.Storage), format.Bucket, format.AccessKey, format.SecretKey, format.SessionToken)
 	}
 	if err != nil {
 		return nil, err
 	}
-	blob = object.WithPrefix(blob, format.Name+"/")
-	if format.StorageClass != "" {
-		if os, ok := blob.(object.SupportStorageClass); ok {
-			err := os.SetStorageClass(format.StorageClass)
-			if err != nil {
-				logger.Warnf("set storage class %q: %v", format.StorageClass, err)
-			}
-		} else {
-			logger.Warnf("Storage class is not supported by %q, will ignore", format.Storage)
-		}
+	return blob, nil
+}
+
+// newEncryptor returns the encryptor for the data of the volume, or nil if it's not encrypted.
+func newEncryptor(format meta.Format) (object.Encryptor, error) {
+	if err := format.Decrypt(); err != nil {
+		return nil, fmt.Errorf("format decrypt: %s", err)
 	}
 	if format.EncryptKey != "" {
 		passphrase := os.Getenv("JFS_RSA_PASSPHRASE")
@@ -302,13 +359,19 @@ func createStorage(format meta.Format) (object.ObjectStorage, error) {
 		if err != nil {
 			return nil, fmt.Errorf("parse rsa: %s", err)
 		}
-		encryptor, err := object.NewDataEncryptor(object.NewRSAEncryptor(privKey), format.EncryptAlgo)
+		return object.NewDataEncryptor(object.NewRSAEncryptor(privKey), format.EncryptAlgo)
+	} else if format.EncryptKMS != "" {
+		kms, err := object.NewKMS(format.EncryptKMS)
 		if err != nil {
 			return nil, err
 		}
-		blob = object.NewEncrypted(blob, encryptor)
+		keyEncryptor, err := object.NewKMSEncryptor(kms, format.EncryptDataKeys)
+		if err != nil {
+			return nil, err
+		}
+		return object.NewDataEncryptor(keyEncryptor, format.EncryptAlgo)
 	}
-	return blob, nil
+	return nil, nil
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -381,6 +444,51 @@ func test(store object.ObjectStorage) error {
 	return err
 }
 
+// loadCompressDict reads a dictionary of zstd from path, or trains one from the files under it if it's a directory.
+func loadCompressDict(path, algr string) []byte {
+	if path == "" {
+		return nil
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		logger.Fatalf("compression dictionary: %s", err)
+	}
+	if !st.IsDir() {
+		d, err := os.ReadFile(path)
+		if err != nil {
+			logger.Fatalf("read compression dictionary from %s: %s", path, err)
+		}
+		return d
+	}
+	var samples [][]byte
+	var total int
+	err = filepath.WalkDir(path, func(p string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() || total >= 100<<20 {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, 128<<10)) // the beginning of large files is enough
+		if err == nil && len(data) > 0 {
+			samples = append(samples, data)
+			total += len(data)
+		}
+		return err
+	})
+	if err != nil {
+		logger.Fatalf("read samples from %s: %s", path, err)
+	}
+	d, err := compress.TrainDict(algr, samples, compress.MaxDictSize)
+	if err != nil {
+		logger.Fatalf("train compression dictionary from %d samples: %s", len(samples), err)
+	}
+	logger.Infof("Trained a dictionary of %d bytes from %d samples for %s", len(d), len(samples), algr)
+	return d
+}
+
 func loadEncrypt(keyPath string) string {
 	if keyPath == "" {
 		return ""
@@ -407,9 +515,15 @@ func format(c *cli.Context) error {
 	if v := c.Int("trash-days"); v < 0 {
 		logger.Fatalf("Invalid trash days: %d", v)
 	}
+	if v := c.Int("changelog-days"); v < 0 {
+		logger.Fatalf("Invalid changelog days: %d", v)
+	}
 	if v := c.Int("shards"); v > 256 {
 		logger.Fatalf("too many shards: %d", v)
 	}
+	if d, p := c.Int("data-shards"), c.Int("parity-shards"); d < 0 || p < 0 || d+p > 256 {
+		logger.Fatalf("invalid erasure coding: %d data shards and %d parity shards", d, p)
+	}
 
 	var create, encrypted bool
 	format, err := m.Load(false)
@@ -442,17 +556,25 @@ func format(c *cli.Context) error {
 				format.SessionToken = c.String(flag)
 			case "trash-days":
 				format.TrashDays = c.Int(flag)
+			case "trash-user-quota":
+				format.TrashUserQuota = utils.ParseBytes(c, flag, 'G')
+			case "changelog-days":
+				format.ChangelogDays = c.Int(flag)
 			case "block-size":
 				format.BlockSize = int(fixObjectSize(utils.ParseBytes(c, flag, 'K')) >> 10)
 			case "compress":
 				format.Compression = c.String(flag)
 			case "shards":
 				format.Shards = c.Int(flag)
+			case "data-shards":
+				format.DataShards = c.Int(flag)
+			case "parity-shards":
+				format.ParityShards = c.Int(flag)
 			case "hash-prefix":
 				format.HashPrefix = c.Bool(flag)
 			case "storage":
 				format.Storage = c.String(flag)
-			case "encrypt-rsa-key", "encrypt-algo":
+			case "encrypt-rsa-key", "encrypt-kms", "encrypt-algo", "compress-dict":
 				logger.Warnf("Flag %s is ignored since it cannot be updated", flag)
 			case "ranger-rest-url":
 				format.RangerRestUrl = c.String(flag)
@@ -473,18 +595,25 @@ func format(c *cli.Context) error {
 			SessionToken:     c.String("session-token"),
 			EncryptKey:       loadEncrypt(c.String("encrypt-rsa-key")),
 			EncryptAlgo:      c.String("encrypt-algo"),
+			EncryptKMS:       c.String("encrypt-kms"),
 			Shards:           c.Int("shards"),
+			DataShards:       c.Int("data-shards"),
+			ParityShards:     c.Int("parity-shards"),
 			HashPrefix:       c.Bool("hash-prefix"),
 			Capacity:         utils.ParseBytes(c, "capacity", 'G'),
 			Inodes:           c.Uint64("inodes"),
 			BlockSize:        int(fixObjectSize(utils.ParseBytes(c, "block-size", 'K')) >> 10),
 			Compression:      c.String("compress"),
 			TrashDays:        c.Int("trash-days"),
+			TrashUserQuota:   utils.ParseBytes(c, "trash-user-quota", 'G'),
+			ChangelogDays:    c.Int("changelog-days"),
 			DirStats:         true,
 			UserGroupQuota:   false,
 			MetaVersion:      meta.MaxVersion,
 			MinClientVersion: "1.1.0-A",
 			EnableACL:        c.Bool("enable-acl"),
+			BlockChecksum:    c.Bool("block-checksum"),
+			CompressDict:     loadCompressDict(c.String("compress-dict"), c.String("compress")),
 			RangerRestUrl:    c.String("ranger-rest-url"),
 			RangerService:    c.String("ranger-service"),
 		}
@@ -507,9 +636,44 @@ func format(c *cli.Context) error {
 			format.SessionToken = os.Getenv("SESSION_TOKEN")
 			_ = os.Unsetenv("SESSION_TOKEN")
 		}
+		if format.EncryptKMS != "" {
+			if format.EncryptKey != "" {
+				logger.Fatalf("--encrypt-rsa-key and --encrypt-kms cannot be used together")
+			}
+			kms, err := object.NewKMS(format.EncryptKMS)
+			if err != nil {
+				logger.Fatalf("KMS: %s", err)
+			}
+			key, err := object.NewWrappedDataKey(kms)
+			if err != nil {
+				logger.Fatalf("generate data key with %s: %s", kms, err)
+			}
+			format.EncryptDataKeys = [][]byte{key}
+			format.MinClientVersion = "1.4.0-A"
+		}
+		if format.DataShards > 0 || format.ParityShards > 0 || strings.EqualFold(format.Storage, "replica") || format.BlockChecksum ||
+			strings.Contains(format.Compression, ":") || len(format.CompressDict) > 0 {
+			format.MinClientVersion = "1.4.0-A"
+		}
+		if _, err := compress.NewCompressorWithDict(format.Compression, format.CompressDict); err != nil {
+			logger.Fatalf("compression dictionary: %s", err)
+		}
 	} else {
 		logger.Fatalf("Load metadata: %s", err)
 	}
+	if strings.ToLower(format.Storage) == "ec" {
+		if format.DataShards == 0 || format.ParityShards == 0 {
+			logger.Fatalf("--data-shards and --parity-shards are required for erasure coding")
+		}
+		if format.Shards > 1 {
+			logger.Fatalf("--shards cannot be used with erasure coding")
+		}
+	} else if format.DataShards > 0 || format.ParityShards > 0 {
+		logger.Fatalf("--data-shards and --parity-shards only work with --storage ec")
+	}
+	if strings.ToLower(format.Storage) == "replica" && format.Shards > 1 {
+		logger.Fatalf("--shards cannot be used with replication")
+	}
 	if format.Storage == "file" || format.Storage == "sqlite3" {
 		p, err := filepath.Abs(format.Bucket)
 		if err == nil {
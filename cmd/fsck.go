@@ -18,12 +18,16 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
+	"syscall"
 
+	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juicedata/juicefs/pkg/vfs"
 
 	"github.com/urfave/cli/v2"
 )
@@ -37,12 +41,16 @@ func cmdFsck() *cli.Command {
 		ArgsUsage: "META-URL",
 		Description: `
 It scans all objects in data storage and slices in metadata, comparing them to see if there is any
-lost object or broken file.
+lost object or broken file. With --repair, the files whose trailing blocks are lost are truncated
+(the damaged range is logged), and the orphan files (not in any directory) are linked into /lost+found.
 
 Examples:
 $ juicefs fsck redis://localhost
 
-# Repair broken directories
+# Truncate broken files and reattach orphan files
+$ juicefs fsck redis://localhost --repair
+
+# Repair broken directories and nlink of files
 $ juicefs fsck redis://localhost --path /d1/d2 --repair
 
 # recursively check
@@ -54,7 +62,7 @@ $ juicefs fsck redis://localhost --path /d1/d2 --recursive`,
 			},
 			&cli.BoolFlag{
 				Name:  "repair",
-				Usage: "repair specified path if it's broken",
+				Usage: "repair specified path if it's broken, or the broken and orphan files if no path is specified",
 			},
 			&cli.BoolFlag{
 				Name:    "recursive",
@@ -71,9 +79,6 @@ $ juicefs fsck redis://localhost --path /d1/d2 --recursive`,
 
 func fsck(ctx *cli.Context) error {
 	setup(ctx, 1)
-	if ctx.Bool("repair") && ctx.String("path") == "" {
-		logger.Fatalf("Please provide the path to repair with `--path` option")
-	}
 	removePassword(ctx.Args().Get(0))
 	m := meta.NewClient(ctx.Args().Get(0), nil)
 	format, err := m.Load(true)
@@ -96,6 +101,8 @@ func fsck(ctx *cli.Context) error {
 		logger.Fatalf("object storage: %s", err)
 	}
 	logger.Infof("Data use %s", blob)
+	store := chunk.NewCachedStore(blob, chunkConf, nil)
+	store.SetChecksumStore(m)
 	blob = object.WithPrefix(blob, "chunks/")
 	objs, err := object.ListAll(ctx.Context, blob, "", "", true, false)
 	if err != nil {
@@ -155,6 +162,7 @@ func fsck(ctx *cli.Context) error {
 	sliceBSpin := progress.AddByteSpinner("Scanned slices")
 	lostDSpin := progress.AddDoubleSpinner("Lost blocks")
 	brokens := make(map[meta.Ino]string)
+	lostBlocks := make(map[meta.Ino]map[uint64][]uint32)
 	for inode, ss := range slices {
 		if delfiles[inode] {
 			skippedSlices.IncrBy(len(ss))
@@ -185,6 +193,10 @@ func fsck(ctx *cli.Context) error {
 						}
 						logger.Errorf("can't find block %s for file %s: %s", objKey, brokens[inode], err)
 						lostDSpin.IncrInt64(int64(sz))
+						if lostBlocks[inode] == nil {
+							lostBlocks[inode] = make(map[uint64][]uint32)
+						}
+						lostBlocks[inode][s.Id] = append(lostBlocks[inode][s.Id], i)
 					}
 				}
 			}
@@ -196,7 +208,22 @@ func fsck(ctx *cli.Context) error {
 	if progress.Quiet {
 		logger.Infof("Used by %d slices (%d bytes)", sliceCBar.Current(), sliceBSpin.Current())
 	}
-	if lc, lb := lostDSpin.Current(); lc > 0 {
+	if ctx.Bool("repair") {
+		// compact the truncated files, so the slices with lost blocks are released
+		m.OnMsg(meta.DeleteSlice, func(args ...interface{}) error {
+			return store.Remove(args[0].(uint64), int(args[1].(uint32)))
+		})
+		m.OnMsg(meta.CompactChunk, func(args ...interface{}) error {
+			return vfs.Compact(chunkConf, store, args[0].([]meta.Slice), args[1].(uint64))
+		})
+		for inode, lost := range lostBlocks {
+			if truncateBrokenFile(c, m, inode, brokens[inode], lost, chunkConf.BlockSize) {
+				delete(brokens, inode)
+			}
+		}
+		reattachOrphans(c, m, slices, delfiles)
+	}
+	if lc, lb := lostDSpin.Current(); lc > 0 && len(brokens) > 0 {
 		msg := fmt.Sprintf("%d objects are lost (%d bytes), %d broken files:\n", lc, lb, len(brokens))
 		msg += fmt.Sprintf("%13s: PATH\n", "INODE")
 		var fileList []string
@@ -210,3 +237,106 @@ func fsck(ctx *cli.Context) error {
 
 	return nil
 }
+
+// truncateBrokenFile truncates the file at the first lost block if there is no valid data after it, and
+// logs the damaged range.
+func truncateBrokenFile(c meta.Context, m meta.Meta, inode meta.Ino, fpath string, lost map[uint64][]uint32, blockSize int) bool {
+	var attr meta.Attr
+	if st := m.GetAttr(c, inode, &attr); st != 0 {
+		logger.Errorf("getattr %s: %s", fpath, st)
+		return false
+	}
+	firstLost, lastLost, lastValid := attr.Length, uint64(0), uint64(0)
+	for indx := uint32(0); uint64(indx)*meta.ChunkSize < attr.Length; indx++ {
+		var ss []meta.Slice
+		if st := m.Read(c, inode, indx, &ss); st != 0 {
+			logger.Errorf("read chunk %d of %s: %s", indx, fpath, st)
+			return false
+		}
+		pos := uint64(indx) * meta.ChunkSize
+		for _, s := range ss {
+			// split the used range of the slice by blocks
+			for off := s.Off; s.Id > 0 && off < s.Off+s.Len; {
+				b := off / uint32(blockSize)
+				end := (b + 1) * uint32(blockSize)
+				if end > s.Off+s.Len {
+					end = s.Off + s.Len
+				}
+				start, stop := pos+uint64(off-s.Off), pos+uint64(end-s.Off)
+				if slices.Contains(lost[s.Id], b) {
+					firstLost, lastLost = min(firstLost, start), max(lastLost, stop)
+				} else {
+					lastValid = max(lastValid, stop)
+				}
+				off = end
+			}
+			pos += uint64(s.Len)
+		}
+	}
+	if firstLost >= lastLost {
+		logger.Infof("The lost blocks of %s are not used by its content", fpath)
+		return true
+	}
+	if firstLost < lastValid {
+		logger.Errorf("File %s is damaged in range [%d, %d), which can't be repaired by truncating", fpath, firstLost, lastLost)
+		return false
+	}
+	logger.Warnf("File %s is damaged in range [%d, %d), truncate it from %d to %d bytes", fpath, firstLost, attr.Length, attr.Length, firstLost)
+	if st := m.Truncate(c, inode, 0, firstLost, &attr, true); st != 0 {
+		logger.Errorf("truncate %s: %s", fpath, st)
+		return false
+	}
+	if st := m.Compact(c, inode, 1, func() {}, func() {}); st != 0 {
+		logger.Warnf("compact %s: %s", fpath, st)
+	}
+	return true
+}
+
+// reattachOrphans links the files with data but not in their parent directories into /lost+found. The files
+// are checked by the entries of their parents, which are listed once for all the files in them, and the ones
+// can't be checked are skipped.
+func reattachOrphans(c meta.Context, m meta.Meta, slices map[meta.Ino][]meta.Slice, delfiles map[meta.Ino]bool) {
+	type file struct {
+		inode, parent meta.Ino
+	}
+	var files []file
+	for inode := range slices {
+		if delfiles[inode] || !inode.IsNormal() || inode == meta.RootInode {
+			continue
+		}
+		var attr meta.Attr
+		if st := m.GetAttr(c, inode, &attr); st != 0 || attr.Nlink == 0 || attr.Parent == 0 {
+			continue // deleted, still opened after unlinked, or with hard links
+		}
+		files = append(files, file{inode, attr.Parent})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].parent < files[j].parent })
+	var parent meta.Ino
+	var children map[meta.Ino]bool
+	var listErr syscall.Errno
+	for _, f := range files {
+		if f.parent != parent {
+			parent, children, listErr = f.parent, make(map[meta.Ino]bool), 0
+			var entries []*meta.Entry
+			if st := m.Readdir(c, parent, 0, &entries); st != 0 && st != syscall.ENOENT && st != syscall.ENOTDIR {
+				logger.Warnf("list directory %d to find orphans: %s", parent, st)
+				listErr = st
+			}
+			for _, e := range entries {
+				children[e.Inode] = true
+			}
+		}
+		if listErr != 0 || children[f.inode] {
+			continue
+		}
+		var attr meta.Attr
+		p, st := m.Reattach(c, f.inode, &attr)
+		switch st {
+		case 0:
+			logger.Warnf("Orphan file (inode %d, %d bytes) is reattached as %s", f.inode, attr.Length, p)
+		case syscall.EEXIST, syscall.ENOENT:
+		default:
+			logger.Errorf("reattach orphan inode %d: %s", f.inode, st)
+		}
+	}
+}
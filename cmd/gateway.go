@@ -254,6 +254,7 @@ func initForSvc(c *cli.Context, mp string, svcType, metaUrl, listenAddr string)
 	chunkConf := getChunkConf(c, format)
 	store := chunk.NewCachedStore(blob, *chunkConf, registerer)
 	registerMetaMsg(metaCli, store, chunkConf)
+	joinCacheGroup(metaCli, metaConf, store, chunkConf.CacheGroup)
 
 	err = metaCli.NewSession(true)
 	if err != nil {
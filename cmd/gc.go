@@ -17,6 +17,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -28,6 +29,7 @@ import (
 	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/vfs"
+	"github.com/juju/ratelimit"
 	"github.com/pkg/errors"
 
 	"github.com/urfave/cli/v2"
@@ -53,7 +55,10 @@ $ juicefs gc redis://localhost
 $ juicefs gc redis://localhost --compact
 
 # Delete leaked objects or metadata and delayed deleted slices or files
-$ juicefs gc redis://localhost --delete`,
+$ juicefs gc redis://localhost --delete
+
+# Keep deleting them in background, scanning 1000 objects per second at most
+$ juicefs gc redis://localhost --delete --daemon --interval 6h --rate 1000`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "compact",
@@ -69,6 +74,25 @@ $ juicefs gc redis://localhost --delete`,
 				Value:   10,
 				Usage:   "number threads to delete leaked objects",
 			},
+			&cli.BoolFlag{
+				Name:  "daemon",
+				Usage: "run in background and scan the objects incrementally, keeping the position in the metadata engine",
+			},
+			&cli.StringFlag{
+				Name:  "interval",
+				Value: "6h",
+				Usage: "interval between the rounds of scanning in daemon mode",
+			},
+			&cli.StringFlag{
+				Name:  "slices-interval",
+				Value: "24h",
+				Usage: "interval between the listings of all the slices in daemon mode, the rounds in between reuse the last listing (0 means every round)",
+			},
+			&cli.IntFlag{
+				Name:  "rate",
+				Value: 1000,
+				Usage: "maximum number of objects to scan per second in daemon mode (0 means unlimited)",
+			},
 		},
 	}
 }
@@ -99,6 +123,13 @@ func gc(ctx *cli.Context) error {
 	}
 	logger.Infof("Data use %s", blob)
 	store := chunk.NewCachedStore(blob, chunkConf, nil)
+	store.SetChecksumStore(m)
+	if ctx.Bool("daemon") {
+		if ctx.Bool("compact") {
+			logger.Fatal("compact can not be used in daemon mode")
+		}
+		return gcDaemon(ctx, m, format, blob, store, chunkConf.BlockSize)
+	}
 
 	// Scan all chunks first and do compaction if necessary
 	progress := utils.NewProgress(false)
@@ -109,16 +140,7 @@ func gc(ctx *cli.Context) error {
 	if (delete || compact) && threads <= 0 {
 		logger.Fatal("threads should be greater than 0 to delete or compact objects")
 	}
-	maxMtime := time.Now().Add(time.Hour * -1)
-	strDuration := os.Getenv("JFS_GC_SKIPPEDTIME")
-	if strDuration != "" {
-		iDuration, err := strconv.Atoi(strDuration)
-		if err == nil {
-			maxMtime = time.Now().Add(time.Second * -1 * time.Duration(iDuration))
-		} else {
-			logger.Errorf("parse JFS_GC_SKIPPEDTIME=%s: %s", strDuration, err)
-		}
-	}
+	maxMtime := time.Now().Add(-gcSkippedTime())
 
 	var wg sync.WaitGroup
 	var delSpin *utils.Bar
@@ -302,32 +324,24 @@ func gc(ctx *cli.Context) error {
 		}
 
 		logger.Debugf("found block %s", obj.Key())
-		parts := strings.Split(obj.Key(), "/")
-		if len(parts) != 3 {
-			continue
-		}
-		name := parts[2]
-		parts = strings.Split(name, "_")
-		if len(parts) != 3 {
+		cid, indx, csize, ok := parseBlockKey(obj.Key())
+		if !ok {
 			continue
 		}
 		bar.Increment()
-		cid, _ := strconv.Atoi(parts[0])
-		size := vkeys[uint64(cid)]
+		size := vkeys[cid]
 		var pobj, cobj bool
 		if size == 0 {
-			size, pobj = pkeys[uint64(cid)]
+			size, pobj = pkeys[cid]
 		}
 		if size == 0 {
-			size, cobj = ckeys[uint64(cid)]
+			size, cobj = ckeys[cid]
 		}
 		if size == 0 {
 			logger.Debugf("find leaked object: %s, size: %d", obj.Key(), obj.Size())
 			foundLeaked(obj)
 			continue
 		}
-		indx, _ := strconv.Atoi(parts[1])
-		csize, _ := strconv.Atoi(parts[2])
 		if csize == chunkConf.BlockSize {
 			if (indx+1)*csize > int(size) {
 				logger.Warnf("size of slice %d is larger than expected: %d > %d", cid, indx*chunkConf.BlockSize+csize, size)
@@ -380,3 +394,180 @@ func gc(ctx *cli.Context) error {
 	}
 	return nil
 }
+
+// gcSkippedTime returns how long the new objects are skipped, since their slices may not be committed yet.
+func gcSkippedTime() time.Duration {
+	skipped := time.Hour
+	if strDuration := os.Getenv("JFS_GC_SKIPPEDTIME"); strDuration != "" {
+		iDuration, err := strconv.Atoi(strDuration)
+		if err == nil {
+			skipped = time.Second * time.Duration(iDuration)
+		} else {
+			logger.Errorf("parse JFS_GC_SKIPPEDTIME=%s: %s", strDuration, err)
+		}
+	}
+	return skipped
+}
+
+// parseBlockKey parses the key of a block relative to "chunks/", which is like "0/1/1234_0_4194304".
+func parseBlockKey(key string) (id uint64, indx, size int, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return
+	}
+	parts = strings.Split(parts[2], "_")
+	if len(parts) != 3 {
+		return
+	}
+	cid, _ := strconv.Atoi(parts[0])
+	indx, _ = strconv.Atoi(parts[1])
+	size, _ = strconv.Atoi(parts[2])
+	return uint64(cid), indx, size, true
+}
+
+// gcDaemon scans the objects under one top-level directory of "chunks/" at a time, and saves the next one
+// to scan as a volume-level xattr, so a restarted daemon continues from it. The trash and pending deleted
+// files and slices are cleaned before each round. Listing all the slices is expensive for a large volume, so
+// it's done at most once every slices-interval, and the objects written after it are skipped until the next one.
+func gcDaemon(ctx *cli.Context, m meta.Meta, format *meta.Format, blob object.ObjectStorage, store chunk.ChunkStore, blockSize int) error {
+	interval := utils.Duration(ctx.String("interval"))
+	if interval <= 0 {
+		logger.Fatalf("invalid interval: %s", ctx.String("interval"))
+	}
+	delete := ctx.Bool("delete")
+	var bucket *ratelimit.Bucket
+	if rate := ctx.Int("rate"); rate > 0 {
+		bucket = ratelimit.NewBucketWithRate(float64(rate), int64(rate))
+	}
+	if delete {
+		m.OnMsg(meta.DeleteSlice, func(args ...interface{}) error {
+			return store.Remove(args[0].(uint64), int(args[1].(uint32)))
+		})
+	}
+	m.OnMsg(meta.CompactChunk, func(args ...interface{}) error {
+		return nil // ignore compaction
+	})
+	slicesInterval := utils.Duration(ctx.String("slices-interval"))
+	c := meta.WrapContext(ctx.Context)
+	key := "gcPosition"
+	blob = object.WithPrefix(blob, "chunks/")
+	var sizes map[uint64]uint32
+	var dirs uint64
+	var listed time.Time
+	for {
+		start := time.Now()
+		if delete {
+			edge := start.Add(-time.Duration(format.TrashDays) * 24 * time.Hour)
+			m.CleanupTrashBefore(c, edge, nil)
+			m.CleanupDetachedNodesBefore(c, start.Add(-time.Hour*24), nil)
+			err := m.ScanDeletedObject(c,
+				func(ss []meta.Slice, ts int64) (bool, error) { return ts < edge.Unix(), nil },
+				nil, nil,
+				func(_ meta.Ino, size uint64, ts int64) (bool, error) { return true, nil },
+			)
+			if err != nil {
+				logger.Warnf("scan deleted object: %s", err)
+			}
+		}
+
+		if sizes == nil || start.Sub(listed) >= slicesInterval {
+			sizes, dirs = listSliceSizes(c, m, format, delete)
+			listed = start
+		}
+
+		var value []byte
+		if st := m.GetXattr(c, 0, key, &value); st != 0 && st != meta.ENOATTR {
+			logger.Fatalf("getxattr inode 0 key %s: %s", key, st)
+		}
+		next, _ := strconv.ParseUint(string(value), 10, 64)
+		if next > 0 {
+			logger.Infof("Continue scanning from directory %d of %d", next, dirs)
+		}
+		// the slices written after the listing are unknown, so are their objects
+		maxMtime := listed.Add(-gcSkippedTime())
+		var scanned, leaked, leakedBytes int64
+		for ; next < dirs; next++ {
+			prefix := strconv.FormatUint(next, 10) + "/"
+			if format.HashPrefix {
+				prefix = fmt.Sprintf("%02X/", next)
+			}
+			objs, err := object.ListAll(ctx.Context, blob, prefix, "", true, false)
+			if err != nil {
+				logger.Warnf("list %s: %s", prefix, err)
+				break
+			}
+			for obj := range objs {
+				if obj == nil {
+					err = fmt.Errorf("listing failed")
+					break
+				}
+				if obj.IsDir() {
+					continue
+				}
+				if bucket != nil {
+					bucket.Wait(1)
+				}
+				scanned++
+				if obj.Mtime().After(maxMtime) || obj.Mtime().Unix() == 0 {
+					continue
+				}
+				id, indx, csize, ok := parseBlockKey(obj.Key())
+				if !ok {
+					continue
+				}
+				size := sizes[id]
+				if size != 0 && (csize == blockSize && (indx+1)*csize <= int(size) || indx*blockSize+csize == int(size)) {
+					continue
+				}
+				logger.Debugf("find leaked object: %s, size: %d", obj.Key(), obj.Size())
+				leaked++
+				leakedBytes += obj.Size()
+				if delete {
+					if err := blob.Delete(ctx.Context, obj.Key()); err != nil {
+						logger.Warnf("delete %s: %s", obj.Key(), err)
+					}
+				}
+			}
+			if err != nil {
+				logger.Warnf("scan %s: %s", prefix, err)
+				break
+			}
+			if st := m.SetXattr(c, 0, key, []byte(strconv.FormatUint(next+1, 10)), meta.XattrCreateOrReplace); st != 0 {
+				logger.Warnf("setxattr inode 0 key %s: %s", key, st)
+			}
+		}
+		if next >= dirs {
+			if st := m.SetXattr(c, 0, key, []byte("0"), meta.XattrCreateOrReplace); st != 0 {
+				logger.Warnf("setxattr inode 0 key %s: %s", key, st)
+			}
+		}
+		logger.Infof("Scanned %d objects in %s, found %d leaked (%d bytes)", scanned, time.Since(start), leaked, leakedBytes)
+		if leaked > 0 && !delete {
+			logger.Infof("Please add `--delete` to clean leaked objects")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// listSliceSizes returns the sizes of all the slices, and the number of top-level directories of "chunks/".
+func listSliceSizes(c meta.Context, m meta.Meta, format *meta.Format, delete bool) (map[uint64]uint32, uint64) {
+	slices := make(map[meta.Ino][]meta.Slice)
+	if st := m.ListSlices(c, slices, true, delete, nil); st != 0 {
+		logger.Fatalf("list all slices: %s", st)
+	}
+	sizes := make(map[uint64]uint32)
+	var maxId uint64
+	for _, ss := range slices {
+		for _, s := range ss {
+			sizes[s.Id] = s.Size
+			if s.Id > maxId {
+				maxId = s.Id
+			}
+		}
+	}
+	dirs := maxId/1000/1000 + 1
+	if format.HashPrefix {
+		dirs = 256
+	}
+	return sizes, dirs
+}
@@ -109,3 +109,19 @@ func TestGc(t *testing.T) {
 		t.Fatalf("gc failed: %s", err)
 	}
 }
+
+func TestParseBlockKey(t *testing.T) {
+	for key, expect := range map[string][3]int{
+		"0/1/1234_0_4194304":  {1234, 0, 4194304},
+		"0A/0/10_3_1024":      {10, 3, 1024},
+		"1/1000/1000001_2_10": {1000001, 2, 10},
+	} {
+		id, indx, size, ok := parseBlockKey(key)
+		require.True(t, ok, key)
+		require.Equal(t, expect, [3]int{int(id), indx, size}, key)
+	}
+	for _, key := range []string{"0/1234_0_4194304", "0/1/1234_0", "0/1/2/1234_0_1"} {
+		_, _, _, ok := parseBlockKey(key)
+		require.False(t, ok, key)
+	}
+}
@@ -17,9 +17,12 @@
 package cmd
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -38,7 +41,7 @@ func cmdInfo() *cli.Command {
 		Action:    info,
 		Category:  "INSPECTOR",
 		Usage:     "Show internal information of a path or inode",
-		ArgsUsage: "PATH/INODE",
+		ArgsUsage: "PATH/INODE ...",
 		Description: `
 It is used to inspect internal metadata values of the target file.
 
@@ -48,7 +51,13 @@ $ juicefs info /mnt/jfs/foo
 
 # Check an inode
 $ cd /mnt/jfs
-$ juicefs info -i 100`,
+$ juicefs info -i 100
+
+# Check the layout of all the files in a directory, with the cache and storage of the objects, in JSON format
+$ juicefs info --walk --layout --json /mnt/jfs/dir
+
+# Check the files in a list (one path per line, "-" to read from stdin)
+$ find /mnt/jfs/dir -name "*.parquet" | juicefs info --from-file - --json`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:    "inode",
@@ -68,13 +77,114 @@ $ juicefs info -i 100`,
 				Name:  "raw",
 				Usage: "show internal raw information",
 			},
+			&cli.StringFlag{
+				Name:  "from-file",
+				Usage: `read the paths (one per line) from the file, "-" for stdin`,
+			},
+			&cli.BoolFlag{
+				Name:  "walk",
+				Usage: "show information of all the files under the directories",
+			},
+			&cli.BoolFlag{
+				Name:  "layout",
+				Usage: "show both the slices and objects of files, whether the objects are cached, and their sizes in the object storage (it requests the object storage for every object)",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the information in JSON format",
+			},
 		},
 	}
 }
 
+// infoResult is the information of a path in JSON output.
+type infoResult struct {
+	Path string
+	*vfs.InfoResponse
+	CachedBytes      uint64  `json:",omitempty"` // the data of the file in the local cache
+	StoredBytes      int64   `json:",omitempty"` // the size of the objects in the object storage
+	CompressionRatio float64 `json:",omitempty"` // the size of the original data / the size in the object storage
+}
+
+func newInfoResult(path string, resp *vfs.InfoResponse) *infoResult {
+	r := &infoResult{Path: path, InfoResponse: resp}
+	var size int64
+	for _, o := range resp.Objects {
+		if o.Cached {
+			r.CachedBytes += uint64(o.Len)
+		}
+		if o.Stored > 0 {
+			r.StoredBytes += o.Stored
+			size += int64(o.Size)
+		}
+	}
+	if r.StoredBytes > 0 {
+		r.CompressionRatio = float64(size) / float64(r.StoredBytes)
+	}
+	return r
+}
+
+// infoPaths returns the paths in arguments and the file list, and the files under them if walk is enabled.
+func infoPaths(ctx *cli.Context) []string {
+	paths := ctx.Args().Slice()
+	if name := ctx.String("from-file"); name != "" {
+		var r io.Reader = os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				logger.Fatalf("open %s: %s", name, err)
+			}
+			defer f.Close()
+			r = f
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if p := strings.TrimSpace(scanner.Text()); p != "" {
+				paths = append(paths, p)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			logger.Fatalf("read %s: %s", name, err)
+		}
+	}
+	if !ctx.Bool("walk") || ctx.Bool("inode") {
+		return paths
+	}
+	var result []string
+	for _, p := range paths {
+		result = append(result, p)
+		_ = filepath.WalkDir(p, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Warnf("walk %s: %s", fp, err)
+				return nil
+			}
+			if fp == p {
+				return nil
+			}
+			if vfs.IsSpecialName(d.Name()) || d.Name() == meta.TrashName {
+				if ino, err := utils.GetFileInode(fp); err == nil && (vfs.IsSpecialNode(meta.Ino(ino)) || meta.Ino(ino).IsTrash()) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			if d.Type().IsRegular() {
+				result = append(result, fp)
+			}
+			return nil
+		})
+	}
+	return result
+}
+
 func info(ctx *cli.Context) error {
-	setup0(ctx, 1, 0)
-	var recursive, strict, raw uint8
+	if ctx.IsSet("from-file") {
+		setup0(ctx, 0, 0)
+	} else {
+		setup0(ctx, 1, 0)
+	}
+	var recursive, strict, raw, layout uint8
 	if ctx.Bool("recursive") {
 		recursive = 1
 	}
@@ -84,9 +194,13 @@ func info(ctx *cli.Context) error {
 	if ctx.Bool("raw") {
 		raw = 1
 	}
-	for i := 0; i < ctx.Args().Len(); i++ {
-		progress := utils.NewProgress(recursive == 0) // only show progress for recursive info
-		path := ctx.Args().Get(i)
+	if ctx.Bool("layout") {
+		layout = 1
+	}
+	asJson := ctx.Bool("json")
+	var results []*infoResult
+	for _, path := range infoPaths(ctx) {
+		progress := utils.NewProgress(recursive == 0 || asJson) // only show progress for recursive info
 		dspin := progress.AddDoubleSpinner(path)
 		var d string
 		var inode uint64
@@ -114,13 +228,14 @@ func info(ctx *cli.Context) error {
 			continue
 		}
 
-		wb := utils.NewBuffer(8 + 11)
+		wb := utils.NewBuffer(8 + 12)
 		wb.Put32(meta.InfoV2)
-		wb.Put32(11)
+		wb.Put32(12)
 		wb.Put64(inode)
 		wb.Put8(recursive)
 		wb.Put8(raw)
 		wb.Put8(strict)
+		wb.Put8(layout)
 		_, err = f.Write(wb.Bytes())
 		if err != nil {
 			logger.Fatalf("write message: %s", err)
@@ -128,7 +243,7 @@ func info(ctx *cli.Context) error {
 		data, errno := readProgress(f, func(count, size uint64) {
 			dspin.SetCurrent(int64(count), int64(size))
 		})
-		if errno == syscall.EINVAL {
+		if errno == syscall.EINVAL && !asJson {
 			legacyInfo(d, path, inode, recursive, raw)
 			continue
 		} else if errno != 0 {
@@ -140,97 +255,123 @@ func info(ctx *cli.Context) error {
 		var resp vfs.InfoResponse
 		err = json.Unmarshal(data, &resp)
 		_ = f.Close()
+		if asJson {
+			if err != nil {
+				resp = vfs.InfoResponse{Ino: meta.Ino(inode), Failed: true, Reason: err.Error()}
+			}
+			results = append(results, newInfoResult(path, &resp))
+			continue
+		}
 		if err == nil && resp.Failed {
 			err = errors.New(resp.Reason)
 		}
 		if err != nil {
 			logger.Fatalf("info: %s", err)
 		}
-		fmt.Println(path, ":")
-		fmt.Printf("  inode: %d\n", resp.Ino)
-		fmt.Printf("  files: %d\n", resp.Summary.Files)
-		fmt.Printf("   dirs: %d\n", resp.Summary.Dirs)
-		fmt.Printf(" length: %s\n", utils.FormatBytes(resp.Summary.Length))
-		fmt.Printf("   size: %s\n", utils.FormatBytes(resp.Summary.Size))
-		switch len(resp.Paths) {
-		case 0:
-			fmt.Printf("   path: %s\n", "unknown")
-		case 1:
-			fmt.Printf("   path: %s\n", resp.Paths[0])
-		default:
-			fmt.Printf("  paths:\n")
-			for _, p := range resp.Paths {
-				fmt.Printf("\t%s\n", p)
-			}
+		printInfo(path, newInfoResult(path, &resp), layout == 1)
+	}
+	if asJson {
+		printJson(results)
+	}
+	return nil
+}
+
+func printInfo(path string, resp *infoResult, layout bool) {
+	fmt.Println(path, ":")
+	fmt.Printf("  inode: %d\n", resp.Ino)
+	fmt.Printf("  files: %d\n", resp.Summary.Files)
+	fmt.Printf("   dirs: %d\n", resp.Summary.Dirs)
+	fmt.Printf(" length: %s\n", utils.FormatBytes(resp.Summary.Length))
+	fmt.Printf("   size: %s\n", utils.FormatBytes(resp.Summary.Size))
+	switch len(resp.Paths) {
+	case 0:
+		fmt.Printf("   path: %s\n", "unknown")
+	case 1:
+		fmt.Printf("   path: %s\n", resp.Paths[0])
+	default:
+		fmt.Printf("  paths:\n")
+		for _, p := range resp.Paths {
+			fmt.Printf("\t%s\n", p)
 		}
-		if len(resp.Chunks) > 0 {
-			fmt.Println(" chunks:")
-			results := make([][]string, 0, 1+len(resp.Chunks))
-			results = append(results, []string{"chunkIndex", "sliceId", "size", "offset", "length"})
-			for _, c := range resp.Chunks {
-				results = append(results, []string{
-					strconv.FormatUint(c.ChunkIndex, 10),
-					strconv.FormatUint(c.Id, 10),
-					strconv.FormatUint(uint64(c.Size), 10),
-					strconv.FormatUint(uint64(c.Off), 10),
-					strconv.FormatUint(uint64(c.Len), 10),
-				})
-			}
-			printResult(results, -1, false)
+	}
+	if layout && resp.Summary.Files == 1 && resp.Summary.Dirs == 0 {
+		fmt.Printf(" cached: %s\n", utils.FormatBytes(resp.CachedBytes))
+		fmt.Printf(" stored: %s (compression ratio %.2f)\n", utils.FormatBytes(uint64(resp.StoredBytes)), resp.CompressionRatio)
+	}
+	if len(resp.Chunks) > 0 {
+		fmt.Println(" chunks:")
+		results := make([][]string, 0, 1+len(resp.Chunks))
+		results = append(results, []string{"chunkIndex", "sliceId", "size", "offset", "length"})
+		for _, c := range resp.Chunks {
+			results = append(results, []string{
+				strconv.FormatUint(c.ChunkIndex, 10),
+				strconv.FormatUint(c.Id, 10),
+				strconv.FormatUint(uint64(c.Size), 10),
+				strconv.FormatUint(uint64(c.Off), 10),
+				strconv.FormatUint(uint64(c.Len), 10),
+			})
 		}
-		if len(resp.Objects) > 0 {
-			fmt.Println(" objects:")
-			results := make([][]string, 0, 1+len(resp.Objects))
-			results = append(results, []string{"chunkIndex", "objectName", "size", "offset", "length", "pos"})
-			var chunkOffset, lastChunk uint64
-			for _, o := range resp.Objects {
-				if lastChunk != o.ChunkIndex {
-					chunkOffset = 0
-				}
-				lastChunk = o.ChunkIndex
-				results = append(results, []string{
-					strconv.FormatUint(o.ChunkIndex, 10),
-					o.Key,
-					strconv.FormatUint(uint64(o.Size), 10),
-					strconv.FormatUint(uint64(o.Off), 10),
-					strconv.FormatUint(uint64(o.Len), 10),
-					strconv.FormatUint(chunkOffset+o.ChunkIndex*meta.ChunkSize, 10),
-				})
-				chunkOffset += uint64(o.Len)
-			}
-			printResult(results, 1, false)
+		printResult(results, -1, false)
+	}
+	if len(resp.Objects) > 0 {
+		fmt.Println(" objects:")
+		results := make([][]string, 0, 1+len(resp.Objects))
+		header := []string{"chunkIndex", "objectName", "size", "offset", "length", "pos"}
+		if layout {
+			header = append(header, "cached", "stored")
 		}
-		if len(resp.FLocks) > 0 {
-			fmt.Println(" flocks:")
-			results := make([][]string, 0, 1+len(resp.FLocks))
-			results = append(results, []string{"Sid", "Owner", "Type"})
-			for _, l := range resp.FLocks {
-				results = append(results, []string{
-					strconv.FormatUint(l.Sid, 10),
-					strconv.FormatUint(l.Owner, 10),
-					l.Type,
-				})
+		results = append(results, header)
+		var chunkOffset, lastChunk uint64
+		for _, o := range resp.Objects {
+			if lastChunk != o.ChunkIndex {
+				chunkOffset = 0
 			}
-			printResult(results, 0, false)
-		}
-		if len(resp.PLocks) > 0 {
-			fmt.Println(" plocks:")
-			results := make([][]string, 0, 1+len(resp.PLocks))
-			results = append(results, []string{"Sid", "Owner", "Type", "Pid", "Start", "End"})
-			for _, l := range resp.PLocks {
-				results = append(results, []string{
-					strconv.FormatUint(l.Sid, 10),
-					strconv.FormatUint(l.Owner, 10),
-					ltypeToString(l.Type),
-					strconv.FormatUint(uint64(l.Pid), 10),
-					strconv.FormatUint(l.Start, 10),
-					strconv.FormatUint(l.End, 10),
-				})
+			lastChunk = o.ChunkIndex
+			row := []string{
+				strconv.FormatUint(o.ChunkIndex, 10),
+				o.Key,
+				strconv.FormatUint(uint64(o.Size), 10),
+				strconv.FormatUint(uint64(o.Off), 10),
+				strconv.FormatUint(uint64(o.Len), 10),
+				strconv.FormatUint(chunkOffset+o.ChunkIndex*meta.ChunkSize, 10),
+			}
+			if layout {
+				row = append(row, strconv.FormatBool(o.Cached), strconv.FormatInt(o.Stored, 10))
 			}
-			printResult(results, 0, false)
+			results = append(results, row)
+			chunkOffset += uint64(o.Len)
 		}
+		printResult(results, 1, false)
+	}
+	if len(resp.FLocks) > 0 {
+		fmt.Println(" flocks:")
+		results := make([][]string, 0, 1+len(resp.FLocks))
+		results = append(results, []string{"Sid", "Owner", "Type"})
+		for _, l := range resp.FLocks {
+			results = append(results, []string{
+				strconv.FormatUint(l.Sid, 10),
+				strconv.FormatUint(l.Owner, 10),
+				l.Type,
+			})
+		}
+		printResult(results, 0, false)
+	}
+	if len(resp.PLocks) > 0 {
+		fmt.Println(" plocks:")
+		results := make([][]string, 0, 1+len(resp.PLocks))
+		results = append(results, []string{"Sid", "Owner", "Type", "Pid", "Start", "End"})
+		for _, l := range resp.PLocks {
+			results = append(results, []string{
+				strconv.FormatUint(l.Sid, 10),
+				strconv.FormatUint(l.Owner, 10),
+				ltypeToString(l.Type),
+				strconv.FormatUint(uint64(l.Pid), 10),
+				strconv.FormatUint(l.Start, 10),
+				strconv.FormatUint(l.End, 10),
+			})
+		}
+		printResult(results, 0, false)
 	}
-	return nil
 }
 
 func ltypeToString(t uint32) string {
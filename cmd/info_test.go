@@ -17,6 +17,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -62,3 +63,36 @@ func TestInfo(t *testing.T) {
 	answer = replacer.Replace(answer)
 	require.Equal(t, answer, res)
 }
+
+func TestInfoJson(t *testing.T) {
+	mountTemp(t, nil, nil, nil)
+	defer umountTemp(t)
+
+	dir := fmt.Sprintf("%s/dir2", testMountPoint)
+	if err := os.MkdirAll(dir+"/sub", 0777); err != nil {
+		t.Fatalf("mkdirAll failed: %s", err)
+	}
+	for _, name := range []string{"f1", "f2", "sub/f3"} {
+		if err := os.WriteFile(dir+"/"+name, []byte(strings.Repeat("test", 1000)), 0644); err != nil {
+			t.Fatalf("write file failed: %s", err)
+		}
+	}
+	out, err := getStdout([]string{"", "info", "--walk", "--layout", "--json", dir})
+	if err != nil {
+		t.Fatalf("info failed: %s", err)
+	}
+	var results []*infoResult
+	if err = json.Unmarshal(out, &results); err != nil {
+		t.Fatalf("unmarshal %s: %s", out, err)
+	}
+	require.Len(t, results, 4)
+	require.Equal(t, dir, results[0].Path)
+	for _, r := range results[1:] {
+		require.False(t, r.Failed, r.Reason)
+		require.Equal(t, uint64(1), r.Summary.Files)
+		require.Len(t, r.Chunks, 1)
+		require.Len(t, r.Objects, 1)
+		require.Greater(t, r.StoredBytes, int64(0))
+		require.Greater(t, r.CompressionRatio, 0.0)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+
+	"github.com/urfave/cli/v2"
+)
+
+func cmdLocks() *cli.Command {
+	return &cli.Command{
+		Name:      "locks",
+		Action:    listLocks,
+		Category:  "INSPECTOR",
+		Usage:     "List the flock and POSIX locks held by the clients",
+		ArgsUsage: "META-URL [PATH]",
+		Description: `
+It lists the locks held by all the active sessions, with the client holding each of them, to find out
+which one blocks the applications. PATH is relative to the root of the volume, only the locks of it (or
+the files under it for a directory) are listed if it's specified.
+
+NOTE: The waiting lock requests are not listed.
+
+Examples:
+$ juicefs locks redis://localhost
+$ juicefs locks redis://localhost /logs/app.log --json`,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print locks in JSON format",
+			},
+		},
+	}
+}
+
+type lockEntry struct {
+	Inode      meta.Ino
+	Paths      []string `json:",omitempty"`
+	Kind       string   // flock or plock
+	Type       string
+	Start      uint64 `json:",omitempty"`
+	End        uint64 `json:",omitempty"`
+	Pid        uint32 `json:",omitempty"` // of the process holding the POSIX lock on the client
+	Owner      uint64
+	Sid        uint64
+	HostName   string
+	MountPoint string
+}
+
+func lookupVolumePath(m meta.Meta, p string) (meta.Ino, *meta.Attr, error) {
+	ctx := meta.Background()
+	ino, attr := meta.RootInode, &meta.Attr{}
+	if st := m.GetAttr(ctx, ino, attr); st != 0 {
+		return 0, nil, st
+	}
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if st := m.Lookup(ctx, ino, name, &ino, attr, false); st != 0 {
+			return 0, nil, fmt.Errorf("lookup %s: %s", name, st)
+		}
+	}
+	return ino, attr, nil
+}
+
+func listLocks(c *cli.Context) error {
+	setup0(c, 1, 2)
+	m := openSessionMeta(c)
+	var prefix string
+	var target meta.Ino
+	if c.NArg() > 1 {
+		p := path.Clean("/" + c.Args().Get(1))
+		ino, attr, err := lookupVolumePath(m, p)
+		if err != nil {
+			logger.Fatalf("resolve %s: %s", p, err)
+		}
+		target = ino
+		if attr.Typ == meta.TypeDirectory {
+			prefix = strings.TrimSuffix(p, "/") + "/"
+		}
+	}
+	sessions, err := m.ListSessions()
+	if err != nil {
+		logger.Fatalf("list sessions: %s", err)
+	}
+	ctx := meta.Background()
+	paths := make(map[meta.Ino][]string)
+	getPaths := func(ino meta.Ino) []string {
+		if ps, ok := paths[ino]; ok {
+			return ps
+		}
+		ps := m.GetPaths(ctx, ino)
+		paths[ino] = ps
+		return ps
+	}
+	matched := func(ino meta.Ino) bool {
+		if target == 0 || ino == target {
+			return true
+		}
+		if prefix == "" {
+			return false
+		}
+		for _, p := range getPaths(ino) {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	locks := make([]lockEntry, 0)
+	for _, s := range sessions {
+		d, err := m.GetSession(s.Sid, true)
+		if err != nil {
+			logger.Warnf("get session %d: %s", s.Sid, err)
+			continue
+		}
+		for _, l := range d.Flocks {
+			if matched(l.Inode) {
+				locks = append(locks, lockEntry{Inode: l.Inode, Kind: "flock", Type: l.Ltype, Owner: l.Owner,
+					Sid: d.Sid, HostName: d.HostName, MountPoint: d.MountPoint})
+			}
+		}
+		for _, l := range d.Plocks {
+			if !matched(l.Inode) {
+				continue
+			}
+			for _, r := range l.Records {
+				locks = append(locks, lockEntry{Inode: l.Inode, Kind: "plock", Type: ltypeToString(r.Type),
+					Start: r.Start, End: r.End, Pid: r.Pid, Owner: l.Owner, Sid: d.Sid, HostName: d.HostName,
+					MountPoint: d.MountPoint})
+			}
+		}
+	}
+	sort.SliceStable(locks, func(i, j int) bool { return locks[i].Inode < locks[j].Inode })
+	for i := range locks {
+		locks[i].Paths = getPaths(locks[i].Inode)
+	}
+	if c.Bool("json") {
+		printJson(locks)
+		return nil
+	}
+	if len(locks) == 0 {
+		fmt.Println("No lock is held")
+		return nil
+	}
+	result := [][]string{{"Inode", "Path", "Kind", "Type", "Range", "PID", "Owner", "SID", "Host", "MountPoint"}}
+	for _, l := range locks {
+		rng, pid := "-", "-"
+		if l.Kind == "plock" {
+			rng = fmt.Sprintf("%d-%d", l.Start, l.End)
+			pid = strconv.FormatUint(uint64(l.Pid), 10)
+		}
+		result = append(result, []string{
+			l.Inode.String(),
+			strings.Join(l.Paths, ","),
+			l.Kind,
+			l.Type,
+			rng,
+			pid,
+			strconv.FormatUint(l.Owner, 10),
+			strconv.FormatUint(l.Sid, 10),
+			l.HostName,
+			l.MountPoint,
+		})
+	}
+	printResult(result, 2, false)
+	return nil
+}
@@ -60,21 +60,28 @@ func Main(args []string) error {
 			cmdFormat(),
 			cmdConfig(),
 			cmdQuota(),
+			cmdSession(),
 			cmdDestroy(),
 			cmdGC(),
+			cmdBackfill(),
 			cmdFsck(),
 			cmdRestore(),
+			cmdTrash(),
 			cmdDump(),
 			cmdLoad(),
 			cmdVersion(),
 			cmdStatus(),
 			cmdStats(),
 			cmdProfile(),
+			cmdTop(),
 			cmdInfo(),
+			cmdLocks(),
+			cmdWatch(),
 			cmdMount(),
 			cmdUmount(),
 			cmdGateway(),
 			cmdWebDav(),
+			cmdSFTP(),
 			cmdBench(),
 			cmdObjbench(),
 			cmdMdtest(),
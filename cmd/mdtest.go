@@ -221,6 +221,7 @@ func initForMdtest(c *cli.Context, mp string, metaUrl string) *fs.FileSystem {
 	conf.EntryTimeout = utils.Duration(c.String("entry-cache"))
 	conf.DirEntryTimeout = utils.Duration(c.String("dir-entry-cache"))
 
+	initTracing(c, mp, format.Name)
 	metricsAddr := exposeMetrics(c, registerer, registry)
 	m.InitMetrics(registerer)
 	vfs.InitMetrics(registerer)
@@ -37,8 +37,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/events"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/metric"
 	"github.com/juicedata/juicefs/pkg/usage"
@@ -96,6 +98,12 @@ func exposeMetrics(c *cli.Context, registerer prometheus.Registerer, registry *p
 			EnableOpenMetrics: true,
 		},
 	))
+	// for `juicefs debug` to collect from the debug agent
+	http.HandleFunc("/debug/object-errors", func(w http.ResponseWriter, r *http.Request) {
+		for _, line := range chunk.RecentErrors() {
+			_, _ = fmt.Fprintln(w, line)
+		}
+	})
 	registerer.MustRegister(collectors.NewBuildInfoCollector())
 
 	// If not set metrics addr,the port will be auto set
@@ -218,13 +226,15 @@ func cacheDirPathToAbs(c *cli.Context) {
 		}
 	}
 
-	if rpAcLog := c.String("access-log"); rpAcLog != "" {
-		ap, err := filepath.Abs(rpAcLog)
-		if err == nil && ap != rpAcLog {
-			for i, a := range os.Args {
-				if a == rpAcLog || a == "--access-log="+rpAcLog {
-					os.Args[i] = a[:len(a)-len(rpAcLog)] + ap
-					break
+	for _, name := range []string{"access-log", "settings-file"} {
+		if rp := c.String(name); rp != "" {
+			ap, err := filepath.Abs(rp)
+			if err == nil && ap != rp {
+				for i, a := range os.Args {
+					if a == rp || a == "--"+name+"="+rp {
+						os.Args[i] = a[:len(a)-len(rp)] + ap
+						break
+					}
 				}
 			}
 		}
@@ -246,7 +256,7 @@ func daemonRun(c *cli.Context, addr string, vfsConf *vfs.Config) {
 }
 
 func expandPathForEmbedded(addr string) string {
-	embeddedSchemes := []string{"sqlite3://", "badger://"}
+	embeddedSchemes := []string{"sqlite3://", "badger://", "pebble://"}
 	for _, es := range embeddedSchemes {
 		if strings.HasPrefix(addr, es) {
 			path := addr[len(es):]
@@ -277,13 +287,37 @@ func getVfsConf(c *cli.Context, metaConf *meta.Config, format *meta.Format, chun
 		Chunk:           chunkConf,
 		BackupMeta:      utils.Duration(c.String("backup-meta")),
 		BackupSkipTrash: c.Bool("backup-skip-trash"),
+		BackupMetaKeep:  c.Int("backup-meta-keep"),
+		ScrubInterval:   utils.Duration(c.String("scrub-interval")),
+		ScrubLimit:      utils.ParseMbps(c, "scrub-limit") * 1e6 / 8,
 		Port:            &vfs.Port{DebugAgent: debugAgent, PyroscopeAddr: c.String("pyroscope")},
 		PrefixInternal:  c.Bool("prefix-internal"),
 		Pid:             os.Getpid(),
 		PPid:            os.Getppid(),
 		UMask:           0xFFFF,
 		HideInternal:    c.Bool("hide-internal"),
+		LogWrites:       c.Bool("log-writes"),
+	}
+	if c.IsSet("cache-max-file-size") {
+		cfg.CacheMaxFileSize = utils.ParseBytes(c, "cache-max-file-size", 'M')
+	}
+	for _, p := range strings.Split(c.String("cache-prefixes"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.CachePrefixes = append(cfg.CachePrefixes, "/"+strings.Trim(p, "/"))
+		}
+	}
+	if c.IsSet("max-read-bw") || c.IsSet("max-write-bw") || c.IsSet("max-iops") {
+		cfg.QoS = &vfs.QoS{
+			ReadBandwidth:  utils.ParseMbps(c, "max-read-bw") * 1e6 / 8,
+			WriteBandwidth: utils.ParseMbps(c, "max-write-bw") * 1e6 / 8,
+			IOPS:           c.Int64("max-iops"),
+			Priority:       c.String("io-priority"),
+		}
+		if p := cfg.QoS.Priority; p != vfs.PriorityNormal && p != vfs.PriorityLow {
+			logger.Fatalf("invalid io-priority %s, it should be %s or %s", p, vfs.PriorityNormal, vfs.PriorityLow)
+		}
 	}
+	cfg.PriorityDir = getPriorityDir()
 
 	if c.IsSet("umask") {
 		umask, err := strconv.ParseUint(c.String("umask"), 8, 16)
@@ -297,6 +331,9 @@ func getVfsConf(c *cli.Context, metaConf *meta.Config, format *meta.Format, chun
 	if !skip_check && cfg.BackupMeta > 0 && cfg.BackupMeta < time.Minute*5 {
 		logger.Fatalf("backup-meta should not be less than 5 minutes: %s", cfg.BackupMeta)
 	}
+	if cfg.BackupMetaKeep < 0 {
+		logger.Fatalf("backup-meta-keep should not be negative: %d", cfg.BackupMetaKeep)
+	}
 	return cfg
 }
 
@@ -324,6 +361,10 @@ func getMetaConf(c *cli.Context, mp string, readOnly bool) *meta.Config {
 	conf.SkipDirNlink = c.Int("skip-dir-nlink")
 	conf.ReadOnly = readOnly
 	conf.NoBGJob = c.Bool("no-bgjob")
+	conf.AsyncDeleteRate = c.Int("async-delete-rate")
+	conf.CompactWindow = c.String("compact-window")
+	conf.MaxCompactions = c.Int("max-compactions")
+	conf.CompactLimit = c.Int64("compact-limit")
 	conf.OpenCache = utils.Duration(c.String("open-cache"))
 	conf.OpenCacheLimit = c.Uint64("open-cache-limit")
 	conf.Heartbeat = utils.Duration(c.String("heartbeat"))
@@ -333,6 +374,8 @@ func getMetaConf(c *cli.Context, mp string, readOnly bool) *meta.Config {
 	conf.Sid, _ = strconv.ParseUint(os.Getenv("_JFS_META_SID"), 10, 64)
 	conf.SortDir = c.Bool("sort-dir")
 	conf.FastStatfs = c.Bool("fast-statfs")
+	conf.SlowOpThreshold = utils.Duration(c.String("slow-op-threshold"))
+	conf.SlowOpLog = c.String("slow-op-log")
 
 	atimeMode := c.String("atime-mode")
 	if atimeMode != meta.RelAtime && atimeMode != meta.StrictAtime && atimeMode != meta.NoAtime {
@@ -353,6 +396,39 @@ func getMetaConf(c *cli.Context, mp string, readOnly bool) *meta.Config {
 	return conf
 }
 
+// joinCacheGroup advertises the address of the store in the session (so it should be called before NewSession),
+// and keeps the members of the cache group updated with the sessions of the others.
+func joinCacheGroup(m meta.Meta, metaConf *meta.Config, store chunk.ChunkStore, group string) {
+	gm, ok := store.(chunk.GroupMember)
+	if !ok || gm.GroupAddr() == "" {
+		return
+	}
+	metaConf.CacheGroup = group
+	metaConf.CacheAddr = gm.GroupAddr()
+	go func() {
+		for {
+			if sessions, err := m.ListSessions(); err == nil {
+				gm.UpdateMembers(groupMembers(sessions, group))
+			} else {
+				logger.Warnf("List sessions for cache group %s: %s", group, err)
+			}
+			utils.SleepWithJitter(time.Second * 30)
+		}
+	}()
+}
+
+// groupMembers returns the addresses of the alive sessions in the cache group.
+func groupMembers(sessions []*meta.Session, group string) []string {
+	var addrs []string
+	now := time.Now()
+	for _, s := range sessions {
+		if s.CacheGroup == group && s.CacheAddr != "" && s.Expire.After(now) {
+			addrs = append(addrs, s.CacheAddr)
+		}
+	}
+	return addrs
+}
+
 func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 	cm, err := strconv.ParseUint(c.String("cache-mode"), 8, 32)
 	if err != nil {
@@ -360,9 +436,11 @@ func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 		cm = 0600
 	}
 	chunkConf := &chunk.Config{
-		BlockSize:  format.BlockSize * 1024,
-		Compress:   format.Compression,
-		HashPrefix: format.HashPrefix,
+		BlockSize:     format.BlockSize * 1024,
+		Compress:      format.Compression,
+		CompressDict:  format.CompressDict,
+		HashPrefix:    format.HashPrefix,
+		BlockChecksum: format.BlockChecksum,
 
 		GetTimeout:    utils.Duration(c.String("get-timeout")),
 		PutTimeout:    utils.Duration(c.String("put-timeout")),
@@ -374,6 +452,9 @@ func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 		BufferSize:    utils.ParseBytes(c, "buffer-size", 'M'),
 		UploadLimit:   utils.ParseMbps(c, "upload-limit") * 1e6 / 8,
 		DownloadLimit: utils.ParseMbps(c, "download-limit") * 1e6 / 8,
+		PutLimit:      c.Int64("put-limit"),
+		GetLimit:      c.Int64("get-limit"),
+		ListLimit:     c.Int64("list-limit"),
 		UploadDelay:   utils.Duration(c.String("upload-delay")),
 		UploadHours:   c.String("upload-hours"),
 
@@ -388,6 +469,18 @@ func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 		CacheEviction:     c.String("cache-eviction"),
 		CacheScanInterval: utils.Duration(c.String("cache-scan-interval")),
 		CacheExpire:       utils.Duration(c.String("cache-expire")),
+		CacheTiers:        parseCacheTiers(c.String("cache-tiers")),
+		CacheDev:          c.String("cache-dev"),
+		CacheDevFormat:    c.Bool("cache-dev-format"),
+		CacheEncrypt:      c.Bool("cache-encrypt"),
+		CacheGroup:        c.String("cache-group"),
+		GroupListen:       c.String("group-listen"),
+		GroupSecret:       c.String("group-secret"),
+		GroupCert:         c.String("group-cert"),
+		GroupKey:          c.String("group-key"),
+		GroupCA:           c.String("group-ca"),
+		WritebackDurable:  c.Bool("writeback-durable"),
+		FsyncUpload:       c.Bool("fsync-upload"),
 		OSCache:           os.Getenv("JFS_DROP_OSCACHE") == "",
 		AutoCreate:        true,
 	}
@@ -403,11 +496,61 @@ func getChunkConf(c *cli.Context, format *meta.Format) *chunk.Config {
 	if chunkConf.DownloadLimit == 0 {
 		chunkConf.DownloadLimit = format.DownloadLimit * 1e6 / 8
 	}
+	if chunkConf.CacheEncrypt && chunkConf.Writeback {
+		if enc, err := newEncryptor(*format); err != nil {
+			logger.Warnf("Load data encryption: %s", err)
+		} else {
+			chunkConf.KeyEncryptor = enc
+		}
+	}
 	chunkConf.SelfCheck(format.UUID)
 	return chunkConf
 }
 
+// parseCacheTiers parses the cache tiers in the format of DIRS=SIZE[:EVICTION], separated by comma.
+func parseCacheTiers(s string) []chunk.CacheTier {
+	var tiers []chunk.CacheTier
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		p := strings.LastIndex(t, "=")
+		if p <= 0 {
+			logger.Fatalf("Invalid cache tier %q, it should be DIRS=SIZE[:EVICTION]", t)
+		}
+		tier := chunk.CacheTier{Dir: t[:p]}
+		size := t[p+1:]
+		if i := strings.Index(size, ":"); i >= 0 {
+			size, tier.Eviction = size[:i], size[i+1:]
+		}
+		if size == "" {
+			logger.Fatalf("Invalid cache tier %q, it should be DIRS=SIZE[:EVICTION]", t)
+		}
+		if tier.Size = utils.ParseBytesStr("cache-tiers", size, 'M'); tier.Size == 0 {
+			logger.Fatalf("Size of cache tier %s should be greater than 0", tier.Dir)
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers
+}
+
+// initTracing exports the traces of the operations if the endpoint is set.
+func initTracing(c *cli.Context, mp, name string) {
+	endpoint := c.String("tracing-endpoint")
+	if endpoint == "" {
+		return
+	}
+	h, _ := os.Hostname()
+	err := utils.InitTracing(endpoint, "juicefs", c.Float64("tracing-sample-ratio"), attribute.String("host.name", h),
+		attribute.String("juicefs.mountpoint", mp), attribute.String("juicefs.volume", name), attribute.String("service.version", version.Version()))
+	if err != nil {
+		logger.Fatalf("tracing: %s", err)
+	}
+	logger.Infof("Export traces to %s, sample ratio: %g", endpoint, c.Float64("tracing-sample-ratio"))
+}
+
 func initBackgroundTasks(c *cli.Context, vfsConf *vfs.Config, metaConf *meta.Config, m meta.Meta, blob object.ObjectStorage, registerer prometheus.Registerer, registry *prometheus.Registry) {
+	initTracing(c, vfsConf.Meta.MountPoint, vfsConf.Format.Name)
 	metricsAddr := exposeMetrics(c, registerer, registry)
 	m.InitMetrics(registerer)
 	vfs.InitMetrics(registerer)
@@ -421,10 +564,23 @@ func initBackgroundTasks(c *cli.Context, vfsConf *vfs.Config, metaConf *meta.Con
 	if !metaConf.ReadOnly && !metaConf.NoBGJob && vfsConf.BackupMeta > 0 {
 		registerer.MustRegister(vfs.LastBackupTimeG)
 		registerer.MustRegister(vfs.LastBackupDurationG)
-		go vfs.Backup(m, blob, vfsConf.BackupMeta, vfsConf.BackupSkipTrash)
+		go vfs.Backup(m, blob, vfsConf.BackupMeta, vfsConf.BackupSkipTrash, vfsConf.BackupMetaKeep)
 	} else {
 		logger.Warnf("Metadata backup is disabled")
 	}
+	if c.IsSet("events-sink") && !metaConf.ReadOnly {
+		sink, err := events.NewSink(c.String("events-sink"))
+		if err != nil {
+			logger.Fatalf("events sink: %s", err)
+		}
+		var prefixes []string
+		if c.IsSet("events-prefix") {
+			prefixes = strings.Split(c.String("events-prefix"), ",")
+		}
+		events.InitMetrics(registerer)
+		events.NewPublisher(m, sink, &events.Config{Prefixes: prefixes, BatchSize: c.Int("events-batch")})
+		logger.Infof("Publish metadata changes to %s", sink)
+	}
 	if !c.Bool("no-usage-report") {
 		go usage.ReportUsage(m, version.Version())
 	}
@@ -439,6 +595,13 @@ func (h *storageHolder) Shutdown() {
 	object.Shutdown(h.ObjectStorage)
 }
 
+func (h *storageHolder) Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error) {
+	if r, ok := h.ObjectStorage.(object.SupportRepair); ok {
+		return r.Repair(ctx, key, verify)
+	}
+	return 0, utils.ENOTSUP
+}
+
 func NewReloadableStorage(format *meta.Format, cli meta.Meta, patch func(*meta.Format)) (object.ObjectStorage, error) {
 	if patch != nil {
 		patch(format)
@@ -456,7 +619,7 @@ func NewReloadableStorage(format *meta.Format, cli meta.Meta, patch func(*meta.F
 			patch(new)
 		}
 		old := &holder.fmt
-		if new.Storage != old.Storage || new.Bucket != old.Bucket || new.AccessKey != old.AccessKey || new.SecretKey != old.SecretKey || new.SessionToken != old.SessionToken || new.StorageClass != old.StorageClass {
+		if new.Storage != old.Storage || new.Bucket != old.Bucket || new.AccessKey != old.AccessKey || new.SecretKey != old.SecretKey || new.SessionToken != old.SessionToken || new.StorageClass != old.StorageClass || len(new.EncryptDataKeys) != len(old.EncryptDataKeys) {
 			logger.Infof("found new configuration: storage=%s bucket=%s ak=%s storageClass=%s", new.Storage, new.Bucket, new.AccessKey, new.StorageClass)
 
 			newBlob, err := createStorage(*new)
@@ -525,6 +688,15 @@ func getDefaultLogDir() string {
 	return defaultLogDir
 }
 
+// getPriorityDir returns the directory only accessible by the user, where the mounts of normal priority announce
+// they are busy to the ones of low priority.
+func getPriorityDir() string {
+	if runtime.GOOS == "linux" && os.Getuid() == 0 {
+		return "/var/run/juicefs/io-priority"
+	}
+	return path.Join(getDefaultLogDir(), "io-priority")
+}
+
 func mount(c *cli.Context) error {
 	setup(c, 2)
 	addr := c.Args().Get(0)
@@ -577,6 +749,8 @@ func mount(c *cli.Context) error {
 	metaConf := getMetaConf(c, mp, c.Bool("read-only") || utils.StringContains(strings.Split(c.String("o"), ","), "ro"))
 	if runtime.GOOS == "windows" {
 		metaConf.CaseInsensi = !c.Bool("case-sensitive")
+	} else {
+		metaConf.CaseInsensi = c.Bool("case-insensitive")
 	}
 	// stage 0: check the connection to fail fast
 	// stage 2: need the volume name to check if it's already mounted
@@ -655,6 +829,7 @@ func mount(c *cli.Context) error {
 
 	store := chunk.NewCachedStore(blob, *chunkConf, registerer)
 	registerMetaMsg(metaCli, store, chunkConf)
+	joinCacheGroup(metaCli, metaConf, store, chunkConf.CacheGroup)
 
 	err = metaCli.NewSession(true)
 	if err != nil {
@@ -667,8 +842,15 @@ func mount(c *cli.Context) error {
 	})
 	v := vfs.NewVFS(vfsConf, metaCli, store, registerer, registry)
 	installHandler(metaCli, mp, v, blob)
+	initFuseMetrics(registerer)
 	v.UpdateFormat = updateFormat(c)
 	initBackgroundTasks(c, vfsConf, metaConf, metaCli, blob, registerer, registry)
+	if !metaConf.ReadOnly && !metaConf.NoBGJob && vfsConf.ScrubInterval > 0 {
+		registerer.MustRegister(vfs.ScrubbedBytes)
+		registerer.MustRegister(vfs.DamagedBlocks)
+		registerer.MustRegister(vfs.LastScrubTimeG)
+		go vfs.Scrub(metaCli, store, vfsConf.ScrubInterval, vfsConf.ScrubLimit)
+	}
 	mountMain(v, c)
 	if err := v.FlushAll(""); err != nil {
 		logger.Errorf("flush all delayed data: %s", err)
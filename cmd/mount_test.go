@@ -340,3 +340,28 @@ func TestParseUIDGID(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCacheTiers(t *testing.T) {
+	tiers := parseCacheTiers("memory=4G, /nvme1/jfs:/nvme2/jfs=200G:lru,")
+	assert.Equal(t, 2, len(tiers))
+	assert.Equal(t, "memory", tiers[0].Dir)
+	assert.Equal(t, uint64(4<<30), tiers[0].Size)
+	assert.Equal(t, "", tiers[0].Eviction)
+	assert.Equal(t, "/nvme1/jfs:/nvme2/jfs", tiers[1].Dir)
+	assert.Equal(t, uint64(200<<30), tiers[1].Size)
+	assert.Equal(t, "lru", tiers[1].Eviction)
+	assert.Nil(t, parseCacheTiers(""))
+}
+
+func TestGroupMembers(t *testing.T) {
+	alive := time.Now().Add(time.Minute)
+	sessions := []*meta.Session{
+		{Sid: 1, Expire: alive, SessionInfo: meta.SessionInfo{CacheGroup: "g1", CacheAddr: "10.0.0.1:9000"}},
+		{Sid: 2, Expire: alive, SessionInfo: meta.SessionInfo{CacheGroup: "g2", CacheAddr: "10.0.0.2:9000"}},
+		{Sid: 3, Expire: time.Now().Add(-time.Minute), SessionInfo: meta.SessionInfo{CacheGroup: "g1", CacheAddr: "10.0.0.3:9000"}},
+		{Sid: 4, Expire: alive},
+		{Sid: 5, Expire: alive, SessionInfo: meta.SessionInfo{CacheGroup: "g1", CacheAddr: "10.0.0.5:9000"}},
+	}
+	assert.Equal(t, []string{"10.0.0.1:9000", "10.0.0.5:9000"}, groupMembers(sessions, "g1"))
+	assert.Nil(t, groupMembers(sessions, "g3"))
+}
@@ -45,8 +45,10 @@ import (
 	"time"
 
 	"github.com/juicedata/godaemon"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/urfave/cli/v2"
 
+	"github.com/juicedata/juicefs/pkg/audit"
 	"github.com/juicedata/juicefs/pkg/fuse"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/object"
@@ -338,10 +340,46 @@ func fuseFlags() []cli.Flag {
 			Name:  "all-squash",
 			Usage: "mapping all users to another one specified as <uid>:<gid>",
 		},
+		&cli.BoolFlag{
+			Name:  "case-insensitive",
+			Usage: "look up the names case-insensitively while preserving their cases, and reject new names differing only in case",
+		},
+		&cli.StringFlag{
+			Name:  "map-uid",
+			Usage: "mapping local uids to the ones in the volume, separated by comma, each as <local>:<volume>[:<count>] (e.g. 100000:0:65536)",
+		},
+		&cli.StringFlag{
+			Name:  "map-gid",
+			Usage: "mapping local gids to the ones in the volume, separated by comma, each as <local>:<volume>[:<count>] (e.g. 100000:0:65536)",
+		},
+		&cli.BoolFlag{
+			Name:  "coherent-mmap",
+			Usage: "commit the pages of shared writable mappings once the kernel writes them back, and follow the changes of other clients (--watch-changes), it's disabled with -o writeback_cache",
+		},
 		&cli.BoolFlag{
 			Name:  "prefix-internal",
 			Usage: "add '.jfs' prefix to all internal files",
 		},
+		&cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "write the audit records of file operations to file:///PATH (JSON lines), syslog://[HOST:PORT], syslog+tcp://HOST:PORT or kafka://HOST:PORT/TOPIC",
+		},
+		&cli.StringFlag{
+			Name:  "audit-prefix",
+			Usage: "only audit the operations under these directories (separated by comma)",
+		},
+		&cli.StringFlag{
+			Name:  "audit-ops",
+			Usage: "only audit these operations (separated by comma), default: open,create,write,setattr,mknod,mkdir,symlink,link,unlink,rmdir,rename",
+		},
+		&cli.StringFlag{
+			Name:  "admin-addr",
+			Usage: "address to serve the admin API of the mount, a unix socket (unix:PATH) or [HOST]:PORT (loopback if HOST is omitted, requires the token in JFS_ADMIN_TOKEN)",
+		},
+		&cli.StringFlag{
+			Name:  "settings-file",
+			Usage: "JSON file of the settings to change at runtime (same as the admin API), it's applied at start and re-read on SIGUSR1",
+		},
 		&cli.BoolFlag{
 			Name:   "non-default-permission",
 			Usage:  "disable `default_permissions` option, only for testing",
@@ -553,8 +591,13 @@ func prepareMp(mp string) {
 	}
 }
 
+// xattrEnabled tells whether the kernel should forward the xattr requests, the security ones need them too.
+func xattrEnabled(c *cli.Context) bool {
+	return c.Bool("enable-xattr") || c.Bool("enable-cap") || c.Bool("enable-selinux")
+}
+
 func genFuseOptExt(c *cli.Context, format *meta.Format) (fuseOpt string, mt int, noxattr, noacl bool, maxWrite int) {
-	enableXattr := c.Bool("enable-xattr")
+	enableXattr := xattrEnabled(c)
 	if format.EnableACL {
 		enableXattr = true
 	}
@@ -814,6 +857,15 @@ func increaseRlimit() {
 	}
 }
 
+func initFuseMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fuse_pending_requests",
+		Help: "Number of FUSE requests being served.",
+	}, func() float64 {
+		return float64(fuse.PendingRequests())
+	}))
+}
+
 func installHandler(m meta.Meta, mp string, v *vfs.VFS, blob object.ObjectStorage) {
 	// Go will catch all the signals
 	signal.Ignore(syscall.SIGPIPE)
@@ -852,6 +904,87 @@ func installHandler(m meta.Meta, mp string, v *vfs.VFS, blob object.ObjectStorag
 		}
 	}()
 }
+
+// serveAdmin serves the admin API on a unix socket (unix:PATH) or a TCP address, which is bound to the
+// loopback interface if the host is omitted. The token in JFS_ADMIN_TOKEN is required on TCP.
+func serveAdmin(v *vfs.VFS, addr string) {
+	var restart func() error
+	if os.Getenv("_FUSE_FD_COMM") != "" && os.Getenv("JFS_SUPER_COMM") == "" {
+		// the supervisor starts the binary again once the mount process exits after SIGHUP
+		restart = func() error {
+			logger.Infof("Restart the mount gracefully by admin API")
+			go func() {
+				time.Sleep(time.Millisecond * 100) // to send the response
+				_ = syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			}()
+			return nil
+		}
+	}
+	token := os.Getenv("JFS_ADMIN_TOKEN")
+	network := "tcp"
+	if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", p
+		_ = os.Remove(addr)
+	} else {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			logger.Fatalf("invalid address %s for admin API: %s", addr, err)
+		}
+		if host == "" {
+			addr = net.JoinHostPort("127.0.0.1", port)
+		}
+		if token == "" {
+			logger.Fatalf("admin API on %s requires a token, please set it by the environment variable JFS_ADMIN_TOKEN", addr)
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		logger.Fatalf("listen on %s for admin API: %s", addr, err)
+	}
+	if network == "unix" {
+		if err = os.Chmod(addr, 0600); err != nil {
+			logger.Warnf("chmod %s: %s", addr, err)
+		}
+	}
+	logger.Infof("Admin API listening on %s", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, v.AdminHandler(restart, token)); err != nil {
+			logger.Errorf("Serve admin API: %s", err)
+		}
+	}()
+}
+
+func loadSettings(v *vfs.VFS, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s vfs.AdminSettings
+	if err = json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("parse %s: %s", path, err)
+	}
+	return v.UpdateSettings(&s)
+}
+
+// watchSettings applies the settings in the file, and re-reads it once SIGUSR1 is received (SIGHUP is
+// used to restart the mount gracefully).
+func watchSettings(v *vfs.VFS, path string) {
+	if err := loadSettings(v, path); err != nil {
+		logger.Fatalf("load settings: %s", err)
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR1)
+	go func() {
+		for range signalChan {
+			if err := loadSettings(v, path); err != nil {
+				logger.Errorf("Reload settings: %s", err)
+			} else {
+				logger.Infof("Reloaded settings from %s", path)
+			}
+		}
+	}()
+}
+
 func launchMount(c *cli.Context, mp string, conf *vfs.Config) error {
 	increaseRlimit()
 	utils.AdjustOOMKiller(-1000)
@@ -906,7 +1039,11 @@ func launchMount(c *cli.Context, mp string, conf *vfs.Config) error {
 		notInCSI := os.Getenv("JFS_SUPER_COMM") == ""
 		signalChan := make(chan os.Signal, 10)
 		if notInCSI {
-			signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+			signals := []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}
+			if c.String("settings-file") != "" {
+				signals = append(signals, syscall.SIGUSR1) // to reload the settings
+			}
+			signal.Notify(signalChan, signals...)
 			go func() {
 				for {
 					sig := <-signalChan
@@ -1007,6 +1144,11 @@ func mountMain(v *vfs.VFS, c *cli.Context) {
 	conf.DirEntryTimeout = utils.Duration(c.String("dir-entry-cache"))
 	conf.NegEntryTimeout = utils.Duration(c.String("negative-entry-cache"))
 	conf.ReaddirCache = c.Bool("readdir-cache")
+	conf.CoherentMmap = c.Bool("coherent-mmap")
+	conf.WatchChanges = c.Bool("watch-changes") || conf.CoherentMmap
+	if conf.WatchChanges && conf.Format.ChangelogDays <= 0 {
+		logger.Warnf("watch-changes has no effect without changelog, please enable it with `juicefs config --changelog-days`")
+	}
 	major, minor := utils.GetKernelVersion()
 	if conf.ReaddirCache {
 		if conf.AttrTimeout == 0 {
@@ -1019,6 +1161,9 @@ func mountMain(v *vfs.VFS, c *cli.Context) {
 			logger.Warnf("When both readdir-cache and skip-dir-mtime are enabled, ignoring mtime may disable readdir refreshes on other nodes")
 		}
 	}
+	if conf.Meta.CaseInsensi && conf.NegEntryTimeout > 0 {
+		logger.Warnf("case-insensitive with negative-entry-cache may hide new files created with names in other cases")
+	}
 	if conf.NegEntryTimeout > 0 && (major < 5 || (major == 5 && minor < 11)) {
 		logger.Warnf("On kernel versions below 5.11 (current: %d.%d), negative-entry-cache may cause concurrent check-then-create operations (e.g. mkdir -p) to fail in a distributed environment", major, minor)
 	}
@@ -1039,9 +1184,43 @@ func mountMain(v *vfs.VFS, c *cli.Context) {
 			logger.Infof("Map root uid/gid 0 to %d/%d by setting root-squash", uid, gid)
 		}
 	}
+	var err error
+	if conf.UidMap, err = vfs.ParseIDMap(c.String("map-uid")); err != nil {
+		logger.Fatalf("map-uid: %s", err)
+	}
+	if conf.GidMap, err = vfs.ParseIDMap(c.String("map-gid")); err != nil {
+		logger.Fatalf("map-gid: %s", err)
+	}
+	if addr := c.String("admin-addr"); addr != "" {
+		serveAdmin(v, addr)
+	}
+	if path := c.String("settings-file"); path != "" {
+		watchSettings(v, path)
+	}
+	if uri := c.String("audit-log"); uri != "" {
+		conf.Audit = &audit.Config{Sink: uri}
+		if c.IsSet("audit-prefix") {
+			conf.Audit.Prefixes = strings.Split(c.String("audit-prefix"), ",")
+		}
+		if c.IsSet("audit-ops") {
+			conf.Audit.Ops = strings.Split(c.String("audit-ops"), ",")
+		}
+		sink, err := audit.NewSink(uri)
+		if err != nil {
+			logger.Fatalf("audit log: %s", err)
+		}
+		if v.Auditor, err = audit.NewLogger(v.Meta, sink, conf.Audit); err != nil {
+			logger.Fatalf("audit log: %s", err)
+		}
+		logger.Infof("Audit file operations to %s", sink)
+	}
 	logger.Infof("Mounting volume %s at %s ...", conf.Format.Name, conf.Meta.MountPoint)
-	err := fuse.Serve(v, c.String("o"), c.Bool("enable-xattr"), c.Bool("enable-ioctl"))
+	err = fuse.Serve(v, c.String("o"), xattrEnabled(c), c.Bool("enable-ioctl"))
 	if err != nil {
 		logger.Fatalf("fuse: %s", err)
 	}
+	utils.FlushTraces()
+	if err = v.Auditor.Close(); err != nil {
+		logger.Errorf("close audit log: %s", err)
+	}
 }
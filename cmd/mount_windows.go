@@ -26,6 +26,7 @@ import (
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/vfs"
 	"github.com/juicedata/juicefs/pkg/winfsp"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/urfave/cli/v2"
 )
 
@@ -72,6 +73,14 @@ func mountFlags() []cli.Flag {
 			Usage:  "If set, the file system will be case sensitive",
 			Hidden: true,
 		},
+		&cli.BoolFlag{
+			Name:  "enable-symlink",
+			Usage: "If set, symbolic links will be shown as reparse points and could be created (needs the privilege to create symbolic links)",
+		},
+		&cli.BoolFlag{
+			Name:  "enable-xattr",
+			Usage: "If set, extended attributes will be shown as NTFS extended attributes (EA)",
+		},
 		&cli.BoolFlag{
 			Name:  "report-case",
 			Usage: "If set, juicefs will report the correct case of a file path for a case-insensitive filesystem. (May incur a performance lost)",
@@ -117,7 +126,8 @@ func mountMain(v *vfs.VFS, c *cli.Context) {
 
 	winfsp.Serve(v, c.String("o"),
 		c.Bool("as-root"), int(delayCloseTime.Seconds()), c.Bool("show-dot-files"),
-		c.Int("winfsp-threads"), c.Bool("case-sensitive"), c.Bool("report-case"))
+		c.Int("winfsp-threads"), c.Bool("case-sensitive"), c.Bool("report-case"),
+		c.Bool("enable-symlink"), c.Bool("enable-xattr"))
 }
 
 func checkMountpoint(name, mp, logPath string, background bool) {}
@@ -128,6 +138,8 @@ func setFuseOption(c *cli.Context, format *meta.Format, vfsConf *vfs.Config) {}
 
 func launchMount(c *cli.Context, mp string, conf *vfs.Config) error { return nil }
 
+func initFuseMetrics(registerer prometheus.Registerer) {}
+
 func installHandler(m meta.Meta, mp string, v *vfs.VFS, blob object.ObjectStorage) {}
 
 func tryToInstallMountExec() error { return nil }
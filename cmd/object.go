@@ -395,14 +395,16 @@ func (j *juiceFS) Readlink(name string) (string, error) {
 
 func getDefaultChunkConf(format *meta.Format) *chunk.Config {
 	chunkConf := &chunk.Config{
-		BlockSize:  format.BlockSize * 1024,
-		Compress:   format.Compression,
-		HashPrefix: format.HashPrefix,
-		GetTimeout: time.Minute,
-		PutTimeout: time.Minute,
-		MaxUpload:  50,
-		MaxRetries: 10,
-		BufferSize: 300 << 20,
+		BlockSize:     format.BlockSize * 1024,
+		Compress:      format.Compression,
+		CompressDict:  format.CompressDict,
+		HashPrefix:    format.HashPrefix,
+		BlockChecksum: format.BlockChecksum,
+		GetTimeout:    time.Minute,
+		PutTimeout:    time.Minute,
+		MaxUpload:     50,
+		MaxRetries:    10,
+		BufferSize:    300 << 20,
 	}
 	chunkConf.SelfCheck(format.UUID)
 	return chunkConf
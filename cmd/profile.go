@@ -54,6 +54,13 @@ $ juicefs profile /tmp/juicefs.accesslog
 # Analyze an access log and print the total statistics immediately
 $ juicefs profile /tmp/juicefs.accesslog --interval 0
 
+# Export the operations by path as folded stacks, and render them as a flame graph
+$ juicefs profile /tmp/juicefs.accesslog --interval 0 --folded /tmp/juicefs.folded
+$ flamegraph.pl --countname us /tmp/juicefs.folded > /tmp/juicefs.svg
+
+# Push the statistics of real time operations to an OpenTelemetry collector
+$ juicefs profile /mnt/jfs --otlp-endpoint http://localhost:4318
+
 Details: https://juicefs.com/docs/community/fault_diagnosis_and_analysis#profile`,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -76,6 +83,14 @@ Details: https://juicefs.com/docs/community/fault_diagnosis_and_analysis#profile
 				Value: 2,
 				Usage: "flush interval in seconds; set it to 0 when replaying a log file to get an immediate result",
 			},
+			&cli.StringFlag{
+				Name:  "folded",
+				Usage: "write the total latency of operations by path and type as folded stacks (for flame graph) into this file on every flush",
+			},
+			&cli.StringFlag{
+				Name:  "otlp-endpoint",
+				Usage: "push the statistics of operations as OTLP metrics to this endpoint (http://HOST:PORT[/PATH]) on every flush",
+			},
 		},
 	}
 }
@@ -96,6 +111,7 @@ type profiler struct {
 	/* --- for replay --- */
 	printTime chan time.Time
 	done      chan bool
+	exporter  *profileExporter
 }
 
 type stat struct {
@@ -112,7 +128,8 @@ type logEntry struct {
 	ts            time.Time
 	uid, gid, pid string
 	op            string
-	latency       int // us
+	detail        string // arguments and reply, e.g: (1,foo): (17669,[...])
+	latency       int    // us
 }
 
 func parseLine(line string) *logEntry {
@@ -140,12 +157,20 @@ func parseLine(line string) *logEntry {
 		logger.Warnf("Failed to parse log line: %s: %s", line, err)
 		return nil
 	}
+	var detail string
+	if i := strings.Index(line, fields[3]+" "); i >= 0 {
+		detail = line[i+len(fields[3])+1:]
+		if j := strings.LastIndex(detail, " - "); j >= 0 {
+			detail = detail[:j]
+		}
+	}
 	return &logEntry{
 		ts:      ts,
 		uid:     ids[0],
 		gid:     ids[1],
 		pid:     ids[2],
 		op:      fields[3],
+		detail:  detail,
 		latency: int(latFloat * 1000000.0),
 	}
 }
@@ -208,6 +233,7 @@ func (p *profiler) counter() {
 			}
 			value.count++
 			value.total += entry.latency
+			p.exporter.add(entry)
 		case p.statsChan <- stats:
 			if p.replay {
 				p.printTime <- edge
@@ -239,6 +265,7 @@ func (p *profiler) fastCounter() {
 		}
 		value.count++
 		value.total += entry.latency
+		p.exporter.add(entry)
 	}
 	p.statsChan <- stats
 	p.printTime <- start
@@ -323,6 +350,7 @@ func (p *profiler) flusher() {
 				return keyStats[i].sPtr.total > keyStats[j].sPtr.total
 			})
 			p.flush(ts, keyStats, done)
+			p.exporter.export(ts)
 			if done {
 				os.Exit(0)
 			}
@@ -334,6 +362,21 @@ func (p *profiler) flusher() {
 	}
 }
 
+// accessLogOf returns the path of the access log of the mount point.
+func accessLogOf(mp string) string {
+	inode, err := utils.GetFileInode(mp)
+	if err != nil {
+		logger.Fatalf("Failed to lookup inode for %s: %s", mp, err)
+	}
+	if inode != uint64(meta.RootInode) {
+		logger.Fatalf("Path %s is not a mount point!", mp)
+	}
+	if p := filepath.Join(mp, ".jfs.accesslog"); utils.Exists(p) {
+		return p
+	}
+	return filepath.Join(mp, ".accesslog")
+}
+
 func profile(ctx *cli.Context) error {
 	setup(ctx, 1)
 	logPath := ctx.Args().First()
@@ -343,18 +386,7 @@ func profile(ctx *cli.Context) error {
 	}
 	var replay bool
 	if st.IsDir() { // mount point
-		inode, err := utils.GetFileInode(logPath)
-		if err != nil {
-			logger.Fatalf("Failed to lookup inode for %s: %s", logPath, err)
-		}
-		if inode != uint64(meta.RootInode) {
-			logger.Fatalf("Path %s is not a mount point!", logPath)
-		}
-		if p := filepath.Join(logPath, ".jfs.accesslog"); utils.Exists(p) {
-			logPath = p
-		} else {
-			logPath = filepath.Join(logPath, ".accesslog")
-		}
+		logPath = accessLogOf(logPath)
 	} else { // log file to be replayed
 		replay = true
 	}
@@ -379,6 +411,7 @@ func profile(ctx *cli.Context) error {
 		entryChan: make(chan *logEntry, 16),
 		statsChan: make(chan map[string]*stat),
 		pause:     make(chan bool),
+		exporter:  newProfileExporter(ctx.String("folded"), ctx.String("otlp-endpoint"), logPath),
 	}
 	if prof.replay {
 		prof.printTime = make(chan time.Time)
@@ -401,6 +434,7 @@ func profile(ctx *cli.Context) error {
 		prof.replay = false
 		prof.interval = last.Sub(start)
 		prof.flush(last, keyStats, <-prof.done)
+		prof.exporter.export(last)
 		return nil
 	}
 
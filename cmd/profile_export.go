@@ -0,0 +1,248 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/version"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// profileExporter keeps the cumulative statistics of all the operations, and exports them as folded
+// stacks (for flamegraph) of operations by path, or as OTLP metrics.
+type profileExporter struct {
+	sync.Mutex
+	folded string // path of the folded stacks
+	otlp   string // endpoint of OTLP/HTTP
+	source string
+	start  time.Time
+	paths  map[string]string // inode -> path, learned from the entries
+	ops    map[string]*stat
+	stacks map[string]*stat // "dir;file;op" -> stat
+}
+
+func newProfileExporter(folded, endpoint, source string) *profileExporter {
+	if folded == "" && endpoint == "" {
+		return nil
+	}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			logger.Fatalf("Invalid OTLP endpoint %s, expect http(s)://HOST:PORT[/PATH]", endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		endpoint = u.String()
+	}
+	return &profileExporter{
+		folded: folded,
+		otlp:   endpoint,
+		source: source,
+		paths:  map[string]string{"1": ""},
+		ops:    make(map[string]*stat),
+		stacks: make(map[string]*stat),
+	}
+}
+
+func (e *profileExporter) pathOf(ino string) string {
+	if p, ok := e.paths[ino]; ok {
+		return p
+	}
+	return "inode:" + ino
+}
+
+// target returns the path of the entry, and learns the path of the created or looked up inode.
+func (e *profileExporter) target(entry *logEntry) string {
+	d := entry.detail // e.g: (1,foo): (17669,[-rw-r--r--:0100644,1,0,0,...])
+	start, end := strings.IndexByte(d, '('), strings.IndexByte(d, ')')
+	if start < 0 || end < start {
+		return ""
+	}
+	args := strings.Split(d[start+1:end], ",")
+	switch entry.op {
+	case "lookup", "mknod", "mkdir", "create", "symlink", "unlink", "rmdir", "rename":
+		if len(args) < 2 {
+			break
+		}
+		p := path.Join(e.pathOf(args[0]), args[1])
+		if r := strings.Index(d[end:], ": ("); r >= 0 && entry.op != "unlink" && entry.op != "rmdir" && entry.op != "rename" {
+			reply := d[end+r+3:]
+			if i := strings.IndexAny(reply, ",)"); i > 0 {
+				e.paths[reply[:i]] = p
+			}
+		}
+		return p
+	case "link":
+		if len(args) >= 3 {
+			return path.Join(e.pathOf(args[1]), args[2])
+		}
+	}
+	return e.pathOf(args[0])
+}
+
+func (e *profileExporter) add(entry *logEntry) {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	if e.start.IsZero() {
+		e.start = entry.ts
+	}
+	s, ok := e.ops[entry.op]
+	if !ok {
+		s = &stat{}
+		e.ops[entry.op] = s
+	}
+	s.count++
+	s.total += entry.latency
+	if e.folded == "" {
+		return
+	}
+	var frames []string
+	for _, name := range strings.Split(e.target(entry), "/") {
+		if name != "" {
+			frames = append(frames, strings.ReplaceAll(name, ";", "_"))
+		}
+	}
+	key := strings.Join(append(frames, entry.op), ";")
+	if s, ok = e.stacks[key]; !ok {
+		s = &stat{}
+		e.stacks[key] = s
+	}
+	s.count++
+	s.total += entry.latency
+}
+
+// export writes the folded stacks and pushes the metrics at the time of ts.
+func (e *profileExporter) export(ts time.Time) {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	if e.folded != "" {
+		if err := e.writeFolded(); err != nil {
+			logger.Warnf("Write folded stacks into %s: %s", e.folded, err)
+		}
+	}
+	if e.otlp != "" && len(e.ops) > 0 {
+		if err := e.pushOTLP(ts); err != nil {
+			logger.Warnf("Push metrics to %s: %s", e.otlp, err)
+		}
+	}
+}
+
+// writeFolded writes one stack per line with the total latency in microseconds, which can be rendered
+// by flamegraph.pl, or compared by difffolded.pl.
+func (e *profileExporter) writeFolded() error {
+	keys := make([]string, 0, len(e.stacks))
+	for k := range e.stacks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %d\n", k, e.stacks[k].total)
+	}
+	tmp := e.folded + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.folded)
+}
+
+func otlpAttr(key, value string) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: value}}}
+}
+
+// pushOTLP sends the cumulative count and latency of the operations, the encoding of MetricsData is the
+// same as ExportMetricsServiceRequest.
+func (e *profileExporter) pushOTLP(ts time.Time) error {
+	ops := make([]string, 0, len(e.ops))
+	for op := range e.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	counts := make([]*metricsv1.NumberDataPoint, 0, len(ops))
+	latencies := make([]*metricsv1.NumberDataPoint, 0, len(ops))
+	for _, op := range ops {
+		s := e.ops[op]
+		attrs := []*commonv1.KeyValue{otlpAttr("op", op)}
+		counts = append(counts, &metricsv1.NumberDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: uint64(e.start.UnixNano()),
+			TimeUnixNano:      uint64(ts.UnixNano()),
+			Value:             &metricsv1.NumberDataPoint_AsInt{AsInt: int64(s.count)},
+		})
+		latencies = append(latencies, &metricsv1.NumberDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: uint64(e.start.UnixNano()),
+			TimeUnixNano:      uint64(ts.UnixNano()),
+			Value:             &metricsv1.NumberDataPoint_AsDouble{AsDouble: float64(s.total) / 1e6},
+		})
+	}
+	sum := func(points []*metricsv1.NumberDataPoint) *metricsv1.Metric_Sum {
+		return &metricsv1.Metric_Sum{Sum: &metricsv1.Sum{
+			AggregationTemporality: metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+			DataPoints:             points,
+		}}
+	}
+	data, err := proto.Marshal(&metricsv1.MetricsData{ResourceMetrics: []*metricsv1.ResourceMetrics{{
+		Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+			otlpAttr("service.name", "juicefs-profile"),
+			otlpAttr("juicefs.source", e.source),
+		}},
+		ScopeMetrics: []*metricsv1.ScopeMetrics{{
+			Scope: &commonv1.InstrumentationScope{Name: "juicefs", Version: version.Version()},
+			Metrics: []*metricsv1.Metric{
+				{Name: "juicefs.profile.operations", Description: "number of completed operations", Unit: "{operation}", Data: sum(counts)},
+				{Name: "juicefs.profile.latency", Description: "total latency of completed operations", Unit: "s", Data: sum(latencies)},
+			},
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Post(e.otlp, "application/x-protobuf", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %s: %s", resp.Status, body)
+	}
+	return nil
+}
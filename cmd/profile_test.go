@@ -0,0 +1,81 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProfileExport(t *testing.T) {
+	var received metricsv1.MetricsData
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(data, &received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	folded := filepath.Join(t.TempDir(), "profile.folded")
+	e := newProfileExporter(folded, srv.URL, "test.accesslog")
+	for _, line := range []string{
+		"2024.01.15 08:26:11.003330 [uid:0,gid:0,pid:4403] lookup (1,dir): (2,[drwxr-xr-x:0040755,2,0,0,1,1,1,4096]) - OK <0.000100>",
+		"2024.01.15 08:26:11.003340 [uid:0,gid:0,pid:4403] create (2,a;b,-rw-r--r--:0100644): (3,[-rw-r--r--:0100644,1,0,0,1,1,1,0]) [fh:5] - OK <0.000200>",
+		"2024.01.15 08:26:11.003350 [uid:0,gid:0,pid:4403] write (3,4096,0,5) - OK <0.000300>",
+		"2024.01.15 08:26:11.003360 [uid:0,gid:0,pid:4403] write (3,4096,4096,5) - OK <0.000300>",
+		"2024.01.15 08:26:11.003370 [uid:0,gid:0,pid:4403] getattr (9): (9,[-rw-r--r--:0100644,1,0,0,1,1,1,0]) - OK <0.000010>",
+		"2024.01.15 08:26:11.003380 [uid:0,gid:0,pid:4403] unlink (2,a;b) - OK <0.000050>",
+	} {
+		e.add(parseLine(line))
+	}
+	e.export(time.Now())
+
+	data, err := os.ReadFile(folded)
+	if err != nil {
+		t.Fatalf("read folded: %s", err)
+	}
+	expected := "dir;a_b;create 200\ndir;a_b;unlink 50\ndir;a_b;write 600\ndir;lookup 100\ninode:9;getattr 10\n"
+	if string(data) != expected {
+		t.Fatalf("folded stacks:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	if len(received.ResourceMetrics) != 1 || len(received.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("invalid metrics: %v", &received)
+	}
+	metrics := received.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 2 || metrics[0].Name != "juicefs.profile.operations" {
+		t.Fatalf("invalid metrics: %v", metrics)
+	}
+	for _, dp := range metrics[0].GetSum().DataPoints {
+		if dp.Attributes[0].Value.GetStringValue() == "write" && dp.GetAsInt() != 2 {
+			t.Fatalf("count of write should be 2, but got %d", dp.GetAsInt())
+		}
+	}
+}
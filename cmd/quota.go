@@ -19,6 +19,7 @@ package cmd
 import (
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/juicedata/juicefs/pkg/meta"
@@ -31,7 +32,7 @@ func cmdQuota() *cli.Command {
 	return &cli.Command{
 		Name:            "quota",
 		Category:        "ADMIN",
-		Usage:           "Manage directory quotas",
+		Usage:           "Manage directory, user and group quotas",
 		ArgsUsage:       "META-URL",
 		HideHelpCommand: true,
 		Description: `
@@ -41,12 +42,15 @@ $ juicefs quota get redis://localhost --path /dir1
 $ juicefs quota list redis://localhost
 $ juicefs quota delete redis://localhost --path /dir1
 $ juicefs quota check redis://localhost --path /dir1 --repair
-$ juicefs quota set redis://localhost --uid 1000 --capacity 2 --inodes 200
+$ juicefs quota set redis://localhost --uid 1000 --capacity 500G --inodes 200
 $ juicefs quota get redis://localhost --uid 1000
 $ juicefs quota delete redis://localhost --uid 1000
 $ juicefs quota set redis://localhost --gid 100 --capacity 5 --inodes 500
 $ juicefs quota get redis://localhost --gid 100
-$ juicefs quota delete redis://localhost --gid 100`,
+$ juicefs quota delete redis://localhost --gid 100
+
+# Warn the user after 400 GiB, and deny writes after 500 GiB or 3 days above 400 GiB
+$ juicefs quota set redis://localhost --uid 1000 --capacity 500G --soft-capacity 400G --grace 3d`,
 		Subcommands: []*cli.Command{
 			{
 				Name:      "set",
@@ -98,6 +102,18 @@ $ juicefs quota delete redis://localhost --gid 100`,
 				Name:  "inodes",
 				Usage: "hard quota of the directory limiting its number of inodes",
 			},
+			&cli.StringFlag{
+				Name:  "soft-capacity",
+				Usage: "soft quota limiting the usage of space in GiB, it can be exceeded for a grace period",
+			},
+			&cli.Uint64Flag{
+				Name:  "soft-inodes",
+				Usage: "soft quota limiting the number of inodes, it can be exceeded for a grace period",
+			},
+			&cli.StringFlag{
+				Name:  "grace",
+				Usage: "how long the soft quota can be exceeded before writes are denied (0 means 7 days)",
+			},
 			&cli.BoolFlag{
 				Name:  "repair",
 				Usage: "repair inconsistent quota",
@@ -107,12 +123,14 @@ $ juicefs quota delete redis://localhost --gid 100`,
 				Usage: "calculate total usage of directory in strict mode (NOTE: may be slow for huge directory)",
 			},
 			&cli.Uint64Flag{
-				Name:  "uid",
-				Usage: "user ID for user quota management",
+				Name:    "uid",
+				Aliases: []string{"user"},
+				Usage:   "user ID for user quota management",
 			},
 			&cli.Uint64Flag{
-				Name:  "gid",
-				Usage: "group ID for group quota management",
+				Name:    "gid",
+				Aliases: []string{"group"},
+				Usage:   "group ID for group quota management",
 			},
 		},
 	}
@@ -177,13 +195,22 @@ func quota(c *cli.Context) error {
 	var strict, repair bool
 	if cmd == meta.QuotaSet {
 		strict = c.Bool("strict")
-		q := &meta.Quota{MaxSpace: -1, MaxInodes: -1} // negative means no change
+		q := &meta.Quota{MaxSpace: -1, MaxInodes: -1, SoftSpace: -1, SoftInodes: -1, Grace: -1} // negative means no change
 		if c.IsSet("capacity") {
 			q.MaxSpace = int64(utils.ParseBytes(c, "capacity", 'G'))
 		}
 		if c.IsSet("inodes") {
 			q.MaxInodes = int64(c.Uint64("inodes"))
 		}
+		if c.IsSet("soft-capacity") {
+			q.SoftSpace = int64(utils.ParseBytes(c, "soft-capacity", 'G'))
+		}
+		if c.IsSet("soft-inodes") {
+			q.SoftInodes = int64(c.Uint64("soft-inodes"))
+		}
+		if c.IsSet("grace") {
+			q.Grace = int64(utils.Duration(c.String("grace")) / time.Second)
+		}
 		qs[quotaKey] = q
 	} else if cmd == meta.QuotaCheck {
 		strict = c.Bool("strict")
@@ -205,6 +232,13 @@ func quota(c *cli.Context) error {
 	} else {
 		result[0] = []string{"Path", "Size", "Used", "Use%", "Inodes", "IUsed", "IUse%"}
 	}
+	var soft bool // show the soft limits only if there is any
+	for _, q := range qs {
+		soft = soft || q.SoftSpace > 0 || q.SoftInodes > 0
+	}
+	if soft {
+		result[0] = append(result[0], "Soft", "ISoft", "Grace")
+	}
 
 	paths := make([]string, 0, len(qs))
 	for p := range qs {
@@ -246,7 +280,23 @@ func quota(c *cli.Context) error {
 		} else {
 			identifier = p
 		}
-		result = append(result, []string{identifier, size, used, usedR, itotal, iused, iusedR})
+		row := []string{identifier, size, used, usedR, itotal, iused, iusedR}
+		if soft {
+			var ssize, sinodes, grace string
+			if q.SoftSpace > 0 {
+				ssize = humanize.IBytes(uint64(q.SoftSpace))
+			}
+			if q.SoftInodes > 0 {
+				sinodes = humanize.Comma(q.SoftInodes)
+			}
+			if left := q.GraceLeft(); left > 0 {
+				grace = left.Truncate(time.Second).String()
+			} else if left < 0 {
+				grace = "none"
+			}
+			row = append(row, ssize, sinodes, grace)
+		}
+		result = append(result, row)
 	}
 	printResult(result, 0, false)
 	return nil
@@ -36,13 +36,24 @@ func cmdRmr() *cli.Command {
 		Description: `
 This command provides a faster way to remove huge directories in JuiceFS.
 
+With --async, the directories are removed from the file system at once, and deleted with all the files
+in them by the background jobs of the clients at a limited rate (--async-delete-rate of mount), the
+progress can be checked by "juicefs status".
+
 Examples:
-$ juicefs rmr /mnt/jfs/foo`,
+$ juicefs rmr /mnt/jfs/foo
+
+# Delete a huge directory in background (requires root)
+$ juicefs rmr --async /mnt/jfs/foo`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "skip-trash",
 				Usage: "skip trash and delete files directly (requires root)",
 			},
+			&cli.BoolFlag{
+				Name:  "async",
+				Usage: "remove the directories at once and delete them in background, skipping trash (requires root)",
+			},
 			&cli.IntFlag{
 				Name:    "threads",
 				Aliases: []string{"p"},
@@ -86,6 +97,12 @@ func rmr(ctx *cli.Context) error {
 		}
 		flag = 1
 	}
+	if ctx.Bool("async") {
+		if os.Getuid() != 0 {
+			logger.Fatalf("Only root can remove files in background")
+		}
+		flag |= 2
+	}
 	progress := utils.NewProgress(false)
 	spin := progress.AddCountSpinner("Removing entries")
 	for i := 0; i < ctx.Args().Len(); i++ {
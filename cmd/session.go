@@ -0,0 +1,137 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+
+	"github.com/urfave/cli/v2"
+)
+
+func cmdSession() *cli.Command {
+	return &cli.Command{
+		Name:            "session",
+		Category:        "ADMIN",
+		Usage:           "Manage client sessions",
+		ArgsUsage:       "META-URL",
+		HideHelpCommand: true,
+		Description: `
+NOTE: Read-only session is not listed since it cannot register itself in the metadata.
+
+Examples:
+$ juicefs session list redis://localhost
+$ juicefs session list redis://localhost --json
+$ juicefs session kill redis://localhost 3`,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "List active client sessions",
+				ArgsUsage: "META-URL",
+				Action:    listSessions,
+			},
+			{
+				Name:      "kill",
+				Usage:     "Forcibly clean up a session, releasing its locks and sustained inodes",
+				ArgsUsage: "META-URL SID",
+				Action:    killSession,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "kill the session even if it's still alive",
+					},
+				},
+			},
+		},
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print sessions in JSON format",
+			},
+		},
+	}
+}
+
+func openSessionMeta(c *cli.Context) meta.Meta {
+	metaUri := c.Args().Get(0)
+	removePassword(metaUri)
+	m := meta.NewClient(metaUri, nil)
+	if _, err := m.Load(true); err != nil {
+		logger.Fatalf("load setting: %s", err)
+	}
+	return m
+}
+
+func listSessions(c *cli.Context) error {
+	setup(c, 1)
+	m := openSessionMeta(c)
+	sessions, err := m.ListSessions()
+	if err != nil {
+		logger.Fatalf("list sessions: %s", err)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Sid < sessions[j].Sid })
+	for i, s := range sessions {
+		if d, err := m.GetSession(s.Sid, true); err == nil {
+			d.Expire = s.Expire
+			sessions[i] = d
+		} else {
+			logger.Warnf("get session %d: %s", s.Sid, err)
+		}
+	}
+	if c.Bool("json") {
+		printJson(sessions)
+		return nil
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No active session")
+		return nil
+	}
+	result := [][]string{{"SID", "Host", "MountPoint", "Version", "PID", "Expire", "Sustained", "Flocks", "Plocks"}}
+	for _, s := range sessions {
+		result = append(result, []string{
+			strconv.FormatUint(s.Sid, 10),
+			s.HostName,
+			s.MountPoint,
+			s.Version,
+			strconv.Itoa(s.ProcessID),
+			s.Expire.Format("2006-01-02 15:04:05"),
+			strconv.Itoa(len(s.Sustained)),
+			strconv.Itoa(len(s.Flocks)),
+			strconv.Itoa(len(s.Plocks)),
+		})
+	}
+	printResult(result, 2, false)
+	return nil
+}
+
+func killSession(c *cli.Context) error {
+	setup(c, 2)
+	sid, err := strconv.ParseUint(c.Args().Get(1), 10, 64)
+	if err != nil || sid == 0 {
+		logger.Fatalf("invalid session id: %s", c.Args().Get(1))
+	}
+	m := openSessionMeta(c)
+	if err = m.KillSession(sid, c.Bool("force")); err != nil {
+		return fmt.Errorf("kill session %d: %s", sid, err)
+	}
+	fmt.Printf("Session %d is cleaned up\n", sid)
+	return nil
+}
@@ -0,0 +1,194 @@
+//go:build !nosftp
+// +build !nosftp
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+func cmdSFTP() *cli.Command {
+	selfFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "address to listen on",
+		},
+		&cli.IntFlag{
+			Name:  "port",
+			Value: 2022,
+			Usage: "port to listen on",
+		},
+		&cli.StringSliceFlag{
+			Name:  "host-key",
+			Usage: "private key file of the server, an ephemeral one is generated if not specified",
+		},
+		&cli.StringFlag{
+			Name:     "users",
+			Required: true,
+			Usage:    "file of the users, one per line as NAME:PASSWORD:UID:GID:HOME[:AUTHORIZED_KEYS]",
+		},
+		&cli.StringFlag{
+			Name:  "log",
+			Usage: "path for SFTP log",
+			Value: path.Join(getDefaultLogDir(), "juicefs-sftp.log"), //nolint:typecheck
+		},
+		&cli.StringFlag{
+			Name:  "access-log",
+			Usage: "path for JuiceFS access log",
+		},
+		&cli.BoolFlag{
+			Name:    "background",
+			Aliases: []string{"d"},
+			Usage:   "run in background",
+		},
+		&cli.StringFlag{
+			Name:  "mountpoint",
+			Value: "sftp",
+			Usage: "the mount point for current volume (to follow symlink)",
+		},
+	}
+
+	return &cli.Command{
+		Name:      "sftp",
+		Action:    sftpServe,
+		Category:  "SERVICE",
+		Usage:     "Start an SFTP server",
+		ArgsUsage: "META-URL",
+		Description: `
+It serves the volume over SFTP. Each user is jailed in its home directory (relative to the root of the
+volume, created on the first login if it does not exist), and accesses the files as the UID and GID of
+it. PASSWORD is a bcrypt hash (e.g. generated by "htpasswd -nbB NAME PASSWORD"), or empty to only allow
+the public keys in the AUTHORIZED_KEYS file. The logins and transfers are logged.
+
+Examples:
+$ cat /etc/juicefs/sftp-users
+alice:$2y$05$ZY.8XbwRLTl1ZQ2RM5eTzepF9Op8t6ikUsAWmFcdqP6cn1JzH1uKK:1001:1001:/partners/alice
+bob::1002:1002:/partners/bob:/etc/juicefs/bob.pub
+$ juicefs sftp redis://localhost --port 2022 --host-key /etc/ssh/ssh_host_ed25519_key --users /etc/juicefs/sftp-users`,
+		Flags: expandFlags(selfFlags, clientFlags(0), shareInfoFlags()),
+	}
+}
+
+func loadSFTPUsers(fpath string) (map[string]*fs.SFTPUser, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]*fs.SFTPUser)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ps := strings.Split(line, ":")
+		if len(ps) != 5 && len(ps) != 6 {
+			return nil, fmt.Errorf("line %d: invalid user %q", n, line)
+		}
+		uid, err := strconv.ParseUint(ps[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid uid %s", n, ps[2])
+		}
+		gid, err := strconv.ParseUint(ps[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid gid %s", n, ps[3])
+		}
+		u := &fs.SFTPUser{Name: ps[0], Password: ps[1], Uid: uint32(uid), Gid: uint32(gid), Home: path.Clean("/" + ps[4])}
+		if len(ps) == 6 && ps[5] != "" {
+			data, err := os.ReadFile(ps[5])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			for len(data) > 0 {
+				var key ssh.PublicKey
+				if key, _, _, data, err = ssh.ParseAuthorizedKey(data); err != nil {
+					break
+				}
+				u.AuthorizedKeys = append(u.AuthorizedKeys, key)
+			}
+		}
+		if u.Password == "" && len(u.AuthorizedKeys) == 0 {
+			logger.Warnf("User %s has neither password nor authorized keys, which can't login", u.Name)
+		}
+		users[u.Name] = u
+	}
+	return users, scanner.Err()
+}
+
+func loadHostKeys(files []string) ([]ssh.Signer, error) {
+	var keys []ssh.Signer
+	for _, fpath := range files {
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse host key %s: %s", fpath, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		logger.Warnf("No host key is specified, generate an ephemeral one, which will change after restart")
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func sftpServe(c *cli.Context) error {
+	setup(c, 1)
+	metaUrl := c.Args().Get(0)
+	listenAddr := net.JoinHostPort(c.String("host"), strconv.Itoa(c.Int("port")))
+	users, err := loadSFTPUsers(c.String("users"))
+	if err != nil {
+		logger.Fatalf("load users from %s: %s", c.String("users"), err)
+	}
+	hostKeys, err := loadHostKeys(c.StringSlice("host-key"))
+	if err != nil {
+		logger.Fatalf("load host keys: %s", err)
+	}
+	_, jfs := initForSvc(c, c.String("mountpoint"), "sftp", metaUrl, listenAddr)
+	fs.StartSFTPServer(jfs, fs.SFTPConfig{
+		Addr:     listenAddr,
+		HostKeys: hostKeys,
+		Users:    users,
+	})
+	return jfs.Meta().CloseSession()
+}
@@ -34,7 +34,8 @@ func cmdStatus() *cli.Command {
 		ArgsUsage: "META-URL",
 		Description: `
 It shows basic setting of the target volume, and a list of active sessions (including mount, SDK,
-S3-gateway and WebDAV) that are connected with the metadata engine.
+S3-gateway and WebDAV) that are connected with the metadata engine, and the directories being deleted
+in background (by "juicefs rmr --async").
 
 NOTE: Read-only session is not listed since it cannot register itself in the metadata.
 
@@ -175,6 +175,10 @@ func selectionFlags() []cli.Flag {
 			Name:  "files-from",
 			Usage: "read list of files or dirs to sync from FILE",
 		},
+		&cli.StringFlag{
+			Name:  "source-manifest",
+			Usage: "read the objects to sync from an S3 Inventory (manifest.json) or a CSV file of KEY[,SIZE[,MTIME]], instead of listing SRC",
+		},
 	})
 }
 
@@ -263,11 +267,32 @@ func syncStorageFlags() []cli.Flag {
 		},
 		&cli.StringFlag{
 			Name:  "bwlimit",
-			Usage: "limit bandwidth in Mbps (0 means unlimited)",
+			Usage: "limit bandwidth in Mbps (0 means unlimited), or by daily time windows like 08:00-20:00=50M,20:00-08:00=0",
+		},
+		&cli.StringFlag{
+			Name:  "upload-state-dir",
+			Value: defaultUploadStateDir(),
+			Usage: "directory to save the state of multipart uploads to resume them after restart (empty to disable)",
+		},
+		&cli.StringFlag{
+			Name:  "abort-stale-uploads",
+			Usage: "abort the multipart uploads in destination initiated before `DURATION` ago, except the resumable ones (0 to disable)",
+		},
+		&cli.StringFlag{
+			Name:  "state-db",
+			Usage: "file to save the progress of the sync, to resume it after interrupted instead of listing and comparing from the beginning",
 		},
 	})
 }
 
+func defaultUploadStateDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".juicefs", "sync")
+}
+
 func clusterFlags() []cli.Flag {
 	return addCategories("CLUSTER", []cli.Flag{
 		&cli.StringFlag{
@@ -445,6 +470,50 @@ func createSyncStorage(uri string, conf *sync.Config) (object.ObjectStorage, err
 	return store, nil
 }
 
+// splitBucket splits the URL of an object storage into the URL of the bucket and the key in it.
+func splitBucket(uri string) (string, string) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri, ""
+	}
+	p := strings.TrimPrefix(u.EscapedPath(), "/")
+	if name := strings.ToLower(u.Scheme); name == "minio" || name == "s3" && isS3PathType(u.Host) {
+		_, p, _ = strings.Cut(p, "/") // skip bucket name
+	}
+	key, err := url.PathUnescape(p)
+	if err != nil || !strings.HasSuffix(uri, p) {
+		return uri, ""
+	}
+	return uri[:len(uri)-len(p)], key
+}
+
+// openSourceManifest opens the bucket of the manifest, since the data files of S3 Inventory are listed
+// by the full keys in it.
+func openSourceManifest(uri, srcURL string, conf *sync.Config) (*sync.Manifest, error) {
+	m := &sync.Manifest{}
+	bucket := uri
+	if strings.Contains(uri, "://") {
+		bucket, m.Key = splitBucket(uri)
+	} else {
+		bucket, m.Key = filepath.Split(uri)
+		if bucket == "" {
+			bucket = "./"
+		}
+	}
+	if m.Key == "" || strings.HasSuffix(m.Key, "/") {
+		return nil, fmt.Errorf("invalid manifest %s", uri)
+	}
+	if strings.Contains(srcURL, "://") && !strings.HasPrefix(srcURL, "file://") {
+		_, m.Prefix = splitBucket(srcURL)
+	}
+	var err error
+	// not to change the options of conf by the manifest
+	if m.Store, err = createSyncStorage(bucket, &sync.Config{NoHTTPS: conf.NoHTTPS, Env: conf.Env}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func isS3PathType(endpoint string) bool {
 	//localhost[:8080] 127.0.0.1[:8080]  s3.ap-southeast-1.amazonaws.com[:8080] s3-ap-southeast-1.amazonaws.com[:8080]
 	pattern := `^((localhost)|(s3[.-].*\.amazonaws\.com)|((1\d{2}|2[0-4]\d|25[0-5]|[1-9]\d|[1-9])\.((1\d{2}|2[0-4]\d|25[0-5]|[1-9]\d|\d)\.){2}(1\d{2}|2[0-4]\d|25[0-5]|[1-9]\d|\d)))?(:\d*)?$`
@@ -488,6 +557,12 @@ func doSync(c *cli.Context) error {
 		object.Shutdown(src)
 		object.Shutdown(dst)
 	}()
+	if uri := c.String("source-manifest"); uri != "" && config.Manager == "" {
+		if config.SourceManifest, err = openSourceManifest(uri, srcURL, config); err != nil {
+			return err
+		}
+		defer object.Shutdown(config.SourceManifest.Store)
+	}
 	if config.StorageClass != "" {
 		if os, ok := dst.(object.SupportStorageClass); ok {
 			err := os.SetStorageClass(config.StorageClass)
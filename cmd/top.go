@@ -0,0 +1,283 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func cmdTop() *cli.Command {
+	return &cli.Command{
+		Name:      "top",
+		Action:    top,
+		Category:  "INSPECTOR",
+		Usage:     "Show the processes generating operations on a mount point, like iotop",
+		ArgsUsage: "MOUNTPOINT",
+		Description: `
+This is a tool that analyzes access log of JuiceFS and shows the local processes (by the PID of FUSE
+requests) which are reading, writing or doing metadata operations on the mount point, with the
+container they are in (if any).
+
+Examples:
+$ juicefs top /mnt/jfs
+
+# Sort the processes by the number of metadata operations, and show only the top 10
+$ juicefs top /mnt/jfs --sort meta -n 10`,
+		Flags: []cli.Flag{
+			&cli.Int64Flag{
+				Name:  "interval",
+				Value: 2,
+				Usage: "refresh interval in seconds",
+			},
+			&cli.StringFlag{
+				Name:  "sort",
+				Value: "io",
+				Usage: "sort the processes by io (read+write bytes), read, write, meta (metadata operations) or latency",
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"n"},
+				Value:   20,
+				Usage:   "max number of processes to show",
+			},
+		},
+	}
+}
+
+// procStat is the accounting of the operations of a process in one interval.
+type procStat struct {
+	pid                string
+	readBytes          int64
+	writeBytes         int64
+	readOps, writeOps  int
+	metaOps            int
+	latency            int // total latency in 'us'
+	command, container string
+}
+
+func (s *procStat) ops() int {
+	return s.readOps + s.writeOps + s.metaOps
+}
+
+type procAccounting struct {
+	sync.Mutex
+	procs map[string]*procStat
+}
+
+// account adds the entry to the process of it, the bytes of read are the ones returned, and the ones of
+// write are the ones requested.
+func (a *procAccounting) account(entry *logEntry) {
+	a.Lock()
+	defer a.Unlock()
+	s, ok := a.procs[entry.pid]
+	if !ok {
+		s = &procStat{pid: entry.pid}
+		a.procs[entry.pid] = s
+	}
+	s.latency += entry.latency
+	d := entry.detail // read: (ino,size,off,fh): n, write: (ino,size,off,fh)
+	switch entry.op {
+	case "read":
+		s.readOps++
+		if i := strings.LastIndex(d, "): "); i >= 0 {
+			n, _ := strconv.ParseInt(strings.Trim(d[i+3:], "()"), 10, 64)
+			s.readBytes += n
+		}
+	case "write":
+		s.writeOps++
+		if args := strings.SplitN(strings.TrimPrefix(d, "("), ",", 3); len(args) == 3 {
+			n, _ := strconv.ParseInt(args[1], 10, 64)
+			s.writeBytes += n
+		}
+	default:
+		s.metaOps++
+	}
+}
+
+// reset returns the accounting of the last interval, and starts a new one.
+func (a *procAccounting) reset() map[string]*procStat {
+	a.Lock()
+	defer a.Unlock()
+	procs := a.procs
+	a.procs = make(map[string]*procStat)
+	return procs
+}
+
+var containerID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// procInfo returns the command and the (short) container ID of a local process.
+func procInfo(pid string) (string, string) {
+	if pid == "0" {
+		return "[juicefs]", ""
+	}
+	var command, container string
+	if data, err := os.ReadFile("/proc/" + pid + "/cmdline"); err == nil && len(data) > 0 {
+		command = strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+	} else if data, err = os.ReadFile("/proc/" + pid + "/comm"); err == nil {
+		command = strings.TrimSpace(string(data))
+	}
+	if data, err := os.ReadFile("/proc/" + pid + "/cgroup"); err == nil {
+		if id := containerID.Find(data); id != nil {
+			container = string(id[:12])
+		}
+	}
+	return command, container
+}
+
+func sortProcs(procs []*procStat, by string) {
+	key := func(s *procStat) int64 {
+		switch by {
+		case "read":
+			return s.readBytes
+		case "write":
+			return s.writeBytes
+		case "meta":
+			return int64(s.metaOps)
+		case "latency":
+			return int64(s.latency)
+		default:
+			return s.readBytes + s.writeBytes
+		}
+	}
+	sort.Slice(procs, func(i, j int) bool { // reversed
+		if ki, kj := key(procs[i]), key(procs[j]); ki != kj {
+			return ki > kj
+		}
+		return procs[i].ops() > procs[j].ops()
+	})
+}
+
+func printProcs(procs []*procStat, interval time.Duration, ts time.Time, limit int, colorful bool) {
+	secs := interval.Seconds()
+	var readBytes, writeBytes int64
+	var ops int
+	for _, s := range procs {
+		readBytes += s.readBytes
+		writeBytes += s.writeBytes
+		ops += s.ops()
+	}
+	lines := make([]string, 3)
+	lines[0] = fmt.Sprintf("> JuiceFS Top  Refresh: %.0f seconds %20s", secs, ts.Format("2006-01-02T15:04:05"))
+	lines[1] = fmt.Sprintf("Total READ: %s/s  Total WRITE: %s/s  Total OPS: %.0f/s",
+		humanize.IBytes(uint64(float64(readBytes)/secs)), humanize.IBytes(uint64(float64(writeBytes)/secs)), float64(ops)/secs)
+	lines[2] = fmt.Sprintf("%-8s %12s %12s %10s %10s %10s %12s %-12s %s",
+		"PID", "READ/s", "WRITE/s", "R-OPS/s", "W-OPS/s", "META/s", "Average(us)", "CONTAINER", "COMMAND")
+	if limit > 0 && len(procs) > limit {
+		procs = procs[:limit]
+	}
+	for _, s := range procs {
+		command := s.command
+		if len(command) > 60 {
+			command = command[:60]
+		}
+		lines = append(lines, fmt.Sprintf("%-8s %12s %12s %10.1f %10.1f %10.1f %12.0f %-12s %s", s.pid,
+			humanize.IBytes(uint64(float64(s.readBytes)/secs)), humanize.IBytes(uint64(float64(s.writeBytes)/secs)),
+			float64(s.readOps)/secs, float64(s.writeOps)/secs, float64(s.metaOps)/secs,
+			float64(s.latency)/float64(s.ops()), s.container, command))
+	}
+	if colorful {
+		fmt.Print(CLEAR_SCREEM)
+		fmt.Println(colorize1(lines[0], GREEN))
+		fmt.Println(colorize1(lines[1], YELLOW))
+		fmt.Println(colorize1(lines[2], BLUE))
+		for _, l := range lines[3:] {
+			fmt.Println(colorize1(l, BLACK))
+		}
+	} else {
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+		fmt.Println()
+	}
+}
+
+func top(ctx *cli.Context) error {
+	setup(ctx, 1)
+	mp := ctx.Args().First()
+	if st, err := os.Stat(mp); err != nil {
+		logger.Fatalf("Failed to stat path %s: %s", mp, err)
+	} else if !st.IsDir() {
+		logger.Fatalf("Path %s is not a mount point!", mp)
+	}
+	if ctx.Int64("interval") <= 0 {
+		logger.Fatalf("Interval must be > 0!")
+	}
+	switch ctx.String("sort") {
+	case "io", "read", "write", "meta", "latency":
+	default:
+		logger.Fatalf("Invalid sort key %s, expect io, read, write, meta or latency", ctx.String("sort"))
+	}
+	logPath := accessLogOf(mp)
+	file, err := os.Open(logPath)
+	if err != nil {
+		logger.Fatalf("Failed to open log file %s: %s", logPath, err)
+	}
+	defer file.Close()
+
+	acct := &procAccounting{procs: make(map[string]*procStat)}
+	go func() {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if entry := parseLine(scanner.Text()); entry != nil {
+				acct.account(entry)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			logger.Fatalf("Reading log file failed with error: %s", err)
+		}
+	}()
+
+	interval := time.Second * time.Duration(ctx.Int64("interval"))
+	colorful := utils.SupportANSIColor(os.Stdout.Fd())
+	infos := make(map[string][2]string) // pid -> command, container
+	last := time.Now()
+	printProcs(nil, interval, last, 0, colorful)
+	for ts := range time.NewTicker(interval).C {
+		stats := acct.reset()
+		procs := make([]*procStat, 0, len(stats))
+		for pid, s := range stats {
+			info, ok := infos[pid]
+			if !ok {
+				info[0], info[1] = procInfo(pid)
+				infos[pid] = info
+			}
+			s.command, s.container = info[0], info[1]
+			procs = append(procs, s)
+		}
+		for pid := range infos {
+			if _, ok := stats[pid]; !ok { // the pid may be reused later
+				delete(infos, pid)
+			}
+		}
+		sortProcs(procs, ctx.String("sort"))
+		printProcs(procs, ts.Sub(last), ts, ctx.Int("limit"), colorful)
+		last = ts
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import "testing"
+
+func TestProcAccounting(t *testing.T) {
+	acct := &procAccounting{procs: make(map[string]*procStat)}
+	for _, line := range []string{
+		"2024.01.15 08:26:11.003330 [uid:0,gid:0,pid:100] read (3,131072,0,5): (131072) - OK <0.000100>",
+		"2024.01.15 08:26:11.003340 [uid:0,gid:0,pid:100] read (3,131072,131072,5): 4096 - OK <0.000100>",
+		"2024.01.15 08:26:11.003350 [uid:0,gid:0,pid:200] write (4,65536,0,6) - OK <0.000300>",
+		"2024.01.15 08:26:11.003360 [uid:0,gid:0,pid:200] getattr (4): (4,[-rw-r--r--:0100644,1,0,0,1,1,1,0]) - OK <0.000010>",
+		"2024.01.15 08:26:11.003370 [uid:0,gid:0,pid:300] lookup (1,foo) - ENOENT <0.000020>",
+	} {
+		acct.account(parseLine(line))
+	}
+	stats := acct.reset()
+	if len(acct.procs) != 0 || len(stats) != 3 {
+		t.Fatalf("expect 3 processes, but got %d", len(stats))
+	}
+	if s := stats["100"]; s.readOps != 2 || s.readBytes != 131072+4096 || s.latency != 200 {
+		t.Fatalf("invalid stat of pid 100: %+v", s)
+	}
+	if s := stats["200"]; s.writeOps != 1 || s.writeBytes != 65536 || s.metaOps != 1 {
+		t.Fatalf("invalid stat of pid 200: %+v", s)
+	}
+
+	procs := []*procStat{stats["100"], stats["200"], stats["300"]}
+	sortProcs(procs, "write")
+	if procs[0].pid != "200" {
+		t.Fatalf("pid 200 should be the first by write, but got %s", procs[0].pid)
+	}
+	sortProcs(procs, "io")
+	if procs[0].pid != "100" || procs[2].pid != "300" {
+		t.Fatalf("invalid order by io: %s %s %s", procs[0].pid, procs[1].pid, procs[2].pid)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+
+	"github.com/urfave/cli/v2"
+)
+
+func cmdTrash() *cli.Command {
+	return &cli.Command{
+		Name:            "trash",
+		Category:        "ADMIN",
+		Usage:           "Browse, search and restore removed files in trash",
+		ArgsUsage:       "META-URL",
+		HideHelpCommand: true,
+		Description: `
+Removed files are kept in trash by the original paths, so they can be found and put back without looking
+into the .trash directory. A file in a removed directory is restored with the directory (recreated from
+trash), and newer versions of the same path are preferred.
+
+Examples:
+# List the files removed in the last 24 hours
+$ juicefs trash list redis://localhost --since 24h
+
+# Search the removed files by name or path
+$ juicefs trash search redis://localhost "*.log"
+$ juicefs trash search redis://localhost "/data/2024-*/report.csv" --uid 1000
+
+# Restore a file, or a directory with all the removed files in it
+$ juicefs trash restore redis://localhost /data/report.csv
+$ juicefs trash restore redis://localhost /data/2024-01 --since "2024-01-15 08:00:00"`,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "List removed files in trash",
+				ArgsUsage: "META-URL",
+				Action:    listTrash,
+			},
+			{
+				Name:      "search",
+				Usage:     "Search removed files in trash by the name or the original path",
+				ArgsUsage: "META-URL PATTERN",
+				Action:    searchTrash,
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore removed files to their original locations",
+				ArgsUsage: "META-URL PATH ...",
+				Action:    restoreTrash,
+			},
+		},
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: `only the files removed after the time, e.g. "2024-01-15 08:00:00" or "24h" (before now)`,
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: `only the files removed before the time, e.g. "2024-01-15" or "7d" (before now)`,
+			},
+			&cli.Uint64Flag{
+				Name:  "uid",
+				Usage: "only the files owned by the user",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the files in JSON format",
+			},
+		},
+	}
+}
+
+// parseTrashTime parses an absolute time in local timezone, or a duration before now.
+func parseTrashTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d := utils.Duration(s); d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// trashFilter returns a function to check the time of removal and the owner of the entries.
+func trashFilter(c *cli.Context) func(e *meta.TrashEntry) bool {
+	var since, until time.Time
+	var err error
+	if c.IsSet("since") {
+		if since, err = parseTrashTime(c.String("since")); err != nil {
+			logger.Fatalf("since: %s", err)
+		}
+	}
+	if c.IsSet("until") {
+		if until, err = parseTrashTime(c.String("until")); err != nil {
+			logger.Fatalf("until: %s", err)
+		}
+	}
+	withUid, uid := c.IsSet("uid"), uint32(c.Uint64("uid"))
+	return func(e *meta.TrashEntry) bool {
+		// entries are kept in trash by the hour, so the ones removed in the hour of since are included
+		if !since.IsZero() && !e.Deleted.Add(time.Hour).After(since) {
+			return false
+		}
+		if !until.IsZero() && e.Deleted.After(until) {
+			return false
+		}
+		return !withUid || e.Attr.Uid == uid
+	}
+}
+
+func loadTrash(c *cli.Context, match func(e *meta.TrashEntry) bool) (meta.Meta, []*meta.TrashEntry) {
+	m := openSessionMeta(c)
+	entries, st := m.ListTrash(meta.Background())
+	if st != 0 {
+		logger.Fatalf("list trash: %s", st)
+	}
+	filter := trashFilter(c)
+	var result []*meta.TrashEntry
+	for _, e := range entries {
+		if filter(e) && match(e) {
+			result = append(result, e)
+		}
+	}
+	return m, result
+}
+
+func trashPath(e *meta.TrashEntry) string {
+	if e.Path == "" {
+		return fmt.Sprintf("<unknown parent %d>/%s", e.Parent, e.OrigName)
+	}
+	return e.Path
+}
+
+func printTrash(c *cli.Context, entries []*meta.TrashEntry) {
+	if c.Bool("json") {
+		printJson(entries)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No file is found in trash")
+		return
+	}
+	result := [][]string{{"Removed", "Type", "Size", "UID", "Path", "Trash"}}
+	for _, e := range entries {
+		typ := "file"
+		switch e.Attr.Typ {
+		case meta.TypeDirectory:
+			typ = "dir"
+		case meta.TypeSymlink:
+			typ = "symlink"
+		}
+		result = append(result, []string{
+			e.Deleted.Local().Format("2006-01-02 15:00"),
+			typ,
+			humanize.IBytes(e.Attr.Length),
+			strconv.FormatUint(uint64(e.Attr.Uid), 10),
+			trashPath(e),
+			path.Join("/", meta.TrashName, e.Dir, e.Name),
+		})
+	}
+	printResult(result, 4, false)
+}
+
+func listTrash(c *cli.Context) error {
+	setup(c, 1)
+	_, entries := loadTrash(c, func(e *meta.TrashEntry) bool { return true })
+	printTrash(c, entries)
+	return nil
+}
+
+func searchTrash(c *cli.Context) error {
+	setup(c, 2)
+	pattern := c.Args().Get(1)
+	if _, err := path.Match(pattern, ""); err != nil {
+		logger.Fatalf("invalid pattern %s: %s", pattern, err)
+	}
+	_, entries := loadTrash(c, func(e *meta.TrashEntry) bool {
+		if strings.Contains(pattern, "/") {
+			ok, _ := path.Match(pattern, e.Path)
+			return ok
+		}
+		ok, _ := path.Match(pattern, e.OrigName)
+		return ok
+	})
+	printTrash(c, entries)
+	return nil
+}
+
+func underTrashPath(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func restoreTrash(c *cli.Context) error {
+	setup(c, 2)
+	if os.Getuid() != 0 && runtime.GOOS != "windows" {
+		return fmt.Errorf("only root can restore files from trash")
+	}
+	dirs := make([]string, 0, c.NArg()-1)
+	for _, p := range c.Args().Slice()[1:] {
+		dirs = append(dirs, path.Clean("/"+p))
+	}
+	m, entries := loadTrash(c, func(e *meta.TrashEntry) bool {
+		for _, d := range dirs {
+			if e.Path != "" && underTrashPath(e.Path, d) {
+				return true
+			}
+		}
+		return false
+	})
+	if len(entries) == 0 {
+		fmt.Println("No file is found in trash")
+		return nil
+	}
+	// restore the parents before the children, and the newer versions first
+	sort.SliceStable(entries, func(i, j int) bool {
+		if di, dj := strings.Count(entries[i].Path, "/"), strings.Count(entries[j].Path, "/"); di != dj {
+			return di < dj
+		}
+		return entries[i].Deleted.After(entries[j].Deleted)
+	})
+	if err := m.NewSession(false); err != nil {
+		logger.Warnf("running without sessions because fail to new session: %s", err)
+	} else {
+		defer func() {
+			_ = m.CloseSession()
+		}()
+	}
+	ctx := meta.Background()
+	restored := make(map[string]bool)
+	var count, skipped, failed int
+	for _, e := range entries {
+		if restored[e.Path] {
+			logger.Debugf("skip an older version of %s in %s", e.Path, e.Dir)
+			skipped++
+			continue
+		}
+		if st := m.RestoreTrash(ctx, e); st == 0 {
+			restored[e.Path] = true
+			count++
+		} else {
+			logger.Warnf("restore %s from %s: %s", e.Path, path.Join(e.Dir, e.Name), st)
+			failed++
+		}
+	}
+	fmt.Printf("Restored %d files, skipped %d older versions and failed %d files\n", count, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to restore %d files", failed)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashTimeAndPath(t *testing.T) {
+	if ts, err := parseTrashTime("2024-01-15 08:30:00"); err != nil || !ts.Equal(time.Date(2024, 1, 15, 8, 30, 0, 0, time.Local)) {
+		t.Fatalf("parse absolute time: %s %s", ts, err)
+	}
+	if ts, err := parseTrashTime("2024-01-15"); err != nil || !ts.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("parse date: %s %s", ts, err)
+	}
+	if ts, err := parseTrashTime("1d"); err != nil || time.Since(ts) < 24*time.Hour || time.Since(ts) > 25*time.Hour {
+		t.Fatalf("parse duration: %s %s", ts, err)
+	}
+	if _, err := parseTrashTime("yesterday"); err == nil {
+		t.Fatalf("invalid time should fail")
+	}
+
+	for _, c := range []struct {
+		path, dir string
+		under     bool
+	}{
+		{"/a/b", "/a", true},
+		{"/a", "/a", true},
+		{"/ab", "/a", false},
+		{"/a", "/", true},
+	} {
+		if underTrashPath(c.path, c.dir) != c.under {
+			t.Fatalf("%s under %s should be %v", c.path, c.dir, c.under)
+		}
+	}
+}
@@ -47,7 +47,9 @@ func cmdWarmup() *cli.Command {
 		ArgsUsage: "[PATH ...]",
 		Description: `
 This command provides a faster way to actively build cache for the target files. It reads all objects
-of the files and then write them into local cache directory.
+of the files and then write them into local cache directory. If the mount point is in a cache group,
+every block is downloaded and cached by its owner in the group, while the files are listed and the
+progress is tracked only by this command, so it should not be run on every member.
 
 Examples:
 # Warm all files in datadir
@@ -58,7 +60,10 @@ $ cat /tmp/filelist
 /mnt/jfs/datadir/f1
 /mnt/jfs/datadir/f2
 /mnt/jfs/datadir/f3
-$ juicefs warmup -f /tmp/filelist`,
+$ juicefs warmup -f /tmp/filelist
+
+# Keep the models in the local cache, they are never evicted until unpinned
+$ juicefs warmup --pin /mnt/jfs/models`,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "file",
@@ -84,6 +89,14 @@ $ juicefs warmup -f /tmp/filelist`,
 				Name:  "check",
 				Usage: "check whether the data blocks are cached or not",
 			},
+			&cli.BoolFlag{
+				Name:  "pin",
+				Usage: "cache the data blocks and keep them from eviction",
+			},
+			&cli.BoolFlag{
+				Name:  "unpin",
+				Usage: "allow the pinned data blocks to be evicted",
+			},
 		},
 	}
 }
@@ -191,8 +204,15 @@ func warmup(ctx *cli.Context) error {
 	setup0(ctx, 1, 0)
 
 	evict, check := ctx.Bool("evict"), ctx.Bool("check")
-	if evict && check {
-		logger.Fatalf("--check and --evict can't be used together")
+	pin, unpin := ctx.Bool("pin"), ctx.Bool("unpin")
+	var n int
+	for _, b := range []bool{evict, check, pin, unpin} {
+		if b {
+			n++
+		}
+	}
+	if n > 1 {
+		logger.Fatalf("only one of --check, --evict, --pin and --unpin can be used")
 	}
 
 	var paths []string
@@ -258,6 +278,10 @@ func warmup(ctx *cli.Context) error {
 		action = vfs.EvictCache
 	} else if check {
 		action = vfs.CheckCache
+	} else if pin {
+		action = vfs.PinCache
+	} else if unpin {
+		action = vfs.UnpinCache
 	}
 
 	background := ctx.Bool("background")
@@ -297,7 +321,7 @@ func warmup(ctx *cli.Context) error {
 		switch action {
 		case vfs.WarmupCache:
 			logger.Infof("%s: %d files (%s bytes)", action, count, humanize.IBytes(uint64(bytes)))
-		case vfs.EvictCache:
+		case vfs.EvictCache, vfs.PinCache, vfs.UnpinCache:
 			logger.Infof("%s: %d files (%s bytes)", action, count, humanize.IBytes(uint64(bytes)))
 		case vfs.CheckCache:
 			if len(total.Locations) > 0 {
@@ -50,6 +50,19 @@ func cmdWebDav() *cli.Command {
 			Name:  "enable-proppatch",
 			Usage: "enable proppatch method support",
 		},
+		&cli.StringFlag{
+			Name:  "oidc-issuer",
+			Usage: "issuer URL of the OpenID Connect provider to validate the bearer tokens, instead of basic authentication",
+		},
+		&cli.StringFlag{
+			Name:  "oidc-audience",
+			Usage: "expected audience (client ID) of the OIDC tokens",
+		},
+		&cli.StringFlag{
+			Name:  "oidc-user-claim",
+			Value: "preferred_username",
+			Usage: "claim of the OIDC tokens as the name of the local user to access the files",
+		},
 		&cli.StringFlag{
 			Name:  "log",
 			Usage: "path for WebDAV log",
@@ -87,7 +100,10 @@ func cmdWebDav() *cli.Command {
 Examples:
 $ export WEBDAV_USER=root
 $ export WEBDAV_PASSWORD=1234
-$ juicefs webdav redis://localhost localhost:9007`,
+$ juicefs webdav redis://localhost localhost:9007
+
+# Validate the tokens issued by the OpenID Connect provider
+$ juicefs webdav redis://localhost localhost:9007 --oidc-issuer https://sso.example.com/realms/demo --oidc-audience webdav`,
 		Flags: expandFlags(selfFlags, clientFlags(0), shareInfoFlags()),
 	}
 }
@@ -96,6 +112,9 @@ func webdav(c *cli.Context) error {
 	setup(c, 2)
 	metaUrl := c.Args().Get(0)
 	listenAddr := c.Args().Get(1)
+	if c.IsSet("oidc-issuer") && c.String("oidc-audience") == "" {
+		logger.Fatalf("--oidc-audience is required with --oidc-issuer")
+	}
 	_, jfs := initForSvc(c, c.String("mountpoint"), "webdav", metaUrl, listenAddr)
 	fs.StartHTTPServer(jfs, fs.WebdavConfig{
 		Addr:            listenAddr,
@@ -107,6 +126,9 @@ func webdav(c *cli.Context) error {
 		KeyFile:         c.String("key-file"),
 		EnableProppatch: c.Bool("enable-proppatch"),
 		MaxDeletes:      c.Int("threads"),
+		OIDCIssuer:      c.String("oidc-issuer"),
+		OIDCAudience:    c.String("oidc-audience"),
+		OIDCUserClaim:   c.String("oidc-user-claim"),
 	})
 	return jfs.Meta().CloseSession()
 }
@@ -0,0 +1,391 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+var logger = utils.GetLogger("juicefs")
+
+// Ops are the operations which can be audited.
+var Ops = []string{"open", "create", "write", "setattr", "mknod", "mkdir", "symlink", "link", "unlink", "rmdir", "rename"}
+
+// Record is an audited file operation.
+type Record struct {
+	Time    time.Time
+	Op      string
+	Path    string
+	NewPath string `json:",omitempty"` // for rename
+	Inode   meta.Ino
+	Access  string `json:",omitempty"` // read, write or read,write for open and create
+	Uid     uint32
+	Gid     uint32
+	Pid     uint32
+	Host    string
+	Result  string // OK or the name of the error, e.g. EACCES
+}
+
+// Sink writes batches of records to a local file or an external system.
+type Sink interface {
+	String() string
+	Write(records []*Record) error
+	Close() error
+}
+
+// Creator creates a sink from the address after "scheme://".
+type Creator func(scheme, addr string) (Sink, error)
+
+var sinks = make(map[string]Creator)
+
+func Register(scheme string, creator Creator) {
+	sinks[scheme] = creator
+}
+
+// NewSink creates a sink by the scheme of the URI, e.g. file:///var/log/juicefs-audit.log.
+func NewSink(uri string) (Sink, error) {
+	p := strings.Index(uri, "://")
+	if p < 0 {
+		return nil, fmt.Errorf("invalid audit sink: %s", utils.RemovePassword(uri))
+	}
+	scheme := uri[:p]
+	creator, ok := sinks[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported audit sink: %s", scheme)
+	}
+	return creator(scheme, uri[p+3:])
+}
+
+// Config is the configuration of audit logging.
+type Config struct {
+	Sink     string
+	Prefixes []string `json:",omitempty"` // only audit the operations under these directories
+	Ops      []string `json:",omitempty"` // only audit these operations
+}
+
+// Logger resolves the paths of the operations when they are done (before the files are removed), and
+// writes the records to the sink in background. No record is dropped: the operations are blocked when
+// the queue is full, and the failed writes are retried until they succeed.
+type Logger struct {
+	sync.Mutex
+	conf     *Config
+	sink     Sink
+	m        meta.Meta
+	host     string
+	ops      map[string]bool
+	dirs     map[meta.Ino]string // cached paths of directories
+	prefixes map[meta.Ino]bool   // inodes of the prefixes, nil if they need to be resolved
+	under    map[meta.Ino]bool   // cached directories whether they are under any prefix
+	resolved time.Time
+	missing  bool // some of the prefixes don't exist
+	queue    chan *Record
+	done     chan struct{}
+}
+
+// NewLogger checks the configuration and starts writing the audited operations of m to the sink.
+func NewLogger(m meta.Meta, sink Sink, conf *Config) (*Logger, error) {
+	l := &Logger{
+		conf:  conf,
+		sink:  sink,
+		m:     m,
+		ops:   make(map[string]bool),
+		dirs:  make(map[meta.Ino]string),
+		queue: make(chan *Record, 10240),
+		done:  make(chan struct{}),
+	}
+	l.host, _ = os.Hostname()
+	for i, p := range conf.Prefixes {
+		conf.Prefixes[i] = path.Clean("/" + p)
+	}
+	if len(conf.Ops) == 0 {
+		conf.Ops = Ops
+	}
+	for _, op := range conf.Ops {
+		var valid bool
+		for _, o := range Ops {
+			valid = valid || o == op
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid operation %s to audit, should be one of %s", op, strings.Join(Ops, ","))
+		}
+		l.ops[op] = true
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *Logger) String() string {
+	return l.sink.String()
+}
+
+func (l *Logger) enabled(op string) bool {
+	return l != nil && l.ops[op]
+}
+
+func (l *Logger) newRecord(ctx meta.Context, op string, inode meta.Ino, err syscall.Errno) *Record {
+	result := "OK"
+	if err != 0 {
+		result = utils.ErrnoName(err)
+	}
+	return &Record{Time: time.Now(), Op: op, Inode: inode, Uid: ctx.Uid(), Gid: ctx.Gid(), Pid: ctx.Pid(), Host: l.host, Result: result}
+}
+
+func (l *Logger) enqueue(r *Record) {
+	if !r.Under(l.conf.Prefixes) {
+		return
+	}
+	select {
+	case l.queue <- r:
+	default:
+		logger.Warnf("Audit queue is full, wait for the sink %s to write the records", l.sink)
+		l.queue <- r
+	}
+}
+
+// mayUnder checks whether the inode could be under any of the prefixes by its ancestors, which are looked up
+// in the cached directories first, so the path is resolved only if it's needed. The file with hard links or
+// the one fails to be checked is resolved as well.
+func (l *Logger) mayUnder(inode meta.Ino) bool {
+	if len(l.conf.Prefixes) == 0 {
+		return true
+	}
+	l.Lock()
+	if l.prefixes == nil || time.Since(l.resolved) > time.Minute { // prefixes could be changed by other clients
+		l.resolvePrefixes()
+	}
+	prefixes, under := l.prefixes, l.under
+	l.Unlock()
+	if prefixes[meta.RootInode] || prefixes[inode] {
+		return true
+	}
+	ctx := meta.Background()
+	var attr meta.Attr
+	if l.m.GetAttr(ctx, inode, &attr) != 0 || attr.Parent == 0 {
+		return true
+	}
+	var visited []meta.Ino
+	result := true
+	for d := attr.Parent; ; {
+		l.Lock()
+		u, ok := under[d]
+		l.Unlock()
+		if ok {
+			result = u
+			break
+		}
+		if prefixes[d] || d == meta.RootInode {
+			result = prefixes[d]
+			break
+		}
+		visited = append(visited, d)
+		if l.m.GetAttr(ctx, d, &attr) != 0 || attr.Parent == 0 {
+			return true
+		}
+		d = attr.Parent
+	}
+	l.Lock()
+	if len(under) > 100000 {
+		clear(under)
+	}
+	for _, d := range visited {
+		under[d] = result
+	}
+	l.Unlock()
+	return result
+}
+
+// resolvePrefixes finds the inodes of the prefixes, the missing ones are ignored until they are created.
+func (l *Logger) resolvePrefixes() {
+	l.prefixes, l.under, l.resolved, l.missing = make(map[meta.Ino]bool), make(map[meta.Ino]bool), time.Now(), false
+	ctx := meta.Background()
+	for _, p := range l.conf.Prefixes {
+		inode := meta.RootInode
+		var attr meta.Attr
+		for _, name := range strings.Split(p, "/") {
+			if name == "" {
+				continue
+			}
+			if st := l.m.Lookup(ctx, inode, name, &inode, &attr, false); st != 0 {
+				inode = 0
+				break
+			}
+		}
+		if inode != 0 {
+			l.prefixes[inode] = true
+		} else {
+			l.missing = true
+		}
+	}
+}
+
+// Entry audits an operation on the entry in the parent directory, inode is 0 if it's unknown.
+func (l *Logger) Entry(ctx meta.Context, op string, parent meta.Ino, name string, inode meta.Ino, err syscall.Errno) {
+	if l != nil && err == 0 && op == "mkdir" {
+		l.Lock()
+		if l.missing {
+			l.prefixes = nil // a missing prefix could be created
+		}
+		l.Unlock()
+	}
+	if l.enabled(op) {
+		r := l.newRecord(ctx, op, inode, err)
+		r.Path = l.entryPath(parent, name)
+		l.enqueue(r)
+	}
+}
+
+// Create audits a creation of the file with the flags to open it, inode is 0 if it failed.
+func (l *Logger) Create(ctx meta.Context, parent meta.Ino, name string, inode meta.Ino, flags uint32, err syscall.Errno) {
+	if l.enabled("create") {
+		r := l.newRecord(ctx, "create", inode, err)
+		r.Path = l.entryPath(parent, name)
+		r.Access = access(flags)
+		l.enqueue(r)
+	}
+}
+
+// Inode audits an operation on the file, flags are the ones to open it, or 0 for other operations.
+func (l *Logger) Inode(ctx meta.Context, op string, inode meta.Ino, flags uint32, err syscall.Errno) {
+	if l.enabled(op) && l.mayUnder(inode) {
+		r := l.newRecord(ctx, op, inode, err)
+		if ps := l.m.GetPaths(meta.Background(), inode); len(ps) > 0 {
+			r.Path = ps[0]
+		}
+		if op == "open" {
+			r.Access = access(flags)
+		}
+		l.enqueue(r)
+	}
+}
+
+// Rename audits a rename of the entry, inode is 0 if it's unknown.
+func (l *Logger) Rename(ctx meta.Context, parent meta.Ino, name string, newParent meta.Ino, newName string, inode meta.Ino, err syscall.Errno) {
+	if l.enabled("rename") {
+		r := l.newRecord(ctx, "rename", inode, err)
+		r.Path, r.NewPath = l.entryPath(parent, name), l.entryPath(newParent, newName)
+		l.enqueue(r)
+	}
+	if l != nil && err == 0 {
+		l.Lock()
+		l.dirs = make(map[meta.Ino]string) // paths of the subdirectories are changed
+		l.prefixes, l.under = nil, nil
+		l.Unlock()
+	}
+}
+
+func access(flags uint32) string {
+	switch flags & syscall.O_ACCMODE {
+	case syscall.O_WRONLY:
+		return "write"
+	case syscall.O_RDWR:
+		return "read,write"
+	default:
+		return "read"
+	}
+}
+
+// entryPath returns the path of the entry in the directory, or "" if it can't be resolved.
+func (l *Logger) entryPath(parent meta.Ino, name string) string {
+	var d string
+	if parent == meta.RootInode {
+		d = "/"
+	} else {
+		l.Lock()
+		p, ok := l.dirs[parent]
+		l.Unlock()
+		if !ok {
+			if ps := l.m.GetPaths(meta.Background(), parent); len(ps) > 0 {
+				p = ps[0]
+			}
+			l.Lock()
+			if len(l.dirs) > 100000 {
+				l.dirs = make(map[meta.Ino]string)
+			}
+			l.dirs[parent] = p
+			l.Unlock()
+		}
+		d = p
+	}
+	if d == "" {
+		return ""
+	}
+	return path.Join(d, name)
+}
+
+// Under checks whether the record is under any of the directories.
+func (r *Record) Under(dirs []string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+	for _, d := range dirs {
+		if underPath(r.Path, d) || r.NewPath != "" && underPath(r.NewPath, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func underPath(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	var batch []*Record
+	for r := range l.queue {
+		batch = append(batch, r)
+		if len(batch) >= 100 || len(l.queue) == 0 {
+			l.write(batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		l.write(batch)
+	}
+}
+
+// write retries the batch until it's written, the operations are blocked once the queue is full.
+func (l *Logger) write(batch []*Record) {
+	for i := 1; ; i++ {
+		err := l.sink.Write(batch)
+		if err == nil {
+			return
+		}
+		logger.Errorf("Write %d audit records to %s (tried %d times): %s", len(batch), l.sink, i, err)
+		time.Sleep(time.Second * time.Duration(min(i, 30)))
+	}
+}
+
+// Close writes the pending records and closes the sink, it should be called after the file system is
+// unmounted. It waits until all the records are written.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.queue)
+	<-l.done
+	return l.sink.Close()
+}
@@ -0,0 +1,126 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnder(t *testing.T) {
+	r := &Record{Path: "/a/b/c", NewPath: "/d/e"}
+	cases := []struct {
+		dirs  []string
+		under bool
+	}{
+		{nil, true},
+		{[]string{"/"}, true},
+		{[]string{"/a"}, true},
+		{[]string{"/a/b/c"}, true},
+		{[]string{"/a/b/cd"}, false},
+		{[]string{"/x", "/d"}, true},
+		{[]string{"/x"}, false},
+	}
+	for _, c := range cases {
+		if u := r.Under(c.dirs); u != c.under {
+			t.Fatalf("Under(%v) = %v, expected %v", c.dirs, u, c.under)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	if _, err := NewSink("unknown://host"); err == nil {
+		t.Fatalf("unknown scheme should fail")
+	}
+	if _, err := NewSink("file://relative/path"); err == nil {
+		t.Fatalf("relative path should fail")
+	}
+	p := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewSink("file://" + p)
+	if err != nil {
+		t.Fatalf("new sink: %s", err)
+	}
+	defer sink.Close()
+	if err = sink.Write([]*Record{{Op: "create", Path: "/f", Result: "OK"}}); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	// rotated by logrotate
+	if err = os.Rename(p, p+".1"); err != nil {
+		t.Fatalf("rename: %s", err)
+	}
+	if err = sink.Write([]*Record{{Op: "unlink", Path: "/f", Result: "EACCES"}}); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	for name, op := range map[string]string{p + ".1": "create", p: "unlink"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("open %s: %s", name, err)
+		}
+		var records []*Record
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r Record
+			if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("decode %q: %s", scanner.Text(), err)
+			}
+			records = append(records, &r)
+		}
+		_ = f.Close()
+		if len(records) != 1 || records[0].Op != op {
+			t.Fatalf("records in %s: %+v", name, records)
+		}
+	}
+}
+
+type flakySink struct {
+	fails   int
+	records []*Record
+}
+
+func (s *flakySink) String() string { return "flaky://" }
+
+func (s *flakySink) Write(records []*Record) error {
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("unavailable")
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *flakySink) Close() error { return nil }
+
+func TestRetryWrite(t *testing.T) {
+	sink := &flakySink{fails: 1}
+	l, err := NewLogger(nil, sink, &Config{})
+	if err != nil {
+		t.Fatalf("new logger: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		l.enqueue(&Record{Op: "unlink", Path: "/f"})
+	}
+	if err = l.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if len(sink.records) != 3 {
+		t.Fatalf("records should be written after retry: %d", len(sink.records))
+	}
+}
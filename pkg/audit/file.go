@@ -0,0 +1,105 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileSink appends the records as JSON lines to a local file, which is reopened if it's moved away (by
+// logrotate), so it can be rotated without copytruncate.
+type fileSink struct {
+	sync.Mutex
+	path string
+	f    *os.File
+}
+
+func (s *fileSink) String() string {
+	return "file://" + s.path
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *fileSink) Write(records []*Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if st, err := os.Stat(s.path); err != nil || s.f == nil || !sameFile(s.f, st) {
+		if s.f != nil {
+			_ = s.f.Close()
+			s.f = nil
+		}
+		if err = s.open(); err != nil {
+			return err
+		}
+	}
+	_, err := s.f.Write(buf.Bytes())
+	return err
+}
+
+func sameFile(f *os.File, st os.FileInfo) bool {
+	fst, err := f.Stat()
+	return err == nil && os.SameFile(fst, st)
+}
+
+func (s *fileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// file:///var/log/juicefs-audit.log
+func newFileSink(scheme, addr string) (Sink, error) {
+	if !filepath.IsAbs(addr) {
+		return nil, fmt.Errorf("invalid audit file %s, should be file:///path/to/file", addr)
+	}
+	if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+		return nil, err
+	}
+	s := &fileSink{path: addr}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func init() {
+	Register("file", newFileSink)
+}
@@ -0,0 +1,81 @@
+//go:build !nokafka
+// +build !nokafka
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink sends every record as a message keyed by inode, so the operations on a file are kept in order.
+type kafkaSink struct {
+	addr string
+	w    *kafka.Writer
+}
+
+func (k *kafkaSink) String() string {
+	return "kafka://" + k.addr
+}
+
+func (k *kafkaSink) Write(records []*Record) error {
+	msgs := make([]kafka.Message, 0, len(records))
+	for _, r := range records {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(r.Inode.String()), Value: value})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return k.w.WriteMessages(ctx, msgs...)
+}
+
+func (k *kafkaSink) Close() error {
+	return k.w.Close()
+}
+
+// kafka://host1:9092,host2:9092/topic
+func newKafkaSink(scheme, addr string) (Sink, error) {
+	ps := strings.SplitN(addr, "/", 2)
+	if len(ps) != 2 || ps[0] == "" || ps[1] == "" {
+		return nil, fmt.Errorf("invalid kafka address %s, should be kafka://host:port/topic", addr)
+	}
+	return &kafkaSink{
+		addr: addr,
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(ps[0], ",")...),
+			Topic:        ps[1],
+			Balancer:     &kafka.Hash{},
+			BatchSize:    1000,
+			BatchTimeout: time.Millisecond * 10,
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+func init() {
+	Register("kafka", newKafkaSink)
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink sends every record as a JSON message to the local syslog daemon, or a remote one over UDP
+// (syslog://) or TCP (syslog+tcp://).
+type syslogSink struct {
+	uri string
+	w   *syslog.Writer
+}
+
+func (s *syslogSink) String() string {
+	return s.uri
+}
+
+func (s *syslogSink) Write(records []*Record) error {
+	for _, r := range records {
+		msg, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if r.Result == "OK" {
+			err = s.w.Info(string(msg))
+		} else {
+			err = s.w.Warning(string(msg))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// syslog:// (local), syslog://host:514 or syslog+tcp://host:514
+func newSyslogSink(scheme, addr string) (Sink, error) {
+	var network string
+	if addr != "" {
+		network = "udp"
+		if scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "juicefs-audit")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{uri: scheme + "://" + addr, w: w}, nil
+}
+
+func init() {
+	Register("syslog", newSyslogSink)
+	Register("syslog+tcp", newSyslogSink)
+}
@@ -0,0 +1,159 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ChecksumStore keeps the checksums of the blocks in slices, it's implemented by the metadata engine.
+type ChecksumStore interface {
+	SaveChecksums(id uint64, sums []uint32) error
+	// LoadChecksums returns nil if there is no checksum for the slice (written before it's enabled).
+	LoadChecksums(id uint64) ([]uint32, error)
+}
+
+// Every segment of a block has a CRC32C, so part of a block could be verified without reading all of it.
+const checksumSegment = 256 << 10
+
+const maxCachedChecksums = 10000
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// segmentChecksums returns the checksums of the segments in the data of a block.
+func segmentChecksums(data []byte) []uint32 {
+	sums := make([]uint32, 0, (len(data)+checksumSegment-1)/checksumSegment)
+	for off := 0; off < len(data); off += checksumSegment {
+		sums = append(sums, crc32.Checksum(data[off:min(off+checksumSegment, len(data))], crc32c))
+	}
+	return sums
+}
+
+// segmentRange extends the range [off, off+size) of a block to the boundaries of its segments.
+func segmentRange(off, size, blockSize int) (int, int) {
+	end := (off + size + checksumSegment - 1) / checksumSegment * checksumSegment
+	return off / checksumSegment * checksumSegment, min(end, blockSize)
+}
+
+// parseBlockKey returns the slice id and the index of a block from its key ("chunks/.../id_indx_size").
+func parseBlockKey(key string) (id uint64, indx int, ok bool) {
+	ps := strings.Split(path.Base(key), "_")
+	if len(ps) != 3 {
+		return
+	}
+	id, err := strconv.ParseUint(ps[0], 10, 64)
+	if err != nil {
+		return
+	}
+	indx, err = strconv.Atoi(ps[1])
+	return id, indx, err == nil
+}
+
+// checksumCache keeps the checksums of recently read slices, slices without checksum are cached as nil.
+type checksumCache struct {
+	sync.Mutex
+	sums map[uint64][]uint32
+	ids  []uint64 // in the order of loading, for eviction
+}
+
+// SetChecksumStore sets the store of block checksums, they are saved and verified only if BlockChecksum is enabled.
+func (store *cachedStore) SetChecksumStore(cs ChecksumStore) {
+	store.checksums = cs
+}
+
+// sliceChecksums returns the checksums of the blocks in a slice, nil means they are not verified.
+func (store *cachedStore) sliceChecksums(id uint64) ([]uint32, error) {
+	if store.checksums == nil || !store.conf.BlockChecksum {
+		return nil, nil
+	}
+	c := &store.sumCache
+	c.Lock()
+	sums, ok := c.sums[id]
+	c.Unlock()
+	if ok {
+		return sums, nil
+	}
+	sums, err := store.checksums.LoadChecksums(id)
+	if err != nil {
+		return nil, fmt.Errorf("load checksums of slice %d: %s", id, err)
+	}
+	c.Lock()
+	if c.sums == nil {
+		c.sums = make(map[uint64][]uint32)
+	}
+	if _, ok := c.sums[id]; !ok {
+		if len(c.ids) >= maxCachedChecksums {
+			delete(c.sums, c.ids[0])
+			c.ids = c.ids[1:]
+		}
+		c.sums[id] = sums
+		c.ids = append(c.ids, id)
+	}
+	c.Unlock()
+	return sums, nil
+}
+
+// verifySegments checks the data read from offset off of the block indx against the checksums of the slice.
+// off should be aligned to the segments, and the data should end at a segment boundary or the end of the block.
+func (store *cachedStore) verifySegments(sums []uint32, indx, off int, data []byte) error {
+	first := indx*((store.conf.BlockSize+checksumSegment-1)/checksumSegment) + off/checksumSegment
+	for i, sum := range segmentChecksums(data) {
+		if first+i >= len(sums) || sums[first+i] != sum {
+			return fmt.Errorf("segment %d of block %d: %w", off/checksumSegment+i, indx, errChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+// verifyChecksums checks the whole data of a block against the checksums in the metadata, if there are.
+func (store *cachedStore) verifyChecksums(key string, data []byte) error {
+	id, indx, ok := parseBlockKey(key)
+	if !ok {
+		return nil
+	}
+	sums, err := store.sliceChecksums(id)
+	if err != nil || sums == nil {
+		return err
+	}
+	return store.verifySegments(sums, indx, 0, data)
+}
+
+// readVerified reads p at boff of the block indx by read, which is called with the range extended to the
+// boundaries of the segments, so that it's verified against the checksums of the slice.
+func (s *rSlice) readVerified(sums []uint32, indx int, p []byte, boff int, read func(buf []byte, off int) (int, error)) (int, error) {
+	start, end := segmentRange(boff, len(p), s.blockSize(indx))
+	buf := make([]byte, end-start)
+	n, err := read(buf, start)
+	if n == len(buf) {
+		err = nil
+	} else if err == nil {
+		err = fmt.Errorf("short read: %d < %d", n, len(buf))
+	}
+	if err == nil {
+		err = s.store.verifySegments(sums, indx, start, buf)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf[boff-start:]), nil
+}
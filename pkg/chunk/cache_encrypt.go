@@ -0,0 +1,233 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+var (
+	cipherMagic        = []byte("JFSE")
+	errUnknownCacheKey = errors.New("encrypted by the key of another mount")
+	errPlainCache      = errors.New("not encrypted")
+)
+
+// cipherMarker is created in the cache directory when the encryption is enabled, only the plaintext blocks
+// modified before it are known to be written before and could still be read.
+const cipherMarker = ".encrypted"
+
+// cacheCipher encrypts the blocks written into the cache directories with a key generated for every mount.
+// The staging blocks carry the key wrapped by the encryptor of the volume, so they could still be uploaded
+// after the client is restarted, while the cached blocks of the previous mounts are dropped.
+//
+// An encrypted block is stored as: magic | length of wrapped key (2 bytes) | wrapped key | nonce | ciphertext
+type cacheCipher struct {
+	aead    cipher.AEAD
+	wrapped []byte           // the key wrapped by the volume encryptor, nil if the volume is not encrypted
+	keyEnc  object.Encryptor // to unwrap the keys of the staging blocks
+	mu      sync.Mutex
+	known   map[string]cipher.AEAD // keys of the other mounts by the wrapped ones
+	marked  map[string]time.Time   // when the cache directories are marked as encrypted
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newCacheCipher(keyEnc object.Encryptor) (*cacheCipher, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &cacheCipher{aead: aead, keyEnc: keyEnc, known: make(map[string]cipher.AEAD), marked: make(map[string]time.Time)}
+	if keyEnc != nil {
+		if c.wrapped, err = keyEnc.Encrypt(key); err != nil {
+			return nil, fmt.Errorf("wrap cache key: %s", err)
+		}
+		c.known[string(c.wrapped)] = aead
+	}
+	return c, nil
+}
+
+func (c *cacheCipher) headerSize(wrapped int) int {
+	return len(cipherMagic) + 2 + wrapped + c.aead.NonceSize()
+}
+
+// seal encrypts the block into buf, which should be large enough (see sealedSize).
+func (c *cacheCipher) seal(buf, data []byte, staging bool) ([]byte, error) {
+	var wrapped []byte
+	if staging {
+		if c.wrapped == nil {
+			return nil, errors.New("staging blocks can not be encrypted without data encryption of the volume")
+		}
+		wrapped = c.wrapped
+	}
+	hs := c.headerSize(len(wrapped))
+	copy(buf, cipherMagic)
+	binary.BigEndian.PutUint16(buf[len(cipherMagic):], uint16(len(wrapped)))
+	copy(buf[len(cipherMagic)+2:], wrapped)
+	nonce := buf[hs-c.aead.NonceSize() : hs]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(buf[:hs], nonce, data, nil), nil
+}
+
+func (c *cacheCipher) sealedSize(length int, staging bool) int {
+	n := c.headerSize(0) + length + c.aead.Overhead()
+	if staging {
+		n += len(c.wrapped)
+	}
+	return n
+}
+
+func (c *cacheCipher) aeadOf(wrapped []byte) (cipher.AEAD, error) {
+	if len(wrapped) == 0 {
+		return c.aead, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if aead, ok := c.known[string(wrapped)]; ok {
+		return aead, nil
+	}
+	if c.keyEnc == nil {
+		return nil, errUnknownCacheKey
+	}
+	key, err := c.keyEnc.Decrypt(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap cache key: %s", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	c.known[string(wrapped)] = aead
+	return aead, nil
+}
+
+// open decrypts the raw block into dst, the keys of the cached blocks of another mount can not be recovered.
+func (c *cacheCipher) open(dst, raw []byte) error {
+	ml := len(cipherMagic)
+	if len(raw) < ml+2 || !bytes.Equal(raw[:ml], cipherMagic) {
+		return errors.New("invalid header")
+	}
+	wl := int(binary.BigEndian.Uint16(raw[ml:]))
+	if len(raw) != c.headerSize(wl)+len(dst)+c.aead.Overhead() {
+		return fmt.Errorf("invalid size %d for block of %d bytes", len(raw), len(dst))
+	}
+	aead, err := c.aeadOf(raw[ml+2 : ml+2+wl])
+	if err != nil {
+		return err
+	}
+	hs := c.headerSize(wl)
+	_, err = aead.Open(dst[:0], raw[hs-aead.NonceSize():hs], raw[hs:], nil)
+	if err != nil && wl == 0 {
+		err = errUnknownCacheKey // most likely cached before restart
+	}
+	return err
+}
+
+// markDir creates the marker in the cache directory if it's not there, the plaintext blocks in it are
+// accepted only if they are not modified after the marker.
+func (c *cacheCipher) markDir(dir string) error {
+	name := filepath.Join(dir, cipherMarker)
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		if err = os.WriteFile(name, nil, 0600); err == nil {
+			fi, err = os.Stat(name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.marked[dir] = fi.ModTime()
+	c.mu.Unlock()
+	return nil
+}
+
+// plainBefore returns when the cache directory of the block is marked, zero if it's not marked.
+func (c *cacheCipher) plainBefore(name string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir, t := range c.marked {
+		if strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// openBlockFile opens a cached or staging block, the plaintext one is read only if it's written before the
+// encryption is enabled, otherwise errPlainCache is returned.
+func openBlockFile(name string, length int, level string, c *cacheCipher) (ReadCloser, error) {
+	if c == nil {
+		return openCacheFile(name, length, level)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == int64(length) || fi.Size() == int64(length+((length-1)/csBlock+1)*4) {
+		if t := c.plainBefore(name); t.IsZero() || fi.ModTime().After(t) {
+			return nil, fmt.Errorf("open %s: %w", name, errPlainCache)
+		}
+		return openCacheFile(name, length, level)
+	}
+	if fi.Size() < int64(length) || fi.Size() > int64(length+(64<<10)) {
+		return nil, fmt.Errorf("invalid file size %d, data length %d", fi.Size(), length)
+	}
+	raw := NewOffPage(int(fi.Size()))
+	defer raw.Release()
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(fp, raw.Data)
+	_ = fp.Close()
+	if err != nil {
+		return nil, err
+	}
+	p := NewOffPage(length)
+	defer p.Release()
+	if err = c.open(p.Data, raw.Data); err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", name, err)
+	}
+	return NewPageReader(p), nil
+}
@@ -0,0 +1,389 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+// GroupMember is a ChunkStore that shares its cached blocks with the other clients in the same cache group.
+type GroupMember interface {
+	// GroupAddr returns the address that the other members fetch blocks from, empty if it's not in a group.
+	GroupAddr() string
+	// UpdateMembers replaces the members of the group with the given addresses (including itself).
+	UpdateMembers(addrs []string)
+}
+
+const (
+	groupReplicas  = 64               // virtual nodes of every member in the hash ring
+	peerDownTime   = 30 * time.Second // skip a member for this long after it failed
+	groupTokenName = "X-JuiceFS-Group-Token"
+)
+
+type vnode struct {
+	hash uint32
+	addr string
+}
+
+// cacheGroup assigns every block to one of the members by consistent hashing over its key, the owner
+// caches the block and serves it to the others, so a block read by N clients is downloaded only once.
+type cacheGroup struct {
+	store  *cachedStore
+	addr   string
+	token  string
+	client *http.Client
+	mu     sync.RWMutex
+	ring   []vnode
+	down   map[string]time.Time
+}
+
+func groupToken(secret, name, uuid string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(uuid + "/" + name))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkGroup validates the settings of the cache group. Blocks are served only over mutual TLS to the members
+// that know the secret, on an address that is given explicitly.
+func (c *Config) checkGroup(uuid string) error {
+	if c.GroupSecret == "" {
+		return errors.New("group-secret is required")
+	}
+	host, _, err := net.SplitHostPort(c.GroupListen)
+	if err != nil {
+		return fmt.Errorf("invalid group-listen %q: %s", c.GroupListen, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("group-listen %q should have the address of a local interface", c.GroupListen)
+	}
+	if c.GroupCert == "" || c.GroupKey == "" {
+		return errors.New("group-cert and group-key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(c.GroupCert, c.GroupKey)
+	if err != nil {
+		return fmt.Errorf("load certificate: %s", err)
+	}
+	roots := x509.NewCertPool()
+	if c.GroupCA != "" {
+		pem, err := os.ReadFile(c.GroupCA)
+		if err != nil {
+			return fmt.Errorf("load CA: %s", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", c.GroupCA)
+		}
+	} else {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parse certificate: %s", err)
+		}
+		roots.AddCert(leaf)
+	}
+	// The members are reached by the addresses in their sessions, which may not be in the certificate,
+	// so only the chain is verified.
+	verify := func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate from the member")
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			crt, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = crt
+			} else {
+				opts.Intermediates.AddCert(crt)
+			}
+		}
+		_, err := leaf.Verify(opts)
+		return err
+	}
+	c.groupTLS = &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verify,
+		MinVersion:            tls.VersionTLS12,
+	}
+	c.groupToken = groupToken(c.GroupSecret, c.CacheGroup, uuid)
+	return nil
+}
+
+func blockHash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func newCacheGroup(store *cachedStore, listen string) (*cacheGroup, error) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	addr := ln.Addr().String() // with the port chosen
+	ln = tls.NewListener(ln, store.conf.groupTLS)
+	g := &cacheGroup{
+		store: store,
+		addr:  addr,
+		token: store.conf.groupToken,
+		client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 100,
+				IdleConnTimeout:     time.Minute,
+				DialContext:         (&net.Dialer{Timeout: time.Second * 3}).DialContext,
+				TLSClientConfig:     store.conf.groupTLS,
+			},
+		},
+		down: make(map[string]time.Time),
+	}
+	g.update([]string{addr})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks/", g.serve)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logger.Errorf("Serve cache group %s at %s: %s", store.conf.CacheGroup, addr, err)
+		}
+	}()
+	logger.Infof("Join cache group %s with address %s", store.conf.CacheGroup, addr)
+	return g, nil
+}
+
+func (g *cacheGroup) update(addrs []string) {
+	ring := make([]vnode, 0, len(addrs)*groupReplicas)
+	for _, a := range addrs {
+		for i := 0; i < groupReplicas; i++ {
+			ring = append(ring, vnode{blockHash(a + "#" + strconv.Itoa(i)), a})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	g.mu.Lock()
+	g.ring = ring
+	g.mu.Unlock()
+}
+
+// owner returns the member that the block belongs to.
+func (g *cacheGroup) owner(key string) string {
+	h := blockHash(key)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if len(g.ring) == 0 {
+		return g.addr
+	}
+	i := sort.Search(len(g.ring), func(i int) bool { return g.ring[i].hash >= h })
+	if i == len(g.ring) {
+		i = 0
+	}
+	return g.ring[i].addr
+}
+
+// peer returns the member to fetch the block from, or empty if it's owned by itself or the owner is down.
+func (g *cacheGroup) peer(key string) string {
+	addr := g.owner(key)
+	if addr == g.addr {
+		return ""
+	}
+	g.mu.RLock()
+	t, ok := g.down[addr]
+	g.mu.RUnlock()
+	if ok && time.Since(t) < peerDownTime {
+		return ""
+	}
+	return addr
+}
+
+// fetch reads the block from the member, or asks the member to cache the block if page is nil.
+func (g *cacheGroup) fetch(addr, key string, page *Page) error {
+	err := utils.WithTimeout(func(ctx context.Context) error {
+		method, expected := http.MethodGet, http.StatusOK
+		if page == nil {
+			method, expected = http.MethodPost, http.StatusNoContent
+		}
+		req, err := http.NewRequestWithContext(ctx, method, "https://"+addr+"/blocks/"+key, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(groupTokenName, g.token)
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expected {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		if page == nil {
+			return nil
+		}
+		if resp.ContentLength != int64(len(page.Data)) {
+			return fmt.Errorf("unexpected length %d", resp.ContentLength)
+		}
+		_, err = io.ReadFull(resp.Body, page.Data)
+		return err
+	}, g.store.conf.GetTimeout)
+	g.mu.Lock()
+	if err != nil {
+		g.down[addr] = time.Now()
+	} else {
+		delete(g.down, addr)
+	}
+	g.mu.Unlock()
+	return err
+}
+
+// serve sends the block to a member of the group, it's loaded from the object storage and cached if missed.
+// A POST only warms up the block without sending it back.
+func (g *cacheGroup) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(groupTokenName) != g.token {
+		http.Error(w, "invalid group token", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/blocks/")
+	size := parseObjOrigSize(key)
+	if !strings.HasPrefix(key, "chunks/") || size <= 0 || size > g.store.conf.BlockSize {
+		http.Error(w, "invalid block "+key, http.StatusBadRequest)
+		return
+	}
+	page := NewOffPage(size)
+	defer page.Release()
+	if err := g.store.readBlock(key, page); err != nil {
+		logger.Warnf("Serve block %s to %s: %s", key, r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	_, _ = w.Write(page.Data)
+}
+
+// readBlock reads the whole block from the local cache, or the object storage if it's not cached.
+func (store *cachedStore) readBlock(key string, page *Page) error {
+	if r, err := store.bcache.load(key); err == nil {
+		n, err := r.ReadAt(page.Data, 0)
+		_ = r.Close()
+		if n == len(page.Data) && (err == nil || err == io.EOF) {
+			if err = store.verifyChecksums(key, page.Data); err == nil {
+				store.cacheHits.Add(1)
+				store.cacheHitBytes.Add(float64(n))
+				return nil
+			} else if !errors.Is(err, errChecksumMismatch) {
+				return err
+			}
+		}
+		logger.Warnf("remove partial or corrupted cached block %s: %d %v", key, n, err)
+		store.bcache.remove(key, false)
+	}
+	store.cacheMiss.Add(1)
+	store.cacheMissBytes.Add(float64(len(page.Data)))
+	block, err := store.group.Execute(key, func() (*Page, error) {
+		page.Acquire()
+		err := store.load(context.Background(), key, page, store.shouldCache(len(page.Data)), false)
+		return page, err
+	})
+	defer block.Release()
+	if err == nil && block != page {
+		copy(page.Data, block.Data)
+	}
+	return err
+}
+
+// loadFromPeer tries to read the block from its owner in the cache group, false means it should be loaded
+// from the object storage.
+func (store *cachedStore) loadFromPeer(key string, page *Page) bool {
+	if store.peers == nil {
+		return false
+	}
+	addr := store.peers.peer(key)
+	if addr == "" {
+		return false
+	}
+	start := time.Now()
+	err := store.peers.fetch(addr, key, page)
+	if err == nil {
+		err = store.verifyChecksums(key, page.Data)
+	}
+	if err != nil {
+		logger.Warnf("Fetch block %s from %s: %s, read it from object storage", key, addr, err)
+		store.peerReqErrors.Add(1)
+		return false
+	}
+	logger.Debugf("Fetch block %s from %s (cost: %s)", key, addr, time.Since(start))
+	store.peerDataBytes.Add(float64(len(page.Data)))
+	return true
+}
+
+// fillPeer asks the owner in the cache group to cache the block, false means it should be cached locally.
+func (store *cachedStore) fillPeer(key string) bool {
+	if store.peers == nil {
+		return false
+	}
+	addr := store.peers.peer(key)
+	if addr == "" {
+		return false
+	}
+	if err := store.peers.fetch(addr, key, nil); err != nil {
+		logger.Warnf("Warm up block %s on %s: %s, cache it locally", key, addr, err)
+		store.peerReqErrors.Add(1)
+		return false
+	}
+	return true
+}
+
+func (store *cachedStore) GroupAddr() string {
+	if store.peers == nil {
+		return ""
+	}
+	return store.peers.addr
+}
+
+func (store *cachedStore) UpdateMembers(addrs []string) {
+	if store.peers == nil {
+		return
+	}
+	var self bool
+	for _, a := range addrs {
+		self = self || a == store.peers.addr
+	}
+	if !self {
+		addrs = append(addrs, store.peers.addr)
+	}
+	store.peers.update(addrs)
+}
@@ -0,0 +1,109 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const pinnedFile = "pinned"
+
+type noCacheKey struct{}
+
+// WithoutCache returns a context to read blocks without adding them into the cache, the cached ones are
+// still used.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func noCache(ctx context.Context) bool {
+	v, _ := ctx.Value(noCacheKey{}).(bool)
+	return v
+}
+
+// pin keeps the block out of eviction (and expiration), it's not cached by pinning.
+func (cache *cacheStore) pin(key string, pinned bool) {
+	k := cache.getCacheKey(key)
+	cache.Lock()
+	defer cache.Unlock()
+	if _, ok := cache.pinned[k]; ok == pinned {
+		return
+	}
+	if pinned {
+		cache.pinned[k] = struct{}{}
+	} else {
+		delete(cache.pinned, k)
+	}
+	cache.pinChanged = true
+}
+
+// loadPinned reads the pinned blocks saved by the previous mounts.
+func (cache *cacheStore) loadPinned() {
+	data, err := os.ReadFile(filepath.Join(cache.dir, pinnedFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Read pinned blocks in %s: %s", cache.dir, err)
+		}
+		return
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if key := strings.TrimSpace(s.Text()); key != "" {
+			cache.pinned[cache.getCacheKey(key)] = struct{}{}
+		}
+	}
+	if len(cache.pinned) > 0 {
+		logger.Infof("Found %d pinned blocks in %s", len(cache.pinned), cache.dir)
+	}
+}
+
+// savePinned writes the pinned blocks into the cache directory once they are changed.
+func (cache *cacheStore) savePinned() {
+	for cache.available() {
+		time.Sleep(time.Second)
+		cache.Lock()
+		if !cache.pinChanged {
+			cache.Unlock()
+			continue
+		}
+		var buf bytes.Buffer
+		for k := range cache.pinned {
+			buf.WriteString(cache.getPathFromKey(k))
+			buf.WriteByte('\n')
+		}
+		cache.pinChanged = false
+		cache.Unlock()
+		path := filepath.Join(cache.dir, pinnedFile)
+		tmp := path + ".tmp"
+		err := os.WriteFile(tmp, buf.Bytes(), cache.mode)
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+		if err != nil {
+			logger.Warnf("Save pinned blocks in %s: %s", cache.dir, err)
+			cache.Lock()
+			cache.pinChanged = true
+			cache.Unlock()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedEviction(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "diskCache")
+	conf := defaultConf
+	conf.CacheEviction = EvictionLRU
+	conf.FreeSpace = 0.00001
+	conf.CacheScanInterval = -1 // Disable periodic scan
+	conf.CacheSize = 1 << 30
+	conf.CacheItems = 10
+
+	m := new(cacheManagerMetrics)
+	m.initMetrics()
+	s := newCacheStore(m, dir, int64(conf.CacheSize), conf.CacheItems, 1, &conf, nil)
+	for i := 1; i <= 3; i++ {
+		s.pin(fmt.Sprintf("%d_%d_1024", i, i), true)
+	}
+	for i := 1; i <= 20; i++ {
+		key := fmt.Sprintf("%d_%d_1024", i, i)
+		s.add(key, 1024, uint32(time.Now().Add(time.Duration(i)*time.Second).Unix()))
+		require.LessOrEqual(t, int64(s.keys.len()), conf.CacheItems)
+	}
+	for i := 1; i <= 3; i++ { // the oldest ones are kept
+		require.NotNil(t, s.keys.get(s.getCacheKey(fmt.Sprintf("%d_%d_1024", i, i))))
+	}
+	require.Nil(t, s.keys.get(s.getCacheKey("4_4_1024")))
+
+	s.pin("1_1_1024", false)
+	s.add("21_21_1024", 1024, uint32(time.Now().Add(time.Minute).Unix()))
+	require.Nil(t, s.keys.get(s.getCacheKey("1_1_1024")))
+
+	// the pinned blocks are remembered after restart
+	time.Sleep(time.Millisecond * 1500)
+	s2 := newCacheStore(m, dir, int64(conf.CacheSize), conf.CacheItems, 1, &conf, nil)
+	require.Equal(t, 2, len(s2.pinned))
+	_, ok := s2.pinned[s2.getCacheKey("2_2_1024")]
+	require.True(t, ok)
+}
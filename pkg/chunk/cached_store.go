@@ -19,6 +19,7 @@ package chunk
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/juicedata/juicefs/pkg/compress"
@@ -34,6 +36,8 @@ import (
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juju/ratelimit"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const chunkSize = 1 << 26 // 64M
@@ -70,11 +74,16 @@ func (s *rSlice) blockSize(indx int) int {
 	return bsize
 }
 
-func (s *rSlice) key(indx int) string {
-	if s.store.conf.HashPrefix {
-		return fmt.Sprintf("chunks/%02X/%v/%v_%v_%v", s.id%256, s.id/1000/1000, s.id, indx, s.blockSize(indx))
+// BlockKey returns the key of a block in the object storage (without the name of the volume).
+func BlockKey(hashPrefix bool, id uint64, indx, size int) string {
+	if hashPrefix {
+		return fmt.Sprintf("chunks/%02X/%v/%v_%v_%v", id%256, id/1000/1000, id, indx, size)
 	}
-	return fmt.Sprintf("chunks/%v/%v/%v_%v_%v", s.id/1000/1000, s.id/1000, s.id, indx, s.blockSize(indx))
+	return fmt.Sprintf("chunks/%v/%v/%v_%v_%v", id/1000/1000, id/1000, id, indx, size)
+}
+
+func (s *rSlice) key(indx int) string {
+	return BlockKey(s.store.conf.HashPrefix, s.id, indx, s.blockSize(indx))
 }
 
 func (s *rSlice) index(off int) int {
@@ -127,11 +136,22 @@ func (s *rSlice) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 	}
 
 	key := s.key(indx)
+	sums, err := s.store.sliceChecksums(s.id)
+	if err != nil {
+		return 0, err
+	}
+	nc := noCache(ctx)
 	if s.store.conf.CacheEnabled() {
 		start := time.Now()
 		r, err := s.store.bcache.load(key)
 		if err == nil {
-			n, err = r.ReadAt(p, int64(boff))
+			if sums == nil {
+				n, err = r.ReadAt(p, int64(boff))
+			} else {
+				n, err = s.readVerified(sums, indx, p, boff, func(buf []byte, off int) (int, error) {
+					return r.ReadAt(buf, int64(off))
+				})
+			}
 			if !s.store.conf.OSCache {
 				dropOSCache(r)
 			}
@@ -142,7 +162,11 @@ func (s *rSlice) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 				s.store.cacheReadHist.Observe(time.Since(start).Seconds())
 				return n, nil
 			}
-			logger.Warnf("remove partial cached block %s: %d %s", key, n, err)
+			if errors.Is(err, errChecksumMismatch) {
+				logger.Warnf("remove corrupted cached block %s: %s", key, err)
+			} else {
+				logger.Warnf("remove partial cached block %s: %d %s", key, n, err)
+			}
 			s.store.bcache.remove(key, false)
 		}
 	}
@@ -150,11 +174,12 @@ func (s *rSlice) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 	s.store.cacheMiss.Add(1)
 	s.store.cacheMissBytes.Add(float64(len(p)))
 
-	if s.store.seekable &&
-		(!s.store.conf.CacheEnabled() || (boff > 0 && len(p) <= blockSize/4)) {
+	if s.store.seekable && (s.store.peers == nil || s.store.peers.peer(key) == "") &&
+		(!s.store.conf.CacheEnabled() || nc || (boff > 0 && len(p) <= blockSize/4)) {
 		if s.store.downLimit != nil {
 			s.store.downLimit.Wait(int64(len(p)))
 		}
+		s.store.throttle("GET", s.store.getLimit)
 		fullPage, err := s.store.group.TryPiggyback(key)
 		if fullPage != nil {
 			defer fullPage.Release()
@@ -170,24 +195,34 @@ func (s *rSlice) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 			sc    = object.DefaultStorageClass
 		)
 		page.Acquire()
-		err = utils.WithTimeout(func(ctx context.Context) error {
+		err = utils.WithTimeout(func(ctx context.Context) (err error) {
 			defer page.Release()
-			in, err := s.store.storage.Get(ctx, key, int64(boff), int64(len(p)), object.WithRequestID(&reqID), object.WithStorageClass(&sc))
-			if err == nil {
-				n, err = io.ReadFull(in, p)
-				_ = in.Close()
+			get := func(buf []byte, off int) (int, error) {
+				in, err := s.store.storage.Get(ctx, key, int64(off), int64(len(buf)), object.WithRequestID(&reqID), object.WithStorageClass(&sc))
+				if err != nil {
+					return 0, err
+				}
+				defer in.Close()
+				return io.ReadFull(in, buf)
+			}
+			if sums == nil {
+				n, err = get(p, boff)
+			} else {
+				n, err = s.readVerified(sums, indx, p, boff, get)
 			}
 			return err
 		}, s.store.conf.GetTimeout)
 		used := time.Since(st)
-		logRequest("GET", key, fmt.Sprintf("RANGE(%d,%d) ", boff, len(p)), reqID, err, used)
+		logRequest(ctx, "GET", key, fmt.Sprintf("RANGE(%d,%d) ", boff, len(p)), reqID, err, used)
 		s.store.objectDataBytes.WithLabelValues("GET", sc).Add(float64(n))
 		s.store.objectReqsHistogram.WithLabelValues("GET", sc).Observe(used.Seconds())
 		if err == nil {
-			s.store.fetcher.fetch(key)
+			if !nc {
+				s.store.fetcher.fetch(key)
+			}
 			return n, nil
 		} else {
-			s.store.objectReqErrors.Add(1)
+			s.store.objectReqErrors.WithLabelValues("GET", sc).Add(1)
 			// fall back to full read
 		}
 	}
@@ -199,7 +234,11 @@ func (s *rSlice) ReadAt(ctx context.Context, page *Page, off int) (n int, err er
 		} else {
 			tmp.Acquire()
 		}
-		err = s.store.load(key, tmp, s.store.shouldCache(blockSize), false)
+		if s.store.loadFromPeer(key, tmp) {
+			err = nil // cached by the owner
+		} else {
+			err = s.store.load(ctx, key, tmp, s.store.shouldCache(blockSize) && !nc, false)
+		}
 		return tmp, err
 	})
 	defer block.Release()
@@ -271,19 +310,23 @@ func freePage(p *Page) {
 type wSlice struct {
 	rSlice
 	pages       [][]*Page
+	sums        [][]uint32 // checksums of the segments in every block
 	uploaded    int
 	errors      chan error
 	uploadError error
 	pendings    int
 	writeback   bool
+	cache       bool
 }
 
 func sliceForWrite(id uint64, store *cachedStore) *wSlice {
 	return &wSlice{
 		rSlice:    rSlice{id, 0, store},
 		pages:     make([][]*Page, chunkSize/store.conf.BlockSize),
+		sums:      make([][]uint32, chunkSize/store.conf.BlockSize),
 		errors:    make(chan error, chunkSize/store.conf.BlockSize),
 		writeback: store.conf.Writeback,
+		cache:     true,
 	}
 }
 
@@ -295,6 +338,10 @@ func (s *wSlice) SetWriteback(enabled bool) {
 	s.writeback = enabled
 }
 
+func (s *wSlice) SetCache(enabled bool) {
+	s.cache = enabled
+}
+
 func (s *wSlice) WriteAt(p []byte, off int64) (n int, err error) {
 	if int(off)+len(p) > chunkSize {
 		return 0, fmt.Errorf("write out of chunk boudary: %d > %d", int(off)+len(p), chunkSize)
@@ -344,6 +391,7 @@ func (store *cachedStore) put(key string, p *Page) error {
 	if store.upLimit != nil {
 		store.upLimit.Wait(int64(len(p.Data)))
 	}
+	store.throttle("PUT", store.putLimit)
 	p.Acquire()
 	var (
 		reqID string
@@ -352,19 +400,20 @@ func (store *cachedStore) put(key string, p *Page) error {
 	return utils.WithTimeout(func(ctx context.Context) error {
 		defer p.Release()
 		st := time.Now()
-		err := store.storage.Put(ctx, key, bytes.NewReader(p.Data), object.WithRequestID(&reqID), object.WithStorageClass(&sc))
+		err := store.storage.Put(ctx, key, bytes.NewReader(p.Data), object.WithRequestID(&reqID), object.WithStorageClass(&sc), object.WithIfNoneMatch())
 		used := time.Since(st)
-		logRequest("PUT", key, "", reqID, err, used)
+		logRequest(context.Background(), "PUT", key, "", reqID, err, used)
 		store.objectDataBytes.WithLabelValues("PUT", sc).Add(float64(len(p.Data)))
 		store.objectReqsHistogram.WithLabelValues("PUT", sc).Observe(used.Seconds())
 		if err != nil {
-			store.objectReqErrors.Add(1)
+			store.objectReqErrors.WithLabelValues("PUT", sc).Add(1)
 		}
 		return err
 	}, store.conf.PutTimeout)
 }
 
 func (store *cachedStore) delete(key string) error {
+	store.throttle("DELETE", store.putLimit)
 	st := time.Now()
 	var reqID string
 	err := utils.WithTimeout(func(ctx context.Context) error {
@@ -376,10 +425,10 @@ func (store *cachedStore) delete(key string) error {
 		strings.Contains(err.Error(), "No such file")) {
 		err = nil
 	}
-	logRequest("DELETE", key, "", reqID, err, used)
+	logRequest(context.Background(), "DELETE", key, "", reqID, err, used)
 	store.objectReqsHistogram.WithLabelValues("DELETE", "").Observe(used.Seconds())
 	if err != nil {
-		store.objectReqErrors.Add(1)
+		store.objectReqErrors.WithLabelValues("DELETE", "").Add(1)
 	}
 	return err
 }
@@ -396,7 +445,7 @@ func (store *cachedStore) upload(key string, block *Page, s *wSlice) error {
 		buf.Acquire()
 	}
 	defer buf.Release()
-	if sync && (blen < store.conf.BlockSize || store.conf.CacheLargeWrite) {
+	if sync && s.cache && (blen < store.conf.BlockSize || store.conf.CacheLargeWrite) {
 		// block will be freed after written into disk
 		store.bcache.cache(key, block, false, false)
 	}
@@ -419,6 +468,14 @@ func (store *cachedStore) upload(key string, block *Page, s *wSlice) error {
 		}
 		if err = store.put(key, buf); err == nil {
 			break
+		} else if errors.Is(err, os.ErrExist) && (try > 0 || s == nil) {
+			// blocks are never overwritten, so it's written by the previous try or before restart (for staging blocks)
+			logger.Debugf("Block %s exists already", key)
+			err = nil
+			break
+		} else if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("block %s exists already, the slice ID may be reused", key)
+			break
 		}
 		logger.Debugf("Upload %s: %s (try %d)", key, err, try+1)
 	}
@@ -451,6 +508,9 @@ func (s *wSlice) upload(indx int) {
 		if off != blen {
 			panic(fmt.Sprintf("block length does not match: %v != %v", off, blen))
 		}
+		if s.store.conf.BlockChecksum && s.store.checksums != nil {
+			s.sums[indx] = segmentChecksums(block.Data) // read by Finish after the result is received
+		}
 		if s.writeback {
 			stagingPath := "unknown"
 			stageFailed := false
@@ -470,6 +530,7 @@ func (s *wSlice) upload(indx int) {
 					logger.Warnf("write %s to disk: %s, upload it directly", key, err)
 				}
 			} else {
+				item := s.store.addPending(key, stagingPath) // before acknowledged, so Persist could find it
 				s.errors <- nil
 				if s.store.conf.UploadDelay == 0 && s.store.canUpload() {
 					select {
@@ -477,10 +538,12 @@ func (s *wSlice) upload(indx int) {
 						defer func() { <-s.store.currentUpload }()
 						if err = s.store.upload(key, block, nil); err == nil {
 							s.store.bcache.uploaded(key, blen)
+							s.store.removePending(key)
 							if err := s.store.bcache.removeStage(key); err != nil {
 								logger.Warnf("failed to remove stage %s in upload", stagingPath)
 							}
 						} else { // add to delay list and wait for later scanning
+							s.store.releasePending(item)
 							s.store.addDelayedStaging(key, stagingPath, time.Now(), false)
 						}
 						return
@@ -488,6 +551,7 @@ func (s *wSlice) upload(indx int) {
 					}
 				}
 				block.Release()
+				s.store.releasePending(item)
 				s.store.addDelayedStaging(key, stagingPath, time.Now(), false)
 				return
 			}
@@ -539,6 +603,16 @@ func (s *wSlice) Finish(length int) error {
 			return err
 		}
 	}
+	if s.store.conf.BlockChecksum && s.store.checksums != nil {
+		var sums []uint32
+		for _, bs := range s.sums[:n] {
+			sums = append(sums, bs...)
+		}
+		if err := s.store.checksums.SaveChecksums(s.id, sums); err != nil {
+			s.uploadError = fmt.Errorf("save checksums of slice %d: %s", s.id, err)
+			return s.uploadError
+		}
+	}
 	return nil
 }
 
@@ -564,16 +638,25 @@ type Config struct {
 	CacheEviction     string
 	CacheScanInterval time.Duration
 	CacheExpire       time.Duration
+	CacheTiers        []CacheTier // faster tiers above CacheDir, from the fastest one
+	CacheDev          string      // raw block device used instead of CacheDir
+	CacheDevFormat    bool        // format CacheDev if it's not used by the volume before
 	OSCache           bool
 	FreeSpace         float32
 	AutoCreate        bool
 	Compress          string
+	CompressDict      []byte `json:"-"`
 	MaxUpload         int
 	MaxStageWrite     int
 	MaxRetries        int
 	UploadLimit       int64 // bytes per second
 	DownloadLimit     int64 // bytes per second
+	PutLimit          int64 // PUT and DELETE requests per second
+	GetLimit          int64 // GET requests per second
+	ListLimit         int64 // LIST requests per second
 	Writeback         bool
+	WritebackDurable  bool // fsync the staging blocks before the writes are acknowledged
+	FsyncUpload       bool // fsync() waits for the staging blocks to be uploaded
 	UploadDelay       time.Duration
 	UploadHours       string
 	HashPrefix        bool
@@ -585,9 +668,37 @@ type Config struct {
 	BufferSize        uint64
 	Readahead         int
 	Prefetch          int
+	BlockChecksum     bool
+	CacheGroup        string // share cached blocks with the clients in the same group
+	GroupListen       string // address to serve the blocks to the group
+	GroupSecret       string `json:"-"` // shared by the members to authenticate the requests
+	GroupCert         string // certificate and key shared by the members for mutual TLS
+	GroupKey          string
+	GroupCA           string // CA to verify the members, the certificate itself is trusted if empty
+	groupToken        string
+	groupTLS          *tls.Config
+	CacheEncrypt      bool             // encrypt the cached and staging blocks
+	KeyEncryptor      object.Encryptor // to protect the key of staging blocks, required by writeback with CacheEncrypt
+	cacheCipher       *cacheCipher
+	uuid              string
 }
 
 func (c *Config) SelfCheck(uuid string) {
+	c.uuid = uuid
+	if c.CacheDev != "" && c.CacheEnabled() {
+		if c.Writeback {
+			logger.Warnf("writeback is not supported with cache-dev, staging blocks need cache-dir")
+			c.Writeback = false
+		}
+		if c.CacheEncrypt {
+			logger.Warnf("cache-encrypt is not supported with cache-dev yet")
+			c.CacheEncrypt = false
+		}
+	}
+	if c.CacheEncrypt && c.Writeback && c.KeyEncryptor == nil {
+		logger.Warnf("writeback is disabled since staging blocks can not be encrypted without data encryption of the volume")
+		c.Writeback = false
+	}
 	if !c.CacheEnabled() {
 		if c.Writeback || c.Prefetch > 0 {
 			logger.Warnf("cache-size is 0, writeback and prefetch will be disabled")
@@ -595,6 +706,7 @@ func (c *Config) SelfCheck(uuid string) {
 			c.Prefetch = 0
 		}
 		c.CacheDir = "memory"
+		c.CacheDev = ""
 	}
 	if !c.Writeback {
 		if c.UploadDelay > 0 || c.UploadHours != "" {
@@ -602,6 +714,8 @@ func (c *Config) SelfCheck(uuid string) {
 			c.UploadDelay = 0
 			c.UploadHours = ""
 		}
+		c.WritebackDurable = false
+		c.FsyncUpload = false
 	}
 	if !c.CacheFullBlock && c.Writeback {
 		logger.Warnf("cache-partial-only is ineffective for stage blocks with writeback enabled")
@@ -642,6 +756,38 @@ func (c *Config) SelfCheck(uuid string) {
 		logger.Warnf("LRU eviction is not supported in memory cache mode yet, setting it to 2-random")
 		c.CacheEviction = Eviction2Random
 	}
+	if len(c.CacheTiers) > 0 && c.CacheDir == "memory" {
+		logger.Warnf("cache tiers are ignored in memory cache mode")
+		c.CacheTiers = nil
+	}
+	for i := range c.CacheTiers {
+		t := &c.CacheTiers[i]
+		if t.Dir != "memory" {
+			ds := utils.SplitDir(t.Dir)
+			for j := range ds {
+				ds[j] = filepath.Join(ds[j], uuid)
+			}
+			t.Dir = strings.Join(ds, string(os.PathListSeparator))
+		}
+		if t.Eviction == "" {
+			t.Eviction = c.CacheEviction
+		} else if t.Eviction != Eviction2Random && t.Eviction != EvictionNone && t.Eviction != EvictionLRU {
+			logger.Warnf("eviction of cache tier %s should be one of [%s, %s, %s]", t.Dir, EvictionNone, Eviction2Random, EvictionLRU)
+			t.Eviction = Eviction2Random
+		}
+		if t.Dir == "memory" && t.Eviction == EvictionLRU {
+			t.Eviction = Eviction2Random
+		}
+	}
+	if c.CacheGroup != "" {
+		if !c.CacheEnabled() {
+			logger.Warnf("cache group %s is disabled since cache-size is 0", c.CacheGroup)
+			c.CacheGroup = ""
+		} else if err := c.checkGroup(uuid); err != nil {
+			logger.Warnf("cache group %s is disabled: %s", c.CacheGroup, err)
+			c.CacheGroup = ""
+		}
+	}
 	if c.CacheExpire > 0 && c.CacheExpire < time.Second {
 		logger.Warnf("cache-expire it too short, setting it to 1 second")
 		c.CacheExpire = time.Second
@@ -683,6 +829,7 @@ type cachedStore struct {
 	fetcher       *prefetcher
 	conf          Config
 	group         *Controller
+	peers         *cacheGroup
 	currentUpload chan bool
 	pendingCh     chan *pendingItem
 	pendingKeys   map[string]*pendingItem
@@ -693,6 +840,11 @@ type cachedStore struct {
 	seekable      bool
 	upLimit       *ratelimit.Bucket
 	downLimit     *ratelimit.Bucket
+	putLimit      *ratelimit.Bucket
+	getLimit      *ratelimit.Bucket
+	checksums     ChecksumStore
+	sumCache      checksumCache
+	cacheSize     atomic.Uint64 // changed by UpdateCacheSize at runtime
 
 	cacheHits           prometheus.Counter
 	cacheMiss           prometheus.Counter
@@ -700,21 +852,73 @@ type cachedStore struct {
 	cacheMissBytes      prometheus.Counter
 	cacheReadHist       prometheus.Histogram
 	objectReqsHistogram *prometheus.HistogramVec
-	objectReqErrors     prometheus.Counter
+	objectReqErrors     *prometheus.CounterVec
 	objectDataBytes     *prometheus.CounterVec
+	objectReqThrottled  *prometheus.CounterVec
 	stageBlockDelay     prometheus.Counter
 	stageBlockErrors    prometheus.Counter
+	peerDataBytes       prometheus.Counter
+	peerReqErrors       prometheus.Counter
+}
+
+// logRequest logs the request to the object storage, and records it as a span of the trace in ctx.
+const maxRecentErrors = 100
+
+// recentErrors are the last failed requests to the object storage.
+var recentErrors struct {
+	sync.Mutex
+	lines []string
+}
+
+// RecentErrors returns the last failed requests to the object storage, the oldest one first.
+func RecentErrors() []string {
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	return append([]string(nil), recentErrors.lines...)
 }
 
-func logRequest(typeStr, key, param, reqID string, err error, used time.Duration) {
+func logRequest(ctx context.Context, typeStr, key, param, reqID string, err error, used time.Duration) {
+	if err != nil {
+		line := fmt.Sprintf("%s %s %s %s(req_id: %q, err: %v, cost: %s)", time.Now().Format("2006-01-02 15:04:05.000000"), typeStr, key, param, reqID, err, used)
+		recentErrors.Lock()
+		if len(recentErrors.lines) >= maxRecentErrors {
+			recentErrors.lines = recentErrors.lines[1:]
+		}
+		recentErrors.lines = append(recentErrors.lines, line)
+		recentErrors.Unlock()
+	}
+	if utils.TracingEnabled() {
+		span := utils.StartSpan(ctx, "object."+strings.ToLower(typeStr), trace.WithTimestamp(time.Now().Add(-used)),
+			trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("juicefs.key", key), attribute.String("juicefs.request_id", reqID)))
+		utils.EndSpan(span, err)
+	}
 	if used > SlowRequest {
+		if id := utils.TraceID(ctx); id != "" {
+			param += fmt.Sprintf("(trace_id: %s) ", id)
+		}
 		logger.Warnf("slow request: %s %s %s(req_id: %q, err: %v, cost: %s)", typeStr, key, param, reqID, err, used)
 	} else {
 		logger.Debugf("%s %s %s(req_id: %q, err: %v, cost: %s)", typeStr, key, param, reqID, err, used)
 	}
 }
 
-func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bool) (err error) {
+// throttle waits for a token of request from the bucket, if there is no token available.
+func (store *cachedStore) throttle(method string, limit *ratelimit.Bucket) {
+	if limit == nil {
+		return
+	}
+	if d := limit.Take(1); d > 0 {
+		store.objectReqThrottled.WithLabelValues(method).Inc()
+		time.Sleep(d)
+	}
+}
+
+// newRequestLimit allows bursts of requests within one second.
+func newRequestLimit(rate int64) *ratelimit.Bucket {
+	return ratelimit.NewBucketWithRate(float64(rate), rate)
+}
+
+func (store *cachedStore) load(ctx context.Context, key string, page *Page, cache bool, forceCache bool) (err error) {
 	defer func() {
 		e := recover()
 		if e != nil {
@@ -727,6 +931,7 @@ func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bo
 	if store.downLimit != nil && !compressed {
 		store.downLimit.Wait(int64(len(page.Data)))
 	}
+	store.throttle("GET", store.getLimit)
 	var (
 		in    io.ReadCloser
 		n     int
@@ -757,22 +962,25 @@ func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bo
 		return err
 	}, store.conf.GetTimeout)
 	used := time.Since(start)
-	logRequest("GET", key, "", reqID, err, used)
+	logRequest(ctx, "GET", key, "", reqID, err, used)
 	if store.downLimit != nil && compressed {
 		store.downLimit.Wait(int64(n))
 	}
 	store.objectDataBytes.WithLabelValues("GET", sc).Add(float64(n))
 	store.objectReqsHistogram.WithLabelValues("GET", sc).Observe(used.Seconds())
 	if err != nil {
-		store.objectReqErrors.Add(1)
+		store.objectReqErrors.WithLabelValues("GET", sc).Add(1)
 		return fmt.Errorf("get %s: %s", key, err)
 	}
 	if compressed {
-		n, err = store.compressor.Decompress(page.Data, p.Data[:n])
+		n, err = store.decode(page.Data, p.Data[:n])
 	}
 	if err != nil || n < len(page.Data) {
 		return fmt.Errorf("read %s fully: %v (%d < %d) after %s", key, err, n, len(page.Data), used)
 	}
+	if err = store.verifyChecksums(key, page.Data); err != nil {
+		return fmt.Errorf("verify %s: %w", key, err)
+	}
 	if cache {
 		store.bcache.cache(key, page, forceCache, !store.conf.OSCache)
 	}
@@ -781,7 +989,10 @@ func (store *cachedStore) load(key string, page *Page, cache bool, forceCache bo
 
 // NewCachedStore create a cached store.
 func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.Registerer) ChunkStore {
-	compressor := compress.NewCompressor(config.Compress)
+	compressor, err := compress.NewCompressorWithDict(config.Compress, config.CompressDict)
+	if err != nil {
+		logger.Fatalf("load compression dictionary: %s", err)
+	}
 	if compressor == nil {
 		logger.Fatalf("unknown compress algorithm: %s", config.Compress)
 	}
@@ -794,6 +1005,12 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 	if config.PutTimeout == 0 {
 		config.PutTimeout = time.Second * 60
 	}
+	if config.CacheEncrypt && config.CacheDir != "memory" {
+		var err error
+		if config.cacheCipher, err = newCacheCipher(config.KeyEncryptor); err != nil {
+			logger.Fatalf("init cache encryption: %s", err)
+		}
+	}
 	store := &cachedStore{
 		storage:       storage,
 		conf:          config,
@@ -804,6 +1021,7 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 		pendingKeys:   make(map[string]*pendingItem),
 		group:         NewController(),
 	}
+	store.cacheSize.Store(config.CacheSize)
 	if config.UploadLimit > 0 {
 		// there are overheads coming from HTTP/TCP/IP
 		store.upLimit = ratelimit.NewBucketWithRate(float64(config.UploadLimit)*0.85, config.UploadLimit/10)
@@ -811,7 +1029,17 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 	if config.DownloadLimit > 0 {
 		store.downLimit = ratelimit.NewBucketWithRate(float64(config.DownloadLimit)*0.85, config.DownloadLimit/10)
 	}
+	if config.PutLimit > 0 {
+		store.putLimit = newRequestLimit(config.PutLimit)
+	}
+	if config.GetLimit > 0 {
+		store.getLimit = newRequestLimit(config.GetLimit)
+	}
 	store.initMetrics()
+	if config.ListLimit > 0 {
+		// the store never lists objects, the limit applies to background tasks (e.g. cleaning up backups) of the client
+		object.SetListLimit(config.ListLimit, func() { store.objectReqThrottled.WithLabelValues("LIST").Inc() })
+	}
 	if store.conf.CacheDir != "memory" && store.conf.Writeback {
 		store.startHour, store.endHour, _ = config.parseHours()
 		if store.startHour != store.endHour {
@@ -847,11 +1075,14 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 		if size == 0 || size > store.conf.BlockSize {
 			return
 		}
+		if store.peers != nil && store.peers.peer(key) != "" {
+			return // prefetched by the owner
+		}
 		p := NewOffPage(size)
 		defer p.Release()
 		block, err := store.group.Execute(key, func() (*Page, error) { // dedup requests with full read
 			p.Acquire()
-			err := store.load(key, p, false, false) // delay writing cache until singleflight ends to prevent blocking waiters
+			err := store.load(context.Background(), key, p, false, false) // delay writing cache until singleflight ends to prevent blocking waiters
 			return p, err
 		})
 		defer block.Release()
@@ -860,6 +1091,14 @@ func NewCachedStore(storage object.ObjectStorage, config Config, reg prometheus.
 		}
 	})
 
+	if config.CacheGroup != "" {
+		if g, err := newCacheGroup(store, config.GroupListen); err != nil {
+			logger.Errorf("Join cache group %s: %s", config.CacheGroup, err)
+		} else {
+			store.peers = g
+		}
+	}
+
 	if store.conf.CacheDir != "memory" && store.conf.Writeback {
 		for i := 0; i < store.conf.MaxUpload; i++ {
 			go store.uploader()
@@ -911,14 +1150,18 @@ func (store *cachedStore) initMetrics() {
 		Help:    "Object requests latency distributions.",
 		Buckets: prometheus.ExponentialBuckets(0.01, 1.5, 25),
 	}, []string{"method", "storage_class"})
-	store.objectReqErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	store.objectReqErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "object_request_errors",
 		Help: "failed requests to object store",
-	})
+	}, []string{"method", "storage_class"})
 	store.objectDataBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "object_request_data_bytes",
 		Help: "Object requests size in bytes.",
 	}, []string{"method", "storage_class"})
+	store.objectReqThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "object_request_throttled",
+		Help: "Object requests delayed by the request limit.",
+	}, []string{"method"})
 	store.stageBlockDelay = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "staging_block_delay_seconds",
 		Help: "Total seconds of delay for staging blocks",
@@ -927,6 +1170,14 @@ func (store *cachedStore) initMetrics() {
 		Name: "staging_block_errors",
 		Help: "Total errors when staging blocks",
 	})
+	store.peerDataBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_group_data_bytes",
+		Help: "Bytes of blocks fetched from the cache group.",
+	})
+	store.peerReqErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_group_request_errors",
+		Help: "Failed requests to the cache group.",
+	})
 }
 
 func (store *cachedStore) regMetrics(reg prometheus.Registerer) {
@@ -941,8 +1192,11 @@ func (store *cachedStore) regMetrics(reg prometheus.Registerer) {
 	reg.MustRegister(store.objectReqsHistogram)
 	reg.MustRegister(store.objectReqErrors)
 	reg.MustRegister(store.objectDataBytes)
+	reg.MustRegister(store.objectReqThrottled)
 	reg.MustRegister(store.stageBlockDelay)
 	reg.MustRegister(store.stageBlockErrors)
+	reg.MustRegister(store.peerDataBytes)
+	reg.MustRegister(store.peerReqErrors)
 	reg.MustRegister(prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
 			Name: "blockcache_blocks",
@@ -969,6 +1223,38 @@ func (store *cachedStore) regMetrics(reg prometheus.Registerer) {
 		func() float64 {
 			return float64(len(store.currentUpload))
 		}))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "staging_pending_blocks",
+			Help: "number of staging blocks waiting in the upload queue",
+		},
+		func() float64 {
+			return float64(len(store.pendingCh))
+		}))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "staging_block_age_seconds",
+			Help: "age of the oldest staging block not uploaded yet",
+		},
+		func() float64 {
+			return store.oldestPending().Seconds()
+		}))
+}
+
+// oldestPending returns the age of the oldest staging block which is not uploaded yet.
+func (store *cachedStore) oldestPending() time.Duration {
+	var oldest time.Time
+	store.pendingMutex.Lock()
+	for _, item := range store.pendingKeys {
+		if oldest.IsZero() || item.ts.Before(oldest) {
+			oldest = item.ts
+		}
+	}
+	store.pendingMutex.Unlock()
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
 }
 
 func (store *cachedStore) shouldCache(size int) bool {
@@ -981,7 +1267,7 @@ func parseObjOrigSize(key string) int {
 	return l
 }
 
-func (store *cachedStore) uploadStagingFile(key string, stagingPath string) {
+func (store *cachedStore) uploadStagingFile(key string, stagingPath string, force bool) {
 	store.currentUpload <- true
 	defer func() {
 		<-store.currentUpload
@@ -994,16 +1280,14 @@ func (store *cachedStore) uploadStagingFile(key string, stagingPath string) {
 		logger.Debugf("Key %s is not needed, drop it", key)
 		return
 	}
-	defer func() {
-		item.uploading = false
-	}()
+	defer store.releasePending(item)
 
-	if !store.canUpload() {
+	if !force && !store.canUpload() {
 		return
 	}
 
 	blen := parseObjOrigSize(key)
-	f, err := openCacheFile(stagingPath, blen, store.conf.CacheChecksum)
+	f, err := openBlockFile(stagingPath, blen, store.conf.CacheChecksum, store.conf.cacheCipher)
 	if err != nil {
 		if store.isPendingValid(key) {
 			logger.Errorf("Open staging file %s: %s", stagingPath, err)
@@ -1048,17 +1332,89 @@ func (store *cachedStore) addDelayedStaging(key, stagingPath string, added time.
 		item = &pendingItem{key, stagingPath, added, false}
 		store.pendingKeys[key] = item
 	}
+	uploading := item.uploading
+	send := !uploading && (force || store.canUpload() && time.Since(added) > store.conf.UploadDelay)
+	if send {
+		item.uploading = true
+	}
 	store.pendingMutex.Unlock()
-	if item.uploading {
+	if uploading {
 		logger.Debugf("Key %s is ignored since it's already being uploaded", key)
 		return true
 	}
-	if force || store.canUpload() && time.Since(added) > store.conf.UploadDelay {
+	if send {
 		select {
 		case store.pendingCh <- item:
-			item.uploading = true
 			return true
 		default:
+			store.releasePending(item)
+		}
+	}
+	return false
+}
+
+// addPending adds the staging block being uploaded right after it's written.
+func (store *cachedStore) addPending(key, stagingPath string) *pendingItem {
+	store.pendingMutex.Lock()
+	defer store.pendingMutex.Unlock()
+	item := store.pendingKeys[key]
+	if item == nil {
+		item = &pendingItem{key, stagingPath, time.Now(), true}
+		store.pendingKeys[key] = item
+	}
+	return item
+}
+
+func (store *cachedStore) releasePending(item *pendingItem) {
+	store.pendingMutex.Lock()
+	item.uploading = false
+	store.pendingMutex.Unlock()
+}
+
+// Persist uploads the staging blocks of the slice right away (even out of the upload hours), and waits until
+// all of them are in the object storage.
+func (store *cachedStore) Persist(id uint64, length int) error {
+	if !store.conf.Writeback {
+		return nil
+	}
+	s := &rSlice{id, length, store}
+	for _, key := range s.keys() {
+		for {
+			store.pendingMutex.Lock()
+			item, ok := store.pendingKeys[key]
+			busy := ok && item.uploading
+			if ok && !busy {
+				item.uploading = true
+			}
+			store.pendingMutex.Unlock()
+			if !ok {
+				break
+			}
+			if busy {
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+			store.uploadStagingFile(item.key, item.fpath, true)
+			if store.isPendingValid(key) {
+				return fmt.Errorf("upload staging block %s failed", key)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Staged returns whether any block of the slice is still waiting in the staging area to be uploaded.
+func (store *cachedStore) Staged(id uint64, length int) bool {
+	if !store.conf.Writeback {
+		return false
+	}
+	s := &rSlice{id, length, store}
+	store.pendingMutex.Lock()
+	defer store.pendingMutex.Unlock()
+	for _, key := range s.keys() {
+		if _, ok := store.pendingKeys[key]; ok {
+			return true
 		}
 	}
 	return false
@@ -1096,7 +1452,7 @@ func (store *cachedStore) scanDelayedStaging() {
 
 func (store *cachedStore) uploader() {
 	for it := range store.pendingCh {
-		store.uploadStagingFile(it.key, it.fpath)
+		store.uploadStagingFile(it.key, it.fpath, false)
 	}
 }
 
@@ -1123,6 +1479,10 @@ func (store *cachedStore) Remove(id uint64, length int) error {
 }
 
 func (store *cachedStore) FillCache(id uint64, length uint32) error {
+	return store.fillCache(id, length, true)
+}
+
+func (store *cachedStore) fillCache(id uint64, length uint32, peers bool) error {
 	r := sliceForRead(id, int(length), store)
 	keys := r.keys()
 	var err error
@@ -1135,8 +1495,11 @@ func (store *cachedStore) FillCache(id uint64, length uint32) error {
 			logger.Warnf("Invalid size: %s %d", k, size)
 			continue
 		}
+		if peers && store.fillPeer(k) { // cached by the owner in the cache group
+			continue
+		}
 		p := NewOffPage(size)
-		if e := store.load(k, p, true, true); e != nil {
+		if e := store.load(context.Background(), k, p, true, true); e != nil {
 			logger.Warnf("Failed to load key: %s %s", k, e)
 			err = e
 		}
@@ -1145,6 +1508,18 @@ func (store *cachedStore) FillCache(id uint64, length uint32) error {
 	return err
 }
 
+// PinCache keeps the blocks of the slice in the local cache, pinning also loads them.
+func (store *cachedStore) PinCache(id uint64, length uint32, pinned bool) error {
+	r := sliceForRead(id, int(length), store)
+	for _, k := range r.keys() {
+		store.bcache.pin(k, pinned)
+	}
+	if !pinned {
+		return nil
+	}
+	return store.fillCache(id, length, false)
+}
+
 func (store *cachedStore) EvictCache(id uint64, length uint32) error {
 	r := sliceForRead(id, int(length), store)
 	keys := r.keys()
@@ -1168,6 +1543,104 @@ func (store *cachedStore) CheckCache(id uint64, length uint32, handler func(exis
 	return nil
 }
 
+// StatObjects gets the sizes of the blocks of a slice in the object storage (after compression and encryption),
+// handler is called for every block in order, with the size of the original data.
+func (store *cachedStore) StatObjects(id uint64, length uint32, handler func(key string, size int, stored int64, err error)) error {
+	r := sliceForRead(id, int(length), store)
+	for i, k := range r.keys() {
+		var o object.Object
+		start := time.Now()
+		err := utils.WithTimeout(func(ctx context.Context) (err error) {
+			o, err = store.storage.Head(ctx, k)
+			return err
+		}, store.conf.GetTimeout)
+		logRequest(context.Background(), "HEAD", k, "", "", err, time.Since(start))
+		var stored int64
+		if err == nil {
+			stored = o.Size()
+		}
+		handler(k, r.blockSize(i), stored, err)
+	}
+	return nil
+}
+
+// verifyBlock checks the data of a block read from the object storage against its original size and checksums.
+func (store *cachedStore) verifyBlock(key string, data []byte, size int) error {
+	buf := make([]byte, size)
+	if _, err := store.decode(buf, data); err != nil {
+		return err
+	}
+	return store.verifyChecksums(key, buf)
+}
+
+// Scrub reads the blocks of a slice from the object storage (bypassing the cache) and verifies them, handler is
+// called for every damaged block. The damaged blocks are repaired if the object storage keeps redundant copies.
+func (store *cachedStore) Scrub(id uint64, length uint32, handler func(key string, damaged error, repaired bool)) error {
+	r := sliceForRead(id, int(length), store)
+	var lastErr error
+	for i, k := range r.keys() {
+		size := r.blockSize(i)
+		verify := func(data []byte) error { return store.verifyBlock(k, data, size) }
+		store.throttle("GET", store.getLimit)
+		if store.downLimit != nil {
+			store.downLimit.Wait(int64(size))
+		}
+		if rs, ok := store.storage.(object.SupportRepair); ok {
+			n, err := rs.Repair(context.Background(), k, verify)
+			if err == nil {
+				if n > 0 {
+					handler(k, fmt.Errorf("%d copies are damaged", n), true)
+				}
+				continue
+			} else if !errors.Is(err, utils.ENOTSUP) {
+				logger.Warnf("Repair block %s: %s", k, err)
+				handler(k, err, false)
+				continue
+			}
+		}
+		var data []byte
+		var reqID string
+		start := time.Now()
+		err := utils.WithTimeout(func(ctx context.Context) error {
+			in, err := store.storage.Get(ctx, k, 0, -1, object.WithRequestID(&reqID))
+			if err == nil {
+				data, err = io.ReadAll(in)
+				_ = in.Close()
+			}
+			return err
+		}, store.conf.GetTimeout)
+		logRequest(context.Background(), "GET", k, "", reqID, err, time.Since(start))
+		if err == nil {
+			err = verify(data)
+		} else if !os.IsNotExist(err) {
+			lastErr = err // can not tell whether it's damaged
+			continue
+		}
+		if err != nil {
+			handler(k, err, false)
+		}
+	}
+	return lastErr
+}
+
+// decode decodes the raw data of a block read from the object storage into dst, which has the original size.
+func (store *cachedStore) decode(dst, raw []byte) (int, error) {
+	if store.compressor.CompressBound(len(dst)) > len(dst) {
+		n, err := store.compressor.Decompress(dst, raw)
+		if err != nil {
+			return 0, fmt.Errorf("decompress: %s", err)
+		}
+		if n != len(dst) {
+			return n, fmt.Errorf("decompressed size %d != %d", n, len(dst))
+		}
+		return n, nil
+	}
+	if len(raw) != len(dst) {
+		return 0, fmt.Errorf("size %d != %d", len(raw), len(dst))
+	}
+	return copy(dst, raw), nil
+}
+
 func (store *cachedStore) UsedMemory() int64 {
 	return store.bcache.usedMemory()
 }
@@ -1193,4 +1666,12 @@ func (store *cachedStore) UpdateLimit(upload, download int64) {
 	}
 }
 
+// UpdateCacheSize resizes the block cache, conf.CacheSize is kept since the cache can't be disabled at runtime.
+func (store *cachedStore) UpdateCacheSize(size uint64) {
+	if old := store.cacheSize.Swap(size); old != size {
+		logger.Infof("Cache size changed from %d to %d", old, size)
+		store.bcache.resize(int64(size))
+	}
+}
+
 var _ ChunkStore = (*cachedStore)(nil)
@@ -20,8 +20,19 @@ package chunk
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -161,6 +172,27 @@ func TestStoreLimited(t *testing.T) {
 	testStore(t, store)
 }
 
+func TestStoreRequestLimited(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.PutLimit = 5
+	conf.GetLimit = 5
+	store := NewCachedStore(mem, conf, nil)
+	testStore(t, store)
+
+	s := store.(*cachedStore)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		s.throttle("GET", s.getLimit)
+	}
+	if used := time.Since(start); used < time.Millisecond*500 {
+		t.Fatalf("10 requests should take at least 0.5s with limit 5/s, but got %s", used)
+	}
+	if n := toFloat64(s.objectReqThrottled.WithLabelValues("GET")); n == 0 {
+		t.Fatalf("throttled requests should be counted")
+	}
+}
+
 func TestStoreFull(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "", "")
 	conf := defaultConf
@@ -267,6 +299,47 @@ func TestStoreDelayed(t *testing.T) {
 	}
 }
 
+func TestPersist(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.CacheDir = t.TempDir()
+	conf.Writeback = true
+	conf.WritebackDurable = true
+	conf.FsyncUpload = true
+	conf.UploadDelay = time.Hour
+	store := NewCachedStore(mem, conf, nil)
+	w := store.NewWriter(11)
+	if _, err := w.WriteAt(make([]byte, 5<<20), 0); err != nil {
+		t.Fatalf("write fail: %s", err)
+	}
+	if err := w.Finish(5 << 20); err != nil {
+		t.Fatalf("write fail: %s", err)
+	}
+	if _, err := mem.Head(ctx, "chunks/0/0/11_0_1048576"); err == nil {
+		t.Fatalf("staging block should not be uploaded yet")
+	}
+	if !store.Staged(11, 5<<20) {
+		t.Fatalf("slice 11 should be staged")
+	}
+	if err := store.Persist(11, 5<<20); err != nil {
+		t.Fatalf("persist slice 11: %s", err)
+	}
+	if store.Staged(11, 5<<20) {
+		t.Fatalf("slice 11 should not be staged after persisted")
+	}
+	for _, key := range sliceForRead(11, 5<<20, store.(*cachedStore)).keys() {
+		if _, err := mem.Head(ctx, key); err != nil {
+			t.Fatalf("head object %s: %s", key, err)
+		}
+		if _, err := os.Stat(filepath.Join(conf.CacheDir, stagingDir, key)); !os.IsNotExist(err) {
+			t.Fatalf("staging block %s should be removed: %v", key, err)
+		}
+	}
+	if err := store.Persist(11, 5<<20); err != nil {
+		t.Fatalf("persist slice 11 again: %s", err)
+	}
+}
+
 func TestStoreMultiBuckets(t *testing.T) {
 	mem, _ := object.CreateStorage("mem", "", "", "", "")
 	conf := defaultConf
@@ -339,6 +412,526 @@ func TestFillCache(t *testing.T) {
 	err = store.CheckCache(11, uint32(bsize), handler)
 	assert.Nil(t, err)
 	assert.Equal(t, uint64(bsize), missBytes)
+
+	// shrink the cache
+	for id := uint64(20); id < 40; id++ {
+		if err := forgetSlice(store, id, 1024); err != nil {
+			t.Fatalf("forge slice %d 1024: %s", id, err)
+		}
+		defer store.Remove(id, 1024)
+	}
+	time.Sleep(time.Millisecond * 100) // waiting for flush
+	store.UpdateCacheSize(50 << 10)
+	if cnt, used := bcache.stats(); cnt == 0 || used > 50<<10 {
+		t.Fatalf("cache cnt %d used %d, expect used <= %d", cnt, used, 50<<10)
+	}
+}
+
+func TestPinCache(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.CacheSize = 10 << 20
+	conf.FreeSpace = 0.01
+	conf.CacheDir = t.TempDir()
+	store := NewCachedStore(mem, conf, nil)
+	bsize := conf.BlockSize
+	for _, id := range []uint64{11, 12} {
+		if err := forgetSlice(store, id, bsize); err != nil {
+			t.Fatalf("forge slice %d %d: %s", id, bsize, err)
+		}
+		defer store.Remove(id, bsize)
+	}
+	time.Sleep(time.Millisecond * 100) // waiting for flush
+
+	// not cached by the reads without cache
+	r := store.NewReader(12, bsize)
+	p := NewPage(make([]byte, bsize))
+	if n, err := r.ReadAt(WithoutCache(context.Background()), p, 0); err != nil || n != bsize {
+		t.Fatalf("read slice 12: %d %s", n, err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	bcache := store.(*cachedStore).bcache
+	if cnt, _ := bcache.stats(); cnt != 0 {
+		t.Fatalf("cache cnt %d, expect 0", cnt)
+	}
+
+	if err := store.PinCache(11, uint32(bsize), true); err != nil {
+		t.Fatalf("pin cache 11: %s", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if cnt, _ := bcache.stats(); cnt != 1 {
+		t.Fatalf("cache cnt %d, expect 1", cnt)
+	}
+	cs := bcache.(*cacheManager).stores[0]
+	key := sliceForRead(11, bsize, store.(*cachedStore)).key(0)
+	assert.Contains(t, cs.pinned, cs.getCacheKey(key))
+	assert.Nil(t, store.PinCache(11, uint32(bsize), false))
+	assert.Empty(t, cs.pinned)
+}
+
+func TestScrub(t *testing.T) {
+	d1, d2 := t.TempDir()+"/", t.TempDir()+"/"
+	rep, _ := object.NewReplicated("file:"+d1+",file:"+d2, "", "", "")
+	conf := defaultConf
+	conf.Compress = "lz4"
+	_ = os.RemoveAll(conf.CacheDir)
+	store := NewCachedStore(rep, conf, nil)
+	bsize := conf.BlockSize
+	if err := forgetSlice(store, 12, bsize+1024); err != nil {
+		t.Fatalf("forge slice 12: %s", err)
+	}
+	defer store.Remove(12, bsize+1024)
+
+	var damaged []string
+	handler := func(key string, err error, repaired bool) {
+		if !repaired {
+			t.Fatalf("block %s is not repaired: %s", key, err)
+		}
+		damaged = append(damaged, key)
+	}
+	if err := store.Scrub(12, uint32(bsize+1024), handler); err != nil || len(damaged) != 0 {
+		t.Fatalf("scrub healthy slice: %v %s", damaged, err)
+	}
+	replica, _ := object.CreateStorage("file", d2, "", "", "")
+	_ = replica.Put(ctx, "chunks/0/0/12_0_1048576", bytes.NewReader([]byte("broken")))
+	_ = replica.Delete(ctx, "chunks/0/0/12_1_1024")
+	if err := store.Scrub(12, uint32(bsize+1024), handler); err != nil || len(damaged) != 2 {
+		t.Fatalf("scrub damaged slice: %v %s", damaged, err)
+	}
+	if err := store.Scrub(12, uint32(bsize+1024), handler); err != nil || len(damaged) != 2 {
+		t.Fatalf("scrub repaired slice: %v %s", damaged, err)
+	}
+
+	// without redundancy
+	single, _ := object.CreateStorage("mem", "", "", "", "")
+	store = NewCachedStore(single, conf, nil)
+	if err := forgetSlice(store, 13, 1024); err != nil {
+		t.Fatalf("forge slice 13: %s", err)
+	}
+	_ = single.Put(ctx, "chunks/0/0/13_0_1024", bytes.NewReader([]byte("broken")))
+	var found int
+	if err := store.Scrub(13, 1024, func(key string, err error, repaired bool) {
+		if repaired {
+			t.Fatalf("block %s should not be repaired", key)
+		}
+		found++
+	}); err != nil || found != 1 {
+		t.Fatalf("scrub without redundancy: %d %s", found, err)
+	}
+}
+
+func TestStatObjects(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.Compress = "lz4"
+	store := NewCachedStore(mem, conf, nil)
+	bsize := conf.BlockSize
+	if err := forgetSlice(store, 14, bsize+1024); err != nil {
+		t.Fatalf("forge slice 14: %s", err)
+	}
+	_ = mem.Delete(ctx, "chunks/0/0/14_1_1024")
+	var sizes []int
+	var missing int
+	if err := store.StatObjects(14, uint32(bsize+1024), func(key string, size int, stored int64, err error) {
+		sizes = append(sizes, size)
+		if err != nil {
+			missing++
+		} else if stored <= 0 || stored >= int64(size) {
+			t.Fatalf("block %s of %d bytes is stored in %d bytes", key, size, stored)
+		}
+	}); err != nil {
+		t.Fatalf("stat objects: %s", err)
+	}
+	if len(sizes) != 2 || sizes[0] != bsize || sizes[1] != 1024 || missing != 1 {
+		t.Fatalf("stat objects: %v, missing %d", sizes, missing)
+	}
+}
+
+type memChecksums map[uint64][]uint32
+
+func (m memChecksums) SaveChecksums(id uint64, sums []uint32) error {
+	m[id] = sums
+	return nil
+}
+
+func (m memChecksums) LoadChecksums(id uint64) ([]uint32, error) {
+	return m[id], nil
+}
+
+func TestBlockChecksum(t *testing.T) {
+	size := 3*checksumSegment + 1024
+	for _, algo := range []string{"none", "lz4"} {
+		mem, _ := object.CreateStorage("mem", "", "", "", "")
+		sums := make(memChecksums)
+		conf := defaultConf
+		conf.Compress = algo
+		conf.CacheSize = 0
+		legacy := NewCachedStore(mem, conf, nil)
+		conf.BlockChecksum = true
+		store := NewCachedStore(mem, conf, nil)
+		store.SetChecksumStore(sums)
+		read := func(s ChunkStore, id uint64, off, n int) error {
+			p := NewPage(make([]byte, n))
+			defer p.Release()
+			_, err := s.NewReader(id, size).ReadAt(ctx, p, off)
+			if err == nil && !bytes.Equal(p.Data, bytes.Repeat([]byte{0x41}, n)) {
+				err = errors.New("unexpected data")
+			}
+			return err
+		}
+
+		if err := forgetSlice(legacy, 20, size); err != nil {
+			t.Fatalf("write legacy slice: %s", err)
+		}
+		if err := read(store, 20, 0, size); err != nil {
+			t.Fatalf("%s: read legacy block: %s", algo, err)
+		}
+		if err := forgetSlice(store, 21, size); err != nil {
+			t.Fatalf("write slice: %s", err)
+		}
+		if len(sums[21]) != 4 {
+			t.Fatalf("%s: checksums of slice: %v", algo, sums[21])
+		}
+		if err := read(store, 21, 0, size); err != nil {
+			t.Fatalf("%s: read block with checksum: %s", algo, err)
+		}
+		if err := read(store, 21, checksumSegment+100, 1000); err != nil {
+			t.Fatalf("%s: read part of block with checksum: %s", algo, err)
+		}
+
+		key := fmt.Sprintf("chunks/0/0/21_0_%d", size)
+		in, err := mem.Get(ctx, key, 0, -1)
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		data, err := io.ReadAll(in)
+		_ = in.Close()
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		if algo == "none" {
+			data[2*checksumSegment+10] ^= 0x20
+		} else {
+			data = bytes.Repeat([]byte{0x41}, size)
+			data[2*checksumSegment+10] ^= 0x20
+			buf := make([]byte, store.(*cachedStore).compressor.CompressBound(size))
+			n, _ := store.(*cachedStore).compressor.Compress(buf, data)
+			data = buf[:n]
+		}
+		_ = mem.Put(ctx, key, bytes.NewReader(data))
+		if err := read(store, 21, 0, size); err == nil {
+			t.Fatalf("%s: damaged block should not be read", algo)
+		}
+		if algo == "none" {
+			if err := read(store, 21, 10, 1000); err != nil {
+				t.Fatalf("%s: read undamaged part of block: %s", algo, err)
+			}
+			if err := read(store, 21, 2*checksumSegment, 100); err == nil {
+				t.Fatalf("%s: damaged part of block should not be read", algo)
+			}
+		}
+		if err := store.(*cachedStore).verifyBlock(key, data, size); err == nil {
+			t.Fatalf("%s: damaged block should not be verified", algo)
+		}
+	}
+
+	// cached blocks are verified
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.CacheDir = "memory"
+	conf.BlockChecksum = true
+	store := NewCachedStore(mem, conf, nil).(*cachedStore)
+	store.SetChecksumStore(make(memChecksums))
+	if err := forgetSlice(store, 22, 1024); err != nil {
+		t.Fatalf("write slice: %s", err)
+	}
+	key := "chunks/0/0/22_0_1024"
+	damaged := bytes.Repeat([]byte{0x41}, 1024)
+	damaged[100] = 0
+	store.bcache.remove(key, false)
+	store.bcache.cache(key, NewPage(damaged), true, false)
+	p := NewPage(make([]byte, 1024))
+	defer p.Release()
+	if _, err := store.NewReader(22, 1024).ReadAt(ctx, p, 0); err != nil || p.Data[100] != 0x41 {
+		t.Fatalf("read damaged cached block: %v %x", err, p.Data[100])
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.CacheDir = t.TempDir()
+	conf.CacheTiers = []CacheTier{{Dir: "memory", Size: 10 << 20}}
+	conf.SelfCheck("test")
+	store := NewCachedStore(mem, conf, nil).(*cachedStore)
+	tiered := store.bcache.(*tieredCache)
+	if err := forgetSlice(store, 30, 1024); err != nil {
+		t.Fatalf("write slice: %s", err)
+	}
+	key := "chunks/0/0/30_0_1024"
+	for i := 0; i < 50; i++ {
+		if _, ok := tiered.bottom().exist(key); ok {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if _, ok := tiered.tiers[0].exist(key); ok {
+		t.Fatalf("block %s should not be in the upper tier", key)
+	}
+	for i := 0; i < promoteHits; i++ {
+		p := NewPage(make([]byte, 1024))
+		if n, err := store.NewReader(30, 1024).ReadAt(ctx, p, 0); n != 1024 || err != nil {
+			t.Fatalf("read: %d %s", n, err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if _, ok := tiered.tiers[0].exist(key); ok {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if loc, ok := tiered.exist(key); !ok || loc != "memory" {
+		t.Fatalf("block %s is not promoted: %s %v", key, loc, ok)
+	}
+	m := tiered.metrics
+	if hits, miss := toFloat64(m.tierHits.WithLabelValues("1")), toFloat64(m.tierMiss.WithLabelValues("0")); hits != promoteHits || miss != promoteHits {
+		t.Fatalf("expect %d hits from tier 1 and %d miss from tier 0, but got %v and %v", promoteHits, promoteHits, hits, miss)
+	}
+	tiered.remove(key, false)
+	if _, ok := tiered.exist(key); ok {
+		t.Fatalf("block %s should be removed from all the tiers", key)
+	}
+}
+
+func TestCacheEncrypt(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	keyEnc, err := object.NewDataEncryptor(object.NewRSAEncryptor(privKey), object.AES256GCM_RSA)
+	require.Nil(t, err)
+
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := defaultConf
+	conf.CacheDir = t.TempDir()
+	conf.CacheEncrypt = true
+	conf.SelfCheck("test")
+	store := NewCachedStore(mem, conf, nil).(*cachedStore)
+	if err := forgetSlice(store, 50, 1024); err != nil {
+		t.Fatalf("write slice: %s", err)
+	}
+	key := "chunks/0/0/50_0_1024"
+	path := filepath.Join(store.conf.CacheDir, cacheDir, key)
+	cs := store.bcache.(*cacheManager).stores[0]
+	for i := 0; i < 50; i++ {
+		cs.Lock()
+		flushed := len(cs.pages) == 0
+		cs.Unlock()
+		if _, err := os.Stat(path); err == nil && flushed {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	raw, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.True(t, bytes.HasPrefix(raw, cipherMagic))
+	require.False(t, bytes.Contains(raw, bytes.Repeat([]byte{0x41}, 64)))
+	p := NewPage(make([]byte, 1024))
+	if n, err := store.NewReader(50, 1024).ReadAt(ctx, p, 0); n != 1024 || err != nil {
+		t.Fatalf("read: %d %s", n, err)
+	}
+	require.Equal(t, bytes.Repeat([]byte{0x41}, 1024), p.Data)
+
+	// cached blocks of other mounts can not be decrypted, while the staging ones can
+	data := bytes.Repeat([]byte{0x42}, 1024)
+	c1, err := newCacheCipher(keyEnc)
+	require.Nil(t, err)
+	c2, err := newCacheCipher(keyEnc)
+	require.Nil(t, err)
+	dir := t.TempDir()
+	for _, staging := range []bool{false, true} {
+		buf := make([]byte, c1.sealedSize(len(data), staging))
+		sealed, err := c1.seal(buf, data, staging)
+		require.Nil(t, err)
+		require.Equal(t, len(buf), len(sealed))
+		name := filepath.Join(dir, fmt.Sprintf("%v_0_1024", staging))
+		require.Nil(t, os.WriteFile(name, sealed, 0600))
+		for _, c := range []*cacheCipher{c1, c2} {
+			r, err := openBlockFile(name, len(data), CsNone, c)
+			if c == c2 && !staging {
+				require.ErrorIs(t, err, errUnknownCacheKey)
+				continue
+			}
+			require.Nil(t, err)
+			got := make([]byte, len(data))
+			_, err = r.ReadAt(got, 0)
+			require.Nil(t, err)
+			require.Equal(t, data, got)
+			_ = r.Close()
+		}
+	}
+	c3, _ := newCacheCipher(nil)
+	_, err = c3.seal(make([]byte, c3.sealedSize(len(data), true)), data, true)
+	require.NotNil(t, err)
+
+	// plaintext blocks are read only if they are written before the cache dir is marked as encrypted
+	plain := filepath.Join(dir, "plain_0_1024")
+	require.Nil(t, os.WriteFile(plain, data, 0600))
+	_, err = openBlockFile(plain, len(data), CsNone, c1)
+	require.ErrorIs(t, err, errPlainCache)
+	require.Nil(t, os.Chtimes(plain, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+	require.Nil(t, c1.markDir(dir))
+	r, err := openBlockFile(plain, len(data), CsNone, c1)
+	require.Nil(t, err)
+	_ = r.Close()
+	require.Nil(t, os.WriteFile(plain, data, 0600))
+	require.Nil(t, os.Chtimes(plain, time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+	_, err = openBlockFile(plain, len(data), CsNone, c1)
+	require.ErrorIs(t, err, errPlainCache)
+
+	// the plaintext cached block written by another client is dropped
+	cachedPath := filepath.Join(store.conf.CacheDir, cacheDir, "chunks/0/0/51_0_1024")
+	require.Nil(t, os.MkdirAll(filepath.Dir(cachedPath), 0700))
+	require.Nil(t, os.WriteFile(cachedPath, data, 0600))
+	cs.add("chunks/0/0/51_0_1024", 1024, uint32(time.Now().Unix()))
+	_, err = cs.load("chunks/0/0/51_0_1024")
+	require.NotNil(t, err)
+	_, err = os.Stat(cachedPath)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(store.conf.CacheDir, cipherMarker))
+	require.Nil(t, err)
+}
+
+// writeGroupCert writes a self-signed certificate and its key for the members of cache group.
+func writeGroupCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "juicefs-group"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "group.crt"), filepath.Join(dir, "group.key")
+	require.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600))
+	return certFile, keyFile
+}
+
+func TestCacheGroup(t *testing.T) {
+	mem, _ := object.CreateStorage("mem", "", "", "", "")
+	certFile, keyFile := writeGroupCert(t)
+	groupConf := func() Config {
+		conf := defaultConf
+		conf.CacheDir = t.TempDir()
+		conf.CacheGroup = "test"
+		conf.GroupListen = "127.0.0.1:0"
+		conf.GroupSecret = "secret"
+		conf.GroupCert, conf.GroupKey = certFile, keyFile
+		return conf
+	}
+	newMember := func() *cachedStore {
+		conf := groupConf()
+		conf.SelfCheck("test")
+		return NewCachedStore(mem, conf, nil).(*cachedStore)
+	}
+	for _, modify := range []func(c *Config){
+		func(c *Config) { c.GroupSecret = "" },
+		func(c *Config) { c.GroupListen = ":0" },
+		func(c *Config) { c.GroupListen = "0.0.0.0:0" },
+		func(c *Config) { c.GroupKey = "" },
+	} {
+		conf := groupConf()
+		modify(&conf)
+		if conf.SelfCheck("test"); conf.CacheGroup != "" {
+			t.Fatalf("cache group should be disabled: %+v", conf)
+		}
+	}
+	a, b := newMember(), newMember()
+	members := []string{a.GroupAddr(), b.GroupAddr()}
+	a.UpdateMembers(members)
+	b.UpdateMembers(members)
+
+	var id uint64
+	var key string
+	for id = 40; ; id++ {
+		if key = fmt.Sprintf("chunks/0/0/%d_0_1024", id); a.peers.owner(key) == a.GroupAddr() {
+			break
+		}
+	}
+	if b.peers.owner(key) != a.GroupAddr() {
+		t.Fatalf("members disagree on the owner of %s", key)
+	}
+	if err := forgetSlice(a, id, 1024); err != nil {
+		t.Fatalf("write slice: %s", err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, ok := a.bcache.exist(key); ok {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	_ = mem.Delete(ctx, key) // only the owner has it now
+	p := NewPage(make([]byte, 1024))
+	if n, err := b.NewReader(id, 1024).ReadAt(ctx, p, 0); n != 1024 || err != nil {
+		t.Fatalf("read from the group: %d %s", n, err)
+	}
+	if !bytes.Equal(p.Data, bytes.Repeat([]byte{0x41}, 1024)) {
+		t.Fatalf("unexpected data from the group")
+	}
+	if _, ok := b.bcache.exist(key); ok {
+		t.Fatalf("block %s should be cached only by the owner", key)
+	}
+
+	// warm up on the owner
+	for id++; ; id++ {
+		if key = fmt.Sprintf("chunks/0/0/%d_0_1024", id); a.peers.owner(key) == a.GroupAddr() {
+			break
+		}
+	}
+	if err := forgetSlice(b, id, 1024); err != nil {
+		t.Fatalf("write slice: %s", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	_ = b.EvictCache(id, 1024)
+	if err := b.FillCache(id, 1024); err != nil {
+		t.Fatalf("warm up slice %d: %s", id, err)
+	}
+	if _, ok := a.bcache.exist(key); !ok {
+		t.Fatalf("block %s should be cached by the owner", key)
+	}
+	if _, ok := b.bcache.exist(key); ok {
+		t.Fatalf("block %s should not be cached by the warming member", key)
+	}
+
+	if resp, err := http.Get("http://" + a.GroupAddr() + "/blocks/" + key); err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Fatalf("block should not be served over plain HTTP")
+		}
+	}
+	insecure := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	req, _ := http.NewRequest(http.MethodGet, "https://"+a.GroupAddr()+"/blocks/"+key, nil)
+	req.Header.Set(groupTokenName, b.peers.token)
+	if resp, err := insecure.Do(req); err == nil {
+		_ = resp.Body.Close()
+		t.Fatalf("block should not be served to a client without certificate: %s", resp.Status)
+	}
+
+	b.peers.token = "invalid"
+	p = NewPage(make([]byte, 1024))
+	if err := b.peers.fetch(a.GroupAddr(), key, p); err == nil {
+		t.Fatalf("fetch with invalid token should fail")
+	}
+	if b.peers.peer(key) != "" {
+		t.Fatalf("failed member should be skipped")
+	}
 }
 
 func BenchmarkCachedRead(b *testing.B) {
@@ -402,6 +995,6 @@ func TestStoreRetry(t *testing.T) {
 	cs := NewCachedStore(s, defaultConf, nil)
 	p := NewPage(nil)
 	defer p.Release()
-	cs.(*cachedStore).load("non", p, false, false) // wont retry
+	cs.(*cachedStore).load(context.Background(), "non", p, false, false) // wont retry
 	require.Equal(t, int32(1), s.cnt)
 }
@@ -30,6 +30,7 @@ type Writer interface {
 	ID() uint64
 	SetID(id uint64)
 	SetWriteback(enabled bool)
+	SetCache(enabled bool)
 	FlushTo(offset int) error
 	Finish(length int) error
 	Abort()
@@ -40,8 +41,15 @@ type ChunkStore interface {
 	NewWriter(id uint64) Writer
 	Remove(id uint64, length int) error
 	FillCache(id uint64, length uint32) error
+	PinCache(id uint64, length uint32, pinned bool) error
 	EvictCache(id uint64, length uint32) error
 	CheckCache(id uint64, length uint32, handler func(exists bool, loc string, size int)) error
+	Scrub(id uint64, length uint32, handler func(key string, damaged error, repaired bool)) error
+	StatObjects(id uint64, length uint32, handler func(key string, size int, stored int64, err error)) error
+	Persist(id uint64, length int) error
+	Staged(id uint64, length int) bool
 	UsedMemory() int64
 	UpdateLimit(upload, download int64)
+	UpdateCacheSize(size uint64)
+	SetChecksumStore(cs ChecksumStore)
 }
@@ -0,0 +1,606 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	devMagic     = "JFSCDEV1"
+	devSlabMagic = "JFSSLAB1"
+	devSlotMagic = "JFSB"
+	devSector    = 4 << 10
+	devFirstSlab = 1 << 20  // offset of the first slab, after the super block
+	devSlabSize  = 64 << 20 // should hold a few slots of the largest blocks (16 MiB)
+	devMinClass  = 16       // 64 KiB
+	devMaxKey    = 256
+	// header of slot: magic | epoch | size | checksum | generation (8 bytes) | length of key (2 bytes) | key
+	devSlotHeader = 26
+)
+
+var errSlotReused = errors.New("cache slot is reused")
+
+// signatures of the file systems and partition tables that should never be overwritten: offset, magic, name
+var devSignatures = []struct {
+	off   int64
+	magic string
+	name  string
+}{
+	{0, "XFSB", "xfs"},
+	{0, "LUKS\xba\xbe", "LUKS"},
+	{0, "hsqs", "squashfs"},
+	{3, "NTFS    ", "ntfs"},
+	{510, "\x55\xaa", "MBR or FAT"},
+	{512, "EFI PART", "GPT"},
+	{536, "LVM2 001", "LVM2"},
+	{1080, "\x53\xef", "ext2/3/4"},
+	{4086, "SWAPSPACE2", "swap"},
+	{32769, "CD001", "iso9660"},
+	{65600, "_BHRfS_M", "btrfs"},
+}
+
+// devSignature returns the name of the file system or partition table found in the device, if any.
+func devSignature(f *os.File) string {
+	for _, sig := range devSignatures {
+		buf := make([]byte, len(sig.magic))
+		if n, _ := f.ReadAt(buf, sig.off); n == len(buf) && string(buf) == sig.magic {
+			return sig.name
+		}
+	}
+	return ""
+}
+
+// devCache keeps the cached blocks in a raw block device (or a large file) with its own layout, which avoids the
+// overhead of the file system and the exhaustion of inodes by millions of small files.
+//
+// The device starts with a super block, followed by the slabs of devSlabSize bytes. A slab is split into slots of
+// the same size (a sector for the header and a power of two bytes for the data) once it's used, and a block is
+// written into the smallest slot that fits it. The header of a slot (key, size and checksum of the block) is
+// written after the data, so the index can be rebuilt by scanning the headers after restart.
+type devCache struct {
+	sync.Mutex
+	path     string
+	dev      *os.File
+	gen      uint64          // generation of the layout, to ignore the slabs written before it's formatted
+	slabs    []*devSlab      // all the slabs in the device
+	free     []int32         // slabs not used yet
+	avail    map[int][]int32 // slabs having free slots by the class (could be stale)
+	byClass  map[int][]int32 // slabs used by the class
+	index    map[string]*devEntry
+	used     int64
+	pending  chan pendingFile
+	pages    map[string]*Page
+	eviction string
+	metrics  *cacheManagerMetrics
+}
+
+type devSlab struct {
+	class int      // log2 of the data size of the slots, 0 means not used yet
+	epoch uint32   // bumped when the slab is used by another class, to ignore the stale slot headers
+	keys  []string // key of the block in every slot, empty means free
+	vers  []uint32 // bumped when the slot is reused, to detect the stale readers
+	nfree int
+	avail bool
+	// number of slots being written, the slab can't be evicted as a whole until the writes are done
+	writing int
+}
+
+type devEntry struct {
+	slab, slot int32
+	size       int32
+	crc        uint32
+	atime      uint32
+}
+
+func devClass(size int) int {
+	c := bits.Len(uint(size - 1))
+	if c < devMinClass {
+		c = devMinClass
+	}
+	return c
+}
+
+func devSlotSize(class int) int64 { return devSector + 1<<class }
+
+func devSlots(class int) int { return int((devSlabSize - devSector) / devSlotSize(class)) }
+
+func (c *devCache) slabOff(slab int32) int64 { return devFirstSlab + int64(slab)*devSlabSize }
+
+// slotOff returns the offset of the header of the slot, the data follows it.
+func (c *devCache) slotOff(slab, slot int32) int64 {
+	return c.slabOff(slab) + devSector + int64(slot)*devSlotSize(c.slabs[slab].class)
+}
+
+func newDevCache(config *Config, metrics *cacheManagerMetrics) (*devCache, error) {
+	f, err := os.OpenFile(config.CacheDev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockDevice(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock %s: %s", config.CacheDev, err)
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if int64(config.CacheSize) < size {
+		size = int64(config.CacheSize)
+	}
+	n := (size - devFirstSlab) / devSlabSize
+	if n < 1 {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s is too small (%s) for caching", config.CacheDev, humanize.IBytes(uint64(size)))
+	}
+	pendingPages := int(config.BufferSize) * 2 / 10 / config.BlockSize
+	c := &devCache{
+		path:     config.CacheDev,
+		dev:      f,
+		slabs:    make([]*devSlab, n),
+		avail:    make(map[int][]int32),
+		byClass:  make(map[int][]int32),
+		index:    make(map[string]*devEntry),
+		pending:  make(chan pendingFile, pendingPages),
+		pages:    make(map[string]*Page),
+		eviction: config.CacheEviction,
+		metrics:  metrics,
+	}
+	for i := range c.slabs {
+		c.slabs[i] = &devSlab{}
+	}
+	start := time.Now()
+	if err = c.scan(config.uuid, config.CacheDevFormat); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	logger.Infof("Device cache (%s): %d slabs (%s), found %d cached blocks (%s) with %s", c.path, n,
+		humanize.IBytes(uint64(n*devSlabSize)), len(c.index), humanize.IBytes(uint64(c.used)), time.Since(start))
+	go c.flush()
+	return c, nil
+}
+
+// scan rebuilds the index from the headers. If the device is not used by the volume before, it's formatted only
+// when asked explicitly, and never if it holds a file system or partition table.
+func (c *devCache) scan(uuid string, format bool) error {
+	sb := make([]byte, devSector)
+	if _, err := c.dev.ReadAt(sb, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("read super block: %s", err)
+	}
+	ul := int(binary.BigEndian.Uint16(sb[24:]))
+	if !bytes.Equal(sb[:8], []byte(devMagic)) || binary.BigEndian.Uint64(sb[16:]) != devSlabSize ||
+		ul > devSector-26 || string(sb[26:26+ul]) != uuid {
+		if !bytes.Equal(sb[:8], []byte(devMagic)) {
+			if sig := devSignature(c.dev); sig != "" {
+				return fmt.Errorf("%s holds %s, refuse to format it", c.path, sig)
+			}
+		}
+		if !format {
+			return fmt.Errorf("%s is not formatted for caching blocks of volume %s, use --cache-dev-format to format it", c.path, uuid)
+		}
+		logger.Infof("Format %s for caching blocks of volume %s", c.path, uuid)
+		c.gen = rand.Uint64()
+		sb = make([]byte, devSector)
+		copy(sb, devMagic)
+		binary.BigEndian.PutUint64(sb[8:], c.gen)
+		binary.BigEndian.PutUint64(sb[16:], devSlabSize)
+		binary.BigEndian.PutUint16(sb[24:], uint16(len(uuid)))
+		copy(sb[26:], uuid)
+		if _, err := c.dev.WriteAt(sb, 0); err != nil {
+			return fmt.Errorf("write super block: %s", err)
+		}
+		for i := len(c.slabs) - 1; i >= 0; i-- {
+			c.free = append(c.free, int32(i))
+		}
+		return nil
+	}
+	c.gen = binary.BigEndian.Uint64(sb[8:])
+
+	hdr := make([]byte, 32)
+	slot := make([]byte, devSlotHeader+devMaxKey)
+	for i := len(c.slabs) - 1; i >= 0; i-- {
+		s := c.slabs[i]
+		_, err := c.dev.ReadAt(hdr, c.slabOff(int32(i)))
+		class := int(hdr[16])
+		if err != nil || !bytes.Equal(hdr[:8], []byte(devSlabMagic)) || binary.BigEndian.Uint64(hdr[8:]) != c.gen ||
+			class < devMinClass || class > 24 {
+			c.free = append(c.free, int32(i))
+			continue
+		}
+		c.use(int32(i), class, binary.BigEndian.Uint32(hdr[17:]))
+		for j := range s.keys {
+			if _, err = c.dev.ReadAt(slot, c.slotOff(int32(i), int32(j))); err != nil {
+				continue
+			}
+			size := int32(binary.BigEndian.Uint32(slot[8:]))
+			kl := int(binary.BigEndian.Uint16(slot[24:]))
+			if !bytes.Equal(slot[:4], []byte(devSlotMagic)) || binary.BigEndian.Uint32(slot[4:]) != s.epoch ||
+				binary.BigEndian.Uint64(slot[16:]) != c.gen || size <= 0 || size > 1<<class || kl == 0 || kl > devMaxKey {
+				continue
+			}
+			key := string(slot[devSlotHeader : devSlotHeader+kl])
+			if _, ok := c.index[key]; ok || parseObjOrigSize(key) != int(size) {
+				continue
+			}
+			s.keys[j] = key
+			s.nfree--
+			c.index[key] = &devEntry{int32(i), int32(j), size, binary.BigEndian.Uint32(slot[12:]), uint32(time.Now().Unix())}
+			c.used += int64(size)
+		}
+		if s.nfree > 0 {
+			s.avail = true
+			c.avail[class] = append(c.avail[class], int32(i))
+		}
+	}
+	return nil
+}
+
+// use assigns the slab to the class, locked
+func (c *devCache) use(slab int32, class int, epoch uint32) {
+	s := c.slabs[slab]
+	n := devSlots(class)
+	s.class, s.epoch = class, epoch
+	s.keys = make([]string, n)
+	s.vers = make([]uint32, n)
+	s.nfree = n
+	c.byClass[class] = append(c.byClass[class], slab)
+}
+
+// release frees the slot, locked
+func (c *devCache) release(slab, slot int32) {
+	s := c.slabs[slab]
+	if key := s.keys[slot]; key != "" {
+		if e, ok := c.index[key]; ok && e.slab == slab && e.slot == slot {
+			c.used -= int64(e.size)
+			delete(c.index, key)
+		}
+	}
+	s.keys[slot] = ""
+	s.vers[slot]++
+	s.nfree++
+	if !s.avail {
+		s.avail = true
+		c.avail[s.class] = append(c.avail[s.class], slab)
+	}
+}
+
+// evict frees a slot of the class, or a whole slab of another class if there is none, locked
+func (c *devCache) evict(class int) bool {
+	if c.eviction == EvictionNone {
+		return false
+	}
+	if slabs := c.byClass[class]; len(slabs) > 0 {
+		// for two random slots, evict the older one (the slots being written are not indexed yet)
+		var victim *devEntry
+		for i := 0; i < 4 && (victim == nil || i < 2); i++ {
+			slab := slabs[rand.Intn(len(slabs))]
+			s := c.slabs[slab]
+			if key := s.keys[rand.Intn(len(s.keys))]; key != "" {
+				if e := c.index[key]; e != nil && (victim == nil || e.atime < victim.atime) {
+					victim = e
+				}
+			}
+		}
+		if victim != nil {
+			c.metrics.evicted(int64(victim.size))
+			c.release(victim.slab, victim.slot)
+			return true
+		}
+	}
+	var most int
+	for cl, slabs := range c.byClass {
+		if len(slabs) > len(c.byClass[most]) && (cl != class || len(slabs) > 1) {
+			most = cl
+		}
+	}
+	slabs := c.byClass[most]
+	if len(slabs) == 0 {
+		return false
+	}
+	i, start := -1, rand.Intn(len(slabs))
+	for j := range slabs {
+		if k := (start + j) % len(slabs); c.slabs[slabs[k]].writing == 0 {
+			i = k
+			break
+		}
+	}
+	if i < 0 {
+		return false // all the slabs are being written
+	}
+	slab := slabs[i]
+	c.byClass[most] = append(slabs[:i], slabs[i+1:]...)
+	s := c.slabs[slab]
+	for j, key := range s.keys {
+		if key != "" {
+			var size int64
+			if e := c.index[key]; e != nil {
+				size = int64(e.size)
+			}
+			c.metrics.evicted(size)
+			c.release(slab, int32(j))
+		}
+	}
+	s.class, s.avail = 0, false
+	c.free = append(c.free, slab)
+	return true
+}
+
+// alloc reserves a slot for the block, it returns whether the header of the slab should be written, locked
+func (c *devCache) alloc(key string, size int) (int32, int32, bool, bool) {
+	class := devClass(size)
+	for try := 0; try < 3; try++ {
+		for len(c.avail[class]) > 0 {
+			list := c.avail[class]
+			slab := list[len(list)-1]
+			s := c.slabs[slab]
+			if s.class != class || s.nfree == 0 {
+				c.avail[class] = list[:len(list)-1]
+				if s.class == class {
+					s.avail = false
+				}
+				continue
+			}
+			for j, k := range s.keys {
+				if k == "" {
+					s.keys[j] = key
+					s.vers[j]++
+					s.nfree--
+					return slab, int32(j), false, true
+				}
+			}
+		}
+		if len(c.free) > 0 {
+			slab := c.free[len(c.free)-1]
+			c.free = c.free[:len(c.free)-1]
+			c.use(slab, class, c.slabs[slab].epoch+1)
+			s := c.slabs[slab]
+			s.keys[0] = key
+			s.vers[0]++
+			s.nfree--
+			if s.nfree > 0 {
+				s.avail = true
+				c.avail[class] = append(c.avail[class], slab)
+			}
+			return slab, 0, true, true
+		}
+		if !c.evict(class) {
+			break
+		}
+	}
+	return 0, 0, false, false
+}
+
+func (c *devCache) write(key string, data []byte) {
+	c.Lock()
+	if _, ok := c.index[key]; ok {
+		c.Unlock()
+		return
+	}
+	slab, slot, newSlab, ok := c.alloc(key, len(data))
+	if !ok {
+		c.Unlock()
+		logger.Debugf("Caching device is full (%s), drop %s (%d bytes)", c.path, key, len(data))
+		c.metrics.cacheDrops.Add(1)
+		return
+	}
+	s := c.slabs[slab]
+	s.writing++ // keep the slab from being reused by another class until the write is done
+	class, epoch, ver := s.class, s.epoch, s.vers[slot]
+	off := c.slotOff(slab, slot)
+	c.Unlock()
+
+	start := time.Now()
+	c.metrics.cacheWrites.Add(1)
+	c.metrics.cacheWriteBytes.Add(float64(len(data)))
+	var err error
+	if newSlab {
+		hdr := make([]byte, devSector)
+		copy(hdr, devSlabMagic)
+		binary.BigEndian.PutUint64(hdr[8:], c.gen)
+		hdr[16] = byte(class)
+		binary.BigEndian.PutUint32(hdr[17:], epoch)
+		_, err = c.dev.WriteAt(hdr, c.slabOff(slab))
+	}
+	hdr := make([]byte, devSlotHeader+len(key))
+	if err == nil {
+		_, err = c.dev.WriteAt(hdr[:4], off) // invalidate the previous block
+	}
+	if err == nil {
+		_, err = c.dev.WriteAt(data, off+devSector)
+	}
+	sum := crc32.Checksum(data, crc32c)
+	copy(hdr, devSlotMagic)
+	binary.BigEndian.PutUint32(hdr[4:], epoch)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[12:], sum)
+	binary.BigEndian.PutUint64(hdr[16:], c.gen)
+	binary.BigEndian.PutUint16(hdr[24:], uint16(len(key)))
+	copy(hdr[devSlotHeader:], key)
+	if err == nil {
+		_, err = c.dev.WriteAt(hdr, off)
+	}
+	c.metrics.cacheWriteHist.Observe(time.Since(start).Seconds())
+
+	c.Lock()
+	defer c.Unlock()
+	s.writing--
+	if s.keys[slot] != key || s.vers[slot] != ver {
+		return // evicted or removed
+	}
+	if err != nil {
+		logger.Warnf("Write %s into %s: %s", key, c.path, err)
+		c.release(slab, slot)
+		return
+	}
+	c.index[key] = &devEntry{slab, slot, int32(len(data)), sum, uint32(time.Now().Unix())}
+	c.used += int64(len(data))
+}
+
+func (c *devCache) flush() {
+	for w := range c.pending {
+		c.write(w.key, w.page.Data)
+		c.Lock()
+		delete(c.pages, w.key)
+		c.Unlock()
+		w.page.Release()
+	}
+}
+
+func (c *devCache) cache(key string, p *Page, force, dropCache bool) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.pages[key]; ok {
+		return
+	}
+	if _, ok := c.index[key]; ok {
+		return
+	}
+	p.Acquire()
+	c.pages[key] = p
+	select {
+	case c.pending <- pendingFile{key, p, dropCache}:
+	default:
+		if force {
+			c.Unlock()
+			c.pending <- pendingFile{key, p, dropCache}
+			c.Lock()
+		} else {
+			logger.Debugf("Caching queue is full (%s), drop %s (%d bytes)", c.path, key, len(p.Data))
+			c.metrics.cacheDrops.Add(1)
+			delete(c.pages, key)
+			p.Release()
+		}
+	}
+}
+
+func (c *devCache) remove(key string, staging bool) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.pages, key)
+	if e, ok := c.index[key]; ok {
+		// invalidate the header before the slot could be reused, so it's not found after restart
+		if _, err := c.dev.WriteAt(make([]byte, 4), c.slotOff(e.slab, e.slot)); err != nil {
+			logger.Warnf("Remove %s from %s: %s", key, c.path, err)
+		}
+		c.release(e.slab, e.slot)
+	}
+}
+
+type devReader struct {
+	c          *devCache
+	slab, slot int32
+	ver        uint32
+	off        int64
+	size       int
+	crc        uint32
+}
+
+func (r *devReader) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(r.size) {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(b)) > int64(r.size) {
+		b = b[:int64(r.size)-off]
+		eof = io.EOF
+	}
+	n, err := r.c.dev.ReadAt(b, r.off+off)
+	if err != nil {
+		return n, err
+	}
+	r.c.Lock()
+	reused := r.c.slabs[r.slab].vers[r.slot] != r.ver
+	r.c.Unlock()
+	if reused {
+		return 0, errSlotReused
+	}
+	if off == 0 && n == r.size {
+		if sum := crc32.Checksum(b, crc32c); sum != r.crc {
+			return 0, fmt.Errorf("data checksum %d != expect %d", sum, r.crc)
+		}
+	}
+	return n, eof
+}
+
+func (r *devReader) Close() error { return nil }
+
+func (c *devCache) load(key string) (ReadCloser, error) {
+	c.Lock()
+	defer c.Unlock()
+	if p, ok := c.pages[key]; ok {
+		return NewPageReader(p), nil
+	}
+	e, ok := c.index[key]
+	if !ok {
+		return nil, errNotCached
+	}
+	e.atime = uint32(time.Now().Unix())
+	return &devReader{c, e.slab, e.slot, c.slabs[e.slab].vers[e.slot], c.slotOff(e.slab, e.slot) + devSector, int(e.size), e.crc}, nil
+}
+
+func (c *devCache) exist(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.pages[key]; ok {
+		return c.path, true
+	}
+	_, ok := c.index[key]
+	return c.path, ok
+}
+
+func (c *devCache) usedMemory() int64 {
+	c.Lock()
+	defer c.Unlock()
+	var used int64
+	for _, p := range c.pages {
+		used += int64(cap(p.Data))
+	}
+	return used
+}
+
+func (c *devCache) stats() (int64, int64) {
+	c.Lock()
+	defer c.Unlock()
+	return int64(len(c.index)), c.used
+}
+
+func (c *devCache) stage(key string, data []byte) (string, error) {
+	return "", errors.New("not supported")
+}
+func (c *devCache) removeStage(key string) error     { return nil }
+func (c *devCache) uploaded(key string, size int)    {}
+func (c *devCache) isEmpty() bool                    { return false }
+func (c *devCache) getMetrics() *cacheManagerMetrics { return c.metrics }
+
+func (c *devCache) pin(key string, pinned bool) {} // not supported yet
+
+func (c *devCache) resize(size int64) {
+	logger.Warnf("Cache size can not be changed for cache device %s", c.path)
+}
@@ -0,0 +1,178 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDev(t *testing.T, slabs int) string {
+	path := filepath.Join(t.TempDir(), "dev")
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	require.Nil(t, f.Truncate(devFirstSlab+int64(slabs)*devSlabSize))
+	_ = f.Close()
+	return path
+}
+
+func openTestDev(t *testing.T, path, uuid string) *devCache {
+	conf := defaultConf
+	conf.CacheDev = path
+	conf.CacheDevFormat = true
+	conf.CacheSize = 1 << 40
+	conf.BufferSize = 64 << 20
+	conf.BlockSize = 4 << 20
+	conf.CacheEviction = Eviction2Random
+	conf.uuid = uuid
+	c, err := newDevCache(&conf, newCacheManagerMetrics(nil))
+	require.Nil(t, err)
+	return c
+}
+
+func waitCached(c *devCache) {
+	for i := 0; i < 100; i++ {
+		c.Lock()
+		n := len(c.pages)
+		c.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func readDev(t *testing.T, c *devCache, key string, size int) []byte {
+	r, err := c.load(key)
+	require.Nil(t, err)
+	defer r.Close()
+	buf := make([]byte, size)
+	n, err := r.ReadAt(buf, 0)
+	require.Nil(t, err)
+	require.Equal(t, size, n)
+	return buf
+}
+
+func TestDevCache(t *testing.T) {
+	path := newTestDev(t, 4)
+	c := openTestDev(t, path, "test")
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("chunks/0/0/%d_0_%d", i, 100<<10*(i+1))
+		keys = append(keys, key)
+		c.cache(key, NewPage(bytes.Repeat([]byte{byte(i)}, 100<<10*(i+1))), true, false)
+	}
+	waitCached(c)
+	for i, key := range keys {
+		loc, ok := c.exist(key)
+		require.True(t, ok, key)
+		require.Equal(t, path, loc)
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 100<<10*(i+1)), readDev(t, c, key, 100<<10*(i+1)))
+	}
+	r, _ := c.load(keys[3])
+	part := make([]byte, 10)
+	n, err := r.ReadAt(part, 100<<10*4-5)
+	require.Equal(t, 5, n)
+	require.NotNil(t, err)
+	c.remove(keys[0], false)
+	_, ok := c.exist(keys[0])
+	require.False(t, ok)
+	cnt, used := c.stats()
+	require.Equal(t, int64(9), cnt)
+	require.Equal(t, int64(100<<10*54), used)
+	_ = c.dev.Close()
+
+	// the index is rebuilt after restart
+	c = openTestDev(t, path, "test")
+	cnt, _ = c.stats()
+	require.Equal(t, int64(9), cnt)
+	_, ok = c.exist(keys[0])
+	require.False(t, ok)
+	require.Equal(t, bytes.Repeat([]byte{9}, 1000<<10), readDev(t, c, keys[9], 1000<<10))
+	_ = c.dev.Close()
+
+	// used by another volume
+	conf := defaultConf
+	conf.CacheDev = path
+	conf.CacheSize = 1 << 40
+	conf.uuid = "other"
+	_, err = newDevCache(&conf, newCacheManagerMetrics(nil))
+	require.ErrorContains(t, err, "cache-dev-format")
+	c = openTestDev(t, path, "other")
+	cnt, _ = c.stats()
+	require.Equal(t, int64(0), cnt)
+	_ = c.dev.Close()
+
+	// never format a device with a file system
+	path = newTestDev(t, 2)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.Nil(t, err)
+	_, err = f.WriteAt([]byte{0x53, 0xef}, 1080)
+	require.Nil(t, err)
+	_ = f.Close()
+	conf.CacheDev = path
+	conf.CacheDevFormat = true
+	_, err = newDevCache(&conf, newCacheManagerMetrics(nil))
+	require.ErrorContains(t, err, "ext2/3/4")
+}
+
+func TestDevCacheEvict(t *testing.T) {
+	c := openTestDev(t, newTestDev(t, 2), "test")
+	defer c.dev.Close()
+	slots := devSlots(devClass(4 << 20))
+	for i := 0; i < slots*3; i++ {
+		c.cache(fmt.Sprintf("chunks/0/0/%d_0_%d", i, 4<<20), NewPage(make([]byte, 4<<20)), true, false)
+	}
+	waitCached(c)
+	cnt, _ := c.stats()
+	require.Equal(t, int64(slots*2), cnt)
+	require.Equal(t, 2, len(c.byClass[devClass(4<<20)]))
+
+	// the slabs being written are not evicted
+	c.Lock()
+	for _, slab := range c.byClass[devClass(4<<20)] {
+		c.slabs[slab].writing++
+	}
+	require.False(t, c.evict(devClass(1024)))
+	for _, slab := range c.byClass[devClass(4<<20)] {
+		c.slabs[slab].writing--
+	}
+	c.Unlock()
+
+	// a slab of the large blocks is evicted for the small ones
+	key := "chunks/0/0/1000_0_1024"
+	c.cache(key, NewPage(make([]byte, 1024)), true, false)
+	waitCached(c)
+	_, ok := c.exist(key)
+	require.True(t, ok)
+	cnt, _ = c.stats()
+	require.Equal(t, int64(slots+1), cnt)
+	require.Equal(t, 1, len(c.byClass[devClass(4<<20)]))
+
+	// stale readers are detected
+	r, err := c.load(key)
+	require.Nil(t, err)
+	c.remove(key, false)
+	_, err = r.ReadAt(make([]byte, 1024), 0)
+	require.Equal(t, errSlotReused, err)
+}
@@ -92,7 +92,12 @@ type cacheStore struct {
 	stageFull bool
 	rawFull   bool
 	checksum  string // checksum level
-	uploader  func(key, path string, force bool) bool
+	cipher    *cacheCipher
+	syncStage bool
+
+	pinned     map[cacheKey]struct{}
+	pinChanged bool
+	uploader   func(key, path string, force bool) bool
 
 	opTs map[time.Duration]func() error
 	opMu sync.Mutex
@@ -123,6 +128,8 @@ func newCacheStore(m *cacheManagerMetrics, dir string, cacheSize, maxItems int64
 		maxStageWrite: config.MaxStageWrite,
 		freeRatio:     config.FreeSpace,
 		checksum:      config.CacheChecksum,
+		cipher:        config.cacheCipher,
+		syncStage:     config.WritebackDurable,
 		hashPrefix:    config.HashPrefix,
 		scanInterval:  config.CacheScanInterval,
 		cacheExpire:   config.CacheExpire,
@@ -131,6 +138,7 @@ func newCacheStore(m *cacheManagerMetrics, dir string, cacheSize, maxItems int64
 		pages:         make(map[string]*Page),
 		uploader:      uploader,
 		opTs:          make(map[time.Duration]func() error),
+		pinned:        make(map[cacheKey]struct{}),
 	}
 	c.stateLock = sync.Mutex{}
 	if config.Writeback {
@@ -140,6 +148,13 @@ func newCacheStore(m *cacheManagerMetrics, dir string, cacheSize, maxItems int64
 	}
 
 	c.createDir(c.dir)
+	if c.cipher != nil {
+		if err := c.cipher.markDir(c.dir); err != nil {
+			logger.Warnf("Mark cache dir %s as encrypted: %s", c.dir, err)
+		}
+	} else if err := os.Remove(filepath.Join(c.dir, cipherMarker)); err == nil {
+		logger.Infof("Cache dir %s is not encrypted anymore", c.dir)
+	}
 	usage := c.curFreeRatio()
 	if usage.br < c.freeRatio || usage.fr < c.freeRatio {
 		logger.Warnf("not enough space (%d%%) or inodes (%d%%) for caching in %s: free ratio should be >= %d%%", int(usage.br*100), int(usage.fr*100), c.dir, int(c.freeRatio*100))
@@ -150,8 +165,10 @@ func newCacheStore(m *cacheManagerMetrics, dir string, cacheSize, maxItems int64
 	c.setLimitByFreeRatio(usage, c.freeRatio)
 
 	c.createLockFile()
+	c.loadPinned()
 	go c.checkLockFile()
 	go c.flush()
+	go c.savePinned()
 	go c.checkFreeSpace()
 	if c.cacheExpire > 0 {
 		go c.cleanupExpire()
@@ -232,6 +249,16 @@ func (c *cacheStore) available() bool {
 	return c.state.state() != dcDown
 }
 
+func (cache *cacheStore) resize(size int64) {
+	cache.Lock()
+	defer cache.Unlock()
+	logger.Infof("Cache capacity of %s changed from %s to %s", cache.dir, humanize.IBytes(uint64(cache.capacity)), humanize.IBytes(uint64(size)))
+	cache.capacity = size
+	if cache.full() && cache.keys.name() != EvictionNone {
+		cache.cleanupFull()
+	}
+}
+
 func (c *cacheStore) enabled() bool {
 	return c.capacity > 0
 }
@@ -373,13 +400,16 @@ func (cache *cacheStore) cleanupExpire() {
 			if v.size < 0 {
 				continue // staging
 			}
+			if _, ok := cache.pinned[k]; ok {
+				continue
+			}
 			if v.atime < cutoff {
 				if cache.keys.remove(k, false) != nil {
 					deleted++
 					freed += int64(v.size + 4096)
 					cache.used -= int64(v.size + 4096)
 					todel = append(todel, k)
-					cache.m.cacheEvicts.Add(1)
+					cache.m.evicted(int64(v.size))
 				}
 			}
 		}
@@ -490,7 +520,7 @@ func (cache *cacheStore) curFreeRatio() DiskFreeRatio {
 	return usage
 }
 
-func (cache *cacheStore) flushPage(path string, data []byte, dropCache bool) (err error) {
+func (cache *cacheStore) flushPage(path string, data []byte, dropCache, staging bool) (err error) {
 	if !cache.available() {
 		return errCacheDown
 	}
@@ -520,18 +550,34 @@ func (cache *cacheStore) flushPage(path string, data []byte, dropCache bool) (er
 		}
 	}()
 
+	if cache.cipher != nil {
+		buf := NewOffPage(cache.cipher.sealedSize(len(data), staging))
+		defer buf.Release()
+		if data, err = cache.cipher.seal(buf.Data, data, staging); err != nil {
+			logger.Warnf("Encrypt cache file %s failed: %s", tmp, err)
+			_ = f.Close()
+			return
+		}
+	}
 	if err = cache.writeFile(f, data); err != nil {
 		logger.Warnf("Write to cache file %s failed: %s", tmp, err)
 		_ = f.Close()
 		return
 	}
-	if cache.checksum != CsNone {
+	if cache.checksum != CsNone && cache.cipher == nil { // authenticated by the cipher
 		if err = cache.writeFile(f, checksum(data)); err != nil {
 			logger.Warnf("Write checksum to cache file %s failed: %s", tmp, err)
 			_ = f.Close()
 			return
 		}
 	}
+	if staging && cache.syncStage {
+		if err = cache.checkErr(f.Sync); err != nil {
+			logger.Warnf("Sync cache file %s failed: %s", tmp, err)
+			_ = f.Close()
+			return
+		}
+	}
 	if dropCache {
 		dropOSCache(f)
 	}
@@ -541,6 +587,10 @@ func (cache *cacheStore) flushPage(path string, data []byte, dropCache bool) (er
 	}
 	if err = cache.renameFile(tmp, path); err != nil {
 		logger.Warnf("Rename cache file %s -> %s failed: %s", tmp, path, err)
+	} else if staging && cache.syncStage {
+		if err = cache.checkErr(func() error { return syncDir(filepath.Dir(path)) }); err != nil {
+			logger.Warnf("Sync directory of cache file %s failed: %s", path, err)
+		}
 	}
 	return
 }
@@ -599,11 +649,7 @@ func (cache *cacheStore) getCacheKey(key string) cacheKey {
 }
 
 func (cache *cacheStore) getPathFromKey(k cacheKey) string {
-	if cache.hashPrefix {
-		return fmt.Sprintf("chunks/%02X/%v/%v_%v_%v", k.id%256, k.id/1000/1000, k.id, k.indx, k.size)
-	} else {
-		return fmt.Sprintf("chunks/%v/%v/%v_%v_%v", k.id/1000/1000, k.id/1000, k.id, k.indx, k.size)
-	}
+	return BlockKey(cache.hashPrefix, k.id, int(k.indx), int(k.size))
 }
 
 func (cache *cacheStore) remove(key string, staging bool) {
@@ -644,11 +690,17 @@ func (cache *cacheStore) load(key string) (ReadCloser, error) {
 	}
 	cache.Unlock()
 
-	var f *cacheFile
+	var f ReadCloser
 	var err error
 	err = cache.checkErr(func() error {
-		f, err = openCacheFile(cache.cachePath(key), parseObjOrigSize(key), cache.checksum)
-		if err != nil && !os.IsNotExist(err) {
+		f, err = openBlockFile(cache.cachePath(key), parseObjOrigSize(key), cache.checksum, cache.cipher)
+		if errors.Is(err, errUnknownCacheKey) {
+			logger.Debugf("Remove cache file %s of another mount", cache.cachePath(key))
+			_ = os.Remove(cache.cachePath(key))
+		} else if errors.Is(err, errPlainCache) {
+			logger.Warnf("Remove cache file %s not encrypted", cache.cachePath(key))
+			_ = os.Remove(cache.cachePath(key))
+		} else if err != nil && !os.IsNotExist(err) {
 			logger.Warnf("Open cache file %s failed: %s", cache.cachePath(key), err)
 		}
 		return err
@@ -705,7 +757,7 @@ func (cache *cacheStore) flush() {
 	for {
 		w := <-cache.pending
 		path := cache.cachePath(w.key)
-		if cache.enabled() && cache.flushPage(path, w.page.Data, w.dropCache) == nil {
+		if cache.enabled() && cache.flushPage(path, w.page.Data, w.dropCache, false) == nil {
 			cache.add(w.key, int32(len(w.page.Data)), uint32(time.Now().Unix()))
 		}
 		cache.Lock()
@@ -760,7 +812,7 @@ func (cache *cacheStore) stage(key string, data []byte) (string, error) {
 	}
 	stagingBlocks.Add(1)
 	defer stagingBlocks.Add(-1)
-	err := cache.flushPage(stagingPath, data, false)
+	err := cache.flushPage(stagingPath, data, false, true)
 	if err == nil {
 		cache.m.stageBlocks.Add(1)
 		cache.m.stageBlockBytes.Add(float64(len(data)))
@@ -820,19 +872,27 @@ func (cache *cacheStore) cleanupFull() {
 	var todel []cacheKey
 	var freed int64
 	var now = uint32(time.Now().Unix())
+	var pinned = make(map[cacheKey]cacheItem)
 
 	for k, item := range cache.keys.evictionIter() {
+		if _, ok := cache.pinned[k]; ok {
+			pinned[k] = item
+			continue
+		}
 		freed += int64(item.size + 4096)
 		cache.used -= int64(item.size + 4096)
 		todel = append(todel, k)
 
 		logger.Debugf("remove %s from cache, age: %ds", k, now-item.atime)
-		cache.m.cacheEvicts.Add(1)
+		cache.m.evicted(int64(item.size))
 
-		if int64(cache.keys.len()) <= num && cache.used <= goal {
+		if int64(cache.keys.len()+len(pinned)) <= num && cache.used <= goal {
 			break
 		}
 	}
+	for k, item := range pinned { // put them back
+		cache.keys.add(k, item)
+	}
 	if len(todel) > 0 {
 		logger.Debugf("cleanup cache (%s) using %s eviction: %d blocks (%s), freed %d blocks (%s)", cache.dir, cache.keys.name(), cache.keys.len(), humanize.IBytes(uint64(cache.used)), len(todel), humanize.IBytes(uint64(freed)))
 	}
@@ -1077,11 +1137,40 @@ type CacheManager interface {
 	usedMemory() int64
 	isEmpty() bool
 	getMetrics() *cacheManagerMetrics
+	pin(key string, pinned bool)
+	resize(size int64)
 }
 
 func newCacheManager(config *Config, reg prometheus.Registerer, uploader func(key, path string, force bool) bool) CacheManager {
 	getEnvs()
 	metrics := newCacheManagerMetrics(reg)
+	if len(config.CacheTiers) == 0 {
+		return openCacheManager(config, metrics, uploader)
+	}
+	var tiers []CacheManager
+	for _, t := range config.CacheTiers {
+		conf := *config
+		conf.CacheDir = t.Dir
+		conf.CacheSize = t.Size
+		conf.CacheEviction = t.Eviction
+		conf.CacheItems = 0
+		conf.Writeback = false
+		conf.CacheDev = ""
+		conf.CacheTiers = nil
+		logger.Infof("Cache tier %d: %s (%s, %s)", len(tiers), t.Dir, humanize.IBytes(t.Size), t.Eviction)
+		tiers = append(tiers, openCacheManager(&conf, metrics.forTier(len(tiers)), uploader))
+	}
+	return newTieredCache(append(tiers, openCacheManager(config, metrics.forTier(len(tiers)), uploader)), metrics)
+}
+
+func openCacheManager(config *Config, metrics *cacheManagerMetrics, uploader func(key, path string, force bool) bool) CacheManager {
+	if config.CacheDev != "" && config.CacheEnabled() {
+		c, err := newDevCache(config, metrics)
+		if err == nil {
+			return c
+		}
+		logger.Warnf("Cache on %s: %s, use cache-dir %s instead", config.CacheDev, err, config.CacheDir)
+	}
 	if config.CacheDir == "memory" || !config.CacheEnabled() {
 		return newMemStore(config, metrics)
 	}
@@ -1129,6 +1218,22 @@ func (m *cacheManager) getMetrics() *cacheManagerMetrics {
 	return m.metrics
 }
 
+// resize splits the new capacity evenly among the cache dirs, as when they are opened.
+func (m *cacheManager) resize(size int64) {
+	m.Lock()
+	stores := make([]*cacheStore, 0, len(m.stores))
+	for _, s := range m.stores {
+		if s != nil {
+			stores = append(stores, s)
+		}
+	}
+	per := size / int64(len(m.stores))
+	m.Unlock()
+	for _, s := range stores {
+		s.resize(per)
+	}
+}
+
 func (m *cacheManager) cleanup() {
 	for !m.isEmpty() {
 		var ids []string
@@ -1281,6 +1386,12 @@ func (m *cacheManager) stage(key string, data []byte) (string, error) {
 	return "", errors.New("no available cache dir")
 }
 
+func (m *cacheManager) pin(key string, pinned bool) {
+	if store := m.getStore(key); store != nil {
+		store.pin(key, pinned)
+	}
+}
+
 func (m *cacheManager) uploaded(key string, size int) {
 	store := m.getStore(key)
 	if store != nil {
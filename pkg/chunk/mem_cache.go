@@ -158,7 +158,7 @@ func (c *memcache) cleanup() {
 		cnt++
 		if cnt > 1 {
 			logger.Debugf("remove %s from cache, age: %d", lastKey, now.Sub(lastValue.atime))
-			c.metrics.cacheEvicts.Add(1)
+			c.metrics.evicted(int64(cap(lastValue.page.Data)))
 			c.delete(lastKey, lastValue.page)
 			cnt = 0
 			if !c.full() {
@@ -168,6 +168,16 @@ func (c *memcache) cleanup() {
 	}
 }
 
+func (c *memcache) resize(size int64) {
+	c.Lock()
+	defer c.Unlock()
+	logger.Infof("Memory cache capacity changed from %s to %s", humanize.IBytes(uint64(c.capacity)), humanize.IBytes(uint64(size)))
+	c.capacity = size
+	if c.full() && c.eviction != EvictionNone {
+		c.cleanup()
+	}
+}
+
 func (c *memcache) enabled() bool {
 	return c.capacity > 0
 }
@@ -194,7 +204,7 @@ func (c *memcache) cleanupExpire() {
 			if v.atime.Before(cutoff) {
 				deleted++
 				freed += int64(cap(v.page.Data))
-				c.metrics.cacheEvicts.Add(1)
+				c.metrics.evicted(int64(cap(v.page.Data)))
 				c.delete(k, v.page)
 			}
 		}
@@ -212,3 +222,5 @@ func (c *memcache) stage(key string, data []byte) (string, error) {
 func (c *memcache) uploaded(key string, size int)    {}
 func (c *memcache) isEmpty() bool                    { return false }
 func (c *memcache) getMetrics() *cacheManagerMetrics { return c.metrics }
+
+func (cache *memcache) pin(key string, pinned bool) {}
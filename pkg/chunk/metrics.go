@@ -17,6 +17,8 @@
 package chunk
 
 import (
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -30,6 +32,10 @@ type cacheManagerMetrics struct {
 	stageBlocks     prometheus.Gauge
 	stageBlockBytes prometheus.Gauge
 	stageWriteBytes prometheus.Counter
+	evictBytes      *prometheus.CounterVec
+	tierHits        *prometheus.CounterVec
+	tierMiss        *prometheus.CounterVec
+	tier            string // index of the cache tier, 0 is the fastest one
 }
 
 func newCacheManagerMetrics(reg prometheus.Registerer) *cacheManagerMetrics {
@@ -39,6 +45,18 @@ func newCacheManagerMetrics(reg prometheus.Registerer) *cacheManagerMetrics {
 	return metrics
 }
 
+// forTier returns the metrics of the cache tier, which share the same collectors.
+func (c *cacheManagerMetrics) forTier(tier int) *cacheManagerMetrics {
+	m := *c
+	m.tier = strconv.Itoa(tier)
+	return &m
+}
+
+func (c *cacheManagerMetrics) evicted(size int64) {
+	c.cacheEvicts.Add(1)
+	c.evictBytes.WithLabelValues(c.tier).Add(float64(size))
+}
+
 func (c *cacheManagerMetrics) registerMetrics(reg prometheus.Registerer) {
 	if reg != nil {
 		reg.MustRegister(c.cacheDrops)
@@ -49,6 +67,9 @@ func (c *cacheManagerMetrics) registerMetrics(reg prometheus.Registerer) {
 		reg.MustRegister(c.stageBlocks)
 		reg.MustRegister(c.stageBlockBytes)
 		reg.MustRegister(c.stageWriteBytes)
+		reg.MustRegister(c.evictBytes)
+		reg.MustRegister(c.tierHits)
+		reg.MustRegister(c.tierMiss)
 		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Name: "staging_writing_blocks",
 			Help: "Number of writing blocks in staging.",
@@ -92,4 +113,17 @@ func (c *cacheManagerMetrics) initMetrics() {
 		Name: "staging_write_bytes",
 		Help: "write bytes of blocks in the staging path.",
 	})
+	c.evictBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blockcache_evict_bytes",
+		Help: "evicted bytes of cache blocks by tier",
+	}, []string{"tier"})
+	c.tierHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blockcache_tier_hits",
+		Help: "read from cached block by tier",
+	}, []string{"tier"})
+	c.tierMiss = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blockcache_tier_miss",
+		Help: "missed read from cached block by tier",
+	}, []string{"tier"})
+	c.tier = "0"
 }
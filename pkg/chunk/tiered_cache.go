@@ -0,0 +1,189 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chunk
+
+import (
+	"io"
+	"sync"
+)
+
+// CacheTier is a cache tier above CacheDir, which keeps the hot blocks promoted from the slower tiers.
+type CacheTier struct {
+	Dir      string
+	Size     uint64
+	Eviction string
+}
+
+const (
+	promoteHits    = 2       // promote a block after it's read this many times from a tier
+	maxTrackedHits = 1 << 20 // forget the access counts once too many blocks are tracked
+)
+
+type promotion struct {
+	key  string
+	tier int
+}
+
+// tieredCache stacks the cache tiers from the fastest to the slowest one. All the blocks are cached in the
+// slowest tier (where the staging blocks are kept as well), the frequently read ones are promoted one tier up
+// and stay there until they are evicted by the policy of that tier, so no demotion is needed.
+type tieredCache struct {
+	tiers     []CacheManager
+	metrics   *cacheManagerMetrics
+	mu        sync.Mutex
+	hits      map[string]uint8
+	promoting chan promotion
+}
+
+func newTieredCache(tiers []CacheManager, metrics *cacheManagerMetrics) *tieredCache {
+	c := &tieredCache{
+		tiers:     tiers,
+		metrics:   metrics,
+		hits:      make(map[string]uint8),
+		promoting: make(chan promotion, 100),
+	}
+	go c.promote()
+	return c
+}
+
+func (c *tieredCache) bottom() CacheManager {
+	return c.tiers[len(c.tiers)-1]
+}
+
+func (c *tieredCache) cache(key string, p *Page, force, dropCache bool) {
+	c.bottom().cache(key, p, force, dropCache)
+}
+
+func (c *tieredCache) remove(key string, staging bool) {
+	for i, t := range c.tiers {
+		t.remove(key, staging && i == len(c.tiers)-1)
+	}
+	c.mu.Lock()
+	delete(c.hits, key)
+	c.mu.Unlock()
+}
+
+func (c *tieredCache) load(key string) (ReadCloser, error) {
+	var err error
+	for i, t := range c.tiers {
+		var r ReadCloser
+		m := t.getMetrics()
+		if r, err = t.load(key); err == nil {
+			m.tierHits.WithLabelValues(m.tier).Inc()
+			if i > 0 {
+				c.hit(key, i)
+			}
+			return r, nil
+		}
+		m.tierMiss.WithLabelValues(m.tier).Inc()
+	}
+	return nil, err
+}
+
+// hit counts the read of key from tier, and promotes it once it's read frequently.
+func (c *tieredCache) hit(key string, tier int) {
+	c.mu.Lock()
+	if len(c.hits) >= maxTrackedHits {
+		c.hits = make(map[string]uint8)
+	}
+	c.hits[key]++
+	n := c.hits[key]
+	if n >= promoteHits {
+		delete(c.hits, key)
+	}
+	c.mu.Unlock()
+	if n >= promoteHits {
+		select {
+		case c.promoting <- promotion{key, tier}:
+		default: // busy, try again later
+		}
+	}
+}
+
+func (c *tieredCache) promote() {
+	for p := range c.promoting {
+		r, err := c.tiers[p.tier].load(p.key)
+		if err != nil {
+			continue
+		}
+		page := NewOffPage(parseObjOrigSize(p.key))
+		n, err := r.ReadAt(page.Data, 0)
+		_ = r.Close()
+		if n == len(page.Data) && (err == nil || err == io.EOF) {
+			logger.Debugf("Promote %s to cache tier %d", p.key, p.tier-1)
+			c.tiers[p.tier-1].cache(p.key, page, false, false)
+		}
+		page.Release()
+	}
+}
+
+func (c *tieredCache) exist(key string) (string, bool) {
+	for _, t := range c.tiers {
+		if loc, existed := t.exist(key); existed {
+			return loc, true
+		}
+	}
+	return "", false
+}
+
+func (c *tieredCache) uploaded(key string, size int) {
+	c.bottom().uploaded(key, size)
+}
+
+func (c *tieredCache) stage(key string, data []byte) (string, error) {
+	return c.bottom().stage(key, data)
+}
+
+func (c *tieredCache) removeStage(key string) error {
+	return c.bottom().removeStage(key)
+}
+
+func (c *tieredCache) stats() (int64, int64) {
+	var cnt, used int64
+	for _, t := range c.tiers {
+		n, u := t.stats()
+		cnt += n
+		used += u
+	}
+	return cnt, used
+}
+
+func (c *tieredCache) usedMemory() int64 {
+	var used int64
+	for _, t := range c.tiers {
+		used += t.usedMemory()
+	}
+	return used
+}
+
+func (c *tieredCache) isEmpty() bool {
+	return c.bottom().isEmpty()
+}
+
+func (c *tieredCache) getMetrics() *cacheManagerMetrics {
+	return c.metrics
+}
+
+// resize changes the size of the slowest tier (the cache dir), the other tiers keep their sizes.
+func (c *tieredCache) resize(size int64) {
+	c.bottom().resize(size)
+}
+
+// pin keeps the block in the slowest tier, where all the blocks are cached.
+func (c *tieredCache) pin(key string, pinned bool) {
+	c.bottom().pin(key, pinned)
+}
@@ -52,3 +52,7 @@ func getDiskUsage(path string) (uint64, uint64, uint64, uint64) {
 func changeMode(dir string, st os.FileInfo, mode os.FileMode) {}
 
 func inRootVolume(dir string) bool { return false }
+
+func lockDevice(f *os.File) error { return nil }
+
+func syncDir(dir string) error { return nil }
@@ -17,16 +17,26 @@
 package compress
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/DataDog/zstd"
 	"github.com/hungys/go-lz4"
+	"github.com/klauspost/compress/dict"
+	kzstd "github.com/klauspost/compress/zstd"
 )
 
 // ZSTD_LEVEL compression level used by Zstd
 const ZSTD_LEVEL = 1 // fastest
 
+// ZSTD_MAX_LEVEL is the highest compression level of Zstd
+const ZSTD_MAX_LEVEL = 22
+
+// MaxDictSize is the largest dictionary of Zstd, which is kept in the volume settings
+const MaxDictSize = 64 << 10
+
 // Compressor interface to be implemented by a compression algo
 type Compressor interface {
 	Name() string
@@ -35,11 +45,18 @@ type Compressor interface {
 	Decompress(dst, src []byte) (int, error)
 }
 
-// NewCompressor returns a struct implementing Compressor interface
+// NewCompressor returns a struct implementing Compressor interface, the level of Zstd can be
+// specified as "zstd:LEVEL" (1-22).
 func NewCompressor(algr string) Compressor {
 	algr = strings.ToLower(algr)
 	if algr == "zstd" {
-		return ZStandard{ZSTD_LEVEL}
+		return ZStandard{level: ZSTD_LEVEL}
+	} else if strings.HasPrefix(algr, "zstd:") {
+		level, err := strconv.Atoi(algr[len("zstd:"):])
+		if err != nil || level < 1 || level > ZSTD_MAX_LEVEL {
+			return nil
+		}
+		return ZStandard{level: level}
 	} else if algr == "lz4" {
 		return LZ4{}
 	} else if algr == "none" || algr == "" {
@@ -48,6 +65,48 @@ func NewCompressor(algr string) Compressor {
 	return nil
 }
 
+// NewCompressorWithDict returns a Zstd compressor using the dictionary (trained by TrainDict), the
+// blocks compressed with a dictionary can only be decompressed with the same one.
+func NewCompressorWithDict(algr string, dictionary []byte) (Compressor, error) {
+	c := NewCompressor(algr)
+	if len(dictionary) == 0 {
+		return c, nil
+	}
+	z, ok := c.(ZStandard)
+	if !ok {
+		return nil, fmt.Errorf("dictionary is not supported by %s", algr)
+	}
+	if len(dictionary) > MaxDictSize {
+		return nil, fmt.Errorf("dictionary is too large: %d > %d", len(dictionary), MaxDictSize)
+	}
+	var err error
+	if z.dict, err = zstd.NewBulkProcessor(dictionary, z.level); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// TrainDict builds a Zstd dictionary of at most size bytes from the samples, which should be similar
+// to the blocks to be compressed.
+func TrainDict(algr string, samples [][]byte, size int) ([]byte, error) {
+	z, ok := NewCompressor(algr).(ZStandard)
+	if !ok {
+		return nil, fmt.Errorf("dictionary is not supported by %s", algr)
+	}
+	if size <= 0 || size > MaxDictSize {
+		return nil, fmt.Errorf("invalid size of dictionary: %d", size)
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no sample to train the dictionary")
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize:    size,
+		HashBytes:      6,
+		ZstdLevel:      kzstd.EncoderLevelFromZstd(z.level),
+		ZstdDictCompat: true,
+	})
+}
+
 type noOp struct{}
 
 func (n noOp) Name() string            { return "Noop" }
@@ -70,6 +129,7 @@ func (n noOp) Decompress(dst, src []byte) (int, error) {
 // ZStandard implements Compressor interface using zstd library
 type ZStandard struct {
 	level int
+	dict  *zstd.BulkProcessor
 }
 
 // Name returns name of the algorithm Zstd
@@ -80,7 +140,13 @@ func (n ZStandard) CompressBound(l int) int { return zstd.CompressBound(l) }
 
 // Compress using Zstd
 func (n ZStandard) Compress(dst, src []byte) (int, error) {
-	d, err := zstd.CompressLevel(dst, src, n.level)
+	var d []byte
+	var err error
+	if n.dict != nil {
+		d, err = n.dict.Compress(dst, src)
+	} else {
+		d, err = zstd.CompressLevel(dst, src, n.level)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -92,7 +158,13 @@ func (n ZStandard) Compress(dst, src []byte) (int, error) {
 
 // Decompress using Zstd
 func (n ZStandard) Decompress(dst, src []byte) (int, error) {
-	d, err := zstd.Decompress(dst, src)
+	var d []byte
+	var err error
+	if n.dict != nil {
+		d, err = n.dict.Decompress(dst, src)
+	} else {
+		d, err = zstd.Decompress(dst, src)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -17,6 +17,7 @@
 package compress
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"testing"
@@ -69,6 +70,57 @@ func TestUncompressed(t *testing.T) {
 
 func TestZstd(t *testing.T) {
 	testCompress(t, NewCompressor("zstd"))
+	testCompress(t, NewCompressor("zstd:9"))
+	testCompress(t, NewCompressor("Zstd:22"))
+	for _, algr := range []string{"zstd:", "zstd:0", "zstd:23", "zstd:fast", "lz4:1"} {
+		if NewCompressor(algr) != nil {
+			t.Fatalf("%s should be invalid", algr)
+		}
+	}
+}
+
+func TestZstdDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","email":"user%d@example.com","active":%v}`, i, i*7, i*13, i%2 == 0)))
+	}
+	d, err := TrainDict("zstd:3", samples, 4<<10)
+	if err != nil || len(d) == 0 || len(d) > 4<<10 {
+		t.Fatalf("train dictionary: %d bytes, %v", len(d), err)
+	}
+	c, err := NewCompressorWithDict("zstd:3", d)
+	if err != nil {
+		t.Fatalf("new compressor with dictionary: %s", err)
+	}
+	testCompress(t, c)
+
+	src := []byte(`{"id":12345,"name":"user86415","email":"user160485@example.com","active":false}`)
+	buf := make([]byte, c.CompressBound(len(src)))
+	n, err := c.Compress(buf, src)
+	if err != nil {
+		t.Fatalf("compress: %s", err)
+	}
+	plain := NewCompressor("zstd:3")
+	buf2 := make([]byte, plain.CompressBound(len(src)))
+	if n2, _ := plain.Compress(buf2, src); n >= n2 {
+		t.Fatalf("compressed size with dictionary %d >= %d", n, n2)
+	}
+	if _, err = plain.Decompress(make([]byte, len(src)), buf[:n]); err == nil {
+		t.Fatalf("decompress without dictionary should fail")
+	}
+
+	if _, err = NewCompressorWithDict("lz4", d); err == nil {
+		t.Fatalf("lz4 should not support dictionary")
+	}
+	if _, err = NewCompressorWithDict("zstd", make([]byte, MaxDictSize+1)); err == nil {
+		t.Fatalf("dictionary should be too large")
+	}
+	if _, err = TrainDict("zstd", nil, 4<<10); err == nil {
+		t.Fatalf("train dictionary without samples should fail")
+	}
+	if _, err = TrainDict("lz4", samples, 4<<10); err == nil {
+		t.Fatalf("train dictionary for lz4 should fail")
+	}
 }
 
 func TestLZ4(t *testing.T) {
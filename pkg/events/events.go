@@ -0,0 +1,257 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logger = utils.GetLogger("juicefs")
+
+var (
+	publishedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "events_published",
+		Help: "Number of metadata events published to the sink.",
+	})
+	droppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_dropped",
+		Help: "Number of metadata events dropped, labeled by reason.",
+	}, []string{"reason"})
+)
+
+// InitMetrics registers the metrics of publishers.
+func InitMetrics(registerer prometheus.Registerer) {
+	if registerer == nil {
+		return
+	}
+	registerer.MustRegister(publishedEvents)
+	registerer.MustRegister(droppedEvents)
+}
+
+// Event is a metadata change with resolved paths.
+type Event struct {
+	Seq      uint64 `json:",omitempty"` // 0 if the changelog is not enabled
+	Time     time.Time
+	Sid      uint64
+	Op       string
+	Inode    meta.Ino
+	Path     string
+	NewPath  string   `json:",omitempty"`
+	Replaced meta.Ino `json:",omitempty"` // the inode overwritten by rename
+}
+
+// Sink publishes batches of events to an external system.
+type Sink interface {
+	String() string
+	Publish(events []*Event) error
+	Close() error
+}
+
+// Creator creates a sink from the address after "scheme://".
+type Creator func(scheme, addr string) (Sink, error)
+
+var sinks = make(map[string]Creator)
+
+func Register(scheme string, creator Creator) {
+	sinks[scheme] = creator
+}
+
+// NewSink creates a sink by the scheme of the URI, e.g. kafka://host:9092/topic.
+func NewSink(uri string) (Sink, error) {
+	p := strings.Index(uri, "://")
+	if p < 0 {
+		return nil, fmt.Errorf("invalid events sink: %s", utils.RemovePassword(uri))
+	}
+	scheme := uri[:p]
+	creator, ok := sinks[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported events sink: %s", scheme)
+	}
+	return creator(scheme, uri[p+3:])
+}
+
+// Resolver resolves paths of changed entries, caching paths of directories.
+type Resolver struct {
+	m    meta.Meta
+	dirs map[meta.Ino]string
+}
+
+func NewResolver(m meta.Meta) *Resolver {
+	return &Resolver{m: m, dirs: make(map[meta.Ino]string)}
+}
+
+func (r *Resolver) dir(inode meta.Ino) string {
+	if p, ok := r.dirs[inode]; ok {
+		return p
+	}
+	var p string
+	if ps := r.m.GetPaths(meta.Background(), inode); len(ps) > 0 {
+		p = ps[0]
+	}
+	if len(r.dirs) > 100000 {
+		r.dirs = make(map[meta.Ino]string)
+	}
+	r.dirs[inode] = p
+	return p
+}
+
+// Resolve returns the path of the changed entry, and the new path if it's renamed.
+func (r *Resolver) Resolve(e *meta.ChangeEvent) (p, np string) {
+	if e.Parent > 0 && e.Name != "" {
+		if d := r.dir(e.Parent); d != "" {
+			p = path.Join(d, e.Name)
+		}
+	} else if ps := r.m.GetPaths(meta.Background(), e.Inode); len(ps) > 0 {
+		p = ps[0]
+	}
+	if e.Op == meta.ChangeRename {
+		if d := r.dir(e.NewParent); d != "" {
+			np = path.Join(d, e.NewName)
+		}
+	}
+	if e.Type == meta.TypeDirectory && (e.Op == meta.ChangeRename || e.Op == meta.ChangeUnlink) {
+		r.dirs = make(map[meta.Ino]string) // paths of the subdirectories are changed
+	}
+	return
+}
+
+func (r *Resolver) Event(e *meta.ChangeEvent) *Event {
+	p, np := r.Resolve(e)
+	return &Event{
+		Seq:      e.Seq,
+		Time:     time.Unix(0, e.Time),
+		Sid:      e.Sid,
+		Op:       e.OpName(),
+		Inode:    e.Inode,
+		Path:     p,
+		NewPath:  np,
+		Replaced: e.Replaced,
+	}
+}
+
+// Under checks whether the event happened under any of the directories.
+func (e *Event) Under(dirs []string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+	for _, d := range dirs {
+		if underPath(e.Path, d) || e.NewPath != "" && underPath(e.NewPath, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func underPath(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// Config is the configuration of a publisher.
+type Config struct {
+	Prefixes  []string // only publish events under these directories
+	BatchSize int      // max number of events in a message
+}
+
+// Publisher publishes metadata changes made by a client to a sink.
+type Publisher struct {
+	conf     *Config
+	sink     Sink
+	resolver *Resolver
+	queue    chan []*meta.ChangeEvent // batches are dropped when it's full
+	dropped  uint64
+}
+
+// NewPublisher subscribes the changes of m and publishes them to sink in background.
+func NewPublisher(m meta.Meta, sink Sink, conf *Config) *Publisher {
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 100
+	}
+	for i, p := range conf.Prefixes {
+		conf.Prefixes[i] = path.Clean("/" + p)
+	}
+	p := &Publisher{
+		conf:     conf,
+		sink:     sink,
+		resolver: NewResolver(m),
+		queue:    make(chan []*meta.ChangeEvent, 1024),
+	}
+	m.OnChange(p.enqueue)
+	go p.run()
+	return p
+}
+
+// enqueue is called by the metadata client with the changes, which are buffered in memory until it
+// returns, so the batch is dropped instead of blocking it when the sink is too slow.
+func (p *Publisher) enqueue(events []*meta.ChangeEvent) {
+	select {
+	case p.queue <- events:
+	default:
+		p.drop(len(events), "queue_full")
+		logger.Warnf("Drop %d events because the sink %s is too slow, %d dropped in total", len(events), p.sink, p.Dropped())
+	}
+}
+
+func (p *Publisher) drop(n int, reason string) {
+	atomic.AddUint64(&p.dropped, uint64(n))
+	droppedEvents.WithLabelValues(reason).Add(float64(n))
+}
+
+// Dropped returns the number of events dropped by the publisher.
+func (p *Publisher) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+func (p *Publisher) run() {
+	var batch []*Event
+	for events := range p.queue {
+		for _, e := range events {
+			if ev := p.resolver.Event(e); ev.Under(p.conf.Prefixes) {
+				batch = append(batch, ev)
+			}
+			if len(batch) >= p.conf.BatchSize {
+				p.publish(batch)
+				batch = nil
+			}
+		}
+		if len(batch) > 0 && len(p.queue) == 0 {
+			p.publish(batch)
+			batch = nil
+		}
+	}
+}
+
+func (p *Publisher) publish(batch []*Event) {
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = p.sink.Publish(batch); err == nil {
+			publishedEvents.Add(float64(len(batch)))
+			return
+		}
+		logger.Debugf("Publish %d events to %s: %s", len(batch), p.sink, err)
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+	p.drop(len(batch), "publish_failed")
+	logger.Warnf("Drop %d events after failing to publish to %s: %s, %d dropped in total", len(batch), p.sink, err, p.Dropped())
+}
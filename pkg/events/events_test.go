@@ -0,0 +1,88 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+func TestUnder(t *testing.T) {
+	e := &Event{Path: "/a/b/c", NewPath: "/d/e"}
+	cases := []struct {
+		dirs  []string
+		under bool
+	}{
+		{nil, true},
+		{[]string{"/"}, true},
+		{[]string{"/a"}, true},
+		{[]string{"/a/b/c"}, true},
+		{[]string{"/a/b/cd"}, false},
+		{[]string{"/x", "/d"}, true},
+		{[]string{"/x"}, false},
+	}
+	for _, c := range cases {
+		if r := e.Under(c.dirs); r != c.under {
+			t.Fatalf("Under(%v) = %v, expected %v", c.dirs, r, c.under)
+		}
+	}
+}
+
+func TestDropped(t *testing.T) {
+	p := &Publisher{sink: &webhook{}, queue: make(chan []*meta.ChangeEvent, 1)}
+	events := []*meta.ChangeEvent{{Op: meta.ChangeCreate}, {Op: meta.ChangeWrite}}
+	p.enqueue(events)
+	p.enqueue(events)
+	p.enqueue(events[:1])
+	if d := p.Dropped(); d != 3 {
+		t.Fatalf("dropped events: %d", d)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got []*Event
+	var user, passwd string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, passwd, _ = r.BasicAuth()
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := NewSink("unknown://host/topic"); err == nil {
+		t.Fatalf("unknown scheme should fail")
+	}
+	sink, err := NewSink("http://u:p@" + srv.Listener.Addr().String() + "/hook")
+	if err != nil {
+		t.Fatalf("new sink: %s", err)
+	}
+	defer sink.Close()
+	if err = sink.Publish([]*Event{{Op: "create", Inode: 2, Path: "/f"}}); err != nil {
+		t.Fatalf("publish: %s", err)
+	}
+	if len(got) != 1 || got[0].Op != "create" || got[0].Path != "/f" || got[0].Inode != 2 {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+	if user != "u" || passwd != "p" {
+		t.Fatalf("basic auth: %s:%s", user, passwd)
+	}
+}
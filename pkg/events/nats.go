@@ -0,0 +1,79 @@
+//go:build !nonats
+// +build !nonats
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/nats-io/nats.go"
+)
+
+type natsSink struct {
+	addr    string
+	subject string
+	conn    *nats.Conn
+}
+
+func (n *natsSink) String() string {
+	return "nats://" + utils.RemovePassword(n.addr)
+}
+
+func (n *natsSink) Publish(events []*Event) error {
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err = n.conn.Publish(n.subject, data); err != nil {
+			return err
+		}
+	}
+	return n.conn.FlushTimeout(time.Minute)
+}
+
+func (n *natsSink) Close() error {
+	n.conn.Close()
+	return nil
+}
+
+// nats://[user:password@]host1:4222,host2:4222/subject
+func newNatsSink(scheme, addr string) (Sink, error) {
+	ps := strings.SplitN(addr, "/", 2)
+	if len(ps) != 2 || ps[0] == "" || ps[1] == "" {
+		return nil, fmt.Errorf("invalid nats address %s, should be nats://host:port/subject", utils.RemovePassword(addr))
+	}
+	var servers []string
+	for _, h := range strings.Split(ps[0], ",") {
+		servers = append(servers, scheme+"://"+h)
+	}
+	conn, err := nats.Connect(strings.Join(servers, ","), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats %s: %s", utils.RemovePassword(ps[0]), err)
+	}
+	return &natsSink{addr: addr, subject: ps[1], conn: conn}, nil
+}
+
+func init() {
+	Register("nats", newNatsSink)
+}
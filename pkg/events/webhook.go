@@ -0,0 +1,88 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhook posts events as a JSON array to the URL.
+type webhook struct {
+	url    string
+	user   *url.Userinfo
+	client *http.Client
+}
+
+func (w *webhook) String() string {
+	return w.url
+}
+
+func (w *webhook) Publish(events []*Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.user != nil {
+		passwd, _ := w.user.Password()
+		req.SetBasicAuth(w.user.Username(), passwd)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+func newWebhook(scheme, addr string) (Sink, error) {
+	u, err := url.Parse(scheme + "://" + addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", scheme, err)
+	}
+	user := u.User
+	u.User = nil
+	return &webhook{
+		url:    u.String(),
+		user:   user,
+		client: &http.Client{Timeout: time.Second * 30},
+	}, nil
+}
+
+func init() {
+	Register("http", newWebhook)
+	Register("https", newWebhook)
+}
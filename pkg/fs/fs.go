@@ -170,6 +170,7 @@ type File struct {
 }
 
 func NewFileSystem(conf *vfs.Config, m meta.Meta, d chunk.ChunkStore, registry *prometheus.Registry) (*FileSystem, error) {
+	d.SetChecksumStore(m)
 	reader := vfs.NewDataReader(conf, m, d)
 	fs := &FileSystem{
 		m:               m,
@@ -681,6 +682,9 @@ func (fs *FileSystem) Truncate(ctx meta.Context, path string, length uint64) (er
 		return syscall.EISDIR
 	}
 	err = fs.m.Truncate(ctx, fi.inode, 0, length, nil, false)
+	if err == 0 {
+		fs.InvalidateAttr(fi.inode)
+	}
 	return
 }
 
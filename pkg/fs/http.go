@@ -89,10 +89,34 @@ type webdavFS struct {
 	fs     *FileSystem
 	umask  uint16
 	config WebdavConfig
+	locks  *davLockSystem
+}
+
+type userContextKey struct{}
+
+// mctx returns the context of the user authenticated by OIDC, or the one of the server.
+func (hfs *webdavFS) mctx(ctx context.Context) meta.Context {
+	if c, ok := ctx.Value(userContextKey{}).(meta.Context); ok {
+		return c
+	}
+	return hfs.ctx
+}
+
+func (hfs *webdavFS) created(name string) {
+	if hfs.locks == nil {
+		return
+	}
+	if fi, err := hfs.fs.Stat(hfs.ctx, name); err == 0 {
+		hfs.locks.created(name, fi.Inode())
+	}
 }
 
 func (hfs *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
-	return econv(hfs.fs.Mkdir(hfs.ctx, name, uint16(perm), hfs.umask))
+	err := hfs.fs.Mkdir(hfs.mctx(ctx), name, uint16(perm), hfs.umask)
+	if err == 0 {
+		hfs.created(name)
+	}
+	return econv(err)
 }
 
 func (hfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
@@ -107,33 +131,36 @@ func (hfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		mode |= vfs.MODE_MASK_X
 	}
 	name = strings.TrimRight(name, "/")
-	f, err := hfs.fs.Open(hfs.ctx, name, uint32(mode))
+	mctx := hfs.mctx(ctx)
+	f, err := hfs.fs.Open(mctx, name, uint32(mode))
 	if err != 0 {
 		if err == syscall.ENOENT && flag&os.O_CREATE != 0 {
-			f, err = hfs.fs.Create(hfs.ctx, name, uint16(perm), hfs.umask)
+			if f, err = hfs.fs.Create(mctx, name, uint16(perm), hfs.umask); err == 0 {
+				hfs.created(name)
+			}
 		}
 	} else if flag&os.O_TRUNC != 0 {
-		if errno := hfs.fs.Truncate(hfs.ctx, name, 0); errno != 0 {
+		if errno := hfs.fs.Truncate(mctx, name, 0); errno != 0 {
 			return nil, errno
 		}
 	} else if flag&os.O_APPEND != 0 {
-		if _, err := f.Seek(hfs.ctx, 0, 2); err != nil {
+		if _, err := f.Seek(mctx, 0, 2); err != nil {
 			return nil, err
 		}
 	}
-	return &davFile{f, hfs.ctx, hfs.fs, hfs.config}, econv(err)
+	return &davFile{f, mctx, hfs.fs, hfs.config}, econv(err)
 }
 
 func (hfs *webdavFS) RemoveAll(ctx context.Context, name string) error {
-	return econv(hfs.fs.Rmr(hfs.ctx, name, false, hfs.config.MaxDeletes))
+	return econv(hfs.fs.Rmr(hfs.mctx(ctx), name, false, hfs.config.MaxDeletes))
 }
 
 func (hfs *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
-	return econv(hfs.fs.Rename(hfs.ctx, oldName, newName, 0))
+	return econv(hfs.fs.Rename(hfs.mctx(ctx), oldName, newName, 0))
 }
 
 func (hfs *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	fi, err := hfs.fs.Stat(hfs.ctx, removeNewLine(name))
+	fi, err := hfs.fs.Stat(hfs.mctx(ctx), removeNewLine(name))
 	return fi, econv(err)
 }
 
@@ -253,17 +280,53 @@ type WebdavConfig struct {
 	CertFile        string
 	KeyFile         string
 	MaxDeletes      int
+	OIDCIssuer      string
+	OIDCAudience    string
+	OIDCUserClaim   string
 }
 
 type indexHandler struct {
 	*webdav.Handler
 	WebdavConfig
+	oidc *oidcVerifier
+}
+
+// authOIDC validates the bearer token, or the token as the password of basic authentication for the
+// clients which do not support bearer tokens, and the request is served as the user it's mapped to.
+func (h *indexHandler) authOIDC(w http.ResponseWriter, r *http.Request) *http.Request {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, _ = r.BasicAuth()
+	}
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Restricted"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	name, expire, err := h.oidc.verify(token)
+	if err != nil {
+		logger.Debugf("WEBDAV [%s]: %s, invalid token: %s", r.Method, r.URL, err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Restricted", error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	ctx, err := h.oidc.context(name, expire)
+	if err != nil {
+		logger.Warnf("WEBDAV [%s]: %s, user %s: %s", r.Method, r.URL, name, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, ctx))
 }
 
 func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// http://www.webdav.org/specs/rfc4918.html#n-guidance-for-clients-desiring-to-authenticate
-	if h.Username != "" && h.Password != "" {
+	if h.oidc != nil {
+		if r = h.authOIDC(w, r); r == nil {
+			return
+		}
+	} else if h.Username != "" && h.Password != "" {
 		userName, pwd, ok := r.BasicAuth()
 		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
@@ -284,7 +347,7 @@ func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//
 	// Get, when applied to collection, will return the same as PROPFIND method.
 	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, h.Handler.Prefix) {
-		info, err := h.Handler.FileSystem.Stat(context.TODO(), strings.TrimPrefix(r.URL.Path, h.Handler.Prefix))
+		info, err := h.Handler.FileSystem.Stat(r.Context(), strings.TrimPrefix(r.URL.Path, h.Handler.Prefix))
 		if err == nil && info.IsDir() {
 			if h.DisallowList {
 				http.Error(w, "Forbidden", http.StatusForbidden)
@@ -337,10 +400,11 @@ func (h *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func StartHTTPServer(fs *FileSystem, config WebdavConfig) {
 	ctx := meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
-	hfs := &webdavFS{ctx, fs, uint16(utils.GetUmask()), config}
+	hfs := &webdavFS{ctx: ctx, fs: fs, umask: uint16(utils.GetUmask()), config: config}
+	hfs.locks = newDavLockSystem(ctx, fs)
 	srv := &webdav.Handler{
 		FileSystem: hfs,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: hfs.locks,
 		Logger: func(r *http.Request, err error) {
 			if err != nil {
 				logger.Errorf("WEBDAV [%s]: %s, ERROR: %s", r.Method, r.URL, err)
@@ -349,7 +413,15 @@ func StartHTTPServer(fs *FileSystem, config WebdavConfig) {
 			}
 		},
 	}
-	var h http.Handler = &indexHandler{Handler: srv, WebdavConfig: config}
+	ih := &indexHandler{Handler: srv, WebdavConfig: config}
+	if config.OIDCIssuer != "" {
+		v, err := newOIDCVerifier(config.OIDCIssuer, config.OIDCAudience, config.OIDCUserClaim)
+		if err != nil {
+			logger.Fatalf("Initialize OIDC of %s: %s", config.OIDCIssuer, err)
+		}
+		ih.oidc = v
+	}
+	var h http.Handler = ih
 	if config.EnableGzip {
 		h = makeGzipHandler(h)
 	}
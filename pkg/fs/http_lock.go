@@ -0,0 +1,474 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	davTokenPrefix = "opaquelocktoken:"
+	davLockXattr   = "juicefs.webdav.lock." // + uuid of the token, on the root directory
+	davSessionTTL  = time.Second * 10       // to cache the sessions confirmed alive
+)
+
+// davLock is a WebDAV lock saved as an xattr of the root directory, and the flock held by its server.
+type davLock struct {
+	Root      string
+	ZeroDepth bool   `json:",omitempty"`
+	OwnerXML  string `json:",omitempty"`
+	Duration  time.Duration
+	Expire    int64  `json:",omitempty"` // in unix nanoseconds, zero for infinite timeout
+	Sid       uint64 // of the server created it
+	Owner     uint64 // of the flock
+	Inode     Ino    `json:",omitempty"` // zero before the resource is created
+
+	token string
+}
+
+func (l *davLock) expired(now time.Time) bool {
+	return l.Expire != 0 && now.UnixNano() >= l.Expire
+}
+
+func (l *davLock) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: l.Root, Duration: l.Duration, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+// covers tells whether the resource is locked by the lock.
+func (l *davLock) covers(name string) bool {
+	return l.Root == name || !l.ZeroDepth && isAncestor(l.Root, name)
+}
+
+// conflicts tells whether the lock prevents another one to be created on the resource.
+func (l *davLock) conflicts(name string, zeroDepth bool) bool {
+	return l.covers(name) || !zeroDepth && isAncestor(name, l.Root)
+}
+
+func isAncestor(dir, name string) bool {
+	if dir == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
+
+func davName(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// davLockSystem keeps the WebDAV locks in the metadata engine, as the xattrs of the root directory, so
+// they are shared by all the WebDAV servers of the volume: a lock created by one server can be refreshed
+// or unlocked by the others, and all of them honor the depth-infinity locks on the members of a locked
+// collection. Each lock is also held as a flock by the server created it, which excludes the applications
+// holding flocks on the mount points; the flocks are released by the metadata engine once the session is
+// gone, and the locks of the sessions gone are dropped by the other servers.
+type davLockSystem struct {
+	ctx meta.Context
+	fs  *FileSystem
+
+	mu    sync.Mutex
+	locks map[string]*davLock // created by this server, by token
+	owner uint64
+	alive map[uint64]time.Time // the sessions confirmed alive
+}
+
+func newDavLockSystem(ctx meta.Context, fs *FileSystem) *davLockSystem {
+	ls := &davLockSystem{
+		ctx:   ctx,
+		fs:    fs,
+		locks: make(map[string]*davLock),
+		owner: rand.Uint64(),
+		alive: make(map[uint64]time.Time),
+	}
+	go func() {
+		for range time.NewTicker(time.Second).C {
+			ls.mu.Lock()
+			ls.expire(time.Now())
+			ls.refresh()
+			ls.mu.Unlock()
+		}
+	}()
+	return ls
+}
+
+func (ls *davLockSystem) sid() uint64 {
+	if ls.fs.conf.Meta == nil {
+		return 0
+	}
+	return ls.fs.conf.Meta.Sid
+}
+
+func (ls *davLockSystem) nextOwner() uint64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.owner++
+	return ls.owner
+}
+
+// expire releases the flocks of the expired locks created by this server, and removes them, unless
+// they are refreshed through the other servers.
+func (ls *davLockSystem) expire(now time.Time) {
+	for token, l := range ls.locks {
+		if !l.expired(now) {
+			continue
+		}
+		if r, st := ls.load(token); st == 0 && !r.expired(now) {
+			l.Duration, l.Expire = r.Duration, r.Expire
+			continue
+		}
+		ls.release(l)
+		ls.remove(token)
+		delete(ls.locks, token)
+	}
+}
+
+// refresh follows the locks created by this server which are refreshed or unlocked through the other
+// servers, and releases the flocks of the unlocked ones.
+func (ls *davLockSystem) refresh() {
+	for token, l := range ls.locks {
+		r, st := ls.load(token)
+		if st == meta.ENOATTR {
+			ls.release(l)
+			delete(ls.locks, token)
+		} else if st == 0 {
+			l.Duration, l.Expire = r.Duration, r.Expire
+		}
+	}
+}
+
+func (ls *davLockSystem) release(l *davLock) {
+	if l.Inode == 0 {
+		return
+	}
+	if st := ls.fs.Meta().Flock(ls.ctx, l.Inode, l.Owner, meta.F_UNLCK, false); st != 0 {
+		logger.Warnf("Release flock of inode %d: %s", l.Inode, st)
+	}
+}
+
+func (ls *davLockSystem) load(token string) (*davLock, syscall.Errno) {
+	id, ok := strings.CutPrefix(token, davTokenPrefix)
+	if !ok || strings.ContainsRune(id, 0) {
+		return nil, meta.ENOATTR
+	}
+	var buf []byte
+	if st := ls.fs.Meta().GetXattr(ls.ctx, meta.RootInode, davLockXattr+id, &buf); st != 0 {
+		return nil, st
+	}
+	l := &davLock{token: token}
+	if err := json.Unmarshal(buf, l); err != nil {
+		logger.Warnf("Invalid WebDAV lock %s: %s", token, err)
+		return nil, meta.ENOATTR
+	}
+	return l, 0
+}
+
+func (ls *davLockSystem) save(l *davLock, flags uint32) syscall.Errno {
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return syscall.EINVAL
+	}
+	return ls.fs.Meta().SetXattr(ls.ctx, meta.RootInode, davLockXattr+strings.TrimPrefix(l.token, davTokenPrefix), buf, flags)
+}
+
+func (ls *davLockSystem) remove(token string) {
+	st := ls.fs.Meta().RemoveXattr(ls.ctx, meta.RootInode, davLockXattr+strings.TrimPrefix(token, davTokenPrefix))
+	if st != 0 && st != meta.ENOATTR {
+		logger.Warnf("Remove WebDAV lock %s: %s", token, st)
+	}
+}
+
+// valid tells whether the lock is still in effect, the invalid ones are removed.
+func (ls *davLockSystem) valid(now time.Time, l *davLock) bool {
+	if !l.expired(now) && ls.sessionAlive(now, l.Sid) {
+		return true
+	}
+	ls.remove(l.token)
+	return false
+}
+
+func (ls *davLockSystem) sessionAlive(now time.Time, sid uint64) bool {
+	if sid == ls.sid() {
+		return true
+	}
+	if t, ok := ls.alive[sid]; ok && now.Sub(t) < davSessionTTL {
+		return true
+	}
+	if _, err := ls.fs.Meta().GetSession(sid, false); err != nil {
+		logger.Debugf("Session %d of WebDAV lock: %s", sid, err)
+		delete(ls.alive, sid)
+		return false
+	}
+	ls.alive[sid] = now
+	return true
+}
+
+// lookup returns the lock by token, if it's still in effect.
+func (ls *davLockSystem) lookup(now time.Time, token string) (*davLock, error) {
+	l, st := ls.load(token)
+	if st == meta.ENOATTR {
+		return nil, webdav.ErrNoSuchLock
+	} else if st != 0 {
+		return nil, econv(st)
+	}
+	if !ls.valid(now, l) {
+		return nil, webdav.ErrNoSuchLock
+	}
+	return l, nil
+}
+
+// list returns all the locks in effect of the volume.
+func (ls *davLockSystem) list(now time.Time) ([]*davLock, error) {
+	var names []byte
+	if st := ls.fs.Meta().ListXattr(ls.ctx, meta.RootInode, &names); st != 0 {
+		return nil, econv(st)
+	}
+	var locks []*davLock
+	for _, name := range bytes.Split(names, []byte{0}) {
+		id, ok := strings.CutPrefix(string(name), davLockXattr)
+		if !ok {
+			continue
+		}
+		l, st := ls.load(davTokenPrefix + id)
+		if st == meta.ENOATTR {
+			continue // unlocked
+		} else if st != 0 {
+			return nil, econv(st)
+		}
+		if ls.valid(now, l) {
+			locks = append(locks, l)
+		}
+	}
+	return locks, nil
+}
+
+func (ls *davLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	root := davName(details.Root)
+	locks, err := ls.list(now)
+	if err != nil {
+		return "", err
+	}
+	for _, o := range locks {
+		if o.conflicts(root, details.ZeroDepth) {
+			return "", webdav.ErrLocked
+		}
+	}
+	ls.owner++
+	l := &davLock{
+		Root:      root,
+		ZeroDepth: details.ZeroDepth,
+		OwnerXML:  details.OwnerXML,
+		Duration:  details.Duration,
+		Sid:       ls.sid(),
+		Owner:     ls.owner,
+		token:     davTokenPrefix + uuid.New().String(),
+	}
+	if details.Duration >= 0 {
+		l.Expire = now.Add(details.Duration).UnixNano()
+	}
+	fi, st := ls.fs.Stat(ls.ctx, root)
+	if st == 0 {
+		st = ls.fs.Meta().Flock(ls.ctx, fi.Inode(), l.Owner, meta.F_WRLCK, false)
+		if st == 0 {
+			l.Inode = fi.Inode()
+		}
+	} else if st == syscall.ENOENT {
+		st = 0 // the flock is held once it's created
+	}
+	if st == 0 {
+		if st = ls.save(l, meta.XattrCreate); st != 0 {
+			ls.release(l)
+		}
+	}
+	if st != 0 {
+		if st == syscall.EAGAIN {
+			return "", webdav.ErrLocked
+		}
+		return "", econv(st)
+	}
+	// another server may create a conflicting lock at the same time, both of them give up then
+	if locks, err = ls.list(now); err == nil {
+		for _, o := range locks {
+			if o.token != l.token && o.conflicts(root, details.ZeroDepth) {
+				err = webdav.ErrLocked
+				break
+			}
+		}
+	}
+	if err != nil {
+		ls.release(l)
+		ls.remove(l.token)
+		return "", err
+	}
+	ls.locks[l.token] = l
+	return l.token, nil
+}
+
+// created holds the flocks of the locks on the resource which did not exist when they were created.
+func (ls *davLockSystem) created(name string, inode Ino) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	name = davName(name)
+	for token, l := range ls.locks {
+		if l.Inode != 0 || l.Root != name {
+			continue
+		}
+		if st := ls.fs.Meta().Flock(ls.ctx, inode, l.Owner, meta.F_WRLCK, false); st != 0 {
+			logger.Warnf("Flock %s created with lock: %s", name, st)
+			ls.remove(token)
+			delete(ls.locks, token)
+			continue
+		}
+		l.Inode = inode
+		if st := ls.save(l, meta.XattrReplace); st != 0 {
+			logger.Warnf("Update WebDAV lock %s: %s", token, st)
+		}
+	}
+}
+
+// probe holds a flock on the existing resource during the request, unless it's the root of a confirmed
+// lock (whose flock is held by the server created it).
+func (ls *davLockSystem) probe(name string, confirmed []*davLock) (*davLock, error) {
+	fi, st := ls.fs.Stat(ls.ctx, name)
+	if st != 0 {
+		return nil, nil
+	}
+	for _, l := range confirmed {
+		if l.Inode == fi.Inode() {
+			return nil, nil
+		}
+	}
+	l := &davLock{Inode: fi.Inode(), Owner: ls.nextOwner()}
+	if st = ls.fs.Meta().Flock(ls.ctx, l.Inode, l.Owner, meta.F_WRLCK, false); st != 0 {
+		if st == syscall.EAGAIN {
+			return nil, webdav.ErrLocked
+		}
+		return nil, econv(st)
+	}
+	return l, nil
+}
+
+func (ls *davLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	ls.expire(now)
+	var confirmed []*davLock
+	for _, c := range conditions {
+		if c.Token == "" {
+			continue
+		}
+		l, err := ls.lookup(now, c.Token)
+		if err == webdav.ErrNoSuchLock {
+			continue
+		} else if err != nil {
+			ls.mu.Unlock()
+			return nil, err
+		}
+		confirmed = append(confirmed, l)
+	}
+	ls.mu.Unlock()
+
+	var names []string
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		name = davName(name)
+		var covered bool
+		for _, l := range confirmed {
+			if l.covers(name) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		names = append(names, name)
+	}
+
+	var probes []*davLock
+	release := func() {
+		for _, l := range probes {
+			ls.release(l)
+		}
+	}
+	for _, name := range names {
+		l, err := ls.probe(name, confirmed)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if l != nil {
+			probes = append(probes, l)
+		}
+	}
+	return release, nil
+}
+
+func (ls *davLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	l, err := ls.lookup(now, token)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	l.Duration, l.Expire = duration, 0
+	if duration >= 0 {
+		l.Expire = now.Add(duration).UnixNano()
+	}
+	if st := ls.save(l, meta.XattrReplace); st == meta.ENOATTR {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	} else if st != 0 {
+		return webdav.LockDetails{}, econv(st)
+	}
+	if o := ls.locks[token]; o != nil {
+		o.Duration, o.Expire = l.Duration, l.Expire
+	}
+	return l.details(), nil
+}
+
+func (ls *davLockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	if _, err := ls.lookup(now, token); err != nil {
+		return err
+	}
+	ls.remove(token)
+	// the flock held by another server is released once it finds the lock removed
+	if l := ls.locks[token]; l != nil {
+		ls.release(l)
+		delete(ls.locks, token)
+	}
+	return nil
+}
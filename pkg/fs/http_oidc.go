@@ -0,0 +1,285 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+const (
+	oidcRefreshInterval = time.Minute     // the least interval to refetch the keys for an unknown key ID
+	oidcUserTTL         = time.Minute * 5 // the most time to cache a local user, to follow the changes of it
+)
+
+// oidcVerifier validates the bearer tokens issued by an OpenID Connect provider, and maps them to the
+// local users by a claim.
+type oidcVerifier struct {
+	issuer   string
+	audience string
+	claim    string
+	client   *http.Client
+
+	sync.Mutex
+	jwksURL string
+	keys    map[string]interface{} // by key ID
+	fetched time.Time
+	users   map[string]*oidcUser // by name
+}
+
+// oidcUser is a cached local user, it's valid until the token it's mapped from expires.
+type oidcUser struct {
+	ctx    meta.Context
+	expire time.Time
+}
+
+func newOIDCVerifier(issuer, audience, claim string) (*oidcVerifier, error) {
+	v := &oidcVerifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		claim:    claim,
+		client:   &http.Client{Timeout: time.Second * 10},
+		users:    make(map[string]*oidcUser),
+	}
+	var conf struct {
+		Issuer  string `json:"issuer"`
+		JWKSURL string `json:"jwks_uri"`
+	}
+	if err := v.get(v.issuer+"/.well-known/openid-configuration", &conf); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(conf.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("issuer %q does not match %q", conf.Issuer, issuer)
+	}
+	v.jwksURL = conf.JWKSURL
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *oidcVerifier) get(url string, result interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// refresh fetches the signing keys of the provider, it should be called with lock held except in the
+// constructor.
+func (v *oidcVerifier) refresh() error {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	v.fetched = time.Now()
+	if err := v.get(v.jwksURL, &jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Warnf("Ignore key %s of %s: %s", k.Kid, v.issuer, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	kid, _ := t.Header["kid"].(string)
+	v.Lock()
+	defer v.Unlock()
+	key, ok := v.keys[kid]
+	if !ok && time.Since(v.fetched) > oidcRefreshInterval {
+		if err := v.refresh(); err != nil {
+			return nil, err
+		}
+		key, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// verify validates the token, and returns the name of the local user it's mapped to and the time the
+// token expires (zero if it has no expiration).
+func (v *oidcVerifier) verify(token string) (string, time.Time, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, v.key); err != nil {
+		return "", time.Time{}, err
+	}
+	if !claims.VerifyIssuer(v.issuer, true) && !claims.VerifyIssuer(v.issuer+"/", true) {
+		return "", time.Time{}, fmt.Errorf("invalid issuer %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return "", time.Time{}, fmt.Errorf("invalid audience %v", claims["aud"])
+	}
+	name, _ := claims[v.claim].(string)
+	if name == "" {
+		return "", time.Time{}, fmt.Errorf("no claim %s", v.claim)
+	}
+	var expire time.Time
+	switch exp := claims["exp"].(type) {
+	case float64:
+		expire = time.Unix(int64(exp), 0)
+	case json.Number:
+		if n, err := exp.Int64(); err == nil {
+			expire = time.Unix(n, 0)
+		}
+	}
+	return name, expire, nil
+}
+
+// context returns the context of the local user, with the groups it belongs to. It's cached until the
+// token expires, but no longer than oidcUserTTL.
+func (v *oidcVerifier) context(name string, expire time.Time) (meta.Context, error) {
+	now := time.Now()
+	v.Lock()
+	u, ok := v.users[name]
+	v.Unlock()
+	if ok && now.Before(u.expire) {
+		return u.ctx, nil
+	}
+	ctx, err := lookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if expire.IsZero() || expire.After(now.Add(oidcUserTTL)) {
+		expire = now.Add(oidcUserTTL)
+	}
+	v.Lock()
+	for n, u := range v.users {
+		if !now.Before(u.expire) {
+			delete(v.users, n)
+		}
+	}
+	v.users[name] = &oidcUser{ctx, expire}
+	v.Unlock()
+	return ctx, nil
+}
+
+func lookupUser(name string) (meta.Context, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %s of %s", u.Uid, name)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %s of %s", u.Gid, name)
+	}
+	gids := []uint32{uint32(gid)}
+	if groups, err := u.GroupIds(); err == nil {
+		for _, g := range groups {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil && id != gid {
+				gids = append(gids, uint32(id))
+			}
+		}
+	}
+	return meta.NewContext(uint32(os.Getpid()), uint32(uid), gids), nil
+}
@@ -18,18 +18,30 @@ package fs
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"io"
 	"io/fs"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/user"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
+	"golang.org/x/net/webdav"
 )
 
 func TestWebdav(t *testing.T) {
 	jfs := createTestFS(t)
-	webdavFS := &webdavFS{meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())}), jfs, uint16(utils.GetUmask()), WebdavConfig{EnableProppatch: true}}
+	webdavFS := &webdavFS{meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())}), jfs, uint16(utils.GetUmask()), WebdavConfig{EnableProppatch: true}, nil}
 	ctx := context.Background()
 	_, err := webdavFS.Stat(ctx, "/")
 	if err != nil {
@@ -85,3 +97,191 @@ func TestWebdav(t *testing.T) {
 		t.Fatalf("webdavFS close file failed: %s", err)
 	}
 }
+
+func TestWebdavLock(t *testing.T) {
+	jfs := createTestFS(t)
+	ctx := meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
+	ls1 := newDavLockSystem(ctx, jfs)
+	ls2 := newDavLockSystem(ctx, jfs) // another server of the volume
+	hfs := &webdavFS{ctx: ctx, fs: jfs, umask: 022, locks: ls1}
+	now := time.Now()
+	token, err := ls1.Create(now, webdav.LockDetails{Root: "/locked", Duration: time.Minute, ZeroDepth: true})
+	if err != nil {
+		t.Fatalf("create lock: %s", err)
+	}
+	// the file is created by the handler after the lock
+	f, err := hfs.OpenFile(context.Background(), "/locked", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		t.Fatalf("create locked file: %s", err)
+	}
+	_ = f.Close()
+	if _, err = ls2.Create(now, webdav.LockDetails{Root: "/locked", Duration: time.Minute}); err != webdav.ErrLocked {
+		t.Fatalf("lock the locked file from another server: %v", err)
+	}
+	if _, err = ls1.Confirm(now, "/locked", ""); err != webdav.ErrConfirmationFailed {
+		t.Fatalf("write the locked file without token: %v", err)
+	}
+	release, err := ls1.Confirm(now, "/locked", "", webdav.Condition{Token: token})
+	if err != nil {
+		t.Fatalf("write the locked file with token: %s", err)
+	}
+	release()
+	// the lock is shared by the servers
+	release, err = ls2.Confirm(now, "/locked", "", webdav.Condition{Token: token})
+	if err != nil {
+		t.Fatalf("write the locked file with token from another server: %s", err)
+	}
+	release()
+	if _, err = ls2.Refresh(now, token, time.Second); err != nil {
+		t.Fatalf("refresh the lock from another server: %s", err)
+	}
+
+	// a depth-infinity lock on a directory
+	if _, err = ls2.Create(now, webdav.LockDetails{Root: "/", Duration: time.Minute}); err != webdav.ErrLocked {
+		t.Fatalf("lock root with a locked member: %v", err)
+	}
+	if err = hfs.Mkdir(context.Background(), "/dir", 0755); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	token2, err := ls2.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	if err != nil {
+		t.Fatalf("lock dir: %s", err)
+	}
+	if _, err = ls1.Create(now, webdav.LockDetails{Root: "/dir/a/b", Duration: time.Minute, ZeroDepth: true}); err != webdav.ErrLocked {
+		t.Fatalf("lock a member of the locked dir from another server: %v", err)
+	}
+	if _, err = ls1.Confirm(now, "/dir/a", "", webdav.Condition{Token: token}); err != webdav.ErrConfirmationFailed {
+		t.Fatalf("write a member of the locked dir without its token: %v", err)
+	}
+	if release, err = ls1.Confirm(now, "/dir/a", "", webdav.Condition{Token: token2}); err != nil {
+		t.Fatalf("write a member of the locked dir from another server: %s", err)
+	}
+	release()
+	if err = ls1.Unlock(now, token2); err != nil {
+		t.Fatalf("unlock from another server: %s", err)
+	}
+	if err = ls2.Unlock(now, token2); err != webdav.ErrNoSuchLock {
+		t.Fatalf("unlock again: %v", err)
+	}
+	ls2.mu.Lock()
+	ls2.refresh()
+	n := len(ls2.locks)
+	ls2.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("flock of the unlocked dir is still held")
+	}
+	if _, err = ls1.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute, ZeroDepth: true}); err != nil {
+		t.Fatalf("lock the unlocked dir: %s", err)
+	}
+
+	// expired
+	now = now.Add(time.Second * 2)
+	ls1.mu.Lock()
+	ls1.refresh() // refreshed through ls2
+	ls1.expire(now)
+	ls1.mu.Unlock()
+	token, err = ls2.Create(now, webdav.LockDetails{Root: "/locked", Duration: -1})
+	if err != nil {
+		t.Fatalf("lock the file with expired lock: %s", err)
+	}
+	if err = ls2.Unlock(now, token); err != nil {
+		t.Fatalf("unlock: %s", err)
+	}
+	if _, err = ls1.Create(now, webdav.LockDetails{Root: "/locked", Duration: time.Minute}); err != nil {
+		t.Fatalf("lock the unlocked file: %s", err)
+	}
+}
+
+func TestWebdavOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+	var issuer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+		case "/keys":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "k1", "kty": "RSA", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+	v, err := newOIDCVerifier(issuer, "webdav", "preferred_username")
+	if err != nil {
+		t.Fatalf("new verifier: %s", err)
+	}
+	sign := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign token: %s", err)
+		}
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	if name, expire, err := v.verify(sign("k1", jwt.MapClaims{"iss": issuer, "aud": "webdav", "exp": exp, "preferred_username": "alice"})); err != nil || name != "alice" || expire.Unix() != exp {
+		t.Fatalf("verify valid token: %s %s %v", name, expire, err)
+	}
+	if _, _, err := v.verify(sign("k1", jwt.MapClaims{"iss": issuer, "aud": []string{"other", "webdav"}, "exp": exp, "preferred_username": "alice"})); err != nil {
+		t.Fatalf("verify token of multiple audiences: %s", err)
+	}
+	for _, claims := range []jwt.MapClaims{
+		{"iss": issuer, "aud": "webdav", "exp": time.Now().Add(-time.Minute).Unix(), "preferred_username": "alice"},
+		{"iss": "https://other", "aud": "webdav", "exp": exp, "preferred_username": "alice"},
+		{"iss": issuer, "aud": "other", "exp": exp, "preferred_username": "alice"},
+		{"iss": issuer, "aud": "webdav", "exp": exp},
+	} {
+		if _, _, err := v.verify(sign("k1", claims)); err == nil {
+			t.Fatalf("verify invalid token %v", claims)
+		}
+	}
+	if _, _, err := v.verify(sign("k2", jwt.MapClaims{"iss": issuer, "aud": "webdav", "exp": exp, "preferred_username": "alice"})); err == nil {
+		t.Fatalf("verify token of unknown key")
+	}
+	hs256 := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": issuer, "aud": "webdav", "exp": exp, "preferred_username": "alice"})
+	hs256.Header["kid"] = "k1"
+	if s, _ := hs256.SignedString([]byte("secret")); s != "" {
+		if _, _, err := v.verify(s); err == nil {
+			t.Fatalf("verify token signed by HMAC")
+		}
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("current user: %s", err)
+	}
+	ctx, err := v.context(u.Username, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("context of %s: %s", u.Username, err)
+	}
+	if strconv.Itoa(int(ctx.Uid())) != u.Uid {
+		t.Fatalf("uid of %s: %d != %s", u.Username, ctx.Uid(), u.Uid)
+	}
+	if c := v.users[u.Username]; c == nil || time.Until(c.expire) > oidcUserTTL {
+		t.Fatalf("user %s should be cached no longer than %s", u.Username, oidcUserTTL)
+	}
+	// cached until the token expires, and evicted then
+	v.users["expired"] = &oidcUser{ctx, time.Now().Add(-time.Second)}
+	v.users[u.Username].expire = time.Now().Add(-time.Second)
+	if _, err = v.context(u.Username, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("context of %s: %s", u.Username, err)
+	}
+	if _, ok := v.users["expired"]; ok {
+		t.Fatalf("expired user is not evicted")
+	}
+	if c := v.users[u.Username]; c == nil || time.Until(c.expire) > time.Minute {
+		t.Fatalf("user %s should be cached until the token expires", u.Username)
+	}
+	if _, err = v.context("no-such-user-of-juicefs", time.Time{}); err == nil {
+		t.Fatalf("context of unknown user")
+	}
+}
@@ -0,0 +1,475 @@
+//go:build !nosftp
+// +build !nosftp
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juicedata/juicefs/pkg/vfs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPUser is a user of the SFTP server, who is jailed in its home directory.
+type SFTPUser struct {
+	Name           string
+	Password       string // bcrypt hash, empty to disable the password authentication
+	Uid, Gid       uint32
+	Home           string // the directory of the volume as the root of the user
+	AuthorizedKeys []ssh.PublicKey
+}
+
+type SFTPConfig struct {
+	Addr     string
+	HostKeys []ssh.Signer
+	Users    map[string]*SFTPUser
+}
+
+type sftpServer struct {
+	fs     *FileSystem
+	ctx    meta.Context
+	config SFTPConfig
+	umask  uint16
+}
+
+func (s *sftpServer) auth(conn ssh.ConnMetadata, check func(u *SFTPUser) bool) (*ssh.Permissions, error) {
+	u := s.config.Users[conn.User()]
+	if u == nil || !check(u) {
+		logger.Warnf("SFTP %s@%s: authentication failed", conn.User(), conn.RemoteAddr())
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"user": u.Name}}, nil
+}
+
+func (s *sftpServer) sshConfig() *ssh.ServerConfig {
+	conf := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return s.auth(conn, func(u *SFTPUser) bool {
+				return u.Password != "" && bcrypt.CompareHashAndPassword([]byte(u.Password), password) == nil
+			})
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return s.auth(conn, func(u *SFTPUser) bool {
+				for _, k := range u.AuthorizedKeys {
+					if bytes.Equal(k.Marshal(), key.Marshal()) {
+						return true
+					}
+				}
+				return false
+			})
+		},
+	}
+	for _, k := range s.config.HostKeys {
+		conf.AddHostKey(k)
+	}
+	return conf
+}
+
+func (s *sftpServer) serve(ln net.Listener) error {
+	conf := s.sshConfig()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn, conf)
+	}
+}
+
+func (s *sftpServer) handleConn(conn net.Conn, conf *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	if err != nil {
+		logger.Debugf("SFTP handshake with %s: %s", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	h, err := s.newHandler(s.config.Users[sconn.Permissions.Extensions["user"]], sconn.RemoteAddr().String())
+	if err != nil {
+		logger.Errorf("SFTP %s@%s: %s", sconn.User(), sconn.RemoteAddr(), err)
+		return
+	}
+	logger.Infof("SFTP %s: logged in", h)
+	defer logger.Infof("SFTP %s: logged out", h)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			logger.Warnf("SFTP %s: accept channel: %s", h, err)
+			continue
+		}
+		go func() {
+			for req := range creqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				srv := sftp.NewRequestServer(ch, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h})
+				if err := srv.Serve(); err != nil && err != io.EOF {
+					logger.Warnf("SFTP %s: %s", h, err)
+				}
+				_ = srv.Close()
+			}
+		}()
+	}
+}
+
+// sftpHandler serves the requests of a user, with the paths relative to its home directory.
+type sftpHandler struct {
+	s      *sftpServer
+	ctx    meta.Context
+	user   *SFTPUser
+	remote string
+}
+
+func (s *sftpServer) newHandler(u *SFTPUser, remote string) (*sftpHandler, error) {
+	ctx := meta.NewContext(uint32(os.Getpid()), u.Uid, []uint32{u.Gid})
+	h := &sftpHandler{s, ctx, u, remote}
+	fi, err := s.fs.Stat(ctx, u.Home)
+	if err == syscall.ENOENT {
+		// created by the server, and then handed over to the user
+		if err = s.fs.MkdirAll(s.ctx, u.Home, 0755, s.umask); err == 0 {
+			var f *File
+			if f, err = s.fs.Open(s.ctx, u.Home, 0); err == 0 {
+				err = f.Chown(s.ctx, u.Uid, u.Gid)
+			}
+		}
+	} else if err == 0 && !fi.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err != 0 {
+		return nil, fmt.Errorf("home directory %s: %s", u.Home, err)
+	}
+	return h, nil
+}
+
+func (h *sftpHandler) String() string {
+	return h.user.Name + "@" + h.remote
+}
+
+const maxSFTPSymlinks = 40
+
+// path returns the path in the volume of p, which is relative to the home directory. The symlinks are
+// resolved inside the home directory as chroot does (the absolute targets are relative to it, and ".."
+// stops at it), so they can't point out of it. The last component is resolved only if follow.
+func (h *sftpHandler) path(p string, follow bool) (string, syscall.Errno) {
+	names := strings.Split(path.Clean("/"+p), "/")
+	cur := "/"
+	for links := 0; len(names) > 0; {
+		name := names[0]
+		names = names[1:]
+		if name == "" || name == "." {
+			continue
+		} else if name == ".." {
+			cur = path.Dir(cur)
+			continue
+		}
+		next := path.Join(cur, name)
+		if len(names) == 0 && !follow {
+			cur = next
+			break
+		}
+		fi, err := h.s.fs.Lstat(h.ctx, path.Join(h.user.Home, next))
+		if err == syscall.ENOENT {
+			// to be created
+			cur = path.Join(append([]string{next}, names...)...)
+			break
+		} else if err != 0 {
+			return "", err
+		}
+		if !fi.IsSymlink() {
+			cur = next
+			continue
+		}
+		if links++; links > maxSFTPSymlinks {
+			return "", syscall.ELOOP
+		}
+		target, err := h.s.fs.Readlink(h.ctx, path.Join(h.user.Home, next))
+		if err != 0 {
+			return "", err
+		}
+		if strings.HasPrefix(string(target), "/") {
+			cur = "/"
+		}
+		names = append(strings.Split(string(target), "/"), names...)
+	}
+	return path.Join(h.user.Home, cur), 0
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	p, err := h.path(r.Filepath, true)
+	if err != 0 {
+		return nil, econv(err)
+	}
+	f, err := h.s.fs.Open(h.ctx, p, vfs.MODE_MASK_R)
+	if err != 0 {
+		return nil, econv(err)
+	}
+	if f.info.IsDir() {
+		_ = f.Close(h.ctx)
+		return nil, syscall.EISDIR
+	}
+	return &sftpFile{File: f, h: h, op: "download", name: r.Filepath, start: time.Now()}, nil
+}
+
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.open(r)
+}
+
+func (h *sftpHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return h.open(r)
+}
+
+func (h *sftpHandler) open(r *sftp.Request) (*sftpFile, error) {
+	flags := r.Pflags()
+	p, err := h.path(r.Filepath, true)
+	if err != 0 {
+		return nil, econv(err)
+	}
+	mode := uint32(vfs.MODE_MASK_W)
+	if flags.Read {
+		mode |= vfs.MODE_MASK_R
+	}
+	var f *File
+	err = syscall.ENOENT
+	if !flags.Excl {
+		if flags.Trunc {
+			err = h.s.fs.Truncate(h.ctx, p, 0)
+		}
+		if err == 0 || !flags.Trunc {
+			f, err = h.s.fs.Open(h.ctx, p, mode)
+		}
+	}
+	if err == syscall.ENOENT && flags.Creat {
+		perm := uint16(0666)
+		if attrs := r.Attributes(); r.AttrFlags().Permissions {
+			perm = uint16(attrs.FileMode().Perm())
+		}
+		f, err = h.s.fs.Create(h.ctx, p, perm, h.s.umask)
+		if err == 0 && mode != vfs.MODE_MASK_W {
+			_ = f.Close(h.ctx)
+			f, err = h.s.fs.Open(h.ctx, p, mode)
+		}
+	}
+	if err != 0 {
+		return nil, econv(err)
+	}
+	if f.info.IsDir() {
+		_ = f.Close(h.ctx)
+		return nil, syscall.EISDIR
+	}
+	return &sftpFile{File: f, h: h, op: "upload", name: r.Filepath, start: time.Now()}, nil
+}
+
+func (h *sftpHandler) setstat(r *sftp.Request) syscall.Errno {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	p, err := h.path(r.Filepath, true)
+	if err != 0 {
+		return err
+	}
+	if flags.Size {
+		if err := h.s.fs.Truncate(h.ctx, p, attrs.Size); err != 0 {
+			return err
+		}
+	}
+	if !flags.Permissions && !flags.UidGid && !flags.Acmodtime {
+		return 0
+	}
+	f, err := h.s.fs.Open(h.ctx, p, 0)
+	if err != 0 {
+		return err
+	}
+	if flags.Permissions {
+		if err = f.Chmod(h.ctx, uint16(attrs.Mode&07777)); err != 0 {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err = f.Chown(h.ctx, attrs.UID, attrs.GID); err != 0 {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		err = f.Utime(h.ctx, int64(attrs.Atime)*1000, int64(attrs.Mtime)*1000)
+	}
+	return err
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	var err syscall.Errno
+	var p, target string
+	switch r.Method {
+	case "Setstat":
+		err = h.setstat(r)
+	case "Rename":
+		if p, err = h.path(r.Filepath, false); err == 0 {
+			if target, err = h.path(r.Target, false); err == 0 {
+				err = h.s.fs.Rename(h.ctx, p, target, meta.RenameNoReplace)
+			}
+		}
+	case "Rmdir", "Mkdir", "Remove":
+		if p, err = h.path(r.Filepath, false); err != 0 {
+			break
+		}
+		switch r.Method {
+		case "Rmdir":
+			err = h.s.fs.Rmdir(h.ctx, p)
+		case "Mkdir":
+			err = h.s.fs.Mkdir(h.ctx, p, 0777, h.s.umask)
+		case "Remove":
+			err = h.s.fs.Unlink(h.ctx, p)
+		}
+	default:
+		// links are not allowed to be created
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if r.Method != "Setstat" {
+		logger.Infof("SFTP %s: %s %s %s: %s", h, r.Method, r.Filepath, r.Target, errstr(err))
+	}
+	return econv(err)
+}
+
+func (h *sftpHandler) PosixRename(r *sftp.Request) error {
+	p, err := h.path(r.Filepath, false)
+	if err == 0 {
+		var target string
+		if target, err = h.path(r.Target, false); err == 0 {
+			err = h.s.fs.Rename(h.ctx, p, target, 0)
+		}
+	}
+	logger.Infof("SFTP %s: Rename %s %s: %s", h, r.Filepath, r.Target, errstr(err))
+	return econv(err)
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	return h.list(r, true)
+}
+
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	return h.list(r, false)
+}
+
+func (h *sftpHandler) list(r *sftp.Request, follow bool) (sftp.ListerAt, error) {
+	p, err := h.path(r.Filepath, r.Method == "List" || r.Method != "Readlink" && follow)
+	if err != 0 {
+		return nil, econv(err)
+	}
+	switch r.Method {
+	case "List":
+		f, err := h.s.fs.Open(h.ctx, p, 0)
+		if err != 0 {
+			return nil, econv(err)
+		}
+		defer f.Close(h.ctx)
+		if !f.info.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		entries, err := f.Readdir(h.ctx, 0)
+		return sftpLister(entries), econv(err)
+	case "Readlink":
+		target, err := h.s.fs.Readlink(h.ctx, p)
+		if err != 0 {
+			return nil, econv(err)
+		}
+		return sftpLister{&FileStat{name: string(target), attr: &Attr{Typ: meta.TypeSymlink}}}, nil
+	default:
+		// the symlinks are resolved already
+		fi, err := h.s.fs.Lstat(h.ctx, p)
+		if err != 0 {
+			return nil, econv(err)
+		}
+		return sftpLister{fi}, nil
+	}
+}
+
+type sftpLister []os.FileInfo
+
+func (l sftpLister) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpFile is an opened file, which is logged with the transferred bytes once closed.
+type sftpFile struct {
+	*File
+	h     *sftpHandler
+	op    string
+	name  string
+	start time.Time
+	bytes int64
+}
+
+func (f *sftpFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.Pread(f.h.ctx, b, off)
+	atomic.AddInt64(&f.bytes, int64(n))
+	return n, err
+}
+
+func (f *sftpFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.Pwrite(f.h.ctx, b, off)
+	atomic.AddInt64(&f.bytes, int64(n))
+	if err != 0 {
+		return n, econv(err)
+	}
+	return n, nil
+}
+
+func (f *sftpFile) Close() error {
+	err := f.File.Close(f.h.ctx)
+	logger.Infof("SFTP %s: %s %s %d bytes in %s: %s", f.h, f.op, f.name, atomic.LoadInt64(&f.bytes),
+		time.Since(f.start).Round(time.Millisecond), errstr(err))
+	return econv(err)
+}
+
+func StartSFTPServer(fs *FileSystem, config SFTPConfig) {
+	ctx := meta.NewContext(uint32(os.Getpid()), uint32(os.Getuid()), []uint32{uint32(os.Getgid())})
+	s := &sftpServer{fs: fs, ctx: ctx, config: config, umask: uint16(utils.GetUmask())}
+	ln, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		logger.Fatalf("Listen on %s: %s", config.Addr, err)
+	}
+	logger.Infof("SFTP listening on %s", config.Addr)
+	if err = s.serve(ln); err != nil {
+		logger.Fatalf("Error with SFTP server: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+//go:build !nosftp
+// +build !nosftp
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTP(t *testing.T) {
+	jfs := createTestFS(t)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewSignerFromKey(priv)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	ctx := meta.NewContext(uint32(os.Getpid()), uid, []uint32{gid})
+	s := &sftpServer{fs: jfs, ctx: ctx, umask: 022, config: SFTPConfig{
+		HostKeys: []ssh.Signer{hostKey},
+		Users:    map[string]*SFTPUser{"alice": {Name: "alice", Password: string(hash), Uid: uid, Gid: gid, Home: "/partners/alice"}},
+	}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.serve(ln) }()
+
+	dial := func(password string) (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return sftp.NewClient(conn)
+	}
+	if _, err := dial("wrong"); err == nil {
+		t.Fatalf("login with wrong password should fail")
+	}
+	c, err := dial("secret")
+	if err != nil {
+		t.Fatalf("login: %s", err)
+	}
+	defer c.Close()
+
+	f, err := c.Create("/../../data.csv")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if _, err = f.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if fi, err := jfs.Stat(ctx, "/partners/alice/data.csv"); err != 0 || fi.Size() != 5 {
+		t.Fatalf("file should be in the home directory: %s", err)
+	}
+	if f, err = c.Open("data.csv"); err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if data, err := io.ReadAll(f); err != nil || string(data) != "hello" {
+		t.Fatalf("read: %q %v", data, err)
+	}
+	_ = f.Close()
+
+	if err = c.Mkdir("dir"); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	if err = c.Rename("data.csv", "dir/data.csv"); err != nil {
+		t.Fatalf("rename: %s", err)
+	}
+	if entries, err := c.ReadDir("/dir"); err != nil || len(entries) != 1 || entries[0].Name() != "data.csv" {
+		t.Fatalf("readdir: %+v %v", entries, err)
+	}
+	if err = c.Symlink("/", "root"); err == nil {
+		t.Fatalf("symlink should not be allowed")
+	}
+
+	// the symlinks are resolved inside the home directory
+	for p, data := range map[string]string{"/secret": "outside", "/partners/alice/secret": "inside"} {
+		f, err := jfs.Create(ctx, p, 0644, 022)
+		if err != 0 {
+			t.Fatalf("create %s: %s", p, err)
+		}
+		_, _ = f.Write(ctx, []byte(data))
+		_ = f.Close(ctx)
+	}
+	for link, target := range map[string]string{"rel": "../../secret", "abs": "/secret", "up": "../.."} {
+		if err := jfs.Symlink(ctx, target, "/partners/alice/"+link); err != 0 {
+			t.Fatalf("symlink %s: %s", link, err)
+		}
+	}
+	for _, name := range []string{"rel", "abs", "up/secret", "dir/../up/../rel"} {
+		f, err := c.Open(name)
+		if err != nil {
+			t.Fatalf("open %s: %s", name, err)
+		}
+		if data, err := io.ReadAll(f); err != nil || string(data) != "inside" {
+			t.Fatalf("read %s: %q %v", name, data, err)
+		}
+		_ = f.Close()
+	}
+	if _, err = c.Stat("up/partners"); !os.IsNotExist(err) {
+		t.Fatalf("stat out of the home directory: %v", err)
+	}
+	if target, err := c.ReadLink("rel"); err != nil || target != "../../secret" {
+		t.Fatalf("readlink: %s %v", target, err)
+	}
+	if fi, err := c.Lstat("abs"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("lstat symlink: %+v %v", fi, err)
+	}
+	if err = c.Remove("abs"); err != nil {
+		t.Fatalf("remove symlink: %s", err)
+	}
+	if fi, err := jfs.Stat(ctx, "/partners/alice/secret"); err != 0 || fi.Size() != 6 {
+		t.Fatalf("target of the removed symlink: %s", err)
+	}
+	if err = c.Remove("dir/data.csv"); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	if err = c.RemoveDirectory("dir"); err != nil {
+		t.Fatalf("rmdir: %s", err)
+	}
+	if _, err = c.Stat("dir"); !os.IsNotExist(err) {
+		t.Fatalf("stat removed dir: %v", err)
+	}
+}
@@ -19,6 +19,7 @@ package fuse
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -43,12 +44,21 @@ type fuseContext struct {
 	header   *fuse.InHeader
 	canceled bool
 	cancel   <-chan struct{}
+	gidMap   vfs.IDMap
 
 	checkPermission bool
 }
 
 var gidcache = newGidCache(time.Minute * 5)
 
+// pendingRequests is the number of FUSE requests being served.
+var pendingRequests int64
+
+// PendingRequests returns the number of FUSE requests being served.
+func PendingRequests() int64 {
+	return atomic.LoadInt64(&pendingRequests)
+}
+
 var contextPool = sync.Pool{
 	New: func() interface{} {
 		return &fuseContext{}
@@ -56,27 +66,39 @@ var contextPool = sync.Pool{
 }
 
 func (fs *fileSystem) newContext(cancel <-chan struct{}, header *fuse.InHeader) *fuseContext {
+	atomic.AddInt64(&pendingRequests, 1)
 	ctx := contextPool.Get().(*fuseContext)
 	ctx.Context = context.Background()
 	ctx.start = time.Now()
 	ctx.canceled = false
 	ctx.cancel = cancel
 	ctx.header = header
+	ctx.gidMap = nil
 	ctx.checkPermission = fs.conf.NonDefaultPermission && header.Uid != 0
+	var squashed bool
 	if header.Uid == 0 && fs.conf.RootSquash != nil {
 		ctx.checkPermission = true
 		ctx.header.Uid = fs.conf.RootSquash.Uid
 		ctx.header.Gid = fs.conf.RootSquash.Gid
+		squashed = true
 	}
 	if fs.conf.AllSquash != nil {
 		ctx.checkPermission = true
 		ctx.header.Uid = fs.conf.AllSquash.Uid
 		ctx.header.Gid = fs.conf.AllSquash.Gid
+		squashed = true
+	}
+	if !squashed && (fs.conf.UidMap != nil || fs.conf.GidMap != nil) {
+		// the callers not covered by the mappings access the volume as OverflowID
+		ctx.header.Uid, _ = fs.conf.UidMap.ToVolume(header.Uid)
+		ctx.header.Gid, _ = fs.conf.GidMap.ToVolume(header.Gid)
+		ctx.gidMap = fs.conf.GidMap
 	}
 	return ctx
 }
 
 func releaseContext(ctx *fuseContext) {
+	atomic.AddInt64(&pendingRequests, -1)
 	contextPool.Put(ctx)
 }
 
@@ -90,7 +112,15 @@ func (c *fuseContext) Gid() uint32 {
 
 func (c *fuseContext) Gids() []uint32 {
 	if c.checkPermission {
-		return gidcache.get(c.Pid(), c.Gid())
+		gids := gidcache.get(c.Pid(), c.Gid())
+		if c.gidMap != nil {
+			mapped := make([]uint32, len(gids))
+			for i, g := range gids {
+				mapped[i], _ = c.gidMap.ToVolume(g)
+			}
+			gids = mapped
+		}
+		return gids
 	}
 	return []uint32{c.header.Gid}
 }
@@ -40,6 +40,8 @@ type fileSystem struct {
 	fuse.RawFileSystem
 	conf *vfs.Config
 	v    *vfs.VFS
+
+	commitWriteback bool // commit the pages written back from shared mappings (--coherent-mmap)
 }
 
 func newFileSystem(conf *vfs.Config, v *vfs.VFS) *fileSystem {
@@ -61,22 +63,24 @@ func (fs *fileSystem) replyAttr(ctx *fuseContext, entry *meta.Entry, attr *fuse.
 		st := fs.v.Meta.GetAttr(ctx, entry.Inode, &attr)
 		if st == 0 {
 			*entry.Attr = attr
-			set(fs.conf.AttrTimeout)
+			set(fs.v.Timeouts().Attr)
 		}
 	} else {
-		set(fs.conf.AttrTimeout)
+		set(fs.v.Timeouts().Attr)
 	}
 	fs.v.UpdateLength(entry.Inode, entry.Attr)
 	attrToStat(entry.Inode, entry.Attr, attr)
+	attr.Uid = fs.conf.UidMap.ToLocal(attr.Uid)
+	attr.Gid = fs.conf.GidMap.ToLocal(attr.Gid)
 }
 
 func (fs *fileSystem) replyEntry(ctx *fuseContext, out *fuse.EntryOut, e *meta.Entry) fuse.Status {
 	out.NodeId = uint64(e.Inode)
 	out.Generation = 1
 	if e.Attr.Typ == meta.TypeDirectory {
-		out.SetEntryTimeout(fs.conf.DirEntryTimeout)
+		out.SetEntryTimeout(fs.v.Timeouts().DirEntry)
 	} else {
-		out.SetEntryTimeout(fs.conf.EntryTimeout)
+		out.SetEntryTimeout(fs.v.Timeouts().Entry)
 	}
 	fs.replyAttr(ctx, e, &out.Attr, out.SetAttrTimeout)
 	return 0
@@ -87,9 +91,9 @@ func (fs *fileSystem) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name
 	defer releaseContext(ctx)
 	entry, err := fs.v.Lookup(ctx, Ino(header.NodeId), name)
 	if err != 0 {
-		if fs.conf.NegEntryTimeout != 0 && err == syscall.ENOENT {
+		if timeout := fs.v.Timeouts().NegEntry; timeout != 0 && err == syscall.ENOENT {
 			out.NodeId = 0 // zero nodeid is same as ENOENT, but with valid timeout
-			out.SetEntryTimeout(fs.conf.NegEntryTimeout)
+			out.SetEntryTimeout(timeout)
 			return 0
 		}
 		return fuse.Status(err)
@@ -115,7 +119,12 @@ func (fs *fileSystem) GetAttr(cancel <-chan struct{}, in *fuse.GetAttrIn, out *f
 func (fs *fileSystem) SetAttr(cancel <-chan struct{}, in *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
 	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
-	entry, err := fs.v.SetAttr(ctx, Ino(in.NodeId), int(in.Valid), in.Fh, in.Mode, in.Uid, in.Gid, int64(in.Atime), int64(in.Mtime), in.Atimensec, in.Mtimensec, in.Size)
+	uid, uok := fs.conf.UidMap.ToVolume(in.Uid)
+	gid, gok := fs.conf.GidMap.ToVolume(in.Gid)
+	if in.Valid&fuse.FATTR_UID != 0 && !uok || in.Valid&fuse.FATTR_GID != 0 && !gok {
+		return fuse.EINVAL // the new owner can not be stored in the volume
+	}
+	entry, err := fs.v.SetAttr(ctx, Ino(in.NodeId), int(in.Valid), in.Fh, in.Mode, uid, gid, int64(in.Atime), int64(in.Mtime), in.Atimensec, in.Mtimensec, in.Size)
 	if err != 0 {
 		return fuse.Status(err)
 	}
@@ -198,6 +207,9 @@ func (fs *fileSystem) GetXAttr(cancel <-chan struct{}, header *fuse.InHeader, at
 	if err != 0 {
 		return 0, fuse.Status(err)
 	}
+	if len(dest) > 0 {
+		value, _ = vfs.MapACLXattr(attr, value, fs.conf.UidMap, fs.conf.GidMap, false)
+	}
 	copy(dest, value)
 	return uint32(len(value)), 0
 }
@@ -216,7 +228,11 @@ func (fs *fileSystem) ListXAttr(cancel <-chan struct{}, header *fuse.InHeader, d
 func (fs *fileSystem) SetXAttr(cancel <-chan struct{}, in *fuse.SetXAttrIn, attr string, data []byte) fuse.Status {
 	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
-	err := fs.v.SetXattr(ctx, Ino(in.NodeId), attr, data, in.Flags)
+	data, err := vfs.MapACLXattr(attr, data, fs.conf.UidMap, fs.conf.GidMap, true)
+	if err != 0 {
+		return fuse.Status(err)
+	}
+	err = fs.v.SetXattr(ctx, Ino(in.NodeId), attr, data, in.Flags)
 	return fuse.Status(err)
 }
 
@@ -280,12 +296,31 @@ func (fs *fileSystem) Write(cancel <-chan struct{}, in *fuse.WriteIn, data []byt
 	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
 	err := fs.v.Write(ctx, Ino(in.NodeId), data, in.Offset, in.Fh)
+	if err == 0 && fs.writtenBack(in) {
+		// written back from a shared mapping, commit it for the other clients
+		err = fs.v.Fsync(ctx, Ino(in.NodeId), 1, in.Fh)
+	}
 	if err != 0 {
 		return 0, fuse.Status(err)
 	}
 	return uint32(len(data)), 0
 }
 
+// writtenBack tells whether the write is the writeback of a shared mapping that should be committed.
+// Without writeback_cache, only the pages of shared mappings are written back from the page cache.
+func (fs *fileSystem) writtenBack(in *fuse.WriteIn) bool {
+	return fs.commitWriteback && in.WriteFlags&fuse.WRITE_CACHE != 0
+}
+
+// commitWriteback returns whether the writeback of shared mappings is committed on the mount.
+func commitWriteback(conf *vfs.Config, opt *fuse.MountOptions) bool {
+	if conf.CoherentMmap && opt.EnableWriteback {
+		logger.Warnf("coherent-mmap is disabled with writeback_cache, because every write is written back from the page cache")
+		return false
+	}
+	return conf.CoherentMmap
+}
+
 func (fs *fileSystem) Flush(cancel <-chan struct{}, in *fuse.FlushIn) fuse.Status {
 	ctx := fs.newContext(cancel, &in.InHeader)
 	defer releaseContext(ctx)
@@ -512,6 +547,7 @@ func Serve(v *vfs.VFS, options string, xattrs, ioctl bool) error {
 		opt.Options = append(opt.Options, "volname="+conf.Format.Name)
 		opt.Options = append(opt.Options, "daemon_timeout=60", "iosize=65536", "novncache")
 	}
+	imp.commitWriteback = commitWriteback(conf, &opt)
 	fssrv, err := fuse.NewServer(imp, conf.Meta.MountPoint, &opt)
 	if err != nil {
 		if execErr, ok := err.(*exec.Error); ok {
@@ -533,6 +569,15 @@ func Serve(v *vfs.VFS, options string, xattrs, ioctl bool) error {
 		v.InvalidateEntry = func(parent Ino, name string) syscall.Errno {
 			return syscall.Errno(fssrv.EntryNotify(uint64(parent), name))
 		}
+		v.InvalidateInode = func(ino Ino, off, length int64) syscall.Errno {
+			return syscall.Errno(fssrv.InodeNotify(uint64(ino), off, length))
+		}
+		v.NotifyDelete = func(parent, child Ino, name string) syscall.Errno {
+			return syscall.Errno(fssrv.DeleteNotify(uint64(parent), uint64(child), name))
+		}
+		if conf.WatchChanges && conf.Format.ChangelogDays > 0 {
+			go v.WatchChanges(meta.Background())
+		}
 	}
 
 	fsserv = fssrv
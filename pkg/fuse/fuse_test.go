@@ -35,6 +35,7 @@ import (
 
 	"github.com/gofrs/flock"
 	"github.com/google/uuid"
+	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/hanwen/go-fuse/v2/posixtest"
 	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
@@ -302,3 +303,77 @@ func TestFUSE(t *testing.T) {
 		})
 	}
 }
+
+func TestCommitWriteback(t *testing.T) {
+	conf := &vfs.Config{CoherentMmap: true}
+	if !commitWriteback(conf, &fuse.MountOptions{}) {
+		t.Fatalf("writeback of shared mappings should be committed")
+	}
+	if commitWriteback(conf, &fuse.MountOptions{EnableWriteback: true}) {
+		t.Fatalf("writeback should not be committed with writeback_cache")
+	}
+	if commitWriteback(&vfs.Config{}, &fuse.MountOptions{}) {
+		t.Fatalf("writeback should not be committed without coherent-mmap")
+	}
+
+	fs := &fileSystem{commitWriteback: true}
+	if !fs.writtenBack(&fuse.WriteIn{WriteFlags: fuse.WRITE_CACHE}) {
+		t.Fatalf("writeback from the page cache should be committed")
+	}
+	if fs.writtenBack(&fuse.WriteIn{}) {
+		t.Fatalf("direct writes should not be committed")
+	}
+	fs.commitWriteback = false
+	if fs.writtenBack(&fuse.WriteIn{WriteFlags: fuse.WRITE_CACHE}) {
+		t.Fatalf("writeback should not be committed when disabled")
+	}
+}
+
+func TestSetAttrIDMap(t *testing.T) {
+	m := meta.NewClient("memkv://", meta.DefaultConf())
+	format := &meta.Format{Name: "test", UUID: uuid.New().String(), Storage: "mem", BlockSize: 4096}
+	if err := m.Init(format, true); err != nil {
+		t.Fatalf("format: %s", err)
+	}
+	chunkConf := chunk.Config{BlockSize: format.BlockSize * 1024, MaxUpload: 1, BufferSize: 10 << 20, CacheDir: "memory"}
+	blob, _ := object.CreateStorage("mem", "", "", "", "")
+	conf := &vfs.Config{Meta: meta.DefaultConf(), Format: *format, Chunk: &chunkConf, FuseOpts: &vfs.FuseOptions{}}
+	conf.UidMap, _ = vfs.ParseIDMap("100000:0:65536")
+	conf.GidMap, _ = vfs.ParseIDMap("100000:0:65536")
+	v := vfs.NewVFS(conf, m, chunk.NewCachedStore(blob, chunkConf, nil), nil, nil)
+	fs := newFileSystem(conf, v)
+
+	entry, fh, st := v.Create(vfs.NewLogContext(meta.Background()), 1, "f", 0644, 0, syscall.O_RDWR)
+	if st != 0 {
+		t.Fatalf("create: %s", st)
+	}
+	v.Release(vfs.NewLogContext(meta.Background()), entry.Inode, fh)
+	chown := func(valid, uid, gid uint32) (*fuse.AttrOut, fuse.Status) {
+		in := &fuse.SetAttrIn{}
+		in.NodeId = uint64(entry.Inode)
+		in.Caller.Uid, in.Caller.Gid = 100000, 100000
+		in.Valid, in.Owner.Uid, in.Owner.Gid = valid, uid, gid
+		out := &fuse.AttrOut{}
+		return out, fs.SetAttr(nil, in, out)
+	}
+	out, code := chown(fuse.FATTR_UID|fuse.FATTR_GID, 100005, 100006)
+	if code != fuse.OK || out.Uid != 100005 || out.Gid != 100006 {
+		t.Fatalf("chown to mapped IDs: %s %d:%d", code, out.Uid, out.Gid)
+	}
+	var attr meta.Attr
+	if st := m.GetAttr(meta.Background(), entry.Inode, &attr); st != 0 || attr.Uid != 5 || attr.Gid != 6 {
+		t.Fatalf("owner in the volume: %s %d:%d", st, attr.Uid, attr.Gid)
+	}
+	if _, code = chown(fuse.FATTR_UID, 1000, 0); code != fuse.EINVAL {
+		t.Fatalf("chown to an unmapped uid: %s", code)
+	}
+	if _, code = chown(fuse.FATTR_GID, 0, 1000); code != fuse.EINVAL {
+		t.Fatalf("chgrp to an unmapped gid: %s", code)
+	}
+	if _, code = chown(fuse.FATTR_MODE, 0, 0); code != fuse.OK {
+		t.Fatalf("chmod without changing the owner: %s", code)
+	}
+	if st := m.GetAttr(meta.Background(), entry.Inode, &attr); st != 0 || attr.Uid != 5 || attr.Gid != 6 {
+		t.Fatalf("owner in the volume: %s %d:%d", st, attr.Uid, attr.Gid)
+	}
+}
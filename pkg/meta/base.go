@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path"
 	"reflect"
@@ -37,8 +38,11 @@ import (
 	aclAPI "github.com/juicedata/juicefs/pkg/acl"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/juicedata/juicefs/pkg/version"
+	"github.com/juju/ratelimit"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 )
@@ -94,15 +98,25 @@ type engine interface {
 	doCleanupSlices(ctx Context)
 	doCleanupDelayedSlices(ctx Context, edge int64) (int, error)
 	doDeleteSlice(id uint64, size uint32) error
+	// Save the checksums of the blocks in a slice, which are deleted together with the slice.
+	doSetChecksums(ctx Context, id uint64, sums []byte) error
+	doGetChecksums(ctx Context, id uint64) ([]byte, error) // nil if not found
+	// Publish the blocking lock requests of the session for the other clients to detect deadlocks, nil removes them.
+	doSetLockWaits(ctx Context, sid uint64, waits []byte) error
+	doListLockWaits(ctx Context) (map[uint64][]byte, error)
 
 	doCloneEntry(ctx Context, srcIno Ino, parent Ino, name string, ino Ino, attr *Attr, cmode uint8, cumask uint16, top bool) syscall.Errno
 	doAttachDirNode(ctx Context, parent Ino, dstIno Ino, name string) syscall.Errno
 	doFindDetachedNodes(t time.Time) []Ino
 	doCleanupDetachedNode(ctx Context, detachedNode Ino) syscall.Errno
+	// Remove the directory from the parent and keep it as a detached node to be deleted in background.
+	doDetachDir(ctx Context, parent Ino, name string, inode *Ino, attr *Attr) syscall.Errno
 
 	doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, error)
 	// set quota, return true if there is no quota exists before
 	doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota) (created bool, err error)
+	// set the time the soft limits are exceeded if it's still old, return the current one
+	doSetQuotaExceeded(ctx Context, qtype uint32, key uint64, old, exceeded int64) (int64, error)
 	doDelQuota(ctx Context, qtype uint32, key uint64) error
 	doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota, map[uint64]*Quota, error)
 	doFlushQuotas(ctx Context, quotas []*iQuota) error
@@ -119,15 +133,20 @@ type engine interface {
 	doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst Ino, nameDst string, flags uint32, inode, tinode *Ino, attr, tattr *Attr) syscall.Errno
 	doSetXattr(ctx Context, inode Ino, name string, value []byte, flags uint32) syscall.Errno
 	doRemoveXattr(ctx Context, inode Ino, name string) syscall.Errno
+	// Write the attribute of inode, recounting the nlink for a directory.
 	doRepair(ctx Context, inode Ino, attr *Attr) syscall.Errno
 	doTouchAtime(ctx Context, inode Ino, attr *Attr, ts time.Time) (bool, error)
 	doRead(ctx Context, inode Ino, indx uint32) ([]*slice, syscall.Errno)
-	doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno
+	doWrite(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno
 	doTruncate(ctx Context, inode Ino, flags uint8, length uint64, delta *dirStat, attr *Attr, skipPermCheck bool) syscall.Errno
 	doFallocate(ctx Context, inode Ino, mode uint8, off uint64, size uint64, delta *dirStat, attr *Attr) syscall.Errno
 	doCompactChunk(inode Ino, indx uint32, origin []byte, ss []*slice, skipped int, pos uint32, id uint64, size uint32, delayed []byte) syscall.Errno
 
 	doGetParents(ctx Context, inode Ino) map[Ino]int
+	// Persist events with continuous sequence numbers allocated in the same transaction.
+	doReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error)
+	// Delete events with sequence number <= upto.
+	doDeleteChangelog(ctx Context, upto uint64) error
 	doUpdateDirStat(ctx Context, batch map[Ino]dirStat) error
 	// @trySync: try sync dir stat if broken or not existed
 	doGetDirStat(ctx Context, ino Ino, trySync bool) (*dirStat, syscall.Errno)
@@ -139,6 +158,7 @@ type engine interface {
 	scanPendingFiles(Context, pendingFileScan) error
 
 	GetSession(sid uint64, detail bool) (*Session, error)
+	ListSessions() ([]*Session, error)
 
 	doSetFacl(ctx Context, ino Ino, aclType uint8, rule *aclAPI.Rule) syscall.Errno
 	doGetFacl(ctx Context, ino Ino, aclType uint8, aclId uint32, rule *aclAPI.Rule) syscall.Errno
@@ -151,6 +171,12 @@ type engine interface {
 	prepareLoad(ctx Context, opt *LoadOption) error
 }
 
+// engineStats is implemented by the engines which can report the statistics of the storage or the
+// connection pool, they are exported as the metric meta_engine_stats.
+type engineStats interface {
+	engineStats() map[string]float64
+}
+
 type trashSliceScan func(ss []Slice, ts int64) (clean bool, err error)
 type pendingSliceScan func(id uint64, size uint32) (clean bool, err error)
 type trashFileScan func(inode Ino, size uint64, ts time.Time) (clean bool, err error)
@@ -233,8 +259,12 @@ type baseMeta struct {
 	of           *openfiles
 	removedFiles map[Ino]bool
 	compacting   map[uint64]bool
+	compactLimit *ratelimit.Bucket // bandwidth limit of compaction
+	compactStart time.Duration     // window of background compaction in the day
+	compactEnd   time.Duration
 	maxDeleting  chan struct{}
-	dslices      chan Slice // slices to delete
+	dslices      chan Slice    // slices to delete
+	detachedDirs chan struct{} // wake up the background deletion of detached directories
 	symlinks     *symlinkCache
 	msgCallbacks *msgCallbacks
 	reloadCb     []func(*Format)
@@ -251,9 +281,19 @@ type baseMeta struct {
 	dirStatsLock sync.Mutex
 	dirStats     map[Ino]dirStat
 
+	changelogLock   sync.Mutex
+	changelog       []*ChangeEvent
+	changelogWrites map[Ino]time.Time // files written recently
+	changeCbs       []func([]*ChangeEvent)
+	subscribed      int32
+
 	fsStatsLock sync.Mutex
 	*fsStat
 
+	lockWaitsMu   sync.Mutex
+	lockWaits     map[*lockWait]struct{} // blocking lock requests of this client
+	lockPublishMu sync.Mutex             // to publish the requests in order
+
 	parentMu    sync.Mutex        // protect dirParents
 	quotaMu     sync.RWMutex      // protect dirQuotas
 	dirParents  map[Ino]Ino       // directory inode -> parent inode
@@ -276,12 +316,18 @@ type baseMeta struct {
 	opDist       prometheus.Histogram
 	opCount      *prometheus.CounterVec
 	opDuration   *prometheus.CounterVec
+	opMethodDist *prometheus.HistogramVec
+	compactDist  prometheus.Histogram
+	compactSkips prometheus.Counter
+	engineStatsG *prometheus.GaugeVec
+	slowLog      *log.Logger
+	slowFile     *os.File
 
 	en engine
 }
 
 func newBaseMeta(addr string, conf *Config) *baseMeta {
-	return &baseMeta{
+	m := &baseMeta{
 		addr:         utils.RemovePassword(addr),
 		conf:         conf,
 		sid:          conf.Sid,
@@ -290,16 +336,19 @@ func newBaseMeta(addr string, conf *Config) *baseMeta {
 		removedFiles: make(map[Ino]bool),
 		compacting:   make(map[uint64]bool),
 		maxDeleting:  make(chan struct{}, 100),
+		detachedDirs: make(chan struct{}, 1),
 		symlinks:     newSymlinkCache(maxSymCacheNum),
 		fsStat: &fsStat{
 			usedSpace:  unknownUsage,
 			usedInodes: unknownUsage,
 		},
-		dirStats:    make(map[Ino]dirStat),
-		dirParents:  make(map[Ino]Ino),
-		dirQuotas:   make(map[uint64]*Quota),
-		userQuotas:  make(map[uint64]*Quota),
-		groupQuotas: make(map[uint64]*Quota),
+		dirStats:        make(map[Ino]dirStat),
+		changelogWrites: make(map[Ino]time.Time),
+		lockWaits:       make(map[*lockWait]struct{}),
+		dirParents:      make(map[Ino]Ino),
+		dirQuotas:       make(map[uint64]*Quota),
+		userQuotas:      make(map[uint64]*Quota),
+		groupQuotas:     make(map[uint64]*Quota),
 		msgCallbacks: &msgCallbacks{
 			callbacks: make(map[uint32]MsgCallback),
 		},
@@ -343,6 +392,48 @@ func newBaseMeta(addr string, conf *Config) *baseMeta {
 			Name: "meta_ops_duration_seconds",
 			Help: "Meta operation duration in seconds.",
 		}, []string{"method"}),
+		opMethodDist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "meta_ops_method_durations_histogram_seconds",
+			Help:    "Operation latency distributions by method.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 30),
+		}, []string{"method"}),
+		compactDist: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "compaction_durations_histogram_seconds",
+			Help:    "Slice compaction latency distributions.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 20),
+		}),
+		compactSkips: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "compaction_skipped",
+			Help: "The number of compactions skipped because too many chunks are being compacted.",
+		}),
+		engineStatsG: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "meta_engine_stats",
+			Help: "Statistics of the meta engine.",
+		}, []string{"stat"}),
+	}
+	if conf.CompactWindow != "" {
+		m.compactStart, m.compactEnd, _ = parseTimeWindow(conf.CompactWindow)
+	}
+	if conf.CompactLimit > 0 {
+		bps := float64(conf.CompactLimit) * 1e6 / 8
+		m.compactLimit = ratelimit.NewBucketWithRate(bps, int64(bps))
+	}
+	if conf.SlowOpLog != "" {
+		f, err := os.OpenFile(conf.SlowOpLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logger.Warnf("Open slow operation log %s: %s, use the client log instead", conf.SlowOpLog, err)
+		} else {
+			m.slowFile = f
+			m.slowLog = log.New(f, "", log.LstdFlags|log.Lmicroseconds)
+		}
+	}
+	return m
+}
+
+// closeSlowLog closes the file of slow operations, called when the engine is shut down.
+func (m *baseMeta) closeSlowLog() {
+	if m.slowFile != nil {
+		_ = m.slowFile.Close()
 	}
 }
 
@@ -359,6 +450,21 @@ func (m *baseMeta) InitMetrics(reg prometheus.Registerer) {
 	reg.MustRegister(m.opDist)
 	reg.MustRegister(m.opCount)
 	reg.MustRegister(m.opDuration)
+	reg.MustRegister(m.opMethodDist)
+	reg.MustRegister(m.compactDist)
+	reg.MustRegister(m.compactSkips)
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "compacting_chunks",
+		Help: "Number of chunks being compacted.",
+	}, func() float64 {
+		m.Lock()
+		defer m.Unlock()
+		return float64(len(m.compacting))
+	}))
+	es, _ := m.en.(engineStats)
+	if es != nil {
+		reg.MustRegister(m.engineStatsG)
+	}
 
 	go func() {
 		for {
@@ -373,16 +479,48 @@ func (m *baseMeta) InitMetrics(reg prometheus.Registerer) {
 				m.totalSpaceG.Set(float64(totalSpace))
 				m.totalInodesG.Set(float64(iused + iavail))
 			}
+			if es != nil {
+				for k, v := range es.engineStats() {
+					m.engineStatsG.WithLabelValues(k).Set(v)
+				}
+			}
 			utils.SleepWithJitter(time.Second * 10)
 		}
 	}()
 }
 
+// startSpan starts a span of the operation in the trace of ctx, and returns the context with it.
+func (m *baseMeta) startSpan(ctx Context, op string, inode Ino) (Context, trace.Span) {
+	if !utils.TracingEnabled() {
+		return ctx, utils.SpanFromContext(nil)
+	}
+	span := utils.StartSpan(ctx, "meta."+op, trace.WithAttributes(attribute.Int64("juicefs.inode", int64(inode))))
+	return ctx.WithValue(utils.SpanKey, span), span
+}
+
 func (m *baseMeta) timeit(method string, start time.Time) {
-	used := time.Since(start).Seconds()
+	d := time.Since(start)
+	used := d.Seconds()
 	m.opDist.Observe(used)
+	m.opMethodDist.WithLabelValues(method).Observe(used)
 	m.opCount.WithLabelValues(method).Inc()
 	m.opDuration.WithLabelValues(method).Add(used)
+	if m.isSlow(d) {
+		m.logSlow("Slow operation %s (%s)", method, d)
+	}
+}
+
+// isSlow returns whether an operation or transaction taking d should be written to the slow log.
+func (m *baseMeta) isSlow(d time.Duration) bool {
+	return m.conf.SlowOpThreshold > 0 && d >= m.conf.SlowOpThreshold
+}
+
+func (m *baseMeta) logSlow(format string, args ...interface{}) {
+	if m.slowLog != nil {
+		m.slowLog.Printf(format, args...)
+	} else {
+		logger.Warnf(format, args...)
+	}
 }
 
 func (m *baseMeta) getBase() *baseMeta {
@@ -501,6 +639,8 @@ func (m *baseMeta) newSessionInfo() []byte {
 		MountPoint: m.conf.MountPoint,
 		MountTime:  time.Now(),
 		ProcessID:  os.Getpid(),
+		CacheGroup: m.conf.CacheGroup,
+		CacheAddr:  m.conf.CacheAddr,
 	})
 	if err != nil {
 		panic(err) // marshal SessionInfo should never fail
@@ -542,17 +682,20 @@ func (m *baseMeta) NewSession(record bool) error {
 
 	m.loadQuotas()
 
-	m.sessWG.Add(3)
+	m.sessWG.Add(4)
 	go m.flushStats(ctx)
 	go m.flushDirStat(ctx)
 	go m.flushQuotas(ctx)
+	go m.flushChangelog(ctx)
 	m.startDeleteSliceTasks() // start MaxDeletes tasks
 
 	if !m.conf.NoBGJob {
-		m.sessWG.Add(4)
+		m.sessWG.Add(6)
 		go m.cleanupDeletedFiles(ctx)
 		go m.cleanupSlices(ctx)
 		go m.cleanupTrash(ctx)
+		go m.cleanupDetachedDirs(ctx)
+		go m.cleanupChangelog(ctx)
 		go m.symlinks.clean(ctx, &m.sessWG)
 	}
 	return nil
@@ -700,6 +843,26 @@ func (m *baseMeta) CleanStaleSessions(ctx Context) {
 	}
 }
 
+func (m *baseMeta) KillSession(sid uint64, force bool) error {
+	if sid == m.sid {
+		return fmt.Errorf("cannot kill the current session %d", sid)
+	}
+	sessions, err := m.en.ListSessions()
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.Sid == sid {
+			if !force && s.Expire.After(time.Now()) {
+				return fmt.Errorf("session %d is still alive (expire at %s), use --force to kill it anyway", sid, s.Expire.Format("2006-01-02 15:04:05"))
+			}
+			logger.Infof("kill session %d %+v", sid, s.SessionInfo)
+			return m.en.doCleanStaleSession(sid)
+		}
+	}
+	return fmt.Errorf("session %d not found", sid)
+}
+
 func (m *baseMeta) CloseSession() error {
 	m.FlushSession()
 	m.sesMu.Lock()
@@ -723,6 +886,7 @@ func (m *baseMeta) FlushSession() {
 	m.doFlushStats()
 	m.doFlushDirStat()
 	m.doFlushQuotas()
+	m.doFlushChangelog()
 	logger.Infof("flush session %d:", m.sid)
 }
 
@@ -793,18 +957,7 @@ func (m *baseMeta) StatFS(ctx Context, ino Ino, totalspace, availspace, iused, i
 		if usage == nil {
 			usage = &q
 		}
-		if q.MaxSpace > 0 {
-			ls := uint64(q.MaxSpace - q.UsedSpace)
-			if ls < *availspace {
-				*availspace = ls
-			}
-		}
-		if q.MaxInodes > 0 {
-			li := uint64(q.MaxInodes - q.UsedInodes)
-			if li < *iavail {
-				*iavail = li
-			}
-		}
+		limitQuota(&q, availspace, iavail)
 		if ino == RootInode {
 			break
 		}
@@ -819,9 +972,48 @@ func (m *baseMeta) StatFS(ctx Context, ino Ino, totalspace, availspace, iused, i
 		*totalspace = uint64(usage.UsedSpace) + *availspace
 		*iused = uint64(usage.UsedInodes)
 	}
+	// the quotas of the calling user and group are also applied, so they can see how much is left for them
+	if m.getFormat().UserGroupQuota {
+		m.quotaMu.RLock()
+		uq, gq := m.userQuotas[uint64(ctx.Uid())], m.groupQuotas[uint64(ctx.Gid())]
+		m.quotaMu.RUnlock()
+		for _, quota := range []*Quota{uq, gq} {
+			if quota == nil {
+				continue
+			}
+			q := quota.snap()
+			q.sanitize()
+			if limitQuota(&q, availspace, iavail) {
+				*totalspace = uint64(q.UsedSpace) + *availspace
+				*iused = uint64(q.UsedInodes)
+			}
+		}
+	}
 	return 0
 }
 
+// limitQuota reduces the available space and inodes to the ones left by the effective limits of q,
+// returns true if any of them is reduced.
+func limitQuota(q *Quota, availspace, iavail *uint64) bool {
+	var limited bool
+	space, inodes := q.limits()
+	if space > 0 {
+		ls := uint64(max(space-q.UsedSpace, 0))
+		if ls < *availspace {
+			*availspace = ls
+			limited = true
+		}
+	}
+	if inodes > 0 {
+		li := uint64(max(inodes-q.UsedInodes, 0))
+		if li < *iavail {
+			*iavail = li
+			limited = true
+		}
+	}
+	return limited
+}
+
 func (m *baseMeta) statRootFs(ctx Context, totalspace, availspace, iused, iavail *uint64) syscall.Errno {
 	used, inodes := atomic.LoadInt64(&m.usedSpace), atomic.LoadInt64(&m.usedInodes)
 	var err error
@@ -893,7 +1085,9 @@ func (m *baseMeta) resolveCase(ctx Context, parent Ino, name string) *Entry {
 	return nil
 }
 
-func (m *baseMeta) Lookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr, checkPerm bool) syscall.Errno {
+func (m *baseMeta) Lookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr, checkPerm bool) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "lookup", parent)
+	defer func() { utils.EndSpan(span, st) }()
 	if inode == nil || attr == nil {
 		return syscall.EINVAL // bad request
 	}
@@ -932,7 +1126,7 @@ func (m *baseMeta) Lookup(ctx Context, parent Ino, name string, inode *Ino, attr
 		*inode = TrashInode
 		return 0
 	}
-	st := m.en.doLookup(ctx, parent, name, inode, attr)
+	st = m.en.doLookup(ctx, parent, name, inode, attr)
 	if st == syscall.ENOENT && m.conf.CaseInsensi {
 		if e := m.resolveCase(ctx, parent, name); e != nil {
 			*inode = e.Inode
@@ -1063,7 +1257,9 @@ func (m *baseMeta) Access(ctx Context, inode Ino, mmask uint8, attr *Attr) sysca
 	return 0
 }
 
-func (m *baseMeta) GetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
+func (m *baseMeta) GetAttr(ctx Context, inode Ino, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "getattr", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	inode = m.checkRoot(inode)
 	if m.conf.OpenCache > 0 && m.of.Check(inode, attr) {
 		return 0
@@ -1105,12 +1301,18 @@ func (m *baseMeta) GetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 	return err
 }
 
-func (m *baseMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr) syscall.Errno {
+func (m *baseMeta) SetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "setattr", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	defer m.timeit("SetAttr", time.Now())
 	inode = m.checkRoot(inode)
 	var oldAttr Attr
 
-	err := m.en.doSetAttr(ctx, inode, set, sugidclearmode, attr, &oldAttr)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeSetAttr, Inode: inode}, func(e *ChangeEvent) {
+		e.Type, e.Parent = attr.Typ, attr.Parent
+	})
+	err := m.en.doSetAttr(cctx, inode, set, sugidclearmode, attr, &oldAttr)
+	m.endChange(changes, err)
 	if err == 0 {
 		m.of.InvalidateChunk(inode, invalidateAttrOnly)
 		m.of.Update(inode, attr)
@@ -1194,7 +1396,9 @@ func (m *baseMeta) allocateInodes() (freeID, error) {
 	return freeID{next: uint64(v) - inodeBatch, maxid: uint64(v)}, nil
 }
 
-func (m *baseMeta) Mknod(ctx Context, parent Ino, name string, _type uint8, mode, cumask uint16, rdev uint32, path string, inode *Ino, attr *Attr) syscall.Errno {
+func (m *baseMeta) Mknod(ctx Context, parent Ino, name string, _type uint8, mode, cumask uint16, rdev uint32, path string, inode *Ino, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "mknod", parent)
+	defer func() { utils.EndSpan(span, st) }()
 	if _type < TypeFile || _type > TypeSocket {
 		return syscall.EINVAL
 	}
@@ -1249,7 +1453,11 @@ func (m *baseMeta) Mknod(ctx Context, parent Ino, name string, _type uint8, mode
 	}
 	attr.Parent = parent
 	attr.Full = true
-	st := m.en.doMknod(ctx, parent, name, _type, mode, cumask, path, inode, attr)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeCreate, Type: _type, Parent: parent, Name: name}, func(e *ChangeEvent) {
+		e.Inode = *inode
+	})
+	st = m.en.doMknod(cctx, parent, name, _type, mode, cumask, path, inode, attr)
+	m.endChange(changes, st)
 	if st == 0 {
 		m.en.updateStats(space, inodes)
 		m.updateDirStat(ctx, parent, 0, space, inodes)
@@ -1296,7 +1504,9 @@ func (m *baseMeta) Symlink(ctx Context, parent Ino, name string, path string, in
 	return m.Mknod(ctx, parent, name, TypeSymlink, 0777, 0, 0, path, inode, attr)
 }
 
-func (m *baseMeta) Link(ctx Context, inode, parent Ino, name string, attr *Attr) syscall.Errno {
+func (m *baseMeta) Link(ctx Context, inode, parent Ino, name string, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "link", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	if parent.IsTrash() {
 		return syscall.EPERM
 	}
@@ -1336,11 +1546,21 @@ func (m *baseMeta) Link(ctx Context, inode, parent Ino, name string, attr *Attr)
 	}
 
 	defer func() { m.of.InvalidateChunk(inode, invalidateAttrOnly) }()
-	err := m.en.doLink(ctx, inode, parent, name, attr)
+	tmpfile := attr.Nlink == 0 // unlinked file created by O_TMPFILE
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeLink, Type: attr.Typ, Inode: inode, Parent: parent, Name: name}, nil)
+	err := m.en.doLink(cctx, inode, parent, name, attr)
+	m.endChange(changes, err)
 	if err == 0 {
 		m.updateDirStat(ctx, parent, int64(attr.Length), align4K(attr.Length), 1)
 		m.updateDirQuota(ctx, parent, align4K(attr.Length), 1)
-		m.updateUserGroupQuota(ctx, attr.Uid, attr.Gid, 0, 1)
+		if tmpfile {
+			m.Lock()
+			delete(m.removedFiles, inode)
+			m.Unlock()
+			m.updateUserGroupQuota(ctx, attr.Uid, attr.Gid, align4K(attr.Length), 1)
+		} else {
+			m.updateUserGroupQuota(ctx, attr.Uid, attr.Gid, 0, 1)
+		}
 	}
 	return err
 }
@@ -1389,7 +1609,9 @@ func (m *baseMeta) ReadLink(ctx Context, inode Ino, path *[]byte) syscall.Errno
 	return 0
 }
 
-func (m *baseMeta) Unlink(ctx Context, parent Ino, name string, skipCheckTrash ...bool) syscall.Errno {
+func (m *baseMeta) Unlink(ctx Context, parent Ino, name string, skipCheckTrash ...bool) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "unlink", parent)
+	defer func() { utils.EndSpan(span, st) }()
 	if parent == RootInode && name == TrashName || parent.IsTrash() && ctx.Uid() != 0 {
 		return syscall.EPERM
 	}
@@ -1400,7 +1622,9 @@ func (m *baseMeta) Unlink(ctx Context, parent Ino, name string, skipCheckTrash .
 	defer m.timeit("Unlink", time.Now())
 	parent = m.checkRoot(parent)
 	var attr Attr
-	err := m.en.doUnlink(ctx, parent, name, &attr, skipCheckTrash...)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeUnlink, Parent: parent, Name: name}, nil)
+	err := m.en.doUnlink(cctx, parent, name, &attr, skipCheckTrash...)
+	m.endChange(changes, err)
 	if err == 0 {
 		var diffLength uint64
 		if attr.Typ == TypeFile {
@@ -1419,7 +1643,9 @@ func (m *baseMeta) Unlink(ctx Context, parent Ino, name string, skipCheckTrash .
 	return err
 }
 
-func (m *baseMeta) Rmdir(ctx Context, parent Ino, name string, skipCheckTrash ...bool) syscall.Errno {
+func (m *baseMeta) Rmdir(ctx Context, parent Ino, name string, skipCheckTrash ...bool) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "rmdir", parent)
+	defer func() { utils.EndSpan(span, st) }()
 	if name == "." {
 		return syscall.EINVAL
 	}
@@ -1437,7 +1663,11 @@ func (m *baseMeta) Rmdir(ctx Context, parent Ino, name string, skipCheckTrash ..
 	parent = m.checkRoot(parent)
 	var inode Ino
 	var oldAttr Attr
-	st := m.en.doRmdir(ctx, parent, name, &inode, &oldAttr, skipCheckTrash...)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeUnlink, Type: TypeDirectory, Parent: parent, Name: name}, func(e *ChangeEvent) {
+		e.Inode = inode
+	})
+	st = m.en.doRmdir(cctx, parent, name, &inode, &oldAttr, skipCheckTrash...)
+	m.endChange(changes, st)
 	if st == 0 {
 		if !parent.IsTrash() {
 			m.parentMu.Lock()
@@ -1451,7 +1681,9 @@ func (m *baseMeta) Rmdir(ctx Context, parent Ino, name string, skipCheckTrash ..
 	return st
 }
 
-func (m *baseMeta) Rename(ctx Context, parentSrc Ino, nameSrc string, parentDst Ino, nameDst string, flags uint32, inode *Ino, attr *Attr) syscall.Errno {
+func (m *baseMeta) Rename(ctx Context, parentSrc Ino, nameSrc string, parentDst Ino, nameDst string, flags uint32, inode *Ino, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "rename", parentSrc)
+	defer func() { utils.EndSpan(span, st) }()
 	if parentSrc == RootInode && nameSrc == TrashName || parentDst == RootInode && nameDst == TrashName {
 		return syscall.EPERM
 	}
@@ -1521,7 +1753,14 @@ func (m *baseMeta) Rename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 	}
 	tinode := new(Ino)
 	tattr := new(Attr)
-	st := m.en.doRename(ctx, parentSrc, nameSrc, parentDst, nameDst, flags, inode, tinode, attr, tattr)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeRename, Parent: parentSrc, Name: nameSrc, NewParent: parentDst, NewName: nameDst}, func(e *ChangeEvent) {
+		e.Type, e.Inode = attr.Typ, *inode
+		if flags != RenameExchange {
+			e.Replaced = *tinode
+		}
+	})
+	st = m.en.doRename(cctx, parentSrc, nameSrc, parentDst, nameDst, flags, inode, tinode, attr, tattr)
+	m.endChange(changes, st)
 	if st == 0 {
 		var diffLength uint64
 		if attr.Typ == TypeDirectory {
@@ -1591,6 +1830,8 @@ func (m *baseMeta) touchAtime(ctx Context, inode Ino, attr *Attr) {
 }
 
 func (m *baseMeta) Open(ctx Context, inode Ino, flags uint32, attr *Attr) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "open", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	if m.conf.ReadOnly && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
 		return syscall.EROFS
 	}
@@ -1648,6 +1889,8 @@ func (m *baseMeta) InvalidateChunkCache(ctx Context, inode Ino, indx uint32) sys
 }
 
 func (m *baseMeta) Read(ctx Context, inode Ino, indx uint32, slices *[]Slice) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "read", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	defer func() {
 		if st == 0 {
 			m.touchAtime(ctx, inode, nil)
@@ -1723,8 +1966,29 @@ func (m *baseMeta) Close(ctx Context, inode Ino) syscall.Errno {
 	return 0
 }
 
-func (m *baseMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time) syscall.Errno {
+func (m *baseMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "write", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	defer m.timeit("Write", time.Now())
+	return m.writeExtents(ctx, inode, indx, []Extent{{off, slice}}, mtime)
+}
+
+func (m *baseMeta) WriteExtents(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "write", inode)
+	defer func() { utils.EndSpan(span, st) }()
+	defer m.timeit("WriteExtents", time.Now())
+	if len(extents) == 0 {
+		return 0
+	}
+	for _, e := range extents[1:] {
+		if e.Id != extents[0].Id || e.Size != extents[0].Size {
+			return syscall.EINVAL
+		}
+	}
+	return m.writeExtents(ctx, inode, indx, extents, mtime)
+}
+
+func (m *baseMeta) writeExtents(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time) (st syscall.Errno) {
 	f := m.of.find(inode)
 	if f != nil {
 		f.Lock()
@@ -1734,7 +1998,11 @@ func (m *baseMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice
 	var numSlices int
 	var delta dirStat
 	var attr Attr
-	st := m.en.doWrite(ctx, inode, indx, off, slice, mtime, &numSlices, &delta, &attr)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: inode}, func(e *ChangeEvent) {
+		e.Parent = attr.Parent
+	})
+	st = m.en.doWrite(cctx, inode, indx, extents, mtime, &numSlices, &delta, &attr)
+	m.endChange(changes, st)
 	if st == 0 {
 		m.updateParentStat(ctx, inode, attr.Parent, delta.length, delta.space)
 		if delta.space != 0 {
@@ -1751,19 +2019,25 @@ func (m *baseMeta) Write(ctx Context, inode Ino, indx uint32, off uint32, slice
 	return st
 }
 
-func (m *baseMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, attr *Attr, skipPermCheck bool) syscall.Errno {
+func (m *baseMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64, attr *Attr, skipPermCheck bool) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "truncate", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	defer m.timeit("Truncate", time.Now())
 	f := m.of.find(inode)
 	if f != nil {
 		f.Lock()
 		defer f.Unlock()
 	}
-	defer func() { m.of.InvalidateChunk(inode, invalidateAllChunks) }()
+	defer func() { m.of.InvalidateChunk(inode, InvalidateAllChunks) }()
 	if attr == nil {
 		attr = &Attr{}
 	}
 	var delta dirStat
-	st := m.en.doTruncate(ctx, inode, flags, length, &delta, attr, skipPermCheck)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: inode}, func(e *ChangeEvent) {
+		e.Parent = attr.Parent
+	})
+	st = m.en.doTruncate(cctx, inode, flags, length, &delta, attr, skipPermCheck)
+	m.endChange(changes, st)
 	if st == 0 {
 		m.updateParentStat(ctx, inode, attr.Parent, delta.length, delta.space)
 		if delta.space != 0 {
@@ -1773,7 +2047,9 @@ func (m *baseMeta) Truncate(ctx Context, inode Ino, flags uint8, length uint64,
 	return st
 }
 
-func (m *baseMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size uint64, flength *uint64) syscall.Errno {
+func (m *baseMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, size uint64, flength *uint64) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "fallocate", inode)
+	defer func() { utils.EndSpan(span, st) }()
 	if mode&fallocCollapesRange != 0 && mode != fallocCollapesRange {
 		return syscall.EINVAL
 	}
@@ -1795,10 +2071,14 @@ func (m *baseMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, siz
 		f.Lock()
 		defer f.Unlock()
 	}
-	defer func() { m.of.InvalidateChunk(inode, invalidateAllChunks) }()
+	defer func() { m.of.InvalidateChunk(inode, InvalidateAllChunks) }()
 	var delta dirStat
 	var attr Attr
-	st := m.en.doFallocate(ctx, inode, mode, off, size, &delta, &attr)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: inode}, func(e *ChangeEvent) {
+		e.Parent = attr.Parent
+	})
+	st = m.en.doFallocate(cctx, inode, mode, off, size, &delta, &attr)
+	m.endChange(changes, st)
 	if st == 0 {
 		if flength != nil {
 			*flength = attr.Length
@@ -1812,6 +2092,8 @@ func (m *baseMeta) Fallocate(ctx Context, inode Ino, mode uint8, off uint64, siz
 }
 
 func (m *baseMeta) Readdir(ctx Context, inode Ino, plus uint8, entries *[]*Entry) (rerr syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "readdir", inode)
+	defer func() { utils.EndSpan(span, rerr) }()
 	var attr Attr
 	defer func() {
 		if rerr == 0 {
@@ -1866,7 +2148,11 @@ func (m *baseMeta) SetXattr(ctx Context, inode Ino, name string, value []byte, f
 	}
 
 	defer m.timeit("SetXattr", time.Now())
-	return m.en.doSetXattr(ctx, m.checkRoot(inode), name, value, flags)
+	inode = m.checkRoot(inode)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeXattr, Inode: inode}, nil)
+	st := m.en.doSetXattr(cctx, inode, name, value, flags)
+	m.endChange(changes, st)
+	return st
 }
 
 func (m *baseMeta) RemoveXattr(ctx Context, inode Ino, name string) syscall.Errno {
@@ -1878,7 +2164,11 @@ func (m *baseMeta) RemoveXattr(ctx Context, inode Ino, name string) syscall.Errn
 	}
 
 	defer m.timeit("RemoveXattr", time.Now())
-	return m.en.doRemoveXattr(ctx, m.checkRoot(inode), name)
+	inode = m.checkRoot(inode)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeXattr, Inode: inode}, nil)
+	st := m.en.doRemoveXattr(cctx, inode, name)
+	m.endChange(changes, st)
+	return st
 }
 
 func (m *baseMeta) GetParents(ctx Context, inode Ino) map[Ino]int {
@@ -1995,6 +2285,101 @@ func (m *baseMeta) countDirNlink(ctx Context, inode Ino) (uint32, syscall.Errno)
 	return dirCounter, 0
 }
 
+// countFileNlink returns the number of entries of a file by its parents, or 0 if it's unknown.
+func (m *baseMeta) countFileNlink(ctx Context, inode Ino, attr *Attr) uint32 {
+	if attr.Parent > 0 {
+		return 1
+	}
+	var nlink uint32
+	for _, count := range m.en.doGetParents(ctx, inode) {
+		if count > 0 {
+			nlink += uint32(count)
+		}
+	}
+	return nlink
+}
+
+// hasEntry checks whether the directory parent has an entry of inode, a missing parent has no entry.
+func (m *baseMeta) hasEntry(ctx Context, parent, inode Ino) (bool, syscall.Errno) {
+	var pattr Attr
+	if st := m.en.doGetAttr(ctx, parent, &pattr); st == syscall.ENOENT {
+		return false, 0
+	} else if st != 0 {
+		return false, st
+	}
+	if pattr.Typ != TypeDirectory {
+		return false, 0
+	}
+	var entries []*Entry
+	if st := m.en.doReaddir(ctx, parent, 0, &entries, -1); st == syscall.ENOENT {
+		return false, 0
+	} else if st != 0 {
+		return false, st
+	}
+	for _, e := range entries {
+		if e.Inode == inode {
+			return true, 0
+		}
+	}
+	return false, 0
+}
+
+// Reattach links an orphan file, whose parent doesn't have an entry of it, into /lost+found with its inode
+// number as the name, and returns the new path. It returns EEXIST if the file is still in its parent, and
+// ENOTSUP for a file with hard links, whose parents can't be repaired here.
+func (m *baseMeta) Reattach(ctx Context, inode Ino, attr *Attr) (string, syscall.Errno) {
+	if st := m.en.doGetAttr(ctx, inode, attr); st != 0 {
+		return "", st
+	}
+	if attr.Typ == TypeDirectory {
+		return "", syscall.EISDIR
+	}
+	if attr.Nlink == 0 {
+		return "", syscall.ENOENT // still opened after unlinked
+	}
+	if attr.Parent == 0 {
+		return "", syscall.ENOTSUP
+	}
+	if found, st := m.hasEntry(ctx, attr.Parent, inode); st != 0 {
+		return "", st
+	} else if found {
+		return "", syscall.EEXIST
+	}
+	var lostFound Ino
+	var lattr Attr
+	st := m.Lookup(ctx, RootInode, LostFoundName, &lostFound, &lattr, false)
+	if st == syscall.ENOENT {
+		st = m.Mkdir(ctx, RootInode, LostFoundName, 0700, 0, 0, &lostFound, &lattr)
+	}
+	if st != 0 {
+		return "", st
+	}
+	if lattr.Typ != TypeDirectory {
+		return "", syscall.ENOTDIR
+	}
+	// link it like a file created by O_TMPFILE to get the new parent, then restore the nlink
+	nlink := attr.Nlink
+	attr.Nlink = 0
+	if st = m.en.doRepair(ctx, inode, attr); st != 0 {
+		return "", st
+	}
+	name := inode.String()
+	st = m.en.doLink(ctx, inode, lostFound, name, attr)
+	if attr.Nlink != nlink {
+		attr.Nlink = nlink
+		if est := m.en.doRepair(ctx, inode, attr); est != 0 {
+			logger.Errorf("restore nlink of inode %d to %d: %s", inode, nlink, est)
+		}
+	}
+	m.of.InvalidateChunk(inode, invalidateAttrOnly)
+	if st != 0 {
+		return "", st
+	}
+	m.updateDirStat(ctx, lostFound, int64(attr.Length), align4K(attr.Length), 1)
+	m.updateDirQuota(ctx, lostFound, align4K(attr.Length), 1)
+	return "/" + LostFoundName + "/" + name, 0
+}
+
 type metaWalkFunc func(ctx Context, inode Ino, p string, attr *Attr)
 
 func (m *baseMeta) walk(ctx Context, inode Ino, p string, attr *Attr, walkFn metaWalkFunc) syscall.Errno {
@@ -2104,7 +2489,26 @@ func (m *baseMeta) Check(ctx Context, fpath string, repair bool, recursive bool,
 				path := e.path
 				attr := e.attr
 				if attr.Typ != TypeDirectory {
-					// TODO
+					if attr.Full {
+						if nlink := m.countFileNlink(ctx, inode, attr); nlink > 0 && attr.Nlink != nlink {
+							logger.Warnf("nlink of %s should be %d, but got %d", path, nlink, attr.Nlink)
+							if repair {
+								attr.Nlink = nlink
+								st := m.en.doRepair(ctx, inode, attr)
+								m.of.InvalidateChunk(inode, invalidateAttrOnly)
+								if st == 0 || st == syscall.ENOENT {
+									logger.Debugf("Nlink of path %s (inode %d) is successfully repaired", path, inode)
+								} else {
+									hasError = true
+									logger.Errorf("Repair nlink of path %s inode %d: %s", path, inode, st)
+								}
+							} else {
+								logger.Warnf("Path %s (inode %d) can be repaired, please re-run with '--path %s --repair' to fix it", path, inode, path)
+								hasError = true
+							}
+						}
+					}
+					nodeBar.Increment()
 					continue
 				}
 
@@ -2321,12 +2725,19 @@ func (m *baseMeta) compactChunk(inode Ino, indx uint32, once, force bool) {
 			time.Sleep(time.Millisecond * 10)
 			m.Lock()
 		}
-	} else if len(m.compacting) > 10 || m.compacting[k] {
+	} else if m.compactStart != m.compactEnd && !inTimeWindow(time.Now(), m.compactStart, m.compactEnd) {
+		m.Unlock()
+		return // compact it later in the window
+	} else if len(m.compacting) >= m.conf.MaxCompactions || m.compacting[k] {
+		if !m.compacting[k] {
+			m.compactSkips.Inc()
+		}
 		m.Unlock()
 		return
 	}
 	m.compacting[k] = true
 	m.Unlock()
+	start := time.Now()
 	defer func() {
 		m.Lock()
 		delete(m.compacting, k)
@@ -2363,6 +2774,9 @@ func (m *baseMeta) compactChunk(inode Ino, indx uint32, once, force bool) {
 		}
 	}
 
+	if m.compactLimit != nil {
+		m.compactLimit.Wait(int64(size))
+	}
 	var id uint64
 	if st = m.NewSlice(Background(), &id); st != 0 {
 		return
@@ -2399,6 +2813,7 @@ func (m *baseMeta) compactChunk(inode Ino, indx uint32, once, force bool) {
 	} else {
 		logger.Warnf("compact %d %d: %s", inode, indx, err)
 	}
+	m.compactDist.Observe(time.Since(start).Seconds())
 
 	if force {
 		m.Lock()
@@ -2459,6 +2874,63 @@ func (m *baseMeta) Compact(ctx Context, inode Ino, concurrency int, preFunc, pos
 	return st
 }
 
+func (m *baseMeta) CheckFragments(ctx Context, inode Ino, concurrency int, frag *Fragments, progress func()) syscall.Errno {
+	var attr Attr
+	if st := m.GetAttr(ctx, inode, &attr); st != 0 {
+		return st
+	}
+	var wg sync.WaitGroup
+	chunkChan := make(chan cchunk, 10000)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunkChan {
+				ss, st := m.en.doRead(ctx, c.inode, c.indx)
+				if st == 0 && len(ss) > 0 {
+					atomic.AddUint64(&frag.Chunks, 1)
+					atomic.AddUint64(&frag.Slices, uint64(len(ss)))
+					// the same as compactChunk
+					if len(ss) > maxCompactSlices {
+						ss = ss[:maxCompactSlices]
+					}
+					compacted := ss[skipSome(ss):]
+					if _, size, _ := compactChunk(compacted); len(compacted) >= 2 && size > 0 {
+						atomic.AddUint64(&frag.FragmentedChunks, 1)
+						atomic.AddUint64(&frag.FragmentedSlices, uint64(len(compacted)))
+						atomic.AddUint64(&frag.CompactBytes, uint64(size))
+					}
+				}
+				progress()
+				if ctx.Canceled() {
+					return
+				}
+			}
+		}()
+	}
+
+	st := m.walk(ctx, inode, "", &attr, func(ctx Context, fIno Ino, path string, fAttr *Attr) {
+		if fAttr.Typ != TypeFile {
+			return
+		}
+		atomic.AddUint64(&frag.Files, 1)
+		chunkCnt := uint32((fAttr.Length + ChunkSize - 1) / ChunkSize)
+		for i := uint32(0); i < chunkCnt; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case chunkChan <- cchunk{inode: fIno, indx: i}:
+			}
+		}
+	})
+	close(chunkChan)
+	wg.Wait()
+	if st != 0 {
+		logger.Errorf("walk error [inode %v]: %v", inode, st)
+	}
+	return st
+}
+
 func (m *baseMeta) fileDeleted(opened, force bool, inode Ino, length uint64) {
 	if opened {
 		m.Lock()
@@ -2495,6 +2967,30 @@ func (m *baseMeta) deleteSlice_(id uint64, size uint32) {
 	}
 }
 
+func (m *baseMeta) SaveChecksums(id uint64, sums []uint32) error {
+	buf := utils.NewBuffer(uint32(len(sums) * 4))
+	for _, sum := range sums {
+		buf.Put32(sum)
+	}
+	return m.en.doSetChecksums(Background(), id, buf.Bytes())
+}
+
+func (m *baseMeta) LoadChecksums(id uint64) ([]uint32, error) {
+	data, err := m.en.doGetChecksums(Background(), id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid checksums of slice %d: %d bytes", id, len(data))
+	}
+	buf := utils.FromBuffer(data)
+	sums := make([]uint32, len(data)/4)
+	for i := range sums {
+		sums[i] = buf.Get32()
+	}
+	return sums, nil
+}
+
 func (m *baseMeta) deleteSlice(id uint64, size uint32) {
 	if id == 0 || m.conf.MaxDeletes == 0 {
 		return
@@ -2593,6 +3089,11 @@ func (m *baseMeta) cleanupTrash(ctx Context) {
 
 func (m *baseMeta) CleanupDetachedNodesBefore(ctx Context, edge time.Time, increProgress func()) {
 	for _, inode := range m.en.doFindDetachedNodes(edge) {
+		// the directories removed by RemoveAsync are deleted by the clients, at the rate they are limited to
+		var attr Attr
+		if st := m.en.doGetAttr(ctx, inode, &attr); st == 0 && attr.Flags&FlagDeleting != 0 {
+			continue
+		}
 		if eno := m.en.doCleanupDetachedNode(Background(), inode); eno != 0 {
 			logger.Errorf("cleanupDetachedNode: remove detached tree (%d) error: %s", inode, eno)
 		} else {
@@ -2736,6 +3237,9 @@ func (m *baseMeta) doCleanupTrash(ctx Context, days int, force bool) {
 		edge = time.Now()
 	}
 	m.CleanupTrashBefore(ctx, edge, nil)
+	if quota := m.getFormat().TrashUserQuota; quota > 0 && !force {
+		m.cleanupTrashQuota(ctx, quota)
+	}
 }
 
 func (m *baseMeta) cleanupDelayedSlices(ctx Context, days int) {
@@ -2852,10 +3356,14 @@ func (m *baseMeta) Clone(ctx Context, srcParentIno, srcIno, parent Ino, name str
 	}
 	*total = sum.Dirs + sum.Files
 	concurrent := make(chan struct{}, 4)
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeCreate, Type: attr.Typ, Parent: parent, Name: name}, func(e *ChangeEvent) {
+		e.Inode = dstIno
+	})
 	if attr.Typ == TypeDirectory {
 		eno = m.cloneEntry(ctx, srcIno, parent, name, &dstIno, cmode, cumask, count, true, concurrent)
 		if eno == 0 {
-			eno = m.en.doAttachDirNode(ctx, parent, dstIno, name)
+			// the tree is visible once attached
+			eno = m.en.doAttachDirNode(cctx, parent, dstIno, name)
 		}
 		if eno != 0 && dstIno != 0 {
 			if eno := m.en.doCleanupDetachedNode(ctx, dstIno); eno != 0 {
@@ -2863,8 +3371,9 @@ func (m *baseMeta) Clone(ctx Context, srcParentIno, srcIno, parent Ino, name str
 			}
 		}
 	} else {
-		eno = m.cloneEntry(ctx, srcIno, parent, name, nil, cmode, cumask, count, true, concurrent)
+		eno = m.cloneEntry(cctx, srcIno, parent, name, &dstIno, cmode, cumask, count, true, concurrent)
 	}
+	m.endChange(changes, eno)
 	if eno == 0 {
 		m.updateDirStat(ctx, parent, int64(attr.Length), align4K(attr.Length), 1)
 		m.updateDirQuota(ctx, parent, int64(sum.Size), int64(sum.Dirs)+int64(sum.Files))
@@ -2971,6 +3480,10 @@ LOOP:
 }
 
 func (m *baseMeta) mergeAttr(ctx Context, inode Ino, set uint16, cur, attr *Attr, now time.Time, rule *aclAPI.Rule) (*Attr, syscall.Errno) {
+	if cur.Flags&(FlagImmutable|FlagAppend) != 0 && set&SetAttrFlag == 0 &&
+		set&(SetAttrMode|SetAttrUID|SetAttrGID|SetAttrAtime|SetAttrMtime|SetAttrAtimeNow|SetAttrMtimeNow) != 0 {
+		return nil, syscall.EPERM // the same on all the clients, including the read-only files of Windows
+	}
 	dirtyAttr := *cur
 	if (set&(SetAttrUID|SetAttrGID)) != 0 && (set&SetAttrMode) != 0 {
 		attr.Mode |= (cur.Mode & 06000)
@@ -22,10 +22,12 @@ package meta
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
@@ -128,6 +130,7 @@ func testMeta(t *testing.T, m Meta) {
 	testTrash(t, m)
 	testParents(t, m)
 	testRemove(t, m)
+	testRemoveAsync(t, m)
 	testResolve(t, m)
 	testStickyBit(t, m)
 	testLocks(t, m)
@@ -137,6 +140,8 @@ func testMeta(t *testing.T, m Meta) {
 	time.Sleep(time.Second)
 	testCompaction(t, m, true)
 	testCopyFileRange(t, m)
+	testWriteExtents(t, m)
+	testTmpfile(t, m)
 	testCloseSession(t, m)
 	testConcurrentDir(t, m)
 	testAttrFlags(t, m)
@@ -156,6 +161,8 @@ func testMeta(t *testing.T, m Meta) {
 	testDirStat(t, m)
 	testClone(t, m)
 	testACL(t, m)
+	testChangelog(t, m)
+	testBlockChecksums(t, m)
 	base.conf.ReadOnly = true
 	testReadOnly(t, m)
 }
@@ -432,6 +439,12 @@ func testMetaClient(t *testing.T, m Meta) {
 	if base.sid != ses[0].Sid {
 		t.Fatalf("my sid %d != registered sid %d", base.sid, ses[0].Sid)
 	}
+	if err = m.KillSession(base.sid, true); err == nil {
+		t.Fatalf("kill the current session should fail")
+	}
+	if err = m.KillSession(base.sid+1000, true); err == nil {
+		t.Fatalf("kill a nonexistent session should fail")
+	}
 	go m.CleanStaleSessions(Background())
 
 	var parent, inode, dummyInode Ino
@@ -1251,6 +1264,64 @@ func testLocks(t *testing.T, m Meta) {
 		t.Fatalf("lock fail: %s", err)
 	}
 
+	// deadlock
+	if st := m.Setlk(ctx, inode, 1, false, syscall.F_WRLCK, 0, 10, 1); st != 0 {
+		t.Fatalf("plock wlock: %s", st)
+	}
+	if st := m.Setlk(ctx, inode, 2, false, syscall.F_WRLCK, 20, 30, 2); st != 0 {
+		t.Fatalf("plock wlock: %s", st)
+	}
+	done := make(chan syscall.Errno)
+	go func() { done <- m.Setlk(ctx, inode, 1, true, syscall.F_WRLCK, 20, 30, 1) }()
+	time.Sleep(time.Millisecond * 100)
+	if st := m.Setlk(ctx, inode, 2, true, syscall.F_WRLCK, 0, 10, 2); st != syscall.EDEADLK {
+		t.Fatalf("plock deadlock: %s", st)
+	}
+	if st := m.Setlk(ctx, inode, 2, false, syscall.F_UNLCK, 0, 0xFFFFFFFF, 2); st != 0 {
+		t.Fatalf("plock unlock: %s", st)
+	}
+	if st := <-done; st != 0 {
+		t.Fatalf("plock wlock: %s", st)
+	}
+	if st := m.Setlk(ctx, inode, 1, false, syscall.F_UNLCK, 0, 0xFFFFFFFF, 1); st != 0 {
+		t.Fatalf("plock unlock: %s", st)
+	}
+
+	// deadlock with another client
+	base := m.getBase()
+	sid, other := base.sid, base.sid+1000
+	if st := m.Setlk(ctx, inode, 1, false, syscall.F_WRLCK, 0, 10, 1); st != 0 {
+		t.Fatalf("plock wlock: %s", st)
+	}
+	base.sid = other
+	st := m.Setlk(ctx, inode, 2, false, syscall.F_WRLCK, 20, 30, 2)
+	base.sid = sid
+	if st != 0 {
+		t.Fatalf("plock wlock of another client: %s", st)
+	}
+	waits, _ := json.Marshal([]*lockWait{{Inode: inode, Owner: 2, Type: syscall.F_WRLCK, Start: 0, End: 10, Pid: 2}})
+	if err := base.en.doSetLockWaits(ctx, other, waits); err != nil {
+		t.Fatalf("publish lock waits: %s", err)
+	}
+	if st := m.Setlk(ctx, inode, 1, true, syscall.F_WRLCK, 20, 30, 1); st != syscall.EDEADLK {
+		t.Fatalf("plock deadlock across clients: %s", st)
+	}
+	if err := base.en.doSetLockWaits(ctx, other, nil); err != nil {
+		t.Fatalf("remove lock waits: %s", err)
+	}
+	if ws, err := base.en.doListLockWaits(ctx); err != nil || len(ws) != 0 {
+		t.Fatalf("list lock waits: %v %s", ws, err)
+	}
+	base.sid = other
+	st = m.Setlk(ctx, inode, 2, false, syscall.F_UNLCK, 0, 0xFFFFFFFF, 2)
+	base.sid = sid
+	if st != 0 {
+		t.Fatalf("plock unlock of another client: %s", st)
+	}
+	if st := m.Setlk(ctx, inode, 1, false, syscall.F_UNLCK, 0, 0xFFFFFFFF, 1); st != 0 {
+		t.Fatalf("plock unlock: %s", st)
+	}
+
 	if r, ok := m.(*redisMeta); ok {
 		ms, err := r.rdb.SMembers(context.Background(), r.lockedKey(r.sid)).Result()
 		if err != nil {
@@ -1349,6 +1420,81 @@ func testRemove(t *testing.T, m Meta) {
 	}
 }
 
+func testRemoveAsync(t *testing.T, m Meta) {
+	ctx := Background()
+	var parent, inode, dir Ino
+	var attr = &Attr{}
+	if st := m.Mkdir(ctx, 1, "ad", 0755, 0, 0, &parent, attr); st != 0 {
+		t.Fatalf("mkdir ad: %s", st)
+	}
+	if st := m.Create(ctx, parent, "f", 0644, 0, 0, &inode, attr); st != 0 {
+		t.Fatalf("create ad/f: %s", st)
+	}
+	if st := m.RemoveAsync(ctx, parent, "f", &dir); st != syscall.ENOTDIR {
+		t.Fatalf("remove file async should fail with ENOTDIR: %s", st)
+	}
+	if st := m.Mkdir(ctx, parent, "d", 0755, 0, 0, &inode, attr); st != 0 {
+		t.Fatalf("mkdir ad/d: %s", st)
+	}
+	for i := 0; i < 10; i++ {
+		if st := m.Create(ctx, inode, "f"+strconv.Itoa(i), 0644, 0, 0, &dir, attr); st != 0 {
+			t.Fatalf("create ad/d/f%d: %s", i, st)
+		}
+	}
+	if st := m.RemoveAsync(NewContext(1, 1, []uint32{1}), 1, "ad", &dir); st != syscall.EPERM {
+		t.Fatalf("remove async by non-root should fail with EPERM: %s", st)
+	}
+	if st := m.RemoveAsync(ctx, 1, "ad", &dir); st != 0 || dir != parent {
+		t.Fatalf("remove ad async: %s, inode %d", st, dir)
+	}
+	if st := m.Lookup(ctx, 1, "ad", &inode, attr, true); st != syscall.ENOENT {
+		t.Fatalf("lookup ad should fail with ENOENT: %s", st)
+	}
+	var rattr Attr
+	if st := m.GetAttr(ctx, 1, &rattr); st != 0 || rattr.Nlink != 2 {
+		t.Fatalf("getattr root: %s, nlink %d", st, rattr.Nlink)
+	}
+	base := m.getBase()
+	base.doFlushDirStat()
+	ds, st := m.ListDeletions(ctx, true)
+	if st != 0 || len(ds) != 1 || ds[0].Inode != parent || ds[0].Path != "/ad" {
+		t.Fatalf("list deletions: %s %+v", st, ds)
+	}
+	if ds[0].Remaining.Files != 11 || ds[0].Remaining.Dirs != 2 {
+		t.Fatalf("remaining entries: %+v", ds[0].Remaining)
+	}
+	base.CleanupDetachedNodesBefore(ctx, time.Now().Add(time.Hour), nil)
+	if st = m.GetAttr(ctx, parent, attr); st != 0 {
+		t.Fatalf("the dir being deleted should be skipped by gc: %s", st)
+	}
+	var count uint64
+	if st = base.emptyDetachedDir(ctx, parent, parent, nil, &count); st != syscall.EBUSY {
+		t.Fatalf("empty detached dir without claiming should fail with EBUSY: %s", st)
+	}
+	other := base.sid + 1
+	if st = m.SetXattr(ctx, parent, deletingOwnerXattr, []byte(strconv.FormatUint(other, 10)), XattrCreate); st != 0 {
+		t.Fatalf("claim by another session: %s", st)
+	}
+	if base.claimDeletingDir(ctx, parent, map[uint64]bool{other: true}) {
+		t.Fatalf("the dir claimed by a live session should not be claimed")
+	}
+	if !base.claimDeletingDir(ctx, parent, map[uint64]bool{}) {
+		t.Fatalf("the dir claimed by a stale session should be taken over")
+	}
+	if st = base.emptyDetachedDir(ctx, parent, parent, nil, &count); st != 0 {
+		t.Fatalf("empty detached dir: %s", st)
+	}
+	if st = base.en.doCleanupDetachedNode(ctx, parent); st != 0 {
+		t.Fatalf("cleanup detached dir: %s", st)
+	}
+	if st = m.GetAttr(ctx, parent, attr); st != syscall.ENOENT {
+		t.Fatalf("getattr of deleted dir should fail with ENOENT: %s", st)
+	}
+	if ds, st = m.ListDeletions(ctx, false); st != 0 || len(ds) != 0 {
+		t.Fatalf("list deletions after deleted: %s %+v", st, ds)
+	}
+}
+
 func testCaseIncensi(t *testing.T, m Meta) {
 	ctx := Background()
 	var inode Ino
@@ -1601,6 +1747,13 @@ func testCompaction(t *testing.T, m Meta, trash bool) {
 	_ = m.Write(ctx, inode, 1, uint32(30<<20), Slice{Id: sliceId, Size: 8, Len: 8}, time.Now())
 	m.NewSlice(ctx, &sliceId)
 	_ = m.Write(ctx, inode, 1, uint32(40<<20), Slice{Id: sliceId, Size: 8, Len: 8}, time.Now())
+	var frag Fragments
+	if st := m.CheckFragments(ctx, inode, 2, &frag, func() {}); st != 0 {
+		t.Fatalf("check fragments: %s", st)
+	}
+	if frag.Files != 1 || frag.Chunks != 1 || frag.Slices != 3 || frag.FragmentedChunks != 1 || frag.CompactBytes == 0 {
+		t.Fatalf("fragments before compaction: %+v", frag)
+	}
 	var cs1 []Slice
 	_ = m.Read(ctx, inode, 1, &cs1)
 	if len(cs1) != 5 {
@@ -1614,6 +1767,10 @@ func testCompaction(t *testing.T, m Meta, trash bool) {
 	if len(cs) != 1 {
 		t.Fatalf("expect 1 slice, but got %+v", cs)
 	}
+	frag = Fragments{}
+	if st := m.CheckFragments(ctx, 1, 2, &frag, func() {}); st != 0 || frag.FragmentedChunks != 0 {
+		t.Fatalf("fragments after compaction: %s %+v", st, frag)
+	}
 
 	// append
 	var size uint32 = 100000
@@ -1890,6 +2047,62 @@ func testTruncateAndDelete(t *testing.T, m Meta) {
 	}
 }
 
+func testWriteExtents(t *testing.T, m Meta) {
+	m.OnMsg(DeleteSlice, func(args ...interface{}) error {
+		return nil
+	})
+	ctx := Background()
+	var inode Ino
+	var attr = &Attr{}
+	_ = m.Unlink(ctx, 1, "flog")
+	if st := m.Create(ctx, 1, "flog", 0644, 022, 0, &inode, attr); st != 0 {
+		t.Fatalf("create file: %s", st)
+	}
+	defer m.Unlink(ctx, 1, "flog")
+	var id uint64
+	_ = m.NewSlice(ctx, &id)
+	extents := []Extent{
+		{Pos: 8192, Slice: Slice{Id: id, Size: 12288, Off: 0, Len: 4096}},
+		{Pos: 0, Slice: Slice{Id: id, Size: 12288, Off: 4096, Len: 4096}},
+		{Pos: 10240, Slice: Slice{Id: id, Size: 12288, Off: 8192, Len: 4096}},
+	}
+	if st := m.WriteExtents(ctx, inode, 1, []Extent{extents[0], {Pos: 0, Slice: Slice{Id: id + 1, Len: 1}}}, time.Now()); st != syscall.EINVAL {
+		t.Fatalf("extents of different slices: %s", st)
+	}
+	if st := m.WriteExtents(ctx, inode, 1, extents, time.Now()); st != 0 {
+		t.Fatalf("write extents: %s", st)
+	}
+	if st := m.GetAttr(ctx, inode, attr); st != 0 || attr.Length != ChunkSize+14336 {
+		t.Fatalf("length: %d %s", attr.Length, st)
+	}
+	var slices []Slice
+	if st := m.Read(ctx, inode, 1, &slices); st != 0 {
+		t.Fatalf("read chunk: %s", st)
+	}
+	expected := []Slice{
+		{Id: id, Size: 12288, Off: 4096, Len: 4096},
+		{Off: 4096, Len: 4096},
+		{Id: id, Size: 12288, Off: 0, Len: 2048},
+		{Id: id, Size: 12288, Off: 8192, Len: 4096},
+	}
+	if !reflect.DeepEqual(slices, expected) {
+		t.Fatalf("expect %+v, but got %+v", expected, slices)
+	}
+	var sliceMap = make(map[Ino][]Slice)
+	if st := m.ListSlices(ctx, sliceMap, false, false, nil); st != 0 {
+		t.Fatalf("list slices: %s", st)
+	}
+	var refs int
+	for _, s := range sliceMap[inode] {
+		if s.Id == id {
+			refs++
+		}
+	}
+	if refs != 3 {
+		t.Fatalf("expect 3 references of the slice, but got %d", refs)
+	}
+}
+
 func testCopyFileRange(t *testing.T, m Meta) {
 	m.OnMsg(DeleteSlice, func(args ...interface{}) error {
 		return nil
@@ -1958,6 +2171,64 @@ func testCopyFileRange(t *testing.T, m Meta) {
 	}
 }
 
+func testTmpfile(t *testing.T, m Meta) {
+	m.getBase().sid = 0
+	if err := m.NewSession(true); err != nil {
+		t.Fatalf("new session: %s", err)
+	}
+	defer m.CloseSession()
+	ctx := Background()
+	sid := m.getBase().sid
+	sustained := func() int {
+		s, err := m.GetSession(sid, true)
+		if err != nil {
+			t.Fatalf("get session: %s", err)
+		}
+		return len(s.Sustained)
+	}
+	before := sustained()
+
+	// what VFS does for O_TMPFILE
+	var inode Ino
+	var attr = &Attr{}
+	if st := m.Create(ctx, 1, "tmpfile", 0644, 022, syscall.O_RDWR, &inode, attr); st != 0 {
+		t.Fatalf("create tmpfile: %s", st)
+	}
+	if st := m.Unlink(ctx, 1, "tmpfile", true); st != 0 {
+		t.Fatalf("unlink tmpfile: %s", st)
+	}
+	if st := m.GetAttr(ctx, inode, attr); st != 0 || attr.Nlink != 0 {
+		t.Fatalf("getattr tmpfile: %s nlink %d", st, attr.Nlink)
+	}
+	if n := sustained(); n != before+1 {
+		t.Fatalf("sustained %d, expect %d", n, before+1)
+	}
+
+	// linkat(2) makes it a regular file
+	if st := m.Link(ctx, inode, 1, "linked", attr); st != 0 {
+		t.Fatalf("link tmpfile: %s", st)
+	}
+	if attr.Nlink != 1 || attr.Parent != 1 {
+		t.Fatalf("nlink %d parent %d, expect 1 and 1", attr.Nlink, attr.Parent)
+	}
+	if n := sustained(); n != before {
+		t.Fatalf("sustained %d, expect %d", n, before)
+	}
+	if st := m.Close(ctx, inode); st != 0 {
+		t.Fatalf("close tmpfile: %s", st)
+	}
+	var found Ino
+	if st := m.Lookup(ctx, 1, "linked", &found, attr, true); st != 0 || found != inode {
+		t.Fatalf("lookup linked: %s inode %d, expect %d", st, found, inode)
+	}
+	if ps := m.GetParents(ctx, inode); len(ps) != 1 || ps[1] != 1 {
+		t.Fatalf("parents of linked: %v", ps)
+	}
+	if st := m.Unlink(ctx, 1, "linked"); st != 0 {
+		t.Fatalf("unlink linked: %s", st)
+	}
+}
+
 func testCloseSession(t *testing.T, m Meta) {
 	// reset session
 	m.getBase().sid = 0
@@ -2248,6 +2519,70 @@ func testTrash(t *testing.T, m Meta) {
 		t.Fatalf("rmdir secrmd: %s", st)
 	}
 
+	// restore a file in a removed directory
+	var dir Ino
+	attr = &Attr{}
+	if st := m.Mkdir(ctx, 1, "rd", 0755, 022, 0, &dir, attr); st != 0 {
+		t.Fatalf("mkdir rd: %s", st)
+	}
+	if st := m.Create(ctx, dir, "rf", 0644, 022, 0, &inode, attr); st != 0 {
+		t.Fatalf("create rd/rf: %s", st)
+	}
+	if st := m.Unlink(ctx, dir, "rf"); st != 0 {
+		t.Fatalf("unlink rd/rf: %s", st)
+	}
+	if st := m.Rmdir(ctx, 1, "rd"); st != 0 {
+		t.Fatalf("rmdir rd: %s", st)
+	}
+	findTrash := func(p string) *TrashEntry {
+		tes, st := m.ListTrash(ctx)
+		if st != 0 {
+			t.Fatalf("list trash: %s", st)
+		}
+		for _, e := range tes {
+			if e.Path == p {
+				return e
+			}
+		}
+		return nil
+	}
+	te := findTrash("/rd/rf")
+	if te == nil || te.Inode != inode || te.Parent != dir {
+		t.Fatalf("rd/rf is not found in trash: %+v", te)
+	}
+	if st := m.RestoreTrash(ctx, te); st != 0 {
+		t.Fatalf("restore rd/rf: %s", st)
+	}
+	if st := m.Lookup(ctx, 1, "rd", &dir, attr, true); st != 0 || dir != te.Parent {
+		t.Fatalf("lookup rd: %s", st)
+	}
+	if st := m.Lookup(ctx, dir, "rf", &inode, attr, true); st != 0 || inode != te.Inode {
+		t.Fatalf("lookup rd/rf: %s", st)
+	}
+	if findTrash("/rd") != nil || findTrash("/rd/rf") != nil {
+		t.Fatalf("rd/rf should not be in trash")
+	}
+	if st := m.Remove(ctx, 1, "rd", true, RmrDefaultThreads, nil); st != 0 {
+		t.Fatalf("rmr rd: %s", st)
+	}
+
+	// the oldest files are deleted when the trash quota is exceeded
+	for _, name := range []string{"q1", "q2"} {
+		if st := m.Create(ctx, 1, name, 0644, 022, 0, &inode, attr); st != 0 {
+			t.Fatalf("create %s: %s", name, st)
+		}
+		if st := m.Truncate(ctx, inode, 0, 1<<20, attr, false); st != 0 {
+			t.Fatalf("truncate %s: %s", name, st)
+		}
+		if st := m.Unlink(ctx, 1, name); st != 0 {
+			t.Fatalf("unlink %s: %s", name, st)
+		}
+	}
+	m.getBase().cleanupTrashQuota(ctx, 1<<20)
+	if findTrash("/q1") != nil || findTrash("/q2") == nil {
+		t.Fatalf("only q1 should be deleted from trash")
+	}
+
 	ctx2 := NewContext(1000, 1, []uint32{1})
 	if st := m.Unlink(ctx2, TrashInode+1, "d"); st != syscall.EPERM {
 		t.Fatalf("unlink d: %s", st)
@@ -2619,6 +2954,54 @@ func testAttrFlags(t *testing.T, m Meta) {
 	if st := m.CopyFileRange(ctx, copysrcFile, 0, copydstFile, 0, 1024, 0, nil, nil); st != syscall.EPERM {
 		t.Fatalf("copy_file_range f: %s", st)
 	}
+
+	var wormFile Ino
+	if st := m.Create(ctx, 1, "wormfile", 0644, 022, 0, &wormFile, nil); st != 0 {
+		t.Fatalf("create f: %s", st)
+	}
+	attr.Flags = FlagAppend
+	if st := m.SetAttr(ctx, wormFile, SetAttrFlag, 0, attr); st != 0 {
+		t.Fatalf("setattr f: %s", st)
+	}
+	var sliceId uint64
+	if st := m.NewSlice(ctx, &sliceId); st != 0 {
+		t.Fatalf("new slice: %s", st)
+	}
+	if st := m.Write(ctx, wormFile, 0, 0, Slice{Id: sliceId, Size: 100, Len: 100}, time.Now()); st != 0 {
+		t.Fatalf("write f: %s", st)
+	}
+	if st := m.Write(ctx, wormFile, 0, 50, Slice{Id: sliceId, Size: 100, Len: 100}, time.Now()); st != syscall.EPERM {
+		t.Fatalf("overwrite append-only f: %s", st)
+	}
+	if st := m.NewSlice(ctx, &sliceId); st != 0 {
+		t.Fatalf("new slice: %s", st)
+	}
+	if st := m.Write(ctx, wormFile, 0, 100, Slice{Id: sliceId, Size: 100, Len: 100}, time.Now()); st != 0 {
+		t.Fatalf("append f: %s", st)
+	}
+	attr.Mode = 0600
+	if st := m.SetAttr(ctx, wormFile, SetAttrMode, 0, attr); st != syscall.EPERM {
+		t.Fatalf("chmod f: %s", st)
+	}
+	attr.Flags = FlagImmutable
+	if st := m.SetAttr(ctx, wormFile, SetAttrFlag, 0, attr); st != 0 {
+		t.Fatalf("setattr f: %s", st)
+	}
+	if st := m.Write(ctx, wormFile, 0, 200, Slice{Id: sliceId, Size: 100, Len: 100}, time.Now()); st != syscall.EPERM {
+		t.Fatalf("write f: %s", st)
+	}
+	if st := m.SetAttr(ctx, wormFile, SetAttrMtimeNow, 0, attr); st != syscall.EPERM {
+		t.Fatalf("touch f: %s", st)
+	}
+
+	// clear the flags of f for the later tests
+	if st := m.Lookup(ctx, 1, "f", &inode, attr, false); st != 0 {
+		t.Fatalf("lookup f: %s", st)
+	}
+	attr.Flags = 0
+	if st := m.SetAttr(ctx, inode, SetAttrFlag, 0, attr); st != 0 {
+		t.Fatalf("setattr f: %s", st)
+	}
 }
 
 func setAttr(t *testing.T, m Meta, inode Ino, attr *Attr) {
@@ -2629,7 +3012,7 @@ func setAttr(t *testing.T, m Meta, inode Ino, attr *Attr) {
 			return tx.Set(Background(), m.inodeKey(inode), m.marshal(attr), 0).Err()
 		}, m.inodeKey(inode))
 	case *dbMeta:
-		err = m.txn(func(s *xorm.Session) error {
+		err = m.txn(Background(), func(s *xorm.Session) error {
 			_, err = s.ID(inode).AllCols().Update(&node{
 				Inode:     inode,
 				Type:      attr.Typ,
@@ -2767,6 +3150,47 @@ func testCheckAndRepair(t *testing.T, m Meta) {
 			t.Fatalf("d4Inode  attr: %+v", *dirAttr)
 		}
 	}
+
+	var fileInode Ino
+	fileAttr := &Attr{}
+	if st := m.Create(Background(), checkInode, "file", 0640, 022, 0, &fileInode, fileAttr); st != 0 {
+		t.Fatalf("create: %s", st)
+	}
+	fileAttr.Nlink = 5
+	setAttr(t, m, fileInode, fileAttr)
+	if err := m.Check(Background(), "/check/file", false, false, false); err == nil {
+		t.Fatal("check should fail")
+	}
+	if err := m.Check(Background(), "/check/file", true, false, false); err != nil {
+		t.Fatalf("check: %s", err)
+	}
+	if st := m.GetAttr(Background(), fileInode, fileAttr); st != 0 || fileAttr.Nlink != 1 {
+		t.Fatalf("nlink of file should be 1: %d %s", fileAttr.Nlink, st)
+	}
+
+	if _, st := m.Reattach(Background(), fileInode, fileAttr); st != syscall.EEXIST {
+		t.Fatalf("reattach a file in its parent: %s", st)
+	}
+	if st := m.GetAttr(Background(), fileInode, fileAttr); st != 0 || fileAttr.Nlink != 1 || fileAttr.Parent != checkInode {
+		t.Fatalf("attr of the file should not be changed: %+v %s", *fileAttr, st)
+	}
+	// pretend the file is an orphan, whose parent is gone
+	fileAttr.Parent = 1 << 40
+	setAttr(t, m, fileInode, fileAttr)
+	p, st := m.Reattach(Background(), fileInode, fileAttr)
+	if st != 0 {
+		t.Fatalf("reattach: %s", st)
+	}
+	var lostFound, inode Ino
+	if st = m.Lookup(Background(), RootInode, LostFoundName, &lostFound, fileAttr, false); st != 0 {
+		t.Fatalf("lookup lost+found: %s", st)
+	}
+	if st = m.Lookup(Background(), lostFound, fileInode.String(), &inode, fileAttr, false); st != 0 || inode != fileInode {
+		t.Fatalf("lookup %s: %s", p, st)
+	}
+	if fileAttr.Nlink != 1 || fileAttr.Parent != lostFound {
+		t.Fatalf("attr of reattached file: %+v", *fileAttr)
+	}
 }
 
 func testDirStat(t *testing.T, m Meta) {
@@ -2874,6 +3298,116 @@ func testDirStat(t *testing.T, m Meta) {
 	checkResult(0, 0, 0)
 }
 
+func testChangelog(t *testing.T, m Meta) {
+	format := testFormat()
+	format.ChangelogDays = 1
+	if err := m.Init(format, false); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer func() {
+		if err := m.Init(testFormat(), false); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+	}()
+	ctx := Background()
+	var parent, inode Ino
+	var attr = &Attr{}
+	if st := m.Mkdir(ctx, 1, "cld", 0755, 022, 0, &parent, attr); st != 0 {
+		t.Fatalf("mkdir cld: %s", st)
+	}
+	if st := m.Create(ctx, parent, "f", 0644, 022, 0, &inode, attr); st != 0 {
+		t.Fatalf("create cld/f: %s", st)
+	}
+	var sliceId uint64
+	for i := 0; i < 3; i++ {
+		if st := m.NewSlice(ctx, &sliceId); st != 0 {
+			t.Fatalf("new slice: %s", st)
+		}
+		if st := m.Write(ctx, inode, 0, uint32(i)*100, Slice{Id: sliceId, Size: 100, Len: 100}, time.Now()); st != 0 {
+			t.Fatalf("write cld/f: %s", st)
+		}
+	}
+	attr.Mode = 0600
+	if st := m.SetAttr(ctx, inode, SetAttrMode, 0, attr); st != 0 {
+		t.Fatalf("setattr cld/f: %s", st)
+	}
+	var replaced Ino
+	if st := m.Create(ctx, 1, "clf", 0644, 022, 0, &replaced, nil); st != 0 {
+		t.Fatalf("create clf: %s", st)
+	}
+	if st := m.Rename(ctx, parent, "f", 1, "clf", 0, nil, nil); st != 0 {
+		t.Fatalf("rename cld/f: %s", st)
+	}
+	if st := m.Unlink(ctx, 1, "clf"); st != 0 {
+		t.Fatalf("unlink clf: %s", st)
+	}
+	if st := m.Rmdir(ctx, 1, "cld"); st != 0 {
+		t.Fatalf("rmdir cld: %s", st)
+	}
+	// the events are persisted in the transactions of the operations
+
+	expected := []ChangeEvent{
+		{Op: ChangeCreate, Type: TypeDirectory, Inode: parent, Parent: 1, Name: "cld"},
+		{Op: ChangeCreate, Type: TypeFile, Inode: inode, Parent: parent, Name: "f"},
+		{Op: ChangeWrite, Type: TypeFile, Inode: inode, Parent: parent},
+		{Op: ChangeSetAttr, Type: TypeFile, Inode: inode, Parent: parent},
+		{Op: ChangeCreate, Type: TypeFile, Inode: replaced, Parent: 1, Name: "clf"},
+		{Op: ChangeRename, Type: TypeFile, Inode: inode, Parent: parent, Name: "f", NewParent: 1, NewName: "clf", Replaced: replaced},
+		{Op: ChangeUnlink, Type: TypeFile, Inode: inode, Parent: 1, Name: "clf"},
+		{Op: ChangeUnlink, Type: TypeDirectory, Inode: parent, Parent: 1, Name: "cld"},
+	}
+	var events []*ChangeEvent
+	if err := m.WatchChangelog(ctx, 0, func(e *ChangeEvent) bool {
+		events = append(events, e)
+		return len(events) < len(expected)
+	}); err != nil {
+		t.Fatalf("watch changelog: %s", err)
+	}
+	for i, e := range events {
+		if e.Seq == 0 || i > 0 && e.Seq != events[i-1].Seq+1 {
+			t.Fatalf("unexpected sequence of event %d: %+v", i, e)
+		}
+		exp := expected[i]
+		if e.Op != exp.Op || e.Type != exp.Type || e.Inode != exp.Inode || e.Parent != exp.Parent ||
+			e.Name != exp.Name || e.NewParent != exp.NewParent || e.NewName != exp.NewName || e.Replaced != exp.Replaced {
+			t.Fatalf("event %d: expect %+v, got %+v", i, exp, e)
+		}
+	}
+	last := events[len(events)-1].Seq
+	if es, err := m.ReadChangelog(ctx, last-1, 10); err != nil || len(es) != 1 || es[0].Seq != last {
+		t.Fatalf("read changelog after %d: %v %+v", last-1, err, es)
+	}
+	if err := m.getBase().doCleanupChangelog(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("cleanup changelog: %s", err)
+	}
+	if es, err := m.ReadChangelog(ctx, 0, 10); err != nil || len(es) != 0 {
+		t.Fatalf("read changelog after cleanup: %v %+v", err, es)
+	}
+}
+
+func testBlockChecksums(t *testing.T, m Meta) {
+	var sliceId uint64
+	if st := m.NewSlice(Background(), &sliceId); st != 0 {
+		t.Fatalf("new slice: %s", st)
+	}
+	if sums, err := m.LoadChecksums(sliceId); err != nil || sums != nil {
+		t.Fatalf("load checksums of new slice: %v %s", sums, err)
+	}
+	sums := []uint32{1, 2, 0xFFFFFFFF}
+	if err := m.SaveChecksums(sliceId, sums); err != nil {
+		t.Fatalf("save checksums: %s", err)
+	}
+	if got, err := m.LoadChecksums(sliceId); err != nil || !reflect.DeepEqual(got, sums) {
+		t.Fatalf("load checksums: %v %s", got, err)
+	}
+	if err := m.getBase().en.doDeleteSlice(sliceId, 100); err != nil {
+		t.Fatalf("delete slice: %s", err)
+	}
+	if got, err := m.LoadChecksums(sliceId); err != nil || got != nil {
+		t.Fatalf("load checksums of deleted slice: %v %s", got, err)
+	}
+}
+
 func testClone(t *testing.T, m Meta) {
 	// $ tree cloneDir
 	// .
@@ -3104,7 +3638,7 @@ func testClone(t *testing.T, m Meta) {
 		if exists, err := m.db.Exist(removedItem...); err != nil || exists {
 			t.Fatalf("has keys not removed: %v", removedItem)
 		}
-		m.txn(func(s *xorm.Session) error {
+		m.txn(Background(), func(s *xorm.Session) error {
 			return mustInsert(s,
 				&detachedNode{Inode: dNode1, Added: time.Now().Add(-1 * time.Minute).Unix()},
 				&detachedNode{Inode: dNode2, Added: time.Now().Add(-5 * time.Minute).Unix()},
@@ -3379,6 +3913,40 @@ func testQuota(t *testing.T, m Meta) {
 	if st := m.Create(ctx, parent, "f4", 0644, 0, 0, nil, &attr); st != syscall.EDQUOT {
 		t.Fatalf("Create quota/d22/f4: %s", st)
 	}
+
+	// soft limits
+	if st := m.Mkdir(ctx, RootInode, "quota_soft", 0755, 0, 0, &inode, &attr); st != 0 {
+		t.Fatalf("Mkdir quota_soft: %s", st)
+	}
+	p = "/quota_soft"
+	if err := m.HandleQuota(ctx, QuotaSet, p, 0, 0, map[string]*Quota{p: {MaxInodes: 10, SoftInodes: 1, Grace: 1}}, false, false, false); err != nil {
+		t.Fatalf("HandleQuota set %s: %s", p, err)
+	}
+	m.getBase().loadQuotas()
+	for _, name := range []string{"s1", "s2"} {
+		if st := m.Create(ctx, inode, name, 0644, 0, 0, nil, &attr); st != 0 {
+			t.Fatalf("Create quota_soft/%s: %s", name, st)
+		}
+	}
+	m.getBase().doFlushQuotas()
+	m.getBase().loadQuotas() // start the grace period
+	qs = make(map[string]*Quota)
+	if err := m.HandleQuota(ctx, QuotaGet, p, 0, 0, qs, false, false, false); err != nil {
+		t.Fatalf("HandleQuota get %s: %s", p, err)
+	} else if q := qs[p]; q.SoftInodes != 1 || q.Grace != 1 || q.Exceeded == 0 || q.UsedInodes != 2 {
+		t.Fatalf("HandleQuota get %s: %+v", p, q)
+	}
+	if st := m.Create(ctx, inode, "s3", 0644, 0, 0, nil, &attr); st != 0 {
+		t.Fatalf("Create quota_soft/s3 in grace period: %s", st)
+	}
+	time.Sleep(time.Second * 2)
+	if st := m.Create(ctx, inode, "s4", 0644, 0, 0, nil, &attr); st != syscall.EDQUOT {
+		t.Fatalf("Create quota_soft/s4 after grace period: %s", st)
+	}
+	var totalspace, availspace, iused, iavail uint64
+	if st := m.StatFS(ctx, inode, &totalspace, &availspace, &iused, &iavail); st != 0 || iavail != 0 {
+		t.Fatalf("StatFS quota_soft: %s, iavail %d", st, iavail)
+	}
 }
 
 func testAtime(t *testing.T, m Meta) {
@@ -3567,6 +4135,19 @@ func TestQuotaEdgeCases(t *testing.T) {
 	if err := m.checkQuota(operatorCtx, 512*1024, 1, fileOwnerUid, fileOwnerGid); err != 0 {
 		t.Fatalf("checkQuota should pass when within both limits, got: %s", err)
 	}
+
+	t.Log("Testing soft quota limit...")
+	m.userQuotas[uint64(fileOwnerUid)] = &Quota{MaxSpace: 2*1024*1024, SoftSpace: 1024*1024, Grace: 60, Exceeded: time.Now().Unix()}
+	m.groupQuotas[uint64(fileOwnerGid)] = &Quota{}
+
+	if err := m.checkQuota(operatorCtx, 1536*1024, 0, fileOwnerUid, fileOwnerGid); err != 0 {
+		t.Fatalf("checkQuota should pass when exceeding soft limit in grace period, got: %s", err)
+	}
+
+	m.userQuotas[uint64(fileOwnerUid)].Exceeded = time.Now().Unix() - 61
+	if err := m.checkQuota(operatorCtx, 1536*1024, 0, fileOwnerUid, fileOwnerGid); err != syscall.EDQUOT {
+		t.Fatalf("checkQuota should fail with EDQUOT when exceeding soft limit after grace period, got: %s", err)
+	}
 }
 
 // TestCheckQuotaFileOwner
@@ -4680,3 +5261,29 @@ func testHardlinkQuota(t *testing.T, m Meta, ctx Context, parent Ino, uid, gid u
 	m.HandleQuota(ctx, QuotaDel, "", uid, gid, nil, false, false, false)
 	m.HandleQuota(ctx, QuotaDel, parentPath, 0, 0, nil, false, false, false)
 }
+
+func TestSlowOpLog(t *testing.T) {
+	conf := testConfig()
+	conf.SlowOpThreshold = time.Nanosecond
+	conf.SlowOpLog = filepath.Join(t.TempDir(), "slow.log")
+	m, err := newKVMeta("memkv", "jfs-unit-test", conf)
+	if err != nil {
+		t.Fatalf("create meta: %s", err)
+	}
+	if err = m.Init(testFormat(), true); err != nil {
+		t.Fatalf("initialize failed: %s", err)
+	}
+	defer m.Shutdown()
+	var inode Ino
+	var attr Attr
+	if st := m.Mkdir(Background(), RootInode, "d", 0755, 0, 0, &inode, &attr); st != 0 {
+		t.Fatalf("mkdir: %s", st)
+	}
+	data, err := os.ReadFile(conf.SlowOpLog)
+	if err != nil {
+		t.Fatalf("read slow log: %s", err)
+	}
+	require.Contains(t, string(data), "Slow operation Mknod")
+	require.Contains(t, string(data), "Slow transaction doMknod")
+	require.Contains(t, string(data), "tries: 1")
+}
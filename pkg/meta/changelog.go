@@ -0,0 +1,363 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+// Operations recorded in the changelog.
+const (
+	ChangeCreate uint8 = iota + 1
+	ChangeLink
+	ChangeUnlink
+	ChangeRename
+	ChangeSetAttr
+	ChangeWrite
+	ChangeXattr
+)
+
+var changeOpNames = map[uint8]string{
+	ChangeCreate:  "create",
+	ChangeLink:    "link",
+	ChangeUnlink:  "unlink",
+	ChangeRename:  "rename",
+	ChangeSetAttr: "setattr",
+	ChangeWrite:   "write",
+	ChangeXattr:   "xattr",
+}
+
+// ChangelogLatest can be passed to WatchChangelog to skip all existing events.
+const ChangelogLatest = ^uint64(0)
+
+const (
+	changelogBatch        = 1000
+	changelogPollInterval = time.Second
+)
+
+// ChangeEvent is a metadata change recorded in the changelog of a volume.
+type ChangeEvent struct {
+	Seq    uint64 // assigned by the metadata engine when the event is persisted
+	Time   int64  // in nanoseconds
+	Sid    uint64
+	Op     uint8
+	Type   uint8 // type of the inode, 0 if unknown
+	Inode  Ino
+	Parent Ino // 0 if the event is not bound to an entry, e.g. setattr of a hard link
+	Name   string
+	// destination of rename
+	NewParent Ino
+	NewName   string
+	Replaced  Ino // the inode overwritten by rename, 0 if none
+}
+
+func (e *ChangeEvent) OpName() string {
+	if n, ok := changeOpNames[e.Op]; ok {
+		return n
+	}
+	return fmt.Sprintf("op%d", e.Op)
+}
+
+func (e *ChangeEvent) marshal() []byte {
+	w := utils.NewBuffer(uint32(8*7 + 2 + 2*2 + len(e.Name) + len(e.NewName)))
+	w.Put64(e.Seq)
+	w.Put64(uint64(e.Time))
+	w.Put64(e.Sid)
+	w.Put8(e.Op)
+	w.Put8(e.Type)
+	w.Put64(uint64(e.Inode))
+	w.Put64(uint64(e.Parent))
+	w.Put16(uint16(len(e.Name)))
+	w.Put([]byte(e.Name))
+	w.Put64(uint64(e.NewParent))
+	w.Put16(uint16(len(e.NewName)))
+	w.Put([]byte(e.NewName))
+	w.Put64(uint64(e.Replaced))
+	return w.Bytes()
+}
+
+func (e *ChangeEvent) unmarshal(buf []byte) error {
+	if len(buf) < 8*6+2+2*2 {
+		return fmt.Errorf("invalid changelog event: %v", buf)
+	}
+	rb := utils.ReadBuffer(buf)
+	e.Seq = rb.Get64()
+	e.Time = int64(rb.Get64())
+	e.Sid = rb.Get64()
+	e.Op = rb.Get8()
+	e.Type = rb.Get8()
+	e.Inode = Ino(rb.Get64())
+	e.Parent = Ino(rb.Get64())
+	n := int(rb.Get16())
+	if rb.Left() < n+8+2 {
+		return fmt.Errorf("invalid changelog event: %v", buf)
+	}
+	e.Name = string(rb.Get(n))
+	e.NewParent = Ino(rb.Get64())
+	n = int(rb.Get16())
+	if rb.Left() < n {
+		return fmt.Errorf("invalid changelog event: %v", buf)
+	}
+	e.NewName = string(rb.Get(n))
+	if rb.Left() >= 8 { // added later
+		e.Replaced = Ino(rb.Get64())
+	}
+	return nil
+}
+
+func (m *baseMeta) changelogEnabled() bool {
+	return !m.conf.ReadOnly && (m.getFormat().ChangelogDays > 0 || atomic.LoadInt32(&m.subscribed) > 0)
+}
+
+func (m *baseMeta) OnChange(fn func(events []*ChangeEvent)) {
+	m.msgCallbacks.Lock()
+	defer m.msgCallbacks.Unlock()
+	m.changeCbs = append(m.changeCbs, fn)
+	atomic.StoreInt32(&m.subscribed, 1)
+}
+
+// txnChanges carries the event of an operation into its transaction, so the event is persisted
+// atomically with the changes of metadata.
+type txnChanges struct {
+	event   *ChangeEvent
+	fill    func(e *ChangeEvent) // completes the event with the results of the operation, could be nil
+	persist bool                 // false if the event is only published to the local subscribers
+	done    bool                 // persisted by a committed transaction
+	seq     interface {          // the sequence assigned by the script of Redis
+		Uint64() (uint64, error)
+	}
+}
+
+type txnChangesKey struct{}
+
+// withChange returns a context to run the operation of the event with, and the changes to be passed
+// to endChange when it's done. The changes are nil if the changelog is not enabled.
+func (m *baseMeta) withChange(ctx Context, e *ChangeEvent, fill func(e *ChangeEvent)) (Context, *txnChanges) {
+	if !m.changelogEnabled() {
+		return ctx, nil
+	}
+	now := time.Now()
+	m.changelogLock.Lock()
+	if e.Op == ChangeWrite {
+		// a file is usually written by many slices, record it at most once per second
+		if last, ok := m.changelogWrites[e.Inode]; ok && now.Sub(last) < time.Second {
+			m.changelogLock.Unlock()
+			return ctx, nil
+		}
+		m.changelogWrites[e.Inode] = now
+	} else {
+		delete(m.changelogWrites, e.Inode)
+	}
+	m.changelogLock.Unlock()
+	e.Time = now.UnixNano()
+	e.Sid = m.sid
+	c := &txnChanges{event: e, fill: fill, persist: m.getFormat().ChangelogDays > 0}
+	return ctx.WithValue(txnChangesKey{}, c), c
+}
+
+// endChange publishes the event to the local subscribers if the operation succeeded.
+func (m *baseMeta) endChange(c *txnChanges, st syscall.Errno) {
+	if c == nil {
+		return
+	}
+	e := c.event
+	if st != 0 {
+		if e.Op == ChangeWrite {
+			m.changelogLock.Lock()
+			delete(m.changelogWrites, e.Inode)
+			m.changelogLock.Unlock()
+		}
+		return
+	}
+	if atomic.LoadInt32(&m.subscribed) == 0 {
+		return
+	}
+	if c.fill != nil {
+		c.fill(e)
+	}
+	if c.seq != nil {
+		if seq, err := c.seq.Uint64(); err == nil {
+			e.Seq = seq
+		}
+	}
+	m.changelogLock.Lock()
+	m.changelog = append(m.changelog, e)
+	m.changelogLock.Unlock()
+}
+
+// pendingChanges returns the changes to be persisted in the transaction running with ctx, or nil.
+func pendingChanges(ctx context.Context) *txnChanges {
+	c, _ := ctx.Value(txnChangesKey{}).(*txnChanges)
+	if c == nil || !c.persist || c.done {
+		return nil
+	}
+	if c.fill != nil {
+		c.fill(c.event)
+	}
+	return c
+}
+
+// committedChanges marks the changes of ctx as persisted, after the transaction is committed.
+func committedChanges(ctx context.Context) {
+	if c, _ := ctx.Value(txnChangesKey{}).(*txnChanges); c != nil && c.persist {
+		c.done = true
+	}
+}
+
+// changedInode records the inode found by the transaction, e.g. the one unlinked by name.
+func changedInode(ctx context.Context, inode Ino, typ uint8) {
+	if c, _ := ctx.Value(txnChangesKey{}).(*txnChanges); c != nil {
+		c.event.Inode, c.event.Type = inode, typ
+	}
+}
+
+func (m *baseMeta) flushChangelog(ctx Context) {
+	defer m.sessWG.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.doFlushChangelog()
+		}
+	}
+}
+
+// doFlushChangelog publishes the buffered events to the local subscribers, they are persisted already.
+func (m *baseMeta) doFlushChangelog() {
+	m.changelogLock.Lock()
+	now := time.Now()
+	for ino, last := range m.changelogWrites {
+		if now.Sub(last) >= time.Second {
+			delete(m.changelogWrites, ino)
+		}
+	}
+	if len(m.changelog) == 0 {
+		m.changelogLock.Unlock()
+		return
+	}
+	events := m.changelog
+	m.changelog = nil
+	m.changelogLock.Unlock()
+	m.msgCallbacks.Lock()
+	cbs := m.changeCbs
+	m.msgCallbacks.Unlock()
+	for _, cb := range cbs {
+		cb(events)
+	}
+}
+
+func (m *baseMeta) cleanupChangelog(ctx Context) {
+	defer m.sessWG.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(utils.JitterIt(time.Hour)):
+		}
+		days := m.getFormat().ChangelogDays
+		if days <= 0 {
+			continue
+		}
+		if ok, err := m.en.setIfSmall("lastCleanupChangelog", time.Now().Unix(), int64(time.Hour.Seconds())*9/10); err != nil {
+			logger.Warnf("checking counter lastCleanupChangelog: %s", err)
+		} else if ok {
+			if err = m.doCleanupChangelog(ctx, time.Now().Add(-time.Duration(days)*24*time.Hour)); err != nil {
+				logger.Warnf("cleanup changelog: %s", err)
+			}
+		}
+	}
+}
+
+// doCleanupChangelog removes events that happened before edge.
+func (m *baseMeta) doCleanupChangelog(ctx Context, edge time.Time) error {
+	var upto uint64
+	for !ctx.Canceled() {
+		events, err := m.en.doReadChangelog(ctx, upto, changelogBatch)
+		if err != nil {
+			return err
+		}
+		var done bool
+		for _, e := range events {
+			if e.Time >= edge.UnixNano() {
+				done = true
+				break
+			}
+			upto = e.Seq
+		}
+		if done || len(events) < changelogBatch {
+			break
+		}
+	}
+	if upto == 0 {
+		return nil
+	}
+	logger.Debugf("cleanup changelog events up to %d", upto)
+	return m.en.doDeleteChangelog(ctx, upto)
+}
+
+func (m *baseMeta) ReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error) {
+	if limit <= 0 {
+		limit = changelogBatch
+	}
+	return m.en.doReadChangelog(ctx, after, limit)
+}
+
+func (m *baseMeta) WatchChangelog(ctx Context, after uint64, fn func(e *ChangeEvent) bool) error {
+	if m.getFormat().ChangelogDays <= 0 {
+		return fmt.Errorf("changelog is not enabled, please enable it with `juicefs config --changelog-days`")
+	}
+	if after == ChangelogLatest {
+		v, err := m.en.getCounter("nextChangelog")
+		if err != nil {
+			return fmt.Errorf("get counter nextChangelog: %s", err)
+		}
+		after = uint64(v)
+	}
+	for {
+		events, err := m.en.doReadChangelog(ctx, after, changelogBatch)
+		if err != nil {
+			logger.Warnf("read changelog after %d: %s", after, err)
+		}
+		for _, e := range events {
+			if after > 0 && e.Seq > after+1 {
+				// the consumer is too slow and old events were cleaned up
+				logger.Warnf("changelog events %d-%d are lost", after+1, e.Seq-1)
+			}
+			if !fn(e) {
+				return nil
+			}
+			after = e.Seq
+		}
+		if len(events) == changelogBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(changelogPollInterval):
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package meta
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
@@ -25,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/juicedata/juicefs/pkg/version"
@@ -51,11 +53,48 @@ type Config struct {
 	Sid                uint64
 	SortDir            bool
 	FastStatfs         bool
-	NetworkInterfaces  []string // list of network interfaces to use for IP discovery (empty means all)
+	NetworkInterfaces  []string      // list of network interfaces to use for IP discovery (empty means all)
+	SlowOpThreshold    time.Duration // log operations and transactions slower than this (0 means disabled)
+	SlowOpLog          string        // file to write slow operations to (empty means the client log)
+	CacheGroup         string        // name of the cache group to advertise in the session
+	CacheAddr          string        // address serving the cached blocks to the cache group
+	AsyncDeleteRate    int           // max number of entries deleted per second in background by rmr --async (0 means unlimited)
+	CompactWindow      string        // time of day to compact chunks in background, e.g. "0:00-6:00" (empty means any time)
+	MaxCompactions     int           // max number of chunks compacted concurrently in background
+	CompactLimit       int64         // bandwidth limit for compaction in Mbps (0 means unlimited)
 }
 
 func DefaultConf() *Config {
-	return &Config{Strict: true, Retries: 10, MaxDeletes: 2, Heartbeat: 12 * time.Second, AtimeMode: NoAtime, DirStatFlushPeriod: 1 * time.Second}
+	return &Config{Strict: true, Retries: 10, MaxDeletes: 2, Heartbeat: 12 * time.Second, AtimeMode: NoAtime, DirStatFlushPeriod: 1 * time.Second, MaxCompactions: 10}
+}
+
+// parseTimeWindow parses a time window of the day like "0:00-6:00", it can go across midnight like "22:00-6:00".
+func parseTimeWindow(s string) (start, end time.Duration, err error) {
+	ps := strings.Split(s, "-")
+	if len(ps) != 2 {
+		return 0, 0, fmt.Errorf("invalid time window %q, should be like 0:00-6:00", s)
+	}
+	var t [2]time.Duration
+	for i, p := range ps {
+		var h, m int
+		if n, err := fmt.Sscanf(strings.TrimSpace(p), "%d:%d", &h, &m); err != nil || n != 2 || h < 0 || h > 24 || m < 0 || m > 59 || h == 24 && m > 0 {
+			return 0, 0, fmt.Errorf("invalid time %q in window %q", p, s)
+		}
+		t[i] = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	}
+	if t[0] == t[1] {
+		return 0, 0, fmt.Errorf("empty time window %q", s)
+	}
+	return t[0], t[1], nil
+}
+
+// inTimeWindow checks whether the time of day is in the window [start, end).
+func inTimeWindow(t time.Time, start, end time.Duration) bool {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if start < end {
+		return d >= start && d < end
+	}
+	return d >= start || d < end
 }
 
 func (c *Config) SelfCheck() {
@@ -70,6 +109,15 @@ func (c *Config) SelfCheck() {
 		logger.Warnf("heartbeat should not be greater than 10 minutes")
 		c.Heartbeat = time.Minute * 10
 	}
+	if c.CompactWindow != "" {
+		if _, _, err := parseTimeWindow(c.CompactWindow); err != nil {
+			logger.Warnf("%s, compaction will not be limited by time", err)
+			c.CompactWindow = ""
+		}
+	}
+	if c.MaxCompactions <= 0 {
+		c.MaxCompactions = 10
+	}
 }
 
 type Format struct {
@@ -82,17 +130,22 @@ type Format struct {
 	SecretKey        string `json:",omitempty"`
 	SessionToken     string `json:",omitempty"`
 	BlockSize        int
-	Compression      string `json:",omitempty"`
-	Shards           int    `json:",omitempty"`
-	HashPrefix       bool   `json:",omitempty"`
-	Capacity         uint64 `json:",omitempty"`
-	Inodes           uint64 `json:",omitempty"`
-	EncryptKey       string `json:",omitempty"`
-	EncryptAlgo      string `json:",omitempty"`
-	KeyEncrypted     bool   `json:",omitempty"`
-	UploadLimit      int64  `json:",omitempty"` // Mbps
-	DownloadLimit    int64  `json:",omitempty"` // Mbps
+	Compression      string   `json:",omitempty"`
+	Shards           int      `json:",omitempty"`
+	DataShards       int      `json:",omitempty"`
+	ParityShards     int      `json:",omitempty"`
+	HashPrefix       bool     `json:",omitempty"`
+	Capacity         uint64   `json:",omitempty"`
+	Inodes           uint64   `json:",omitempty"`
+	EncryptKey       string   `json:",omitempty"`
+	EncryptAlgo      string   `json:",omitempty"`
+	EncryptKMS       string   `json:",omitempty"`
+	EncryptDataKeys  [][]byte `json:",omitempty"` // data keys wrapped by the KMS, the last one is used for new objects
+	KeyEncrypted     bool     `json:",omitempty"`
+	UploadLimit      int64    `json:",omitempty"` // Mbps
+	DownloadLimit    int64    `json:",omitempty"` // Mbps
 	TrashDays        int
+	TrashUserQuota   uint64 `json:",omitempty"` // max size of the removed files of a user in trash
 	MetaVersion      int    `json:",omitempty"`
 	MinClientVersion string `json:",omitempty"`
 	MaxClientVersion string `json:",omitempty"`
@@ -101,6 +154,9 @@ type Format struct {
 	EnableACL        bool
 	RangerRestUrl    string `json:",omitempty"`
 	RangerService    string `json:",omitempty"`
+	ChangelogDays    int    `json:",omitempty"`
+	BlockChecksum    bool   `json:",omitempty"`
+	CompressDict     []byte `json:",omitempty"`
 }
 
 func (f *Format) update(old *Format, force bool) error {
@@ -115,8 +171,14 @@ func (f *Format) update(old *Format, force bool) error {
 			args = []interface{}{"block size", old.BlockSize, f.BlockSize}
 		case f.Compression != old.Compression:
 			args = []interface{}{"compression", old.Compression, f.Compression}
+		case !bytes.Equal(f.CompressDict, old.CompressDict):
+			args = []interface{}{"compression dictionary", fmt.Sprintf("%d bytes", len(old.CompressDict)), fmt.Sprintf("%d bytes", len(f.CompressDict))}
 		case f.Shards != old.Shards:
 			args = []interface{}{"shards", old.Shards, f.Shards}
+		case f.DataShards != old.DataShards:
+			args = []interface{}{"data shards", old.DataShards, f.DataShards}
+		case f.ParityShards != old.ParityShards:
+			args = []interface{}{"parity shards", old.ParityShards, f.ParityShards}
 		case f.HashPrefix != old.HashPrefix:
 			args = []interface{}{"hash prefix", old.HashPrefix, f.HashPrefix}
 		case f.MetaVersion != old.MetaVersion:
@@ -19,6 +19,7 @@ package meta
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestRemoveSecret(t *testing.T) {
@@ -52,3 +53,32 @@ func TestEncrypt(t *testing.T) {
 		t.Fatalf("invalid format: %+v", format)
 	}
 }
+
+func TestTimeWindow(t *testing.T) {
+	for _, c := range []struct {
+		window string
+		hour   int
+		in     bool
+	}{
+		{"0:00-6:00", 0, true},
+		{"0:00-6:00", 5, true},
+		{"0:00-6:00", 6, false},
+		{"22:00-6:00", 23, true},
+		{"22:00-6:00", 3, true},
+		{"22:00-6:00", 12, false},
+		{"1:30-24:00", 23, true},
+	} {
+		start, end, err := parseTimeWindow(c.window)
+		if err != nil {
+			t.Fatalf("parse %s: %s", c.window, err)
+		}
+		if in := inTimeWindow(time.Date(2024, 1, 1, c.hour, 0, 0, 0, time.Local), start, end); in != c.in {
+			t.Fatalf("%d:00 in %s should be %v", c.hour, c.window, c.in)
+		}
+	}
+	for _, w := range []string{"", "6:00", "0:00-25:00", "1:60-2:00", "3:00-3:00", "a-b"} {
+		if _, _, err := parseTimeWindow(w); err == nil {
+			t.Fatalf("parse %q should fail", w)
+		}
+	}
+}
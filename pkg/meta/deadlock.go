@@ -0,0 +1,206 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const deadlockCheckInterval = time.Second
+
+// lockWait is a blocking request of flock or POSIX lock, the ones waiting for more than deadlockCheckInterval
+// are published in the metadata engine, so the cycles across clients could be found.
+type lockWait struct {
+	Sid        uint64 `json:"-"`
+	Inode      Ino
+	Owner      uint64
+	Flock      bool   `json:",omitempty"`
+	Type       uint32 `json:"Ltype"`
+	Start, End uint64 `json:",omitempty"`
+	Pid        uint32 `json:",omitempty"`
+	checked    time.Time
+	published  bool // protected by lockWaitsMu
+}
+
+func (w *lockWait) String() string {
+	if w.Flock {
+		return fmt.Sprintf("owner %d of session %d waits for flock %s of inode %d", w.Owner, w.Sid, lockType(w.Type), w.Inode)
+	}
+	return fmt.Sprintf("owner %d (pid %d) of session %d waits for plock %s [%d,%d] of inode %d", w.Owner, w.Pid, w.Sid,
+		lockType(w.Type), w.Start, w.End, w.Inode)
+}
+
+func lockType(ltype uint32) string {
+	if ltype == F_RDLCK {
+		return "R"
+	}
+	return "W"
+}
+
+type lockLister func(ctx context.Context, inode Ino) ([]PLockItem, []FLockItem, error)
+
+// waiter is the owner of lock requests in a session.
+type waiter struct {
+	sid, owner uint64
+}
+
+// waitLock is called when the blocking request w has to wait, it returns EDEADLK if w closes a cycle of the
+// waiting requests of all the clients, which would never be granted.
+func (m *baseMeta) waitLock(ctx Context, w *lockWait, list lockLister) syscall.Errno {
+	now := time.Now()
+	m.lockWaitsMu.Lock()
+	w.Sid = m.sid
+	m.lockWaits[w] = struct{}{}
+	m.lockWaitsMu.Unlock()
+	if now.Sub(w.checked) < deadlockCheckInterval {
+		return 0
+	}
+	first := w.checked.IsZero()
+	w.checked = now
+	m.lockWaitsMu.Lock()
+	publish := !first && !w.published
+	w.published = w.published || publish
+	m.lockWaitsMu.Unlock()
+	if publish {
+		m.publishLockWaits(ctx)
+	}
+	if cycle := m.findDeadlock(ctx, w, list); cycle != nil {
+		var desc []string
+		for _, c := range cycle {
+			desc = append(desc, c.String())
+		}
+		logger.Warnf("Deadlock of locks, fail the last request: %s", strings.Join(desc, ", "))
+		return syscall.EDEADLK
+	}
+	return 0
+}
+
+func (m *baseMeta) doneLock(w *lockWait) {
+	m.lockWaitsMu.Lock()
+	delete(m.lockWaits, w)
+	published := w.published
+	m.lockWaitsMu.Unlock()
+	if published {
+		m.publishLockWaits(Background())
+	}
+}
+
+// publishLockWaits saves the published requests of this client into the metadata engine.
+func (m *baseMeta) publishLockWaits(ctx Context) {
+	m.lockPublishMu.Lock()
+	defer m.lockPublishMu.Unlock()
+	var waits []*lockWait
+	m.lockWaitsMu.Lock()
+	for w := range m.lockWaits {
+		if w.published {
+			waits = append(waits, w)
+		}
+	}
+	m.lockWaitsMu.Unlock()
+	var data []byte
+	if len(waits) > 0 {
+		data, _ = json.Marshal(waits)
+	}
+	if err := m.en.doSetLockWaits(ctx, m.sid, data); err != nil {
+		logger.Warnf("Publish %d blocking lock requests: %s", len(waits), err)
+	}
+}
+
+// lockHolders returns the owners holding the locks conflicted with w.
+func (m *baseMeta) lockHolders(ctx context.Context, w *lockWait, list lockLister) []waiter {
+	plocks, flocks, err := list(ctx, w.Inode)
+	if err != nil {
+		logger.Warnf("List locks of inode %d: %s", w.Inode, err)
+		return nil
+	}
+	var owners []waiter
+	if w.Flock {
+		for _, l := range flocks {
+			if (l.Sid != w.Sid || l.Owner != w.Owner) && (w.Type == F_WRLCK || l.Type == "W") {
+				owners = append(owners, waiter{l.Sid, l.Owner})
+			}
+		}
+	} else {
+		for _, l := range plocks {
+			if (l.Sid != w.Sid || l.Owner != w.Owner) && (w.Type == F_WRLCK || l.Type == F_WRLCK) && w.End >= l.Start && w.Start <= l.End {
+				owners = append(owners, waiter{l.Sid, l.Owner})
+			}
+		}
+	}
+	return owners
+}
+
+// findDeadlock returns the waiting requests from w back to the owner of w, if any.
+func (m *baseMeta) findDeadlock(ctx Context, w *lockWait, list lockLister) []*lockWait {
+	waits := make(map[waiter][]*lockWait)
+	m.lockWaitsMu.Lock()
+	for o := range m.lockWaits {
+		waits[waiter{o.Sid, o.Owner}] = append(waits[waiter{o.Sid, o.Owner}], o)
+	}
+	m.lockWaitsMu.Unlock()
+	others, err := m.en.doListLockWaits(ctx)
+	if err != nil {
+		logger.Warnf("List blocking lock requests of other clients: %s", err)
+	}
+	for sid, data := range others {
+		if sid == m.sid {
+			continue
+		}
+		var ws []*lockWait
+		if err := json.Unmarshal(data, &ws); err != nil {
+			logger.Warnf("Invalid blocking lock requests of session %d: %s", sid, err)
+			continue
+		}
+		for _, o := range ws {
+			o.Sid = sid
+			waits[waiter{sid, o.Owner}] = append(waits[waiter{sid, o.Owner}], o)
+		}
+	}
+
+	self := waiter{w.Sid, w.Owner}
+	path := []*lockWait{w}
+	visited := map[*lockWait]bool{w: true}
+	var walk func(cur *lockWait) bool
+	walk = func(cur *lockWait) bool {
+		for _, o := range m.lockHolders(ctx, cur, list) {
+			if o == self {
+				return true
+			}
+			for _, next := range waits[o] {
+				if visited[next] {
+					continue
+				}
+				visited[next] = true
+				path = append(path, next)
+				if walk(next) {
+					return true
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		return false
+	}
+	if walk(w) {
+		return path
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"bytes"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juju/ratelimit"
+)
+
+// deletingPathXattr keeps the original path of a directory being deleted in background.
+const deletingPathXattr = "juicefs.deleting.path"
+
+// deletingOwnerXattr keeps the session that is deleting the directory, so the clients don't conflict.
+const deletingOwnerXattr = "juicefs.deleting.owner"
+
+// Deletion is a directory being deleted in background.
+type Deletion struct {
+	Inode     Ino
+	Path      string    // the original path
+	Removed   time.Time // when it's removed from the namespace
+	Remaining *Summary  `json:",omitempty"` // the entries left in the directory
+}
+
+func (m *baseMeta) RemoveAsync(ctx Context, parent Ino, name string, inode *Ino) (st syscall.Errno) {
+	ctx, span := m.startSpan(ctx, "removeAsync", parent)
+	defer func() { utils.EndSpan(span, st) }()
+	if parent == RootInode && name == TrashName || parent.IsTrash() {
+		return syscall.EPERM
+	}
+	if name == "." || name == ".." {
+		return syscall.EINVAL
+	}
+	if ctx.Uid() != 0 {
+		return syscall.EPERM // the entries are deleted without checking their permissions
+	}
+	if m.conf.ReadOnly {
+		return syscall.EROFS
+	}
+	defer m.timeit("RemoveAsync", time.Now())
+	parent = m.checkRoot(parent)
+	var dirPath string
+	if ps := m.GetPaths(ctx, parent); len(ps) > 0 {
+		dirPath = ps[0]
+	}
+	var attr Attr
+	cctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeUnlink, Type: TypeDirectory, Parent: parent, Name: name}, func(e *ChangeEvent) {
+		e.Inode = *inode
+	})
+	st = m.en.doDetachDir(cctx, parent, name, inode, &attr)
+	m.endChange(changes, st)
+	if st != 0 {
+		return st
+	}
+	m.parentMu.Lock()
+	delete(m.dirParents, *inode)
+	m.parentMu.Unlock()
+	m.updateDirStat(ctx, parent, 0, -align4K(0), -1)
+	m.updateUserGroupQuota(ctx, attr.Uid, attr.Gid, -align4K(0), -1)
+	// the entries in it are released from the quotas of the parents when they are deleted
+	if dirPath != "" {
+		if st := m.en.doSetXattr(ctx, *inode, deletingPathXattr, []byte(path.Join(dirPath, name)), 0); st != 0 {
+			logger.Warnf("Set path of the detached directory %d: %s", *inode, st)
+		}
+	}
+	select {
+	case m.detachedDirs <- struct{}{}:
+	default:
+	}
+	return 0
+}
+
+// findDeletingDirs returns the detached directories to be deleted in background, the other detached nodes
+// are the ones being cloned.
+func (m *baseMeta) findDeletingDirs(ctx Context) map[Ino]*Attr {
+	dirs := make(map[Ino]*Attr)
+	for _, inode := range m.en.doFindDetachedNodes(time.Now().Add(time.Second)) {
+		attr := &Attr{}
+		if st := m.en.doGetAttr(ctx, inode, attr); st == 0 && attr.Flags&FlagDeleting != 0 {
+			dirs[inode] = attr
+		}
+	}
+	return dirs
+}
+
+func (m *baseMeta) ListDeletions(ctx Context, summary bool) ([]*Deletion, syscall.Errno) {
+	var result []*Deletion
+	for inode, attr := range m.findDeletingDirs(ctx) {
+		d := &Deletion{Inode: inode, Removed: time.Unix(attr.Ctime, int64(attr.Ctimensec))}
+		var value []byte
+		if st := m.en.(Meta).GetXattr(ctx, inode, deletingPathXattr, &value); st == 0 {
+			d.Path = string(value)
+		}
+		if summary {
+			d.Remaining = &Summary{}
+			if st := m.GetSummary(ctx, inode, d.Remaining, true, false); st != 0 && st != syscall.ENOENT {
+				return nil, st
+			}
+		}
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Removed.Before(result[j].Removed) })
+	return result, 0
+}
+
+// claimDeletingDir returns true if the directory is claimed by the current session, it's taken over
+// only if the session that claimed it is gone.
+func (m *baseMeta) claimDeletingDir(ctx Context, inode Ino, alive map[uint64]bool) bool {
+	owner := []byte(strconv.FormatUint(m.sid, 10))
+	st := m.en.doSetXattr(ctx, inode, deletingOwnerXattr, owner, XattrCreate)
+	if st != syscall.EEXIST {
+		return st == 0
+	}
+	var value []byte
+	if st = m.en.(Meta).GetXattr(ctx, inode, deletingOwnerXattr, &value); st != 0 {
+		return false
+	}
+	if bytes.Equal(value, owner) {
+		return true
+	}
+	if sid, err := strconv.ParseUint(string(value), 10, 64); err == nil && alive[sid] {
+		return false
+	}
+	logger.Infof("Take over the deletion of detached directory %d from session %s", inode, value)
+	if st = m.en.doRemoveXattr(ctx, inode, deletingOwnerXattr); st != 0 && st != ENOATTR {
+		return false
+	}
+	return m.en.doSetXattr(ctx, inode, deletingOwnerXattr, owner, XattrCreate) == 0
+}
+
+// ownsDeletingDir checks that the directory is still claimed by the current session, in case that it's
+// taken over by another client at the same time.
+func (m *baseMeta) ownsDeletingDir(ctx Context, inode Ino) bool {
+	var value []byte
+	return m.en.(Meta).GetXattr(ctx, inode, deletingOwnerXattr, &value) == 0 && string(value) == strconv.FormatUint(m.sid, 10)
+}
+
+// cleanupDetachedDirs deletes the detached directories by all the clients, each directory is claimed by
+// one client at a time, and each client deletes at most AsyncDeleteRate entries per second.
+func (m *baseMeta) cleanupDetachedDirs(ctx Context) {
+	defer m.sessWG.Done()
+	var limiter *ratelimit.Bucket
+	if m.conf.AsyncDeleteRate > 0 {
+		limiter = ratelimit.NewBucketWithRate(float64(m.conf.AsyncDeleteRate), int64(m.conf.AsyncDeleteRate))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.detachedDirs:
+		case <-time.After(utils.JitterIt(time.Minute)):
+		}
+		dirs := m.findDeletingDirs(ctx)
+		if len(dirs) == 0 {
+			continue
+		}
+		sessions, err := m.en.ListSessions()
+		if err != nil {
+			logger.Warnf("List sessions: %s", err)
+			continue
+		}
+		alive := make(map[uint64]bool, len(sessions))
+		for _, s := range sessions {
+			alive[s.Sid] = true
+		}
+		inodes := make([]Ino, 0, len(dirs))
+		for inode := range dirs {
+			inodes = append(inodes, inode)
+		}
+		// spread the clients over the directories
+		rand.Shuffle(len(inodes), func(i, j int) { inodes[i], inodes[j] = inodes[j], inodes[i] })
+		for _, inode := range inodes {
+			if ctx.Canceled() {
+				return
+			}
+			if !m.claimDeletingDir(ctx, inode, alive) {
+				continue
+			}
+			start := time.Now()
+			var count uint64
+			st := m.emptyDetachedDir(ctx, inode, inode, limiter, &count)
+			if st == 0 {
+				st = m.en.doCleanupDetachedNode(ctx, inode)
+			}
+			if st == syscall.EBUSY {
+				logger.Infof("Detached directory %d is taken over by another client", inode)
+			} else if st == syscall.EAGAIN {
+				logger.Infof("Detached directory %d is in conflict with other clients, try again later", inode)
+			} else if st != 0 && st != syscall.EINTR {
+				logger.Warnf("Delete detached directory %d: %s", inode, st)
+			} else if st == 0 {
+				logger.Infof("Deleted detached directory %d with %d entries in %s", inode, count, time.Since(start))
+			}
+		}
+	}
+}
+
+// emptyDetachedDir deletes all the entries in the directory recursively and permanently, at the rate
+// of the limiter. It returns EBUSY if the detached directory top is not claimed by the current session anymore,
+// or EAGAIN if the entries keep conflicting with other clients, so it's given back and tried again later.
+func (m *baseMeta) emptyDetachedDir(ctx Context, top, inode Ino, limiter *ratelimit.Bucket, count *uint64) syscall.Errno {
+	var retries int
+	for {
+		if !m.ownsDeletingDir(ctx, top) {
+			return syscall.EBUSY
+		}
+		var entries []*Entry
+		if st := m.en.doReaddir(ctx, inode, 0, &entries, 1000); st != 0 {
+			if st == syscall.ENOENT {
+				return 0 // deleted by another client
+			}
+			return st
+		}
+		if len(entries) == 0 {
+			return 0
+		}
+		var deleted int
+		for _, e := range entries {
+			if ctx.Canceled() {
+				return syscall.EINTR
+			}
+			var st syscall.Errno
+			if e.Attr.Typ == TypeDirectory {
+				if st = m.emptyDetachedDir(ctx, top, e.Inode, limiter, count); st != 0 {
+					return st
+				}
+			}
+			if limiter != nil {
+				limiter.Wait(1)
+			}
+			if e.Attr.Typ == TypeDirectory {
+				st = m.Rmdir(ctx, inode, string(e.Name), true)
+			} else {
+				st = m.Unlink(ctx, inode, string(e.Name), true)
+			}
+			switch st {
+			case 0:
+				*count++
+				deleted++
+			case syscall.ENOENT, syscall.ENOTEMPTY: // in conflict with another client, try again later
+			default:
+				return st
+			}
+		}
+		if deleted > 0 {
+			retries = 0
+			continue
+		}
+		if retries++; retries > 3 {
+			return syscall.EAGAIN
+		}
+		select {
+		case <-ctx.Done():
+			return syscall.EINTR
+		case <-time.After(time.Second * time.Duration(retries)):
+		}
+	}
+}
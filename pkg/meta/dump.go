@@ -99,6 +99,9 @@ type DumpedQuota struct {
 	MaxInodes  int64 `json:"maxInodes"`
 	UsedSpace  int64 `json:"-"`
 	UsedInodes int64 `json:"-"`
+	SoftSpace  int64 `json:"softSpace,omitempty"`
+	SoftInodes int64 `json:"softInodes,omitempty"`
+	Grace      int64 `json:"grace,omitempty"`
 }
 
 type DumpedACLEntry struct {
@@ -359,7 +362,15 @@ func (dm *DumpedMeta) writeJsonWithOutTree(w io.Writer) (*bufio.Writer, error) {
 func (m *baseMeta) loadDumpedQuotas(ctx Context, quotas map[Ino]*DumpedQuota) {
 	// update quota
 	for inode, q := range quotas {
-		if _, err := m.en.doSetQuota(ctx, DirQuotaType, uint64(inode), &Quota{q.MaxSpace, q.MaxInodes, q.UsedSpace, q.UsedInodes, 0, 0}); err != nil {
+		if _, err := m.en.doSetQuota(ctx, DirQuotaType, uint64(inode), &Quota{
+			MaxSpace:   q.MaxSpace,
+			MaxInodes:  q.MaxInodes,
+			UsedSpace:  q.UsedSpace,
+			UsedInodes: q.UsedInodes,
+			SoftSpace:  q.SoftSpace,
+			SoftInodes: q.SoftInodes,
+			Grace:      q.Grace,
+		}); err != nil {
 			logger.Warnf("reset quota of %d: %s", inode, err)
 			continue
 		}
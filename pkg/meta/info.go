@@ -104,3 +104,13 @@ func checkRedisInfo(rawInfo string) (info redisInfo, err error) {
 	}
 	return
 }
+
+// redisInfoField returns the value of the field in the output of the INFO command.
+func redisInfoField(rawInfo, key string) string {
+	for _, l := range strings.Split(rawInfo, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(l), ":"); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
@@ -57,6 +57,8 @@ const (
 	OpSummary = 1007
 	// CompactPath is a message to trigger compact
 	CompactPath = 1008
+	// CompactDryRun is a message to check the fragmentation of chunks without compaction
+	CompactDryRun = 1009
 )
 
 const (
@@ -100,6 +102,7 @@ const (
 	FlagWindowsSystem
 	FlagWindowsArchive
 	FlagSkipTrash // skip moving to .trash - Mapped to 's' in chattr
+	FlagDeleting  // the detached directory is being deleted in background
 )
 
 const (
@@ -138,6 +141,9 @@ func (i Ino) IsNormal() bool {
 
 var TrashName = ".trash"
 
+// LostFoundName is the directory under root to reattach the orphan files by fsck.
+const LostFoundName = "lost+found"
+
 type internalNode struct {
 	inode Ino
 	name  string
@@ -318,6 +324,12 @@ type Slice struct {
 	Len  uint32
 }
 
+// Extent is a range of a chunk backed by part of a slice.
+type Extent struct {
+	Pos uint32 // offset in the chunk
+	Slice
+}
+
 // Summary represents the total number of files/directories and
 // total length of all files inside a directory.
 type Summary struct {
@@ -327,6 +339,16 @@ type Summary struct {
 	Dirs   uint64
 }
 
+// Fragments is the fragmentation of the chunks under a path.
+type Fragments struct {
+	Files            uint64
+	Chunks           uint64
+	Slices           uint64
+	FragmentedChunks uint64 // chunks which can be compacted
+	FragmentedSlices uint64 // slices to be compacted in the fragmented chunks
+	CompactBytes     uint64 // bytes to be rewritten by compaction
+}
+
 type TreeSummary struct {
 	Inode    Ino
 	Path     string
@@ -344,6 +366,8 @@ type SessionInfo struct {
 	MountPoint string
 	MountTime  time.Time
 	ProcessID  int
+	CacheGroup string `json:",omitempty"`
+	CacheAddr  string `json:",omitempty"` // address to fetch the cached blocks from
 }
 
 type Flock struct {
@@ -396,8 +420,15 @@ type Meta interface {
 	ListLocks(ctx context.Context, inode Ino) ([]PLockItem, []FLockItem, error)
 	// CleanStaleSessions cleans up sessions not active for more than 5 minutes
 	CleanStaleSessions(ctx Context)
+	// KillSession forcibly cleans up a session, releasing its locks and sustained inodes.
+	// A session that is still alive is refused unless force is true.
+	KillSession(sid uint64, force bool) error
 	// CleanupTrashBefore deletes all files in trash before the given time.
 	CleanupTrashBefore(ctx Context, edge time.Time, increProgress func(int))
+	// ListTrash returns all entries in trash with their original paths, ordered by the time they are removed.
+	ListTrash(ctx Context) ([]*TrashEntry, syscall.Errno)
+	// RestoreTrash moves an entry in trash back to its original location, the removed parents are restored first.
+	RestoreTrash(ctx Context, e *TrashEntry) syscall.Errno
 	// CleanupDetachedNodesBefore deletes all detached nodes before the given time.
 	CleanupDetachedNodesBefore(ctx Context, edge time.Time, increProgress func())
 
@@ -456,6 +487,12 @@ type Meta interface {
 	NewSlice(ctx Context, id *uint64) syscall.Errno
 	// Write put a slice of data on top of the given chunk.
 	Write(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time) syscall.Errno
+	// WriteExtents put extents of the same slice on top of the given chunk in order, the slice is referenced by all of them.
+	WriteExtents(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time) syscall.Errno
+	// SaveChecksums stores the checksums of the blocks in a new slice.
+	SaveChecksums(id uint64, sums []uint32) error
+	// LoadChecksums returns the checksums of the blocks in a slice, or nil if they are not stored.
+	LoadChecksums(id uint64) ([]uint32, error)
 	// InvalidateChunkCache invalidate chunk cache
 	InvalidateChunkCache(ctx Context, inode Ino, indx uint32) syscall.Errno
 	// CopyFileRange copies part of a file to another one.
@@ -484,12 +521,19 @@ type Meta interface {
 	CompactAll(ctx Context, threads int, bar *utils.Bar) syscall.Errno
 	// Compact chunks for specified path
 	Compact(ctx Context, inode Ino, concurrency int, preFunc, postFunc func()) syscall.Errno
+	// CheckFragments counts the chunks of the files under the path which can be compacted, without compacting them
+	CheckFragments(ctx Context, inode Ino, concurrency int, frag *Fragments, progress func()) syscall.Errno
 
 	// ListSlices returns all slices used by all files.
 	ListSlices(ctx Context, slices map[Ino][]Slice, scanPending, delete bool, showProgress func()) syscall.Errno
 	// Remove all files and directories recursively.
 	// count represents the number of attempted deletions of entries (even if failed).
 	Remove(ctx Context, parent Ino, name string, skipTrash bool, numThreads int, count *uint64) syscall.Errno
+	// RemoveAsync removes a directory from the namespace at once, and deletes it with all the entries
+	// in background (skipping trash) by the clients.
+	RemoveAsync(ctx Context, parent Ino, name string, inode *Ino) syscall.Errno
+	// ListDeletions returns the directories being deleted in background, with the remaining entries if summary is true.
+	ListDeletions(ctx Context, summary bool) ([]*Deletion, syscall.Errno)
 	// Get summary of a node; for a directory it will accumulate all its child nodes
 	GetSummary(ctx Context, inode Ino, summary *Summary, recursive bool, strict bool) syscall.Errno
 	// GetTreeSummary returns a summary in tree structure
@@ -500,6 +544,8 @@ type Meta interface {
 	GetPaths(ctx Context, inode Ino) []string
 	// Check integrity of an absolute path and repair it if asked
 	Check(ctx Context, fpath string, repair bool, recursive bool, statAll bool) error
+	// Reattach links an orphan file into /lost+found, and returns the new path
+	Reattach(ctx Context, inode Ino, attr *Attr) (string, syscall.Errno)
 	// Change root to a directory specified by subdir
 	Chroot(ctx Context, subdir string) syscall.Errno
 	// chroot set the root directory by inode
@@ -511,11 +557,18 @@ type Meta interface {
 	OnMsg(mtype uint32, cb MsgCallback)
 	// OnReload register a callback for any change founded after reloaded.
 	OnReload(func(new *Format))
+	// OnChange register a callback for batches of metadata changes made by this client.
+	OnChange(func(events []*ChangeEvent))
 
 	HandleQuota(ctx Context, cmd uint8, dpath string, uid uint32, gid uint32, quotas map[string]*Quota, strict, repair bool, create bool) error
 	//Triggers a global user group quota scan
 	ScanUserGroupUsage(ctx Context) error
 
+	// ReadChangelog returns at most limit events with sequence number larger than after.
+	ReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error)
+	// WatchChangelog calls fn for events after the given sequence number in order, until ctx is canceled or fn returns false.
+	WatchChangelog(ctx Context, after uint64, fn func(e *ChangeEvent) bool) error
+
 	// Dump the tree under root, which may be modified by checkRoot
 	DumpMeta(w io.Writer, root Ino, threads int, keepSecret, fast, skipTrash bool) error
 	LoadMeta(r io.Reader) error
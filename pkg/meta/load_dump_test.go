@@ -433,6 +433,48 @@ func TestLoadDumpV2(t *testing.T) {
 	}
 }
 
+func TestLoadDumpV2SoftQuota(t *testing.T) {
+	engines := map[string][]string{
+		"sqlite3": {"sqlite3://" + path.Join(t.TempDir(), "soft-quota1.db"), "sqlite3://" + path.Join(t.TempDir(), "soft-quota2.db")},
+		"redis":   {"redis://127.0.0.1:6379/2", "redis://127.0.0.1:6379/3"},
+		"badger":  {"badger://" + path.Join(t.TempDir(), "soft-quota-bk1"), "badger://" + path.Join(t.TempDir(), "soft-quota-bk2")},
+	}
+	for name, addrs := range engines {
+		t.Run("Metadata Engine: "+name, func(t *testing.T) {
+			ctx := Background()
+			m := testLoad(t, addrs[0], sampleFile, false)
+			defer m.Shutdown()
+			soft := &Quota{MaxSpace: -1, MaxInodes: -1, UsedSpace: -1, UsedInodes: -1, SoftSpace: 1 << 29, SoftInodes: 50, Grace: 3600}
+			if _, err := m.(engine).doSetQuota(ctx, DirQuotaType, 1, soft); err != nil {
+				t.Fatalf("set soft quota: %s", err)
+			}
+			fname := path.Join(t.TempDir(), name+"-soft-quota.dump")
+			testDumpV2(t, m, fname, nil)
+
+			m2 := NewClient(addrs[1], nil)
+			defer m2.Shutdown()
+			if err := m2.Reset(); err != nil {
+				t.Fatalf("reset meta: %s", err)
+			}
+			fp, err := os.Open(fname)
+			if err != nil {
+				t.Fatalf("open file: %s", fname)
+			}
+			defer fp.Close()
+			if err = m2.LoadMetaV2(ctx, fp, &LoadOption{Threads: 10}); err != nil {
+				t.Fatalf("load meta: %s", err)
+			}
+			q, err := m2.(engine).doGetQuota(ctx, DirQuotaType, 1)
+			if err != nil || q == nil {
+				t.Fatalf("get quota: %v %s", q, err)
+			}
+			if q.MaxSpace != 1<<30 || q.MaxInodes != 100 || q.SoftSpace != soft.SoftSpace || q.SoftInodes != soft.SoftInodes || q.Grace != soft.Grace {
+				t.Fatalf("quota after load: %+v", *q)
+			}
+		})
+	}
+}
+
 func TestLoadDumpSlow(t *testing.T) { //skip mutate
 	if os.Getenv("SKIP_NON_CORE") == "true" {
 		t.Skipf("skip non-core test")
@@ -6,7 +6,7 @@ import (
 )
 
 const (
-	invalidateAllChunks = 0xFFFFFFFF
+	InvalidateAllChunks = 0xFFFFFFFF
 	invalidateAttrOnly  = 0xFFFFFFFE
 )
 
@@ -229,7 +229,7 @@ func (o *openfiles) InvalidateChunk(ino Ino, indx uint32) {
 	defer o.Unlock()
 	of, ok := o.files[ino]
 	if ok {
-		if indx == invalidateAllChunks {
+		if indx == InvalidateAllChunks {
 			of.invalidateChunk()
 		} else if indx == 0 {
 			of.first = nil
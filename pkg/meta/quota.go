@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/pkg/errors"
 )
 
@@ -49,10 +50,19 @@ const (
 	UGQuotaKey = "ug_quota"
 )
 
+// DefaultQuotaGrace is the grace period of soft limits if it's not specified.
+const DefaultQuotaGrace = 7 * 24 * 3600
+
 type Quota struct {
 	MaxSpace, MaxInodes   int64
 	UsedSpace, UsedInodes int64
 	newSpace, newInodes   int64
+	// The soft limits can be exceeded for a grace period (in seconds), which starts from Exceeded (unix
+	// time, 0 if the usage is under the soft limits). Like the others, negative values mean no change
+	// when they are set. They are stored apart from the hard limits, so old clients still enforce the
+	// hard ones, and Exceeded is only changed by doSetQuotaExceeded.
+	SoftSpace, SoftInodes int64
+	Grace, Exceeded       int64
 }
 
 type iQuota struct {
@@ -61,23 +71,117 @@ type iQuota struct {
 	quota *Quota
 }
 
-// Returns true if it will exceed the quota limit
+// packSoftQuota packs the soft limits, grace period and the time they are exceeded of a quota.
+func packSoftQuota(q *Quota) []byte {
+	wb := utils.NewBuffer(32)
+	wb.Put64(uint64(q.SoftSpace))
+	wb.Put64(uint64(q.SoftInodes))
+	wb.Put64(uint64(q.Grace))
+	wb.Put64(uint64(q.Exceeded))
+	return wb.Bytes()
+}
+
+func parseSoftQuota(buf []byte, q *Quota) error {
+	if len(buf) != 32 {
+		return fmt.Errorf("invalid soft quota value: %v", buf)
+	}
+	rb := utils.ReadBuffer(buf)
+	q.SoftSpace, q.SoftInodes = int64(rb.Get64()), int64(rb.Get64())
+	q.Grace, q.Exceeded = int64(rb.Get64()), int64(rb.Get64())
+	return nil
+}
+
+// hasSoftQuota returns true if any of the soft limits or grace period is set.
+func (q *Quota) hasSoftQuota() bool {
+	return q.SoftSpace > 0 || q.SoftInodes > 0 || q.Grace > 0
+}
+
+// updateSoftQuota changes the soft limits and grace period of origin by the non-negative ones in q.
+func updateSoftQuota(origin, q *Quota) {
+	if q.SoftSpace >= 0 {
+		origin.SoftSpace = q.SoftSpace
+	}
+	if q.SoftInodes >= 0 {
+		origin.SoftInodes = q.SoftInodes
+	}
+	if q.Grace >= 0 {
+		origin.Grace = q.Grace
+	}
+}
+
+// Returns true if it will exceed the hard limits, or the soft limits after the grace period
 func (q *Quota) check(space, inodes int64) bool {
+	expired := q.graceExpired()
 	if space > 0 {
-		max := atomic.LoadInt64(&q.MaxSpace)
-		if max > 0 && atomic.LoadInt64(&q.UsedSpace)+atomic.LoadInt64(&q.newSpace)+space > max {
+		used := atomic.LoadInt64(&q.UsedSpace) + atomic.LoadInt64(&q.newSpace) + space
+		if max := atomic.LoadInt64(&q.MaxSpace); max > 0 && used > max {
+			return true
+		}
+		if soft := atomic.LoadInt64(&q.SoftSpace); expired && soft > 0 && used > soft {
 			return true
 		}
 	}
 	if inodes > 0 {
-		max := atomic.LoadInt64(&q.MaxInodes)
-		if max > 0 && atomic.LoadInt64(&q.UsedInodes)+atomic.LoadInt64(&q.newInodes)+inodes > max {
+		used := atomic.LoadInt64(&q.UsedInodes) + atomic.LoadInt64(&q.newInodes) + inodes
+		if max := atomic.LoadInt64(&q.MaxInodes); max > 0 && used > max {
+			return true
+		}
+		if soft := atomic.LoadInt64(&q.SoftInodes); expired && soft > 0 && used > soft {
 			return true
 		}
 	}
 	return false
 }
 
+// softExceeded returns true if the usage is above any of the soft limits.
+func (q *Quota) softExceeded() bool {
+	if soft := atomic.LoadInt64(&q.SoftSpace); soft > 0 && atomic.LoadInt64(&q.UsedSpace)+atomic.LoadInt64(&q.newSpace) > soft {
+		return true
+	}
+	soft := atomic.LoadInt64(&q.SoftInodes)
+	return soft > 0 && atomic.LoadInt64(&q.UsedInodes)+atomic.LoadInt64(&q.newInodes) > soft
+}
+
+func (q *Quota) gracePeriod() int64 {
+	if g := atomic.LoadInt64(&q.Grace); g > 0 {
+		return g
+	}
+	return DefaultQuotaGrace
+}
+
+// GraceLeft returns the time left before the soft limits are enforced, which is negative if the grace
+// period is over, or 0 if the soft limits are not exceeded.
+func (q *Quota) GraceLeft() time.Duration {
+	exceeded := atomic.LoadInt64(&q.Exceeded)
+	if exceeded <= 0 {
+		return 0
+	}
+	left := time.Until(time.Unix(exceeded+q.gracePeriod(), 0))
+	if left == 0 {
+		left = -1
+	}
+	return left
+}
+
+func (q *Quota) graceExpired() bool {
+	return q.GraceLeft() < 0
+}
+
+// limits returns the effective limits of space and inodes (0 for unlimited), the soft limits are
+// effective after the grace period.
+func (q *Quota) limits() (space, inodes int64) {
+	space, inodes = q.MaxSpace, q.MaxInodes
+	if q.graceExpired() {
+		if q.SoftSpace > 0 && (space <= 0 || q.SoftSpace < space) {
+			space = q.SoftSpace
+		}
+		if q.SoftInodes > 0 && (inodes <= 0 || q.SoftInodes < inodes) {
+			inodes = q.SoftInodes
+		}
+	}
+	return
+}
+
 func (q *Quota) update(space, inodes int64) {
 	atomic.AddInt64(&q.newSpace, space)
 	atomic.AddInt64(&q.newInodes, inodes)
@@ -91,6 +195,10 @@ func (q *Quota) snap() Quota {
 		UsedInodes: atomic.LoadInt64(&q.UsedInodes),
 		newSpace:   atomic.LoadInt64(&q.newSpace),
 		newInodes:  atomic.LoadInt64(&q.newInodes),
+		SoftSpace:  atomic.LoadInt64(&q.SoftSpace),
+		SoftInodes: atomic.LoadInt64(&q.SoftInodes),
+		Grace:      atomic.LoadInt64(&q.Grace),
+		Exceeded:   atomic.LoadInt64(&q.Exceeded),
 	}
 }
 
@@ -313,11 +421,50 @@ func (m *baseMeta) loadQuotas() {
 		return
 	}
 	m.quotaMu.Lock()
-	defer m.quotaMu.Unlock()
-
 	m.syncQuotaMaps(m.dirQuotas, dirQuotas, "inode")
 	m.syncQuotaMaps(m.userQuotas, userQuotas, "user")
 	m.syncQuotaMaps(m.groupQuotas, groupQuotas, "group")
+	var changed []*iQuota
+	for qtype, quotas := range []map[uint64]*Quota{m.dirQuotas, m.userQuotas, m.groupQuotas} {
+		for key, q := range quotas {
+			if q.softExceeded() != (atomic.LoadInt64(&q.Exceeded) > 0) {
+				changed = append(changed, &iQuota{qtype: uint32(qtype), qkey: key, quota: q})
+			}
+		}
+	}
+	m.quotaMu.Unlock()
+	if !m.conf.ReadOnly {
+		m.updateQuotaGrace(Background(), changed)
+	}
+}
+
+var quotaTypeNames = []string{"directory", "user", "group"}
+
+// updateQuotaGrace starts the grace period of the quotas which exceed the soft limits, or stops it if the
+// usage is under the soft limits again.
+func (m *baseMeta) updateQuotaGrace(ctx Context, quotas []*iQuota) {
+	now := time.Now().Unix()
+	for _, q := range quotas {
+		var exceeded int64
+		if q.quota.softExceeded() {
+			exceeded = now
+		}
+		// compare and set, so the grace period started by another client is not restarted
+		cur, err := m.en.doSetQuotaExceeded(ctx, q.qtype, q.qkey, atomic.LoadInt64(&q.quota.Exceeded), exceeded)
+		if err != nil {
+			logger.Warnf("Update grace period of %s quota %d: %s", quotaTypeNames[q.qtype], q.qkey, err)
+			continue
+		}
+		atomic.StoreInt64(&q.quota.Exceeded, cur)
+		if cur != exceeded {
+			logger.Debugf("Grace period of %s quota %d is changed by another client: %d", quotaTypeNames[q.qtype], q.qkey, cur)
+		} else if exceeded > 0 {
+			logger.Warnf("Soft limits of %s quota %d are exceeded, they will be enforced after %s", quotaTypeNames[q.qtype], q.qkey,
+				time.Duration(q.quota.gracePeriod())*time.Second)
+		} else {
+			logger.Infof("Usage of %s quota %d is under the soft limits again", quotaTypeNames[q.qtype], q.qkey)
+		}
+	}
 }
 
 func (m *baseMeta) syncQuotaMaps(existing map[uint64]*Quota, loaded map[uint64]*Quota, quotaType string) {
@@ -329,6 +476,10 @@ func (m *baseMeta) syncQuotaMaps(existing map[uint64]*Quota, loaded map[uint64]*
 			atomic.SwapInt64(&quota.MaxInodes, q.MaxInodes)
 			atomic.SwapInt64(&quota.UsedSpace, q.UsedSpace)
 			atomic.SwapInt64(&quota.UsedInodes, q.UsedInodes)
+			atomic.SwapInt64(&quota.SoftSpace, q.SoftSpace)
+			atomic.SwapInt64(&quota.SoftInodes, q.SoftInodes)
+			atomic.SwapInt64(&quota.Grace, q.Grace)
+			atomic.SwapInt64(&quota.Exceeded, q.Exceeded)
 		} else {
 			existing[key] = q
 		}
@@ -657,6 +808,9 @@ func (m *baseMeta) handleQuotaSet(ctx Context, qtype uint32, key uint64, dpath s
 		MaxInodes:  quota.MaxInodes,
 		UsedSpace:  -1,
 		UsedInodes: -1,
+		SoftSpace:  quota.SoftSpace,
+		SoftInodes: quota.SoftInodes,
+		Grace:      quota.Grace,
 	})
 	if err != nil {
 		return err
@@ -684,6 +838,9 @@ func (m *baseMeta) initializeQuotaUsage(ctx Context, qtype uint32, key uint64, d
 			UsedInodes: int64(sum.Dirs+sum.Files) - 1,
 			MaxSpace:   -1,
 			MaxInodes:  -1,
+			SoftSpace:  -1,
+			SoftInodes: -1,
+			Grace:      -1,
 		})
 		if err != nil {
 			return wrapErr(err)
@@ -734,6 +891,9 @@ func (m *baseMeta) ScanUserGroupUsage(ctx Context) error {
 			MaxInodes:  atomic.LoadInt64(&quota.MaxInodes),
 			UsedSpace:  atomic.LoadInt64(&quota.UsedSpace),
 			UsedInodes: atomic.LoadInt64(&quota.UsedInodes),
+			SoftSpace:  -1,
+			SoftInodes: -1,
+			Grace:      -1,
 		}
 	}
 
@@ -744,6 +904,9 @@ func (m *baseMeta) ScanUserGroupUsage(ctx Context) error {
 			MaxInodes:  atomic.LoadInt64(&quota.MaxInodes),
 			UsedSpace:  atomic.LoadInt64(&quota.UsedSpace),
 			UsedInodes: atomic.LoadInt64(&quota.UsedInodes),
+			SoftSpace:  -1,
+			SoftInodes: -1,
+			Grace:      -1,
 		}
 	}
 	m.quotaMu.Unlock()
@@ -917,6 +1080,9 @@ func (m *baseMeta) handleQuotaCheck(ctx Context, qtype uint32, key uint64, dpath
 			MaxSpace:   -1,
 			UsedInodes: q.UsedInodes,
 			UsedSpace:  q.UsedSpace,
+			SoftSpace:  -1,
+			SoftInodes: -1,
+			Grace:      -1,
 		})
 		return err
 	}
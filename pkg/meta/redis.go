@@ -50,6 +50,8 @@ import (
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -65,10 +67,12 @@ import (
 	Sessions:   sessions -> [ $sid -> heartbeat ]
 	sustained:  session$sid -> [$inode]
 	locked:     locked$sid -> { lockf$inode or lockp$inode }
+	Lock waits: lockWaits -> { $sid -> blocking lock requests }
 
 	Removed files: delfiles -> [$inode:$length -> seconds]
 	detached nodes: detachedNodes -> [$inode -> seconds]
 	Slices refs: sliceRef -> {k$sliceId_$size -> refcount}
+	Block checksums: blockChecksums -> {$sliceId -> checksums}
 
 	Dir data length:   dirDataLength -> { $inode -> length }
 	Dir used space:    dirUsedSpace -> { $inode -> usedSpace }
@@ -77,6 +81,7 @@ import (
 	Quota used space:  dirQuotaUsedSpace -> { $inode -> usedSpace }
 	Quota used inodes: dirQuotaUsedInodes -> { $inode -> usedInodes }
 	Acl: acl -> { $acl_id -> acl }
+	Changelog: changelog -> [$event -> seq]
 
 	Redis features:
 	  Sorted Set: 1.2+
@@ -92,6 +97,10 @@ type redisMeta struct {
 	prefix     string
 	shaLookup  string // The SHA returned by Redis for the loaded `scriptLookup`
 	shaResolve string // The SHA returned by Redis for the loaded `scriptResolve`
+
+	replicas       *replicaSet
+	replicaClients []*redis.Client
+	lastReplOffset int64 // replication offset of the primary in the last check
 }
 
 var _ Meta = (*redisMeta)(nil)
@@ -117,6 +126,8 @@ func newRedisMeta(driver, addr string, conf *Config) (Meta, error) {
 	readTimeout := query.duration("read-timeout", "read_timeout", time.Second*30)
 	writeTimeout := query.duration("write-timeout", "write_timeout", time.Second*5)
 	routeRead := query.pop("route-read")
+	replicas := query.pop("replicas")
+	maxStaleness := query.duration("max-staleness", "max_staleness", defaultMaxStaleness)
 	skipVerify := query.pop("insecure-skip-verify")
 	certFile := query.pop("tls-cert-file")
 	keyFile := query.pop("tls-key-file")
@@ -261,21 +272,124 @@ func newRedisMeta(driver, addr string, conf *Config) (Meta, error) {
 	}
 	m.en = m
 	m.checkServerConfig()
+	if replicas != "" {
+		if c, ok := rdb.(*redis.Client); ok {
+			m.replicas = newReplicaSet(parseReplicas(replicas, "6379"), maxStaleness)
+			for _, addr := range m.replicas.addrs {
+				ropt := *c.Options()
+				ropt.Addr = addr
+				m.replicaClients = append(m.replicaClients, redis.NewClient(&ropt))
+			}
+			go m.replicas.run(m.probeReplicas)
+		} else {
+			logger.Warnf("replicas are ignored for redis sentinel or cluster, please use route-read instead")
+		}
+	}
 	return m, nil
 }
 
+// probeReplicas checks whether replicas have caught up with the replication offset of the primary in the previous check.
+func (m *redisMeta) probeReplicas() []bool {
+	fresh := make([]bool, len(m.replicaClients))
+	info, err := m.rdb.Info(Background(), "replication").Result()
+	if err != nil {
+		logger.Warnf("get replication info of the primary: %s", err)
+		return fresh
+	}
+	offset, _ := strconv.ParseInt(redisInfoField(info, "master_repl_offset"), 10, 64)
+	for i, c := range m.replicaClients {
+		info, err := c.Info(Background(), "replication").Result()
+		if err != nil {
+			logger.Debugf("get replication info of replica %s: %s", m.replicas.addrs[i], err)
+			continue
+		}
+		roffset, _ := strconv.ParseInt(redisInfoField(info, "slave_repl_offset"), 10, 64)
+		fresh[i] = redisInfoField(info, "master_link_status") == "up" && m.lastReplOffset > 0 && roffset >= m.lastReplOffset
+	}
+	m.lastReplOffset = offset
+	return fresh
+}
+
+// replica returns a client of a fresh replica, or nil if there is none.
+func (m *redisMeta) replica() redis.Cmdable {
+	if m.replicas != nil {
+		if i := m.replicas.pick(); i >= 0 {
+			return m.replicaClients[i]
+		}
+	}
+	return nil
+}
+
 func (m *redisMeta) Shutdown() error {
+	m.closeSlowLog()
+	if m.replicas != nil {
+		m.replicas.close()
+		for _, c := range m.replicaClients {
+			_ = c.Close()
+		}
+	}
 	return m.rdb.Close()
 }
 
 func (m *redisMeta) doDeleteSlice(id uint64, size uint32) error {
-	return m.rdb.HDel(Background(), m.sliceRefs(), m.sliceKey(id, size)).Err()
+	ctx := Background()
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, m.sliceRefs(), m.sliceKey(id, size))
+		pipe.HDel(ctx, m.blockChecksums(), strconv.FormatUint(id, 10))
+		return nil
+	})
+	return err
+}
+
+func (m *redisMeta) doSetChecksums(ctx Context, id uint64, sums []byte) error {
+	return m.rdb.HSet(ctx, m.blockChecksums(), strconv.FormatUint(id, 10), sums).Err()
+}
+
+func (m *redisMeta) doGetChecksums(ctx Context, id uint64) ([]byte, error) {
+	sums, err := m.rdb.HGet(ctx, m.blockChecksums(), strconv.FormatUint(id, 10)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return sums, err
+}
+
+func (m *redisMeta) doSetLockWaits(ctx Context, sid uint64, waits []byte) error {
+	if waits == nil {
+		return m.rdb.HDel(ctx, m.lockWaits(), strconv.FormatUint(sid, 10)).Err()
+	}
+	return m.rdb.HSet(ctx, m.lockWaits(), strconv.FormatUint(sid, 10), waits).Err()
+}
+
+func (m *redisMeta) doListLockWaits(ctx Context) (map[uint64][]byte, error) {
+	vals, err := m.rdb.HGetAll(ctx, m.lockWaits()).Result()
+	if err != nil {
+		return nil, err
+	}
+	waits := make(map[uint64][]byte, len(vals))
+	for k, v := range vals {
+		if sid, err := strconv.ParseUint(k, 10, 64); err == nil {
+			waits[sid] = []byte(v)
+		}
+	}
+	return waits, nil
 }
 
 func (m *redisMeta) Name() string {
 	return "redis"
 }
 
+func (m *redisMeta) engineStats() map[string]float64 {
+	s := m.rdb.PoolStats()
+	return map[string]float64{
+		"pool_hits":        float64(s.Hits),
+		"pool_misses":      float64(s.Misses),
+		"pool_timeouts":    float64(s.Timeouts),
+		"pool_total_conns": float64(s.TotalConns),
+		"pool_idle_conns":  float64(s.IdleConns),
+		"pool_stale_conns": float64(s.StaleConns),
+	}
+}
+
 func (m *redisMeta) doInit(format *Format, force bool) error {
 	ctx := Background()
 	body, err := m.rdb.Get(ctx, m.setting()).Bytes()
@@ -297,8 +411,8 @@ func (m *redisMeta) doInit(format *Format, force bool) error {
 		}
 		if !old.UserGroupQuota && format.UserGroupQuota {
 			// remove user group quota as they are outdated
-			err := m.rdb.Del(ctx, m.userQuotaKey(), m.userQuotaUsedSpaceKey(), m.userQuotaUsedInodesKey(),
-				m.groupQuotaKey(), m.groupQuotaUsedSpaceKey(), m.groupQuotaUsedInodesKey()).Err()
+			err := m.rdb.Del(ctx, m.userQuotaKey(), m.userQuotaUsedSpaceKey(), m.userQuotaUsedInodesKey(), m.userSoftQuotaKey(),
+				m.groupQuotaKey(), m.groupQuotaUsedSpaceKey(), m.groupQuotaUsedInodesKey(), m.groupSoftQuotaKey()).Err()
 			if err != nil {
 				return errors.Wrap(err, "remove user group quota")
 			}
@@ -679,6 +793,20 @@ func (m *redisMeta) groupQuotaKey() string {
 	return m.prefix + "groupQuota"
 }
 
+// dirSoftQuotaKey keeps the soft limits of the directory quotas apart from the hard ones, which are still
+// read by old clients.
+func (m *redisMeta) dirSoftQuotaKey() string {
+	return m.prefix + "dirSoftQuota"
+}
+
+func (m *redisMeta) userSoftQuotaKey() string {
+	return m.prefix + "userSoftQuota"
+}
+
+func (m *redisMeta) groupSoftQuotaKey() string {
+	return m.prefix + "groupSoftQuota"
+}
+
 func (m *redisMeta) totalInodesKey() string {
 	return m.prefix + totalInodes
 }
@@ -687,6 +815,10 @@ func (m *redisMeta) aclKey() string {
 	return m.prefix + "acl"
 }
 
+func (m *redisMeta) changelogKey() string {
+	return m.prefix + "changelog"
+}
+
 func (m *redisMeta) delfiles() string {
 	return m.prefix + "delfiles"
 }
@@ -711,6 +843,14 @@ func (m *redisMeta) sliceRefs() string {
 	return m.prefix + "sliceRef"
 }
 
+func (m *redisMeta) blockChecksums() string {
+	return m.prefix + "blockChecksums"
+}
+
+func (m *redisMeta) lockWaits() string {
+	return m.prefix + "lockWaits"
+}
+
 func (m *redisMeta) packQuota(space, inodes int64) []byte {
 	wb := utils.NewBuffer(16)
 	wb.Put64(uint64(space))
@@ -904,6 +1044,20 @@ func (m *redisMeta) handleLuaResult(op string, res interface{}, err error, retur
 }
 
 func (m *redisMeta) doLookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr) syscall.Errno {
+	if rdb := m.replica(); rdb != nil {
+		if buf, err := rdb.HGet(ctx, m.entryKey(parent), name).Bytes(); err == nil {
+			_, foundIno := m.parseEntry(buf)
+			if attr == nil {
+				*inode = foundIno
+				return 0
+			}
+			if a, err := rdb.Get(ctx, m.inodeKey(foundIno)).Bytes(); err == nil {
+				m.parseAttr(a, attr)
+				*inode = foundIno
+				return 0
+			}
+		}
+	}
 	var foundIno Ino
 	var foundType uint8
 	var encodedAttr []byte
@@ -973,6 +1127,12 @@ func (m *redisMeta) Resolve(ctx Context, parent Ino, path string, inode *Ino, at
 }
 
 func (m *redisMeta) doGetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
+	if rdb := m.replica(); rdb != nil {
+		if a, err := rdb.Get(ctx, m.inodeKey(inode)).Bytes(); err == nil {
+			m.parseAttr(a, attr)
+			return 0
+		}
+	}
 	a, err := m.rdb.Get(ctx, m.inodeKey(inode)).Bytes()
 	if err == nil {
 		m.parseAttr(a, attr)
@@ -1064,8 +1224,10 @@ func (m *redisMeta) txn(ctx Context, txf func(tx *redis.Tx) error, keys ...strin
 	start := time.Now()
 	defer func() { m.txDist.Observe(time.Since(start).Seconds()) }()
 
+	span := utils.StartSpan(ctx, "meta.txn")
 	m.txLock(h)
 	defer m.txUnlock(h)
+	defer m.replicas.wrote()
 	// TODO: enable retry for some of idempodent transactions
 	var (
 		retryOnFailture = false
@@ -1074,6 +1236,7 @@ func (m *redisMeta) txn(ctx Context, txf func(tx *redis.Tx) error, keys ...strin
 	)
 	for i := 0; i < 50; i++ {
 		if ctx.Canceled() {
+			utils.EndSpan(span, syscall.EINTR)
 			return syscall.EINTR
 		}
 		err := m.rdb.Watch(ctx, replaceErrno(txf), keys...)
@@ -1084,11 +1247,15 @@ func (m *redisMeta) txn(ctx Context, txf func(tx *redis.Tx) error, keys ...strin
 				err = syscall.Errno(eno)
 			}
 		}
+		if err == nil {
+			committedChanges(ctx)
+		}
 		if err != nil && m.shouldRetry(err, retryOnFailture) {
 			if method == "" {
 				method = callerName(ctx) // lazy evaluation
 			}
 			m.txRestart.WithLabelValues(method).Add(1)
+			span.AddEvent("restart", trace.WithAttributes(attribute.String("error", err.Error())))
 			logger.Debugf("Transaction failed, restart it (tried %d): %s", i+1, err)
 			lastErr = err
 			time.Sleep(time.Millisecond * time.Duration(rand.Int()%((i+1)*(i+1))))
@@ -1096,9 +1263,17 @@ func (m *redisMeta) txn(ctx Context, txf func(tx *redis.Tx) error, keys ...strin
 		} else if err == nil && i > 1 {
 			logger.Warnf("Transaction succeeded after %d tries (%s), keys: %v, method: %s, last error: %s", i+1, time.Since(start), keys, method, lastErr)
 		}
+		if used := time.Since(start); m.isSlow(used) {
+			if method == "" {
+				method = callerName(ctx)
+			}
+			m.logSlow("Slow transaction %s (%s), tries: %d, keys: %v, error: %v", method, used, i+1, keys, err)
+		}
+		utils.EndSpan(span, err)
 		return err
 	}
 	logger.Warnf("Already tried 50 times, returning: %s", lastErr)
+	utils.EndSpan(span, lastErr)
 	return lastErr
 }
 
@@ -1167,7 +1342,9 @@ func (m *redisMeta) doTruncate(ctx Context, inode Ino, flags uint8, length uint6
 		t.Mtimensec = uint32(now.Nanosecond())
 		t.Ctime = now.Unix()
 		t.Ctimensec = uint32(now.Nanosecond())
+		*attr = t
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(&t), 0)
 			// zero out from left to right
 			var l = uint32(right - left)
@@ -1185,9 +1362,6 @@ func (m *redisMeta) doTruncate(ctx Context, inode Ino, flags uint8, length uint6
 			pipe.IncrBy(ctx, m.usedSpaceKey(), delta.space)
 			return nil
 		})
-		if err == nil {
-			*attr = t
-		}
 		return err
 	}, m.inodeKey(inode)))
 }
@@ -1232,7 +1406,9 @@ func (m *redisMeta) doFallocate(ctx Context, inode Ino, mode uint8, off uint64,
 		t.Mtimensec = uint32(now.Nanosecond())
 		t.Ctime = now.Unix()
 		t.Ctimensec = uint32(now.Nanosecond())
+		*attr = t
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(&t), 0)
 			if mode&(fallocZeroRange|fallocPunchHole) != 0 && off < old {
 				off, size := off, size
@@ -1254,9 +1430,6 @@ func (m *redisMeta) doFallocate(ctx Context, inode Ino, mode uint8, off uint64,
 			pipe.IncrBy(ctx, m.usedSpaceKey(), align4K(length)-align4K(old))
 			return nil
 		})
-		if err == nil {
-			*attr = t
-		}
 		return err
 	}, m.inodeKey(inode)))
 }
@@ -1298,13 +1471,12 @@ func (m *redisMeta) doSetAttr(ctx Context, inode Ino, set uint16, sugidclearmode
 
 		dirtyAttr.Ctime = now.Unix()
 		dirtyAttr.Ctimensec = uint32(now.Nanosecond())
+		*attr = *dirtyAttr
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(dirtyAttr), 0)
 			return nil
 		})
-		if err == nil {
-			*attr = *dirtyAttr
-		}
 		return err
 	}, m.inodeKey(inode)))
 }
@@ -1481,6 +1653,7 @@ func (m *redisMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, m
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.Set(ctx, m.inodeKey(*inode), m.marshal(attr), 0)
 			if updateParent {
 				pipe.Set(ctx, m.inodeKey(parent), m.marshal(&pattr), 0)
@@ -1538,6 +1711,7 @@ func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string, attr *Attr, s
 		if _type == TypeDirectory {
 			return syscall.EPERM
 		}
+		changedInode(ctx, inode, _type)
 		if err := tx.Watch(ctx, m.inodeKey(inode)).Err(); err != nil {
 			return err
 		}
@@ -1600,6 +1774,7 @@ func (m *redisMeta) doUnlink(ctx Context, parent Ino, name string, attr *Attr, s
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.HDel(ctx, m.entryKey(parent), name)
 			if updateParent {
 				pipe.Set(ctx, m.inodeKey(parent), m.marshal(&pattr), 0)
@@ -1740,6 +1915,7 @@ func (m *redisMeta) doRmdir(ctx Context, parent Ino, name string, pinode *Ino, o
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.HDel(ctx, m.entryKey(parent), name)
 			if !parent.IsTrash() {
 				pipe.Set(ctx, m.inodeKey(parent), m.marshal(&pattr), 0)
@@ -1759,6 +1935,7 @@ func (m *redisMeta) doRmdir(ctx Context, parent Ino, name string, pinode *Ino, o
 			pipe.HDel(ctx, m.dirUsedSpaceKey(), field)
 			pipe.HDel(ctx, m.dirUsedInodesKey(), field)
 			pipe.HDel(ctx, m.dirQuotaKey(), field)
+			pipe.HDel(ctx, m.dirSoftQuotaKey(), field)
 			pipe.HDel(ctx, m.dirQuotaUsedSpaceKey(), field)
 			pipe.HDel(ctx, m.dirQuotaUsedInodesKey(), field)
 			return nil
@@ -1997,6 +2174,7 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			if exchange { // dbuf, tattr are valid
 				pipe.Set(ctx, m.inodeKey(dino), m.marshal(&tattr), 0)
 				pipe.HSet(ctx, m.entryKey(parentSrc), nameSrc, dbuf)
@@ -2048,6 +2226,7 @@ func (m *redisMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentD
 					if dtyp == TypeDirectory {
 						field := dino.String()
 						pipe.HDel(ctx, m.dirQuotaKey(), field)
+						pipe.HDel(ctx, m.dirSoftQuotaKey(), field)
 						pipe.HDel(ctx, m.dirQuotaUsedSpaceKey(), field)
 						pipe.HDel(ctx, m.dirQuotaUsedInodesKey(), field)
 					}
@@ -2120,7 +2299,12 @@ func (m *redisMeta) doLink(ctx Context, inode, parent Ino, name string, attr *At
 			return syscall.EPERM
 		}
 		oldParent := iattr.Parent
-		iattr.Parent = 0
+		tmpfile := iattr.Nlink == 0 // created by O_TMPFILE
+		if tmpfile {
+			iattr.Parent = parent
+		} else {
+			iattr.Parent = 0
+		}
 		iattr.Ctime = now.Unix()
 		iattr.Ctimensec = uint32(now.Nanosecond())
 		iattr.Nlink++
@@ -2135,11 +2319,16 @@ func (m *redisMeta) doLink(ctx Context, inode, parent Ino, name string, attr *At
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			pipe.HSet(ctx, m.entryKey(parent), name, m.packEntry(iattr.Typ, inode))
 			if updateParent {
 				pipe.Set(ctx, m.inodeKey(parent), m.marshal(&pattr), 0)
 			}
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(&iattr), 0)
+			if tmpfile {
+				pipe.SRem(ctx, m.sustained(m.sid), strconv.Itoa(int(inode)))
+				return nil
+			}
 			if oldParent > 0 {
 				pipe.HIncrBy(ctx, m.parentKey(inode), oldParent.String(), 1)
 			}
@@ -2154,6 +2343,10 @@ func (m *redisMeta) doLink(ctx Context, inode, parent Ino, name string, attr *At
 }
 
 func (m *redisMeta) fillAttr(ctx Context, es []*Entry) error {
+	return m.fillAttrFrom(ctx, m.rdb, es)
+}
+
+func (m *redisMeta) fillAttrFrom(ctx Context, rdb redis.Cmdable, es []*Entry) error {
 	if len(es) == 0 {
 		return nil
 	}
@@ -2161,7 +2354,7 @@ func (m *redisMeta) fillAttr(ctx Context, es []*Entry) error {
 	for i, e := range es {
 		keys[i] = m.inodeKey(e.Inode)
 	}
-	rs, err := m.rdb.MGet(ctx, keys...).Result()
+	rs, err := rdb.MGet(ctx, keys...).Result()
 	if err != nil {
 		return err
 	}
@@ -2169,7 +2362,9 @@ func (m *redisMeta) fillAttr(ctx Context, es []*Entry) error {
 		if re != nil {
 			if a, ok := re.(string); ok {
 				m.parseAttr([]byte(a), es[j].Attr)
-				m.of.Update(es[j].Inode, es[j].Attr)
+				if rdb == m.rdb {
+					m.of.Update(es[j].Inode, es[j].Attr)
+				}
 			}
 		}
 	}
@@ -2177,8 +2372,19 @@ func (m *redisMeta) fillAttr(ctx Context, es []*Entry) error {
 }
 
 func (m *redisMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*Entry, limit int) syscall.Errno {
+	if rdb := m.replica(); rdb != nil {
+		n := len(*entries)
+		if err := m.readdir(ctx, rdb, inode, plus, entries, limit); err == nil && len(*entries) > n {
+			return 0
+		}
+		*entries = (*entries)[:n] // a new directory may not be replicated yet
+	}
+	return errno(m.readdir(ctx, m.rdb, inode, plus, entries, limit))
+}
+
+func (m *redisMeta) readdir(ctx Context, rdb redis.Cmdable, inode Ino, plus uint8, entries *[]*Entry, limit int) error {
 	var stop = errors.New("stop")
-	err := m.hscan(ctx, m.entryKey(inode), func(keys []string) error {
+	err := m.hscanFrom(ctx, rdb, m.entryKey(inode), func(keys []string) error {
 		newEntries := make([]Entry, len(keys)/2)
 		newAttrs := make([]Attr, len(keys)/2)
 		for i := 0; i < len(keys); i += 2 {
@@ -2203,14 +2409,14 @@ func (m *redisMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*En
 		err = nil
 	}
 	if err != nil {
-		return errno(err)
+		return err
 	}
 
 	if plus != 0 && len(*entries) != 0 {
 		batchSize := 4096
 		nEntries := len(*entries)
 		if nEntries <= batchSize {
-			err = m.fillAttr(ctx, *entries)
+			err = m.fillAttrFrom(ctx, rdb, *entries)
 		} else {
 			indexCh := make(chan []*Entry, 10)
 			var wg sync.WaitGroup
@@ -2219,7 +2425,7 @@ func (m *redisMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*En
 				go func() {
 					defer wg.Done()
 					for es := range indexCh {
-						e := m.fillAttr(ctx, es)
+						e := m.fillAttrFrom(ctx, rdb, es)
 						if e != nil {
 							err = e
 							break
@@ -2237,11 +2443,8 @@ func (m *redisMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*En
 			close(indexCh)
 			wg.Wait()
 		}
-		if err != nil {
-			return errno(err)
-		}
 	}
-	return 0
+	return err
 }
 
 func (m *redisMeta) doCleanStaleSession(sid uint64) error {
@@ -2300,6 +2503,10 @@ func (m *redisMeta) doCleanStaleSession(sid uint64) error {
 			logger.Warnf("HDel sessionInfos %s: %s", ssid, err)
 			fail = true
 		}
+		if err := m.rdb.HDel(ctx, m.lockWaits(), ssid).Err(); err != nil {
+			logger.Warnf("HDel lockWaits %s: %s", ssid, err)
+			fail = true
+		}
 	}
 	if fail {
 		return fmt.Errorf("failed to clean up sid %d", sid)
@@ -2403,7 +2610,7 @@ func (m *redisMeta) doRead(ctx Context, inode Ino, indx uint32) ([]*slice, sysca
 	return readSlices(vals), 0
 }
 
-func (m *redisMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
+func (m *redisMeta) doWrite(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
 	return errno(m.txn(ctx, func(tx *redis.Tx) error {
 		*delta = dirStat{}
 		*attr = Attr{}
@@ -2412,10 +2619,13 @@ func (m *redisMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, sli
 			return err
 		}
 		m.parseAttr(a, attr)
-		if attr.Typ != TypeFile {
+		if attr.Typ != TypeFile || attr.Flags&FlagImmutable != 0 {
 			return syscall.EPERM
 		}
-		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
+		if attr.Flags&FlagAppend != 0 && extentsStart(indx, extents) < attr.Length {
+			return syscall.EPERM // only appending is allowed
+		}
+		newleng := extentsEnd(indx, extents)
 		if newleng > attr.Length {
 			delta.length = int64(newleng - attr.Length)
 			delta.space = align4K(newleng) - align4K(attr.Length)
@@ -2432,9 +2642,17 @@ func (m *redisMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, sli
 
 		var rpush *redis.IntCmd
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			rpush = pipe.RPush(ctx, m.chunkKey(inode, indx), marshalSlice(off, slice.Id, slice.Size, slice.Off, slice.Len))
+			m.pipeChanges(ctx, pipe)
+			vals := make([]interface{}, 0, len(extents))
+			for _, e := range extents {
+				vals = append(vals, marshalSlice(e.Pos, e.Id, e.Size, e.Off, e.Len))
+			}
+			rpush = pipe.RPush(ctx, m.chunkKey(inode, indx), vals...)
 			// most of chunk are used by single inode, so use that as the default (1 == not exists)
 			// pipe.Incr(ctx, r.sliceKey(slice.ID, slice.Size))
+			if s := extents[0]; len(extents) > 1 && s.Id > 0 {
+				pipe.HIncrBy(ctx, m.sliceRefs(), m.sliceKey(s.Id, s.Size), int64(len(extents)-1))
+			}
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(attr), 0)
 			if delta.space > 0 {
 				pipe.IncrBy(ctx, m.usedSpaceKey(), delta.space)
@@ -2457,7 +2675,10 @@ func (m *redisMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino,
 	}
 	var newLength, newSpace int64
 	var sattr, attr Attr
-	defer func() { m.of.InvalidateChunk(fout, invalidateAllChunks) }()
+	defer func() { m.of.InvalidateChunk(fout, InvalidateAllChunks) }()
+	ctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: fout}, func(e *ChangeEvent) {
+		e.Parent = attr.Parent
+	})
 	err := m.txn(ctx, func(tx *redis.Tx) error {
 		newLength, newSpace = 0, 0
 		rs, err := tx.MGet(ctx, m.inodeKey(fin), m.inodeKey(fout)).Result()
@@ -2519,6 +2740,7 @@ func (m *redisMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino,
 		}
 
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
 			coff := offIn / ChunkSize * ChunkSize
 			for _, sv := range vals {
 				// Add a zero chunk for hole
@@ -2580,6 +2802,7 @@ func (m *redisMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino,
 		}
 		return err
 	}, m.inodeKey(fout), m.inodeKey(fin))
+	m.endChange(changes, errno(err))
 	if err == nil {
 		m.updateParentStat(ctx, fout, attr.Parent, newLength, newSpace)
 	}
@@ -2621,6 +2844,44 @@ func (m *redisMeta) doGetParents(ctx Context, inode Ino) map[Ino]int {
 	return ps
 }
 
+// scriptChangelog appends an event to the changelog with the next sequence, which is assigned when the
+// transaction is executed to keep the events in the order of commits.
+const scriptChangelog = `
+local seq = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], seq, ARGV[1])
+return seq
+`
+
+// pipeChanges appends the event of the operation in the pipeline of its transaction.
+func (m *redisMeta) pipeChanges(ctx Context, pipe redis.Pipeliner) {
+	if c := pendingChanges(ctx); c != nil {
+		keys := []string{m.counterKey("nextChangelog"), m.changelogKey()}
+		c.seq = pipe.Eval(ctx, scriptChangelog, keys, c.event.marshal())
+	}
+}
+
+func (m *redisMeta) doReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error) {
+	rng := &redis.ZRangeBy{Min: "(" + strconv.FormatUint(after, 10), Max: "+inf", Count: int64(limit)}
+	vals, err := m.rdb.ZRangeByScoreWithScores(ctx, m.changelogKey(), rng).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*ChangeEvent, 0, len(vals))
+	for _, v := range vals {
+		var e ChangeEvent
+		if err = e.unmarshal([]byte(v.Member.(string))); err != nil {
+			return nil, err
+		}
+		e.Seq = uint64(v.Score) // assigned by scriptChangelog
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (m *redisMeta) doDeleteChangelog(ctx Context, upto uint64) error {
+	return m.rdb.ZRemRangeByScore(ctx, m.changelogKey(), "-inf", strconv.FormatUint(upto, 10)).Err()
+}
+
 func (m *redisMeta) doSyncDirStat(ctx Context, ino Ino) (*dirStat, syscall.Errno) {
 	if m.conf.ReadOnly {
 		return nil, syscall.EROFS
@@ -3224,9 +3485,13 @@ func (m *redisMeta) scan(ctx context.Context, pattern string, f func([]string) e
 }
 
 func (m *redisMeta) hscan(ctx context.Context, key string, f func([]string) error) error {
+	return m.hscanFrom(ctx, m.rdb, key, f)
+}
+
+func (m *redisMeta) hscanFrom(ctx context.Context, rdb redis.Cmdable, key string, f func([]string) error) error {
 	var cursor uint64
 	for {
-		keys, c, err := m.rdb.HScan(ctx, key, cursor, "*", 10000).Result()
+		keys, c, err := rdb.HScan(ctx, key, cursor, "*", 10000).Result()
 		if err != nil {
 			logger.Warnf("HSCAN %s: %s", key, err)
 			return err
@@ -3492,18 +3757,20 @@ func (m *redisMeta) scanPendingFiles(ctx Context, scan pendingFileScan) error {
 
 func (m *redisMeta) doRepair(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 	return errno(m.txn(ctx, func(tx *redis.Tx) error {
-		attr.Nlink = 2
-		vals, err := tx.HGetAll(ctx, m.entryKey(inode)).Result()
-		if err != nil {
-			return err
-		}
-		for _, v := range vals {
-			typ, _ := m.parseEntry([]byte(v))
-			if typ == TypeDirectory {
-				attr.Nlink++
+		if attr.Typ == TypeDirectory {
+			attr.Nlink = 2
+			vals, err := tx.HGetAll(ctx, m.entryKey(inode)).Result()
+			if err != nil {
+				return err
+			}
+			for _, v := range vals {
+				typ, _ := m.parseEntry([]byte(v))
+				if typ == TypeDirectory {
+					attr.Nlink++
+				}
 			}
 		}
-		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			pipe.Set(ctx, m.inodeKey(inode), m.marshal(attr), 0)
 			return nil
 		})
@@ -3548,47 +3815,44 @@ func (m *redisMeta) ListXattr(ctx Context, inode Ino, names *[]byte) syscall.Err
 func (m *redisMeta) doSetXattr(ctx Context, inode Ino, name string, value []byte, flags uint32) syscall.Errno {
 	key := m.xattrKey(inode)
 	return errno(m.txn(ctx, func(tx *redis.Tx) error {
-		switch flags {
-		case XattrCreate:
-			ok, err := tx.HSetNX(ctx, key, name, value).Result()
-			if err != nil {
-				return err
-			}
-			if !ok {
-				return syscall.EEXIST
-			}
-			return nil
-		case XattrReplace:
+		if flags == XattrCreate || flags == XattrReplace {
 			if ok, err := tx.HExists(ctx, key, name).Result(); err != nil {
 				return err
-			} else if !ok {
+			} else if ok && flags == XattrCreate {
+				return syscall.EEXIST
+			} else if !ok && flags == XattrReplace {
 				return ENOATTR
 			}
-			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-				pipe.HSet(ctx, key, name, value)
-				return nil
-			})
-			return err
-		default: // XattrCreateOrReplace
-			_, err := tx.HSet(ctx, key, name, value).Result()
-			return err
 		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
+			pipe.HSet(ctx, key, name, value)
+			return nil
+		})
+		return err
 	}, key))
 }
 
 func (m *redisMeta) doRemoveXattr(ctx Context, inode Ino, name string) syscall.Errno {
-	n, err := m.rdb.HDel(ctx, m.xattrKey(inode), name).Result()
-	if err != nil {
-		return errno(err)
-	} else if n == 0 {
-		return ENOATTR
-	} else {
-		return 0
-	}
+	key := m.xattrKey(inode)
+	return errno(m.txn(ctx, func(tx *redis.Tx) error {
+		if ok, err := tx.HExists(ctx, key, name).Result(); err != nil {
+			return err
+		} else if !ok {
+			return ENOATTR
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
+			pipe.HDel(ctx, key, name)
+			return nil
+		})
+		return err
+	}, key))
 }
 
 type quotaKeys struct {
 	quotaKey      string
+	softKey       string
 	usedSpaceKey  string
 	usedInodesKey string
 }
@@ -3598,18 +3862,21 @@ func (m *redisMeta) getQuotaKeys(qtype uint32) (*quotaKeys, error) {
 	case DirQuotaType:
 		return &quotaKeys{
 			quotaKey:      m.dirQuotaKey(),
+			softKey:       m.dirSoftQuotaKey(),
 			usedSpaceKey:  m.dirQuotaUsedSpaceKey(),
 			usedInodesKey: m.dirQuotaUsedInodesKey(),
 		}, nil
 	case UserQuotaType:
 		return &quotaKeys{
 			quotaKey:      m.userQuotaKey(),
+			softKey:       m.userSoftQuotaKey(),
 			usedSpaceKey:  m.userQuotaUsedSpaceKey(),
 			usedInodesKey: m.userQuotaUsedInodesKey(),
 		}, nil
 	case GroupQuotaType:
 		return &quotaKeys{
 			quotaKey:      m.groupQuotaKey(),
+			softKey:       m.groupSoftQuotaKey(),
 			usedSpaceKey:  m.groupQuotaUsedSpaceKey(),
 			usedInodesKey: m.groupQuotaUsedInodesKey(),
 		}, nil
@@ -3629,10 +3896,13 @@ func (m *redisMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, e
 		pipe.HGet(ctx, config.quotaKey, field)
 		pipe.HGet(ctx, config.usedSpaceKey, field)
 		pipe.HGet(ctx, config.usedInodesKey, field)
+		pipe.HGet(ctx, config.softKey, field)
 		return nil
 	})
 	if err == redis.Nil {
-		return nil, nil
+		if _, e := cmds[0].(*redis.StringCmd).Bytes(); e == redis.Nil {
+			return nil, nil
+		}
 	} else if err != nil {
 		return nil, err
 	}
@@ -3644,6 +3914,11 @@ func (m *redisMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, e
 
 	var quota Quota
 	quota.MaxSpace, quota.MaxInodes = m.parseQuota(buf)
+	if soft, e := cmds[3].(*redis.StringCmd).Bytes(); e == nil {
+		if err = parseSoftQuota(soft, &quota); err != nil {
+			return nil, err
+		}
+	}
 	if quota.UsedSpace, err = cmds[1].(*redis.StringCmd).Int64(); err != nil {
 		return nil, err
 	}
@@ -3673,6 +3948,14 @@ func (m *redisMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quo
 		} else {
 			return e
 		}
+		soft, e := tx.HGet(ctx, config.softKey, field).Bytes()
+		if e == nil {
+			if e = parseSoftQuota(soft, origin); e != nil {
+				return e
+			}
+		} else if e != redis.Nil {
+			return e
+		}
 
 		if quota.MaxSpace >= 0 {
 			origin.MaxSpace = quota.MaxSpace
@@ -3680,9 +3963,15 @@ func (m *redisMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quo
 		if quota.MaxInodes >= 0 {
 			origin.MaxInodes = quota.MaxInodes
 		}
+		updateSoftQuota(origin, quota)
 
 		_, e = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			pipe.HSet(ctx, config.quotaKey, field, m.packQuota(origin.MaxSpace, origin.MaxInodes))
+			if origin.hasSoftQuota() {
+				pipe.HSet(ctx, config.softKey, field, packSoftQuota(origin))
+			} else if soft != nil {
+				pipe.HDel(ctx, config.softKey, field)
+			}
 			if quota.UsedSpace >= 0 {
 				pipe.HSet(ctx, config.usedSpaceKey, field, quota.UsedSpace)
 			} else if created {
@@ -3696,10 +3985,42 @@ func (m *redisMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quo
 			return nil
 		})
 		return e
-	}, m.inodeKey(Ino(key)))
+	}, m.inodeKey(Ino(key)), config.softKey)
 	return created, err
 }
 
+func (m *redisMeta) doSetQuotaExceeded(ctx Context, qtype uint32, key uint64, old, exceeded int64) (int64, error) {
+	config, err := m.getQuotaKeys(qtype)
+	if err != nil {
+		return 0, err
+	}
+	field := strconv.FormatUint(key, 10)
+	var cur int64
+	err = m.txn(ctx, func(tx *redis.Tx) error {
+		buf, e := tx.HGet(ctx, config.softKey, field).Bytes()
+		if e == redis.Nil {
+			cur = 0 // the soft limits are removed
+			return nil
+		} else if e != nil {
+			return e
+		}
+		var q Quota
+		if e = parseSoftQuota(buf, &q); e != nil {
+			return e
+		}
+		if cur = q.Exceeded; cur != old {
+			return nil
+		}
+		q.Exceeded, cur = exceeded, exceeded
+		_, e = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, config.softKey, field, packSoftQuota(&q))
+			return nil
+		})
+		return e
+	}, config.softKey)
+	return cur, err
+}
+
 func (m *redisMeta) doDelQuota(ctx Context, qtype uint32, key uint64) error {
 	config, err := m.getQuotaKeys(qtype)
 	if err != nil {
@@ -3716,6 +4037,7 @@ func (m *redisMeta) doDelQuota(ctx Context, qtype uint32, key uint64) error {
 			pipe.HDel(ctx, config.usedSpaceKey, field)
 			pipe.HDel(ctx, config.usedInodesKey, field)
 		}
+		pipe.HDel(ctx, config.softKey, field)
 		return nil
 	})
 	return err
@@ -3738,6 +4060,15 @@ func (m *redisMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Qu
 			return nil, nil, nil, fmt.Errorf("failed to load %s quotas: %w", qt.name, err)
 		}
 
+		softs := make(map[string][]byte)
+		if err := m.hscan(ctx, config.softKey, func(keys []string) error {
+			for i := 0; i < len(keys); i += 2 {
+				softs[keys[i]] = []byte(keys[i+1])
+			}
+			return nil
+		}); err != nil {
+			return nil, nil, nil, err
+		}
 		quotas := make(map[uint64]*Quota)
 		if err := m.hscan(ctx, config.quotaKey, func(keys []string) error {
 			for i := 0; i < len(keys); i += 2 {
@@ -3752,7 +4083,13 @@ func (m *redisMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Qu
 					continue
 				}
 
-				maxSpace, maxInodes := m.parseQuota(val)
+				var quota Quota
+				quota.MaxSpace, quota.MaxInodes = m.parseQuota(val)
+				if soft, ok := softs[key]; ok {
+					if err := parseSoftQuota(soft, &quota); err != nil {
+						logger.Errorf("%s of quota %s", err, key)
+					}
+				}
 				usedSpace, err := m.rdb.HGet(ctx, config.usedSpaceKey, key).Int64()
 				if err != nil && err != redis.Nil {
 					return err
@@ -3762,12 +4099,8 @@ func (m *redisMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Qu
 					return err
 				}
 
-				quotas[id] = &Quota{
-					MaxSpace:   int64(maxSpace),
-					MaxInodes:  int64(maxInodes),
-					UsedSpace:  usedSpace,
-					UsedInodes: usedInodes,
-				}
+				quota.UsedSpace, quota.UsedInodes = usedSpace, usedInodes
+				quotas[id] = &quota
 			}
 			return nil
 		}); err != nil {
@@ -4166,6 +4499,7 @@ func (m *redisMeta) DumpMeta(w io.Writer, root Ino, threads int, keepSecret, fas
 		}
 	}
 	quotas := make(map[Ino]*DumpedQuota)
+	softs := m.rdb.HGetAll(ctx, m.dirSoftQuotaKey()).Val()
 	for k, v := range m.rdb.HGetAll(ctx, m.dirQuotaKey()).Val() {
 		inode, err := strconv.ParseUint(k, 10, 64)
 		if err != nil {
@@ -4176,9 +4510,14 @@ func (m *redisMeta) DumpMeta(w io.Writer, root Ino, threads int, keepSecret, fas
 			logger.Warnf("invalid quota string: %s", hex.EncodeToString([]byte(v)))
 			continue
 		}
-		var quota DumpedQuota
+		var quota Quota
 		quota.MaxSpace, quota.MaxInodes = m.parseQuota([]byte(v))
-		quotas[Ino(inode)] = &quota
+		if soft, ok := softs[k]; ok {
+			if err = parseSoftQuota([]byte(soft), &quota); err != nil {
+				logger.Warnf("%s of quota %s", err, k)
+			}
+		}
+		quotas[Ino(inode)] = &DumpedQuota{MaxSpace: quota.MaxSpace, MaxInodes: quota.MaxInodes, SoftSpace: quota.SoftSpace, SoftInodes: quota.SoftInodes, Grace: quota.Grace}
 	}
 
 	dm := &DumpedMeta{
@@ -4514,6 +4853,7 @@ func (m *redisMeta) doCloneEntry(ctx Context, srcIno Ino, parent Ino, name strin
 		}
 
 		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			m.pipeChanges(ctx, p)
 			p.Set(ctx, m.inodeKey(ino), m.marshal(&attr), 0)
 			p.IncrBy(ctx, m.usedSpaceKey(), align4K(attr.Length))
 			p.Incr(ctx, m.totalInodesKey())
@@ -4647,6 +4987,7 @@ func (m *redisMeta) doAttachDirNode(ctx Context, parent Ino, dstIno Ino, name st
 		}
 
 		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			m.pipeChanges(ctx, p)
 			p.HSet(ctx, m.entryKey(parent), name, m.packEntry(TypeDirectory, dstIno))
 			pattr.Nlink++
 			now := time.Now()
@@ -4662,6 +5003,74 @@ func (m *redisMeta) doAttachDirNode(ctx Context, parent Ino, dstIno Ino, name st
 	}, m.inodeKey(parent), m.entryKey(parent)))
 }
 
+func (m *redisMeta) doDetachDir(ctx Context, parent Ino, name string, pinode *Ino, oldAttr *Attr) syscall.Errno {
+	return errno(m.txn(ctx, func(tx *redis.Tx) error {
+		buf, err := tx.HGet(ctx, m.entryKey(parent), name).Bytes()
+		if err == redis.Nil && m.conf.CaseInsensi {
+			if e := m.resolveCase(ctx, parent, name); e != nil {
+				name = string(e.Name)
+				buf = m.packEntry(e.Attr.Typ, e.Inode)
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		typ, inode := m.parseEntry(buf)
+		if typ != TypeDirectory {
+			return syscall.ENOTDIR
+		}
+		if err = tx.Watch(ctx, m.inodeKey(inode)).Err(); err != nil {
+			return err
+		}
+		rs, err := tx.MGet(ctx, m.inodeKey(parent), m.inodeKey(inode)).Result()
+		if err != nil {
+			return err
+		}
+		if rs[0] == nil || rs[1] == nil {
+			return redis.Nil
+		}
+		var pattr, attr Attr
+		m.parseAttr([]byte(rs[0].(string)), &pattr)
+		m.parseAttr([]byte(rs[1].(string)), &attr)
+		if st := m.Access(ctx, parent, MODE_MASK_W|MODE_MASK_X, &pattr); st != 0 {
+			return st
+		}
+		if (pattr.Flags&FlagAppend) != 0 || (pattr.Flags&FlagImmutable) != 0 {
+			return syscall.EPERM
+		}
+		if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
+			return syscall.EACCES
+		}
+		now := time.Now()
+		pattr.Nlink--
+		pattr.Mtime = now.Unix()
+		pattr.Mtimensec = uint32(now.Nanosecond())
+		pattr.Ctime = now.Unix()
+		pattr.Ctimensec = uint32(now.Nanosecond())
+		attr.Flags |= FlagDeleting
+		attr.Ctime = now.Unix()
+		attr.Ctimensec = uint32(now.Nanosecond())
+		*pinode = inode
+		*oldAttr = attr
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.pipeChanges(ctx, pipe)
+			pipe.HDel(ctx, m.entryKey(parent), name)
+			pipe.Set(ctx, m.inodeKey(parent), m.marshal(&pattr), 0)
+			pipe.Set(ctx, m.inodeKey(inode), m.marshal(&attr), 0)
+			pipe.ZAdd(ctx, m.detachedNodes(), redis.Z{Member: inode.String(), Score: float64(now.Unix())})
+			field := inode.String()
+			pipe.HDel(ctx, m.dirQuotaKey(), field)
+			pipe.HDel(ctx, m.dirSoftQuotaKey(), field)
+			pipe.HDel(ctx, m.dirQuotaUsedSpaceKey(), field)
+			pipe.HDel(ctx, m.dirQuotaUsedInodesKey(), field)
+			return nil
+		})
+		return err
+	}, m.inodeKey(parent), m.entryKey(parent)))
+}
+
 func (m *redisMeta) doTouchAtime(ctx Context, inode Ino, attr *Attr, now time.Time) (bool, error) {
 	var updated bool
 	err := m.txn(ctx, func(tx *redis.Tx) error {
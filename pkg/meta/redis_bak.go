@@ -339,6 +339,26 @@ func (m *redisMeta) dumpQuota(ctx Context, opt *DumpOption, ch chan<- *dumpedRes
 		}
 	}
 
+	vals, err = m.rdb.HGetAll(ctx, m.dirSoftQuotaKey()).Result()
+	if err != nil {
+		return fmt.Errorf("get dirSoftQuotaKey err: %w", err)
+	}
+	for k, v := range vals {
+		inode, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			logger.Warnf("parse soft quota inode: %s: %v", k, err)
+			continue
+		}
+		var soft Quota
+		if err = parseSoftQuota([]byte(v), &soft); err != nil {
+			logger.Warnf("%s of quota %d", err, inode)
+		} else if q, ok := quotas[Ino(inode)]; !ok {
+			logger.Warnf("quota for soft limits not found: %d", inode)
+		} else {
+			q.SoftSpace, q.SoftInodes, q.Grace = soft.SoftSpace, soft.SoftInodes, soft.Grace
+		}
+	}
+
 	vals, err = m.rdb.HGetAll(ctx, m.dirQuotaUsedInodesKey()).Result()
 	if err != nil {
 		return fmt.Errorf("get dirQuotaUsedInodesKey err: %w", err)
@@ -881,6 +901,10 @@ func (m *redisMeta) loadQuota(ctx Context, msg proto.Message) error {
 		pipe.HSet(ctx, m.dirQuotaKey(), inodeKey, m.packQuota(pq.MaxSpace, pq.MaxInodes))
 		pipe.HSet(ctx, m.dirQuotaUsedInodesKey(), inodeKey, pq.UsedInodes)
 		pipe.HSet(ctx, m.dirQuotaUsedSpaceKey(), inodeKey, pq.UsedSpace)
+		soft := &Quota{SoftSpace: pq.SoftSpace, SoftInodes: pq.SoftInodes, Grace: pq.Grace}
+		if soft.hasSoftQuota() {
+			pipe.HSet(ctx, m.dirSoftQuotaKey(), inodeKey, packSoftQuota(soft))
+		}
 		if pipe.Len() >= redisPipeLimit {
 			if err := execPipe(ctx, pipe); err != nil {
 				return err
@@ -50,6 +50,7 @@ func (r *redisMeta) Flock(ctx Context, inode Ino, owner uint64, ltype uint32, bl
 		}, ikey))
 	}
 	var err error
+	var w *lockWait
 	for {
 		err = r.txn(ctx, func(tx *redis.Tx) error {
 			owners, err := tx.HGetAll(ctx, ikey).Result()
@@ -83,6 +84,13 @@ func (r *redisMeta) Flock(ctx Context, inode Ino, owner uint64, ltype uint32, bl
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner, Flock: true, Type: ltype}
+			defer r.doneLock(w)
+		}
+		if st := r.waitLock(ctx, w, r.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -139,6 +147,7 @@ func (r *redisMeta) Setlk(ctx Context, inode Ino, owner uint64, block bool, ltyp
 	ctx = ctx.WithValue(txMethodKey{}, "Setlk"+strconv.Itoa(int(ltype)))
 	var err error
 	lock := plockRecord{ltype, pid, start, end}
+	var w *lockWait
 	for {
 		err = r.txn(ctx, func(tx *redis.Tx) error {
 			if ltype == F_UNLCK {
@@ -198,6 +207,13 @@ func (r *redisMeta) Setlk(ctx Context, inode Ino, owner uint64, block bool, ltyp
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner, Type: ltype, Start: start, End: end, Pid: pid}
+			defer r.doneLock(w)
+		}
+		if st := r.waitLock(ctx, w, r.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -0,0 +1,124 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaxStaleness = time.Second * 2
+
+// replicaSet routes read-only requests (lookup, getattr and readdir) to replicas of the metadata engine
+// in round robin. A replica is only used when it's not behind the primary for more than maxStaleness,
+// and requests are sent to the primary when the replica misses. After a write of this client, requests
+// are sent to the primary for maxStaleness, so the client always reads its own writes.
+type replicaSet struct {
+	addrs        []string
+	maxStaleness time.Duration
+	fresh        []int32
+	next         uint32
+	lastWrite    int64 // unix nano of the last write
+	stop         chan struct{}
+}
+
+// parseReplicas parses the comma separated addresses, adding the default port if it's missing.
+func parseReplicas(addrs, port string) []string {
+	var rs []string
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if port != "" && !strings.Contains(a, ":") {
+			a += ":" + port
+		}
+		rs = append(rs, a)
+	}
+	return rs
+}
+
+func newReplicaSet(addrs []string, maxStaleness time.Duration) *replicaSet {
+	if maxStaleness <= 0 {
+		maxStaleness = defaultMaxStaleness
+	}
+	return &replicaSet{
+		addrs:        addrs,
+		maxStaleness: maxStaleness,
+		fresh:        make([]int32, len(addrs)),
+		stop:         make(chan struct{}),
+	}
+}
+
+// wrote marks that a write of this client was just committed in the primary.
+func (r *replicaSet) wrote() {
+	if r != nil {
+		atomic.StoreInt64(&r.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// pick returns the index of a fresh replica, or -1 if there is none or this client wrote recently.
+func (r *replicaSet) pick() int {
+	if time.Since(time.Unix(0, atomic.LoadInt64(&r.lastWrite))) < r.maxStaleness {
+		return -1
+	}
+	n := uint32(len(r.addrs))
+	start := atomic.AddUint32(&r.next, 1)
+	for i := uint32(0); i < n; i++ {
+		j := (start + i) % n
+		if atomic.LoadInt32(&r.fresh[j]) == 1 {
+			return int(j)
+		}
+	}
+	return -1
+}
+
+func (r *replicaSet) update(fresh []bool) {
+	for i, f := range fresh {
+		var v int32
+		if f {
+			v = 1
+		}
+		if old := atomic.SwapInt32(&r.fresh[i], v); old != v {
+			if f {
+				logger.Infof("Route read-only requests to metadata replica %s", r.addrs[i])
+			} else {
+				logger.Warnf("Metadata replica %s is unavailable or behind the primary for more than %s", r.addrs[i], r.maxStaleness)
+			}
+		}
+	}
+}
+
+// run checks the replicas every half of maxStaleness by probe, which returns whether each of them is fresh.
+func (r *replicaSet) run(probe func() []bool) {
+	ticker := time.NewTicker(r.maxStaleness / 2)
+	defer ticker.Stop()
+	for {
+		r.update(probe())
+		select {
+		case <-r.stop:
+			r.update(make([]bool, len(r.addrs)))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *replicaSet) close() {
+	close(r.stop)
+}
@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"sort"
@@ -47,6 +48,8 @@ import (
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const MaxFieldsCountOfTable = 18 // node table
@@ -170,6 +173,16 @@ type sliceRef struct {
 	Refs int    `xorm:"index notnull"`
 }
 
+type blockChecksum struct {
+	Id   uint64 `xorm:"pk chunkid"`
+	Sums []byte `xorm:"blob notnull"`
+}
+
+type lockWaiting struct {
+	Sid   uint64 `xorm:"pk"`
+	Waits []byte `xorm:"blob notnull"`
+}
+
 type delslices struct {
 	Id      uint64 `xorm:"pk chunkid"`
 	Deleted int64  `xorm:"notnull"` // timestamp
@@ -235,6 +248,11 @@ type dirStats struct {
 	UsedInodes int64 `xorm:"notnull"`
 }
 
+type changelog struct {
+	Id   uint64 `xorm:"pk"`
+	Data []byte `xorm:"blob notnull"`
+}
+
 type detachedNode struct {
 	Inode Ino   `xorm:"pk notnull"`
 	Added int64 `xorm:"notnull"`
@@ -246,6 +264,10 @@ type dirQuota struct {
 	MaxInodes  int64 `xorm:"notnull"`
 	UsedSpace  int64 `xorm:"notnull"`
 	UsedInodes int64 `xorm:"notnull"`
+	SoftSpace  int64 `xorm:"notnull default 0"`
+	SoftInodes int64 `xorm:"notnull default 0"`
+	Grace      int64 `xorm:"notnull default 0"`
+	Exceeded   int64 `xorm:"notnull default 0"`
 }
 
 type userGroupQuota struct {
@@ -255,6 +277,10 @@ type userGroupQuota struct {
 	MaxInodes  int64  `xorm:"notnull"`
 	UsedSpace  int64  `xorm:"notnull"`
 	UsedInodes int64  `xorm:"notnull"`
+	SoftSpace  int64  `xorm:"notnull default 0"`
+	SoftInodes int64  `xorm:"notnull default 0"`
+	Grace      int64  `xorm:"notnull default 0"`
+	Exceeded   int64  `xorm:"notnull default 0"`
 }
 
 type dbMeta struct {
@@ -266,6 +292,9 @@ type dbMeta struct {
 	noReadOnlyTxn bool
 	statement     map[string]string
 	tablePrefix   string
+
+	replicas   *replicaSet
+	replicaDBs []*xorm.Engine
 }
 
 var _ Meta = (*dbMeta)(nil)
@@ -446,6 +475,19 @@ func newSQLMeta(driver, addr string, conf *Config) (Meta, error) {
 	} else {
 		tablePrefix = "jfs_" + tablePrefix + "_"
 	}
+	var replicas, staleness string
+	if replicas, err = extractCustomConfig(&query, "replicas", ""); err != nil {
+		return nil, err
+	}
+	if staleness, err = extractCustomConfig(&query, "max_staleness", ""); err != nil {
+		return nil, err
+	}
+	maxStaleness := defaultMaxStaleness
+	if staleness != "" {
+		if maxStaleness, err = time.ParseDuration(staleness); err != nil {
+			return nil, fmt.Errorf("parse max_staleness %s: %s", staleness, err)
+		}
+	}
 
 	if driver == "sqlite3" {
 		if !query.Has("cache") {
@@ -546,10 +588,134 @@ func newSQLMeta(driver, addr string, conf *Config) (Meta, error) {
 		},
 	}
 	m.en = m
+	if replicas != "" {
+		if driver != "mysql" && driver != "pgx" {
+			return nil, fmt.Errorf("replicas are not supported for %s", driver)
+		}
+		m.replicas = newReplicaSet(parseReplicas(replicas, ""), maxStaleness)
+		for _, host := range m.replicas.addrs {
+			dsn, err := replicaDSN(driver, addr, host)
+			if err != nil {
+				return nil, err
+			}
+			re, err := xorm.NewEngine(driver, dsn)
+			if err != nil {
+				return nil, fmt.Errorf("unable to use replica %s: %s", host, err)
+			}
+			re.SetLogger(engine.Logger())
+			if searchPath != "" {
+				re.SetSchema(searchPath)
+			}
+			if vOpenConns > 0 {
+				re.DB().SetMaxOpenConns(vOpenConns)
+			}
+			re.DB().SetMaxIdleConns(vIdleConns)
+			re.DB().SetConnMaxIdleTime(time.Second * time.Duration(vIdleTime))
+			re.SetTableMapper(engine.GetTableMapper())
+			m.replicaDBs = append(m.replicaDBs, re)
+		}
+		go m.replicas.run(m.probeReplicas)
+	}
 	return m, nil
 }
 
+var mysqlHost = regexp.MustCompile(`@(\w*)\(([^)]*)\)/`)
+
+// replicaDSN replaces the host in the data source name with the one of a replica.
+func replicaDSN(driver, addr, host string) (string, error) {
+	switch driver {
+	case "mysql":
+		if loc := mysqlHost.FindStringSubmatchIndex(addr); loc != nil {
+			return addr[:loc[4]] + host + addr[loc[5]:], nil
+		}
+		if i := strings.Index(addr, "@/"); i >= 0 {
+			return addr[:i] + "@tcp(" + host + ")" + addr[i+1:], nil
+		}
+	case "pgx":
+		if u, err := url.Parse(addr); err == nil && u.Host != "" {
+			u.Host = host
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the host in the address of %s", driver)
+}
+
+// probeReplicas checks whether the replication lag of the replicas is within maxStaleness.
+func (m *dbMeta) probeReplicas() []bool {
+	fresh := make([]bool, len(m.replicaDBs))
+	for i, re := range m.replicaDBs {
+		lag, err := replicationLag(re)
+		if err != nil {
+			logger.Debugf("get replication lag of replica %s: %s", m.replicas.addrs[i], err)
+			continue
+		}
+		fresh[i] = lag <= m.replicas.maxStaleness
+	}
+	return fresh
+}
+
+func replicationLag(re *xorm.Engine) (time.Duration, error) {
+	switch re.DriverName() {
+	case "mysql":
+		rows, err := re.QueryString("SHOW REPLICA STATUS")
+		if err != nil {
+			rows, err = re.QueryString("SHOW SLAVE STATUS") // before MySQL 8.0.22
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(rows) == 0 {
+			return 0, fmt.Errorf("not a replica")
+		}
+		v, ok := rows[0]["Seconds_Behind_Source"]
+		if !ok {
+			v = rows[0]["Seconds_Behind_Master"]
+		}
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		return time.Duration(secs) * time.Second, nil
+	case "pgx":
+		rows, err := re.QueryString("SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+			"ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END AS lag")
+		if err != nil {
+			return 0, err
+		}
+		if len(rows) == 0 {
+			return 0, fmt.Errorf("no result")
+		}
+		secs, err := strconv.ParseFloat(rows[0]["lag"], 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("unsupported driver %s", re.DriverName())
+}
+
+// replicaTxn runs f with a fresh replica, it returns ENOTSUP if there is none.
+func (m *dbMeta) replicaTxn(ctx context.Context, f func(s *xorm.Session) error) error {
+	if m.replicas == nil {
+		return syscall.ENOTSUP
+	}
+	i := m.replicas.pick()
+	if i < 0 {
+		return syscall.ENOTSUP
+	}
+	s := m.replicaDBs[i].NewSession().Context(ctx)
+	defer s.Close()
+	return f(s)
+}
+
 func (m *dbMeta) Shutdown() error {
+	m.closeSlowLog()
+	if m.replicas != nil {
+		m.replicas.close()
+		for _, re := range m.replicaDBs {
+			_ = re.Close()
+		}
+	}
 	return m.db.Close()
 }
 
@@ -561,11 +727,71 @@ func (m *dbMeta) Name() string {
 	return name
 }
 
+func (m *dbMeta) engineStats() map[string]float64 {
+	s := m.db.DB().Stats()
+	return map[string]float64{
+		"open_conns":            float64(s.OpenConnections),
+		"inuse_conns":           float64(s.InUse),
+		"idle_conns":            float64(s.Idle),
+		"wait_count":            float64(s.WaitCount),
+		"wait_duration_seconds": s.WaitDuration.Seconds(),
+	}
+}
+
 func (m *dbMeta) doDeleteSlice(id uint64, size uint32) error {
-	return m.txn(func(s *xorm.Session) error {
-		_, err := s.Delete(&sliceRef{Id: id})
+	return m.txn(Background(), func(s *xorm.Session) error {
+		if _, err := s.Delete(&sliceRef{Id: id}); err != nil {
+			return err
+		}
+		_, err := s.Delete(&blockChecksum{Id: id})
+		return err
+	})
+}
+
+func (m *dbMeta) doSetChecksums(ctx Context, id uint64, sums []byte) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
+		if _, err := s.Delete(&blockChecksum{Id: id}); err != nil {
+			return err
+		}
+		return mustInsert(s, &blockChecksum{Id: id, Sums: sums})
+	})
+}
+
+func (m *dbMeta) doGetChecksums(ctx Context, id uint64) (sums []byte, err error) {
+	err = m.simpleTxn(ctx, func(s *xorm.Session) error {
+		c := blockChecksum{Id: id}
+		ok, err := s.Get(&c)
+		if ok {
+			sums = c.Sums
+		}
 		return err
 	})
+	return
+}
+
+func (m *dbMeta) doSetLockWaits(ctx Context, sid uint64, waits []byte) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
+		if _, err := s.Delete(&lockWaiting{Sid: sid}); err != nil || waits == nil {
+			return err
+		}
+		return mustInsert(s, &lockWaiting{Sid: sid, Waits: waits})
+	})
+}
+
+func (m *dbMeta) doListLockWaits(ctx Context) (map[uint64][]byte, error) {
+	var rows []lockWaiting
+	err := m.simpleTxn(ctx, func(s *xorm.Session) error {
+		rows = nil
+		return s.Find(&rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+	waits := make(map[uint64][]byte, len(rows))
+	for _, r := range rows {
+		waits[r.Sid] = r.Waits
+	}
+	return waits, nil
 }
 
 func (m *dbMeta) syncTable(beans ...interface{}) error {
@@ -604,6 +830,15 @@ func (m *dbMeta) syncAllTables() error {
 	if err := m.syncTable(new(acl)); err != nil {
 		return fmt.Errorf("create table acl: %s", err)
 	}
+	if err := m.syncTable(new(changelog)); err != nil {
+		return fmt.Errorf("create table changelog: %s", err)
+	}
+	if err := m.syncTable(new(blockChecksum)); err != nil {
+		return fmt.Errorf("create table block_checksum: %s", err)
+	}
+	if err := m.syncTable(new(lockWaiting)); err != nil {
+		return fmt.Errorf("create table lock_waiting: %s", err)
+	}
 	return nil
 }
 
@@ -662,7 +897,7 @@ func (m *dbMeta) doInit(format *Format, force bool) error {
 	n.setAtime(now)
 	n.setMtime(now)
 	n.setCtime(now)
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(Background(), func(s *xorm.Session) error {
 		if format.TrashDays > 0 {
 			ok2, err := s.ForUpdate().Get(&node{Inode: TrashInode})
 			if err != nil {
@@ -722,7 +957,7 @@ func (m *dbMeta) Reset() error {
 		&node{}, &edge{}, &symlink{}, &xattr{},
 		&chunk{}, &sliceRef{}, &delslices{},
 		&session{}, &session2{}, &sustained{}, &delfile{},
-		&flock{}, &plock{}, &dirStats{}, &dirQuota{}, &userGroupQuota{}, &detachedNode{}, &acl{})
+		&flock{}, &plock{}, &dirStats{}, &dirQuota{}, &userGroupQuota{}, &detachedNode{}, &acl{}, &changelog{}, &blockChecksum{}, &lockWaiting{})
 }
 
 func (m *dbMeta) doLoad() (data []byte, err error) {
@@ -744,9 +979,9 @@ func (m *dbMeta) doLoad() (data []byte, err error) {
 
 func (m *dbMeta) doNewSession(sinfo []byte, update bool) error {
 	// add new table
-	err := m.syncTable(new(session2), new(delslices), new(dirStats), new(detachedNode), new(dirQuota), new(userGroupQuota), new(acl))
+	err := m.syncTable(new(session2), new(delslices), new(dirStats), new(detachedNode), new(dirQuota), new(userGroupQuota), new(acl), new(changelog), new(blockChecksum), new(lockWaiting))
 	if err != nil {
-		return fmt.Errorf("update table session2, delslices, dirstats, detachedNode, dirQuota, userGroupQuota, acl: %s", err)
+		return fmt.Errorf("update table session2, delslices, dirstats, detachedNode, dirQuota, userGroupQuota, acl, changelog, block_checksum: %s", err)
 	}
 	// add node table
 	if err = m.syncTable(new(node)); err != nil {
@@ -764,12 +999,12 @@ func (m *dbMeta) doNewSession(sinfo []byte, update bool) error {
 	for {
 		beans := session2{Sid: m.sid, Expire: m.expireTime(), Info: sinfo}
 		if update {
-			return m.txn(func(s *xorm.Session) error {
+			return m.txn(Background(), func(s *xorm.Session) error {
 				_, err = s.Cols("expire", "info").Update(&beans, &session2{Sid: beans.Sid})
 				return err
 			})
 		} else {
-			if err = m.txn(func(s *xorm.Session) error {
+			if err = m.txn(Background(), func(s *xorm.Session) error {
 				return mustInsert(s, &beans)
 			}); err == nil {
 				break
@@ -935,7 +1170,7 @@ func (m *dbMeta) getCounter(name string) (v int64, err error) {
 }
 
 func (m *dbMeta) incrCounter(name string, value int64) (v int64, err error) {
-	err = m.txn(func(s *xorm.Session) error {
+	err = m.txn(Background(), func(s *xorm.Session) error {
 		v, err = m.incrSessionCounter(s, name, value)
 		return err
 	})
@@ -962,7 +1197,7 @@ func (m *dbMeta) incrSessionCounter(s *xorm.Session, name string, value int64) (
 
 func (m *dbMeta) setIfSmall(name string, value, diff int64) (bool, error) {
 	var changed bool
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(Background(), func(s *xorm.Session) error {
 		changed = false
 		c := counter{Name: name}
 		ok, err := s.ForUpdate().Get(&c)
@@ -1035,7 +1270,7 @@ func (m *dbMeta) shouldRetry(err error) bool {
 	}
 }
 
-func (m *dbMeta) txn(f func(s *xorm.Session) error, inodes ...Ino) error {
+func (m *dbMeta) txn(ctx context.Context, f func(s *xorm.Session) error, inodes ...Ino) error {
 	if m.conf.ReadOnly {
 		return syscall.EROFS
 	}
@@ -1047,23 +1282,32 @@ func (m *dbMeta) txn(f func(s *xorm.Session) error, inodes ...Ino) error {
 		inodes = []Ino{1}
 	}
 
+	span := utils.StartSpan(ctx, "meta.txn")
 	defer m.txBatchLock(inodes...)()
+	defer m.replicas.wrote()
 	var (
 		lastErr error
 		method  string
 	)
 	for i := 0; i < 50; i++ {
 		_, err := m.db.Transaction(func(s *xorm.Session) (interface{}, error) {
-			return nil, f(s)
+			if err := f(s); err != nil {
+				return nil, err
+			}
+			return nil, m.appendChanges(ctx, s)
 		})
 		if eno, ok := err.(syscall.Errno); ok && eno == 0 {
 			err = nil
 		}
+		if err == nil {
+			committedChanges(ctx)
+		}
 		if err != nil && m.shouldRetry(err) {
 			if method == "" {
-				method = callerName(context.TODO()) // lazy evaluation
+				method = callerName(ctx) // lazy evaluation
 			}
 			m.txRestart.WithLabelValues(method).Add(1)
+			span.AddEvent("restart", trace.WithAttributes(attribute.String("error", err.Error())))
 			logger.Debugf("Transaction failed, restart it (tried %d): %s", i+1, err)
 			lastErr = err
 			time.Sleep(time.Millisecond * time.Duration(i*i))
@@ -1071,9 +1315,17 @@ func (m *dbMeta) txn(f func(s *xorm.Session) error, inodes ...Ino) error {
 		} else if err == nil && i > 1 {
 			logger.Warnf("Transaction succeeded after %d tries (%s), inodes: %v, method: %s, last error: %s", i+1, time.Since(start), inodes, method, lastErr)
 		}
+		if used := time.Since(start); m.isSlow(used) {
+			if method == "" {
+				method = callerName(ctx)
+			}
+			m.logSlow("Slow transaction %s (%s), tries: %d, inodes: %v, error: %v", method, used, i+1, inodes, err)
+		}
+		utils.EndSpan(span, err)
 		return err
 	}
 	logger.Warnf("Already tried 50 times, returning: %s", lastErr)
+	utils.EndSpan(span, lastErr)
 	return lastErr
 }
 
@@ -1135,6 +1387,12 @@ func (m *dbMeta) roTxn(ctx context.Context, f func(s *xorm.Session) error) error
 		} else if err == nil && i > 1 {
 			logger.Warnf("Read transaction succeeded after %d tries (%s), method: %s, last error: %s", i+1, time.Since(start), method, lastErr)
 		}
+		if used := time.Since(start); m.isSlow(used) {
+			if method == "" {
+				method = callerName(ctx)
+			}
+			m.logSlow("Slow read transaction %s (%s), tries: %d, error: %v", method, used, i+1, err)
+		}
 		return err
 	}
 	logger.Warnf("Already tried %d times, returning: %s", maxRetry, lastErr)
@@ -1174,6 +1432,12 @@ func (m *dbMeta) simpleTxn(ctx context.Context, f func(s *xorm.Session) error) e
 		} else if err == nil && i > 1 {
 			logger.Warnf("Simple transaction succeeded after %d tries (%s), method: %s, last error: %s", i+1, time.Since(start), method, lastErr)
 		}
+		if used := time.Since(start); m.isSlow(used) {
+			if method == "" {
+				method = callerName(ctx)
+			}
+			m.logSlow("Slow simple transaction %s (%s), tries: %d, error: %v", method, used, i+1, err)
+		}
 		return err
 	}
 	logger.Warnf("Already tried %d times, returning: %s", maxRetry, lastErr)
@@ -1268,7 +1532,7 @@ func (m *dbMeta) doSyncVolumeStat(ctx Context) error {
 		return err
 	}
 	logger.Debugf("Used space: %s, inodes: %d", humanize.IBytes(uint64(used)), inode)
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
 		if _, err := s.Cols("value").Update(&counter{Value: inode}, &counter{Name: totalInodes}); err != nil {
 			return fmt.Errorf("update totalInodes: %s", err)
 		}
@@ -1281,7 +1545,7 @@ func (m *dbMeta) doFlushStats() {
 	newSpace := atomic.LoadInt64(&m.newSpace)
 	newInodes := atomic.LoadInt64(&m.newInodes)
 	if newSpace != 0 || newInodes != 0 {
-		err := m.txn(func(s *xorm.Session) error {
+		err := m.txn(Background(), func(s *xorm.Session) error {
 			if _, err := s.Exec(m.sqlConv("update counter set value=value + ? where name='totalInodes'"), newInodes); err != nil {
 				return err
 			}
@@ -1301,9 +1565,10 @@ func (m *dbMeta) doFlushStats() {
 }
 
 func (m *dbMeta) doLookup(ctx Context, parent Ino, name string, inode *Ino, attr *Attr) syscall.Errno {
-	return errno(m.simpleTxn(ctx, func(s *xorm.Session) error {
+	var nn namedNode
+	lookup := func(s *xorm.Session) error {
 		s = s.Table(&edge{})
-		nn := namedNode{node: node{Parent: parent}, Name: []byte(name)}
+		nn = namedNode{node: node{Parent: parent}, Name: []byte(name)}
 		var exist bool
 		var err error
 		if attr != nil {
@@ -1318,15 +1583,24 @@ func (m *dbMeta) doLookup(ctx Context, parent Ino, name string, inode *Ino, attr
 		if !exist {
 			return syscall.ENOENT
 		}
+		return nil
+	}
+	if err := m.replicaTxn(ctx, lookup); err == nil {
+		*inode = nn.Inode
+		m.parseAttr(&nn.node, attr)
+		return 0
+	}
+	err := m.simpleTxn(ctx, lookup)
+	if err == nil {
 		*inode = nn.Inode
 		m.parseAttr(&nn.node, attr)
 		m.of.Update(nn.Inode, attr)
-		return nil
-	}))
+	}
+	return errno(err)
 }
 
 func (m *dbMeta) doGetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
-	return errno(m.simpleTxn(ctx, func(s *xorm.Session) error {
+	getattr := func(s *xorm.Session) error {
 		var n = node{Inode: inode}
 		ok, err := s.Get(&n)
 		if err != nil {
@@ -1336,11 +1610,15 @@ func (m *dbMeta) doGetAttr(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 		}
 		m.parseAttr(&n, attr)
 		return nil
-	}))
+	}
+	if err := m.replicaTxn(ctx, getattr); err == nil {
+		return 0
+	}
+	return errno(m.simpleTxn(ctx, getattr))
 }
 
 func (m *dbMeta) doSetAttr(ctx Context, inode Ino, set uint16, sugidclearmode uint8, attr *Attr, oldAttr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		var cur = node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&cur)
 		if err != nil {
@@ -1434,7 +1712,7 @@ func (m *dbMeta) upsertSlice(s *xorm.Session, inode Ino, indx uint32, buf []byte
 }
 
 func (m *dbMeta) doTruncate(ctx Context, inode Ino, flags uint8, length uint64, delta *dirStat, attr *Attr, skipPermCheck bool) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		*delta = dirStat{}
 		nodeAttr := node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&nodeAttr)
@@ -1504,7 +1782,7 @@ func (m *dbMeta) doTruncate(ctx Context, inode Ino, flags uint8, length uint64,
 }
 
 func (m *dbMeta) doFallocate(ctx Context, inode Ino, mode uint8, off uint64, size uint64, delta *dirStat, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		*delta = dirStat{}
 		nodeAttr := node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&nodeAttr)
@@ -1588,7 +1866,7 @@ func (m *dbMeta) doReadlink(ctx Context, inode Ino, noatime bool) (atime int64,
 
 	attr := &Attr{}
 	now := time.Now()
-	err = m.txn(func(s *xorm.Session) error {
+	err = m.txn(ctx, func(s *xorm.Session) error {
 		nodeAttr := node{Inode: inode}
 		ok, e := s.ForUpdate().Get(&nodeAttr)
 		if e != nil {
@@ -1623,7 +1901,7 @@ func (m *dbMeta) doReadlink(ctx Context, inode Ino, noatime bool) (atime int64,
 }
 
 func (m *dbMeta) doMknod(ctx Context, parent Ino, name string, _type uint8, mode, cumask uint16, path string, inode *Ino, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		var pn = node{Inode: parent}
 		ok, err := s.Get(&pn)
 		if err != nil {
@@ -1797,7 +2075,7 @@ func (m *dbMeta) doUnlink(ctx Context, parent Ino, name string, attr *Attr, skip
 	var n node
 	var opened bool
 	var newSpace, newInode int64
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		opened = false
 		newSpace, newInode = 0, 0
 		var pn = node{Inode: parent}
@@ -1838,6 +2116,7 @@ func (m *dbMeta) doUnlink(ctx Context, parent Ino, name string, attr *Attr, skip
 		if e.Type == TypeDirectory {
 			return syscall.EPERM
 		}
+		changedInode(ctx, e.Inode, e.Type)
 
 		n = node{Inode: e.Inode}
 		ok, err = s.ForUpdate().Get(&n)
@@ -1966,7 +2245,7 @@ func (m *dbMeta) doRmdir(ctx Context, parent Ino, name string, pinode *Ino, attr
 			return st
 		}
 	}
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		var pn = node{Inode: parent}
 		ok, err := s.Get(&pn)
 		if err != nil {
@@ -2122,7 +2401,7 @@ func (m *dbMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 	if !parentSrc.IsTrash() { // there should be no conflict if parentSrc is in trash, relax lock to accelerate `restore` subcommand
 		parentLocks = append(parentLocks, parentSrc)
 	}
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		opened = false
 		dino = 0
 		newSpace, newInode = 0, 0
@@ -2467,7 +2746,7 @@ func (m *dbMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 }
 
 func (m *dbMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		var pn = node{Inode: parent}
 		ok, err := s.Get(&pn)
 		if err != nil {
@@ -2521,7 +2800,15 @@ func (m *dbMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 			pn.setCtime(now)
 			updateParent = true
 		}
-		n.Parent = 0
+		tmpfile := n.Nlink == 0 // created by O_TMPFILE
+		if tmpfile {
+			n.Parent = parent
+			if _, err = s.Delete(&sustained{Sid: m.sid, Inode: inode}); err != nil {
+				return err
+			}
+		} else {
+			n.Parent = 0
+		}
 		n.Nlink++
 		n.setCtime(now)
 
@@ -2551,51 +2838,67 @@ func (m *dbMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 }
 
 func (m *dbMeta) doReaddir(ctx Context, inode Ino, plus uint8, entries *[]*Entry, limit int) syscall.Errno {
+	n := len(*entries)
+	err := m.replicaTxn(ctx, func(s *xorm.Session) error {
+		return m.readdir(s, inode, plus, entries, limit, false)
+	})
+	if err == nil && len(*entries) > n {
+		return 0
+	}
+	*entries = (*entries)[:n] // a new directory may not be replicated yet
 	return errno(m.simpleTxn(ctx, func(s *xorm.Session) error {
-		s = s.Table(&edge{})
-		if plus != 0 {
-			s = s.Join("INNER", &node{}, m.sqlConv("edge.inode=node.inode"))
-		}
-		if limit > 0 {
-			s = s.Limit(limit, 0)
+		return m.readdir(s, inode, plus, entries, limit, true)
+	}))
+}
+
+// readdir appends entries of the directory, and updates the cached attributes of open files if primary is true.
+func (m *dbMeta) readdir(s *xorm.Session, inode Ino, plus uint8, entries *[]*Entry, limit int, primary bool) error {
+	s = s.Table(&edge{})
+	if plus != 0 {
+		s = s.Join("INNER", &node{}, m.sqlConv("edge.inode=node.inode"))
+	}
+	if limit > 0 {
+		s = s.Limit(limit, 0)
+	}
+	var nodes []namedNode
+	if err := s.Find(&nodes, &edge{Parent: inode}); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if len(n.Name) == 0 {
+			logger.Errorf("Corrupt entry with empty name: inode %d parent %d", n.Inode, inode)
+			continue
 		}
-		var nodes []namedNode
-		if err := s.Find(&nodes, &edge{Parent: inode}); err != nil {
-			return err
+		entry := &Entry{
+			Inode: n.Inode,
+			Name:  n.Name,
+			Attr:  &Attr{},
 		}
-		for _, n := range nodes {
-			if len(n.Name) == 0 {
-				logger.Errorf("Corrupt entry with empty name: inode %d parent %d", n.Inode, inode)
-				continue
-			}
-			entry := &Entry{
-				Inode: n.Inode,
-				Name:  n.Name,
-				Attr:  &Attr{},
-			}
-			if plus != 0 {
-				m.parseAttr(&n.node, entry.Attr)
+		if plus != 0 {
+			m.parseAttr(&n.node, entry.Attr)
+			if primary {
 				m.of.Update(entry.Inode, entry.Attr)
-			} else {
-				entry.Attr.Typ = n.Type
 			}
-			*entries = append(*entries, entry)
+		} else {
+			entry.Attr.Typ = n.Type
 		}
-		return nil
-	}))
+		*entries = append(*entries, entry)
+	}
+	return nil
 }
 
 func (m *dbMeta) doCleanStaleSession(sid uint64) error {
 	var fail bool
 	// release locks
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(Background(), func(s *xorm.Session) error {
 		if _, err := s.Delete(flock{Sid: sid}); err != nil {
 			return err
 		}
 		if _, err := s.Delete(plock{Sid: sid}); err != nil {
 			return err
 		}
-		return nil
+		_, err := s.Delete(&lockWaiting{Sid: sid})
+		return err
 	})
 	if err != nil {
 		logger.Warnf("Delete flock/plock with sid %d: %s", sid, err)
@@ -2622,7 +2925,7 @@ func (m *dbMeta) doCleanStaleSession(sid uint64) error {
 	if fail {
 		return fmt.Errorf("failed to clean up sid %d", sid)
 	} else {
-		return m.txn(func(s *xorm.Session) error {
+		return m.txn(Background(), func(s *xorm.Session) error {
 			if n, err := s.Delete(&session2{Sid: sid}); err != nil {
 				return err
 			} else if n == 1 {
@@ -2679,7 +2982,7 @@ func (m *dbMeta) doFindStaleSessions(limit int) ([]uint64, error) {
 }
 
 func (m *dbMeta) doRefreshSession() error {
-	return m.txn(func(ses *xorm.Session) error {
+	return m.txn(Background(), func(ses *xorm.Session) error {
 		n, err := ses.Cols("Expire").Update(&session2{Expire: m.expireTime()}, &session2{Sid: m.sid})
 		if err == nil && n == 0 {
 			logger.Warnf("Session %d was stale and cleaned up, but now it comes back again", m.sid)
@@ -2692,7 +2995,7 @@ func (m *dbMeta) doRefreshSession() error {
 func (m *dbMeta) doDeleteSustainedInode(sid uint64, inode Ino) error {
 	var n = node{Inode: inode}
 	var newSpace int64
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(Background(), func(s *xorm.Session) error {
 		newSpace = 0
 		n = node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&n)
@@ -2731,8 +3034,8 @@ func (m *dbMeta) doRead(ctx Context, inode Ino, indx uint32) ([]*slice, syscall.
 	return readSliceBuf(c.Slices), 0
 }
 
-func (m *dbMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+func (m *dbMeta) doWrite(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		*delta = dirStat{}
 		nodeAttr := node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&nodeAttr)
@@ -2742,10 +3045,13 @@ func (m *dbMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice
 		if !ok {
 			return syscall.ENOENT
 		}
-		if nodeAttr.Type != TypeFile {
+		if nodeAttr.Type != TypeFile || nodeAttr.Flags&FlagImmutable != 0 {
 			return syscall.EPERM
 		}
-		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
+		if nodeAttr.Flags&FlagAppend != 0 && extentsStart(indx, extents) < nodeAttr.Length {
+			return syscall.EPERM // only appending is allowed
+		}
+		newleng := extentsEnd(indx, extents)
 		if newleng > nodeAttr.Length {
 			delta.length = int64(newleng - nodeAttr.Length)
 			delta.space = align4K(newleng) - align4K(nodeAttr.Length)
@@ -2758,12 +3064,15 @@ func (m *dbMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice
 		nodeAttr.setCtime(time.Now().UnixNano())
 		m.parseAttr(&nodeAttr, attr)
 
-		buf := marshalSlice(off, slice.Id, slice.Size, slice.Off, slice.Len)
+		buf := make([]byte, 0, sliceBytes*len(extents))
+		for _, e := range extents {
+			buf = append(buf, marshalSlice(e.Pos, e.Id, e.Size, e.Off, e.Len)...)
+		}
 		var insert bool // no compaction check for the first slice
 		if err = m.upsertSlice(s, inode, indx, buf, &insert); err != nil {
 			return err
 		}
-		if err = mustInsert(s, sliceRef{slice.Id, slice.Size, 1}); err != nil {
+		if err = mustInsert(s, sliceRef{extents[0].Id, extents[0].Size, len(extents)}); err != nil {
 			return err
 		}
 		_, err = s.Cols("length", "mtime", "ctime", "mtimensec", "ctimensec").Update(&nodeAttr, &node{Inode: inode})
@@ -2785,8 +3094,11 @@ func (m *dbMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 	}
 	var newLength, newSpace int64
 	var nin, nout node
-	defer func() { m.of.InvalidateChunk(fout, invalidateAllChunks) }()
-	err := m.txn(func(s *xorm.Session) error {
+	defer func() { m.of.InvalidateChunk(fout, InvalidateAllChunks) }()
+	ctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: fout}, func(e *ChangeEvent) {
+		e.Parent = nout.Parent
+	})
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		newLength, newSpace = 0, 0
 		nin = node{Inode: fin}
 		nout = node{Inode: fout}
@@ -2904,6 +3216,7 @@ func (m *dbMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 		}
 		return nil
 	}, fout)
+	m.endChange(changes, errno(err))
 	if err == nil {
 		m.updateParentStat(ctx, fout, nout.Parent, newLength, newSpace)
 		if newSpace > 0 {
@@ -2949,6 +3262,46 @@ func (m *dbMeta) doGetParents(ctx Context, inode Ino) map[Ino]int {
 	return ps
 }
 
+// appendChanges appends the event of the operation in its transaction.
+func (m *dbMeta) appendChanges(ctx context.Context, s *xorm.Session) error {
+	c := pendingChanges(ctx)
+	if c == nil {
+		return nil
+	}
+	seq, err := m.incrSessionCounter(s, "nextChangelog", 1)
+	if err != nil {
+		return err
+	}
+	c.event.Seq = uint64(seq)
+	return mustInsert(s, &changelog{Id: c.event.Seq, Data: c.event.marshal()})
+}
+
+func (m *dbMeta) doReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error) {
+	var rows []changelog
+	if err := m.roTxn(ctx, func(s *xorm.Session) error {
+		rows = nil
+		return s.Where("id > ?", after).OrderBy("id").Limit(limit).Find(&rows)
+	}); err != nil {
+		return nil, err
+	}
+	events := make([]*ChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		var e ChangeEvent
+		if err := e.unmarshal(row.Data); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (m *dbMeta) doDeleteChangelog(ctx Context, upto uint64) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
+		_, err := s.Where("id <= ?", upto).Delete(&changelog{})
+		return err
+	})
+}
+
 func (m *dbMeta) doUpdateDirStat(ctx Context, batch map[Ino]dirStat) error {
 	table := m.db.GetTableMapper().Obj2Table("dirStats")
 	fileLengthColumn := m.db.GetColumnMapper().Obj2Table("DataLength")
@@ -2965,7 +3318,7 @@ func (m *dbMeta) doUpdateDirStat(ctx Context, batch map[Ino]dirStat) error {
 	nonexist := make(map[Ino]bool, 0)
 
 	for _, group := range m.groupBatch(batch, 1000) {
-		err := m.txn(func(s *xorm.Session) error {
+		err := m.txn(ctx, func(s *xorm.Session) error {
 			for _, ino := range group {
 				stat := batch[ino]
 				ret, err := s.Exec(sql, stat.length, stat.space, stat.inodes, ino)
@@ -3001,7 +3354,7 @@ func (m *dbMeta) doSyncDirStat(ctx Context, ino Ino) (*dirStat, syscall.Errno) {
 	if st != 0 {
 		return nil, st
 	}
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		exist, err := s.Exist(&node{Inode: ino})
 		if err != nil {
 			return err
@@ -3046,7 +3399,7 @@ func (m *dbMeta) doGetDirStat(ctx Context, ino Ino, trySync bool) (*dirStat, sys
 			return nil, eno
 		}
 		st.DataLength, st.UsedSpace, st.UsedInodes = stat.length, stat.space, stat.inodes
-		e := m.txn(func(s *xorm.Session) error {
+		e := m.txn(ctx, func(s *xorm.Session) error {
 			n, err := s.Cols("data_length", "used_space", "used_inodes").Update(&st, &dirStats{Inode: ino})
 			if err == nil && n != 1 {
 				err = errors.Errorf("update dir usage of inode %d: %d rows affected", ino, n)
@@ -3092,7 +3445,7 @@ func (m *dbMeta) doCleanupSlices(ctx Context) {
 
 func (m *dbMeta) deleteChunk(inode Ino, indx uint32) error {
 	var ss []*slice
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(Background(), func(s *xorm.Session) error {
 		ss = ss[:0]
 		var c = chunk{Inode: inode, Indx: indx}
 		ok, err := s.ForUpdate().MustCols("indx").Get(&c)
@@ -3157,7 +3510,7 @@ func (m *dbMeta) doDeleteFileData(inode Ino, length uint64) {
 			return
 		}
 	}
-	_ = m.txn(func(s *xorm.Session) error {
+	_ = m.txn(Background(), func(s *xorm.Session) error {
 		_, err := s.Delete(delfile{Inode: inode})
 		return err
 	})
@@ -3175,7 +3528,7 @@ func (m *dbMeta) doCleanupDelayedSlices(ctx Context, edge int64) (int, error) {
 		})
 
 		for _, ds := range result {
-			if err := m.txn(func(ses *xorm.Session) error {
+			if err := m.txn(ctx, func(ses *xorm.Session) error {
 				ss = ss[:0]
 				ds := delslices{Id: ds.Id}
 				if ok, e := ses.ForUpdate().Get(&ds); e != nil {
@@ -3224,7 +3577,7 @@ func (m *dbMeta) doCleanupDelayedSlices(ctx Context, edge int64) (int, error) {
 }
 
 func (m *dbMeta) doCompactChunk(inode Ino, indx uint32, origin []byte, ss []*slice, skipped int, pos uint32, id uint64, size uint32, delayed []byte) syscall.Errno {
-	st := errno(m.txn(func(s *xorm.Session) error {
+	st := errno(m.txn(Background(), func(s *xorm.Session) error {
 		var c2 = chunk{Inode: inode, Indx: indx}
 		_, err := s.ForUpdate().MustCols("indx").Get(&c2)
 		if err != nil {
@@ -3279,7 +3632,7 @@ func (m *dbMeta) doCompactChunk(inode Ino, indx uint32, origin []byte, ss []*sli
 	}
 
 	if st == syscall.EINVAL {
-		_ = m.txn(func(s *xorm.Session) error {
+		_ = m.txn(Background(), func(s *xorm.Session) error {
 			return mustInsert(s, &sliceRef{id, size, 0})
 		})
 	} else if st == 0 && delayed == nil {
@@ -3400,7 +3753,7 @@ func (m *dbMeta) scanTrashSlices(ctx Context, scan trashSliceScan) error {
 	var ss []Slice
 	for _, ds := range dss {
 		var clean bool
-		err = m.txn(func(tx *xorm.Session) error {
+		err = m.txn(ctx, func(tx *xorm.Session) error {
 			ss = ss[:0]
 			del := delslices{Id: ds.Id}
 			found, err := tx.Get(&del)
@@ -3517,15 +3870,17 @@ func (m *dbMeta) doRepair(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 	n.setAtime(attr.Atime*1e9 + int64(attr.Atimensec))
 	n.setMtime(attr.Mtime*1e9 + int64(attr.Mtimensec))
 	n.setCtime(attr.Ctime*1e9 + int64(attr.Ctimensec))
-	return errno(m.txn(func(s *xorm.Session) error {
-		n.Nlink = 2
-		var rows []edge
-		if err := s.Find(&rows, &edge{Parent: inode}); err != nil {
-			return err
-		}
-		for _, row := range rows {
-			if row.Type == TypeDirectory {
-				n.Nlink++
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
+		if n.Type == TypeDirectory {
+			n.Nlink = 2
+			var rows []edge
+			if err := s.Find(&rows, &edge{Parent: inode}); err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if row.Type == TypeDirectory {
+					n.Nlink++
+				}
 			}
 		}
 		ok, err := s.ForUpdate().Get(&node{Inode: inode})
@@ -3594,7 +3949,7 @@ func (m *dbMeta) ListXattr(ctx Context, inode Ino, names *[]byte) syscall.Errno
 }
 
 func (m *dbMeta) doSetXattr(ctx Context, inode Ino, name string, value []byte, flags uint32) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		var k = &xattr{Inode: inode, Name: name}
 		var x = xattr{Inode: inode, Name: name, Value: value}
 		ok, err := s.ForUpdate().Get(k)
@@ -3626,7 +3981,7 @@ func (m *dbMeta) doSetXattr(ctx Context, inode Ino, name string, value []byte, f
 }
 
 func (m *dbMeta) doRemoveXattr(ctx Context, inode Ino, name string) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		n, err := s.Delete(&xattr{Inode: inode, Name: name})
 		if err != nil {
 			return err
@@ -3653,7 +4008,11 @@ func (m *dbMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, erro
 					MaxSpace:   q.MaxSpace,
 					MaxInodes:  q.MaxInodes,
 					UsedSpace:  q.UsedSpace,
-					UsedInodes: q.UsedInodes}
+					UsedInodes: q.UsedInodes,
+					SoftSpace:  q.SoftSpace,
+					SoftInodes: q.SoftInodes,
+					Grace:      q.Grace,
+					Exceeded:   q.Exceeded}
 			}
 			return e
 		} else {
@@ -3664,7 +4023,11 @@ func (m *dbMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, erro
 					MaxSpace:   q.MaxSpace,
 					MaxInodes:  q.MaxInodes,
 					UsedSpace:  q.UsedSpace,
-					UsedInodes: q.UsedInodes}
+					UsedInodes: q.UsedInodes,
+					SoftSpace:  q.SoftSpace,
+					SoftInodes: q.SoftInodes,
+					Grace:      q.Grace,
+					Exceeded:   q.Exceeded}
 			}
 			return e
 		}
@@ -3672,8 +4035,8 @@ func (m *dbMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, erro
 	return quota, err
 }
 
-func updateQuotaFields(quota *Quota, exist bool, maxSpace, maxInodes *int64, usedSpace, usedInodes *int64) []string {
-	updateColumns := make([]string, 0, 4)
+func updateQuotaFields(quota *Quota, exist bool, maxSpace, maxInodes *int64, usedSpace, usedInodes *int64, limits ...*int64) []string {
+	updateColumns := make([]string, 0, 8)
 	if quota.MaxSpace >= 0 {
 		*maxSpace = quota.MaxSpace
 		updateColumns = append(updateColumns, "max_space")
@@ -3696,13 +4059,20 @@ func updateQuotaFields(quota *Quota, exist bool, maxSpace, maxInodes *int64, use
 		*usedInodes = 0
 		updateColumns = append(updateColumns, "used_inodes")
 	}
+	// soft_space, soft_inodes and grace, exceeded is only set by doSetQuotaExceeded
+	for i, v := range []int64{quota.SoftSpace, quota.SoftInodes, quota.Grace} {
+		if v >= 0 {
+			*limits[i] = v
+			updateColumns = append(updateColumns, []string{"soft_space", "soft_inodes", "grace"}[i])
+		}
+	}
 
 	return updateColumns
 }
 
 func (m *dbMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota) (bool, error) {
 	var created bool
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		if qtype == DirQuotaType {
 			origin := &dirQuota{Inode: Ino(key)}
 			exist, e := s.ForUpdate().Get(origin)
@@ -3710,7 +4080,8 @@ func (m *dbMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota)
 				return e
 			}
 			created = !exist
-			updateColumns := updateQuotaFields(quota, exist, &origin.MaxSpace, &origin.MaxInodes, &origin.UsedSpace, &origin.UsedInodes)
+			updateColumns := updateQuotaFields(quota, exist, &origin.MaxSpace, &origin.MaxInodes, &origin.UsedSpace, &origin.UsedInodes,
+				&origin.SoftSpace, &origin.SoftInodes, &origin.Grace)
 			if exist {
 				_, e = s.Cols(updateColumns...).Update(origin, &dirQuota{Inode: Ino(key)})
 			} else {
@@ -3724,7 +4095,8 @@ func (m *dbMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota)
 				return e
 			}
 			created = !exist
-			updateColumns := updateQuotaFields(quota, exist, &origin.MaxSpace, &origin.MaxInodes, &origin.UsedSpace, &origin.UsedInodes)
+			updateColumns := updateQuotaFields(quota, exist, &origin.MaxSpace, &origin.MaxInodes, &origin.UsedSpace, &origin.UsedInodes,
+				&origin.SoftSpace, &origin.SoftInodes, &origin.Grace)
 			if exist {
 				_, e = s.Cols(updateColumns...).Update(origin, &userGroupQuota{Qtype: qtype, Qkey: key})
 			} else {
@@ -3739,17 +4111,53 @@ func (m *dbMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota)
 	return created, err
 }
 
+func (m *dbMeta) doSetQuotaExceeded(ctx Context, qtype uint32, key uint64, old, exceeded int64) (int64, error) {
+	var cur int64
+	err := m.txn(ctx, func(s *xorm.Session) error {
+		var bean, cond interface{}
+		if qtype == DirQuotaType {
+			bean, cond = &dirQuota{Exceeded: exceeded}, &dirQuota{Inode: Ino(key)}
+		} else if qtype == UserQuotaType || qtype == GroupQuotaType {
+			bean, cond = &userGroupQuota{Exceeded: exceeded}, &userGroupQuota{Qtype: qtype, Qkey: key}
+		} else {
+			return errors.Errorf("invalid quota type %d", qtype)
+		}
+		n, e := s.Cols("exceeded").Where("exceeded = ?", old).Update(bean, cond)
+		if e != nil || n > 0 {
+			cur = exceeded
+			return e
+		}
+		// changed by others or removed
+		cur = 0
+		if qtype == DirQuotaType {
+			q := &dirQuota{Inode: Ino(key)}
+			if ok, e := s.Get(q); e != nil || !ok {
+				return e
+			}
+			cur = q.Exceeded
+		} else {
+			q := &userGroupQuota{Qtype: qtype, Qkey: key}
+			if ok, e := s.Get(q); e != nil || !ok {
+				return e
+			}
+			cur = q.Exceeded
+		}
+		return nil
+	})
+	return cur, err
+}
+
 func (m *dbMeta) doDelQuota(ctx Context, qtype uint32, key uint64) error {
 	if qtype != DirQuotaType && qtype != UserQuotaType && qtype != GroupQuotaType {
 		return errors.Errorf("invalid quota type %d", qtype)
 	}
 
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
 		if qtype == DirQuotaType {
 			_, e := s.Delete(&dirQuota{Inode: Ino(key)})
 			return e
 		} else {
-			_, e := s.Cols("max_space", "max_inodes").
+			_, e := s.Cols("max_space", "max_inodes", "soft_space", "soft_inodes", "grace", "exceeded").
 				Update(&userGroupQuota{MaxSpace: -1, MaxInodes: -1},
 					&userGroupQuota{Qtype: qtype, Qkey: key})
 			return e
@@ -3785,6 +4193,10 @@ func (m *dbMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota
 			MaxInodes:  q.MaxInodes,
 			UsedSpace:  q.UsedSpace,
 			UsedInodes: q.UsedInodes,
+			SoftSpace:  q.SoftSpace,
+			SoftInodes: q.SoftInodes,
+			Grace:      q.Grace,
+			Exceeded:   q.Exceeded,
 		}
 		dirQuotas[uint64(q.Inode)] = quota
 	}
@@ -3796,6 +4208,10 @@ func (m *dbMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota
 			MaxInodes:  q.MaxInodes,
 			UsedSpace:  q.UsedSpace,
 			UsedInodes: q.UsedInodes,
+			SoftSpace:  q.SoftSpace,
+			SoftInodes: q.SoftInodes,
+			Grace:      q.Grace,
+			Exceeded:   q.Exceeded,
 		}
 
 		switch q.Qtype {
@@ -3811,7 +4227,7 @@ func (m *dbMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota
 
 func (m *dbMeta) doFlushQuotas(ctx Context, quotas []*iQuota) error {
 	sort.Slice(quotas, func(i, j int) bool { return quotas[i].qkey < quotas[j].qkey })
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
 		for _, q := range quotas {
 			if q.qtype == DirQuotaType {
 				logger.Infof("doFlushquot ino:%d, %+v", q.qkey, q.quota)
@@ -4299,7 +4715,7 @@ func (m *dbMeta) DumpMeta(w io.Writer, root Ino, threads int, keepSecret, fast,
 		// todo Add user/group quota
 		dumpedQuotas := make(map[Ino]*DumpedQuota, len(qs))
 		for _, q := range qs {
-			dumpedQuotas[Ino(q.Inode)] = &DumpedQuota{q.MaxSpace, q.MaxInodes, 0, 0}
+			dumpedQuotas[Ino(q.Inode)] = &DumpedQuota{MaxSpace: q.MaxSpace, MaxInodes: q.MaxInodes, SoftSpace: q.SoftSpace, SoftInodes: q.SoftInodes, Grace: q.Grace}
 		}
 
 		dm := DumpedMeta{
@@ -4477,7 +4893,7 @@ func (m *dbMeta) LoadMeta(r io.Reader) error {
 	batch := m.getTxnBatchNum()
 	chs := make([]chan interface{}, 6) // node, edge, chunk, chunkRef, xattr, others
 	insert := func(index int, beans []interface{}) error {
-		return m.txn(func(s *xorm.Session) error {
+		return m.txn(Background(), func(s *xorm.Session) error {
 			var n int64
 			var err error
 			if index == len(chs)-1 { // multiple tables
@@ -4544,7 +4960,7 @@ func (m *dbMeta) LoadMeta(r io.Reader) error {
 	wg.Wait()
 
 	// update chunkRefs
-	if err = m.txn(func(s *xorm.Session) error {
+	if err = m.txn(Background(), func(s *xorm.Session) error {
 		for k, v := range refs {
 			if v > 1 {
 				if _, e := s.Cols("refs").Update(&sliceRef{Refs: int(v)}, &sliceRef{Id: k.id}); e != nil {
@@ -4558,7 +4974,7 @@ func (m *dbMeta) LoadMeta(r io.Reader) error {
 	}
 
 	// update nlinks and parents for hardlinks
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(Background(), func(s *xorm.Session) error {
 		for i, ps := range parents {
 			if len(ps) > 1 {
 				_, err := s.Cols("nlink", "parent").Update(&node{Nlink: uint32(len(ps))}, &node{Inode: i})
@@ -4585,7 +5001,7 @@ func isDuplicateEntryErr(err error) bool {
 }
 
 func (m *dbMeta) doCloneEntry(ctx Context, srcIno Ino, parent Ino, name string, ino Ino, attr *Attr, cmode uint8, cumask uint16, top bool) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		n := node{Inode: srcIno}
 		ok, err := s.ForUpdate().Get(&n)
 		if err != nil {
@@ -4746,7 +5162,7 @@ func (m *dbMeta) doCleanupDetachedNode(ctx Context, ino Ino) syscall.Errno {
 		return eno
 	}
 	m.updateStats(-align4K(0), -1)
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		if _, err := s.Delete(&node{Inode: ino}); err != nil {
 			return err
 		}
@@ -4762,7 +5178,7 @@ func (m *dbMeta) doCleanupDetachedNode(ctx Context, ino Ino) syscall.Errno {
 }
 
 func (m *dbMeta) doAttachDirNode(ctx Context, parent Ino, inode Ino, name string) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		// must lock parent node first to avoid deadlock
 		var n = node{Inode: parent}
 		ok, err := s.ForUpdate().Get(&n)
@@ -4799,9 +5215,86 @@ func (m *dbMeta) doAttachDirNode(ctx Context, parent Ino, inode Ino, name string
 	}, parent))
 }
 
+func (m *dbMeta) doDetachDir(ctx Context, parent Ino, name string, pinode *Ino, attr *Attr) syscall.Errno {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
+		var pn = node{Inode: parent}
+		ok, err := s.ForUpdate().Get(&pn)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return syscall.ENOENT
+		}
+		if pn.Type != TypeDirectory {
+			return syscall.ENOTDIR
+		}
+		var pattr Attr
+		m.parseAttr(&pn, &pattr)
+		if st := m.Access(ctx, parent, MODE_MASK_W|MODE_MASK_X, &pattr); st != 0 {
+			return st
+		}
+		if pn.Flags&FlagImmutable != 0 || pn.Flags&FlagAppend != 0 {
+			return syscall.EPERM
+		}
+		var e = edge{Parent: parent, Name: []byte(name)}
+		ok, err = s.Get(&e)
+		if err != nil {
+			return err
+		}
+		if !ok && m.conf.CaseInsensi {
+			if ee := m.resolveCase(ctx, parent, name); ee != nil {
+				ok = true
+				e.Inode = ee.Inode
+				e.Name = ee.Name
+				e.Type = ee.Attr.Typ
+			}
+		}
+		if !ok {
+			return syscall.ENOENT
+		}
+		if e.Type != TypeDirectory {
+			return syscall.ENOTDIR
+		}
+		var n = node{Inode: e.Inode}
+		ok, err = s.ForUpdate().Get(&n)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return syscall.ENOENT
+		}
+		if ctx.Uid() != 0 && pn.Mode&01000 != 0 && ctx.Uid() != pn.Uid && ctx.Uid() != n.Uid {
+			return syscall.EACCES
+		}
+		now := time.Now().UnixNano()
+		pn.Nlink--
+		pn.setMtime(now)
+		pn.setCtime(now)
+		n.Flags |= FlagDeleting
+		n.setCtime(now)
+		*pinode = e.Inode
+		m.parseAttr(&n, attr)
+
+		if _, err := s.Delete(&edge{Parent: parent, Name: e.Name}); err != nil {
+			return err
+		}
+		if _, err = s.Delete(&dirQuota{Inode: e.Inode}); err != nil {
+			return err
+		}
+		if _, err = s.Cols("flags", "ctime", "ctimensec").Update(&n, &node{Inode: n.Inode}); err != nil {
+			return err
+		}
+		if err = mustInsert(s, &detachedNode{Inode: e.Inode, Added: now / 1e9}); err != nil {
+			return err
+		}
+		_, err = s.SetExpr("nlink", "nlink - 1").Cols("nlink", "mtime", "ctime", "mtimensec", "ctimensec").Update(&pn, &node{Inode: pn.Inode})
+		return err
+	}))
+}
+
 func (m *dbMeta) doTouchAtime(ctx Context, inode Ino, attr *Attr, now time.Time) (bool, error) {
 	var updated bool
-	err := m.txn(func(s *xorm.Session) error {
+	err := m.txn(ctx, func(s *xorm.Session) error {
 		curNode := node{Inode: inode}
 		ok, err := s.ForUpdate().Get(&curNode)
 		if err != nil {
@@ -4890,7 +5383,7 @@ func (m *dbMeta) getACL(s *xorm.Session, id uint32) (*aclAPI.Rule, error) {
 }
 
 func (m *dbMeta) doSetFacl(ctx Context, ino Ino, aclType uint8, rule *aclAPI.Rule) syscall.Errno {
-	return errno(m.txn(func(s *xorm.Session) error {
+	return errno(m.txn(ctx, func(s *xorm.Session) error {
 		attr := &Attr{}
 		n := &node{Inode: ino}
 		if ok, err := s.ForUpdate().Get(n); err != nil {
@@ -5004,7 +5497,7 @@ func (m *dbMeta) loadDumpedACLs(ctx Context) error {
 		acls = append(acls, aclV)
 	}
 
-	return m.txn(func(s *xorm.Session) error {
+	return m.txn(ctx, func(s *xorm.Session) error {
 		n, err := s.Insert(acls)
 		if err != nil {
 			return err
@@ -436,6 +436,9 @@ func (m *dbMeta) dumpQuota(ctx Context, opt *DumpOption, ch chan<- *dumpedResult
 			MaxInodes:  q.MaxInodes,
 			UsedSpace:  q.UsedSpace,
 			UsedInodes: q.UsedInodes,
+			SoftSpace:  q.SoftSpace,
+			SoftInodes: q.SoftInodes,
+			Grace:      q.Grace,
 		})
 	}
 	return dumpResult(ctx, ch, &dumpedResult{msg: &pb.Batch{Quotas: quotas}})
@@ -565,7 +568,7 @@ func insertSliceRefs(m *dbMeta, ss []*sliceRef) error {
 	batch := m.getTxnBatchNum()
 	for len(ss) > 0 {
 		bs := min(batch, len(ss))
-		err := m.txn(func(s *xorm.Session) error {
+		err := m.txn(Background(), func(s *xorm.Session) error {
 			nStmt := genMultiSQL(stmt, bs)
 			rows := make([]interface{}, 0, 1+bs*3)
 			rows = append(rows, nStmt)
@@ -676,7 +679,7 @@ func (m *dbMeta) loadSliceRefs(ctx Context, msg proto.Message) error {
 	srs := msg.(*pb.Batch).SliceRefs
 	for len(srs) > 0 {
 		num := min(batch, len(srs))
-		err := m.txn(func(s *xorm.Session) error {
+		err := m.txn(ctx, func(s *xorm.Session) error {
 			var err error
 			for i := 0; i < num; i++ {
 				if err = m.upsertSliceRef(s, srs[i].Id, srs[i].Size, int(srs[i].Refs)); err != nil {
@@ -726,6 +729,9 @@ func (m *dbMeta) loadQuota(ctx Context, msg proto.Message) error {
 			MaxInodes:  q.MaxInodes,
 			UsedSpace:  q.UsedSpace,
 			UsedInodes: q.UsedInodes,
+			SoftSpace:  q.SoftSpace,
+			SoftInodes: q.SoftInodes,
+			Grace:      q.Grace,
 		})
 	}
 	return m.insertRows(rows)
@@ -749,7 +755,7 @@ func (m *dbMeta) insertRows(beans []interface{}) error {
 	batch := m.getTxnBatchNum()
 	for len(beans) > 0 {
 		bs := min(batch, len(beans))
-		err := m.txn(func(s *xorm.Session) error {
+		err := m.txn(Background(), func(s *xorm.Session) error {
 			n, err := s.Insert(beans[:bs])
 			if err == nil && int(n) != bs {
 				err = fmt.Errorf("only %d records inserted", n)
@@ -31,14 +31,15 @@ import (
 func (m *dbMeta) Flock(ctx Context, inode Ino, owner_ uint64, ltype uint32, block bool) syscall.Errno {
 	owner := int64(owner_)
 	if ltype == F_UNLCK {
-		return errno(m.txn(func(s *xorm.Session) error {
+		return errno(m.txn(ctx, func(s *xorm.Session) error {
 			_, err := s.MustCols("inode", "owner", "sid").Delete(&flock{Inode: inode, Owner: owner, Sid: m.sid})
 			return err
 		}, inode))
 	}
 	var err syscall.Errno
+	var w *lockWait
 	for {
-		err = errno(m.txn(func(s *xorm.Session) error {
+		err = errno(m.txn(ctx, func(s *xorm.Session) error {
 			if exists, err := s.ForUpdate().Get(&node{Inode: inode}); err != nil || !exists {
 				if err == nil && !exists {
 					err = syscall.ENOENT
@@ -92,6 +93,13 @@ func (m *dbMeta) Flock(ctx Context, inode Ino, owner_ uint64, ltype uint32, bloc
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner_, Flock: true, Type: ltype}
+			defer m.doneLock(w)
+		}
+		if st := m.waitLock(ctx, w, m.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -159,8 +167,9 @@ func (m *dbMeta) Setlk(ctx Context, inode Ino, owner_ uint64, block bool, ltype
 	var err syscall.Errno
 	lock := plockRecord{ltype, pid, start, end}
 	owner := int64(owner_)
+	var w *lockWait
 	for {
-		err = errno(m.txn(func(s *xorm.Session) error {
+		err = errno(m.txn(ctx, func(s *xorm.Session) error {
 			if exists, err := s.ForUpdate().Get(&node{Inode: inode}); err != nil || !exists {
 				if err == nil && !exists {
 					err = syscall.ENOENT
@@ -236,6 +245,13 @@ func (m *dbMeta) Setlk(ctx Context, inode Ino, owner_ uint64, block bool, ltype
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner_, Type: ltype, Start: start, End: end, Pid: pid}
+			defer m.doneLock(w)
+		}
+		if st := m.waitLock(ctx, w, m.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -42,9 +42,10 @@ type Statistic struct {
 }
 
 type Sections struct {
-	Setting  *Format
-	Sessions []*Session
-	Stat     *Statistic
+	Setting   *Format
+	Sessions  []*Session
+	Stat      *Statistic
+	Deletions []*Deletion `json:",omitempty"` // directories being deleted in background
 }
 
 // Status retrieves the status of the filesystem
@@ -109,10 +110,16 @@ func Status(ctx context.Context, m Meta, trash bool, sections *Sections) error {
 		stat.PendingDeletedFileCount, stat.PendingDeletedFileSize = pendingDeletedFileSpinner.Current()
 	}
 
+	deletions, st := m.ListDeletions(Background(), trash)
+	if st != 0 {
+		return fmt.Errorf("list deletions: %s", st)
+	}
+
 	if sections != nil {
 		sections.Setting = format
 		sections.Sessions = sessions
 		sections.Stat = stat
+		sections.Deletions = deletions
 	}
 	return nil
 }
@@ -40,6 +40,8 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/juicedata/juicefs/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type kvtxn interface {
@@ -113,6 +115,7 @@ func newKVMeta(driver, addr string, conf *Config) (Meta, error) {
 }
 
 func (m *kvMeta) Shutdown() error {
+	m.closeSlowLog()
 	return m.client.close()
 }
 
@@ -120,8 +123,48 @@ func (m *kvMeta) Name() string {
 	return m.client.name()
 }
 
+func (m *kvMeta) engineStats() map[string]float64 {
+	if c, ok := m.client.(engineStats); ok {
+		return c.engineStats()
+	}
+	return nil
+}
+
 func (m *kvMeta) doDeleteSlice(id uint64, size uint32) error {
-	return m.deleteKeys(m.sliceKey(id, size))
+	return m.deleteKeys(m.sliceKey(id, size), m.checksumKey(id))
+}
+
+func (m *kvMeta) doSetChecksums(ctx Context, id uint64, sums []byte) error {
+	return m.txn(ctx, func(tx *kvTxn) error {
+		tx.set(m.checksumKey(id), sums)
+		return nil
+	})
+}
+
+func (m *kvMeta) doGetChecksums(ctx Context, id uint64) ([]byte, error) {
+	return m.get(m.checksumKey(id))
+}
+
+func (m *kvMeta) doSetLockWaits(ctx Context, sid uint64, waits []byte) error {
+	if waits == nil {
+		return m.deleteKeys(m.fmtKey("SW", sid))
+	}
+	return m.txn(ctx, func(tx *kvTxn) error {
+		tx.set(m.fmtKey("SW", sid), waits)
+		return nil
+	})
+}
+
+func (m *kvMeta) doListLockWaits(ctx Context) (map[uint64][]byte, error) {
+	vals, err := m.scanValues(ctx, m.fmtKey("SW"), -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	waits := make(map[uint64][]byte, len(vals))
+	for k, v := range vals {
+		waits[m.parseSid(k)] = v
+	}
+	return waits, nil
 }
 
 func (m *kvMeta) keyLen(args ...interface{}) int {
@@ -177,6 +220,7 @@ func (m *kvMeta) fmtKey(args ...interface{}) []byte {
 
 All keys:
   setting            format
+  Bcccccccc          block checksums of slice
   C...               counter
   AiiiiiiiiI         inode attribute
   AiiiiiiiiD...      dentry
@@ -185,6 +229,7 @@ All keys:
   AiiiiiiiiS         symlink target
   AiiiiiiiiX...      extented attribute
   Diiiiiiiillllllll  delete inodes
+  Eeeeeeeee          changelog event
   Fiiiiiiii          Flocks
   Piiiiiiii          POSIX locks
   Kccccccccnnnn      slice refs
@@ -192,10 +237,12 @@ All keys:
   SEssssssss         session expire time
   SHssssssss         session heartbeat // for legacy client
   SIssssssss         session info
+  SWssssssss         blocking lock requests of session
   SSssssssssiiiiiiii sustained inode
   Uiiiiiiii          data length, space and inodes usage in directory
   Niiiiiiii          detached inde
   QDiiiiiiii         directory quota
+  QSDiiiiiiii        soft limits of directory quota (QSU/QSG for user/group quota)
   Raaaa			     POSIX acl
 */
 
@@ -219,6 +266,14 @@ func (m *kvMeta) sliceKey(id uint64, size uint32) []byte {
 	return m.fmtKey("K", id, size)
 }
 
+func (m *kvMeta) checksumKey(id uint64) []byte {
+	return m.fmtKey("B", id)
+}
+
+func (m *kvMeta) changelogKey(seq uint64) []byte {
+	return m.fmtKey("E", seq)
+}
+
 func (m *kvMeta) delSliceKey(ts int64, id uint64) []byte {
 	return m.fmtKey("L", uint64(ts), id)
 }
@@ -278,7 +333,7 @@ func (m *kvMeta) parseACLId(key string) uint32 {
 }
 
 func (m *kvMeta) parseSid(key string) uint64 {
-	buf := []byte(key[2:]) // "SE" or "SH"
+	buf := []byte(key[2:]) // "SE", "SH" or "SW"
 	if len(buf) != 8 {
 		panic("invalid sid value")
 	}
@@ -487,6 +542,8 @@ func (m *kvMeta) doInit(format *Format, force bool) error {
 			err := m.client.txn(Background(), func(tx *kvTxn) error {
 				tx.deleteKeys(userPrefix)
 				tx.deleteKeys(groupPrefix)
+				tx.deleteKeys(m.fmtKey("QSU"))
+				tx.deleteKeys(m.fmtKey("QSG"))
 				return nil
 			}, 0)
 			if err != nil {
@@ -678,7 +735,7 @@ func (m *kvMeta) doCleanStaleSession(sid uint64) error {
 	if fail {
 		return fmt.Errorf("failed to clean up sid %d", sid)
 	} else {
-		return m.deleteKeys(m.sessionKey(sid), m.legacySessionKey(sid), m.sessionInfoKey(sid))
+		return m.deleteKeys(m.sessionKey(sid), m.legacySessionKey(sid), m.sessionInfoKey(sid), m.fmtKey("SW", sid))
 	}
 }
 
@@ -836,21 +893,32 @@ func (m *kvMeta) txn(ctx context.Context, f func(tx *kvTxn) error, inodes ...Ino
 	}
 	start := time.Now()
 	defer func() { m.txDist.Observe(time.Since(start).Seconds()) }()
+	span := utils.StartSpan(ctx, "meta.txn")
 	defer m.txBatchLock(inodes...)()
 	var (
 		lastErr error
 		method  string
 	)
 	for i := 0; i < 50; i++ {
-		err := m.client.txn(ctx, f, i)
+		err := m.client.txn(ctx, func(tx *kvTxn) error {
+			if err := f(tx); err != nil {
+				return err
+			}
+			m.appendChanges(ctx, tx)
+			return nil
+		}, i)
 		if eno, ok := err.(syscall.Errno); ok && eno == 0 {
 			err = nil
 		}
+		if err == nil {
+			committedChanges(ctx)
+		}
 		if err != nil && m.shouldRetry(err) {
 			if method == "" {
 				method = callerName(ctx) // lazy evaluation
 			}
 			m.txRestart.WithLabelValues(method).Add(1)
+			span.AddEvent("restart", trace.WithAttributes(attribute.String("error", err.Error())))
 			logger.Debugf("Transaction failed, restart it (tried %d): %s", i+1, err)
 			lastErr = err
 			time.Sleep(time.Millisecond * time.Duration(rand.Int()%((i+1)*(i+1))))
@@ -858,9 +926,17 @@ func (m *kvMeta) txn(ctx context.Context, f func(tx *kvTxn) error, inodes ...Ino
 		} else if err == nil && i > 1 {
 			logger.Warnf("Transaction succeeded after %d tries (%s), inodes: %v, method: %s, error: %s", i+1, time.Since(start), inodes, method, lastErr)
 		}
+		if used := time.Since(start); m.isSlow(used) {
+			if method == "" {
+				method = callerName(ctx)
+			}
+			m.logSlow("Slow transaction %s (%s), tries: %d, inodes: %v, error: %v", method, used, i+1, inodes, err)
+		}
+		utils.EndSpan(span, err)
 		return err
 	}
 	logger.Warnf("Already tried 50 times, returning: %s", lastErr)
+	utils.EndSpan(span, lastErr)
 	return lastErr
 }
 
@@ -1318,6 +1394,7 @@ func (m *kvMeta) doUnlink(ctx Context, parent Ino, name string, attr *Attr, skip
 		if _type == TypeDirectory {
 			return syscall.EPERM
 		}
+		changedInode(ctx, inode, _type)
 		keys := [][]byte{m.inodeKey(parent), m.inodeKey(inode)}
 		if trash > 0 {
 			keys = append(keys, m.entryKey(trash, m.trashEntry(parent, inode, name)))
@@ -1513,6 +1590,7 @@ func (m *kvMeta) doRmdir(ctx Context, parent Ino, name string, pinode *Ino, oldA
 		tx.delete(m.entryKey(parent, name))
 		tx.delete(m.dirStatKey(inode))
 		tx.delete(m.dirQuotaKey(inode))
+		tx.delete(m.softQuotaKey(m.dirQuotaKey(inode)))
 		if trash > 0 {
 			tx.set(m.inodeKey(inode), m.marshal(&attr))
 			tx.set(m.entryKey(trash, m.trashEntry(parent, inode, name)), buf)
@@ -1772,6 +1850,7 @@ func (m *kvMeta) doRename(ctx Context, parentSrc Ino, nameSrc string, parentDst
 				}
 				if dtyp == TypeDirectory {
 					tx.delete(m.dirQuotaKey(dino))
+					tx.delete(m.softQuotaKey(m.dirQuotaKey(dino)))
 				}
 			}
 		}
@@ -1842,7 +1921,12 @@ func (m *kvMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 			updateParent = true
 		}
 		oldParent := iattr.Parent
-		iattr.Parent = 0
+		tmpfile := iattr.Nlink == 0 // created by O_TMPFILE
+		if tmpfile {
+			iattr.Parent = parent
+		} else {
+			iattr.Parent = 0
+		}
 		iattr.Ctime = now.Unix()
 		iattr.Ctimensec = uint32(now.Nanosecond())
 		iattr.Nlink++
@@ -1851,10 +1935,14 @@ func (m *kvMeta) doLink(ctx Context, inode, parent Ino, name string, attr *Attr)
 			tx.set(m.inodeKey(parent), m.marshal(&pattr))
 		}
 		tx.set(m.inodeKey(inode), m.marshal(&iattr))
-		if oldParent > 0 {
-			tx.incrBy(m.parentKey(inode, oldParent), 1)
+		if tmpfile {
+			tx.delete(m.sustainedKey(m.sid, inode))
+		} else {
+			if oldParent > 0 {
+				tx.incrBy(m.parentKey(inode, oldParent), 1)
+			}
+			tx.incrBy(m.parentKey(inode, parent), 1)
 		}
-		tx.incrBy(m.parentKey(inode, parent), 1)
 		if attr != nil {
 			*attr = iattr
 		}
@@ -1979,7 +2067,7 @@ func (m *kvMeta) doRead(ctx Context, inode Ino, indx uint32) ([]*slice, syscall.
 	return readSliceBuf(val), 0
 }
 
-func (m *kvMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice Slice, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
+func (m *kvMeta) doWrite(ctx Context, inode Ino, indx uint32, extents []Extent, mtime time.Time, numSlices *int, delta *dirStat, attr *Attr) syscall.Errno {
 	return errno(m.txn(ctx, func(tx *kvTxn) error {
 		*delta = dirStat{}
 		*attr = Attr{}
@@ -1988,14 +2076,17 @@ func (m *kvMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice
 			return syscall.ENOENT
 		}
 		m.parseAttr(rs[0], attr)
-		if attr.Typ != TypeFile {
+		if attr.Typ != TypeFile || attr.Flags&FlagImmutable != 0 {
 			return syscall.EPERM
 		}
+		if attr.Flags&FlagAppend != 0 && extentsStart(indx, extents) < attr.Length {
+			return syscall.EPERM // only appending is allowed
+		}
 		if len(rs[1])%sliceBytes != 0 {
 			logger.Errorf("Invalid chunk value for inode %d indx %d: %d", inode, indx, len(rs[1]))
 			return syscall.EIO
 		}
-		newleng := uint64(indx)*ChunkSize + uint64(off) + uint64(slice.Len)
+		newleng := extentsEnd(indx, extents)
 		if newleng > attr.Length {
 			delta.length = int64(newleng - attr.Length)
 			delta.space = align4K(newleng) - align4K(attr.Length)
@@ -2009,14 +2100,21 @@ func (m *kvMeta) doWrite(ctx Context, inode Ino, indx uint32, off uint32, slice
 		attr.Mtimensec = uint32(mtime.Nanosecond())
 		attr.Ctime = now.Unix()
 		attr.Ctimensec = uint32(now.Nanosecond())
-		val := marshalSlice(off, slice.Id, slice.Size, slice.Off, slice.Len)
+		s := extents[0]
+		val := marshalSlice(s.Pos, s.Id, s.Size, s.Off, s.Len)
 		for i := 0; i < len(rs[1]); i += sliceBytes {
 			if bytes.Equal(rs[1][i:i+sliceBytes], val) {
-				logger.Warnf("Write same slice for inode %d indx %d sliceId %d", inode, indx, slice.Id)
+				logger.Warnf("Write same slice for inode %d indx %d sliceId %d", inode, indx, s.Id)
 				return nil
 			}
 		}
 		val = append(rs[1], val...)
+		for _, e := range extents[1:] {
+			val = append(val, marshalSlice(e.Pos, e.Id, e.Size, e.Off, e.Len)...)
+		}
+		if len(extents) > 1 && s.Id > 0 {
+			tx.incrBy(m.sliceKey(s.Id, s.Size), int64(len(extents)-1))
+		}
 		tx.set(m.inodeKey(inode), m.marshal(attr))
 		tx.set(m.chunkKey(inode, indx), val)
 		*numSlices = len(val) / sliceBytes
@@ -2032,8 +2130,11 @@ func (m *kvMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 		f.Lock()
 		defer f.Unlock()
 	}
-	defer func() { m.of.InvalidateChunk(fout, invalidateAllChunks) }()
+	defer func() { m.of.InvalidateChunk(fout, InvalidateAllChunks) }()
 	var sattr, attr Attr
+	ctx, changes := m.withChange(ctx, &ChangeEvent{Op: ChangeWrite, Type: TypeFile, Inode: fout}, func(e *ChangeEvent) {
+		e.Parent = attr.Parent
+	})
 	err := m.txn(ctx, func(tx *kvTxn) error {
 		newLength, newSpace = 0, 0
 		rs := tx.gets(m.inodeKey(fin), m.inodeKey(fout))
@@ -2148,6 +2249,7 @@ func (m *kvMeta) CopyFileRange(ctx Context, fin Ino, offIn uint64, fout Ino, off
 		}
 		return nil
 	}, fout)
+	m.endChange(changes, errno(err))
 	if err == nil {
 		m.updateParentStat(ctx, fout, attr.Parent, newLength, newSpace)
 	}
@@ -2185,6 +2287,51 @@ func (m *kvMeta) doGetParents(ctx Context, inode Ino) map[Ino]int {
 	return ps
 }
 
+// appendChanges appends the event of the operation in its transaction.
+func (m *kvMeta) appendChanges(ctx context.Context, tx *kvTxn) {
+	if c := pendingChanges(ctx); c != nil {
+		c.event.Seq = uint64(tx.incrBy(m.counterKey("nextChangelog"), 1))
+		tx.set(m.changelogKey(c.event.Seq), c.event.marshal())
+	}
+}
+
+func (m *kvMeta) doReadChangelog(ctx Context, after uint64, limit int) ([]*ChangeEvent, error) {
+	// changelog event: Eeeeeeeee
+	_, vals, err := m.scan(m.changelogKey(after+1), nextKey(m.fmtKey("E")), limit, func(k, v []byte) bool {
+		return len(k) == 1+8
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*ChangeEvent, 0, len(vals))
+	for _, v := range vals {
+		var e ChangeEvent
+		if err = e.unmarshal(v); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (m *kvMeta) doDeleteChangelog(ctx Context, upto uint64) error {
+	for {
+		var n int
+		err := m.txn(ctx, func(tx *kvTxn) error {
+			n = 0
+			tx.scan(m.changelogKey(0), m.changelogKey(upto+1), true, func(k, v []byte) bool {
+				tx.delete(k)
+				n++
+				return n < 1e4
+			})
+			return nil
+		})
+		if err != nil || n < 1e4 {
+			return err
+		}
+	}
+}
+
 func (m *kvMeta) doSyncDirStat(ctx Context, ino Ino) (*dirStat, syscall.Errno) {
 	if m.conf.ReadOnly {
 		return nil, syscall.EROFS
@@ -2658,14 +2805,16 @@ func (m *kvMeta) scanPendingFiles(ctx Context, scan pendingFileScan) error {
 func (m *kvMeta) doRepair(ctx Context, inode Ino, attr *Attr) syscall.Errno {
 	prefix := m.entryKey(inode, "")
 	return errno(m.txn(ctx, func(tx *kvTxn) error {
-		attr.Nlink = 2
-		tx.scan(prefix, nextKey(prefix), false, func(k, v []byte) bool {
-			typ, _ := m.parseEntry(v)
-			if typ == TypeDirectory {
-				attr.Nlink++
-			}
-			return true
-		})
+		if attr.Typ == TypeDirectory {
+			attr.Nlink = 2
+			tx.scan(prefix, nextKey(prefix), false, func(k, v []byte) bool {
+				typ, _ := m.parseEntry(v)
+				if typ == TypeDirectory {
+					attr.Nlink++
+				}
+				return true
+			})
+		}
 		tx.set(m.inodeKey(inode), m.marshal(attr))
 		return nil
 	}, inode))
@@ -2761,16 +2910,26 @@ func (m *kvMeta) getQuotaKey(qtype uint32, key uint64) ([]byte, error) {
 	}
 }
 
+// softQuotaKey returns the key of the soft limits by the key of the quota, e.g. QSDiiiiiiii for QDiiiiiiii.
+func (m *kvMeta) softQuotaKey(quotaKey []byte) []byte {
+	return append(m.fmtKey("QS"), quotaKey[1:]...)
+}
+
 func (m *kvMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, error) {
 	quotaKey, err := m.getQuotaKey(qtype, key)
 	if err != nil {
 		return nil, err
 	}
 
-	buf, err := m.get(quotaKey)
+	var rs [][]byte
+	err = m.client.simpleTxn(ctx, func(tx *kvTxn) error {
+		rs = tx.gets(quotaKey, m.softQuotaKey(quotaKey))
+		return nil
+	}, 0)
 	if err != nil {
 		return nil, err
 	}
+	buf := rs[0]
 	if buf == nil {
 		return nil, nil
 	}
@@ -2778,7 +2937,13 @@ func (m *kvMeta) doGetQuota(ctx Context, qtype uint32, key uint64) (*Quota, erro
 		return nil, fmt.Errorf("invalid quota value: %v", buf)
 	}
 
-	return m.parseQuota(buf), nil
+	q := m.parseQuota(buf)
+	if rs[1] != nil {
+		if err = parseSoftQuota(rs[1], q); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
 }
 
 func (m *kvMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota) (bool, error) {
@@ -2789,7 +2954,8 @@ func (m *kvMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota)
 
 	var created bool
 	err = m.txn(ctx, func(tx *kvTxn) error {
-		buf := tx.get(quotaKey)
+		rs := tx.gets(quotaKey, m.softQuotaKey(quotaKey))
+		buf := rs[0]
 		var origin *Quota
 		var exists bool
 		if len(buf) == 32 {
@@ -2819,12 +2985,50 @@ func (m *kvMeta) doSetQuota(ctx Context, qtype uint32, key uint64, quota *Quota)
 		if quota.UsedInodes >= 0 {
 			origin.UsedInodes = quota.UsedInodes
 		}
+		if rs[1] != nil {
+			if err := parseSoftQuota(rs[1], origin); err != nil {
+				return err
+			}
+		}
+		updateSoftQuota(origin, quota)
 		tx.set(quotaKey, m.packQuota(origin))
+		if origin.hasSoftQuota() {
+			tx.set(m.softQuotaKey(quotaKey), packSoftQuota(origin))
+		} else if rs[1] != nil {
+			tx.delete(m.softQuotaKey(quotaKey))
+		}
 		return nil
 	})
 	return created, err
 }
 
+func (m *kvMeta) doSetQuotaExceeded(ctx Context, qtype uint32, key uint64, old, exceeded int64) (int64, error) {
+	quotaKey, err := m.getQuotaKey(qtype, key)
+	if err != nil {
+		return 0, err
+	}
+	softKey := m.softQuotaKey(quotaKey)
+	var cur int64
+	err = m.txn(ctx, func(tx *kvTxn) error {
+		buf := tx.get(softKey)
+		if buf == nil {
+			cur = 0 // the soft limits are removed
+			return nil
+		}
+		var q Quota
+		if err := parseSoftQuota(buf, &q); err != nil {
+			return err
+		}
+		if cur = q.Exceeded; cur != old {
+			return nil
+		}
+		q.Exceeded, cur = exceeded, exceeded
+		tx.set(softKey, packSoftQuota(&q))
+		return nil
+	})
+	return cur, err
+}
+
 func (m *kvMeta) doDelQuota(ctx Context, qtype uint32, key uint64) error {
 	quotaKey, err := m.getQuotaKey(qtype, key)
 	if err != nil {
@@ -2844,11 +3048,12 @@ func (m *kvMeta) doDelQuota(ctx Context, qtype uint32, key uint64) error {
 		quota.MaxInodes = -1
 		return m.txn(ctx, func(tx *kvTxn) error {
 			tx.set(quotaKey, m.packQuota(quota))
+			tx.delete(m.softQuotaKey(quotaKey))
 			return nil
 		})
 	} else {
 		// For dir quotas, remove all data
-		return m.deleteKeys(quotaKey)
+		return m.deleteKeys(quotaKey, m.softQuotaKey(quotaKey))
 	}
 }
 
@@ -2868,6 +3073,10 @@ func (m *kvMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota
 		if err != nil {
 			return nil, nil, nil, fmt.Errorf("failed to load %s quotas: %w", qt.name, err)
 		}
+		softs, err := m.scanValues(ctx, m.fmtKey("QS"+qt.prefix[1:]), -1, nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load soft limits of %s quotas: %w", qt.name, err)
+		}
 		var quotas map[uint64]*Quota
 		if len(pairs) == 0 {
 			quotas = make(map[uint64]*Quota)
@@ -2881,6 +3090,11 @@ func (m *kvMeta) doLoadQuotas(ctx Context) (map[uint64]*Quota, map[uint64]*Quota
 					id = binary.BigEndian.Uint64([]byte(k[2:])) // skip prefix
 				}
 				quota := m.parseQuota(v)
+				if soft, ok := softs["QS"+k[1:]]; ok {
+					if err := parseSoftQuota(soft, quota); err != nil {
+						logger.Errorf("%s of %s quota %d", err, qt.name, id)
+					}
+				}
 				quotas[id] = quota
 			}
 		}
@@ -3394,11 +3608,18 @@ func (m *kvMeta) DumpMeta(w io.Writer, root Ino, threads int, keepSecret, fast,
 	if err != nil {
 		return err
 	}
+	softs, err := m.scanValues(ctx, m.fmtKey("QSD"), -1, nil)
+	if err != nil {
+		return err
+	}
 	quotas := make(map[Ino]*DumpedQuota, len(pairs))
 	for k, v := range pairs {
 		inode := m.decodeInode([]byte(k[2:]))
 		quota := m.parseQuota(v)
-		quotas[inode] = &DumpedQuota{quota.MaxSpace, quota.MaxInodes, 0, 0}
+		if soft, ok := softs["QS"+k[1:]]; ok {
+			_ = parseSoftQuota(soft, quota)
+		}
+		quotas[inode] = &DumpedQuota{MaxSpace: quota.MaxSpace, MaxInodes: quota.MaxInodes, SoftSpace: quota.SoftSpace, SoftInodes: quota.SoftInodes, Grace: quota.Grace}
 	}
 
 	dm := DumpedMeta{
@@ -3812,6 +4033,60 @@ func (m *kvMeta) doAttachDirNode(ctx Context, parent Ino, inode Ino, name string
 	}, parent))
 }
 
+func (m *kvMeta) doDetachDir(ctx Context, parent Ino, name string, pinode *Ino, oldAttr *Attr) syscall.Errno {
+	return errno(m.txn(ctx, func(tx *kvTxn) error {
+		buf := tx.get(m.entryKey(parent, name))
+		if buf == nil && m.conf.CaseInsensi {
+			if e := m.resolveCase(ctx, parent, name); e != nil {
+				name = string(e.Name)
+				buf = m.packEntry(e.Attr.Typ, e.Inode)
+			}
+		}
+		if buf == nil {
+			return syscall.ENOENT
+		}
+		_type, inode := m.parseEntry(buf)
+		if _type != TypeDirectory {
+			return syscall.ENOTDIR
+		}
+		rs := tx.gets(m.inodeKey(parent), m.inodeKey(inode))
+		if rs[0] == nil || rs[1] == nil {
+			return syscall.ENOENT
+		}
+		var pattr, attr Attr
+		m.parseAttr(rs[0], &pattr)
+		m.parseAttr(rs[1], &attr)
+		if st := m.Access(ctx, parent, MODE_MASK_W|MODE_MASK_X, &pattr); st != 0 {
+			return st
+		}
+		if (pattr.Flags&FlagAppend) != 0 || (pattr.Flags&FlagImmutable) != 0 {
+			return syscall.EPERM
+		}
+		if ctx.Uid() != 0 && pattr.Mode&01000 != 0 && ctx.Uid() != pattr.Uid && ctx.Uid() != attr.Uid {
+			return syscall.EACCES
+		}
+		now := time.Now()
+		pattr.Nlink--
+		pattr.Mtime = now.Unix()
+		pattr.Mtimensec = uint32(now.Nanosecond())
+		pattr.Ctime = now.Unix()
+		pattr.Ctimensec = uint32(now.Nanosecond())
+		attr.Flags |= FlagDeleting
+		attr.Ctime = now.Unix()
+		attr.Ctimensec = uint32(now.Nanosecond())
+		*pinode = inode
+		*oldAttr = attr
+
+		tx.set(m.inodeKey(parent), m.marshal(&pattr))
+		tx.delete(m.entryKey(parent, name))
+		tx.set(m.inodeKey(inode), m.marshal(&attr))
+		tx.delete(m.dirQuotaKey(inode))
+		tx.delete(m.softQuotaKey(m.dirQuotaKey(inode)))
+		tx.set(m.detachedKey(inode), m.packInt64(now.Unix()))
+		return nil
+	}, parent))
+}
+
 func (m *kvMeta) doTouchAtime(ctx Context, inode Ino, attr *Attr, now time.Time) (bool, error) {
 	var updated bool
 	err := m.txn(ctx, func(tx *kvTxn) error {
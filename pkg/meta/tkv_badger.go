@@ -22,9 +22,14 @@ package meta
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
 	"github.com/juicedata/juicefs/pkg/utils"
 )
 
@@ -140,6 +145,11 @@ func (c *badgerClient) name() string {
 	return "badger"
 }
 
+func (c *badgerClient) engineStats() map[string]float64 {
+	lsm, vlog := c.client.Size()
+	return map[string]float64{"lsm_bytes": float64(lsm), "vlog_bytes": float64(vlog)}
+}
+
 func (c *badgerClient) shouldRetry(err error) bool {
 	return err == badger.ErrConflict
 }
@@ -211,8 +221,55 @@ func (c *badgerClient) close() error {
 
 func (c *badgerClient) gc() {}
 
-func newBadgerClient(addr string) (tkvClient, error) {
+// parseBadgerOptions applies the tuning options given in the query string of
+// the meta URL, e.g. badger:///var/jfs/meta?compression=zstd&memtable-size=256M
+func parseBadgerOptions(addr string) (badger.Options, float64, error) {
+	var query url.Values
+	if p := strings.Index(addr, "?"); p >= 0 {
+		var err error
+		if query, err = url.ParseQuery(addr[p+1:]); err != nil {
+			return badger.Options{}, 0, fmt.Errorf("parse options %q: %s", addr[p+1:], err)
+		}
+		addr = addr[:p]
+	}
 	opt := badger.DefaultOptions(addr)
+	gcRatio := 0.7
+	for k, vs := range query {
+		v := vs[0]
+		switch k {
+		case "compression":
+			switch strings.ToLower(v) {
+			case "none":
+				opt.Compression = options.None
+			case "snappy":
+				opt.Compression = options.Snappy
+			case "zstd":
+				opt.Compression = options.ZSTD
+			default:
+				return opt, 0, fmt.Errorf("invalid compression %q for badger: none, snappy or zstd is supported", v)
+			}
+		case "memtable-size":
+			opt.MemTableSize = int64(utils.ParseBytesStr(k, v, 'M'))
+		case "block-cache-size":
+			opt.BlockCacheSize = int64(utils.ParseBytesStr(k, v, 'M'))
+		case "vlog-gc-ratio":
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r <= 0 || r >= 1 {
+				return opt, 0, fmt.Errorf("invalid vlog-gc-ratio %q for badger: should be in (0, 1)", v)
+			}
+			gcRatio = r
+		default:
+			return opt, 0, fmt.Errorf("unknown option %q for badger", k)
+		}
+	}
+	return opt, gcRatio, nil
+}
+
+func newBadgerClient(addr string) (tkvClient, error) {
+	opt, gcRatio, err := parseBadgerOptions(addr)
+	if err != nil {
+		return nil, err
+	}
 	opt.Logger = utils.GetLogger("badger")
 	opt.MetricsEnabled = false
 	client, err := badger.Open(opt)
@@ -222,7 +279,7 @@ func newBadgerClient(addr string) (tkvClient, error) {
 	ticker := time.NewTicker(time.Hour)
 	go func() {
 		for range ticker.C {
-			for client.RunValueLogGC(0.7) == nil {
+			for client.RunValueLogGC(gcRatio) == nil {
 			}
 		}
 	}()
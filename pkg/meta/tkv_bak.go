@@ -408,6 +408,11 @@ func (m *kvMeta) dumpACL(ctx Context, opt *DumpOption, ch chan<- *dumpedResult)
 
 func (m *kvMeta) dumpQuota(ctx Context, opt *DumpOption, ch chan<- *dumpedResult) error {
 	return m.txn(ctx, func(tx *kvTxn) error {
+		softs := make(map[Ino][]byte)
+		tx.scan(m.fmtKey("QSD"), nextKey(m.fmtKey("QSD")), false, func(k, v []byte) bool {
+			softs[m.decodeInode(k[3:])] = v
+			return true
+		})
 		quotas := make([]*pb.Quota, 0, 128)
 		tx.scan(m.fmtKey("QD"), nextKey(m.fmtKey("QD")), false, func(k, v []byte) bool {
 			q := &pb.Quota{}
@@ -417,6 +422,13 @@ func (m *kvMeta) dumpQuota(ctx Context, opt *DumpOption, ch chan<- *dumpedResult
 			q.MaxInodes = int64(b.Get64())
 			q.UsedSpace = int64(b.Get64())
 			q.UsedInodes = int64(b.Get64())
+			if v, ok := softs[Ino(q.Inode)]; ok {
+				var soft Quota
+				if err := parseSoftQuota(v, &soft); err != nil {
+					logger.Warnf("%s of quota %d", err, q.Inode)
+				}
+				q.SoftSpace, q.SoftInodes, q.Grace = soft.SoftSpace, soft.SoftInodes, soft.Grace
+			}
 			quotas = append(quotas, q)
 			return true
 		})
@@ -566,6 +578,10 @@ func (m *kvMeta) loadQuota(ctx Context, msg proto.Message, pairs *[]*pair) {
 		b.Put64(uint64(q.UsedSpace))
 		b.Put64(uint64(q.UsedInodes))
 		*pairs = append(*pairs, &pair{m.dirQuotaKey(Ino(q.Inode)), b.Bytes()})
+		soft := &Quota{SoftSpace: q.SoftSpace, SoftInodes: q.SoftInodes, Grace: q.Grace}
+		if soft.hasSoftQuota() {
+			*pairs = append(*pairs, &pair{m.softQuotaKey(m.dirQuotaKey(Ino(q.Inode))), packSoftQuota(soft)})
+		}
 	}
 }
 
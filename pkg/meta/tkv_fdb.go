@@ -23,6 +23,8 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/apple/foundationdb/bindings/go/src/fdb"
 )
@@ -53,8 +55,26 @@ func newFdbClient(addr string) (tkvClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open database: %s", err)
 	}
-	// TODO: database options
-	return withPrefix(&fdbClient{db}, append([]byte(u.Query().Get("prefix")), 0xFD)), nil
+	query := u.Query()
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %s", v, err)
+		}
+		if err = db.Options().SetTransactionTimeout(d.Milliseconds()); err != nil {
+			return nil, fmt.Errorf("set transaction timeout: %s", err)
+		}
+	}
+	if v := query.Get("retry-limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry-limit %q: %s", v, err)
+		}
+		if err = db.Options().SetTransactionRetryLimit(n); err != nil {
+			return nil, fmt.Errorf("set transaction retry limit: %s", err)
+		}
+	}
+	return withPrefix(&fdbClient{db}, append([]byte(query.Get("prefix")), 0xFD)), nil
 }
 
 func (c *fdbClient) name() string {
@@ -57,6 +57,7 @@ func (m *kvMeta) Flock(ctx Context, inode Ino, owner uint64, ltype uint32, block
 	ctx = ctx.WithValue(txMethodKey{}, "Flock"+strconv.Itoa(int(ltype)))
 	var err error
 	lkey := lockOwner{m.sid, owner}
+	var w *lockWait
 	for {
 		err = m.txn(ctx, func(tx *kvTxn) error {
 			v := tx.get(ikey)
@@ -91,6 +92,13 @@ func (m *kvMeta) Flock(ctx Context, inode Ino, owner uint64, ltype uint32, block
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner, Flock: true, Type: ltype}
+			defer m.doneLock(w)
+		}
+		if st := m.waitLock(ctx, w, m.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -173,6 +181,7 @@ func (m *kvMeta) Setlk(ctx Context, inode Ino, owner uint64, block bool, ltype u
 	var err error
 	lock := plockRecord{ltype, pid, start, end}
 	lkey := lockOwner{m.sid, owner}
+	var w *lockWait
 	for {
 		err = m.txn(ctx, func(tx *kvTxn) error {
 			owners := unmarshalPlock(tx.get(ikey))
@@ -214,6 +223,13 @@ func (m *kvMeta) Setlk(ctx Context, inode Ino, owner uint64, block bool, ltype u
 		if !block || err != syscall.EAGAIN {
 			break
 		}
+		if w == nil {
+			w = &lockWait{Inode: inode, Owner: owner, Type: ltype, Start: start, End: end, Pid: pid}
+			defer m.doneLock(w)
+		}
+		if st := m.waitLock(ctx, w, m.ListLocks); st != 0 {
+			return st
+		}
 		if ltype == F_WRLCK {
 			time.Sleep(time.Millisecond * 1)
 		} else {
@@ -0,0 +1,361 @@
+//go:build !nopebble
+// +build !nopebble
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+type pebbleTxn struct {
+	b        *pebble.Batch
+	observed map[string]struct{}
+	scanned  []keyRange // ranges read by scan and exist, to detect phantoms
+	written  map[string]struct{}
+}
+
+// keyRange is the range [begin, end) of keys.
+type keyRange struct {
+	begin, end []byte
+}
+
+func (r keyRange) contains(key []byte) bool {
+	return bytes.Compare(key, r.begin) >= 0 && bytes.Compare(key, r.end) < 0
+}
+
+func (tx *pebbleTxn) get(key []byte) []byte {
+	tx.observed[string(key)] = struct{}{}
+	value, closer, err := tx.b.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer closer.Close()
+	return append([]byte{}, value...)
+}
+
+func (tx *pebbleTxn) gets(keys ...[]byte) [][]byte {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = tx.get(key)
+	}
+	return values
+}
+
+func (tx *pebbleTxn) scan(begin, end []byte, keysOnly bool, handler func(k, v []byte) bool) {
+	it, err := tx.b.NewIter(&pebble.IterOptions{LowerBound: begin, UpperBound: end})
+	if err != nil {
+		panic(err)
+	}
+	defer it.Close()
+	scanned := keyRange{begin, end}
+	for it.First(); it.Valid(); it.Next() {
+		var value []byte
+		if !keysOnly {
+			value = append([]byte{}, it.Value()...)
+		}
+		key := append([]byte{}, it.Key()...)
+		if !handler(key, value) {
+			scanned.end = nextKey(key)
+			break
+		}
+	}
+	tx.scanned = append(tx.scanned, scanned)
+	if err = it.Error(); err != nil {
+		panic(err)
+	}
+}
+
+func (tx *pebbleTxn) exist(prefix []byte) bool {
+	it, err := tx.b.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: nextKey(prefix)})
+	if err != nil {
+		panic(err)
+	}
+	defer it.Close()
+	if it.First() {
+		tx.scanned = append(tx.scanned, keyRange{prefix, nextKey(it.Key())})
+		return true
+	}
+	tx.scanned = append(tx.scanned, keyRange{prefix, nextKey(prefix)})
+	return false
+}
+
+func (tx *pebbleTxn) set(key, value []byte) {
+	tx.written[string(key)] = struct{}{}
+	if err := tx.b.Set(key, value, nil); err != nil {
+		panic(err)
+	}
+}
+
+func (tx *pebbleTxn) append(key []byte, value []byte) {
+	list := append(tx.get(key), value...)
+	tx.set(key, list)
+}
+
+func (tx *pebbleTxn) incrBy(key []byte, value int64) int64 {
+	buf := tx.get(key)
+	newCounter := parseCounter(buf)
+	if value != 0 {
+		newCounter += value
+		tx.set(key, packCounter(newCounter))
+	}
+	return newCounter
+}
+
+func (tx *pebbleTxn) delete(key []byte) {
+	tx.written[string(key)] = struct{}{}
+	if err := tx.b.Delete(key, nil); err != nil {
+		panic(err)
+	}
+}
+
+// pebbleClient runs transactions in indexed batches with optimistic concurrency control,
+// since pebble has no transactions: a transaction fails to commit if any key it read, or
+// any key in the ranges it scanned, was written by another transaction committed after it
+// started. The database can only be opened by one process, so it's enough to track the
+// conflicts in memory.
+type pebbleClient struct {
+	sync.Mutex
+	db    *pebble.DB
+	cache interface{ Unref() }
+	wopt  *pebble.WriteOptions
+
+	seq     uint64         // number of committed transactions
+	commits []pebbleCommit // committed transactions that may conflict with running ones, ordered by seq
+	active  map[uint64]int // seq when running transactions started -> number of them
+}
+
+type pebbleCommit struct {
+	seq  uint64
+	keys [][]byte
+}
+
+func (c *pebbleClient) name() string {
+	return "pebble"
+}
+
+func (c *pebbleClient) shouldRetry(err error) bool {
+	return strings.Contains(err.Error(), "write conflict")
+}
+
+func (c *pebbleClient) config(key string) interface{} {
+	return nil
+}
+
+func (c *pebbleClient) simpleTxn(ctx context.Context, f func(*kvTxn) error, retry int) (err error) {
+	return c.txn(ctx, f, retry)
+}
+
+func (c *pebbleClient) txn(ctx context.Context, f func(*kvTxn) error, retry int) (err error) {
+	c.Lock()
+	start := c.seq
+	c.active[start]++
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		if c.active[start]--; c.active[start] == 0 {
+			delete(c.active, start)
+		}
+		c.Unlock()
+	}()
+	b := c.db.NewIndexedBatch()
+	defer b.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			fe, ok := r.(error)
+			if ok {
+				err = fe
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	tx := &pebbleTxn{b: b, observed: make(map[string]struct{}), written: make(map[string]struct{})}
+	if err = f(&kvTxn{tx, retry}); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	// reads are not from a snapshot, so read-only transactions are checked as well
+	if err = c.checkConflict(tx, start); err != nil {
+		return err
+	}
+	if b.Empty() {
+		return nil
+	}
+	if err = b.Commit(c.wopt); err != nil {
+		return err
+	}
+	c.seq++
+	keys := make([][]byte, 0, len(tx.written))
+	for k := range tx.written {
+		keys = append(keys, []byte(k))
+	}
+	c.commits = append(c.commits, pebbleCommit{c.seq, keys})
+	c.pruneCommits()
+	return nil
+}
+
+// checkConflict checks the keys and ranges read by tx against the transactions committed after start.
+func (c *pebbleClient) checkConflict(tx *pebbleTxn, start uint64) error {
+	i := sort.Search(len(c.commits), func(i int) bool { return c.commits[i].seq > start })
+	for _, cm := range c.commits[i:] {
+		for _, k := range cm.keys {
+			if _, ok := tx.observed[string(k)]; ok {
+				return fmt.Errorf("write conflict: %q was written after the transaction started", k)
+			}
+			for _, r := range tx.scanned {
+				if r.contains(k) {
+					return fmt.Errorf("write conflict: %q in the scanned range was written after the transaction started", k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pruneCommits forgets the commits that can't conflict with any running transaction.
+func (c *pebbleClient) pruneCommits() {
+	oldest := c.seq
+	for s := range c.active {
+		if s < oldest {
+			oldest = s
+		}
+	}
+	i := sort.Search(len(c.commits), func(i int) bool { return c.commits[i].seq > oldest })
+	if i > 0 {
+		c.commits = append(c.commits[:0], c.commits[i:]...)
+	}
+}
+
+func (c *pebbleClient) scan(prefix []byte, handler func(key []byte, value []byte) bool) error {
+	snap := c.db.NewSnapshot()
+	defer snap.Close()
+	it, err := snap.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: nextKey(prefix)})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.First(); it.Valid(); it.Next() {
+		if !handler(it.Key(), append([]byte{}, it.Value()...)) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+func (c *pebbleClient) reset(prefix []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if prefix == nil {
+		prefix = []byte{}
+	}
+	end := nextKey(prefix)
+	if len(prefix) == 0 {
+		end = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	}
+	return c.db.DeleteRange(prefix, end, c.wopt)
+}
+
+func (c *pebbleClient) close() error {
+	err := c.db.Close()
+	c.cache.Unref()
+	return err
+}
+
+func (c *pebbleClient) gc() {}
+
+// parsePebbleOptions applies the tuning options given in the query string of
+// the meta URL, e.g. pebble:///var/jfs/meta?cache-size=1G&memtable-size=64M&sync=false
+func parsePebbleOptions(addr string) (string, *pebble.Options, *pebble.WriteOptions, error) {
+	var query url.Values
+	if p := strings.Index(addr, "?"); p >= 0 {
+		var err error
+		if query, err = url.ParseQuery(addr[p+1:]); err != nil {
+			return "", nil, nil, fmt.Errorf("parse options %q: %s", addr[p+1:], err)
+		}
+		addr = addr[:p]
+	}
+	opt := &pebble.Options{}
+	cacheSize := int64(128 << 20)
+	wopt := pebble.Sync
+	for k, vs := range query {
+		v := vs[0]
+		switch k {
+		case "cache-size":
+			cacheSize = int64(utils.ParseBytesStr(k, v, 'M'))
+		case "memtable-size":
+			opt.MemTableSize = utils.ParseBytesStr(k, v, 'M')
+		case "max-open-files":
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return "", nil, nil, fmt.Errorf("invalid max-open-files %q for pebble", v)
+			}
+			opt.MaxOpenFiles = n
+		case "sync":
+			sync, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("invalid sync %q for pebble: %s", v, err)
+			}
+			if !sync {
+				wopt = pebble.NoSync
+			}
+		default:
+			return "", nil, nil, fmt.Errorf("unknown option %q for pebble", k)
+		}
+	}
+	opt.Cache = pebble.NewCache(cacheSize)
+	return addr, opt, wopt, nil
+}
+
+func newPebbleClient(addr string) (tkvClient, error) {
+	dir, opt, wopt, err := parsePebbleOptions(addr)
+	if err != nil {
+		return nil, err
+	}
+	opt.Logger = utils.GetLogger("pebble")
+	db, err := pebble.Open(dir, opt)
+	if err != nil {
+		opt.Cache.Unref()
+		return nil, err
+	}
+	return &pebbleClient{
+		db:     db,
+		cache:  opt.Cache,
+		wopt:   wopt,
+		active: make(map[uint64]int),
+	}, nil
+}
+
+func init() {
+	Register("pebble", newKVMeta)
+	drivers["pebble"] = newPebbleClient
+}
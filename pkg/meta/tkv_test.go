@@ -20,10 +20,14 @@ package meta
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"sort"
 	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/dgraph-io/badger/v4/options"
 )
 
 func TestMemKVClient(t *testing.T) {
@@ -51,6 +55,14 @@ func TestBadgerClient(t *testing.T) {
 	testMeta(t, m)
 }
 
+func TestPebbleClient(t *testing.T) {
+	m, err := newKVMeta("pebble", t.TempDir(), testConfig())
+	if err != nil || m.Name() != "pebble" {
+		t.Fatalf("create meta: %s", err)
+	}
+	testMeta(t, m)
+}
+
 func TestEtcdClient(t *testing.T) { //skip mutate
 	if os.Getenv("SKIP_NON_CORE") == "true" {
 		t.Skipf("skip non-core test")
@@ -268,6 +280,79 @@ func TestBadgerKV(t *testing.T) {
 	testTKV(t, c)
 }
 
+func TestBadgerOptions(t *testing.T) {
+	opt, ratio, err := parseBadgerOptions("/tmp/jfs-badger?compression=zstd&memtable-size=128M&block-cache-size=1G&vlog-gc-ratio=0.5")
+	if err != nil {
+		t.Fatalf("parse badger options: %s", err)
+	}
+	if opt.Dir != "/tmp/jfs-badger" || opt.ValueDir != "/tmp/jfs-badger" {
+		t.Fatalf("unexpected dir %s, value dir %s", opt.Dir, opt.ValueDir)
+	}
+	if opt.Compression != options.ZSTD || opt.MemTableSize != 128<<20 || opt.BlockCacheSize != 1<<30 || ratio != 0.5 {
+		t.Fatalf("unexpected options: compression %d, memtable %d, block cache %d, gc ratio %f",
+			opt.Compression, opt.MemTableSize, opt.BlockCacheSize, ratio)
+	}
+	if _, ratio, err = parseBadgerOptions("/tmp/jfs-badger"); err != nil || ratio != 0.7 {
+		t.Fatalf("default options: ratio %f, err %v", ratio, err)
+	}
+	for _, addr := range []string{"/tmp/jfs-badger?compression=lz4", "/tmp/jfs-badger?vlog-gc-ratio=1.5", "/tmp/jfs-badger?unknown=1"} {
+		if _, _, err = parseBadgerOptions(addr); err == nil {
+			t.Fatalf("parse %s should fail", addr)
+		}
+	}
+}
+
+func TestPebbleKV(t *testing.T) {
+	c, err := newPebbleClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTKV(t, c)
+
+	// a key inserted into the scanned range by another transaction is a conflict
+	ctx := context.Background()
+	err = c.txn(ctx, func(kt *kvTxn) error {
+		var found int
+		kt.scan([]byte("p"), []byte("q"), true, func(k, v []byte) bool {
+			found++
+			return true
+		})
+		if found != 0 {
+			return fmt.Errorf("found %d keys", found)
+		}
+		if err := c.txn(ctx, func(kt2 *kvTxn) error {
+			kt2.set([]byte("p1"), []byte("v"))
+			return nil
+		}, 0); err != nil {
+			return err
+		}
+		kt.set([]byte("pcount"), packCounter(int64(found)))
+		return nil
+	}, 0)
+	if err == nil || !c.shouldRetry(err) {
+		t.Fatalf("phantom in scanned range should conflict: %v", err)
+	}
+}
+
+func TestPebbleOptions(t *testing.T) {
+	dir, opt, wopt, err := parsePebbleOptions("/tmp/jfs-pebble?cache-size=1G&memtable-size=64M&max-open-files=100&sync=false")
+	if err != nil {
+		t.Fatalf("parse pebble options: %s", err)
+	}
+	defer opt.Cache.Unref()
+	if dir != "/tmp/jfs-pebble" || opt.MemTableSize != 64<<20 || opt.MaxOpenFiles != 100 || wopt != pebble.NoSync {
+		t.Fatalf("unexpected options: dir %s, memtable %d, max open files %d, sync %v", dir, opt.MemTableSize, opt.MaxOpenFiles, wopt.Sync)
+	}
+	if opt.Cache.MaxSize() != 1<<30 {
+		t.Fatalf("unexpected cache size %d", opt.Cache.MaxSize())
+	}
+	for _, addr := range []string{"/tmp/jfs-pebble?sync=maybe", "/tmp/jfs-pebble?max-open-files=0", "/tmp/jfs-pebble?unknown=1"} {
+		if _, _, _, err = parsePebbleOptions(addr); err == nil {
+			t.Fatalf("parse %s should fail", addr)
+		}
+	}
+}
+
 func TestEtcd(t *testing.T) { //skip mutate
 	if os.Getenv("SKIP_NON_CORE") == "true" {
 		t.Skipf("skip non-core test")
@@ -0,0 +1,232 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TrashEntry is a removed file or directory in the trash, with its original location.
+type TrashEntry struct {
+	Inode    Ino
+	Attr     *Attr
+	Dir      string    // name of the sub directory in trash, which is the hour (UTC) when it's removed
+	Name     string    // name of the entry in the sub directory
+	Deleted  time.Time // the hour when it's removed
+	Parent   Ino       // the original parent directory
+	OrigName string    // the original name
+	Path     string    // the original path, or empty if it can't be resolved
+	dir      Ino       // inode of the sub directory
+}
+
+// newTrashEntry parses the original location of the entry from its name in trash, which is
+// "<parent>-<inode>-<name>", or returns nil if it's not a valid name.
+func newTrashEntry(dir Ino, dirName string, ts time.Time, e *Entry) *TrashEntry {
+	ps := strings.SplitN(string(e.Name), "-", 3)
+	if len(ps) != 3 {
+		return nil
+	}
+	parent, err := strconv.ParseUint(ps[0], 10, 64)
+	if err != nil || parent == 0 {
+		return nil
+	}
+	return &TrashEntry{
+		Inode:    e.Inode,
+		Attr:     e.Attr,
+		Dir:      dirName,
+		Name:     string(e.Name),
+		Deleted:  ts,
+		Parent:   Ino(parent),
+		OrigName: ps[2],
+		dir:      dir,
+	}
+}
+
+func (m *baseMeta) ListTrash(ctx Context) ([]*TrashEntry, syscall.Errno) {
+	entries, st := m.listTrash(ctx)
+	if st != 0 {
+		return nil, st
+	}
+	m.resolveTrashPaths(ctx, entries)
+	return entries, 0
+}
+
+// listTrash returns all the entries in trash, ordered by the time they are removed.
+func (m *baseMeta) listTrash(ctx Context) ([]*TrashEntry, syscall.Errno) {
+	var dirs []*Entry
+	if st := m.en.doReaddir(ctx, TrashInode, 0, &dirs, -1); st != 0 {
+		return nil, st
+	}
+	sort.Slice(dirs, func(i, j int) bool { return string(dirs[i].Name) < string(dirs[j].Name) })
+	var result []*TrashEntry
+	for _, d := range dirs {
+		if ctx.Canceled() {
+			return nil, syscall.EINTR
+		}
+		ts, err := time.Parse("2006-01-02-15", string(d.Name))
+		if err != nil {
+			logger.Warnf("bad entry as a subTrash: %s", d.Name)
+			continue
+		}
+		var entries []*Entry
+		if st := m.en.doReaddir(ctx, d.Inode, 1, &entries, -1); st != 0 {
+			logger.Warnf("readdir subTrash %s: %s", d.Name, st)
+			continue
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Inode < entries[j].Inode })
+		for _, e := range entries {
+			if te := newTrashEntry(d.Inode, string(d.Name), ts, e); te != nil {
+				result = append(result, te)
+			} else {
+				logger.Warnf("bad entry in subTrash %s: %s", d.Name, e.Name)
+			}
+		}
+	}
+	return result, 0
+}
+
+// resolveTrashPaths finds the original paths of the entries, the ones in removed directories are
+// resolved by the original paths of the directories in trash.
+func (m *baseMeta) resolveTrashPaths(ctx Context, entries []*TrashEntry) {
+	removed := make(map[Ino]*TrashEntry, len(entries))
+	for _, e := range entries {
+		if e.Attr.Typ == TypeDirectory {
+			removed[e.Inode] = e
+		}
+	}
+	resolved := make(map[*TrashEntry]bool, len(entries))
+	dirs := make(map[Ino]string) // paths of the existing parents
+	var resolve func(e *TrashEntry, depth int) string
+	resolve = func(e *TrashEntry, depth int) string {
+		if resolved[e] || depth > 1000 {
+			return e.Path
+		}
+		resolved[e] = true
+		var dir string
+		if p, ok := removed[e.Parent]; ok {
+			dir = resolve(p, depth+1)
+		} else if d, ok := dirs[e.Parent]; ok {
+			dir = d
+		} else {
+			if ps := m.GetPaths(ctx, e.Parent); len(ps) > 0 && !strings.HasPrefix(ps[0], "/"+TrashName+"/") {
+				dir = ps[0]
+			}
+			dirs[e.Parent] = dir
+		}
+		if dir != "" {
+			e.Path = path.Join(dir, e.OrigName)
+		}
+		return e.Path
+	}
+	for _, e := range entries {
+		resolve(e, 0)
+	}
+}
+
+// findTrashEntry returns the entry of the inode in a sub directory of trash.
+func (m *baseMeta) findTrashEntry(ctx Context, dir, inode Ino) (*TrashEntry, syscall.Errno) {
+	var dirs, entries []*Entry
+	if st := m.en.doReaddir(ctx, TrashInode, 0, &dirs, -1); st != 0 {
+		return nil, st
+	}
+	for _, d := range dirs {
+		if d.Inode != dir {
+			continue
+		}
+		ts, _ := time.Parse("2006-01-02-15", string(d.Name))
+		if st := m.en.doReaddir(ctx, dir, 1, &entries, -1); st != 0 {
+			return nil, st
+		}
+		for _, e := range entries {
+			if e.Inode == inode {
+				if te := newTrashEntry(dir, string(d.Name), ts, e); te != nil {
+					return te, 0
+				}
+			}
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+func (m *baseMeta) RestoreTrash(ctx Context, e *TrashEntry) syscall.Errno {
+	defer m.timeit("RestoreTrash", time.Now())
+	return m.restoreTrash(ctx, e, 0)
+}
+
+func (m *baseMeta) restoreTrash(ctx Context, e *TrashEntry, depth int) syscall.Errno {
+	var attr Attr
+	if st := m.GetAttr(ctx, e.Parent, &attr); st != 0 {
+		return st // the parent is deleted permanently
+	}
+	if attr.Parent.IsTrash() {
+		if depth > 1000 {
+			return syscall.ELOOP
+		}
+		// the parent is removed too, restore it first
+		pe, st := m.findTrashEntry(ctx, attr.Parent, e.Parent)
+		if st != 0 {
+			return st
+		}
+		if st = m.restoreTrash(ctx, pe, depth+1); st != 0 {
+			return st
+		}
+	}
+	return m.Rename(ctx, e.dir, e.Name, e.Parent, e.OrigName, RenameNoReplace|RenameRestore, nil, nil)
+}
+
+// cleanupTrashQuota deletes the oldest files in trash of the users whose removed files are larger
+// than the quota, before they are expired.
+func (m *baseMeta) cleanupTrashQuota(ctx Context, quota uint64) {
+	entries, st := m.listTrash(ctx)
+	if st != 0 {
+		logger.Warnf("list trash: %s", st)
+		return
+	}
+	usage := make(map[uint32]uint64)
+	for _, e := range entries {
+		if e.Attr.Typ == TypeFile {
+			usage[e.Attr.Uid] += e.Attr.Length
+		}
+	}
+	var count int
+	purged := make(map[uint32]bool)
+	for _, e := range entries {
+		uid := e.Attr.Uid
+		if e.Attr.Typ != TypeFile || usage[uid] <= quota {
+			continue
+		}
+		if ctx.Canceled() {
+			break
+		}
+		var c uint64
+		if st = m.Remove(ctx, e.dir, e.Name, false, m.conf.MaxDeletes, &c); st != 0 {
+			logger.Warnf("delete from trash %s/%s: %s", e.Dir, e.Name, st)
+			continue
+		}
+		usage[uid] -= e.Attr.Length
+		purged[uid] = true
+		count += int(c)
+	}
+	if count > 0 {
+		logger.Infof("cleanup trash: deleted %d files of %d users over the trash quota", count, len(purged))
+	}
+}
@@ -19,6 +19,7 @@ package meta
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/url"
 	"path"
 	"runtime"
@@ -154,6 +155,23 @@ func align4K(length uint64) int64 {
 	return int64((((length - 1) >> 12) + 1) << 12)
 }
 
+// extentsEnd returns the end of the extents in the file.
+func extentsStart(indx uint32, extents []Extent) uint64 {
+	var start uint64 = math.MaxUint64
+	for _, e := range extents {
+		start = min(start, uint64(indx)*ChunkSize+uint64(e.Pos))
+	}
+	return start
+}
+
+func extentsEnd(indx uint32, extents []Extent) uint64 {
+	var end uint64
+	for _, e := range extents {
+		end = max(end, uint64(indx)*ChunkSize+uint64(e.Pos)+uint64(e.Len))
+	}
+	return end
+}
+
 type plockRecord struct {
 	Type  uint32
 	Pid   uint32
@@ -258,6 +258,20 @@ func (e *encrypted) Get(ctx context.Context, key string, off, limit int64, gette
 	return io.NopCloser(bytes.NewBuffer(data)), nil
 }
 
+func (e *encrypted) Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error) {
+	r, ok := e.ObjectStorage.(SupportRepair)
+	if !ok {
+		return 0, notSupported
+	}
+	return r.Repair(ctx, key, func(ciphertext []byte) error {
+		plain, err := e.enc.Decrypt(ciphertext)
+		if err != nil {
+			return fmt.Errorf("Decrypt: %s", err)
+		}
+		return verify(plain)
+	})
+}
+
 func (e *encrypted) Put(ctx context.Context, key string, in io.Reader, getters ...AttrGetter) error {
 	plain, err := io.ReadAll(in)
 	if err != nil {
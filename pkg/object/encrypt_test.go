@@ -22,8 +22,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -233,3 +236,70 @@ func TestEncryptedStore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// fakeVault mimics the transit secrets engine by prefixing the plaintext.
+func fakeVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var args map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&args)
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/jfs":
+			data["ciphertext"] = "vault:v1:" + args["plaintext"]
+		case "/v1/transit/decrypt/jfs":
+			data["plaintext"] = strings.TrimPrefix(args["ciphertext"], "vault:v1:")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestKMSEncryptor(t *testing.T) {
+	srv := fakeVault()
+	defer srv.Close()
+	t.Setenv("VAULT_TOKEN", "test-token")
+	kms, err := NewKMS("vault+http://" + strings.TrimPrefix(srv.URL, "http://") + "/transit/jfs")
+	if err != nil {
+		t.Fatalf("create kms: %s", err)
+	}
+	k1, err := NewWrappedDataKey(kms)
+	if err != nil {
+		t.Fatalf("generate data key: %s", err)
+	}
+	kc, err := NewKMSEncryptor(kms, [][]byte{k1})
+	if err != nil {
+		t.Fatalf("create encryptor: %s", err)
+	}
+	dc, _ := NewDataEncryptor(kc, AES256GCM_RSA)
+	data := []byte("hello")
+	old, _ := dc.Encrypt(data)
+
+	// rotate
+	k2, _ := NewWrappedDataKey(kms)
+	kc2, err := NewKMSEncryptor(kms, [][]byte{k1, k2})
+	if err != nil {
+		t.Fatalf("create encryptor: %s", err)
+	}
+	dc2, _ := NewDataEncryptor(kc2, AES256GCM_RSA)
+	if plaintext, err := dc2.Decrypt(old); err != nil || !bytes.Equal(data, plaintext) {
+		t.Fatalf("decrypt with rotated keys: %s", err)
+	}
+	ciphertext, _ := dc2.Encrypt(data)
+	if plaintext, err := dc2.Decrypt(ciphertext); err != nil || !bytes.Equal(data, plaintext) {
+		t.Fatalf("decrypt: %s", err)
+	}
+	if _, err = dc.Decrypt(ciphertext); err == nil || !strings.Contains(err.Error(), "unknown data key") {
+		t.Fatalf("decrypt with the old keys should fail: %v", err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "bad-token")
+	if _, err = NewKMSEncryptor(kms, [][]byte{k1}); err == nil {
+		t.Fatalf("unwrap with bad token should fail")
+	}
+}
@@ -0,0 +1,453 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// every shard starts with the size of the original object
+const ecHeaderSize = 8
+
+// erasureCoded stripes every object over data+parity stores with Reed-Solomon coding,
+// the object can be read back as long as any `data` of the shards are available.
+type erasureCoded struct {
+	DefaultObjectStorage
+	stores []ObjectStorage
+	data   int
+	parity int
+	enc    reedsolomon.Encoder
+}
+
+func (e *erasureCoded) String() string {
+	return fmt.Sprintf("%s(ec%d+%d)", e.stores[0], e.data, e.parity)
+}
+
+func (e *erasureCoded) Limits() Limits {
+	return Limits{}
+}
+
+func (e *erasureCoded) Create(ctx context.Context) error {
+	for _, o := range e.stores {
+		if err := o.Create(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// each runs f on every store in parallel and returns the errors of them.
+func (e *erasureCoded) each(f func(i int, o ObjectStorage) error) []error {
+	errs := make([]error, len(e.stores))
+	var wg sync.WaitGroup
+	for i, o := range e.stores {
+		wg.Add(1)
+		go func(i int, o ObjectStorage) {
+			defer wg.Done()
+			errs[i] = f(i, o)
+		}(i, o)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (e *erasureCoded) readShard(ctx context.Context, o ObjectStorage, key string, getters ...AttrGetter) ([]byte, error) {
+	r, err := o.Get(ctx, key, 0, -1, getters...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < ecHeaderSize {
+		return nil, fmt.Errorf("shard of %s in %s is too short: %d bytes", key, o, len(data))
+	}
+	return data, nil
+}
+
+func (e *erasureCoded) Head(ctx context.Context, key string) (Object, error) {
+	var lastErr error
+	for _, o := range e.stores {
+		oi, err := o.Head(ctx, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		r, err := o.Get(ctx, key, 0, ecHeaderSize)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var hdr [ecHeaderSize]byte
+		_, err = io.ReadFull(r, hdr[:])
+		_ = r.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return &obj{key, int64(binary.BigEndian.Uint64(hdr[:])), oi.Mtime(), oi.IsDir(), oi.StorageClass()}, nil
+	}
+	return nil, lastErr
+}
+
+func (e *erasureCoded) Get(ctx context.Context, key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	shards := make([][]byte, len(e.stores))
+	errs := make([]error, len(e.stores))
+	var wg sync.WaitGroup
+	fetch := func(from, to int) {
+		for i := from; i < to; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				shards[i], errs[i] = e.readShard(ctx, e.stores[i], key, getters...)
+			}(i)
+		}
+		wg.Wait()
+	}
+	// parity shards are only needed when some of the data shards are lost
+	fetch(0, e.data)
+	var failed int
+	for i := 0; i < e.data; i++ {
+		if errs[i] != nil {
+			failed++
+		}
+	}
+	fetched := e.data
+	if failed > 0 {
+		fetch(e.data, len(e.stores))
+		fetched = len(e.stores)
+	}
+	var size = -1
+	var available int
+	var lastErr error
+	for i, s := range shards[:fetched] {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			if !os.IsNotExist(errs[i]) {
+				logger.Warnf("Read shard %d of %s from %s: %s", i, key, e.stores[i], errs[i])
+			}
+			continue
+		}
+		if sz := int(binary.BigEndian.Uint64(s)); size < 0 {
+			size = sz
+		} else if sz != size {
+			return nil, fmt.Errorf("shards of %s have different sizes: %d != %d", key, sz, size)
+		}
+		shards[i] = s[ecHeaderSize:]
+		available++
+	}
+	if available == 0 {
+		return nil, lastErr
+	} else if available < e.data {
+		return nil, fmt.Errorf("only %d shards of %s are available (%d required): %w", available, key, e.data, lastErr)
+	}
+	if failed > 0 {
+		for i := range shards {
+			if errs[i] != nil {
+				shards[i] = nil
+			}
+		}
+		if size > 0 {
+			if err := e.enc.ReconstructData(shards); err != nil {
+				return nil, fmt.Errorf("reconstruct %s: %s", key, err)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	buf.Grow(size)
+	if size > 0 {
+		if err := e.enc.Join(&buf, shards, size); err != nil {
+			return nil, fmt.Errorf("join shards of %s: %s", key, err)
+		}
+	}
+	data := buf.Bytes()
+	l := int64(len(data))
+	if off > l {
+		off = l
+	}
+	if limit == -1 || off+limit > l {
+		limit = l - off
+	}
+	return io.NopCloser(bytes.NewReader(data[off : off+limit])), nil
+}
+
+func (e *erasureCoded) Put(ctx context.Context, key string, in io.Reader, getters ...AttrGetter) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	size := len(data)
+	shards := make([][]byte, len(e.stores))
+	if size > 0 {
+		if shards, err = e.enc.Split(data); err != nil {
+			return err
+		}
+		if err = e.enc.Encode(shards); err != nil {
+			return err
+		}
+	}
+	var hdr [ecHeaderSize]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(size))
+	errs := e.each(func(i int, o ObjectStorage) error {
+		buf := make([]byte, ecHeaderSize+len(shards[i]))
+		copy(buf, hdr[:])
+		copy(buf[ecHeaderSize:], shards[i])
+		return o.Put(ctx, key, bytes.NewReader(buf), getters...)
+	})
+	var failed int
+	var lastErr error
+	for i, err := range errs {
+		if err != nil {
+			logger.Warnf("Write shard %d of %s into %s: %s", i, key, e.stores[i], err)
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > e.parity {
+		return fmt.Errorf("write %s: %d of %d shards failed: %w", key, failed, len(e.stores), lastErr)
+	}
+	return nil
+}
+
+func (e *erasureCoded) Copy(ctx context.Context, dst, src string) error {
+	return notSupported
+}
+
+func (e *erasureCoded) Delete(ctx context.Context, key string, getters ...AttrGetter) error {
+	errs := e.each(func(i int, o ObjectStorage) error {
+		return o.Delete(ctx, key, getters...)
+	})
+	var failed int
+	var lastErr error
+	for i, err := range errs {
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warnf("Delete shard %d of %s from %s: %s", i, key, e.stores[i], err)
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > e.parity {
+		return fmt.Errorf("delete %s: %d of %d shards failed: %w", key, failed, len(e.stores), lastErr)
+	}
+	return nil
+}
+
+// originSize estimates the size of the object from the size of a shard, padding included.
+func (e *erasureCoded) originSize(o Object) Object {
+	if o.IsDir() || o.Size() < ecHeaderSize {
+		return o
+	}
+	return &obj{o.Key(), (o.Size() - ecHeaderSize) * int64(e.data), o.Mtime(), o.IsDir(), o.StorageClass()}
+}
+
+// List lists the objects from the first available store, the sizes of them are rounded up to multiple of the data shards.
+func (e *erasureCoded) List(ctx context.Context, prefix, startAfter, token, delimiter string, limit int64, followLink bool) ([]Object, bool, string, error) {
+	var lastErr error
+	for _, o := range e.stores {
+		objs, hasMore, nextToken, err := o.List(ctx, prefix, startAfter, token, delimiter, limit, followLink)
+		if errors.Is(err, notSupported) {
+			return nil, false, "", err
+		} else if err != nil {
+			lastErr = err
+			logger.Warnf("List %s: %s", o, err)
+			continue
+		}
+		for i := range objs {
+			objs[i] = e.originSize(objs[i])
+		}
+		return objs, hasMore, nextToken, nil
+	}
+	return nil, false, "", lastErr
+}
+
+func (e *erasureCoded) ListAll(ctx context.Context, prefix, marker string, followLink bool) (<-chan Object, error) {
+	var lastErr error
+	for _, o := range e.stores {
+		ch, err := ListAll(ctx, o, prefix, marker, followLink, true)
+		if errors.Is(err, notSupported) {
+			return nil, err
+		} else if err != nil {
+			lastErr = err
+			logger.Warnf("List %s: %s", o, err)
+			continue
+		}
+		out := make(chan Object, 1000)
+		go func() {
+			defer close(out)
+			for o := range ch {
+				if o != nil {
+					o = e.originSize(o)
+				}
+				out <- o
+			}
+		}()
+		return out, nil
+	}
+	return nil, lastErr
+}
+
+// Repair rebuilds the shards that are missing, inconsistent with the others, or make the object rejected by verify.
+// Besides the missing ones, at most one damaged shard can be found out.
+func (e *erasureCoded) Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error) {
+	raw := make([][]byte, len(e.stores))
+	errs := e.each(func(i int, o ObjectStorage) error {
+		r, err := o.Get(ctx, key, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		raw[i], err = io.ReadAll(r)
+		return err
+	})
+	// the shards with different size from the majority are damaged
+	votes := make(map[[2]int]int)
+	for i, s := range raw {
+		if errs[i] == nil && len(s) >= ecHeaderSize {
+			votes[[2]int{int(binary.BigEndian.Uint64(s)), len(s)}]++
+		} else if errs[i] != nil && !os.IsNotExist(errs[i]) {
+			return 0, errs[i] // can not tell whether it's damaged
+		}
+	}
+	var size, length, most int
+	for v, n := range votes {
+		if n > most {
+			size, length, most = v[0], v[1], n
+		}
+	}
+	if most < e.data {
+		return 0, fmt.Errorf("only %d shards of %s are available (%d required)", most, key, e.data)
+	}
+	shards := make([][]byte, len(e.stores))
+	for i, s := range raw {
+		if errs[i] == nil && len(s) == length && int(binary.BigEndian.Uint64(s)) == size {
+			shards[i] = s[ecHeaderSize:]
+		}
+	}
+
+	rebuild := func(exclude int) ([][]byte, error) {
+		rebuilt := make([][]byte, len(shards))
+		for i := range shards {
+			if i != exclude {
+				rebuilt[i] = shards[i]
+			}
+		}
+		if size == 0 {
+			for i := range rebuilt {
+				rebuilt[i] = []byte{}
+			}
+			return rebuilt, verify(nil)
+		}
+		if err := e.enc.Reconstruct(rebuilt); err != nil {
+			return nil, err
+		}
+		if ok, err := e.enc.Verify(rebuilt); err != nil || !ok {
+			return nil, fmt.Errorf("shards are inconsistent: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := e.enc.Join(&buf, rebuilt, size); err != nil {
+			return nil, err
+		}
+		return rebuilt, verify(buf.Bytes())
+	}
+	rebuilt, err := rebuild(-1)
+	for i := 0; err != nil && i < len(shards); i++ {
+		if shards[i] != nil && most > e.data {
+			rebuilt, err = rebuild(i)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("rebuild %s: %s", key, err)
+	}
+
+	var hdr [ecHeaderSize]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(size))
+	var repaired int
+	for i, s := range rebuilt {
+		if shards[i] != nil && bytes.Equal(shards[i], s) {
+			continue
+		}
+		logger.Warnf("Shard %d of %s in %s is damaged, rewrite it", i, key, e.stores[i])
+		buf := make([]byte, ecHeaderSize+len(s))
+		copy(buf, hdr[:])
+		copy(buf[ecHeaderSize:], s)
+		if err := e.stores[i].Put(ctx, key, bytes.NewReader(buf)); err != nil {
+			return repaired, fmt.Errorf("rewrite shard %d of %s into %s: %s", i, key, e.stores[i], err)
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+func (e *erasureCoded) SetStorageClass(sc string) error {
+	var err = notSupported
+	for _, o := range e.stores {
+		if os, ok := o.(SupportStorageClass); ok {
+			err = os.SetStorageClass(sc)
+		}
+	}
+	return err
+}
+
+// createStorages creates the stores given in endpoints, which is a comma separated list of TYPE:ENDPOINT,
+// e.g. "s3:https://b1.s3.us-east-1.amazonaws.com,file:/data/b2". The same credentials are used for all of them.
+func createStorages(endpoints, ak, sk, token string) ([]ObjectStorage, error) {
+	eps := strings.Split(endpoints, ",")
+	stores := make([]ObjectStorage, len(eps))
+	var err error
+	for i, ep := range eps {
+		p := strings.Index(ep, ":")
+		if p <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q, it should be TYPE:ENDPOINT", ep)
+		}
+		stores[i], err = CreateStorage(strings.ToLower(ep[:p]), ep[p+1:], ak, sk, token)
+		if err != nil {
+			return nil, fmt.Errorf("create storage for %q: %s", ep, err)
+		}
+	}
+	return stores, nil
+}
+
+// NewErasureCoded creates a storage that stripes objects over the stores given in endpoints (see createStorages).
+func NewErasureCoded(endpoints, ak, sk, token string, dataShards, parityShards int) (ObjectStorage, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, fmt.Errorf("invalid erasure coding %d+%d: both data and parity shards should be positive", dataShards, parityShards)
+	}
+	if n := len(strings.Split(endpoints, ",")); n != dataShards+parityShards {
+		return nil, fmt.Errorf("%d buckets are required for erasure coding %d+%d, but got %d", dataShards+parityShards, dataShards, parityShards, n)
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	stores, err := createStorages(endpoints, ak, sk, token)
+	if err != nil {
+		return nil, err
+	}
+	return &erasureCoded{stores: stores, data: dataShards, parity: parityShards, enc: enc}, nil
+}
@@ -115,6 +115,13 @@ type Shutdownable interface {
 	Shutdown()
 }
 
+// SupportRepair is implemented by the storages keeping redundant copies of objects.
+type SupportRepair interface {
+	// Repair checks all the copies of an object with verify, and rewrites the damaged ones from the good ones.
+	// It returns the number of repaired copies.
+	Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error)
+}
+
 func Shutdown(o ObjectStorage) {
 	fn := func(o ObjectStorage) {
 		if s, ok := o.(Shutdownable); ok {
@@ -131,6 +138,14 @@ func Shutdown(o ObjectStorage) {
 		for _, s := range o.stores {
 			fn(s)
 		}
+	case *erasureCoded:
+		for _, s := range o.stores {
+			fn(s)
+		}
+	case *replicated:
+		for _, s := range o.stores {
+			fn(s)
+		}
 	default:
 		fn(o)
 	}
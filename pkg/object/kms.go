@@ -0,0 +1,365 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// KMS wraps and unwraps data keys with a master key managed by an external key management service.
+type KMS interface {
+	String() string
+	Wrap(ctx context.Context, plaintext []byte) ([]byte, error)
+	Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// NewKMS returns the KMS specified by the URI:
+//
+//	aws-kms://<key id, alias or ARN>[?region=<region>]
+//	gcp-kms://projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+//	vault://<host>:<port>/<transit mount>/<key> (vault+http:// for plain HTTP)
+func NewKMS(uri string) (KMS, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid KMS URI %q", uri)
+	}
+	rest, rawQuery, _ := strings.Cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("parse options of KMS %q: %s", uri, err)
+	}
+	switch scheme {
+	case "aws-kms":
+		return newAwsKMS(rest, query)
+	case "gcp-kms":
+		return newGcpKMS(rest, query)
+	case "vault", "vault+http":
+		return newVaultKMS(scheme, rest)
+	}
+	return nil, fmt.Errorf("unsupported KMS: %s", scheme)
+}
+
+func kmsCall(req *http.Request, result interface{}) error {
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, result)
+}
+
+type awsKMS struct {
+	keyID    string
+	region   string
+	endpoint string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+}
+
+func newAwsKMS(keyID string, query url.Values) (KMS, error) {
+	region := query.Get("region")
+	if region == "" && strings.HasPrefix(keyID, "arn:") {
+		if ps := strings.Split(keyID, ":"); len(ps) > 3 {
+			region = ps[3]
+		}
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %s", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("region of aws kms is not specified")
+	}
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", cfg.Region)
+	}
+	return &awsKMS{keyID, cfg.Region, endpoint, cfg.Credentials, v4.NewSigner()}, nil
+}
+
+func (k *awsKMS) String() string {
+	return "aws-kms://" + k.keyID
+}
+
+func (k *awsKMS) call(ctx context.Context, action string, args, result interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	creds, err := k.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieve aws credentials: %s", err)
+	}
+	hash := sha256.Sum256(body)
+	if err = k.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "kms", k.region, time.Now()); err != nil {
+		return err
+	}
+	if err = kmsCall(req, result); err != nil {
+		return fmt.Errorf("aws kms %s: %s", action, err)
+	}
+	return nil
+}
+
+func (k *awsKMS) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var result struct{ CiphertextBlob []byte }
+	err := k.call(ctx, "Encrypt", map[string]interface{}{"KeyId": k.keyID, "Plaintext": plaintext}, &result)
+	return result.CiphertextBlob, err
+}
+
+func (k *awsKMS) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var result struct{ Plaintext []byte }
+	err := k.call(ctx, "Decrypt", map[string]interface{}{"KeyId": k.keyID, "CiphertextBlob": ciphertext}, &result)
+	return result.Plaintext, err
+}
+
+type gcpKMS struct {
+	name     string
+	endpoint string
+	ts       oauth2.TokenSource
+}
+
+func newGcpKMS(name string, query url.Values) (KMS, error) {
+	ts, err := google.DefaultTokenSource(context.Background(), "https://www.googleapis.com/auth/cloudkms")
+	if err != nil {
+		return nil, fmt.Errorf("find google credentials: %s", err)
+	}
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	return &gcpKMS{strings.Trim(name, "/"), strings.TrimSuffix(endpoint, "/"), ts}, nil
+}
+
+func (k *gcpKMS) String() string {
+	return "gcp-kms://" + k.name
+}
+
+func (k *gcpKMS) call(ctx context.Context, action string, args, result interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s:%s", k.endpoint, k.name, action), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	token, err := k.ts.Token()
+	if err != nil {
+		return fmt.Errorf("get google token: %s", err)
+	}
+	token.SetAuthHeader(req)
+	req.Header.Set("Content-Type", "application/json")
+	if err = kmsCall(req, result); err != nil {
+		return fmt.Errorf("gcp kms %s: %s", action, err)
+	}
+	return nil
+}
+
+func (k *gcpKMS) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var result struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err := k.call(ctx, "encrypt", map[string]interface{}{"plaintext": plaintext}, &result)
+	return result.Ciphertext, err
+}
+
+func (k *gcpKMS) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var result struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := k.call(ctx, "decrypt", map[string]interface{}{"ciphertext": ciphertext}, &result)
+	return result.Plaintext, err
+}
+
+// vaultKMS uses the transit secrets engine of HashiCorp Vault, the token is read from VAULT_TOKEN.
+type vaultKMS struct {
+	addr  string
+	mount string
+	key   string
+}
+
+func newVaultKMS(scheme, rest string) (KMS, error) {
+	host, path, _ := strings.Cut(rest, "/")
+	p := strings.LastIndex(path, "/")
+	if host == "" || p <= 0 {
+		return nil, fmt.Errorf("invalid vault KMS %q, it should be vault://<host>:<port>/<mount>/<key>", rest)
+	}
+	if os.Getenv("VAULT_TOKEN") == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is required for vault KMS")
+	}
+	proto := "https"
+	if scheme == "vault+http" {
+		proto = "http"
+	}
+	return &vaultKMS{fmt.Sprintf("%s://%s", proto, host), path[:p], path[p+1:]}, nil
+}
+
+func (k *vaultKMS) String() string {
+	return fmt.Sprintf("%s/%s/keys/%s", k.addr, k.mount, k.key)
+}
+
+func (k *vaultKMS) call(ctx context.Context, action string, args map[string]string) (map[string]string, error) {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s/%s/%s", k.addr, k.mount, action, k.key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var result struct {
+		Data map[string]string `json:"data"`
+	}
+	if err = kmsCall(req, &result); err != nil {
+		return nil, fmt.Errorf("vault %s: %s", action, err)
+	}
+	return result.Data, nil
+}
+
+func (k *vaultKMS) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	data, err := k.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data["ciphertext"]), nil
+}
+
+func (k *vaultKMS) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	data, err := k.call(ctx, "decrypt", map[string]string{"ciphertext": string(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data["plaintext"])
+}
+
+const dataKeySize = 32
+
+// NewWrappedDataKey generates a random data key, and returns it wrapped by the KMS.
+func NewWrappedDataKey(kms KMS) ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	return kms.Wrap(ctx, key)
+}
+
+// keyringEncryptor encrypts the keys of objects with the latest data key using AES-GCM,
+// and keeps the older ones to decrypt objects written before keys are rotated.
+type keyringEncryptor struct {
+	keys []cipher.AEAD
+}
+
+// NewKMSEncryptor unwraps the data keys by the KMS, and returns an Encryptor for the keys of objects.
+func NewKMSEncryptor(kms KMS, wrapped [][]byte) (Encryptor, error) {
+	if len(wrapped) == 0 {
+		return nil, fmt.Errorf("no data key is found")
+	}
+	if len(wrapped) > 1<<16 {
+		return nil, fmt.Errorf("too many data keys: %d", len(wrapped))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	e := &keyringEncryptor{}
+	for i, w := range wrapped {
+		key, err := kms.Unwrap(ctx, w)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key %d by %s: %s", i, kms, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("data key %d: %s", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.keys = append(e.keys, aead)
+	}
+	return e, nil
+}
+
+func (e *keyringEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	id := len(e.keys) - 1
+	aead := e.keys[id]
+	buf := make([]byte, 2+aead.NonceSize(), 2+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	buf[0] = byte(id >> 8)
+	buf[1] = byte(id & 0xFF)
+	if _, err := io.ReadFull(rand.Reader, buf[2:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, buf[2:], plaintext, buf[:2]), nil
+}
+
+func (e *keyringEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, fmt.Errorf("invalid key length %d", len(ciphertext))
+	}
+	id := int(ciphertext[0])<<8 | int(ciphertext[1])
+	if id >= len(e.keys) {
+		return nil, fmt.Errorf("unknown data key %d, the data keys may have been rotated", id)
+	}
+	aead := e.keys[id]
+	if len(ciphertext) < 2+aead.NonceSize() {
+		return nil, fmt.Errorf("invalid key length %d", len(ciphertext))
+	}
+	nonce := ciphertext[2 : 2+aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[2+aead.NonceSize():], ciphertext[:2])
+}
@@ -84,7 +84,7 @@ func (m *memStore) Get(ctx context.Context, key string, off, limit int64, getter
 	}
 	d, ok := m.objects[key]
 	if !ok {
-		return nil, errors.New("not exists")
+		return nil, os.ErrNotExist
 	}
 	if off > int64(len(d.data)) {
 		off = int64(len(d.data))
@@ -26,12 +26,15 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -106,6 +109,11 @@ func setStorageClass(o ObjectStorage) string {
 }
 
 // nolint:errcheck
+// exactSize tells whether the listed objects have the same size as what were put.
+func exactSize(s ObjectStorage) bool {
+	return !strings.Contains(s.String(), "(encrypted)") && !strings.Contains(s.String(), "(ec")
+}
+
 func testStorage(t *testing.T, s ObjectStorage) {
 	ctx := context.Background()
 	sc := setStorageClass(s)
@@ -212,7 +220,7 @@ func testStorage(t *testing.T, s ObjectStorage) {
 			if objs[1].Key() != "test" {
 				t.Fatalf("Second key should be test, but got %s", objs[1].Key())
 			}
-			if !strings.Contains(s.String(), "encrypted") && objs[1].Size() != 5 {
+			if exactSize(s) && objs[1].Size() != 5 {
 				t.Fatalf("Size of first key shold be 5, but got %v", objs[1].Size())
 			}
 			now := time.Now()
@@ -238,7 +246,7 @@ func testStorage(t *testing.T, s ObjectStorage) {
 			if objs[0].Key() != "test" {
 				t.Fatalf("First key should be test, but got %s", objs[0].Key())
 			}
-			if !strings.Contains(s.String(), "encrypted") && objs[0].Size() != 5 {
+			if exactSize(s) && objs[0].Size() != 5 {
 				t.Fatalf("Size of first key shold be 5, but got %v", objs[0].Size())
 			}
 			now := time.Now()
@@ -985,6 +993,203 @@ func TestSharding(t *testing.T) {
 	testStorage(t, s)
 }
 
+func TestErasureCoded(t *testing.T) {
+	s, err := NewErasureCoded("mem:0,mem:1,mem:2,mem:3", "", "", "", 3, 1)
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	testStorage(t, s)
+
+	ctx := context.Background()
+	data := []byte("hello erasure coding")
+	if err = s.Put(ctx, "ec", bytes.NewReader(data)); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	defer s.Delete(ctx, "ec") //nolint:errcheck
+	stores := s.(*erasureCoded).stores
+	// lose one of the data shards
+	_ = stores[1].Delete(ctx, "ec")
+	r, err := s.Get(ctx, "ec", 6, 7)
+	if err != nil {
+		t.Fatalf("get with a lost shard: %s", err)
+	}
+	got, _ := io.ReadAll(r)
+	if string(got) != "erasure" {
+		t.Fatalf("expect erasure but got %q", got)
+	}
+	if o, err := s.Head(ctx, "ec"); err != nil || o.Size() != int64(len(data)) {
+		t.Fatalf("head: %v %v", o, err)
+	}
+	_ = stores[2].Delete(ctx, "ec")
+	if _, err = s.Get(ctx, "ec", 0, -1); err == nil {
+		t.Fatalf("get should fail with two lost shards")
+	}
+	if _, err = NewErasureCoded("mem:0,mem:1,mem:2", "", "", "", 3, 1); err == nil {
+		t.Fatalf("create should fail with not enough buckets")
+	}
+}
+
+func TestReplicated(t *testing.T) {
+	s, err := NewReplicated("mem:0,mem:1", "", "", "")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	testStorage(t, s)
+
+	ctx := context.Background()
+	stores := s.(*replicated).stores
+	_ = s.Put(ctx, "a", bytes.NewReader([]byte("a")))
+	_ = s.Put(ctx, "b", bytes.NewReader([]byte("bb")))
+	_ = stores[0].Delete(ctx, "a")
+	_ = stores[1].Delete(ctx, "b")
+	_ = stores[1].Put(ctx, "c", bytes.NewReader([]byte("ccc")))
+	if d, err := get(s, "a", 0, -1); err != nil || d != "a" {
+		t.Fatalf("get from the second replica: %q %v", d, err)
+	}
+	if objs, err := listAll(ctx, s, "", "", 10, true); err != nil || len(objs) != 3 {
+		t.Fatalf("list should return the union: %v %v", objs, err)
+	}
+	var copied []string
+	var mu sync.Mutex
+	if err = Backfill(ctx, s, "", 2, func(key string, size int64) {
+		mu.Lock()
+		copied = append(copied, key)
+		mu.Unlock()
+	}); err != nil {
+		t.Fatalf("backfill: %s", err)
+	}
+	sort.Strings(copied)
+	if !reflect.DeepEqual(copied, []string{"a", "b", "c"}) {
+		t.Fatalf("backfilled %v", copied)
+	}
+	for i, o := range stores {
+		for _, k := range []string{"a", "b", "c"} {
+			if _, err := o.Head(ctx, k); err != nil {
+				t.Fatalf("%s is missing in replica %d: %s", k, i, err)
+			}
+		}
+	}
+	if _, err = NewReplicated("mem:0", "", "", ""); err == nil {
+		t.Fatalf("create should fail with one bucket")
+	}
+
+	// writes need a quorum, and failed deletions are not backfilled
+	s, _ = NewReplicated("mem:0,mem:1,mem:2", "", "", "")
+	stores = s.(*replicated).stores
+	down := &unavailableStore{stores[2], true}
+	stores[2] = down
+	if err = s.Put(ctx, "d", bytes.NewReader([]byte("d"))); err != nil {
+		t.Fatalf("put with one replica down: %s", err)
+	}
+	stores[1] = &unavailableStore{stores[1], true}
+	if err = s.Put(ctx, "e", bytes.NewReader([]byte("e"))); err == nil {
+		t.Fatalf("put should fail without a quorum")
+	}
+	stores[1] = stores[1].(*unavailableStore).ObjectStorage
+	down.down = false
+	_ = stores[2].Put(ctx, "d", bytes.NewReader([]byte("d")))
+	down.down = true
+	if err = s.Delete(ctx, "d"); err == nil {
+		t.Fatalf("delete should fail with one replica down")
+	}
+	down.down = false
+	copied = nil
+	if err = Backfill(ctx, s, "", 2, func(key string, size int64) { copied = append(copied, key) }); err != nil || len(copied) != 0 {
+		t.Fatalf("backfill deleted object: %v %s", copied, err)
+	}
+	for i, o := range stores {
+		if _, err := o.Head(ctx, "d"); !os.IsNotExist(err) {
+			t.Fatalf("deleted object is in replica %d: %v", i, err)
+		}
+		if _, err := o.Head(ctx, tombstonePrefix+"d"); !os.IsNotExist(err) {
+			t.Fatalf("tombstone is not cleared in replica %d: %v", i, err)
+		}
+	}
+}
+
+// unavailableStore fails all the writes and deletions when it's down.
+type unavailableStore struct {
+	ObjectStorage
+	down bool
+}
+
+func (s *unavailableStore) Put(ctx context.Context, key string, in io.Reader, getters ...AttrGetter) error {
+	if s.down {
+		return fmt.Errorf("%s is down", s.ObjectStorage)
+	}
+	return s.ObjectStorage.Put(ctx, key, in, getters...)
+}
+
+func (s *unavailableStore) Delete(ctx context.Context, key string, getters ...AttrGetter) error {
+	if s.down {
+		return fmt.Errorf("%s is down", s.ObjectStorage)
+	}
+	return s.ObjectStorage.Delete(ctx, key, getters...)
+}
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("hello scrubbing and repairing")
+	verify := func(d []byte) error {
+		if !bytes.Equal(d, data) {
+			return fmt.Errorf("corrupted: %q", d)
+		}
+		return nil
+	}
+	rep, _ := NewReplicated("mem:0,mem:1,mem:2", "", "", "")
+	s := WithPrefix(rep, "prefix/")
+	_ = s.Put(ctx, "r", bytes.NewReader(data))
+	stores := rep.(*replicated).stores
+	_ = stores[0].Put(ctx, "prefix/r", bytes.NewReader([]byte("bit flipped")))
+	_ = stores[2].Delete(ctx, "prefix/r")
+	if n, err := s.(SupportRepair).Repair(ctx, "r", verify); err != nil || n != 2 {
+		t.Fatalf("repair replicas: %d %v", n, err)
+	}
+	for i, o := range stores {
+		if d, err := get(o, "prefix/r", 0, -1); err != nil || d != string(data) {
+			t.Fatalf("replica %d is not repaired: %q %v", i, d, err)
+		}
+	}
+
+	ec, _ := NewErasureCoded("mem:0,mem:1,mem:2,mem:3,mem:4", "", "", "", 3, 2)
+	_ = ec.Put(ctx, "e", bytes.NewReader(data))
+	shards := ec.(*erasureCoded).stores
+	orig, _ := get(shards[0], "e", 0, -1)
+	damaged := []byte(orig)
+	damaged[ecHeaderSize] ^= 0xFF
+	_ = shards[0].Put(ctx, "e", bytes.NewReader(damaged))
+	_ = shards[3].Delete(ctx, "e")
+	if n, err := ec.(SupportRepair).Repair(ctx, "e", verify); err != nil || n != 2 {
+		t.Fatalf("repair shards: %d %v", n, err)
+	}
+	if d, err := get(shards[0], "e", 0, -1); err != nil || d != orig {
+		t.Fatalf("shard 0 is not repaired: %v", err)
+	}
+	if n, err := ec.(SupportRepair).Repair(ctx, "e", verify); err != nil || n != 0 {
+		t.Fatalf("repair healthy shards: %d %v", n, err)
+	}
+	m, _ := CreateStorage("mem", "", "", "", "")
+	if _, err := WithPrefix(m, "prefix/").(SupportRepair).Repair(ctx, "r", verify); err == nil {
+		t.Fatalf("repair should not be supported without redundancy")
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "plugin.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	m, _ := newMem("", "", "", "")
+	go func() { _ = newPluginServer(m).Serve(lis) }()
+	s, err := newPlugin("unix://"+sock, "", "", "")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	defer Shutdown(s)
+	testStorage(t, s)
+}
+
 func TestSQLite(t *testing.T) {
 	s, err := newSQLStore("sqlite3", "/tmp/teststore.db", "", "")
 	if err != nil {
@@ -1116,6 +1321,23 @@ func TestCifs(t *testing.T) { //skip mutate
 // 	testStorage(t, bunny)
 // }
 
+func TestListLimit(t *testing.T) {
+	ctx := context.Background()
+	m, _ := CreateStorage("mem", "", "", "", "")
+	_ = m.Put(ctx, "a", bytes.NewReader([]byte("a")))
+	var throttled int32
+	SetListLimit(10, func() { atomic.AddInt32(&throttled, 1) })
+	defer SetListLimit(0, nil)
+	for i := 0; i < 12; i++ {
+		if objs, err := listAll(ctx, m, "", "", 10, true); err != nil || len(objs) != 1 {
+			t.Fatalf("list: %v %v", objs, err)
+		}
+	}
+	if atomic.LoadInt32(&throttled) == 0 {
+		t.Fatalf("list requests over the limit should be throttled")
+	}
+}
+
 func TestMain(m *testing.M) {
 	if envFile := os.Getenv("JUICEFS_ENV_FILE_FOR_TEST"); envFile != "" {
 		// schema: S3 AWS_ENDPOINT=xxxxx
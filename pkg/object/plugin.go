@@ -0,0 +1,388 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// An object storage plugin is a gRPC server of the service juicefs.object.v1.Plugin, with methods
+// Create, Get, Put, Delete, Head and List. All of them take a pluginRequest and return a pluginResponse,
+// encoded in JSON (content-type application/grpc+json). Not found errors are returned as NotFound,
+// and unsupported methods should return Unimplemented.
+//
+// The plugin is either started by JuiceFS with `;cmd=COMMAND`, in which case the address to listen on
+// is given in the environment variable JFS_PLUGIN_ADDR and the plugin should exit once its stdin is closed,
+// or runs separately and is connected by its address.
+// Go plugins can simply call ServePlugin with any implementation of ObjectStorage.
+const (
+	pluginService     = "juicefs.object.v1.Plugin"
+	pluginMaxMsgSize  = 256 << 20
+	pluginStartupWait = time.Second * 10
+)
+
+type pluginRequest struct {
+	Key        string `json:"key,omitempty"`
+	Off        int64  `json:"off,omitempty"`
+	Limit      int64  `json:"limit,omitempty"`
+	Data       []byte `json:"data,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+	StartAfter string `json:"start_after,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Delimiter  string `json:"delimiter,omitempty"`
+}
+
+type pluginObject struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size"`
+	Mtime        int64  `json:"mtime"` // in nanoseconds since epoch
+	IsDir        bool   `json:"is_dir,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+type pluginResponse struct {
+	Data        []byte         `json:"data,omitempty"`
+	Object      *pluginObject  `json:"object,omitempty"`
+	Objects     []pluginObject `json:"objects,omitempty"`
+	IsTruncated bool           `json:"is_truncated,omitempty"`
+	NextToken   string         `json:"next_token,omitempty"`
+}
+
+type pluginCodec struct{}
+
+func (pluginCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (pluginCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (pluginCodec) Name() string                               { return "json" }
+
+type plugin struct {
+	DefaultObjectStorage
+	desc   string
+	conn   *grpc.ClientConn
+	cmd    *exec.Cmd
+	exited chan error
+	stdin  io.Closer
+	sock   string
+}
+
+func (p *plugin) String() string {
+	return fmt.Sprintf("plugin://%s/", p.desc)
+}
+
+func (p *plugin) call(ctx context.Context, method string, req *pluginRequest) (*pluginResponse, error) {
+	var resp pluginResponse
+	err := p.conn.Invoke(ctx, "/"+pluginService+"/"+method, req, &resp)
+	switch status.Code(err) {
+	case codes.OK:
+		return &resp, nil
+	case codes.NotFound:
+		return nil, os.ErrNotExist
+	case codes.Unimplemented:
+		return nil, notSupported
+	default:
+		return nil, fmt.Errorf("plugin %s: %s", method, status.Convert(err).Message())
+	}
+}
+
+func (p *plugin) Create(ctx context.Context) error {
+	_, err := p.call(ctx, "Create", &pluginRequest{})
+	if errors.Is(err, notSupported) {
+		err = nil
+	}
+	return err
+}
+
+func (p *plugin) Get(ctx context.Context, key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	resp, err := p.call(ctx, "Get", &pluginRequest{Key: key, Off: off, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(resp.Data)), nil
+}
+
+func (p *plugin) Put(ctx context.Context, key string, in io.Reader, getters ...AttrGetter) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	_, err = p.call(ctx, "Put", &pluginRequest{Key: key, Data: data})
+	return err
+}
+
+func (p *plugin) Delete(ctx context.Context, key string, getters ...AttrGetter) error {
+	_, err := p.call(ctx, "Delete", &pluginRequest{Key: key})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
+
+func (o *pluginObject) toObject() Object {
+	return &obj{o.Key, o.Size, time.Unix(0, o.Mtime), o.IsDir, o.StorageClass}
+}
+
+func (p *plugin) Head(ctx context.Context, key string) (Object, error) {
+	resp, err := p.call(ctx, "Head", &pluginRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Object == nil {
+		return nil, os.ErrNotExist
+	}
+	return resp.Object.toObject(), nil
+}
+
+func (p *plugin) List(ctx context.Context, prefix, startAfter, token, delimiter string, limit int64, followLink bool) ([]Object, bool, string, error) {
+	resp, err := p.call(ctx, "List", &pluginRequest{Prefix: prefix, StartAfter: startAfter, Token: token, Delimiter: delimiter, Limit: limit})
+	if err != nil {
+		return nil, false, "", err
+	}
+	objs := make([]Object, len(resp.Objects))
+	for i := range resp.Objects {
+		objs[i] = resp.Objects[i].toObject()
+	}
+	return objs, resp.IsTruncated, resp.NextToken, nil
+}
+
+func (p *plugin) Shutdown() {
+	_ = p.conn.Close()
+	if p.cmd != nil {
+		_ = p.stdin.Close()
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+		_ = os.Remove(p.sock)
+	}
+}
+
+// startPlugin runs the plugin command with JFS_PLUGIN_ADDR set to a unix socket, and waits for it to listen.
+func (p *plugin) startPlugin(command, accessKey, secretKey, token string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty plugin command")
+	}
+	p.sock = filepath.Join(os.TempDir(), fmt.Sprintf("jfs-plugin-%d-%d.sock", os.Getpid(), time.Now().UnixNano()))
+	p.cmd = exec.Command(args[0], args[1:]...)
+	p.cmd.Env = append(os.Environ(),
+		"JFS_PLUGIN_ADDR=unix://"+p.sock,
+		"JFS_PLUGIN_ACCESS_KEY="+accessKey,
+		"JFS_PLUGIN_SECRET_KEY="+secretKey,
+		"JFS_PLUGIN_TOKEN="+token,
+		"JFS_PLUGIN_PARENT="+strconv.Itoa(os.Getpid()),
+	)
+	// the plugin sees EOF on stdin when JuiceFS exits, even if it's killed
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	p.stdin = stdin
+	p.cmd.Stdout = os.Stderr
+	p.cmd.Stderr = os.Stderr
+	if err := p.cmd.Start(); err != nil {
+		return "", fmt.Errorf("start plugin %s: %s", args[0], err)
+	}
+	p.exited = make(chan error, 1)
+	go func() {
+		err := p.cmd.Wait()
+		logger.Debugf("Plugin %s exited: %v", args[0], err)
+		p.exited <- err
+		close(p.exited)
+	}()
+	deadline := time.Now().Add(pluginStartupWait)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-p.exited:
+			return "", fmt.Errorf("plugin %s exited: %v", args[0], err)
+		default:
+		}
+		if c, err := net.Dial("unix", p.sock); err == nil {
+			_ = c.Close()
+			return "unix://" + p.sock, nil
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	_ = p.cmd.Process.Kill()
+	<-p.exited
+	return "", fmt.Errorf("plugin %s is not ready after %s", args[0], pluginStartupWait)
+}
+
+// newPlugin connects to an object storage plugin, the endpoint is either ADDRESS of a running plugin
+// (unix:///path/to/socket or host:port), or ;cmd=COMMAND to start the plugin by JuiceFS.
+func newPlugin(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
+	endpoint = strings.TrimPrefix(endpoint, "plugin://")
+	addr, command, _ := strings.Cut(endpoint, ";cmd=")
+	addr = strings.TrimSuffix(addr, "/")
+	p := &plugin{desc: strings.TrimSuffix(endpoint, "/")}
+	if command != "" {
+		if addr != "" {
+			return nil, fmt.Errorf("plugin address %s should not be given with cmd", addr)
+		}
+		var err error
+		if addr, err = p.startPlugin(command, accessKey, secretKey, token); err != nil {
+			return nil, err
+		}
+	} else if addr == "" {
+		return nil, fmt.Errorf("plugin address or cmd is required")
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(pluginCodec{}), grpc.MaxCallRecvMsgSize(pluginMaxMsgSize), grpc.MaxCallSendMsgSize(pluginMaxMsgSize)))
+	if err != nil {
+		if p.cmd != nil {
+			_ = p.cmd.Process.Kill()
+			<-p.exited
+		}
+		return nil, fmt.Errorf("connect to plugin %s: %s", addr, err)
+	}
+	p.conn = conn
+	return p, nil
+}
+
+func pluginStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, notSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+func pluginObjectOf(o Object) pluginObject {
+	return pluginObject{Key: o.Key(), Size: o.Size(), Mtime: o.Mtime().UnixNano(), IsDir: o.IsDir(), StorageClass: o.StorageClass()}
+}
+
+func handlePlugin(ctx context.Context, s ObjectStorage, method string, req *pluginRequest) (*pluginResponse, error) {
+	resp := &pluginResponse{}
+	switch method {
+	case "Create":
+		return resp, s.Create(ctx)
+	case "Get":
+		r, err := s.Get(ctx, req.Key, req.Off, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		resp.Data, err = io.ReadAll(r)
+		return resp, err
+	case "Put":
+		return resp, s.Put(ctx, req.Key, bytes.NewReader(req.Data))
+	case "Delete":
+		return resp, s.Delete(ctx, req.Key)
+	case "Head":
+		o, err := s.Head(ctx, req.Key)
+		if err != nil {
+			return nil, err
+		}
+		po := pluginObjectOf(o)
+		resp.Object = &po
+		return resp, nil
+	case "List":
+		objs, more, next, err := s.List(ctx, req.Prefix, req.StartAfter, req.Token, req.Delimiter, req.Limit, true)
+		if err != nil {
+			return nil, err
+		}
+		resp.Objects = make([]pluginObject, len(objs))
+		for i, o := range objs {
+			resp.Objects[i] = pluginObjectOf(o)
+		}
+		resp.IsTruncated, resp.NextToken = more, next
+		return resp, nil
+	default:
+		return nil, notSupported
+	}
+}
+
+func pluginMethod(method string) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var req pluginRequest
+			if err := dec(&req); err != nil {
+				return nil, err
+			}
+			resp, err := handlePlugin(ctx, srv.(ObjectStorage), method, &req)
+			return resp, pluginStatus(err)
+		},
+	}
+}
+
+var pluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: pluginService,
+	HandlerType: (*ObjectStorage)(nil),
+	Methods: []grpc.MethodDesc{
+		pluginMethod("Create"),
+		pluginMethod("Get"),
+		pluginMethod("Put"),
+		pluginMethod("Delete"),
+		pluginMethod("Head"),
+		pluginMethod("List"),
+	},
+}
+
+func newPluginServer(store ObjectStorage) *grpc.Server {
+	s := grpc.NewServer(grpc.ForceServerCodec(pluginCodec{}), grpc.MaxRecvMsgSize(pluginMaxMsgSize), grpc.MaxSendMsgSize(pluginMaxMsgSize))
+	s.RegisterService(&pluginServiceDesc, store)
+	return s
+}
+
+// ServePlugin serves the object storage as a plugin on the address given by JFS_PLUGIN_ADDR,
+// which is unix:///path/to/socket or host:port. It returns once stdin is closed if it's started by JuiceFS.
+func ServePlugin(store ObjectStorage) error {
+	addr := os.Getenv("JFS_PLUGIN_ADDR")
+	if addr == "" {
+		return fmt.Errorf("JFS_PLUGIN_ADDR is not set")
+	}
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix://") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	s := newPluginServer(store)
+	if os.Getenv("JFS_PLUGIN_PARENT") != "" {
+		go func() {
+			_, _ = io.Copy(io.Discard, os.Stdin)
+			s.Stop()
+		}()
+	}
+	return s.Serve(lis)
+}
+
+func init() {
+	Register("plugin", newPlugin)
+}
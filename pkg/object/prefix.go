@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -202,10 +203,22 @@ func (p *withPrefix) CompleteUpload(ctx context.Context, key string, uploadID st
 
 func (p *withPrefix) ListUploads(ctx context.Context, marker string) ([]*PendingPart, string, error) {
 	parts, nextMarker, err := p.os.ListUploads(ctx, marker)
+	var filtered []*PendingPart
 	for _, part := range parts {
-		part.Key = part.Key[len(p.prefix):]
+		// skip the uploads out of the prefix, they belong to others
+		if strings.HasPrefix(part.Key, p.prefix) {
+			part.Key = part.Key[len(p.prefix):]
+			filtered = append(filtered, part)
+		}
+	}
+	return filtered, nextMarker, err
+}
+
+func (p *withPrefix) Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error) {
+	if r, ok := p.os.(SupportRepair); ok {
+		return r.Repair(ctx, p.prefix+key, verify)
 	}
-	return parts, nextMarker, err
+	return 0, notSupported
 }
 
 var _ ObjectStorage = (*withPrefix)(nil)
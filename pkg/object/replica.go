@@ -0,0 +1,444 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tombstonePrefix is the prefix of the objects recording the deletions that failed in some of the stores,
+// so Backfill removes the remaining copies instead of copying them back. It's out of the prefix of volumes.
+const tombstonePrefix = "juicefs-tombstones/"
+
+// replicated writes every object into all the stores synchronously, and reads from
+// them in order, so the first one is preferred. Writes succeed when a majority of the
+// stores succeed, the lagging ones should be repaired by Backfill later.
+type replicated struct {
+	DefaultObjectStorage
+	stores []ObjectStorage
+}
+
+// quorum returns the number of stores that an object must be written into.
+func (r *replicated) quorum() int {
+	return len(r.stores)/2 + 1
+}
+
+func (r *replicated) String() string {
+	names := make([]string, len(r.stores))
+	for i, o := range r.stores {
+		names[i] = o.String()
+	}
+	return fmt.Sprintf("replica(%s)", strings.Join(names, ","))
+}
+
+func (r *replicated) Limits() Limits {
+	return Limits{}
+}
+
+func (r *replicated) Create(ctx context.Context) error {
+	for _, o := range r.stores {
+		if err := o.Create(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickErr returns the first error that is not caused by a missing object,
+// which means that the object may be in that store.
+func pickErr(errs []error) error {
+	for _, err := range errs {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return errs[0]
+}
+
+func (r *replicated) Head(ctx context.Context, key string) (Object, error) {
+	errs := make([]error, len(r.stores))
+	for i, o := range r.stores {
+		oi, err := o.Head(ctx, key)
+		if err == nil {
+			return oi, nil
+		}
+		errs[i] = err
+	}
+	return nil, pickErr(errs)
+}
+
+func (r *replicated) Get(ctx context.Context, key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	errs := make([]error, len(r.stores))
+	for i, o := range r.stores {
+		in, err := o.Get(ctx, key, off, limit, getters...)
+		if err == nil {
+			return in, nil
+		}
+		if !os.IsNotExist(err) {
+			logger.Warnf("Read %s from %s: %s", key, o, err)
+		}
+		errs[i] = err
+	}
+	return nil, pickErr(errs)
+}
+
+func (r *replicated) Put(ctx context.Context, key string, in io.Reader, getters ...AttrGetter) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(r.stores))
+	var wg sync.WaitGroup
+	for i, o := range r.stores {
+		wg.Add(1)
+		go func(i int, o ObjectStorage) {
+			defer wg.Done()
+			errs[i] = o.Put(ctx, key, bytes.NewReader(data), getters...)
+		}(i, o)
+	}
+	wg.Wait()
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			logger.Warnf("Write %s into %s: %s, it should be backfilled later", key, r.stores[i], err)
+			failed++
+		}
+	}
+	if len(r.stores)-failed < r.quorum() {
+		// the write should be retried, so don't leave the copies for backfill
+		for i, err := range errs {
+			if err == nil {
+				_ = r.stores[i].Delete(ctx, key)
+			}
+		}
+		return fmt.Errorf("write %s into %d of %d stores, less than the quorum %d: %w", key, len(r.stores)-failed, len(r.stores), r.quorum(), pickErr(errs))
+	}
+	return nil
+}
+
+func (r *replicated) Copy(ctx context.Context, dst, src string) error {
+	return notSupported
+}
+
+// Delete removes the object from all the stores. If it fails in some of them, a tombstone is recorded
+// in the others, so Backfill will finish the deletion instead of copying the object back.
+func (r *replicated) Delete(ctx context.Context, key string, getters ...AttrGetter) error {
+	var lastErr error
+	var deleted []ObjectStorage
+	for _, o := range r.stores {
+		if err := o.Delete(ctx, key, getters...); err != nil && !os.IsNotExist(err) {
+			logger.Warnf("Delete %s from %s: %s", key, o, err)
+			lastErr = err
+		} else {
+			deleted = append(deleted, o)
+		}
+	}
+	if lastErr == nil || len(deleted) == 0 {
+		return lastErr
+	}
+	var recorded bool
+	for _, o := range deleted {
+		if err := o.Put(ctx, tombstonePrefix+key, bytes.NewReader(nil)); err != nil {
+			logger.Warnf("Record tombstone of %s in %s: %s", key, o, err)
+		} else {
+			recorded = true
+		}
+	}
+	if !recorded {
+		return fmt.Errorf("record tombstone of %s: %w, it may be copied back by backfill", key, lastErr)
+	}
+	return lastErr
+}
+
+// ListAll lists the union of the objects in all the stores.
+func (r *replicated) ListAll(ctx context.Context, prefix, marker string, followLink bool) (<-chan Object, error) {
+	heads := &nextObjects{make([]nextKey, 0)}
+	for _, o := range r.stores {
+		ch, err := ListAll(ctx, o, prefix, marker, followLink, true)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %s", o, err)
+		}
+		if first := <-ch; first != nil {
+			heads.Push(nextKey{first, ch})
+		}
+	}
+	heap.Init(heads)
+
+	out := make(chan Object, 1000)
+	go func() {
+		defer close(out)
+		var last string
+		var first = true
+		for heads.Len() > 0 {
+			n := heap.Pop(heads).(nextKey)
+			if key := n.o.Key(); first || key != last {
+				last = key
+				first = false
+				out <- n.o
+			}
+			if o := <-n.ch; o != nil {
+				heap.Push(heads, nextKey{o, n.ch})
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *replicated) SetStorageClass(sc string) error {
+	var err = notSupported
+	for _, o := range r.stores {
+		if os, ok := o.(SupportStorageClass); ok {
+			err = os.SetStorageClass(sc)
+		}
+	}
+	return err
+}
+
+// Repair rewrites the copies that are missing or rejected by verify with a good one.
+func (r *replicated) Repair(ctx context.Context, key string, verify func(data []byte) error) (int, error) {
+	var good []byte
+	var damaged []int
+	var lastErr error
+	for i, o := range r.stores {
+		in, err := o.Get(ctx, key, 0, -1)
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(in)
+			_ = in.Close()
+			if err != nil {
+				return 0, err // can not tell whether it's damaged
+			}
+			err = verify(data)
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err != nil {
+			logger.Warnf("Copy of %s in %s is damaged: %s", key, o, err)
+			damaged = append(damaged, i)
+			lastErr = err
+		} else if good == nil {
+			good = data
+		}
+	}
+	if len(damaged) == 0 {
+		return 0, nil
+	}
+	if good == nil {
+		return 0, fmt.Errorf("no good copy of %s: %w", key, lastErr)
+	}
+	for n, i := range damaged {
+		if err := r.stores[i].Put(ctx, key, bytes.NewReader(good)); err != nil {
+			return n, fmt.Errorf("rewrite %s into %s: %s", key, r.stores[i], err)
+		}
+	}
+	return len(damaged), nil
+}
+
+// loadTombstones returns the keys under prefix with tombstones in any of the stores.
+func (r *replicated) loadTombstones(ctx context.Context, prefix string) (map[string]bool, error) {
+	tombs := make(map[string]bool)
+	for _, o := range r.stores {
+		ch, err := ListAll(ctx, o, tombstonePrefix+prefix, "", true, true)
+		if err != nil {
+			return nil, fmt.Errorf("list tombstones in %s: %s", o, err)
+		}
+		for t := range ch {
+			if t == nil {
+				return nil, fmt.Errorf("list tombstones in %s failed", o)
+			}
+			if !t.IsDir() {
+				tombs[strings.TrimPrefix(t.Key(), tombstonePrefix)] = true
+			}
+		}
+	}
+	return tombs, nil
+}
+
+// clearTombstone removes the tombstone of key from all the stores once the object is deleted from all of them.
+func (r *replicated) clearTombstone(ctx context.Context, key string) error {
+	for _, o := range r.stores {
+		if err := o.Delete(ctx, tombstonePrefix+key); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("clear tombstone of %s in %s: %s", key, o, err)
+		}
+	}
+	return nil
+}
+
+// Backfill copies the objects under prefix that are missing in some of the replicas from the others,
+// onCopy is called after an object is copied. The objects with tombstones, whose deletions failed in
+// some of the replicas, are deleted from the remaining replicas instead.
+func Backfill(ctx context.Context, store ObjectStorage, prefix string, threads int, onCopy func(key string, size int64)) error {
+	r, ok := store.(*replicated)
+	if !ok {
+		return fmt.Errorf("%s is not replicated", store)
+	}
+	// the tombstones must be loaded before listing the objects, or an object deleted in between may be copied back
+	tombs, err := r.loadTombstones(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	chs := make([]<-chan Object, len(r.stores))
+	heads := make([]Object, len(r.stores))
+	next := func(i int) error {
+		for {
+			o, ok := <-chs[i]
+			if !ok {
+				heads[i] = nil
+				return nil
+			}
+			if o == nil {
+				return fmt.Errorf("list %s failed", r.stores[i])
+			}
+			if !o.IsDir() && !strings.HasPrefix(o.Key(), tombstonePrefix) {
+				heads[i] = o
+				return nil
+			}
+		}
+	}
+	for i, o := range r.stores {
+		if chs[i], err = ListAll(ctx, o, prefix, "", true, true); err != nil {
+			return fmt.Errorf("list %s: %s", o, err)
+		}
+		if err = next(i); err != nil {
+			return err
+		}
+	}
+
+	type task struct {
+		obj     Object
+		src     int
+		missing []int
+		present []int // the replicas to delete the object from if it has a tombstone
+		deleted bool
+	}
+	tasks := make(chan task, threads)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var copyErr error
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				key := t.obj.Key()
+				if t.deleted {
+					var err error
+					for _, i := range t.present {
+						if err = r.stores[i].Delete(ctx, key); err != nil && !os.IsNotExist(err) {
+							break
+						}
+						err = nil
+					}
+					if err == nil {
+						err = r.clearTombstone(ctx, key)
+					}
+					if err != nil {
+						logger.Errorf("Finish deletion of %s: %s", key, err)
+						mu.Lock()
+						copyErr = err
+						mu.Unlock()
+					} else {
+						logger.Infof("Removed the remaining copies of deleted %s", key)
+					}
+					continue
+				}
+				in, err := r.stores[t.src].Get(ctx, key, 0, -1)
+				var data []byte
+				if err == nil {
+					data, err = io.ReadAll(in)
+					_ = in.Close()
+				}
+				for _, dst := range t.missing {
+					if err == nil {
+						err = r.stores[dst].Put(ctx, key, bytes.NewReader(data))
+					}
+				}
+				if err != nil {
+					logger.Errorf("Backfill %s from %s: %s", key, r.stores[t.src], err)
+					mu.Lock()
+					copyErr = err
+					mu.Unlock()
+				} else if onCopy != nil {
+					onCopy(key, t.obj.Size())
+				}
+			}
+		}()
+	}
+
+	for err == nil {
+		var min Object
+		for _, o := range heads {
+			if o != nil && (min == nil || o.Key() < min.Key()) {
+				min = o
+			}
+		}
+		if min == nil {
+			break
+		}
+		var t = task{obj: min, src: -1, deleted: tombs[min.Key()]}
+		delete(tombs, min.Key())
+		for i, o := range heads {
+			if o != nil && o.Key() == min.Key() {
+				if t.src < 0 {
+					t.src = i
+				}
+				t.present = append(t.present, i)
+				if err = next(i); err != nil {
+					break
+				}
+			} else {
+				t.missing = append(t.missing, i)
+			}
+		}
+		if t.deleted || len(t.missing) > 0 {
+			tasks <- t
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	// the objects with the remaining tombstones are already gone from all the replicas
+	for key := range tombs {
+		if err = r.clearTombstone(ctx, key); err != nil {
+			logger.Warnf("%s", err)
+			copyErr = err
+		}
+	}
+	return copyErr
+}
+
+// NewReplicated creates a storage that replicates objects into the stores given in endpoints (see createStorages),
+// the order of them is the preference of reads.
+func NewReplicated(endpoints, ak, sk, token string) (ObjectStorage, error) {
+	stores, err := createStorages(endpoints, ak, sk, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(stores) < 2 {
+		return nil, fmt.Errorf("at least 2 buckets are required for replication, but got %d", len(stores))
+	}
+	return &replicated{stores: stores}, nil
+}
@@ -27,6 +27,7 @@ type ResponseAttrs struct {
 	storageClass *string
 	requestID    *string
 	requestSize  *int64
+	ifNoneMatch  bool
 	// other interested attrs can be added here
 }
 
@@ -71,6 +72,18 @@ func WithRequestSize(size *int64) AttrGetter {
 	}
 }
 
+// WithIfNoneMatch asks the storage to write the object only if it does not exist, otherwise os.ErrExist is returned.
+// It's ignored by the storages that don't support conditional writes.
+func WithIfNoneMatch() AttrGetter {
+	return func(attrs *ResponseAttrs) {
+		attrs.ifNoneMatch = true
+	}
+}
+
+func (r *ResponseAttrs) IfNoneMatch() bool {
+	return r.ifNoneMatch
+}
+
 func ApplyGetters(getters ...AttrGetter) ResponseAttrs {
 	var attrs ResponseAttrs
 	for _, getter := range getters {
@@ -29,6 +29,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -40,6 +41,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/pkg/errors"
 )
@@ -53,6 +55,15 @@ type s3client struct {
 	region          string
 	sc              string
 	disableChecksum bool
+	noConditional   int32 // conditional writes are not implemented by the service
+}
+
+// copySource returns the source of Copy and UploadPartCopy, objects in access points are named as ARN/object/KEY.
+func (s *s3client) copySource(key string) string {
+	if strings.HasPrefix(s.bucket, "arn:") {
+		return s.bucket + "/object/" + key
+	}
+	return s.bucket + "/" + key
 }
 
 func (s *s3client) String() string {
@@ -76,6 +87,14 @@ func (s *s3client) Limits() Limits {
 	}
 }
 
+func apiErrorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
 func isExists(err error) bool {
 	msg := err.Error()
 	return strings.Contains(msg, "BucketAlreadyExists") || strings.Contains(msg, "BucketAlreadyOwnedByYou")
@@ -177,12 +196,27 @@ func (s *s3client) Put(ctx context.Context, key string, in io.Reader, getters ..
 	}
 	attrs := ApplyGetters(getters...)
 	attrs.SetStorageClass(s.sc)
+	if attrs.IfNoneMatch() && atomic.LoadInt32(&s.noConditional) == 0 {
+		params.IfNoneMatch = aws.String("*")
+	}
 	resp, err := s.s3.PutObject(ctx, params)
+	if err != nil && params.IfNoneMatch != nil && apiErrorCode(err) == "NotImplemented" {
+		logger.Warnf("Conditional writes are not supported by %s, disable them", s)
+		atomic.StoreInt32(&s.noConditional, 1)
+		params.IfNoneMatch = nil
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = s.s3.PutObject(ctx, params)
+	}
 	if err != nil {
 		var re s3.ResponseError
 		if errors.As(err, &re) {
 			attrs.SetRequestID(re.ServiceRequestID())
 		}
+		if params.IfNoneMatch != nil && apiErrorCode(err) == "PreconditionFailed" {
+			return os.ErrExist
+		}
 		return err
 	}
 	if reqID, ok := middleware.GetRequestIDMetadata(resp.ResultMetadata); ok {
@@ -192,7 +226,7 @@ func (s *s3client) Put(ctx context.Context, key string, in io.Reader, getters ..
 }
 
 func (s *s3client) Copy(ctx context.Context, dst, src string) error {
-	src = s.bucket + "/" + src
+	src = s.copySource(src)
 	params := &s3.CopyObjectInput{
 		Bucket:       &s.bucket,
 		Key:          &dst,
@@ -235,9 +269,11 @@ func (s *s3client) List(ctx context.Context, prefix, start, token, delimiter str
 		Prefix:       &prefix,
 		MaxKeys:      aws.Int32(int32(limit)),
 		EncodingType: types.EncodingTypeUrl,
-		StartAfter:   aws.String(start),
 		Delimiter:    aws.String(delimiter),
 	}
+	if start != "" {
+		param.StartAfter = aws.String(start)
+	}
 	if token != "" {
 		param.ContinuationToken = aws.String(token)
 	}
@@ -285,8 +321,42 @@ func (s *s3client) List(ctx context.Context, prefix, start, token, delimiter str
 	return objs, isTruncated, nextMarker, nil
 }
 
+// isDirectoryBucket tells whether the bucket is a directory bucket of S3 Express One Zone.
+func (s *s3client) isDirectoryBucket() bool {
+	return strings.HasSuffix(s.bucket, "--x-s3")
+}
+
 func (s *s3client) ListAll(ctx context.Context, prefix, marker string, followLink bool) (<-chan Object, error) {
-	return nil, notSupported
+	if !s.isDirectoryBucket() {
+		return nil, notSupported
+	}
+	// directory buckets don't return the keys in order, so all of them are sorted in memory
+	var objs []Object
+	var token string
+	for {
+		page, more, next, err := s.List(ctx, prefix, "", token, "", 1000, followLink)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page {
+			if o.Key() > marker {
+				objs = append(objs, o)
+			}
+		}
+		if !more || next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	out := make(chan Object, 1000)
+	go func() {
+		defer close(out)
+		for _, o := range objs {
+			out <- o
+		}
+	}()
+	return out, nil
 }
 
 func (s *s3client) CreateMultipartUpload(ctx context.Context, key string) (*MultipartUpload, error) {
@@ -321,7 +391,7 @@ func (s *s3client) UploadPart(ctx context.Context, key string, uploadID string,
 func (s *s3client) UploadPartCopy(ctx context.Context, key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
 	resp, err := s.s3.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
 		Bucket:          aws.String(s.bucket),
-		CopySource:      aws.String(s.bucket + "/" + srcKey),
+		CopySource:      aws.String(s.copySource(srcKey)),
 		CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", off, off+size-1)),
 		Key:             aws.String(key),
 		PartNumber:      aws.Int32(int32(num)),
@@ -421,6 +491,10 @@ func autoS3Region(bucketName, accessKey, secretKey string) (string, error) {
 }
 
 func parseRegion(endpoint string) string {
+	if strings.HasPrefix(endpoint, "s3express-") {
+		// zonal endpoint of directory buckets: s3express-[ZONE].[REGION].amazonaws.com
+		endpoint = endpoint[strings.Index(endpoint, ".")+1:]
+	}
 	if strings.HasPrefix(endpoint, "s3-") || strings.HasPrefix(endpoint, "s3.") {
 		endpoint = endpoint[3:]
 	}
@@ -445,7 +519,29 @@ func defaultPathStyle() bool {
 var oracleCompileRegexp = `.*\.compat.objectstorage\.(.*)\.oraclecloud\.com`
 var OVHCompileRegexp = `^s3\.(\w*)(\.\w*)?\.cloud\.ovh\.net$`
 
+// parseS3ARN parses the ARN of an access point or Multi-Region Access Point, e.g.
+// arn:aws:s3:us-east-1:123456789012:accesspoint/myap or arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap,
+// the region of Multi-Region Access Points is empty since the requests are signed with SigV4A.
+func parseS3ARN(endpoint string) (arn, region string, query url.Values, err error) {
+	arn = endpoint
+	if p := strings.Index(arn, "?"); p > 0 {
+		if query, err = url.ParseQuery(arn[p+1:]); err != nil {
+			return "", "", nil, fmt.Errorf("Invalid options in %s: %s", endpoint, err)
+		}
+		arn = arn[:p]
+	}
+	arn = strings.TrimRight(arn, "/")
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "s3" || !strings.HasPrefix(parts[5], "accesspoint/") {
+		return "", "", nil, fmt.Errorf("Invalid ARN of access point: %s", arn)
+	}
+	return arn, parts[3], query, nil
+}
+
 func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
+	if strings.HasPrefix(endpoint, "arn:") {
+		return newS3AccessPoint(endpoint, accessKey, secretKey, token)
+	}
 	if !strings.Contains(endpoint, "://") {
 		if len(strings.Split(endpoint, ".")) > 1 && !strings.HasSuffix(endpoint, ".amazonaws.com") {
 			endpoint = fmt.Sprintf("http://%s", endpoint)
@@ -534,8 +630,19 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 	if region == "" {
 		region = awsDefaultRegion
 	}
+	optFns, disableChecksum := s3Options(strings.ToLower(uri.Scheme) == "https", region, uri.Query())
+	if ep != "" {
+		optFns = append(optFns, func(options *s3.Options) {
+			options.BaseEndpoint = aws.String(uri.Scheme + "://" + ep)
+			options.UsePathStyle = defaultPathStyle()
+		})
+	}
+	return newS3Client(bucketName, region, accessKey, secretKey, token, disableChecksum, optFns)
+}
+
+// s3Options returns the options of S3 client from the query of the endpoint.
+func s3Options(ssl bool, region string, query url.Values) ([]func(*s3.Options), bool) {
 	var optFns []func(*s3.Options)
-	ssl := strings.ToLower(uri.Scheme) == "https"
 	optFns = append(optFns, func(options *s3.Options) {
 		options.EndpointOptions.DisableHTTPS = !ssl
 		options.Region = region
@@ -545,24 +652,45 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		options.RetryMaxAttempts = 1
 	})
 
-	disable100Continue := strings.EqualFold(uri.Query().Get("disable-100-continue"), "true")
+	disable100Continue := strings.EqualFold(query.Get("disable-100-continue"), "true")
 	if disable100Continue {
 		logger.Infof("HTTP header 100-Continue is disabled")
 		optFns = append(optFns, func(options *s3.Options) {
 			options.ContinueHeaderThresholdBytes = -1
 		})
 	}
-	disableChecksum := strings.EqualFold(uri.Query().Get("disable-checksum"), "true")
+	disableChecksum := strings.EqualFold(query.Get("disable-checksum"), "true")
 	if disableChecksum {
 		logger.Infof("default CRC checksum is disabled")
 	}
-
-	if ep != "" {
+	if strings.EqualFold(query.Get("requester-pays"), "true") {
+		logger.Infof("Requests are charged to the requester")
 		optFns = append(optFns, func(options *s3.Options) {
-			options.BaseEndpoint = aws.String(uri.Scheme + "://" + ep)
-			options.UsePathStyle = defaultPathStyle()
+			options.APIOptions = append(options.APIOptions, smithyhttp.AddHeaderValue("x-amz-request-payer", string(types.RequestPayerRequester)))
 		})
 	}
+	return optFns, disableChecksum
+}
+
+// newS3AccessPoint creates a client to access the objects through an access point or Multi-Region Access Point.
+func newS3AccessPoint(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
+	arn, region, query, err := parseS3ARN(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		// Multi-Region Access Points are signed with SigV4A for all regions, the region is only used to resolve the endpoint
+		region = os.Getenv("AWS_REGION")
+		if region == "" {
+			region = awsDefaultRegion
+		}
+	}
+	optFns, disableChecksum := s3Options(true, region, query)
+	return newS3Client(arn, region, accessKey, secretKey, token, disableChecksum, optFns)
+}
+
+func newS3Client(bucketName, region, accessKey, secretKey, token string, disableChecksum bool, optFns []func(*s3.Options)) (ObjectStorage, error) {
+	var err error
 	var cfg aws.Config
 	if accessKey == "anonymous" {
 		cfg, err = config.LoadDefaultConfig(ctx,
@@ -17,8 +17,21 @@
 package object
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,3 +55,104 @@ func Test_s3client_full_string(t *testing.T) {
 		})
 	}
 }
+
+func Test_s3client_access_point(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		host     string
+		auth     string
+	}{
+		{endpoint: "arn:aws:s3:us-west-2:123456789012:accesspoint/myap", host: "myap-123456789012.s3-accesspoint.us-west-2.amazonaws.com", auth: "AWS4-HMAC-SHA256"},
+		{endpoint: "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap?requester-pays=true", host: "mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com", auth: "AWS4-ECDSA-P256-SHA256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			stor, err := newS3(tt.endpoint, "ak", "sk", "")
+			if err != nil {
+				t.Fatalf("newS3() error = %v", err)
+			}
+			var req *smithyhttp.Request
+			captured := errors.New("captured")
+			capture := func(o *s3.Options) {
+				o.APIOptions = append(o.APIOptions, func(stack *smithymiddleware.Stack) error {
+					return stack.Finalize.Add(smithymiddleware.FinalizeMiddlewareFunc("capture",
+						func(ctx context.Context, in smithymiddleware.FinalizeInput, next smithymiddleware.FinalizeHandler) (smithymiddleware.FinalizeOutput, smithymiddleware.Metadata, error) {
+							req = in.Request.(*smithyhttp.Request)
+							return smithymiddleware.FinalizeOutput{}, smithymiddleware.Metadata{}, captured
+						}), smithymiddleware.After)
+				})
+			}
+			c := stor.(*s3client)
+			_, err = c.s3.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: &c.bucket, Key: aws.String("test")}, capture)
+			if !errors.Is(err, captured) {
+				t.Fatalf("HeadObject() error = %v", err)
+			}
+			assert.Equal(t, tt.host, req.URL.Host)
+			assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), tt.auth), req.Header.Get("Authorization"))
+			if strings.Contains(tt.endpoint, "requester-pays") {
+				assert.Equal(t, "requester", req.Header.Get("X-Amz-Request-Payer"))
+			}
+		})
+	}
+	if _, err := newS3("arn:aws:iam::123456789012:role/test", "ak", "sk", ""); err == nil {
+		t.Fatalf("newS3() should fail with invalid ARN")
+	}
+}
+
+func Test_s3client_directory_bucket(t *testing.T) {
+	stor, err := newS3("https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("newS3() error = %v", err)
+	}
+	c := stor.(*s3client)
+	assert.Equal(t, "mybucket--usw2-az1--x-s3", c.bucket)
+	assert.Equal(t, "us-west-2", c.region)
+	assert.True(t, c.isDirectoryBucket())
+}
+
+func Test_s3client_conditional_put(t *testing.T) {
+	for _, supported := range []bool{true, false} {
+		var mu sync.Mutex
+		objects := make(map[string][]byte)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Header.Get("If-None-Match") != "" {
+				code, status := "PreconditionFailed", http.StatusPreconditionFailed
+				if !supported {
+					code, status = "NotImplemented", http.StatusNotImplemented
+				} else if _, ok := objects[r.URL.Path]; !ok {
+					status = 0
+				}
+				if status != 0 {
+					w.WriteHeader(status)
+					_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>test</Message></Error>", code)
+					return
+				}
+			}
+			objects[r.URL.Path] = body
+		}))
+		stor, err := newS3(srv.URL+"/bucket", "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("newS3() error = %v", err)
+		}
+		ctx := context.Background()
+		if err = stor.Put(ctx, "key", strings.NewReader("v1"), WithIfNoneMatch()); err != nil {
+			t.Fatalf("put new object: %s", err)
+		}
+		err = stor.Put(ctx, "key", strings.NewReader("v2"), WithIfNoneMatch())
+		if supported {
+			assert.ErrorIs(t, err, os.ErrExist)
+			assert.Equal(t, "v1", string(objects["/bucket/key"]))
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, int32(1), stor.(*s3client).noConditional)
+			assert.Equal(t, "v2", string(objects["/bucket/key"]))
+		}
+		if err = stor.Put(ctx, "key", strings.NewReader("v3")); err != nil {
+			t.Fatalf("overwrite object: %s", err)
+		}
+		srv.Close()
+	}
+}
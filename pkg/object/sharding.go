@@ -24,7 +24,10 @@ import (
 	"hash/fnv"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/juju/ratelimit"
 )
 
 type sharded struct {
@@ -90,6 +93,34 @@ func (s *sharded) SetStorageClass(sc string) error {
 
 const maxResults = 10000
 
+type listLimiter struct {
+	bucket      *ratelimit.Bucket
+	onThrottled func()
+}
+
+var listLimit atomic.Value // *listLimiter
+
+// SetListLimit limits the number of list requests sent by ListAll per second, onThrottled is called
+// when a request is delayed. A rate not greater than 0 removes the limit.
+func SetListLimit(rate int64, onThrottled func()) {
+	if rate <= 0 {
+		listLimit.Store((*listLimiter)(nil))
+	} else {
+		listLimit.Store(&listLimiter{ratelimit.NewBucketWithRate(float64(rate), rate), onThrottled})
+	}
+}
+
+func waitListLimit() {
+	if l, _ := listLimit.Load().(*listLimiter); l != nil {
+		if d := l.bucket.Take(1); d > 0 {
+			if l.onThrottled != nil {
+				l.onThrottled()
+			}
+			time.Sleep(d)
+		}
+	}
+}
+
 // ListAll lists all keys that starts at marker from object storage.
 func ListAll(ctx context.Context, store ObjectStorage, prefix, marker string, followLink, sort bool) (<-chan Object, error) {
 	if ch, err := store.ListAll(ctx, prefix, marker, followLink); err == nil {
@@ -101,6 +132,7 @@ func ListAll(ctx context.Context, store ObjectStorage, prefix, marker string, fo
 	startTime := time.Now()
 	out := make(chan Object, maxResults)
 	logger.Debugf("Listing objects from %s marker %q", store, marker)
+	waitListLimit()
 	objs, hasMore, nextToken, err := store.List(ctx, prefix, marker, "", "", maxResults, followLink)
 	if errors.Is(err, notSupported) {
 		return ListAllWithDelimiter(ctx, store, prefix, marker, "", followLink)
@@ -134,11 +166,13 @@ func ListAll(ctx context.Context, store ObjectStorage, prefix, marker string, fo
 			startTime = time.Now()
 			logger.Debugf("Continue listing objects from %s marker %q", store, marker)
 			var nextToken2 string
+			waitListLimit()
 			objs, hasMore, nextToken2, err = store.List(ctx, prefix, marker, nextToken, "", maxResults, followLink)
 			for err != nil {
 				logger.Warnf("Fail to list: %s, retry again", err.Error())
 				// slow down
 				time.Sleep(time.Millisecond * 100)
+				waitListLimit()
 				objs, hasMore, nextToken, err = store.List(ctx, prefix, marker, nextToken, "", maxResults, followLink)
 			}
 			nextToken = nextToken2
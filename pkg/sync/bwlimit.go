@@ -0,0 +1,120 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juju/ratelimit"
+)
+
+// BWWindow is the bandwidth limit in a daily time window, which wraps around midnight if End is not
+// after Start.
+type BWWindow struct {
+	Start, End time.Duration // since midnight
+	Limit      int64         // in Mbps, 0 means unlimited
+}
+
+func (w BWWindow) contains(t time.Duration) bool {
+	if w.Start < w.End {
+		return t >= w.Start && t < w.End
+	}
+	return t >= w.Start || t < w.End
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expect HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseBWSchedule parses the windows of bandwidth limits like "08:00-20:00=50M,20:00-08:00=0".
+func ParseBWSchedule(s string) ([]BWWindow, error) {
+	var windows []BWWindow
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		rng, limit, ok := strings.Cut(item, "=")
+		if !ok || limit == "" {
+			return nil, fmt.Errorf("invalid window %q, expect HH:MM-HH:MM=LIMIT", item)
+		}
+		start, end, ok := strings.Cut(rng, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, expect HH:MM-HH:MM=LIMIT", item)
+		}
+		var w BWWindow
+		var err error
+		if w.Start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseClock(end); err != nil {
+			return nil, err
+		}
+		if w.Limit, err = utils.ParseMbpsValue(limit); err != nil {
+			return nil, fmt.Errorf("invalid limit %q of window %q: %s", limit, item, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// limitAt returns the limit of the first window containing the time, or 0 if there is none.
+func limitAt(windows []BWWindow, now time.Time) int64 {
+	y, m, d := now.Date()
+	t := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	for _, w := range windows {
+		if w.contains(t) {
+			return w.Limit
+		}
+	}
+	return 0
+}
+
+func setBWLimit(mbps int64) {
+	if mbps <= 0 {
+		limiter.Store(nil)
+		return
+	}
+	bps := float64(mbps*1e6/8) * 0.85 // 15% overhead
+	limiter.Store(ratelimit.NewBucketWithRate(bps, int64(bps)/10))
+}
+
+func throttle(n int64) {
+	if l := limiter.Load(); l != nil {
+		l.Wait(n)
+	}
+}
+
+// followBWSchedule sets the limit of the current window, and changes it when another window begins.
+func followBWSchedule(windows []BWWindow) {
+	current := limitAt(windows, time.Now())
+	setBWLimit(current)
+	logger.Infof("Bandwidth limit is %d Mbps (0 means unlimited) by the schedule", current)
+	go func() {
+		for range time.NewTicker(time.Second * 10).C {
+			if l := limitAt(windows, time.Now()); l != current {
+				logger.Infof("Bandwidth limit changed from %d to %d Mbps (0 means unlimited) by the schedule", current, l)
+				current = l
+				setBWLimit(l)
+			}
+		}
+	}()
+}
@@ -56,6 +56,7 @@ type Config struct {
 	ListThreads    int
 	ListDepth      int
 	BWLimit        int64
+	BWSchedule     []BWWindow
 	NoHTTPS        bool
 	Verbose        bool
 	Quiet          bool
@@ -70,7 +71,12 @@ type Config struct {
 	EndTime        time.Time
 	Env            map[string]string
 
-	FilesFrom string
+	FilesFrom      string
+	SourceManifest *Manifest
+
+	UploadStateDir    string
+	AbortStaleUploads time.Duration
+	StateDB           string
 
 	rules          []rule
 	concurrentList chan int
@@ -196,7 +202,6 @@ func NewConfigFromCli(c *cli.Context) *Config {
 		Workers:        c.StringSlice("worker"),
 		ManagerAddr:    c.String("manager-addr"),
 		Manager:        c.String("manager"),
-		BWLimit:        utils.ParseMbps(c, "bwlimit"),
 		NoHTTPS:        c.Bool("no-https"),
 		Verbose:        c.Bool("verbose"),
 		Quiet:          c.Bool("quiet"),
@@ -212,6 +217,33 @@ func NewConfigFromCli(c *cli.Context) *Config {
 		FilesFrom:      c.String("files-from"),
 		Env:            make(map[string]string),
 	}
+	if bw := c.String("bwlimit"); strings.Contains(bw, "=") {
+		if cfg.BWSchedule, err = ParseBWSchedule(bw); err != nil {
+			logger.Fatalf("bwlimit: %s", err)
+		}
+	} else {
+		cfg.BWLimit = utils.ParseMbps(c, "bwlimit")
+	}
+	cfg.UploadStateDir = c.String("upload-state-dir")
+	cfg.AbortStaleUploads = utils.Duration(c.String("abort-stale-uploads"))
+	cfg.StateDB = c.String("state-db")
+	if cfg.StateDB != "" && len(cfg.Workers) > 0 {
+		logger.Fatal("state-db is not supported in cluster mode")
+	}
+	if cfg.StateDB != "" && cfg.FilesFrom != "" {
+		logger.Fatal("state-db can not be used with files-from")
+	}
+	if c.IsSet("source-manifest") {
+		if cfg.FilesFrom != "" {
+			logger.Fatal("source-manifest can not be used with files-from")
+		}
+		if cfg.DeleteDst {
+			logger.Fatal("source-manifest can not be used with delete-dst, which needs to list the destination")
+		}
+		if cfg.StateDB != "" {
+			logger.Fatal("state-db can not be used with source-manifest")
+		}
+	}
 	if !c.IsSet("max-size") {
 		cfg.MaxSize = math.MaxInt64
 	}
@@ -69,9 +69,7 @@ func (r *parallelDownloader) download() {
 			}
 			var saved bool
 			if !r.hasErr() {
-				if limiter != nil {
-					limiter.Wait(size)
-				}
+				throttle(size)
 				var in io.ReadCloser
 				e := try(3, func() error {
 					var err error
@@ -0,0 +1,223 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sync
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+// Manifest is the listing of the source, which is an S3 Inventory (manifest.json) or a CSV file of
+// KEY[,SIZE[,MTIME]] per line, so the source is not listed.
+type Manifest struct {
+	Store  object.ObjectStorage // the bucket of the manifest, where the data files of S3 Inventory are
+	Key    string
+	Prefix string // the prefix of the source in the keys of S3 Inventory, which is trimmed
+}
+
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// listedObj is an object from the manifest, whose size is -1 if it's not listed.
+type listedObj struct {
+	key   string
+	size  int64
+	mtime time.Time
+}
+
+func (o *listedObj) Key() string          { return o.key }
+func (o *listedObj) Size() int64          { return o.size }
+func (o *listedObj) Mtime() time.Time     { return o.mtime }
+func (o *listedObj) IsDir() bool          { return strings.HasSuffix(o.key, "/") }
+func (o *listedObj) IsSymlink() bool      { return false }
+func (o *listedObj) StorageClass() string { return "" }
+
+func (m *Manifest) String() string {
+	return fmt.Sprintf("%s%s", m.Store, m.Key)
+}
+
+func (m *Manifest) open(key string) (io.ReadCloser, error) {
+	r, err := m.Store.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(key, ".gz") {
+		return r, nil
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("gunzip %s: %s", key, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gr, r}, nil
+}
+
+// walk calls fn for the objects in the manifest, in the order of them.
+func (m *Manifest) walk(fn func(o *listedObj)) error {
+	if !strings.HasSuffix(m.Key, ".json") {
+		return m.walkCSV(m.Key, nil, fn)
+	}
+	r, err := m.open(m.Key)
+	if err != nil {
+		return err
+	}
+	var inv inventoryManifest
+	err = json.NewDecoder(r).Decode(&inv)
+	_ = r.Close()
+	if err != nil {
+		return fmt.Errorf("parse %s: %s", m.Key, err)
+	}
+	if inv.FileFormat != "CSV" {
+		return fmt.Errorf("format %s of S3 Inventory is not supported, only CSV is", inv.FileFormat)
+	}
+	columns := make(map[string]int)
+	for i, name := range strings.Split(inv.FileSchema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["Key"]; !ok {
+		return fmt.Errorf("no Key in the schema of S3 Inventory: %s", inv.FileSchema)
+	}
+	logger.Infof("Read S3 Inventory of bucket %s with %d data files", inv.SourceBucket, len(inv.Files))
+	for _, f := range inv.Files {
+		if err = m.walkCSV(f.Key, columns, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkCSV reads the objects in a CSV file, whose columns are the given ones of S3 Inventory, or
+// KEY[,SIZE[,MTIME]] if columns is nil.
+func (m *Manifest) walkCSV(key string, columns map[string]int, fn func(o *listedObj)) error {
+	r, err := m.open(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	column := func(record []string, name string, plain int) string {
+		i, ok := plain, columns == nil
+		if !ok {
+			i, ok = columns[name]
+		}
+		if ok && i >= 0 && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read %s: %s", key, err)
+		}
+		if column(record, "IsLatest", -1) == "false" || column(record, "IsDeleteMarker", -1) == "true" {
+			continue
+		}
+		o := &listedObj{key: column(record, "Key", 0), size: -1}
+		if columns != nil { // keys of S3 Inventory are URL-encoded
+			if o.key, err = url.QueryUnescape(o.key); err != nil {
+				return fmt.Errorf("%s line %d: invalid key %q", key, line, record[columns["Key"]])
+			}
+			if !strings.HasPrefix(o.key, m.Prefix) {
+				continue
+			}
+			o.key = o.key[len(m.Prefix):]
+		}
+		if o.key == "" {
+			continue
+		}
+		if size := column(record, "Size", 1); size != "" {
+			if o.size, err = strconv.ParseInt(size, 10, 64); err != nil {
+				return fmt.Errorf("%s line %d: invalid size %q", key, line, size)
+			}
+		}
+		if mtime := column(record, "LastModifiedDate", 2); mtime != "" {
+			if o.mtime, err = time.Parse(time.RFC3339, mtime); err != nil {
+				return fmt.Errorf("%s line %d: invalid mtime %q", key, line, mtime)
+			}
+		} else if o.size >= 0 {
+			o.size = -1 // head it to compare the mtime
+		}
+		fn(o)
+	}
+}
+
+// produceFromManifest compares the objects in the manifest with the ones in dst one by one, the ones
+// without size or mtime in it are read from src.
+func produceFromManifest(tasks chan<- object.Object, src, dst object.ObjectStorage, config *Config) error {
+	m := config.SourceManifest
+	logger.Infof("Read the objects to sync from %s", m)
+	objs := make(chan *listedObj, config.Threads)
+	var wg sync.WaitGroup
+	wg.Add(config.Threads)
+	for i := 0; i < config.Threads; i++ {
+		go func() {
+			defer wg.Done()
+			for o := range objs {
+				var err error
+				if o.size < 0 {
+					err = produceSingleObject(tasks, src, dst, o.key, config)
+				} else {
+					err = produceObject(tasks, dst, o, config)
+				}
+				if os.IsNotExist(err) || errors.Is(err, ignoreDir) {
+					atomic.AddInt64(&ignoreFiles, 1)
+				} else if err != nil {
+					failed.Increment()
+				}
+			}
+		}()
+	}
+	err := m.walk(func(o *listedObj) {
+		if o.key < config.Start || config.End != "" && o.key > config.End {
+			return
+		}
+		objs <- o
+	})
+	close(objs)
+	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("read manifest %s: %s", m, err)
+	}
+	return nil
+}
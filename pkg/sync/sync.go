@@ -66,7 +66,7 @@ var (
 	deleted, failed         *utils.Bar
 	listedPrefix            *utils.Bar
 	concurrent              chan int
-	limiter                 *ratelimit.Bucket
+	limiter                 atomic.Pointer[ratelimit.Bucket]
 	totalHandled            atomic.Int64
 )
 var crcTable = crc32.MakeTable(crc32.Castagnoli)
@@ -252,19 +252,21 @@ func try(n int, f func() error) (err error) {
 	return
 }
 
-func deleteObj(storage object.ObjectStorage, key string, dry bool) {
+func deleteObj(storage object.ObjectStorage, key string, dry bool) bool {
 	if dry {
 		logger.Debugf("Will delete %s from %s", key, storage)
 		deleted.Increment()
-		return
+		return true
 	}
 	start := time.Now()
 	if err := try(3, func() error { return storage.Delete(ctx, key) }); err == nil {
 		deleted.Increment()
 		logger.Debugf("Deleted %s from %s in %s", key, storage, time.Since(start))
+		return true
 	} else {
 		failed.Increment()
 		logger.Errorf("Failed to delete %s from %s in %s: %s", key, storage, time.Since(start), err)
+		return false
 	}
 }
 
@@ -291,9 +293,7 @@ func copyPerms(dst object.ObjectStorage, obj object.Object, config *Config) {
 }
 
 func calPartChksum(objStor object.ObjectStorage, key string, abort chan struct{}, offset, length int64) (uint32, error) {
-	if limiter != nil {
-		limiter.Wait(length)
-	}
+	throttle(length)
 	select {
 	case <-abort:
 		return 0, fmt.Errorf("aborted")
@@ -362,9 +362,7 @@ func calObjChksum(objStor object.ObjectStorage, key string, abort chan struct{},
 }
 
 func compObjPartBinary(src, dst object.ObjectStorage, key string, abort chan struct{}, offset, length int64) error {
-	if limiter != nil {
-		limiter.Wait(length)
-	}
+	throttle(length)
 	select {
 	case <-abort:
 		return fmt.Errorf("aborted")
@@ -587,9 +585,7 @@ type withProgress struct {
 }
 
 func (w *withProgress) Read(b []byte) (int, error) {
-	if limiter != nil {
-		limiter.Wait(int64(len(b)))
-	}
+	throttle(int64(len(b)))
 	n, err := w.r.Read(b)
 	copiedBytes.IncrInt64(int64(n))
 	return n, err
@@ -625,9 +621,7 @@ func init() {
 }
 
 func doUploadPart(src, dst object.ObjectStorage, srckey string, off, size int64, key, uploadID string, num int, calChksum bool) (*object.Part, uint32, error) {
-	if limiter != nil {
-		limiter.Wait(size)
-	}
+	throttle(size)
 	start := time.Now()
 	sz := size
 	data := dynAlloc(int(size))
@@ -744,13 +738,13 @@ func doCopyRange(src, dst object.ObjectStorage, key string, off, size int64, upl
 	return part, tmpChksum, err
 }
 
-func doCopyMultiple(src, dst object.ObjectStorage, key string, size int64, upload *object.MultipartUpload, calChksum bool) (uint32, error) {
+func doCopyMultiple(src, dst object.ObjectStorage, key string, size int64, upload *object.MultipartUpload, st *uploadState, calChksum bool) (uint32, error) {
 	limits := dst.Limits()
 	if size > limits.MaxPartSize*int64(upload.MaxCount) {
 		return 0, fmt.Errorf("object size %d is too large to copy", size)
 	}
 
-	partSize := choosePartSize(upload, size)
+	partSize := st.partSize(choosePartSize(upload, size))
 	n := int((size-1)/partSize) + 1
 	logger.Debugf("Copying data of %s as %d parts (size: %d): %s", key, n, partSize, upload.UploadID)
 	abort := make(chan struct{})
@@ -765,27 +759,52 @@ func doCopyMultiple(src, dst object.ObjectStorage, key string, size int64, uploa
 			if num == n-1 {
 				sz = size - int64(num)*partSize
 			}
+			if p := st.uploaded(num); p != nil {
+				parts[num] = p.Part
+				chksums[num] = chksumWithSz{p.Chksum, sz}
+				copiedBytes.IncrInt64(sz)
+				errs <- nil
+				return
+			}
 			var copyErr error
 			var chksum uint32
 			parts[num], chksum, copyErr = doCopyRange(src, dst, key, int64(num)*partSize, sz, upload, num, abort, calChksum)
 			chksums[num] = chksumWithSz{chksum, sz}
+			if copyErr == nil {
+				st.add(num, parts[num], chksum)
+			}
 			errs <- copyErr
 		}(i)
 	}
 
-	for i := 0; i < n; i++ {
+	var i int
+	for ; i < n; i++ {
 		if err = <-errs; err != nil {
 			close(abort)
 			break
 		}
 	}
+	if err != nil && st != nil {
+		// keep the uploaded parts, so it can be resumed next time
+		for i++; i < n; i++ {
+			<-errs
+		}
+		logger.Infof("Upload %s of %s is kept to be resumed later", upload.UploadID, key)
+		return 0, fmt.Errorf("multipart: %s", err)
+	}
 	if err == nil {
 		err = try(3, func() error { return dst.CompleteUpload(ctx, key, upload.UploadID, parts) })
+		if err != nil && st != nil && !brokenUpload(err) {
+			logger.Infof("Upload %s of %s is kept to be resumed later", upload.UploadID, key)
+			return 0, fmt.Errorf("multipart: %s", err)
+		}
 	}
 	if err != nil {
 		dst.AbortUpload(ctx, key, upload.UploadID)
+		st.remove()
 		return 0, fmt.Errorf("multipart: %s", err)
 	}
+	st.remove()
 	var chksum uint32
 	if calChksum {
 		chksum = chksums[0].chksum
@@ -814,20 +833,27 @@ func CopyData(src, dst object.ObjectStorage, key string, size int64, calChksum b
 			return
 		})
 	} else {
-		var upload *object.MultipartUpload
-		if upload, err = dst.CreateMultipartUpload(ctx, key); err == nil {
-			srcChksum, err = doCopyMultiple(src, dst, key, size, upload, calChksum)
-		} else if err == utils.ENOTSUP {
-			err = try(3, func() (err error) {
-				srcChksum, err = doCopySingle(src, dst, key, size, calChksum)
-				return
-			})
-		} else { // other error retry
-			if err = try(2, func() error {
-				upload, err = dst.CreateMultipartUpload(ctx, key)
-				return err
-			}); err == nil {
-				srcChksum, err = doCopyMultiple(src, dst, key, size, upload, calChksum)
+		var mtime time.Time
+		var resumed bool
+		if uploadStateDir != "" {
+			mtime, srcChksum, resumed, err = resumeUpload(src, dst, key, size, calChksum)
+		}
+		if !resumed && err == nil {
+			var upload *object.MultipartUpload
+			if upload, err = dst.CreateMultipartUpload(ctx, key); err == nil {
+				srcChksum, err = doCopyMultiple(src, dst, key, size, upload, newUploadState(dst, key, upload.UploadID, size, mtime), calChksum)
+			} else if err == utils.ENOTSUP {
+				err = try(3, func() (err error) {
+					srcChksum, err = doCopySingle(src, dst, key, size, calChksum)
+					return
+				})
+			} else { // other error retry
+				if err = try(2, func() error {
+					upload, err = dst.CreateMultipartUpload(ctx, key)
+					return err
+				}); err == nil {
+					srcChksum, err = doCopyMultiple(src, dst, key, size, upload, newUploadState(dst, key, upload.UploadID, size, mtime), calChksum)
+				}
 			}
 		}
 	}
@@ -902,11 +928,12 @@ func worker(tasks chan object.Object, src, dst object.ObjectStorage, config *Con
 			break
 		}
 		key := obj.Key()
+		ok := true
 		switch obj.Size() {
 		case markDeleteSrc:
-			deleteObj(src, key, config.Dry)
+			ok = deleteObj(src, key, config.Dry)
 		case markDeleteDst:
-			deleteObj(dst, key, config.Dry)
+			ok = deleteObj(dst, key, config.Dry)
 		case markCopyPerms:
 			if config.Dry {
 				logger.Debugf("Will copy permissions for %s", key)
@@ -923,6 +950,7 @@ func worker(tasks chan object.Object, src, dst object.ObjectStorage, config *Con
 			obj = withoutSize(obj)
 			if equal, err := checkSum(src, dst, key, nil, obj, config); err != nil {
 				failed.Increment()
+				ok = false
 				break
 			} else if equal {
 				if config.DeleteSrc {
@@ -931,7 +959,7 @@ func worker(tasks chan object.Object, src, dst object.ObjectStorage, config *Con
 						srcDelayDel = append(srcDelayDel, key)
 						srcDelayDelMu.Unlock()
 					} else {
-						deleteObj(src, key, false)
+						ok = deleteObj(src, key, false)
 					}
 				} else if config.Perms && (!obj.IsSymlink() || !config.Links) {
 					if o, e := dst.Head(ctx, key); e == nil {
@@ -945,6 +973,7 @@ func worker(tasks chan object.Object, src, dst object.ObjectStorage, config *Con
 					} else {
 						logger.Warnf("Failed to head object %s: %s", key, e)
 						failed.Increment()
+						ok = false
 					}
 				} else {
 					skipped.Increment()
@@ -1000,9 +1029,11 @@ func worker(tasks chan object.Object, src, dst object.ObjectStorage, config *Con
 				skipped.Increment()
 			} else {
 				failed.Increment()
+				ok = false
 				logger.Errorf("Failed to copy object %s: %s", key, err)
 			}
 		}
+		syncProgress.finish(key, ok)
 		incrHandled(1)
 		done()
 	}
@@ -1094,12 +1125,13 @@ var dstDelayDel []string
 var srcDelayDelMu sync.Mutex
 var srcDelayDel []string
 
-func handleExtraObject(tasks chan<- object.Object, dstobj object.Object, config *Config) bool {
+func handleExtraObject(tasks chan<- object.Object, dstobj object.Object, ps *prefixState, config *Config) bool {
 	incrTotal(1)
 	if !config.DeleteDst || !config.Dirs && dstobj.IsDir() || config.Limit == 0 {
 		logger.Debug("Ignore extra object", dstobj.Key())
 		extra.Increment()
 		extraBytes.IncrInt64(dstobj.Size())
+		syncProgress.pass(ps, dstobj.Key())
 		return false
 	}
 	config.Limit--
@@ -1107,14 +1139,21 @@ func handleExtraObject(tasks chan<- object.Object, dstobj object.Object, config
 		dstDelayDelMu.Lock()
 		dstDelayDel = append(dstDelayDel, dstobj.Key())
 		dstDelayDelMu.Unlock()
+		syncProgress.pass(ps, dstobj.Key())
 	} else {
+		syncProgress.add(ps, dstobj.Key())
 		tasks <- withSize(dstobj, markDeleteDst)
 	}
 	return config.Limit == 0
 }
 
 func startSingleProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, prefix string, config *Config) error {
-	start, end := config.Start, config.End
+	ps := syncProgress.prefix(prefix)
+	if syncProgress.completed(ps) {
+		logger.Debugf("skip prefix %s, which is synced already", prefix)
+		return nil
+	}
+	start, end := syncProgress.start(ps, config.Start), config.End
 	logger.Debugf("maxResults: %d, defaultPartSize: %d, maxBlock: %d", maxResults, defaultPartSize, maxBlock)
 
 	srckeys, err := ListAll(src, prefix, start, end, !config.Links)
@@ -1133,10 +1172,13 @@ func startSingleProducer(tasks chan<- object.Object, src, dst object.ObjectStora
 			return fmt.Errorf("list %s: %s", dst, err)
 		}
 	}
-	return produce(tasks, srckeys, dstkeys, config)
+	if err = produce(tasks, srckeys, dstkeys, ps, config); err == nil && config.Limit != 0 {
+		syncProgress.listed(ps)
+	}
+	return err
 }
 
-func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object, config *Config) error {
+func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object, ps *prefixState, config *Config) error {
 	srckeys = filter(srckeys, config.rules, config)
 	dstkeys = filter(dstkeys, config.rules, config)
 	var dstobj object.Object
@@ -1151,7 +1193,12 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 		skip, skipBytes = 0, 0
 	}
 	defer flushProgress()
+	send := func(obj object.Object) {
+		syncProgress.add(ps, obj.Key())
+		tasks <- obj
+	}
 	skipIt := func(obj object.Object) {
+		syncProgress.pass(ps, obj.Key())
 		skip++
 		skipBytes += obj.Size()
 		if skip > 100 || time.Since(lastUpdate) > time.Millisecond*100 {
@@ -1165,6 +1212,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 		}
 		if !config.Dirs && obj.IsDir() {
 			logger.Debug("Ignore directory ", obj.Key())
+			syncProgress.pass(ps, obj.Key())
 			continue
 		}
 		if config.Limit >= 0 {
@@ -1176,7 +1224,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 		incrTotal(1)
 
 		if dstobj != nil && obj.Key() > dstobj.Key() {
-			if handleExtraObject(tasks, dstobj, config) {
+			if handleExtraObject(tasks, dstobj, ps, config) {
 				return nil
 			}
 			dstobj = nil
@@ -1189,7 +1237,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 				if obj.Key() <= dstobj.Key() {
 					break
 				}
-				if handleExtraObject(tasks, dstobj, config) {
+				if handleExtraObject(tasks, dstobj, ps, config) {
 					return nil
 				}
 				dstobj = nil
@@ -1202,7 +1250,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 				skipIt(obj)
 				continue
 			}
-			tasks <- obj
+			send(obj)
 		} else { // obj.key == dstobj.key
 			if config.IgnoreExisting {
 				skipIt(obj)
@@ -1212,21 +1260,22 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 			if config.ForceUpdate ||
 				(config.Update && obj.Mtime().Unix() > dstobj.Mtime().Unix()) ||
 				(!config.Update && obj.Size() != dstobj.Size()) {
-				tasks <- obj
+				send(obj)
 			} else if config.Update && obj.Mtime().Unix() < dstobj.Mtime().Unix() {
 				skipIt(obj)
 			} else if config.CheckAll { // two objects are likely the same
-				tasks <- withSize(obj, markChecksum)
+				send(withSize(obj, markChecksum))
 			} else if config.DeleteSrc {
 				if obj.IsDir() {
 					srcDelayDelMu.Lock()
 					srcDelayDel = append(srcDelayDel, obj.Key())
 					srcDelayDelMu.Unlock()
+					syncProgress.pass(ps, obj.Key())
 				} else {
-					tasks <- withSize(obj, markDeleteSrc)
+					send(withSize(obj, markDeleteSrc))
 				}
 			} else if config.Perms && needCopyPerms(obj, dstobj) {
-				tasks <- withSize(obj, markCopyPerms)
+				send(withSize(obj, markCopyPerms))
 			} else {
 				skipIt(obj)
 			}
@@ -1235,7 +1284,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 	}
 	if config.DeleteDst {
 		if dstobj != nil {
-			if handleExtraObject(tasks, dstobj, config) {
+			if handleExtraObject(tasks, dstobj, ps, config) {
 				return nil
 			}
 		}
@@ -1243,7 +1292,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 			if dstobj == nil {
 				return fmt.Errorf("listing failed, stop syncing, waiting for pending ones")
 			}
-			if handleExtraObject(tasks, dstobj, config) {
+			if handleExtraObject(tasks, dstobj, ps, config) {
 				return nil
 			}
 		}
@@ -1578,22 +1627,7 @@ var ignoreFiles int64
 func produceSingleObject(tasks chan<- object.Object, src, dst object.ObjectStorage, key string, config *Config) error {
 	obj, err := src.Head(ctx, key)
 	if err == nil && (!obj.IsDir() || obj.IsSymlink() && config.Links || obj.IsDir() && config.Dirs && strings.HasSuffix(key, "/")) {
-		var srckeys = make(chan object.Object, 1)
-		srckeys <- obj
-		close(srckeys)
-		if dobj, e := dst.Head(ctx, key); e == nil || os.IsNotExist(e) {
-			var dstkeys = make(chan object.Object, 1)
-			if dobj != nil {
-				dstkeys <- dobj
-			}
-			close(dstkeys)
-			logger.Debugf("produce single key %s", key)
-			_ = produce(tasks, srckeys, dstkeys, config)
-			return nil
-		} else {
-			logger.Warnf("head %s from %s: %s", key, dst, e)
-			err = e
-		}
+		err = produceObject(tasks, dst, obj, config)
 	} else if err != nil {
 		logger.Warnf("head %s from %s: %s", key, src, err)
 	} else {
@@ -1602,6 +1636,26 @@ func produceSingleObject(tasks chan<- object.Object, src, dst object.ObjectStora
 	return err
 }
 
+// produceObject compares the source object with the one of the same key in dst.
+func produceObject(tasks chan<- object.Object, dst object.ObjectStorage, obj object.Object, config *Config) error {
+	var srckeys = make(chan object.Object, 1)
+	srckeys <- obj
+	close(srckeys)
+	dobj, err := dst.Head(ctx, obj.Key())
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("head %s from %s: %s", obj.Key(), dst, err)
+		return err
+	}
+	var dstkeys = make(chan object.Object, 1)
+	if dobj != nil {
+		dstkeys <- dobj
+	}
+	close(dstkeys)
+	logger.Debugf("produce single key %s", obj.Key())
+	_ = produce(tasks, srckeys, dstkeys, nil, config)
+	return nil
+}
+
 func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, prefix string, listDepth int, config *Config) error {
 	config.concurrentList <- 1
 	defer func() {
@@ -1681,9 +1735,14 @@ func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, pr
 		}
 	}
 	// sync returned objects
-	if err := produce(tasks, srckeys, dstkeys, config); err != nil {
+	ps := syncProgress.prefix(prefix)
+	dstkeys = syncProgress.skipDone(ps, dstkeys)
+	if err := produce(tasks, syncProgress.skipDone(ps, srckeys), dstkeys, ps, config); err != nil {
 		return err
 	}
+	if config.Limit != 0 {
+		syncProgress.listed(ps)
+	}
 	// consume all the keys from dst
 	for range dstkeys {
 	}
@@ -1714,6 +1773,10 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 	if config.Inplace {
 		object.PutInplace = true
 	}
+	uploadStateDir = config.UploadStateDir
+	if config.AbortStaleUploads > 0 && config.Manager == "" {
+		abortStaleUploads(dst, config.AbortStaleUploads)
+	}
 
 	var bufferSize = 10240
 	if config.Manager != "" {
@@ -1723,9 +1786,10 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 	tasks := make(chan object.Object, bufferSize)
 	wg := sync.WaitGroup{}
 	concurrent = make(chan int, config.Threads)
-	if config.BWLimit > 0 {
-		bps := float64(config.BWLimit*1e6/8) * 0.85 // 15% overhead
-		limiter = ratelimit.NewBucketWithRate(bps, int64(bps)/10)
+	if len(config.BWSchedule) > 0 {
+		followBWSchedule(config.BWSchedule)
+	} else {
+		setBWLimit(config.BWLimit)
 	}
 
 	progress := utils.NewProgress(config.Verbose || config.Quiet || config.Manager != "")
@@ -1800,6 +1864,7 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 					if failed.Current() >= config.MaxFailure {
 						logger.Infof("the maximum error limit of %d was reached, stop now", config.MaxFailure)
 						_ = syncExitFunc()
+						syncProgress.close(false)
 						os.Exit(1)
 					}
 					time.Sleep(time.Millisecond * 100)
@@ -1847,14 +1912,22 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 		if config.End != "" {
 			logger.Infof("last key: %q", config.End)
 		}
+		syncProgress = nil
+		if config.StateDB != "" && config.FilesFrom == "" && config.SourceManifest == nil {
+			syncProgress = loadSyncState(config.StateDB, src, dst)
+			syncProgress.keepSaving(time.Second * 3)
+		}
 		config.concurrentList = make(chan int, config.ListThreads)
 		var err error
 		if config.FilesFrom != "" {
 			err = produceFromList(tasks, src, dst, config)
+		} else if config.SourceManifest != nil {
+			err = produceFromManifest(tasks, src, dst, config)
 		} else {
 			err = startProducer(tasks, src, dst, "", config.ListDepth, config)
 		}
 		if err != nil {
+			syncProgress.close(false)
 			return err
 		}
 		noMoreTask(tasks)
@@ -1894,7 +1967,9 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 		}()
 		delWg.Wait()
 	}
-	return syncExitFunc()
+	err := syncExitFunc()
+	syncProgress.close(err == nil)
+	return err
 }
 
 func initSyncMetrics(config *Config) {
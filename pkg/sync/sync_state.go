@@ -0,0 +1,305 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+// the progress of the sync to resume it, nil means disabled
+var syncProgress *syncState
+
+type pendingKey struct {
+	key  string
+	done bool
+	ps   *prefixState
+}
+
+// prefixState is the progress of the keys listed from a prefix, in order. The prefixes are listed in
+// parallel, and the keys in the sub-prefixes (listed separately) are not counted in the parent.
+type prefixState struct {
+	Done      string `json:",omitempty"` // all the keys up to it (inclusive) are handled
+	Completed bool   `json:",omitempty"` // all the keys are handled
+
+	pending []*pendingKey // in the order of keys
+	listed  bool          // all the keys are listed
+	failed  bool          // a task failed, the progress can't go beyond it
+}
+
+// syncState is the progress of a sync, which is saved periodically, so an interrupted sync can continue
+// listing from where it stopped. Since the keys are listed in order and handled concurrently, the
+// progress of a prefix is the last key that all the keys up to it are handled, the few ones after it that
+// have been handled are compared again after resuming.
+type syncState struct {
+	sync.Mutex
+	path     string
+	Src      string
+	Dst      string
+	Prefixes map[string]*prefixState // the listed prefixes
+	Updated  time.Time               // when the state is saved
+
+	byKey   map[string]*pendingKey
+	changed bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// loadSyncState returns the saved state of the sync from src to dst, or a new one if there is none.
+func loadSyncState(path string, src, dst object.ObjectStorage) *syncState {
+	st := &syncState{path: path, Src: src.String(), Dst: dst.String(), Prefixes: make(map[string]*prefixState),
+		byKey: make(map[string]*pendingKey)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Read sync state %s: %s", path, err)
+		}
+		return st
+	}
+	var saved syncState
+	if err = json.Unmarshal(data, &saved); err != nil {
+		logger.Warnf("Parse sync state %s: %s, start over", path, err)
+		return st
+	}
+	if saved.Src != st.Src || saved.Dst != st.Dst {
+		logger.Warnf("Sync state %s is for %s -> %s, start over", path, saved.Src, saved.Dst)
+		return st
+	}
+	for prefix, ps := range saved.Prefixes {
+		if ps != nil {
+			st.Prefixes[prefix] = &prefixState{Done: ps.Done, Completed: ps.Completed}
+		}
+	}
+	st.Updated = saved.Updated
+	if len(st.Prefixes) > 0 {
+		logger.Infof("Resume the sync of %d prefixes, which was saved at %s", len(st.Prefixes), st.Updated.Format(time.RFC3339))
+	}
+	return st
+}
+
+// prefix returns the progress of the keys listed from the prefix.
+func (st *syncState) prefix(prefix string) *prefixState {
+	if st == nil {
+		return nil
+	}
+	st.Lock()
+	defer st.Unlock()
+	ps := st.Prefixes[prefix]
+	if ps == nil {
+		ps = &prefixState{}
+		st.Prefixes[prefix] = ps
+	} else {
+		ps.pending, ps.listed, ps.failed = nil, false, false
+	}
+	return ps
+}
+
+// completed tells whether all the keys of the prefix are handled, so it's not listed again.
+func (st *syncState) completed(ps *prefixState) bool {
+	if ps == nil {
+		return false
+	}
+	st.Lock()
+	defer st.Unlock()
+	return ps.Completed
+}
+
+// start returns the first key to list from the prefix, skipping the handled ones.
+func (st *syncState) start(ps *prefixState, start string) string {
+	if ps == nil {
+		return start
+	}
+	st.Lock()
+	defer st.Unlock()
+	if ps.Done == "" || ps.Done < start {
+		return start
+	}
+	return ps.Done + "\x00"
+}
+
+// skipDone drops the handled keys of the prefix from the listed ones.
+func (st *syncState) skipDone(ps *prefixState, keys <-chan object.Object) <-chan object.Object {
+	if ps == nil {
+		return keys
+	}
+	done := st.start(ps, "")
+	if done == "" {
+		return keys
+	}
+	r := make(chan object.Object, cap(keys))
+	go func() {
+		defer close(r)
+		for o := range keys {
+			if o == nil || o.Key() >= done {
+				r <- o
+			}
+		}
+	}()
+	return r
+}
+
+// add tracks the key of a task in the order of keys, before it's sent to the workers.
+func (st *syncState) add(ps *prefixState, key string) {
+	if ps == nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	if ps.failed {
+		return
+	}
+	p := &pendingKey{key: key, ps: ps}
+	ps.pending = append(ps.pending, p)
+	st.byKey[key] = p
+}
+
+// pass marks the key as handled, which does not need a task.
+func (st *syncState) pass(ps *prefixState, key string) {
+	if ps == nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	if ps.failed {
+		return
+	}
+	if len(ps.pending) == 0 {
+		ps.Done, st.changed = key, true
+	} else {
+		ps.pending = append(ps.pending, &pendingKey{key: key, done: true, ps: ps})
+	}
+}
+
+// listed marks all the keys of the prefix are listed, it's completed once they are handled.
+func (st *syncState) listed(ps *prefixState) {
+	if ps == nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	ps.listed = true
+	st.advance(ps)
+}
+
+func (st *syncState) advance(ps *prefixState) {
+	var i int
+	for i < len(ps.pending) && ps.pending[i].done {
+		ps.Done, st.changed = ps.pending[i].key, true
+		i++
+	}
+	ps.pending = ps.pending[i:]
+	if ps.listed && len(ps.pending) == 0 && !ps.failed && !ps.Completed {
+		ps.Completed, st.changed = true, true
+	}
+}
+
+// finish marks the task of the key as handled, or stops the progress of its prefix if it failed.
+func (st *syncState) finish(key string, ok bool) {
+	if st == nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	p := st.byKey[key]
+	if p == nil {
+		return
+	}
+	delete(st.byKey, key)
+	ps := p.ps
+	if ps.failed {
+		return
+	}
+	if !ok {
+		logger.Warnf("Sync state stops before %q, which failed", key)
+		ps.failed = true
+		for _, o := range ps.pending {
+			delete(st.byKey, o.key)
+		}
+		ps.pending = nil
+		return
+	}
+	p.done = true
+	st.advance(ps)
+}
+
+func (st *syncState) save() {
+	st.Lock()
+	if !st.changed {
+		st.Unlock()
+		return
+	}
+	st.changed = false
+	st.Updated = time.Now()
+	data, err := json.Marshal(st)
+	st.Unlock()
+	if err != nil {
+		logger.Warnf("Encode sync state: %s", err)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		logger.Warnf("Create directory for sync state: %s", err)
+		return
+	}
+	tmp := st.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, st.path)
+	}
+	if err != nil {
+		logger.Warnf("Save sync state %s: %s", st.path, err)
+	}
+}
+
+// keepSaving saves the state periodically until it's closed.
+func (st *syncState) keepSaving(interval time.Duration) {
+	st.stop, st.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(st.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				st.save()
+			case <-st.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close saves the state at last, or removes it if the sync is completed, so the next one starts from
+// the beginning.
+func (st *syncState) close(completed bool) {
+	if st == nil {
+		return
+	}
+	if st.stop != nil {
+		close(st.stop)
+		<-st.stopped
+		st.stop = nil
+	}
+	if !completed {
+		st.save()
+	} else if err := os.Remove(st.path); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Remove sync state %s: %s", st.path, err)
+	}
+}
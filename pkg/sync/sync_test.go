@@ -17,12 +17,17 @@ package sync
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -796,3 +801,317 @@ func TestFilterSizeAndAge(t *testing.T) {
 		t.Fatalf("filterKey should fail")
 	}
 }
+
+// multipartStore keeps the uploaded parts in memory, the parts listed in failing can not be uploaded.
+type multipartStore struct {
+	object.ObjectStorage
+	sync.Mutex
+	uploads  map[string]map[int][]byte
+	created  map[string]time.Time
+	keys     map[string]string
+	uploaded int
+	failing  map[int]bool
+}
+
+func (s *multipartStore) Limits() object.Limits {
+	return object.Limits{MinPartSize: 5 << 20, MaxPartSize: 5 << 30, IsSupportMultipartUpload: true}
+}
+
+func (s *multipartStore) CreateMultipartUpload(ctx context.Context, key string) (*object.MultipartUpload, error) {
+	s.Lock()
+	defer s.Unlock()
+	id := fmt.Sprintf("upload-%d", len(s.keys))
+	s.uploads[id] = make(map[int][]byte)
+	s.created[id] = time.Now()
+	s.keys[id] = key
+	return &object.MultipartUpload{MinPartSize: 5 << 20, MaxCount: 10000, UploadID: id}, nil
+}
+
+func (s *multipartStore) UploadPart(ctx context.Context, key string, uploadID string, num int, body []byte) (*object.Part, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.failing[num] {
+		return nil, syscall.EACCES
+	}
+	s.uploads[uploadID][num] = append([]byte{}, body...)
+	s.uploaded++
+	return &object.Part{Num: num, Size: len(body), ETag: fmt.Sprintf("etag-%d", num)}, nil
+}
+
+func (s *multipartStore) AbortUpload(ctx context.Context, key string, uploadID string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.uploads, uploadID)
+}
+
+func (s *multipartStore) CompleteUpload(ctx context.Context, key string, uploadID string, parts []*object.Part) error {
+	s.Lock()
+	defer s.Unlock()
+	var buf bytes.Buffer
+	for _, p := range parts {
+		data, ok := s.uploads[uploadID][p.Num]
+		if !ok {
+			return fmt.Errorf("part %d of %s is missing", p.Num, uploadID)
+		}
+		buf.Write(data)
+	}
+	delete(s.uploads, uploadID)
+	return s.ObjectStorage.Put(ctx, key, &buf)
+}
+
+func (s *multipartStore) ListUploads(ctx context.Context, marker string) ([]*object.PendingPart, string, error) {
+	s.Lock()
+	defer s.Unlock()
+	var parts []*object.PendingPart
+	for id := range s.uploads {
+		parts = append(parts, &object.PendingPart{Key: s.keys[id], UploadID: id, Created: s.created[id]})
+	}
+	return parts, "", nil
+}
+
+func TestResumeUpload(t *testing.T) {
+	uploadStateDir = t.TempDir()
+	defer func() { uploadStateDir = "" }()
+	InitForCopyData()
+	src, _ := object.CreateStorage("mem", "src", "", "", "")
+	mem, _ := object.CreateStorage("mem", "dst", "", "", "")
+	dst := &multipartStore{
+		ObjectStorage: mem,
+		uploads:       make(map[string]map[int][]byte),
+		created:       make(map[string]time.Time),
+		keys:          make(map[string]string),
+		failing:       map[int]bool{3: true},
+	}
+	data := make([]byte, 4*defaultPartSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	_ = src.Put(ctx, "big", bytes.NewReader(data))
+
+	if _, err := CopyData(src, dst, "big", int64(len(data)), false); err == nil {
+		t.Fatalf("copy should fail")
+	}
+	if dst.uploaded >= 5 || len(dst.uploads) != 1 {
+		t.Fatalf("expect 1 incomplete upload, but got %d parts in %d uploads", dst.uploaded, len(dst.uploads))
+	}
+	if entries, _ := os.ReadDir(uploadStateDir); len(entries) != 1 {
+		t.Fatalf("expect 1 upload state, but got %d", len(entries))
+	}
+
+	// an upload of another object which is not resumable
+	_, _ = dst.CreateMultipartUpload(ctx, "orphan")
+	abortStaleUploads(dst, -time.Second)
+	if len(dst.uploads) != 1 {
+		t.Fatalf("stale upload should be aborted: %d uploads left", len(dst.uploads))
+	}
+
+	// a transient failure keeps the upload to be resumed
+	if _, err := CopyData(src, dst, "big", int64(len(data)), false); err == nil {
+		t.Fatalf("copy should fail")
+	}
+	if entries, _ := os.ReadDir(uploadStateDir); len(entries) != 1 || len(dst.uploads) != 1 {
+		t.Fatalf("upload should be kept: %d states, %d uploads", len(entries), len(dst.uploads))
+	}
+
+	dst.failing = nil
+	if _, err := CopyData(src, dst, "big", int64(len(data)), false); err != nil {
+		t.Fatalf("resume: %s", err)
+	}
+	if dst.uploaded != 5 {
+		t.Fatalf("every part should be uploaded once, but uploaded %d parts", dst.uploaded)
+	}
+	in, err := dst.Get(ctx, "big", 0, -1)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if got, _ := io.ReadAll(in); !bytes.Equal(got, data) {
+		t.Fatalf("data mismatch")
+	}
+	if entries, _ := os.ReadDir(uploadStateDir); len(entries) != 0 {
+		t.Fatalf("upload state should be removed, but got %d", len(entries))
+	}
+	if !brokenUpload(fmt.Errorf("multipart: NoSuchUpload: The specified upload does not exist")) || brokenUpload(syscall.EACCES) {
+		t.Fatalf("only the uploads that can't be completed are broken")
+	}
+}
+
+func TestSyncState(t *testing.T) {
+	src, _ := object.CreateStorage("mem", "src", "", "", "")
+	dst, _ := object.CreateStorage("mem", "dst", "", "", "")
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	st := loadSyncState(path, src, dst)
+	ps := st.prefix("")
+	st.add(ps, "a")
+	st.pass(ps, "b")
+	st.add(ps, "c")
+	st.finish("c", true)
+	if ps.Done != "" {
+		t.Fatalf("progress should not go beyond the pending key a: %q", ps.Done)
+	}
+	st.finish("a", true)
+	if ps.Done != "c" {
+		t.Fatalf("expect progress c, but got %q", ps.Done)
+	}
+	st.add(ps, "d")
+	st.pass(ps, "e")
+	st.finish("d", false)
+	st.pass(ps, "f")
+	if ps.Done != "c" {
+		t.Fatalf("progress should stop before the failed key d: %q", ps.Done)
+	}
+	// the prefixes are tracked separately
+	ps2 := st.prefix("x/")
+	st.add(ps2, "x/1")
+	st.listed(ps2)
+	if ps2.Completed {
+		t.Fatalf("prefix x/ should not be completed with pending key")
+	}
+	st.finish("x/1", true)
+	if !ps2.Completed || ps2.Done != "x/1" {
+		t.Fatalf("prefix x/ should be completed: %+v", ps2)
+	}
+	st.close(false)
+	if st = loadSyncState(path, src, dst); st.Prefixes[""].Done != "c" || !st.Prefixes["x/"].Completed {
+		t.Fatalf("expect saved progress c and completed x/, but got %+v", st.Prefixes)
+	}
+	if st = loadSyncState(path, dst, src); len(st.Prefixes) != 0 {
+		t.Fatalf("state of another sync should not be used: %+v", st.Prefixes)
+	}
+
+	for _, k := range []string{"k1", "k2", "k3", "k4", "k5", "a/1", "a/2", "b/1", "b/2", "b/3", "c/1"} {
+		_ = src.Put(ctx, k, bytes.NewReader([]byte(k)))
+	}
+	config := &Config{
+		Threads:     10,
+		ListThreads: 1,
+		ListDepth:   1,
+		Limit:       -1,
+		MaxSize:     math.MaxInt64,
+		Quiet:       true,
+		StateDB:     path,
+	}
+	st = loadSyncState(path, src, dst)
+	st.Prefixes[""] = &prefixState{Done: "b/2"}
+	st.changed = true
+	st.close(false)
+	if err := Sync(src, dst, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	for _, k := range []string{"b/3", "c/1", "k1"} {
+		if _, err := dst.Head(ctx, k); err != nil {
+			t.Fatalf("%s should be copied: %s", k, err)
+		}
+	}
+	if _, err := dst.Head(ctx, "b/2"); err == nil {
+		t.Fatalf("b/2 should not be copied")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state should be removed after the sync is completed: %v", err)
+	}
+
+	// list the prefixes in parallel
+	for _, k := range []string{"k1", "b/3", "c/1"} {
+		_ = dst.Delete(ctx, k)
+	}
+	st = loadSyncState(path, src, dst)
+	st.Prefixes[""] = &prefixState{Done: "k3"}
+	st.Prefixes["a/"] = &prefixState{Done: "a/2", Completed: true}
+	st.Prefixes["b/"] = &prefixState{Done: "b/1"}
+	st.changed = true
+	st.close(false)
+	config.ListThreads = 4
+	if err := Sync(src, dst, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	for _, k := range []string{"k4", "k5", "b/2", "b/3", "c/1"} {
+		if _, err := dst.Head(ctx, k); err != nil {
+			t.Fatalf("%s should be copied: %s", k, err)
+		}
+	}
+	for _, k := range []string{"k1", "a/1", "b/1"} {
+		if _, err := dst.Head(ctx, k); err == nil {
+			t.Fatalf("%s should not be copied", k)
+		}
+	}
+}
+
+func TestBWSchedule(t *testing.T) {
+	windows, err := ParseBWSchedule("08:00-20:00=50M, 20:00-23:30=1G")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	for clock, expect := range map[time.Duration]int64{
+		7 * time.Hour:                 0,
+		8 * time.Hour:                 50,
+		19*time.Hour + 59*time.Minute: 50,
+		20 * time.Hour:                1000,
+		23*time.Hour + 30*time.Minute: 0,
+	} {
+		if l := limitAt(windows, day.Add(clock)); l != expect {
+			t.Fatalf("limit at %s: expect %d, but got %d", clock, expect, l)
+		}
+	}
+	if windows, err = ParseBWSchedule("22:00-06:00=10"); err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	if limitAt(windows, day.Add(time.Hour)) != 10 || limitAt(windows, day.Add(23*time.Hour)) != 10 || limitAt(windows, day.Add(12*time.Hour)) != 0 {
+		t.Fatalf("window should wrap around midnight")
+	}
+	for _, s := range []string{"08:00=50M", "8-20=50M", "08:00-20:00", "08:00-20:00=50X", "08:00-20:00=fast"} {
+		if _, err = ParseBWSchedule(s); err == nil {
+			t.Fatalf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestSourceManifest(t *testing.T) {
+	bucket, _ := object.CreateStorage("mem", "src", "", "", "")
+	inv, _ := object.CreateStorage("mem", "inventory", "", "", "")
+	src := object.WithPrefix(bucket, "data/")
+	now := time.Now().UTC().Format(time.RFC3339)
+	var csv bytes.Buffer
+	for _, k := range []string{"k1", "k 2", "k3"} {
+		_ = src.Put(ctx, k, bytes.NewReader([]byte(k)))
+		fmt.Fprintf(&csv, "\"src\",\"data/%s\",\"%d\",\"%s\"\n", strings.ReplaceAll(k, " ", "%20"), len(k), now)
+	}
+	fmt.Fprintf(&csv, "\"src\",\"other/k4\",\"2\",\"%s\"\n", now)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(csv.Bytes())
+	_ = w.Close()
+	_ = inv.Put(ctx, "src/all/data/1.csv.gz", &gz)
+	_ = inv.Put(ctx, "src/all/2024-06-01T00-00Z/manifest.json", strings.NewReader(`{"sourceBucket":"src","fileFormat":"CSV",
+"fileSchema":"Bucket, Key, Size, LastModifiedDate","files":[{"key":"src/all/data/1.csv.gz"}]}`))
+	_ = inv.Put(ctx, "keys.csv", strings.NewReader("k1\nk5\n"))
+
+	dst, _ := object.CreateStorage("mem", "dst", "", "", "")
+	config := &Config{
+		Threads:        10,
+		ListThreads:    1,
+		ListDepth:      1,
+		Limit:          -1,
+		MaxSize:        math.MaxInt64,
+		Quiet:          true,
+		SourceManifest: &Manifest{Store: inv, Key: "src/all/2024-06-01T00-00Z/manifest.json", Prefix: "data/"},
+	}
+	if err := Sync(src, dst, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if c := copied.Current(); c != 3 {
+		t.Fatalf("should copy 3 keys, but got %d", c)
+	}
+	if _, err := dst.Head(ctx, "k 2"); err != nil {
+		t.Fatalf("key should be decoded: %s", err)
+	}
+
+	_ = dst.Delete(ctx, "k1")
+	config.SourceManifest = &Manifest{Store: inv, Key: "keys.csv"}
+	if err := Sync(src, dst, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if c := copied.Current(); c != 1 {
+		t.Fatalf("should copy k1 only, but got %d", c)
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+// directory to persist the state of multipart uploads, empty means disabled
+var uploadStateDir string
+
+type uploadedPart struct {
+	*object.Part
+	Chksum uint32
+}
+
+// uploadState is the state of an in-flight multipart upload, which is saved after every part is
+// uploaded, so the upload can be resumed after the process is restarted.
+type uploadState struct {
+	sync.Mutex
+	path     string
+	Dst      string
+	Key      string
+	UploadID string
+	Size     int64
+	Mtime    time.Time // mtime of the source object, to detect changes
+	PartSize int64
+	Parts    map[int]*uploadedPart
+}
+
+func uploadStatePath(dst object.ObjectStorage, key string) string {
+	h := sha256.Sum256([]byte(dst.String() + "\x00" + key))
+	return filepath.Join(uploadStateDir, hex.EncodeToString(h[:16])+".json")
+}
+
+// loadUploadState returns the saved state of the upload of key into dst, if the source is not changed.
+func loadUploadState(dst object.ObjectStorage, key string, size int64, mtime time.Time) *uploadState {
+	if uploadStateDir == "" {
+		return nil
+	}
+	path := uploadStatePath(dst, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Read upload state of %s: %s", key, err)
+		}
+		return nil
+	}
+	var st uploadState
+	if err = json.Unmarshal(data, &st); err != nil {
+		logger.Warnf("Parse upload state %s: %s", path, err)
+		_ = os.Remove(path)
+		return nil
+	}
+	st.path = path
+	if st.Dst != dst.String() || st.Key != key || st.Size != size || !st.Mtime.Equal(mtime) || st.PartSize <= 0 {
+		logger.Infof("Source %s has changed since the last upload %s, start over", key, st.UploadID)
+		dst.AbortUpload(ctx, key, st.UploadID)
+		st.remove()
+		return nil
+	}
+	return &st
+}
+
+// newUploadState returns a state for a new upload, which is saved once the part size is chosen.
+func newUploadState(dst object.ObjectStorage, key, uploadID string, size int64, mtime time.Time) *uploadState {
+	if uploadStateDir == "" || mtime.IsZero() {
+		return nil
+	}
+	return &uploadState{
+		path:     uploadStatePath(dst, key),
+		Dst:      dst.String(),
+		Key:      key,
+		UploadID: uploadID,
+		Size:     size,
+		Mtime:    mtime,
+		Parts:    make(map[int]*uploadedPart),
+	}
+}
+
+// brokenUpload returns whether the error means that the upload can never be completed,
+// e.g. it's aborted or expired, or the saved parts do not match the ones in the object storage.
+func brokenUpload(err error) bool {
+	msg := err.Error()
+	for _, code := range []string{"NoSuchUpload", "InvalidPart", "EntityTooSmall", "too large to copy"} {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeUpload continues the saved upload of key if there is one, it also returns the mtime of the source.
+// The saved upload is only discarded when it's broken, it's kept for the next time on other errors.
+func resumeUpload(src, dst object.ObjectStorage, key string, size int64, calChksum bool) (time.Time, uint32, bool, error) {
+	o, err := src.Head(ctx, key)
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+	st := loadUploadState(dst, key, size, o.Mtime())
+	if st == nil {
+		return o.Mtime(), 0, false, nil
+	}
+	logger.Infof("Resume upload %s of %s with %d parts uploaded", st.UploadID, key, len(st.Parts))
+	// the part size is saved in the state, so the limits of the upload do not matter
+	upload := &object.MultipartUpload{UploadID: st.UploadID, MinPartSize: int(st.PartSize), MaxCount: int((size-1)/st.PartSize) + 1}
+	chksum, err := doCopyMultiple(src, dst, key, size, upload, st, calChksum)
+	if err != nil {
+		if !brokenUpload(err) {
+			return o.Mtime(), 0, false, err
+		}
+		logger.Warnf("Resume upload %s of %s: %s, start over", st.UploadID, key, err)
+		dst.AbortUpload(ctx, key, st.UploadID)
+		st.remove()
+		return o.Mtime(), 0, false, nil
+	}
+	return o.Mtime(), chksum, true, nil
+}
+
+// partSize returns the part size of the upload, the chosen one is used and saved for a new upload.
+func (st *uploadState) partSize(chosen int64) int64 {
+	if st == nil {
+		return chosen
+	}
+	st.Lock()
+	defer st.Unlock()
+	if st.PartSize == 0 {
+		st.PartSize = chosen
+		st.save()
+	}
+	return st.PartSize
+}
+
+func (st *uploadState) uploaded(num int) *uploadedPart {
+	if st == nil {
+		return nil
+	}
+	st.Lock()
+	defer st.Unlock()
+	return st.Parts[num]
+}
+
+func (st *uploadState) add(num int, part *object.Part, chksum uint32) {
+	if st == nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	st.Parts[num] = &uploadedPart{part, chksum}
+	st.save()
+}
+
+// save writes the state into a temporary file and renames it, so that a crash never leaves a broken state.
+func (st *uploadState) save() {
+	data, err := json.Marshal(st)
+	if err != nil {
+		logger.Warnf("Encode upload state of %s: %s", st.Key, err)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		logger.Warnf("Create directory for upload state: %s", err)
+		return
+	}
+	tmp := st.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, st.path)
+	}
+	if err != nil {
+		logger.Warnf("Save upload state of %s: %s", st.Key, err)
+	}
+}
+
+func (st *uploadState) remove() {
+	if st == nil {
+		return
+	}
+	if err := os.Remove(st.path); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Remove upload state of %s: %s", st.Key, err)
+	}
+}
+
+// resumableUploads returns the IDs of uploads into dst that can be resumed.
+func resumableUploads(dst object.ObjectStorage) map[string]bool {
+	ids := make(map[string]bool)
+	if uploadStateDir == "" {
+		return ids
+	}
+	entries, err := os.ReadDir(uploadStateDir)
+	if err != nil {
+		return ids
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(uploadStateDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var st uploadState
+		if json.Unmarshal(data, &st) == nil && st.Dst == dst.String() {
+			ids[st.UploadID] = true
+		}
+	}
+	return ids
+}
+
+// abortStaleUploads aborts the multipart uploads in dst that were initiated before the duration ago,
+// except the ones that can be resumed.
+func abortStaleUploads(dst object.ObjectStorage, age time.Duration) {
+	resumable := resumableUploads(dst)
+	deadline := time.Now().Add(-age)
+	var marker string
+	var aborted int
+	for {
+		uploads, next, err := dst.ListUploads(ctx, marker)
+		if err != nil {
+			logger.Warnf("List multipart uploads in %s: %s", dst, err)
+			break
+		}
+		for _, u := range uploads {
+			if u.Created.Before(deadline) && !resumable[u.UploadID] {
+				logger.Debugf("Abort stale upload %s of %s created at %s", u.UploadID, u.Key, u.Created)
+				dst.AbortUpload(ctx, u.Key, u.UploadID)
+				aborted++
+			}
+		}
+		if next == "" || next == marker {
+			break
+		}
+		marker = next
+	}
+	if aborted > 0 {
+		logger.Infof("Aborted %d stale multipart uploads in %s", aborted, dst)
+	}
+}
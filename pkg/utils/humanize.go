@@ -75,6 +75,18 @@ func ParseMbps(ctx *cli.Context, key string) int64 {
 }
 
 func ParseMbpsStr(key, str string) int64 {
+	val, err := ParseMbpsValue(str)
+	if err != nil {
+		logger.Fatalf("Invalid value \"%s\" for \"%s\"", str, key)
+	}
+	return val
+}
+
+// ParseMbpsValue parses a bandwidth like "100", "100M" or "1G" in Mbps.
+func ParseMbpsValue(str string) (int64, error) {
+	if str == "" {
+		return 0, errors.New("empty value")
+	}
 	s := str
 	var unit byte = 'M'
 	if c := s[len(s)-1]; c < '0' || c > '9' {
@@ -82,23 +94,21 @@ func ParseMbpsStr(key, str string) int64 {
 		s = s[:len(s)-1]
 	}
 	val, err := strconv.ParseFloat(s, 64)
-	if err == nil {
-		switch unit {
-		case 'm', 'M':
-		case 'g', 'G':
-			val *= 1e3
-		case 't', 'T':
-			val *= 1e6
-		case 'p', 'P':
-			val *= 1e9
-		default:
-			err = errors.New("invalid unit")
-		}
-	}
 	if err != nil {
-		logger.Fatalf("Invalid value \"%s\" for \"%s\"", str, key)
+		return 0, err
+	}
+	switch unit {
+	case 'm', 'M':
+	case 'g', 'G':
+		val *= 1e3
+	case 't', 'T':
+		val *= 1e6
+	case 'p', 'P':
+		val *= 1e9
+	default:
+		return 0, errors.New("invalid unit")
 	}
-	return int64(val)
+	return int64(val), nil
 }
 
 func Mbps(val int64) string {
@@ -0,0 +1,141 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var (
+	tracer   trace.Tracer = noop.NewTracerProvider().Tracer("")
+	tracing  bool
+	provider *sdktrace.TracerProvider
+)
+
+type spanKey struct{}
+
+// SpanKey is the key of the current span in the contexts, it's used instead of trace.ContextWithSpan
+// because some of the contexts (e.g. meta.Context) can only be extended by WithValue.
+var SpanKey = spanKey{}
+
+// InitTracing exports the sampled traces to the OTLP/gRPC endpoint, which is HOST:PORT (plaintext) or
+// https://HOST:PORT. An operation is sampled at the ratio, and all the spans of it are sampled together.
+// It should be called before any span is started.
+func InitTracing(endpoint, service string, ratio float64, attrs ...attribute.KeyValue) error {
+	var opts []otlptracegrpc.Option
+	if strings.HasPrefix(endpoint, "https://") {
+		opts = append(opts, otlptracegrpc.WithEndpoint(strings.TrimPrefix(endpoint, "https://")))
+	} else {
+		opts = append(opts, otlptracegrpc.WithEndpoint(strings.TrimPrefix(endpoint, "http://")), otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	return StartTracing(exporter, service, ratio, attrs...)
+}
+
+// StartTracing exports the sampled traces by the exporter.
+func StartTracing(exporter sdktrace.SpanExporter, service string, ratio float64, attrs ...attribute.KeyValue) error {
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("invalid sample ratio %g, it should be in [0, 1]", ratio)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(append(attrs, attribute.String("service.name", service))...)),
+	)
+	tracer = provider.Tracer("github.com/juicedata/juicefs")
+	tracing = true
+	return nil
+}
+
+// FlushTraces exports the pending spans and stops tracing, it should be called before exit.
+func FlushTraces() {
+	if provider == nil {
+		return
+	}
+	tracing = false
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		logger.Warnf("Flush traces: %s", err)
+	}
+}
+
+// TracingEnabled returns whether the traces are exported, the spans are not needed at all if not.
+func TracingEnabled() bool {
+	return tracing
+}
+
+// SpanFromContext returns the current span in ctx, or an invalid span if there is none.
+func SpanFromContext(ctx context.Context) trace.Span {
+	if ctx == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	if span, ok := ctx.Value(SpanKey).(trace.Span); ok {
+		return span
+	}
+	return trace.SpanFromContext(ctx)
+}
+
+// ContextWithSpan returns a copy of ctx with the span as the current one.
+func ContextWithSpan(ctx context.Context, span trace.Span) context.Context {
+	return context.WithValue(ctx, SpanKey, span)
+}
+
+// StartSpan starts a span as the child of the current one in ctx (if any), which should be put into the
+// context of the operation by ContextWithSpan or WithValue(SpanKey, span).
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) trace.Span {
+	if !tracing {
+		return SpanFromContext(nil)
+	}
+	_, span := tracer.Start(trace.ContextWithSpan(context.Background(), SpanFromContext(ctx)), name, opts...)
+	return span
+}
+
+// EndSpan ends the span with the result of the operation.
+func EndSpan(span trace.Span, err error, opts ...trace.SpanEndOption) {
+	if eno, ok := err.(syscall.Errno); ok && eno == 0 {
+		err = nil
+	}
+	if err != nil && span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(opts...)
+}
+
+// TraceID returns the ID of the sampled trace in ctx, or "" if it's not sampled.
+func TraceID(ctx context.Context) string {
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsSampled() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
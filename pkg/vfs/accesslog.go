@@ -65,6 +65,9 @@ func init() {
 
 func logit(ctx Context, method string, err syscall.Errno, format string, args ...interface{}) {
 	used := ctx.Duration()
+	if tc, ok := ctx.(*tracedContext); ok {
+		utils.EndSpan(tc.span, err)
+	}
 	opsDurationsHistogram.Observe(used.Seconds())
 	opsTotal.WithLabelValues(method).Inc()
 	opsDurations.WithLabelValues(method).Add(used.Seconds())
@@ -0,0 +1,311 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminSettings are the settings of a mount which can be changed at runtime.
+type AdminSettings struct {
+	LogLevel           string   `json:",omitempty"` // trace, debug, info, warn or error
+	UploadLimit        *int64   `json:",omitempty"` // Mbps, 0 for unlimited
+	DownloadLimit      *int64   `json:",omitempty"` // Mbps, 0 for unlimited
+	CacheSize          *uint64  `json:",omitempty"` // MiB
+	BufferSize         *uint64  `json:",omitempty"` // MiB
+	AttrCache          *float64 `json:",omitempty"` // seconds
+	EntryCache         *float64 `json:",omitempty"` // seconds
+	DirEntryCache      *float64 `json:",omitempty"` // seconds
+	NegativeEntryCache *float64 `json:",omitempty"` // seconds
+}
+
+// AdminCacheRequest is the request to warm up or evict the cache of files.
+type AdminCacheRequest struct {
+	Paths      []string // relative to the mount point, or "inode:INODE"
+	Threads    int      `json:",omitempty"`
+	Background bool     `json:",omitempty"`
+}
+
+// AdminOp is an operation in progress on an opened file.
+type AdminOp struct {
+	Inode    Ino
+	Fh       uint64
+	Pid      uint32
+	Uid      uint32
+	Duration float64 // in seconds
+}
+
+type adminAPI struct {
+	v       *VFS
+	restart func() error
+}
+
+// AdminHandler returns the handler of the admin API of the mount, which is served in JSON over HTTP:
+//
+//	GET   /config        the config of the mount
+//	PATCH /config        change the settings of the mount (AdminSettings)
+//	GET   /cache         the statistics of the block cache
+//	POST  /cache/warmup  warm up the cache of files (AdminCacheRequest)
+//	POST  /cache/evict   evict the cache of files (AdminCacheRequest)
+//	GET   /ops           the operations in progress on the opened files
+//	POST  /restart       restart the mount gracefully, to upgrade the binary
+//
+// restart is nil if the mount can not be restarted gracefully. If token is not empty, the requests should
+// carry it as the bearer token (Authorization: Bearer <token>).
+func (v *VFS) AdminHandler(restart func() error, token string) http.Handler {
+	a := &adminAPI{v, restart}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", a.getConfig)
+	mux.HandleFunc("PATCH /config", a.setConfig)
+	mux.HandleFunc("GET /cache", a.cacheStats)
+	mux.HandleFunc("POST /cache/warmup", a.cache(WarmupCache))
+	mux.HandleFunc("POST /cache/evict", a.cache(EvictCache))
+	mux.HandleFunc("GET /ops", a.ops)
+	mux.HandleFunc("POST /restart", a.doRestart)
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Warnf("Write admin response: %s", err)
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (a *adminAPI) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.v.currentConf())
+}
+
+// currentConf returns a copy of Conf with the settings changed at runtime.
+func (v *VFS) currentConf() *Config {
+	v.confM.Lock()
+	defer v.confM.Unlock()
+	conf := *v.Conf
+	chunkConf := *v.Conf.Chunk
+	conf.Chunk = &chunkConf
+	t := v.Timeouts()
+	conf.AttrTimeout, conf.EntryTimeout, conf.DirEntryTimeout, conf.NegEntryTimeout = t.Attr, t.Entry, t.DirEntry, t.NegEntry
+	return &conf
+}
+
+func (a *adminAPI) setConfig(w http.ResponseWriter, r *http.Request) {
+	var s AdminSettings
+	if !readJSON(w, r, &s) {
+		return
+	}
+	if err := a.v.UpdateSettings(&s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, a.v.currentConf())
+}
+
+// UpdateSettings changes the settings of the mount, nothing is changed if any of them is invalid. The
+// changed settings are published by atomics to the readers in the hot paths, while Conf.Chunk is only
+// changed with confM held, since it's not read at runtime except for the admin API.
+func (v *VFS) UpdateSettings(s *AdminSettings) error {
+	v.confM.Lock()
+	defer v.confM.Unlock()
+	var level logrus.Level
+	if s.LogLevel != "" {
+		var err error
+		if level, err = logrus.ParseLevel(s.LogLevel); err != nil {
+			return err
+		}
+	}
+	if s.UploadLimit != nil && *s.UploadLimit < 0 || s.DownloadLimit != nil && *s.DownloadLimit < 0 {
+		return errors.New("negative limit")
+	}
+	if s.CacheSize != nil && (*s.CacheSize == 0 || v.Conf.Chunk.CacheSize == 0) {
+		return errors.New("cache can not be enabled or disabled at runtime")
+	}
+	if s.BufferSize != nil && *s.BufferSize < 32 {
+		return errors.New("buffer size should be at least 32 MiB")
+	}
+	for _, t := range []*float64{s.AttrCache, s.EntryCache, s.DirEntryCache, s.NegativeEntryCache} {
+		if t != nil && *t < 0 {
+			return errors.New("negative cache timeout")
+		}
+	}
+
+	if s.LogLevel != "" {
+		utils.SetLogLevel(level)
+		logger.Infof("Log level changed to %s", level)
+	}
+	if s.UploadLimit != nil || s.DownloadLimit != nil {
+		up, down := v.Conf.Chunk.UploadLimit*8/1e6, v.Conf.Chunk.DownloadLimit*8/1e6
+		if s.UploadLimit != nil {
+			up = *s.UploadLimit
+		}
+		if s.DownloadLimit != nil {
+			down = *s.DownloadLimit
+		}
+		v.Store.UpdateLimit(up, down)
+		v.Conf.Chunk.UploadLimit, v.Conf.Chunk.DownloadLimit = up*1e6/8, down*1e6/8
+	}
+	if s.CacheSize != nil {
+		v.Store.UpdateCacheSize(*s.CacheSize << 20)
+		v.Conf.Chunk.CacheSize = *s.CacheSize << 20
+	}
+	if s.BufferSize != nil && *s.BufferSize<<20 != v.Conf.Chunk.BufferSize {
+		logger.Infof("Buffer size changed from %d to %d", v.Conf.Chunk.BufferSize, *s.BufferSize<<20)
+		v.Conf.Chunk.BufferSize = *s.BufferSize << 20
+		v.reader.UpdateBufferSize(v.Conf.Chunk.BufferSize)
+		v.writer.UpdateBufferSize(v.Conf.Chunk.BufferSize)
+	}
+	timeouts := v.Timeouts()
+	var changed bool
+	for _, t := range []struct {
+		name    string
+		value   *float64
+		timeout *time.Duration
+	}{
+		{"attr-cache", s.AttrCache, &timeouts.Attr},
+		{"entry-cache", s.EntryCache, &timeouts.Entry},
+		{"dir-entry-cache", s.DirEntryCache, &timeouts.DirEntry},
+		{"negative-entry-cache", s.NegativeEntryCache, &timeouts.NegEntry},
+	} {
+		if t.value == nil {
+			continue
+		}
+		if d := time.Duration(*t.value * 1e9); d != *t.timeout {
+			logger.Infof("Timeout of %s changed from %s to %s", t.name, *t.timeout, d)
+			*t.timeout = d
+			changed = true
+		}
+	}
+	if changed {
+		v.timeouts.Store(&timeouts)
+	}
+	return nil
+}
+
+func (a *adminAPI) cacheStats(w http.ResponseWriter, r *http.Request) {
+	stats := make(map[string]float64)
+	if a.v.registry != nil {
+		mfs, err := a.v.registry.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, mf := range mfs {
+			i := strings.Index(mf.GetName(), "blockcache_")
+			if i < 0 {
+				continue
+			}
+			name := mf.GetName()[i+len("blockcache_"):]
+			for _, m := range mf.Metric {
+				switch mf.GetType() {
+				case io_prometheus_client.MetricType_GAUGE:
+					stats[name] += m.GetGauge().GetValue()
+				case io_prometheus_client.MetricType_COUNTER:
+					stats[name] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	writeJSON(w, stats)
+}
+
+func (a *adminAPI) cache(action CacheAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AdminCacheRequest
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if len(req.Paths) == 0 {
+			http.Error(w, "no paths", http.StatusBadRequest)
+			return
+		}
+		if req.Threads <= 0 {
+			req.Threads = 50
+		}
+		logger.Infof("Start to %s %d paths with %d workers by admin API, background=%t", action, len(req.Paths), req.Threads, req.Background)
+		if req.Background {
+			go a.v.cacheFiller.Cache(meta.Background(), action, req.Paths, req.Threads, nil)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		stat := &CacheResponse{Locations: make(map[string]uint64)}
+		a.v.cacheFiller.Cache(meta.WrapContext(r.Context()), action, req.Paths, req.Threads, stat)
+		writeJSON(w, stat)
+	}
+}
+
+func (a *adminAPI) ops(w http.ResponseWriter, r *http.Request) {
+	a.v.hanleM.Lock()
+	var hs []*handle
+	for _, l := range a.v.handles {
+		hs = append(hs, l...)
+	}
+	a.v.hanleM.Unlock()
+	ops := make([]AdminOp, 0)
+	for _, h := range hs {
+		h.Lock()
+		for _, c := range h.ops {
+			ops = append(ops, AdminOp{h.inode, h.fh, c.Pid(), c.Uid(), c.Duration().Seconds()})
+		}
+		h.Unlock()
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Duration > ops[j].Duration })
+	writeJSON(w, ops)
+}
+
+func (a *adminAPI) doRestart(w http.ResponseWriter, r *http.Request) {
+	if a.restart == nil {
+		http.Error(w, "the mount can not be restarted gracefully", http.StatusNotImplemented)
+		return
+	}
+	if err := a.restart(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,137 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+const (
+	prefixRetryInterval = time.Minute // to resolve the prefixes created after mount
+	maxPathDepth        = 1000
+)
+
+// cachePrefixes resolves the prefixes to cache into inodes once, so a file is checked by walking up its parents,
+// any parent of a hard-linked file under the prefixes is enough. A renamed prefix is still cached.
+type cachePrefixes struct {
+	sync.Mutex
+	m        meta.Meta
+	paths    []string
+	inodes   map[Ino]bool
+	missing  []string // not created yet
+	resolved time.Time
+}
+
+// newCachePrefixes returns nil if all the files could be cached.
+func newCachePrefixes(conf *Config, m meta.Meta) *cachePrefixes {
+	if len(conf.CachePrefixes) == 0 {
+		return nil
+	}
+	for _, p := range conf.CachePrefixes {
+		if strings.Trim(p, "/") == "" {
+			return nil
+		}
+	}
+	c := &cachePrefixes{m: m, paths: conf.CachePrefixes, inodes: make(map[Ino]bool)}
+	c.resolve(c.paths)
+	return c
+}
+
+// resolve looks up the inodes of the paths, locked
+func (c *cachePrefixes) resolve(paths []string) {
+	ctx := meta.Background()
+	c.missing = c.missing[:0]
+	for _, p := range paths {
+		inode := Ino(meta.RootInode)
+		var attr meta.Attr
+		var st syscall.Errno
+		for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+			if name == "" {
+				continue
+			}
+			if st = c.m.Lookup(ctx, inode, name, &inode, &attr, false); st != 0 {
+				break
+			}
+		}
+		if st == 0 {
+			c.inodes[inode] = true
+		} else {
+			if st != syscall.ENOENT && st != syscall.ENOTDIR {
+				logger.Warnf("Resolve cache prefix %s: %s", p, st)
+			}
+			c.missing = append(c.missing, p)
+		}
+	}
+	c.resolved = time.Now()
+}
+
+// cached tells whether the blocks of the file could be cached by the prefixes.
+func (c *cachePrefixes) cached(inode Ino) bool {
+	if c == nil {
+		return true
+	}
+	c.Lock()
+	if len(c.missing) > 0 && time.Since(c.resolved) > prefixRetryInterval {
+		c.resolve(append([]string(nil), c.missing...))
+	}
+	c.Unlock()
+	return c.under(meta.Background(), inode, 0)
+}
+
+func (c *cachePrefixes) isPrefix(inode Ino) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.inodes[inode]
+}
+
+func (c *cachePrefixes) under(ctx meta.Context, inode Ino, depth int) bool {
+	for ; depth < maxPathDepth; depth++ {
+		if c.isPrefix(inode) {
+			return true
+		}
+		if inode == meta.RootInode {
+			return false
+		}
+		var attr meta.Attr
+		if st := c.m.GetAttr(ctx, inode, &attr); st != 0 {
+			return false
+		}
+		if attr.Parent == 0 { // hard-linked
+			for p := range c.m.GetParents(ctx, inode) {
+				if c.under(ctx, p, depth+1) {
+					return true
+				}
+			}
+			return false
+		}
+		if attr.Parent == inode {
+			return false
+		}
+		inode = attr.Parent
+	}
+	return false
+}
+
+// sizeCached tells whether the blocks of a file of the length could be cached.
+func sizeCached(conf *Config, length uint64) bool {
+	return conf.CacheMaxFileSize == 0 || length <= conf.CacheMaxFileSize
+}
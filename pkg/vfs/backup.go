@@ -47,8 +47,8 @@ var (
 	})
 )
 
-// Backup metadata periodically in the object storage
-func Backup(m meta.Meta, blob object.ObjectStorage, interval time.Duration, skipTrash bool) {
+// Backup metadata periodically in the object storage, at most keep backups are retained (0 means no limit)
+func Backup(m meta.Meta, blob object.ObjectStorage, interval time.Duration, skipTrash bool, keep int) {
 	ctx := meta.Background()
 	key := "lastBackup"
 	for {
@@ -85,7 +85,7 @@ func Backup(m meta.Meta, blob object.ObjectStorage, interval time.Duration, skip
 				logger.Infof("backup metadata started, inodes=%d", iused)
 			}
 			if fpath, err := backup(m, blob, now, iused < 1e5, skipTrash); err == nil {
-				go cleanupBackups(blob, now) // only cleanup on success
+				go cleanupBackups(blob, now, keep) // only cleanup on success
 				LastBackupTimeG.Set(float64(now.UnixNano()) / 1e9)
 				logger.Infof("backup metadata succeed, fast mode: %v, path: %q, used %s", iused < 1e5, fpath, time.Since(now))
 			} else {
@@ -141,7 +141,7 @@ func backup(m meta.Meta, blob object.ObjectStorage, now time.Time, fast, skipTra
 	return blob.String() + fpath, err
 }
 
-func cleanupBackups(blob object.ObjectStorage, now time.Time) {
+func cleanupBackups(blob object.ObjectStorage, now time.Time, keep int) {
 	blob = object.WithPrefix(blob, "meta/")
 	ch, err := object.ListAll(context.TODO(), blob, "", "", true, false)
 	if err != nil {
@@ -160,6 +160,7 @@ func cleanupBackups(blob object.ObjectStorage, now time.Time) {
 	}
 
 	toDel := rotate(objs, now)
+	toDel = append(toDel, exceeded(objs, toDel, keep)...)
 	for _, o := range toDel {
 		if err = blob.Delete(context.Background(), o); err != nil {
 			logger.Warnf("delete object %s: %s", o, err)
@@ -167,6 +168,29 @@ func cleanupBackups(blob object.ObjectStorage, now time.Time) {
 	}
 }
 
+// exceeded returns the oldest backups that are not in toDel yet
+// but have to be removed to keep at most keep backups.
+func exceeded(objs, toDel []string, keep int) []string {
+	if keep <= 0 {
+		return nil
+	}
+	deleted := make(map[string]bool, len(toDel))
+	for _, o := range toDel {
+		deleted[o] = true
+	}
+	var left []string
+	for _, o := range objs {
+		if !deleted[o] && len(o) == 30 { // same as the name check in rotate
+			left = append(left, o)
+		}
+	}
+	if len(left) <= keep {
+		return nil
+	}
+	sort.Strings(left)
+	return left[:len(left)-keep]
+}
+
 // Cleanup policy:
 // 1. keep all backups within 2 days
 // 2. keep one backup each day within 2 weeks
@@ -67,9 +67,35 @@ func TestRotate(t *testing.T) {
 	}
 }
 
+func TestExceeded(t *testing.T) {
+	format := func(ts time.Time) string {
+		return "dump-" + ts.UTC().Format("2006-01-02-150405") + ".json.gz"
+	}
+	now := time.Now()
+	var objs []string
+	for i := 9; i >= 0; i-- {
+		objs = append(objs, format(now.Add(-time.Duration(i)*time.Hour)))
+	}
+	if toDel := exceeded(objs, nil, 0); len(toDel) != 0 {
+		t.Fatalf("nothing should be deleted without limit, but got %v", toDel)
+	}
+	if toDel := exceeded(objs, nil, 20); len(toDel) != 0 {
+		t.Fatalf("nothing should be deleted under limit, but got %v", toDel)
+	}
+	toDel := exceeded(objs, objs[:2], 5)
+	if len(toDel) != 3 {
+		t.Fatalf("expect 3 backups to delete, but got %v", toDel)
+	}
+	for i, o := range toDel {
+		if o != objs[i+2] {
+			t.Fatalf("obj %s != expect %s", o, objs[i+2])
+		}
+	}
+}
+
 func TestBackup(t *testing.T) {
 	v, blob := createTestVFS(nil, "")
-	go Backup(v.Meta, blob, time.Millisecond*100, false)
+	go Backup(v.Meta, blob, time.Millisecond*100, false, 0)
 	time.Sleep(time.Millisecond * 100)
 
 	blob = object.WithPrefix(blob, "meta/")
@@ -46,6 +46,10 @@ func (act CacheAction) String() string {
 		return "evict cache"
 	case CheckCache:
 		return "check cache"
+	case PinCache:
+		return "pin cache"
+	case UnpinCache:
+		return "unpin cache"
 	}
 	return "unknown operation"
 }
@@ -54,6 +58,8 @@ const (
 	WarmupCache CacheAction = iota
 	EvictCache
 	CheckCache = 2
+	PinCache   = 3
+	UnpinCache = 4
 )
 
 type CacheFiller struct {
@@ -103,6 +109,10 @@ func (c *CacheFiller) cacheFile(ctx meta.Context, action CacheAction, resp *Cach
 			handler = func(s meta.Slice) error {
 				return c.store.EvictCache(s.Id, s.Size)
 			}
+		case PinCache, UnpinCache:
+			handler = func(s meta.Slice) error {
+				return c.store.PinCache(s.Id, s.Size, action == PinCache)
+			}
 		case CheckCache:
 			blockHandler := func(exists bool, loc string, size int) {
 				if exists {
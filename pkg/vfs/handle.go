@@ -46,6 +46,7 @@ type handle struct {
 	reader     FileReader
 	writer     FileWriter
 	ops        []Context
+	audited    bool // the first write is audited
 
 	// rwlock
 	writing uint32
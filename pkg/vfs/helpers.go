@@ -22,6 +22,9 @@ import (
 	"time"
 
 	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -78,6 +81,13 @@ func (mode smode) String() string {
 // representation lazily.
 type Entry meta.Entry
 
+func entryInode(entry *meta.Entry) Ino {
+	if entry == nil {
+		return 0
+	}
+	return entry.Inode
+}
+
 func (entry *Entry) String() string {
 	if entry == nil {
 		return ""
@@ -111,3 +121,22 @@ func (ctx *logContext) Duration() time.Duration {
 func NewLogContext(ctx meta.Context) LogContext {
 	return &logContext{ctx, time.Now()}
 }
+
+// tracedContext carries the span of an operation, which is ended by logit.
+type tracedContext struct {
+	meta.Context // with the span
+	log          LogContext
+	span         trace.Span
+}
+
+func (ctx *tracedContext) Duration() time.Duration {
+	return ctx.log.Duration()
+}
+
+func startSpan(ctx LogContext, op string, ino Ino) LogContext {
+	if !utils.TracingEnabled() {
+		return ctx
+	}
+	span := utils.StartSpan(ctx, "vfs."+op, trace.WithAttributes(attribute.Int64("juicefs.inode", int64(ino))))
+	return &tracedContext{ctx.WithValue(utils.SpanKey, span), ctx, span}
+}
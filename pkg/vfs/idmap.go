@@ -0,0 +1,142 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+// OverflowID is used for the IDs not covered by the mappings, as the kernel does for user namespaces, so they
+// never collide with the mapped ones.
+const OverflowID = 65534
+
+// IDRange maps Count IDs starting from Local on the host to the ones starting from Volume in the volume.
+type IDRange struct {
+	Local  uint32
+	Volume uint32
+	Count  uint32
+}
+
+// IDMap maps the IDs of users or groups between the host and the volume. The IDs in the volume not covered are
+// shown as OverflowID on the host, and the IDs on the host not covered can not be stored in the volume.
+// An empty IDMap keeps all the IDs.
+type IDMap []IDRange
+
+// ParseIDMap parses the mappings separated by comma, each as LOCAL:VOLUME[:COUNT].
+func ParseIDMap(s string) (IDMap, error) {
+	var m IDMap
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ps := strings.Split(part, ":")
+		if len(ps) < 2 || len(ps) > 3 {
+			return nil, fmt.Errorf("invalid mapping %q, it should be LOCAL:VOLUME[:COUNT]", part)
+		}
+		var vs [3]uint64
+		vs[2] = 1
+		for i, p := range ps {
+			v, err := strconv.ParseUint(p, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mapping %q: %s", part, err)
+			}
+			vs[i] = v
+		}
+		if vs[2] == 0 || vs[0]+vs[2] > 1<<32 || vs[1]+vs[2] > 1<<32 {
+			return nil, fmt.Errorf("invalid count of mapping %q", part)
+		}
+		r := IDRange{uint32(vs[0]), uint32(vs[1]), uint32(vs[2])}
+		for _, o := range m {
+			if overlap(r.Local, o.Local, r.Count, o.Count) || overlap(r.Volume, o.Volume, r.Count, o.Count) {
+				return nil, fmt.Errorf("mapping %q overlaps with %d:%d:%d", part, o.Local, o.Volume, o.Count)
+			}
+		}
+		m = append(m, r)
+	}
+	return m, nil
+}
+
+func overlap(a, b, na, nb uint32) bool {
+	return uint64(a) < uint64(b)+uint64(nb) && uint64(b) < uint64(a)+uint64(na)
+}
+
+// ToVolume maps an ID of the host to the volume, it returns OverflowID and false if the ID is not covered.
+func (m IDMap) ToVolume(id uint32) (uint32, bool) {
+	for _, r := range m {
+		if id >= r.Local && uint64(id) < uint64(r.Local)+uint64(r.Count) {
+			return id - r.Local + r.Volume, true
+		}
+	}
+	if len(m) == 0 {
+		return id, true
+	}
+	return OverflowID, false
+}
+
+// ToLocal maps an ID in the volume to the host.
+func (m IDMap) ToLocal(id uint32) uint32 {
+	for _, r := range m {
+		if id >= r.Volume && uint64(id) < uint64(r.Volume)+uint64(r.Count) {
+			return id - r.Volume + r.Local
+		}
+	}
+	if len(m) == 0 {
+		return id
+	}
+	return OverflowID
+}
+
+// MapACLXattr maps the named users and groups in the value of a POSIX ACL xattr into the volume (or to the host
+// if toVolume is false), the values of other xattrs are returned as is. It returns EINVAL if a named user or group
+// can not be mapped into the volume.
+func MapACLXattr(name string, value []byte, uids, gids IDMap, toVolume bool) ([]byte, syscall.Errno) {
+	if name != _SECURITY_ACL && name != _SECURITY_ACL_DEFAULT || len(uids) == 0 && len(gids) == 0 ||
+		len(value)%8 != 4 {
+		return value, 0
+	}
+	mapped := make([]byte, len(value))
+	copy(mapped, value)
+	for off := 4; off < len(mapped); off += 8 {
+		var m IDMap
+		switch utils.NewNativeBuffer(mapped[off:]).Get16() {
+		case 2: // named user
+			m = uids
+		case 8: // named group
+			m = gids
+		default:
+			continue
+		}
+		b := utils.NewNativeBuffer(mapped[off+4:])
+		id := b.Get32()
+		if toVolume {
+			var ok bool
+			if id, ok = m.ToVolume(id); !ok {
+				return nil, syscall.EINVAL
+			}
+		} else {
+			id = m.ToLocal(id)
+		}
+		utils.NewNativeBuffer(mapped[off+4:]).Put32(id)
+	}
+	return mapped, 0
+}
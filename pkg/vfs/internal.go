@@ -214,11 +214,13 @@ func writeProgress(item1, item2 *uint64, out io.Writer, done chan struct{}) {
 type obj struct {
 	key            string
 	size, off, len uint32
+	cached         bool
+	stored         int64
 }
 
 func (v *VFS) calcObjects(id uint64, size, offset, length uint32) []*obj {
 	if id == 0 {
-		return []*obj{{"", size, offset, length}}
+		return []*obj{{key: "", size: size, off: offset, len: length}}
 	}
 	if length == 0 || offset+length > size {
 		logger.Warnf("Corrupt slice id %d size %d offset %d length %d", id, size, offset, length)
@@ -235,7 +237,7 @@ func (v *VFS) calcObjects(id uint64, size, offset, length uint32) []*obj {
 	last := (offset + length - 1) / bsize
 	objs := make([]*obj, 0, last-first+1)
 	for indx := first; indx <= last; indx++ {
-		objs = append(objs, &obj{fmt.Sprintf("%s_%d_%d", prefix, indx, bsize), bsize, 0, bsize})
+		objs = append(objs, &obj{key: fmt.Sprintf("%s_%d_%d", prefix, indx, bsize), size: bsize, len: bsize})
 	}
 	fo, lo := objs[0], objs[len(objs)-1]
 	fo.off = offset - first*bsize
@@ -249,6 +251,30 @@ func (v *VFS) calcObjects(id uint64, size, offset, length uint32) []*obj {
 	return objs
 }
 
+// objectLayout checks whether the objects of the slice (calculated by calcObjects) are in the local cache,
+// and gets their sizes in the object storage.
+func (v *VFS) objectLayout(s meta.Slice, objs []*obj) {
+	first := int(s.Off / uint32(v.Conf.Chunk.BlockSize))
+	var i int
+	_ = v.Store.CheckCache(s.Id, s.Size, func(exists bool, loc string, size int) {
+		if j := i - first; j >= 0 && j < len(objs) {
+			objs[j].cached = exists
+		}
+		i++
+	})
+	i = 0
+	_ = v.Store.StatObjects(s.Id, s.Size, func(key string, size int, stored int64, err error) {
+		if j := i - first; j >= 0 && j < len(objs) {
+			if err != nil {
+				logger.Warnf("Stat object %s: %s", key, err)
+			} else {
+				objs[j].stored = stored
+			}
+		}
+		i++
+	})
+}
+
 type InfoResponse struct {
 	Ino     Ino
 	Failed  bool
@@ -294,6 +320,8 @@ type chunkObj struct {
 	ChunkIndex     uint64
 	Key            string
 	Size, Off, Len uint32
+	Cached         bool  `json:",omitempty"` // the block is in the local cache
+	Stored         int64 `json:",omitempty"` // size in the object storage, after compression and encryption
 }
 
 func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, out io.Writer) {
@@ -302,10 +330,11 @@ func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, o
 		done := make(chan struct{})
 		inode := Ino(r.Get64())
 		name := string(r.Get(int(r.Get8())))
-		var skipTrash bool
+		var skipTrash, async bool
 		var numThreads int = meta.RmrDefaultThreads
 		if r.HasMore() {
-			skipTrash = r.Get8()&1 != 0
+			flag := r.Get8()
+			skipTrash, async = flag&1 != 0, flag&2 != 0
 		}
 		if r.HasMore() {
 			numThreads = int(r.Get8())
@@ -313,8 +342,17 @@ func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, o
 		var count uint64
 		var st syscall.Errno
 		go func() {
-			logger.Infof("Start to rmr %d/%s, workers=%d, skipTrash=%v", inode, name, numThreads, skipTrash)
-			st = v.Meta.Remove(ctx, inode, name, skipTrash, numThreads, &count)
+			if async {
+				var dir Ino
+				if st = v.Meta.RemoveAsync(ctx, inode, name, &dir); st == 0 {
+					logger.Infof("Detached %d/%s (inode %d) to be deleted in background", inode, name, dir)
+				} else if st == syscall.ENOTDIR {
+					st = v.Meta.Remove(ctx, inode, name, skipTrash, numThreads, &count)
+				}
+			} else {
+				logger.Infof("Start to rmr %d/%s, workers=%d, skipTrash=%v", inode, name, numThreads, skipTrash)
+				st = v.Meta.Remove(ctx, inode, name, skipTrash, numThreads, &count)
+			}
 			if st != 0 {
 				logger.Errorf("remove %d/%s: %s", inode, name, st)
 			}
@@ -427,6 +465,10 @@ func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, o
 		if r.HasMore() {
 			strict = r.Get8() != 0
 		}
+		var layout bool // both slices and objects, with the cache and storage of objects
+		if r.HasMore() {
+			layout = r.Get8() != 0
+		}
 
 		done := make(chan struct{})
 		var r syscall.Errno
@@ -446,11 +488,16 @@ func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, o
 					var cs []meta.Slice
 					_ = v.Meta.Read(ctx, inode, uint32(indx), &cs)
 					for _, c := range cs {
-						if raw {
+						if raw || layout {
 							info.Chunks = append(info.Chunks, &chunkSlice{indx, c})
-						} else {
-							for _, o := range v.calcObjects(c.Id, c.Size, c.Off, c.Len) {
-								info.Objects = append(info.Objects, &chunkObj{indx, o.key, o.size, o.off, o.len})
+						}
+						if !raw || layout {
+							objs := v.calcObjects(c.Id, c.Size, c.Off, c.Len)
+							if layout && c.Id > 0 {
+								v.objectLayout(c, objs)
+							}
+							for _, o := range objs {
+								info.Objects = append(info.Objects, &chunkObj{indx, o.key, o.size, o.off, o.len, o.cached, o.stored})
 							}
 						}
 					}
@@ -539,6 +586,37 @@ func (v *VFS) handleInternalMsg(ctx meta.Context, cmd uint32, r *utils.Buffer, o
 		writeProgress(&totalChunks, &currChunks, out, done)
 		_, _ = out.Write([]byte{uint8(eno)})
 
+	case meta.CompactDryRun:
+		inode := Ino(r.Get64())
+		coCnt := r.Get16()
+
+		done := make(chan struct{})
+		var frag meta.Fragments
+		var chunks uint64
+		var eno syscall.Errno
+		go func() {
+			eno = v.Meta.CheckFragments(ctx, inode, int(coCnt), &frag, func() {
+				atomic.AddUint64(&chunks, 1)
+			})
+			close(done)
+		}()
+		writeProgress(&chunks, nil, out, done)
+		if eno != 0 {
+			_, _ = out.Write([]byte{uint8(eno)})
+			return
+		}
+		data, err := json.Marshal(&frag)
+		if err != nil {
+			logger.Errorf("marshal fragments error: %v", err)
+			_, _ = out.Write([]byte{byte(syscall.EIO & 0xff)})
+			return
+		}
+		w := utils.NewBuffer(uint32(1 + 4 + len(data)))
+		w.Put8(meta.CDATA)
+		w.Put32(uint32(len(data)))
+		w.Put(data)
+		_, _ = out.Write(w.Bytes())
+
 	case meta.FillCache:
 		paths := strings.Split(string(r.Get(int(r.Get32()))), "\n")
 		concurrent := r.Get16()
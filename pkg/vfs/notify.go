@@ -0,0 +1,104 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+// WatchChanges follows the changelog of the volume, and invalidates the kernel cache of the entries and
+// files changed by other clients, so they are seen before the cache expires. Only the deletions (unlink,
+// rmdir and the old names of renamed files) are delivered to the kernel as notifications seen by inotify.
+// FUSE can't notify the kernel of creations or writes, they are only seen when the entries are accessed again.
+func (v *VFS) WatchChanges(ctx meta.Context) {
+	var root Ino
+	if st := v.Meta.Lookup(ctx, rootID, ".", &root, &Attr{}, false); st != 0 {
+		logger.Warnf("Lookup root: %s", st)
+		return
+	}
+	err := v.Meta.WatchChangelog(ctx, meta.ChangelogLatest, func(e *meta.ChangeEvent) bool {
+		v.invalidateChange(e, root)
+		return true
+	})
+	if err != nil {
+		logger.Warnf("Watch changelog: %s", err)
+	}
+}
+
+// invalidateChange invalidates the kernel cache changed by the event, root is the inode of the mounted
+// directory in the metadata engine.
+func (v *VFS) invalidateChange(e *meta.ChangeEvent, root Ino) {
+	if e.Sid == v.Conf.Meta.Sid {
+		return
+	}
+	if e.Op == meta.ChangeWrite || e.Op == meta.ChangeSetAttr { // the chunks cached for the opened files
+		_ = v.Meta.InvalidateChunkCache(meta.Background(), e.Inode, meta.InvalidateAllChunks)
+	}
+	if v.InvalidateEntry == nil || v.InvalidateInode == nil {
+		return
+	}
+	kernelIno := func(ino Ino) Ino {
+		if ino == root {
+			return rootID
+		}
+		return ino
+	}
+	// the errors are ignored, as the inodes or entries are not cached by the kernel most of the time
+	entry := func(parent Ino, name string) {
+		if parent > 0 {
+			parent = kernelIno(parent)
+			_ = v.InvalidateEntry(parent, name)
+			_ = v.InvalidateInode(parent, 0, 0) // attributes and readdir cache
+		}
+	}
+	notifyDelete := func(parent, inode Ino, name string) {
+		if parent > 0 && v.NotifyDelete != nil && v.NotifyDelete(kernelIno(parent), kernelIno(inode), name) == 0 {
+			_ = v.InvalidateInode(kernelIno(parent), 0, 0)
+		} else {
+			entry(parent, name)
+		}
+	}
+	switch e.Op {
+	case meta.ChangeCreate:
+		entry(e.Parent, e.Name)
+	case meta.ChangeLink:
+		entry(e.Parent, e.Name)
+		_ = v.InvalidateInode(kernelIno(e.Inode), -1, 0)
+	case meta.ChangeUnlink: // including rmdir
+		// the kernel removes the entry as if it's unlinked locally, even if it's in use (e.g. the working
+		// directory of a process), so the watchers of the directory see the deletion
+		notifyDelete(e.Parent, e.Inode, e.Name)
+		_ = v.InvalidateInode(kernelIno(e.Inode), -1, 0)
+	case meta.ChangeRename:
+		// the old name of a directory (or of unknown type) is only invalidated, as the kernel marks the deleted
+		// directory as dead and it can't be used by the new name anymore
+		if e.Type == 0 || e.Type == meta.TypeDirectory {
+			entry(e.Parent, e.Name)
+		} else {
+			notifyDelete(e.Parent, e.Inode, e.Name)
+		}
+		entry(e.NewParent, e.NewName)
+		_ = v.InvalidateInode(kernelIno(e.Inode), -1, 0)
+		if e.Replaced > 0 {
+			_ = v.InvalidateInode(kernelIno(e.Replaced), -1, 0)
+		}
+	case meta.ChangeSetAttr, meta.ChangeWrite:
+		_ = v.InvalidateInode(kernelIno(e.Inode), 0, 0) // attributes and data
+	case meta.ChangeXattr:
+		_ = v.InvalidateInode(kernelIno(e.Inode), -1, 0)
+	}
+}
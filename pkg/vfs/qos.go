@@ -0,0 +1,143 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+const (
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+
+	busyTimeout = 3 * time.Second // a mount is not busy if it did no IO for this long
+)
+
+// QoS limits the reads and writes of the mount, which are separate from the limits of the object storage.
+type QoS struct {
+	ReadBandwidth  int64  // bytes per second
+	WriteBandwidth int64  // bytes per second
+	IOPS           int64  // reads and writes per second
+	Priority       string // a mount of low priority is only limited when a mount of normal priority on the host is busy
+}
+
+// throttle enforces the QoS, the mounts on the same host learn about the busy ones of normal priority from
+// the files touched by them in a private directory (created by the mounts of low priority) of the user.
+type throttle struct {
+	read, write, iops *ratelimit.Bucket
+	low               bool
+	dir               string
+	self              string
+	active            atomic.Int64 // the last IO in unix seconds
+	busy              atomic.Bool  // a mount of normal priority is busy
+}
+
+func newBucket(rate int64) *ratelimit.Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return ratelimit.NewBucketWithRate(float64(rate), rate)
+}
+
+func newThrottle(q *QoS, dir string) *throttle {
+	t := &throttle{
+		dir:  dir,
+		self: strconv.Itoa(os.Getpid()),
+	}
+	if q != nil {
+		t.read, t.write, t.iops = newBucket(q.ReadBandwidth), newBucket(q.WriteBandwidth), newBucket(q.IOPS)
+		t.low = q.Priority == PriorityLow
+	}
+	if t.low {
+		if t.read == nil && t.write == nil && t.iops == nil {
+			logger.Warnf("io-priority=%s has no effect without any limit of read, write or IOPS", PriorityLow)
+			t.low = false
+		} else if dir == "" {
+			logger.Warnf("io-priority=%s has no effect without a directory to find the busy mounts", PriorityLow)
+			t.low = false
+		} else if err := os.MkdirAll(dir, 0700); err != nil {
+			logger.Warnf("Create %s: %s, the limits always take effect", dir, err)
+			t.low = false
+		} else if !privateDir(dir) {
+			logger.Warnf("%s is accessible by other users, the limits always take effect", dir)
+			t.low = false
+		}
+	}
+	return t
+}
+
+// privateDir returns whether the directory could be written only by the current user.
+func privateDir(dir string) bool {
+	fi, err := os.Lstat(dir)
+	return err == nil && fi.IsDir() && fi.Mode().Perm()&0022 == 0
+}
+
+// refresh announces this mount is busy, or finds out whether any mount of normal priority is busy.
+func (t *throttle) refresh() {
+	if t.low {
+		t.busy.Store(t.othersBusy())
+	} else if privateDir(t.dir) { // only when there is a mount of low priority
+		name := filepath.Join(t.dir, t.self)
+		now := time.Now()
+		if err := os.Chtimes(name, now, now); os.IsNotExist(err) {
+			_ = os.WriteFile(name, nil, 0600)
+		}
+	}
+}
+
+func (t *throttle) othersBusy() bool {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Name() == t.self {
+			continue
+		}
+		if fi, err := e.Info(); err == nil && time.Since(fi.ModTime()) < busyTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// wait blocks the reads or writes of size bytes until they are allowed.
+func (t *throttle) wait(write bool, size int) {
+	// refresh at most once a second, only when there is IO
+	if now, last := time.Now().Unix(), t.active.Load(); now > last && t.active.CompareAndSwap(last, now) && t.dir != "" {
+		go t.refresh()
+	}
+	if t.low && !t.busy.Load() {
+		return
+	}
+	if t.iops != nil {
+		t.iops.Wait(1)
+	}
+	b := t.read
+	if write {
+		b = t.write
+	}
+	if b != nil && size > 0 {
+		b.Wait(int64(size))
+	}
+}
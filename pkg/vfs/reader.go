@@ -29,6 +29,8 @@ import (
 	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -51,6 +53,8 @@ const (
 
 const readSessions = 2
 
+const strideHits = 2 // start reading ahead once the same stride is seen this many times
+
 var readBufferUsed atomic.Int64
 
 type sstate uint8
@@ -76,6 +80,7 @@ type DataReader interface {
 	Open(inode Ino, length uint64) FileReader
 	Truncate(inode Ino, length uint64)
 	Invalidate(inode Ino, off, length uint64)
+	UpdateBufferSize(size uint64)
 }
 
 type frange struct {
@@ -102,6 +107,7 @@ type sliceReader struct {
 	next       *sliceReader
 	prev       **sliceReader
 	refs       uint16
+	trace      trace.SpanContext // of the read which started it
 }
 
 func (s *sliceReader) delay(delay time.Duration) {
@@ -167,10 +173,18 @@ func (s *sliceReader) run() {
 	s.state = BUSY
 	indx := s.indx
 	inode := f.inode
+	mctx := meta.Background()
+	var span trace.Span
+	if utils.TracingEnabled() {
+		span = utils.StartSpan(trace.ContextWithSpanContext(context.Background(), s.trace), "vfs.slice_read", trace.WithAttributes(
+			attribute.Int64("juicefs.inode", int64(inode)), attribute.Int64("juicefs.offset", int64(s.block.off)), attribute.Int64("juicefs.length", int64(s.block.len))))
+		defer span.End() // it's also called by runtime.Goexit() in done()
+		mctx = mctx.WithValue(utils.SpanKey, span)
+	}
 	f.Unlock()
 
 	var slices []meta.Slice
-	err := f.r.m.Read(meta.Background(), inode, indx, &slices)
+	err := f.r.m.Read(mctx, inode, indx, &slices)
 	f.Lock()
 	length := f.length
 	if s.state != BUSY || f.err != 0 || f.closing {
@@ -203,6 +217,12 @@ func (s *sliceReader) run() {
 	defer p.Release()
 	var n int
 	ctx := context.WithValue(context.TODO(), meta.CtxKey("inode"), inode) // Output inode in log for debugging
+	if span != nil {
+		ctx = utils.ContextWithSpan(ctx, span)
+	}
+	if f.nocache || !sizeCached(f.r.conf, length) {
+		ctx = chunk.WithoutCache(ctx)
+	}
 	n = f.r.Read(ctx, p, slices, (uint32(s.block.off))%meta.ChunkSize)
 
 	f.Lock()
@@ -218,6 +238,9 @@ func (s *sliceReader) run() {
 	} else {
 		s.currentPos = 0 // start again from beginning
 		err = syscall.EIO
+		if span != nil {
+			utils.EndSpan(span, fmt.Errorf("read %d bytes of %d", n, need))
+		}
 		f.tried++
 		_ = f.r.m.InvalidateChunkCache(meta.Background(), inode, indx)
 		if f.tried > f.r.maxRetries {
@@ -278,6 +301,20 @@ type session struct {
 	atime      time.Time
 }
 
+// stride tracks the runs of sequential reads starting at a fixed distance (e.g. a column of a table stored
+// by row groups), which look random to the sessions, so the following runs could be read ahead.
+type stride struct {
+	cur   frange // the current run
+	size  uint64 // length of the last completed run
+	step  uint64 // distance between the starts of two runs
+	hits  int
+	ahead uint64 // number of runs to read ahead
+}
+
+func (st *stride) reset() {
+	st.step, st.hits, st.ahead = 0, 0, 0
+}
+
 type fileReader struct {
 	// protected by itself
 	inode    Ino
@@ -285,11 +322,14 @@ type fileReader struct {
 	err      syscall.Errno
 	tried    uint32
 	sessions [readSessions]session
+	stride   stride
 	slices   *sliceReader
 	last     **sliceReader
 
 	sync.Mutex
 	closing bool
+	nocache bool              // not under the prefixes to cache
+	trace   trace.SpanContext // of the current read, for the slices started by it
 
 	// protected by r
 	refs uint16
@@ -322,6 +362,7 @@ func (f *fileReader) newSlice(block *frange) *sliceReader {
 	s.page = chunk.NewOffPage(int(s.block.len))
 	s.cond = utils.NewCond(&f.Mutex)
 	s.prev = f.last
+	s.trace = f.trace
 	*(f.last) = s
 	f.last = &(s.next)
 	go s.run()
@@ -418,11 +459,11 @@ func (f *fileReader) checkReadahead(block *frange) int {
 	seqdata := ses.total
 	readahead := ses.readahead
 	used := uint64(readBufferUsed.Load())
-	if readahead == 0 && f.r.blockSize <= f.r.readAheadMax && (block.off == 0 || seqdata > block.len) { // begin with read-ahead turned on
+	if readahead == 0 && f.r.blockSize <= f.r.readAheadMax.Load() && (block.off == 0 || seqdata > block.len) { // begin with read-ahead turned on
 		ses.readahead = f.r.blockSize
-	} else if readahead < f.r.readAheadMax && seqdata >= readahead && f.r.readAheadTotal > used+readahead*4 {
+	} else if readahead < f.r.readAheadMax.Load() && seqdata >= readahead && f.r.readAheadTotal.Load() > used+readahead*4 {
 		ses.readahead *= 2
-	} else if readahead >= f.r.blockSize && (f.r.readAheadTotal < used+readahead/2 || seqdata < readahead/4) {
+	} else if readahead >= f.r.blockSize && (f.r.readAheadTotal.Load() < used+readahead/2 || seqdata < readahead/4) {
 		ses.readahead /= 2
 	}
 	if ses.readahead >= f.r.blockSize {
@@ -435,7 +476,55 @@ func (f *fileReader) checkReadahead(block *frange) int {
 	return idx
 }
 
+func (f *fileReader) checkStride(block *frange) {
+	st := &f.stride
+	if st.cur.len > 0 && st.cur.off <= block.off && block.off <= st.cur.end() {
+		if block.end() > st.cur.end() {
+			st.cur.len = block.end() - st.cur.off
+		}
+		return
+	}
+	prev := st.cur
+	st.cur = *block
+	if prev.len == 0 || block.off < prev.end()+f.r.blockSize { // sequential or random
+		st.reset()
+		return
+	}
+	if step := block.off - prev.off; step != st.step {
+		st.reset()
+		st.step, st.hits = step, 1
+		return
+	}
+	st.size = prev.len
+	st.hits++
+	if st.hits < strideHits {
+		return
+	}
+	unit := max(st.size, f.r.blockSize) // a short run is read ahead to the end of the block
+	used := uint64(readBufferUsed.Load())
+	if st.ahead == 0 {
+		if unit <= f.r.readAheadMax.Load() {
+			st.ahead = 1
+		}
+	} else if unit*st.ahead*2 <= f.r.readAheadMax.Load() && f.r.readAheadTotal.Load() > used+unit*st.ahead*4 {
+		st.ahead *= 2
+	} else if f.r.readAheadTotal.Load() < used+unit*st.ahead/2 {
+		st.ahead /= 2
+	}
+	if st.size > block.len {
+		rest := frange{block.end(), st.size - block.len}
+		f.readAhead(&rest)
+	}
+	for i := uint64(1); i <= st.ahead; i++ {
+		ahead := frange{block.off + st.step*i, st.size}
+		f.readAhead(&ahead)
+	}
+}
+
 func (f *fileReader) need(block *frange) bool {
+	if st := &f.stride; st.ahead > 0 && block.overlap(&frange{st.cur.off, st.step*st.ahead + st.size}) {
+		return true
+	}
 	for _, ses := range f.sessions {
 		if ses.total == 0 {
 			break
@@ -469,11 +558,11 @@ func (f *fileReader) cleanupRequests(block *frange) {
 		return true
 	})
 	f.visit(func(s *sliceReader) bool {
-		if !block.overlap(s.block) && cnt > f.r.maxRequests {
+		if !block.overlap(s.block) && cnt > int(f.r.maxRequests.Load()) {
 			s.drop()
 			cnt--
 		}
-		return cnt > f.r.maxRequests
+		return cnt > int(f.r.maxRequests.Load())
 	})
 }
 
@@ -483,8 +572,8 @@ func (f *fileReader) releaseIdleBuffer() {
 	now := time.Now()
 	var idle = time.Minute
 	used := readBufferUsed.Load()
-	if used > int64(f.r.readAheadTotal) {
-		idle /= time.Duration(used / int64(f.r.readAheadTotal))
+	if used > int64(f.r.readAheadTotal.Load()) {
+		idle /= time.Duration(used / int64(f.r.readAheadTotal.Load()))
 	}
 	f.visit(func(s *sliceReader) bool {
 		if !s.state.valid() || s.lastAccess.Add(idle).Before(now) || !f.need(s.block) {
@@ -538,7 +627,7 @@ func (f *fileReader) readAhead(block *frange) {
 		}
 		return true
 	})
-	if block.len > 0 && block.off < f.length && uint64(readBufferUsed.Load()) < f.r.readAheadTotal {
+	if block.len > 0 && block.off < f.length && uint64(readBufferUsed.Load()) < f.r.readAheadTotal.Load() {
 		if block.len < f.r.blockSize {
 			block.len += f.r.blockSize - block.end()%f.r.blockSize // align to end of a block
 		}
@@ -620,9 +709,9 @@ func (f *fileReader) waitForIO(ctx meta.Context, reqs []*req, buf []byte) (int,
 }
 
 func (f *fileReader) Read(ctx meta.Context, offset uint64, buf []byte) (int, syscall.Errno) {
-	if f.r.readBufferUsed() > f.r.bufferSize {
-		time.Sleep(time.Millisecond * 10)             // slow down
-		for f.r.readBufferUsed() > f.r.bufferSize*2 { // readahead uses 80% of buffer, stop here to avoid OOM
+	if f.r.readBufferUsed() > f.r.bufferSize.Load() {
+		time.Sleep(time.Millisecond * 10)                    // slow down
+		for f.r.readBufferUsed() > f.r.bufferSize.Load()*2 { // readahead uses 80% of buffer, stop here to avoid OOM
 			time.Sleep(time.Millisecond * 100)
 		}
 	}
@@ -634,6 +723,9 @@ func (f *fileReader) Read(ctx meta.Context, offset uint64, buf []byte) (int, sys
 	if f.err != 0 || f.closing {
 		return 0, f.err
 	}
+	if utils.TracingEnabled() {
+		f.trace = utils.SpanFromContext(ctx).SpanContext()
+	}
 
 	size := uint64(len(buf))
 	if offset >= f.length || size == 0 {
@@ -665,6 +757,7 @@ func (f *fileReader) Read(ctx meta.Context, offset uint64, buf []byte) (int, sys
 		}
 	}()
 	f.checkReadahead(block)
+	f.checkStride(block)
 	return f.waitForIO(ctx, reqs, buf)
 }
 
@@ -691,38 +784,48 @@ func (f *fileReader) Close(ctx meta.Context) {
 
 type dataReader struct {
 	sync.Mutex
+	conf           *Config
 	m              meta.Meta
 	store          chunk.ChunkStore
 	files          map[Ino]*fileReader
 	blockSize      uint64
-	bufferSize     int64
-	readAheadMax   uint64
-	readAheadTotal uint64
-	maxRequests    int
+	bufferSize     atomic.Int64 // the limits below are changed by UpdateBufferSize at runtime
+	readAheadMax   atomic.Uint64
+	readAheadTotal atomic.Uint64
+	maxRequests    atomic.Int64
 	maxRetries     uint32
+	prefixes       *cachePrefixes
 }
 
 func NewDataReader(conf *Config, m meta.Meta, store chunk.ChunkStore) DataReader {
-	var readAheadTotal = 256 << 20
-	if conf.Chunk.BufferSize > 0 {
-		readAheadTotal = int(conf.Chunk.BufferSize / 10 * 8) // 80% of total buffer
-	}
-	readAheadMax := min(conf.Chunk.Readahead, readAheadTotal)
 	r := &dataReader{
-		m:              m,
-		store:          store,
-		files:          make(map[Ino]*fileReader),
-		blockSize:      uint64(conf.Chunk.BlockSize),
-		bufferSize:     int64(conf.Chunk.BufferSize),
-		readAheadTotal: uint64(readAheadTotal),
-		readAheadMax:   uint64(readAheadMax),
-		maxRequests:    readAheadMax/conf.Chunk.BlockSize*readSessions + 1,
-		maxRetries:     uint32(conf.Meta.Retries),
+		conf:       conf,
+		m:          m,
+		store:      store,
+		files:      make(map[Ino]*fileReader),
+		blockSize:  uint64(conf.Chunk.BlockSize),
+		maxRetries: uint32(conf.Meta.Retries),
+		prefixes:   newCachePrefixes(conf, m),
 	}
+	r.UpdateBufferSize(conf.Chunk.BufferSize)
 	go r.checkReadBuffer()
 	return r
 }
 
+// UpdateBufferSize changes the limits of read buffer and readahead, the buffered data are not released
+// immediately if the buffer is shrunk.
+func (r *dataReader) UpdateBufferSize(size uint64) {
+	var readAheadTotal = 256 << 20
+	if size > 0 {
+		readAheadTotal = int(size / 10 * 8) // 80% of total buffer
+	}
+	readAheadMax := min(r.conf.Chunk.Readahead, readAheadTotal)
+	r.bufferSize.Store(int64(size))
+	r.readAheadTotal.Store(uint64(readAheadTotal))
+	r.readAheadMax.Store(uint64(readAheadMax))
+	r.maxRequests.Store(int64(readAheadMax/r.conf.Chunk.BlockSize*readSessions + 1))
+}
+
 func (r *dataReader) readBufferUsed() int64 {
 	used := readBufferUsed.Load()
 	return used
@@ -746,9 +849,10 @@ func (r *dataReader) checkReadBuffer() {
 
 func (r *dataReader) Open(inode Ino, length uint64) FileReader {
 	f := &fileReader{
-		r:      r,
-		inode:  inode,
-		length: length,
+		r:       r,
+		inode:   inode,
+		length:  length,
+		nocache: !r.prefixes.cached(inode),
 	}
 	f.last = &(f.slices)
 
@@ -0,0 +1,126 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vfs
+
+import (
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"github.com/juju/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ScrubbedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "scrubbed_bytes",
+		Help: "Total bytes of blocks verified by scrubbing.",
+	})
+	DamagedBlocks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scrub_damaged_blocks",
+		Help: "Number of damaged blocks found by scrubbing.",
+	}, []string{"repaired"})
+	LastScrubTimeG = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "last_scrub_time",
+		Help: "Finish time of the last scrubbing pass.",
+	})
+)
+
+// Scrub verifies all the blocks in the object storage periodically at the rate of limit (bytes per second, 0 means
+// unlimited), only one of the clients does the scrubbing at a time.
+func Scrub(m meta.Meta, store chunk.ChunkStore, interval time.Duration, limit int64) {
+	ctx := meta.Background()
+	key := "lastScrub"
+	for {
+		utils.SleepWithJitter(interval / 10)
+		var value []byte
+		if st := m.GetXattr(ctx, 0, key, &value); st != 0 && st != meta.ENOATTR {
+			logger.Warnf("getxattr inode 1 key %s: %s", key, st)
+			continue
+		}
+		var last time.Time
+		var err error
+		if len(value) > 0 {
+			last, err = time.Parse(time.RFC3339, string(value))
+		}
+		if err != nil {
+			logger.Warnf("parse time value %s: %s", value, err)
+			continue
+		}
+		if now := time.Now(); now.Sub(last) >= interval {
+			if st := m.SetXattr(ctx, 0, key, []byte(now.Format(time.RFC3339)), meta.XattrCreateOrReplace); st != 0 {
+				logger.Warnf("setxattr inode 1 key %s: %s", key, st)
+				continue
+			}
+			logger.Infof("scrubbing started")
+			if scanned, damaged, err := scrub(m, store, limit); err == nil {
+				LastScrubTimeG.Set(float64(time.Now().UnixNano()) / 1e9)
+				logger.Infof("scrubbing finished, verified %d bytes, found %d damaged blocks, used %s", scanned, damaged, time.Since(now))
+			} else {
+				logger.Warnf("scrubbing failed: %s", err)
+			}
+		}
+	}
+}
+
+func scrub(m meta.Meta, store chunk.ChunkStore, limit int64) (int64, int, error) {
+	ctx := meta.Background()
+	slices := make(map[meta.Ino][]meta.Slice)
+	if st := m.ListSlices(ctx, slices, false, false, nil); st != 0 {
+		return 0, 0, st
+	}
+	var bucket *ratelimit.Bucket
+	if limit > 0 {
+		bucket = ratelimit.NewBucketWithRate(float64(limit), limit)
+	}
+	var scanned int64
+	var damaged int
+	visited := make(map[uint64]bool) // slices can be shared by files after clone
+	for inode, ss := range slices {
+		for _, s := range ss {
+			if s.Id == 0 || visited[s.Id] {
+				continue
+			}
+			visited[s.Id] = true
+			if bucket != nil {
+				bucket.Wait(int64(s.Size))
+			}
+			err := store.Scrub(s.Id, s.Size, func(key string, err error, repaired bool) {
+				var attr meta.Attr
+				if m.GetAttr(ctx, inode, &attr) == syscall.ENOENT {
+					return // deleted during scrubbing
+				}
+				damaged++
+				DamagedBlocks.WithLabelValues(strconv.FormatBool(repaired)).Inc()
+				if repaired {
+					logger.Warnf("Repaired damaged block %s of inode %d: %s", key, inode, err)
+				} else {
+					logger.Errorf("Block %s of inode %d is damaged: %s", key, inode, err)
+				}
+			})
+			if err != nil {
+				logger.Warnf("Scrub slice %d of inode %d: %s", s.Id, inode, err)
+			}
+			scanned += int64(s.Size)
+			ScrubbedBytes.Add(float64(s.Size))
+		}
+	}
+	return scanned, damaged, nil
+}
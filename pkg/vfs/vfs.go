@@ -17,6 +17,7 @@
 package vfs
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -24,11 +25,13 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/juicedata/juicefs/pkg/acl"
+	"github.com/juicedata/juicefs/pkg/audit"
 	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/utils"
@@ -133,15 +136,28 @@ type Config struct {
 	NegEntryTimeout      time.Duration
 	EntryTimeout         time.Duration
 	ReaddirCache         bool
+	WatchChanges         bool `json:",omitempty"`
+	CoherentMmap         bool `json:",omitempty"` // commit the pages of shared mappings once written back
+	LogWrites            bool `json:",omitempty"` // append small random writes to a log slice of the chunk
 	BackupMeta           time.Duration
 	BackupSkipTrash      bool
-	FastResolve          bool   `json:",omitempty"`
-	AccessLog            string `json:",omitempty"`
-	Subdir               string `json:",omitempty"`
+	BackupMetaKeep       int           `json:",omitempty"`
+	ScrubInterval        time.Duration `json:",omitempty"`
+	ScrubLimit           int64         `json:",omitempty"`
+	CacheMaxFileSize     uint64        `json:",omitempty"`
+	CachePrefixes        []string      `json:",omitempty"`
+	QoS                  *QoS          `json:",omitempty"`
+	PriorityDir          string        `json:",omitempty"` // private directory shared by the mounts of the user for io-priority
+	FastResolve          bool          `json:",omitempty"`
+	AccessLog            string        `json:",omitempty"`
+	Audit                *audit.Config `json:",omitempty"`
+	Subdir               string        `json:",omitempty"`
 	PrefixInternal       bool
 	HideInternal         bool
 	RootSquash           *AnonymousAccount `json:",omitempty"`
 	AllSquash            *AnonymousAccount `json:",omitempty"`
+	UidMap               IDMap             `json:",omitempty"`
+	GidMap               IDMap             `json:",omitempty"`
 	NonDefaultPermission bool              `json:",omitempty"`
 	UMask                uint16
 
@@ -189,6 +205,7 @@ func (v *VFS) Lookup(ctx Context, parent Ino, name string) (entry *meta.Entry, e
 			return
 		}
 	}
+	ctx = startSpan(ctx, "lookup", parent)
 	defer func() {
 		logit(ctx, "lookup", err, "(%d,%s):%s", parent, name, (*Entry)(entry))
 	}()
@@ -209,6 +226,7 @@ func (v *VFS) GetAttr(ctx Context, ino Ino, opened uint8) (entry *meta.Entry, er
 		entry = &meta.Entry{Inode: n.inode, Attr: n.attr}
 		return
 	}
+	ctx = startSpan(ctx, "getattr", ino)
 	defer func() { logit(ctx, "getattr", err, "(%d):%s", ino, (*Entry)(entry)) }()
 	var attr = &Attr{}
 	err = v.Meta.GetAttr(ctx, ino, attr)
@@ -239,8 +257,10 @@ func get_filetype(mode uint16) uint8 {
 }
 
 func (v *VFS) Mknod(ctx Context, parent Ino, name string, mode uint16, cumask uint16, rdev uint32) (entry *meta.Entry, err syscall.Errno) {
+	ctx = startSpan(ctx, "mknod", parent)
 	defer func() {
 		logit(ctx, "mknod", err, "(%d,%s,%s:0%04o,0x%08X):%s", parent, name, smode(mode), mode, rdev, (*Entry)(entry))
+		v.Auditor.Entry(ctx, "mknod", parent, name, entryInode(entry), err)
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -267,7 +287,11 @@ func (v *VFS) Mknod(ctx Context, parent Ino, name string, mode uint16, cumask ui
 }
 
 func (v *VFS) Unlink(ctx Context, parent Ino, name string) (err syscall.Errno) {
-	defer func() { logit(ctx, "unlink", err, "(%d,%s)", parent, name) }()
+	ctx = startSpan(ctx, "unlink", parent)
+	defer func() {
+		logit(ctx, "unlink", err, "(%d,%s)", parent, name)
+		v.Auditor.Entry(ctx, "unlink", parent, name, 0, err)
+	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EPERM
 		return
@@ -284,8 +308,10 @@ func (v *VFS) Unlink(ctx Context, parent Ino, name string) (err syscall.Errno) {
 }
 
 func (v *VFS) Mkdir(ctx Context, parent Ino, name string, mode uint16, cumask uint16) (entry *meta.Entry, err syscall.Errno) {
+	ctx = startSpan(ctx, "mkdir", parent)
 	defer func() {
 		logit(ctx, "mkdir", err, "(%d,%s,%s:0%04o):%s", parent, name, smode(mode), mode, (*Entry)(entry))
+		v.Auditor.Entry(ctx, "mkdir", parent, name, entryInode(entry), err)
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -307,7 +333,11 @@ func (v *VFS) Mkdir(ctx Context, parent Ino, name string, mode uint16, cumask ui
 }
 
 func (v *VFS) Rmdir(ctx Context, parent Ino, name string) (err syscall.Errno) {
-	defer func() { logit(ctx, "rmdir", err, "(%d,%s)", parent, name) }()
+	ctx = startSpan(ctx, "rmdir", parent)
+	defer func() {
+		logit(ctx, "rmdir", err, "(%d,%s)", parent, name)
+		v.Auditor.Entry(ctx, "rmdir", parent, name, 0, err)
+	}()
 	if len(name) > maxName {
 		err = syscall.ENAMETOOLONG
 		return
@@ -320,8 +350,10 @@ func (v *VFS) Rmdir(ctx Context, parent Ino, name string) (err syscall.Errno) {
 }
 
 func (v *VFS) Symlink(ctx Context, path string, parent Ino, name string) (entry *meta.Entry, err syscall.Errno) {
+	ctx = startSpan(ctx, "symlink", parent)
 	defer func() {
 		logit(ctx, "symlink", err, "(%d,%s,%s):%s", parent, name, path, (*Entry)(entry))
+		v.Auditor.Entry(ctx, "symlink", parent, name, entryInode(entry), err)
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EEXIST
@@ -343,14 +375,18 @@ func (v *VFS) Symlink(ctx Context, path string, parent Ino, name string) (entry
 }
 
 func (v *VFS) Readlink(ctx Context, ino Ino) (path []byte, err syscall.Errno) {
+	ctx = startSpan(ctx, "readlink", ino)
 	defer func() { logit(ctx, "readlink", err, "(%d): (%s)", ino, string(path)) }()
 	err = v.Meta.ReadLink(ctx, ino, &path)
 	return
 }
 
 func (v *VFS) Rename(ctx Context, parent Ino, name string, newparent Ino, newname string, flags uint32) (err syscall.Errno) {
+	var inode Ino
+	ctx = startSpan(ctx, "rename", parent)
 	defer func() {
 		logit(ctx, "rename", err, "(%d,%s,%d,%s,%d)", parent, name, newparent, newname, flags)
+		v.Auditor.Rename(ctx, parent, name, newparent, newname, inode, err)
 	}()
 	if parent == rootID && IsSpecialName(name) {
 		err = syscall.EPERM
@@ -365,7 +401,6 @@ func (v *VFS) Rename(ctx Context, parent Ino, name string, newparent Ino, newnam
 		return
 	}
 
-	var inode Ino
 	var attr = &Attr{}
 	err = v.Meta.Rename(ctx, parent, name, newparent, newname, flags, &inode, attr)
 	if err == 0 {
@@ -377,8 +412,10 @@ func (v *VFS) Rename(ctx Context, parent Ino, name string, newparent Ino, newnam
 }
 
 func (v *VFS) Link(ctx Context, ino Ino, newparent Ino, newname string) (entry *meta.Entry, err syscall.Errno) {
+	ctx = startSpan(ctx, "link", ino)
 	defer func() {
 		logit(ctx, "link", err, "(%d,%d,%s):%s", ino, newparent, newname, (*Entry)(entry))
+		v.Auditor.Entry(ctx, "link", newparent, newname, ino, err)
 	}()
 	if IsSpecialNode(ino) {
 		err = syscall.EPERM
@@ -433,6 +470,7 @@ func (v *VFS) UpdateLength(inode Ino, attr *meta.Attr) {
 }
 
 func (v *VFS) Readdir(ctx Context, ino Ino, size uint32, off int, fh uint64, plus bool) (entries []*meta.Entry, readAt time.Time, err syscall.Errno) {
+	ctx = startSpan(ctx, "readdir", ino)
 	defer func() { logit(ctx, "readdir", err, "(%d,%d,%d,%t): (%d)", ino, size, off, plus, len(entries)) }()
 	h := v.findHandle(ino, fh)
 	if h == nil {
@@ -500,8 +538,10 @@ func (v *VFS) Releasedir(ctx Context, ino Ino, fh uint64) int {
 const O_TMPFILE = 020000000
 
 func (v *VFS) Create(ctx Context, parent Ino, name string, mode uint16, cumask uint16, flags uint32) (entry *meta.Entry, fh uint64, err syscall.Errno) {
+	ctx = startSpan(ctx, "create", parent)
 	defer func() {
 		logit(ctx, "create", err, "(%d,%s,%s:0%04o):%s [fh:%d]", parent, name, smode(mode), mode, (*Entry)(entry), fh)
+		v.Auditor.Create(ctx, parent, name, entryInode(entry), flags, err)
 	}()
 	// O_TMPFILE support
 	doUnlink := runtime.GOOS == "linux" && flags&O_TMPFILE != 0
@@ -536,19 +576,26 @@ func (v *VFS) Create(ctx Context, parent Ino, name string, mode uint16, cumask u
 			if flags&syscall.O_EXCL != 0 {
 				logger.Warnf("The O_EXCL is currently not supported for use with O_TMPFILE")
 			}
-			err = v.Unlink(ctx, parent, name)
+			// it could be linked back by linkat(2), so it should not be moved into trash
+			if err = v.Meta.Unlink(ctx, parent, name, true); err == 0 {
+				v.invalidateDirHandle(parent, name, 0, nil)
+			}
 		}
 	}
 	return
 }
 
 func (v *VFS) Open(ctx Context, ino Ino, flags uint32) (entry *meta.Entry, fh uint64, err syscall.Errno) {
+	ctx = startSpan(ctx, "open", ino)
 	defer func() {
 		if entry != nil {
 			logit(ctx, "open", err, "(%d,%#x) [fh:%d]", ino, flags, fh)
 		} else {
 			logit(ctx, "open", err, "(%d,%#x)", ino, flags)
 		}
+		if !IsSpecialNode(ino) {
+			v.Auditor.Inode(ctx, "open", ino, flags, err)
+		}
 	}()
 	var attr = &Attr{}
 	if IsSpecialNode(ino) {
@@ -642,6 +689,7 @@ func (v *VFS) ReleaseHandler(ino Ino, fh uint64) {
 
 func (v *VFS) Release(ctx Context, ino Ino, fh uint64) {
 	var err syscall.Errno
+	ctx = startSpan(ctx, "release", ino)
 	defer func() { logit(ctx, "release", err, "(%d,%d)", ino, fh) }()
 	if IsSpecialNode(ino) {
 		if ino == logInode {
@@ -737,6 +785,7 @@ func (v *VFS) Read(ctx Context, ino Ino, buf []byte, off uint64, fh uint64) (n i
 		return
 	}
 
+	ctx = startSpan(ctx, "read", ino)
 	defer func() {
 		readSizeHistogram.Observe(float64(n))
 		logit(ctx, "read", err, "(%d,%d,%d,%d): (%d)", ino, size, off, fh, n)
@@ -776,6 +825,7 @@ func (v *VFS) Read(ctx Context, ino Ino, buf []byte, off uint64, fh uint64) (n i
 		err = syscall.EBADF
 		return
 	}
+	v.throttle.wait(false, len(buf))
 	if !h.Rlock(ctx) {
 		err = syscall.EINTR
 		return
@@ -799,6 +849,7 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 	if ino == controlInode && runtime.GOOS == "darwin" {
 		fh = v.getControlHandle(ctx.Pid())
 	}
+	ctx = startSpan(ctx, "write", ino)
 	defer func() { logit(ctx, "write", err, "(%d,%d,%d,%d)", ino, size, off, fh) }()
 	h := v.findHandle(ino, fh)
 	if h == nil {
@@ -838,6 +889,7 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 		return
 	}
 
+	v.throttle.wait(true, len(buf))
 	if !h.Wlock(ctx) {
 		err = syscall.EINTR
 		return
@@ -851,6 +903,10 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 	h.removeOp(ctx)
 
 	if err == 0 {
+		if v.Auditor != nil && !h.audited {
+			h.audited = true
+			v.Auditor.Inode(ctx, "write", ino, 0, 0)
+		}
 		writtenSizeHistogram.Observe(float64(len(buf)))
 		v.reader.Invalidate(ino, off, size)
 		v.invalidateAttr(ino)
@@ -859,6 +915,7 @@ func (v *VFS) Write(ctx Context, ino Ino, buf []byte, off, fh uint64) (err sysca
 }
 
 func (v *VFS) Fallocate(ctx Context, ino Ino, mode uint8, off, size int64, fh uint64) (err syscall.Errno) {
+	ctx = startSpan(ctx, "fallocate", ino)
 	defer func() { logit(ctx, "fallocate", err, "(%d,%d,%d,%d)", ino, mode, off, size) }()
 	if off < 0 || size <= 0 {
 		err = syscall.EINVAL
@@ -909,6 +966,7 @@ func (v *VFS) Fallocate(ctx Context, ino Ino, mode uint8, off, size int64, fh ui
 }
 
 func (v *VFS) CopyFileRange(ctx Context, nodeIn Ino, fhIn, offIn uint64, nodeOut Ino, fhOut, offOut, size uint64, flags uint32) (copied uint64, err syscall.Errno) {
+	ctx = startSpan(ctx, "copy_file_range", nodeIn)
 	defer func() {
 		logit(ctx, "copy_file_range", err, "(%d,%d,%d,%d,%d,%d)", nodeIn, offIn, nodeOut, offOut, size, flags)
 	}()
@@ -989,6 +1047,7 @@ func (v *VFS) Flush(ctx Context, ino Ino, fh uint64, lockOwner uint64) (err sysc
 		fh = v.getControlHandle(ctx.Pid())
 		defer v.releaseControlHandle(ctx.Pid())
 	}
+	ctx = startSpan(ctx, "flush", ino)
 	defer func() { logit(ctx, "flush", err, "(%d,%d,%016X)", ino, fh, lockOwner) }()
 	h := v.findHandle(ino, fh)
 	if h == nil {
@@ -1030,6 +1089,7 @@ func (v *VFS) Flush(ctx Context, ino Ino, fh uint64, lockOwner uint64) (err sysc
 }
 
 func (v *VFS) Fsync(ctx Context, ino Ino, datasync int, fh uint64) (err syscall.Errno) {
+	ctx = startSpan(ctx, "fsync", ino)
 	defer func() { logit(ctx, "fsync", err, "(%d,%d)", ino, datasync) }()
 	if IsSpecialNode(ino) {
 		return
@@ -1046,7 +1106,7 @@ func (v *VFS) Fsync(ctx Context, ino Ino, datasync int, fh uint64) (err syscall.
 		defer h.Wunlock()
 		defer h.removeOp(ctx)
 
-		err = h.writer.Flush(ctx)
+		err = h.writer.Fsync(ctx)
 		if err == syscall.ENOENT || err == syscall.EPERM || err == syscall.EINVAL {
 			err = syscall.EBADF
 		}
@@ -1080,6 +1140,20 @@ func isXattrEnabled(conf *Config, name string) bool {
 	return true
 }
 
+// filterXattrs hides the names which could not be read with the current options, e.g. security.selinux
+// set by a mount with --enable-selinux.
+func filterXattrs(conf *Config, names []byte) []byte {
+	var out []byte
+	for len(names) > 0 {
+		name, rest, _ := bytes.Cut(names, []byte{0})
+		if isXattrEnabled(conf, string(name)) {
+			out = append(append(out, name...), 0)
+		}
+		names = rest
+	}
+	return out
+}
+
 func (v *VFS) SetXattr(ctx Context, ino Ino, name string, value []byte, flags uint32) (err syscall.Errno) {
 	defer func() { logit(ctx, "setxattr", err, "(%d,%s,%d,%d)", ino, name, len(value), flags) }()
 	if IsSpecialNode(ino) {
@@ -1176,6 +1250,9 @@ func (v *VFS) ListXattr(ctx Context, ino Ino, size int) (data []byte, err syscal
 		return
 	}
 	err = v.Meta.ListXattr(ctx, ino, &data)
+	if err == 0 {
+		data = filterXattrs(v.Conf, data)
+	}
 	if size > 0 && len(data) > size {
 		err = syscall.ERANGE
 	}
@@ -1222,10 +1299,14 @@ type VFS struct {
 	Meta            meta.Meta
 	Store           chunk.ChunkStore
 	InvalidateEntry func(parent meta.Ino, name string) syscall.Errno
+	InvalidateInode func(ino meta.Ino, off, length int64) syscall.Errno
+	NotifyDelete    func(parent, child meta.Ino, name string) syscall.Errno // nil if not supported
 	UpdateFormat    func(*meta.Format)
+	Auditor         *audit.Logger // nil means disabled
 	reader          DataReader
 	writer          DataWriter
 	cacheFiller     *CacheFiller
+	throttle        *throttle
 
 	handles   map[Ino][]*handle
 	handleIno map[uint64]Ino
@@ -1236,9 +1317,27 @@ type VFS struct {
 	modifiedAt map[Ino]time.Time
 
 	registry *prometheus.Registry
+
+	confM    sync.Mutex // protects the settings in Conf which can be changed at runtime
+	timeouts atomic.Pointer[CacheTimeouts]
+}
+
+// CacheTimeouts are the timeouts of the kernel caches, which can be changed at runtime by UpdateSettings.
+type CacheTimeouts struct {
+	Attr, Entry, DirEntry, NegEntry time.Duration
+}
+
+// Timeouts returns the current timeouts of the kernel caches, which are the ones in Conf until they are
+// changed at runtime.
+func (v *VFS) Timeouts() CacheTimeouts {
+	if t := v.timeouts.Load(); t != nil {
+		return *t
+	}
+	return CacheTimeouts{v.Conf.AttrTimeout, v.Conf.EntryTimeout, v.Conf.DirEntryTimeout, v.Conf.NegEntryTimeout}
 }
 
 func NewVFS(conf *Config, m meta.Meta, store chunk.ChunkStore, registerer prometheus.Registerer, registry *prometheus.Registry) *VFS {
+	store.SetChecksumStore(m)
 	reader := NewDataReader(conf, m, store)
 	writer := NewDataWriter(conf, m, store, reader)
 
@@ -1249,6 +1348,7 @@ func NewVFS(conf *Config, m meta.Meta, store chunk.ChunkStore, registerer promet
 		reader:      reader,
 		writer:      writer,
 		cacheFiller: NewCacheFiller(conf, m, store),
+		throttle:    newThrottle(conf.QoS, conf.PriorityDir),
 		handles:     make(map[Ino][]*handle),
 		handleIno:   make(map[uint64]Ino),
 		modifiedAt:  make(map[meta.Ino]time.Time),
@@ -17,12 +17,19 @@
 package vfs
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"slices"
 	"strings"
 	"syscall"
@@ -30,12 +37,16 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/juicedata/juicefs/pkg/acl"
+	"github.com/juicedata/juicefs/pkg/audit"
 	"github.com/juicedata/juicefs/pkg/chunk"
 	"github.com/juicedata/juicefs/pkg/meta"
 	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/sys/unix"
 )
 
@@ -360,6 +371,402 @@ func TestVFSIO(t *testing.T) {
 	v.Release(ctx, fe.Inode, fh)
 }
 
+func TestTmpfile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("O_TMPFILE is only supported on Linux")
+	}
+	v, _ := createTestVFS(nil, "")
+	ctx := NewLogContext(meta.Background())
+	fe, fh, e := v.Create(ctx, 1, "", 0644, 0, syscall.O_RDWR|O_TMPFILE)
+	require.Equal(t, syscall.Errno(0), e)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, []byte("hello"), 0, fh))
+	require.Equal(t, syscall.Errno(0), v.Flush(ctx, fe.Inode, fh, 0))
+	var entries []*meta.Entry
+	require.Equal(t, syscall.Errno(0), v.Meta.Readdir(ctx, 1, 0, &entries))
+	for _, e := range entries {
+		require.NotContains(t, string(e.Name), "tmpfile_")
+	}
+
+	le, e := v.Link(ctx, fe.Inode, 1, "linked")
+	require.Equal(t, syscall.Errno(0), e)
+	require.Equal(t, uint32(1), le.Attr.Nlink)
+	v.Release(ctx, fe.Inode, fh)
+	time.Sleep(time.Millisecond * 100)
+
+	le, fh, e = v.Open(ctx, le.Inode, syscall.O_RDONLY)
+	require.Equal(t, syscall.Errno(0), e)
+	buf := make([]byte, 10)
+	n, e := v.Read(ctx, le.Inode, buf, 0, fh)
+	require.Equal(t, syscall.Errno(0), e)
+	require.Equal(t, "hello", string(buf[:n]))
+	v.Release(ctx, le.Inode, fh)
+}
+
+type chunkInvalidator struct {
+	meta.Meta
+	got *[]string
+}
+
+func (m *chunkInvalidator) InvalidateChunkCache(ctx meta.Context, inode meta.Ino, indx uint32) syscall.Errno {
+	if indx == meta.InvalidateAllChunks {
+		*m.got = append(*m.got, fmt.Sprintf("chunks %d", inode))
+	}
+	return m.Meta.InvalidateChunkCache(ctx, inode, indx)
+}
+
+func TestInvalidateChange(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	var got []string
+	v.InvalidateEntry = func(parent meta.Ino, name string) syscall.Errno {
+		got = append(got, fmt.Sprintf("entry %d/%s", parent, name))
+		return 0
+	}
+	v.InvalidateInode = func(ino meta.Ino, off, length int64) syscall.Errno {
+		got = append(got, fmt.Sprintf("inode %d %d", ino, off))
+		return 0
+	}
+	v.Conf.Meta.Sid = 1
+	v.invalidateChange(&meta.ChangeEvent{Sid: 1, Op: meta.ChangeCreate, Inode: 3, Parent: 2, Name: "f"}, 2)
+	require.Empty(t, got, "changes of itself are ignored")
+
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeRename, Inode: 3, Parent: 2, Name: "f", NewParent: 4, NewName: "g"}, 2)
+	require.Equal(t, []string{"entry 1/f", "inode 1 0", "entry 4/g", "inode 4 0", "inode 3 -1"}, got)
+	got = nil
+	v.Meta = &chunkInvalidator{v.Meta, &got}
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeWrite, Inode: 3}, 2)
+	require.Equal(t, []string{"chunks 3", "inode 3 0"}, got)
+
+	got = nil
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeUnlink, Inode: 3, Parent: 2, Name: "f"}, 2)
+	require.Equal(t, []string{"entry 1/f", "inode 1 0", "inode 3 -1"}, got, "fall back to invalidate the entry")
+	v.NotifyDelete = func(parent, child meta.Ino, name string) syscall.Errno {
+		got = append(got, fmt.Sprintf("delete %d/%s %d", parent, name, child))
+		return 0
+	}
+	got = nil
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeUnlink, Inode: 3, Parent: 4, Name: "f"}, 2)
+	require.Equal(t, []string{"delete 4/f 3", "inode 4 0", "inode 3 -1"}, got)
+	got = nil
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeUnlink, Type: meta.TypeDirectory, Inode: 5, Parent: 2, Name: "d"}, 2)
+	require.Equal(t, []string{"delete 1/d 5", "inode 1 0", "inode 5 -1"}, got, "rmdir")
+	got = nil
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeRename, Type: meta.TypeFile, Inode: 3, Parent: 2, Name: "f", NewParent: 4, NewName: "g", Replaced: 6}, 2)
+	require.Equal(t, []string{"delete 1/f 3", "inode 1 0", "entry 4/g", "inode 4 0", "inode 3 -1", "inode 6 -1"}, got)
+	got = nil
+	v.invalidateChange(&meta.ChangeEvent{Sid: 2, Op: meta.ChangeRename, Type: meta.TypeDirectory, Inode: 5, Parent: 2, Name: "d", NewParent: 4, NewName: "e"}, 2)
+	require.Equal(t, []string{"entry 1/d", "inode 1 0", "entry 4/e", "inode 4 0", "inode 5 -1"}, got, "the old name of a directory is only invalidated")
+}
+
+func TestAudit(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewSink("file://" + logPath)
+	require.NoError(t, err)
+	v.Auditor, err = audit.NewLogger(v.Meta, sink, &audit.Config{Sink: "file://" + logPath, Prefixes: []string{"/secret"}})
+	require.NoError(t, err)
+
+	d, st := v.Mkdir(ctx, 1, "secret", 0777, 0)
+	require.Equal(t, syscall.Errno(0), st)
+	pd, st := v.Mkdir(ctx, 1, "public", 0777, 0)
+	require.Equal(t, syscall.Errno(0), st)
+	pe, fh, st := v.Create(ctx, pd.Inode, "p", 0644, 0, syscall.O_WRONLY)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, pe.Inode, []byte("hello"), 0, fh)) // filtered by the ancestors
+	v.Release(ctx, pe.Inode, fh)
+	require.Equal(t, syscall.Errno(0), v.Unlink(ctx, pd.Inode, "p"))
+	fe, fh, st := v.Create(ctx, d.Inode, "f", 0644, 0, syscall.O_WRONLY)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, []byte("hello"), 0, fh))
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, []byte("world"), 5, fh)) // audited once
+	v.Release(ctx, fe.Inode, fh)
+	_, fh, _ = v.Open(ctx, fe.Inode, syscall.O_RDONLY)
+	v.Release(ctx, fe.Inode, fh)
+	_, _, st = v.Create(ctx, d.Inode, "f", 0644, 0, syscall.O_WRONLY|syscall.O_EXCL)
+	require.Equal(t, syscall.EEXIST, st)
+	require.Equal(t, syscall.Errno(0), v.Rename(ctx, d.Inode, "f", 1, "g", 0))
+	require.Equal(t, syscall.Errno(0), v.Unlink(ctx, 1, "g")) // not under /secret
+	require.Equal(t, syscall.Errno(0), v.Rmdir(ctx, 1, "public"))
+	require.NoError(t, v.Auditor.Close())
+
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r audit.Record
+		require.NoError(t, json.Unmarshal([]byte(line), &r))
+		require.Equal(t, uint32(1), r.Uid)
+		require.Equal(t, uint32(10), r.Pid)
+		got = append(got, strings.Join(strings.Fields(fmt.Sprintf("%s %s %s %s %s", r.Op, r.Path, r.NewPath, r.Access, r.Result)), " "))
+	}
+	require.Equal(t, []string{
+		"mkdir /secret OK",
+		"create /secret/f write OK",
+		"write /secret/f OK",
+		"open /secret/f read OK",
+		"create /secret/f write EEXIST",
+		"rename /secret/f /g OK",
+	}, got)
+}
+
+// keptSpans keeps the exported spans after shutdown
+type keptSpans struct {
+	*tracetest.InMemoryExporter
+}
+
+func (e keptSpans) Shutdown(context.Context) error { return nil }
+
+func TestTracing(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	exporter := keptSpans{tracetest.NewInMemoryExporter()}
+	require.NoError(t, utils.StartTracing(exporter, "test", 1))
+	ctx := NewLogContext(meta.NewContext(10, 1, []uint32{2}))
+	fe, fh, st := v.Create(ctx, 1, "f", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, []byte("hello"), 0, fh))
+	require.Equal(t, syscall.Errno(0), v.Flush(ctx, fe.Inode, fh, 0))
+	buf := make([]byte, 5)
+	n, st := v.Read(ctx, fe.Inode, buf, 0, fh)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, 5, n)
+	v.Release(ctx, fe.Inode, fh)
+	utils.FlushTraces()
+
+	spans := exporter.GetSpans()
+	byID := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byID[s.SpanContext.SpanID().String()] = s
+	}
+	parent := func(s tracetest.SpanStub) string {
+		if p, ok := byID[s.Parent.SpanID().String()]; ok && s.Parent.IsValid() {
+			return p.Name
+		}
+		return ""
+	}
+	var chains []string
+	for _, s := range spans {
+		switch s.Name {
+		case "meta.mknod", "meta.read", "vfs.slice_read", "object.put":
+			chains = append(chains, parent(s)+" -> "+s.Name)
+		}
+	}
+	require.Contains(t, chains, "vfs.create -> meta.mknod")
+	require.Contains(t, chains, "vfs.read -> vfs.slice_read")
+	require.Contains(t, chains, "vfs.slice_read -> meta.read")
+	require.Contains(t, chains, " -> object.put") // uploaded in background
+}
+
+func TestIDMap(t *testing.T) {
+	m, err := ParseIDMap("100000:0:65536, 1000:70000")
+	require.Nil(t, err)
+	require.Equal(t, IDMap{{100000, 0, 65536}, {1000, 70000, 1}}, m)
+	toVolume := func(m IDMap, id uint32) uint32 {
+		v, ok := m.ToVolume(id)
+		require.True(t, ok, id)
+		return v
+	}
+	require.Equal(t, uint32(0), toVolume(m, 100000))
+	require.Equal(t, uint32(65535), toVolume(m, 165535))
+	require.Equal(t, uint32(70000), toVolume(m, 1000))
+	for _, id := range []uint32{165536, 1001} {
+		v, ok := m.ToVolume(id)
+		require.False(t, ok, id)
+		require.Equal(t, uint32(OverflowID), v)
+	}
+	require.Equal(t, uint32(100005), m.ToLocal(5))
+	require.Equal(t, uint32(1000), m.ToLocal(70000))
+	require.Equal(t, uint32(OverflowID), m.ToLocal(70001))
+
+	var empty IDMap
+	require.Equal(t, uint32(5), toVolume(empty, 5))
+
+	rule := &acl.Rule{Owner: 7, Group: 5, Mask: 6, Other: 4,
+		NamedUsers: acl.Entries{{Id: 100001, Perm: 6}}, NamedGroups: acl.Entries{{Id: 1000, Perm: 4}}}
+	value := encodeACL(rule)
+	same, st := MapACLXattr("user.a", value, m, m, true)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, value, same)
+	mapped, st := MapACLXattr(_SECURITY_ACL, value, m, m, true)
+	require.Equal(t, syscall.Errno(0), st)
+	r, st := decodeACL(mapped)
+	require.Equal(t, syscall.Errno(0), st)
+	require.Equal(t, uint32(1), r.NamedUsers[0].Id)
+	require.Equal(t, uint32(70000), r.NamedGroups[0].Id)
+	back, _ := MapACLXattr(_SECURITY_ACL_DEFAULT, mapped, m, m, false)
+	require.Equal(t, value, back)
+	rule.NamedUsers[0].Id = 1001
+	_, st = MapACLXattr(_SECURITY_ACL, encodeACL(rule), m, m, true)
+	require.Equal(t, syscall.EINVAL, st)
+	for _, s := range []string{"1", "1:2:3:4", "a:1", "1:2:0", "4294967295:1:2", "0:0:10,5:100"} {
+		_, err = ParseIDMap(s)
+		require.NotNil(t, err, s)
+	}
+}
+
+func TestStrideReadahead(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	ctx := NewLogContext(meta.Background())
+	fe, fh, e := v.Create(ctx, 1, "stride", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	var attr meta.Attr
+	require.Equal(t, syscall.Errno(0), v.Truncate(ctx, fe.Inode, 256<<20, fh, &attr))
+
+	conf := *v.Conf
+	chunkConf := *conf.Chunk
+	chunkConf.Readahead = 16 << 20
+	chunkConf.BufferSize = 300 << 20
+	conf.Chunk = &chunkConf
+	f := NewDataReader(&conf, v.Meta, v.Store).Open(fe.Inode, 256<<20).(*fileReader)
+	defer f.Close(ctx)
+	const step = 16 << 20
+	buf := make([]byte, 128<<10)
+	for i := 0; i < 4; i++ {
+		for off := 0; off < 1<<20; off += len(buf) {
+			n, e := f.Read(ctx, uint64(i*step+off), buf)
+			require.Equal(t, syscall.Errno(0), e)
+			require.Equal(t, len(buf), n)
+		}
+	}
+	f.Lock()
+	defer f.Unlock()
+	require.Equal(t, uint64(step), f.stride.step)
+	require.Equal(t, uint64(1<<20), f.stride.size)
+	require.Equal(t, uint64(2), f.stride.ahead)
+	var next bool
+	f.visit(func(s *sliceReader) bool {
+		next = next || s.block.off == 5*step
+		return true
+	})
+	require.True(t, next, "the run after next should be read ahead")
+}
+
+func TestLogWrites(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	v.Conf.LogWrites = true
+	ctx := NewLogContext(meta.Background())
+	fe, fh, e := v.Create(ctx, 1, "logwrites", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	expected := make([]byte, 1<<20)
+	rand.Read(expected)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, expected, 0, fh))
+	require.Equal(t, syscall.Errno(0), v.Fsync(ctx, fe.Inode, 1, fh))
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		off := r.Intn(len(expected) - 4096)
+		data := make([]byte, 1+r.Intn(4096))
+		r.Read(data)
+		copy(expected[off:], data)
+		require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, data, uint64(off), fh))
+		if i%50 == 0 { // overwrite the same range again
+			r.Read(data)
+			copy(expected[off:], data)
+			require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, data, uint64(off), fh))
+		}
+	}
+	require.Equal(t, syscall.Errno(0), v.Flush(ctx, fe.Inode, fh, 0))
+	var slices []meta.Slice
+	require.Equal(t, syscall.Errno(0), v.Meta.Read(meta.Background(), fe.Inode, 0, &slices))
+	ids := make(map[uint64]bool)
+	for _, s := range slices {
+		if s.Id > 0 {
+			ids[s.Id] = true
+		}
+	}
+	require.LessOrEqual(t, len(ids), 2, "small writes should go into one log slice")
+
+	buf := make([]byte, len(expected))
+	n, e := v.Read(ctx, fe.Inode, buf, 0, fh)
+	require.Equal(t, syscall.Errno(0), e)
+	require.Equal(t, len(expected), n)
+	require.True(t, bytes.Equal(expected, buf), "data read back is different")
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(&QoS{ReadBandwidth: 10 << 20, IOPS: 1000}, "")
+	th.wait(false, 10<<20)
+	start := time.Now()
+	th.wait(false, 1<<20)
+	th.wait(true, 1<<20) // writes are not limited
+	if d := time.Since(start); d < time.Millisecond*50 || d > time.Second {
+		t.Fatalf("read should be limited for about 100ms, but it took %s", d)
+	}
+
+	dir := filepath.Join(t.TempDir(), "io-priority")
+	low := newThrottle(&QoS{ReadBandwidth: 1 << 20, Priority: PriorityLow}, dir)
+	if !low.low {
+		t.Fatalf("low priority should be enabled with a private directory")
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("%s should be private: %v %v", dir, fi, err)
+	}
+	start = time.Now()
+	low.wait(false, 10<<20)
+	low.wait(false, 10<<20)
+	if d := time.Since(start); d > time.Millisecond*100 {
+		t.Fatalf("low priority should not be limited when others are idle, but it took %s", d)
+	}
+	if low.othersBusy() {
+		t.Fatalf("no other mount is busy")
+	}
+	_ = os.WriteFile(filepath.Join(low.dir, "1"), nil, 0666)
+	if !low.othersBusy() {
+		t.Fatalf("another mount is busy")
+	}
+	_ = os.WriteFile(filepath.Join(low.dir, low.self), nil, 0666)
+	_ = os.Remove(filepath.Join(low.dir, "1"))
+	if low.othersBusy() {
+		t.Fatalf("the mount itself should be ignored")
+	}
+
+	_ = os.Chmod(dir, 0777)
+	if newThrottle(&QoS{ReadBandwidth: 1 << 20, Priority: PriorityLow}, dir).low {
+		t.Fatalf("low priority should be disabled with a directory writable by others")
+	}
+}
+
+func TestCacheAdmission(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	ctx := NewLogContext(meta.Background())
+	de, e := v.Mkdir(ctx, 1, "models", 0755, 0)
+	require.Equal(t, syscall.Errno(0), e)
+	fe, fh, e := v.Create(ctx, de.Inode, "a", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	v.Release(ctx, fe.Inode, fh)
+	oe, fh, e := v.Create(ctx, 1, "models2", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	v.Release(ctx, oe.Inode, fh)
+
+	conf := *v.Conf
+	require.True(t, newCachePrefixes(&conf, v.Meta).cached(oe.Inode))
+	conf.CachePrefixes = []string{"/models", "/data/train"}
+	prefixes := newCachePrefixes(&conf, v.Meta)
+	require.True(t, prefixes.cached(fe.Inode))
+	require.False(t, prefixes.cached(oe.Inode))
+	require.Equal(t, []string{"/data/train"}, prefixes.missing)
+	require.True(t, NewDataReader(&conf, v.Meta, v.Store).Open(oe.Inode, 0).(*fileReader).nocache)
+
+	// hard links and the prefixes created later
+	require.Equal(t, syscall.Errno(0), v.Meta.Link(ctx, oe.Inode, de.Inode, "b", nil))
+	require.True(t, prefixes.cached(oe.Inode))
+	dd, e := v.Mkdir(ctx, 1, "data", 0755, 0)
+	require.Equal(t, syscall.Errno(0), e)
+	td, e := v.Mkdir(ctx, dd.Inode, "train", 0755, 0)
+	require.Equal(t, syscall.Errno(0), e)
+	te, fh, e := v.Create(ctx, td.Inode, "c", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	v.Release(ctx, te.Inode, fh)
+	require.False(t, prefixes.cached(te.Inode))
+	prefixes.resolved = time.Time{}
+	require.True(t, prefixes.cached(te.Inode))
+	require.Len(t, prefixes.missing, 0)
+
+	require.True(t, sizeCached(&conf, 1<<30))
+	conf.CacheMaxFileSize = 1 << 20
+	require.True(t, sizeCached(&conf, 1<<20))
+	require.False(t, sizeCached(&conf, 1<<20+1))
+}
+
 func TestVFSXattrs(t *testing.T) {
 	v, _ := createTestVFS(nil, "")
 	ctx := NewLogContext(meta.Background())
@@ -447,6 +854,22 @@ func TestVFSXattrs(t *testing.T) {
 	if e := v.RemoveXattr(ctx, ConfigInode, "test"); e != syscall.EPERM {
 		t.Fatalf("removexattr test: %s", e)
 	}
+	// security xattrs
+	if e := v.SetXattr(ctx, fe.Inode, "security.selinux", []byte("system_u:object_r:container_file_t:s0"), 0); e != syscall.ENOTSUP {
+		t.Fatalf("setxattr security.selinux: %s", e)
+	}
+	v.Conf.Security = &SecurityConfig{EnableSELinux: true}
+	if e := v.SetXattr(ctx, fe.Inode, "security.selinux", []byte("system_u:object_r:container_file_t:s0"), 0); e != 0 {
+		t.Fatalf("setxattr security.selinux: %s", e)
+	}
+	_ = v.SetXattr(ctx, fe.Inode, "user.test", []byte("v"), 0)
+	if v, e := v.ListXattr(ctx, fe.Inode, 100); e != 0 || string(v) != "security.selinux\x00user.test\x00" {
+		t.Fatalf("listxattr: %s %q", e, string(v))
+	}
+	v.Conf.Security = nil
+	if v, e := v.ListXattr(ctx, fe.Inode, 100); e != 0 || string(v) != "user.test\x00" {
+		t.Fatalf("listxattr: %s %q", e, string(v))
+	}
 }
 
 type accessCase struct {
@@ -829,6 +1252,46 @@ func TestInternalFile(t *testing.T) {
 		t.Fatalf("info v2 result: %s", infoResp.Reason)
 	}
 
+	// info v2 with layout
+	lfe, lfh, e := v.Create(ctx, 1, "layout", 0644, 0, syscall.O_RDWR)
+	if e != 0 {
+		t.Fatalf("create layout: %s", e)
+	}
+	if e = v.Write(ctx, lfe.Inode, bytes.Repeat([]byte("layout"), 1000), 0, lfh); e != 0 {
+		t.Fatalf("write layout: %s", e)
+	}
+	if e = v.Flush(ctx, lfe.Inode, lfh, 0); e != 0 {
+		t.Fatalf("flush layout: %s", e)
+	}
+	v.Release(ctx, lfe.Inode, lfh)
+	buf = make([]byte, 4+4+8+4)
+	w = utils.FromBuffer(buf)
+	w.Put32(meta.InfoV2)
+	w.Put32(12)
+	w.Put64(uint64(lfe.Inode))
+	w.Put8(0)
+	w.Put8(0)
+	w.Put8(0)
+	w.Put8(1)
+	if e := v.Write(ctx, fe.Inode, w.Bytes(), off, fh); e != 0 {
+		t.Fatalf("write info v2 with layout: %s", e)
+	}
+	off += uint64(len(buf))
+	buf = make([]byte, 1024*10)
+	if data, e = readData(buf, &off); e != 0 {
+		t.Fatalf("read info v2 with layout: %s", e)
+	}
+	infoResp = InfoResponse{}
+	if e := json.Unmarshal(data, &infoResp); e != nil {
+		t.Fatalf("unmarshal info v2 with layout: %s", e)
+	}
+	if len(infoResp.Chunks) != 1 || len(infoResp.Objects) != 1 || infoResp.Objects[0].Len != 6000 || infoResp.Objects[0].Stored <= 0 {
+		t.Fatalf("info v2 with layout: %+v %+v", infoResp.Chunks, infoResp.Objects)
+	}
+	if e = v.Unlink(ctx, 1, "layout"); e != 0 {
+		t.Fatalf("unlink layout: %s", e)
+	}
+
 	// fill
 	buf = make([]byte, 4+4+8+1+1+2+1)
 	w = utils.FromBuffer(buf)
@@ -1160,3 +1623,89 @@ func testReaddir(t *testing.T, metaUri string, dirNum int, offset int) {
 	entriesTwo := readAll(ctx, parent, fh, offset)
 	require.True(t, reflect.DeepEqual(entriesOne, entriesTwo))
 }
+
+func TestAdminAPI(t *testing.T) {
+	v, _ := createTestVFS(nil, "")
+	ctx := NewLogContext(meta.Background())
+	fe, fh, e := v.Create(ctx, 1, "file", 0644, 0, syscall.O_RDWR)
+	require.Equal(t, syscall.Errno(0), e)
+	require.Equal(t, syscall.Errno(0), v.Write(ctx, fe.Inode, []byte("hello"), 0, fh))
+	require.Equal(t, syscall.Errno(0), v.Flush(ctx, fe.Inode, fh, 0))
+
+	restarted := false
+	h := v.AdminHandler(func() error { restarted = true; return nil }, "")
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	w := call("GET", "/config", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var conf Config
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &conf))
+	require.Equal(t, "test", conf.Format.Name)
+
+	w = call("PATCH", "/config", `{"LogLevel": "debug", "UploadLimit": 80}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, int64(10e6), v.Conf.Chunk.UploadLimit)
+	require.Equal(t, int64(0), v.Conf.Chunk.DownloadLimit)
+	utils.SetLogLevel(logrus.InfoLevel)
+	require.Equal(t, http.StatusBadRequest, call("PATCH", "/config", `{"LogLevel": "loud"}`).Code)
+	require.Equal(t, http.StatusBadRequest, call("PATCH", "/config", `{"DownloadLimit": -1}`).Code)
+	w = call("PATCH", "/config", `{"CacheSize": 100, "BufferSize": 64, "AttrCache": 2, "NegativeEntryCache": 0.5}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, uint64(100<<20), v.Conf.Chunk.CacheSize)
+	require.Equal(t, int64(64<<20), v.writer.(*dataWriter).bufferSize.Load())
+	require.Equal(t, uint64(64<<20/10*8), v.reader.(*dataReader).readAheadTotal.Load())
+	require.Equal(t, time.Second*2, v.Timeouts().Attr)
+	require.Equal(t, time.Millisecond*500, v.Timeouts().NegEntry)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &conf))
+	require.Equal(t, time.Second*2, conf.AttrTimeout)
+	require.Equal(t, http.StatusBadRequest, call("PATCH", "/config", `{"CacheSize": 0}`).Code)
+	require.Equal(t, http.StatusBadRequest, call("PATCH", "/config", `{"BufferSize": 1, "LogLevel": "debug"}`).Code)
+	require.Equal(t, http.StatusBadRequest, call("PATCH", "/config", `{"EntryCache": -1}`).Code)
+	require.Equal(t, uint64(64<<20), v.Conf.Chunk.BufferSize)
+
+	w = call("POST", "/cache/warmup", `{"Paths": ["/file"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var stat CacheResponse
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &stat))
+	require.Equal(t, uint64(1), stat.FileCount)
+	require.Equal(t, uint64(5), stat.TotalBytes)
+	require.Equal(t, http.StatusBadRequest, call("POST", "/cache/evict", `{}`).Code)
+	require.Equal(t, http.StatusOK, call("POST", "/cache/evict", `{"Paths": ["/file"]}`).Code)
+
+	w = call("GET", "/cache", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats map[string]float64
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Contains(t, stats, "blocks")
+
+	fh2 := v.handles[fe.Inode][0]
+	fh2.addOp(ctx)
+	w = call("GET", "/ops", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var ops []AdminOp
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &ops))
+	require.Equal(t, 1, len(ops))
+	require.Equal(t, fe.Inode, ops[0].Inode)
+	fh2.removeOp(ctx)
+
+	require.Equal(t, http.StatusMethodNotAllowed, call("GET", "/restart", "").Code)
+	require.Equal(t, http.StatusAccepted, call("POST", "/restart", "").Code)
+	require.True(t, restarted)
+	h = v.AdminHandler(nil, "")
+	require.Equal(t, http.StatusNotImplemented, call("POST", "/restart", "").Code)
+
+	h = v.AdminHandler(nil, "secret")
+	require.Equal(t, http.StatusUnauthorized, call("GET", "/config", "").Code)
+	r := httptest.NewRequest("GET", "/config", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+}
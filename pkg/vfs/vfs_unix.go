@@ -75,6 +75,7 @@ func accessTest(attr *Attr, mmode uint16, uid uint32, gid uint32) syscall.Errno
 }
 
 func (v *VFS) Access(ctx Context, ino Ino, mask int) (err syscall.Errno) {
+	ctx = startSpan(ctx, "access", ino)
 	defer func() { logit(ctx, "access", err, "(%d,0x%X)", ino, mask) }()
 	var mmask uint16
 	if mask&unix.R_OK != 0 {
@@ -143,8 +144,12 @@ func setattrStr(set int, mode, uid, gid uint32, atime, mtime int64, size uint64)
 
 func (v *VFS) SetAttr(ctx Context, ino Ino, set int, fh uint64, mode, uid, gid uint32, atime, mtime int64, atimensec, mtimensec uint32, size uint64) (entry *meta.Entry, err syscall.Errno) {
 	str := setattrStr(set, mode, uid, gid, atime, mtime, size)
+	ctx = startSpan(ctx, "setattr", ino)
 	defer func() {
 		logit(ctx, "setattr", err, "(%d[%d],0x%X,[%s]):%s", ino, fh, set, str, (*Entry)(entry))
+		if !IsSpecialNode(ino) {
+			v.Auditor.Inode(ctx, "setattr", ino, 0, err)
+		}
 	}()
 	if IsSpecialNode(ino) {
 		n := getInternalNode(ino)
@@ -347,8 +352,18 @@ func (v *VFS) Ioctl(ctx Context, ino Ino, cmd uint32, arg uint64, bufIn, bufOut
 		} else {
 			return syscall.EINVAL
 		}
-		if ctx.CheckPermission() && ctx.Uid() != 0 && iflag&(FS_SECRM_FL|FS_IMMUTABLE_FL|FS_APPEND_FL) != 0 {
-			return syscall.EPERM
+		if ctx.CheckPermission() && ctx.Uid() != 0 {
+			if iflag&(FS_SECRM_FL|FS_IMMUTABLE_FL|FS_APPEND_FL) != 0 {
+				return syscall.EPERM
+			}
+			// clearing them needs the privilege too (CAP_LINUX_IMMUTABLE)
+			if err = v.Meta.GetAttr(ctx, ino, attr); err != 0 {
+				return
+			}
+			if attr.Flags&(meta.FlagImmutable|meta.FlagAppend) != 0 {
+				return syscall.EPERM
+			}
+			attr.Flags = 0
 		}
 		if (iflag & FS_SECRM_FL) != 0 {
 			attr.Flags |= meta.FlagSkipTrash
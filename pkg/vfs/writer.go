@@ -20,6 +20,7 @@ import (
 	"math/rand"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -30,11 +31,13 @@ import (
 
 const (
 	flushDuration = time.Second * 5
+	maxLogExtents = 256 // extents in a log slice, less than the slices to trigger compaction
 )
 
 type FileWriter interface {
 	Write(ctx meta.Context, offset uint64, data []byte) syscall.Errno
 	Flush(ctx meta.Context) syscall.Errno
+	Fsync(ctx meta.Context) syscall.Errno
 	Close(ctx meta.Context) syscall.Errno
 	GetLength() uint64
 	Truncate(length uint64)
@@ -47,6 +50,7 @@ type DataWriter interface {
 	Truncate(inode Ino, length uint64)
 	UpdateMtime(inode Ino, mtime time.Time)
 	FlushAll() error
+	UpdateBufferSize(size uint64)
 }
 
 type sliceWriter struct {
@@ -63,6 +67,7 @@ type sliceWriter struct {
 	notify  *utils.Cond
 	started time.Time
 	lastMod time.Time
+	extents []meta.Extent // the ranges of chunk in a log slice in order, nil for normal slices
 }
 
 func (s *sliceWriter) prepareID(ctx meta.Context, retry bool) {
@@ -120,12 +125,61 @@ func (s *sliceWriter) flushData() {
 
 		s.writer.Abort()
 		s.err = syscall.EIO
+	} else if f := s.chunk.file; f.w.conf.Chunk.FsyncUpload && f.w.store.Staged(s.id, int(s.length)) {
+		f.Lock()
+		f.addStaged(meta.Slice{Id: s.id, Size: s.length, Len: s.length})
+		f.Unlock()
+	}
+}
+
+// protected by s.chunk.file
+func (s *sliceWriter) overlap(pos, size uint32) bool {
+	if s.extents == nil {
+		return pos < s.off+s.slen && s.off < pos+size
+	}
+	for _, e := range s.extents {
+		if pos < e.Pos+e.Len && e.Pos < pos+size {
+			return true
+		}
 	}
+	return false
+}
+
+// protected by s.chunk.file
+func (s *sliceWriter) hasRoom(size uint32) bool {
+	return !s.freezed && s.slen+size <= meta.ChunkSize && len(s.extents) < maxLogExtents
+}
+
+// appendLog returns the offset in the log slice to write the data at pos of the chunk: the last extent of
+// the range is overwritten in place if it's not flushed yet, otherwise the data is appended.
+// protected by s.chunk.file
+func (s *sliceWriter) appendLog(pos, size uint32) uint32 {
+	flushoff := s.slen / uint32(s.chunk.file.w.blockSize) * uint32(s.chunk.file.w.blockSize)
+	for i := len(s.extents) - 1; i >= 0; i-- {
+		e := &s.extents[i]
+		if pos < e.Pos+e.Len && e.Pos < pos+size {
+			if pos >= e.Pos && pos+size <= e.Pos+e.Len && e.Off >= flushoff {
+				return e.Off + pos - e.Pos
+			}
+			break
+		}
+	}
+	if n := len(s.extents); n > 0 {
+		if e := &s.extents[n-1]; e.Pos+e.Len == pos && e.Off+e.Len == s.slen {
+			e.Len += size // sequential writes
+			return s.slen
+		}
+	}
+	s.extents = append(s.extents, meta.Extent{Pos: pos, Slice: meta.Slice{Off: s.slen, Len: size}})
+	return s.slen
 }
 
 // protected by s.chunk.file
 func (s *sliceWriter) write(ctx meta.Context, off uint32, data []uint8) syscall.Errno {
 	f := s.chunk.file
+	if s.extents != nil {
+		off = s.appendLog(off, uint32(len(data)))
+	}
 	_, err := s.writer.WriteAt(data, int64(off))
 	if err != nil {
 		logger.Warnf("write inode: %v chunk: %d off: %d %s", s.chunk.file.inode, s.id, off, err)
@@ -161,7 +215,7 @@ func (c *chunkWriter) findWritableSlice(pos uint32, size uint32) *sliceWriter {
 	blockSize := uint32(c.file.w.blockSize)
 	for i := range c.slices {
 		s := c.slices[len(c.slices)-1-i]
-		if !s.freezed {
+		if !s.freezed && s.extents == nil {
 			flushoff := s.slen / blockSize * blockSize
 			if pos >= s.off+flushoff && pos <= s.off+s.slen {
 				return s
@@ -170,7 +224,7 @@ func (c *chunkWriter) findWritableSlice(pos uint32, size uint32) *sliceWriter {
 				go s.flushData()
 			}
 		}
-		if pos < s.off+s.slen && s.off < pos+size {
+		if s.overlap(pos, size) {
 			// overlaped
 			// TODO: write into multiple slices
 			return nil
@@ -179,6 +233,24 @@ func (c *chunkWriter) findWritableSlice(pos uint32, size uint32) *sliceWriter {
 	return nil
 }
 
+// findLogSlice returns the log slice to append a small write, it should be the latest slice of the chunk
+// to keep the order of the writes.
+// protected by file
+func (c *chunkWriter) findLogSlice(size uint32) *sliceWriter {
+	if n := len(c.slices); n > 0 {
+		if s := c.slices[n-1]; s.extents != nil {
+			if s.hasRoom(size) {
+				return s
+			}
+			if !s.freezed {
+				s.freezed = true
+				go s.flushData()
+			}
+		}
+	}
+	return nil
+}
+
 func (c *chunkWriter) commitThread() {
 	f := c.file
 	defer f.w.free(f)
@@ -196,7 +268,15 @@ func (c *chunkWriter) commitThread() {
 		err := s.err
 		f.Unlock()
 
-		if err == 0 {
+		if err == 0 && s.extents != nil {
+			for i := range s.extents {
+				s.extents[i].Id, s.extents[i].Size = s.id, s.length
+			}
+			err = f.w.m.WriteExtents(meta.Background(), f.inode, c.indx, s.extents, s.lastMod)
+			for _, e := range s.extents {
+				f.w.reader.Invalidate(f.inode, uint64(c.indx)*meta.ChunkSize+uint64(e.Pos), uint64(e.Len))
+			}
+		} else if err == 0 {
 			var ss = meta.Slice{Id: s.id, Size: s.length, Off: s.soff, Len: s.slen}
 			err = f.w.m.Write(meta.Background(), f.inode, c.indx, s.off, ss, s.lastMod)
 			f.w.reader.Invalidate(f.inode, uint64(c.indx)*meta.ChunkSize+uint64(s.off), uint64(ss.Len))
@@ -232,11 +312,24 @@ type fileWriter struct {
 	writewaiting uint16
 	refs         uint16
 	chunks       map[uint32]*chunkWriter
+	staged       []meta.Slice // flushed slices that may not be uploaded yet, for fsync-upload
+	nocache      bool         // not under the prefixes to cache
 
 	flushcond *utils.Cond // wait for chunks==nil (flush)
 	writecond *utils.Cond // wait for flushwaiting==0 (write)
 }
 
+// addStaged remembers the slice for fsync, and forgets the ones uploaded already, protected by file
+func (f *fileWriter) addStaged(s meta.Slice) {
+	staged := f.staged[:0]
+	for _, o := range f.staged {
+		if f.w.store.Staged(o.Id, int(o.Size)) {
+			staged = append(staged, o)
+		}
+	}
+	f.staged = append(staged, s)
+}
+
 // protected by file
 func (f *fileWriter) findChunk(i uint32) *chunkWriter {
 	c := f.chunks[i]
@@ -259,6 +352,10 @@ func (f *fileWriter) freeChunk(c *chunkWriter) {
 func (f *fileWriter) writeChunk(ctx meta.Context, indx uint32, off uint32, data []byte) syscall.Errno {
 	c := f.findChunk(indx)
 	s := c.findWritableSlice(off, uint32(len(data)))
+	logged := s == nil && f.w.conf.LogWrites && len(data) < f.w.blockSize
+	if logged {
+		s = c.findLogSlice(uint32(len(data)))
+	}
 	if s == nil {
 		s = &sliceWriter{
 			chunk:   c,
@@ -267,6 +364,13 @@ func (f *fileWriter) writeChunk(ctx meta.Context, indx uint32, off uint32, data
 			notify:  utils.NewCond(&f.Mutex),
 			started: time.Now(),
 		}
+		if logged {
+			s.off = 0
+			s.extents = make([]meta.Extent, 0, 16)
+		}
+		if f.nocache || !sizeCached(f.w.conf, f.length) {
+			s.writer.SetCache(false)
+		}
 		go s.prepareID(meta.Background(), false)
 		c.slices = append(c.slices, s)
 		if len(c.slices) == 1 {
@@ -300,10 +404,10 @@ func (f *fileWriter) Write(ctx meta.Context, off uint64, data []byte) syscall.Er
 		}
 		time.Sleep(time.Millisecond)
 	}
-	if f.w.usedBufferSize() > f.w.bufferSize {
+	if f.w.usedBufferSize() > f.w.bufferSize.Load() {
 		// slow down
 		time.Sleep(time.Millisecond * 10)
-		for f.w.usedBufferSize() > f.w.bufferSize*2 {
+		for f.w.usedBufferSize() > f.w.bufferSize.Load()*2 {
 			time.Sleep(time.Millisecond * 100)
 		}
 	}
@@ -406,6 +510,27 @@ func (f *fileWriter) Flush(ctx meta.Context) syscall.Errno {
 	return f.flush(ctx, false)
 }
 
+// Fsync flushes the data like Flush, and waits for the staging blocks to be uploaded if fsync-upload is enabled.
+func (f *fileWriter) Fsync(ctx meta.Context) syscall.Errno {
+	if err := f.Flush(ctx); err != 0 || !f.w.conf.Chunk.FsyncUpload {
+		return err
+	}
+	f.Lock()
+	staged := f.staged
+	f.staged = nil
+	f.Unlock()
+	for i, s := range staged {
+		if err := f.w.store.Persist(s.Id, int(s.Size)); err != nil {
+			logger.Errorf("upload slice %d of inode %d: %s", s.Id, f.inode, err)
+			f.Lock()
+			f.staged = append(staged[i:], f.staged...)
+			f.Unlock()
+			return syscall.EIO
+		}
+	}
+	return 0
+}
+
 func (f *fileWriter) Close(ctx meta.Context) syscall.Errno {
 	defer f.w.free(f)
 	return f.Flush(ctx)
@@ -431,9 +556,10 @@ type dataWriter struct {
 	conf       *Config
 	reader     DataReader
 	blockSize  int
-	bufferSize int64
+	bufferSize atomic.Int64 // changed by UpdateBufferSize at runtime
 	files      map[Ino]*fileWriter
 	maxRetries uint32
+	prefixes   *cachePrefixes
 }
 
 func NewDataWriter(conf *Config, m meta.Meta, store chunk.ChunkStore, reader DataReader) DataWriter {
@@ -443,14 +569,19 @@ func NewDataWriter(conf *Config, m meta.Meta, store chunk.ChunkStore, reader Dat
 		reader:     reader,
 		conf:       conf,
 		blockSize:  conf.Chunk.BlockSize,
-		bufferSize: int64(conf.Chunk.BufferSize),
 		files:      make(map[Ino]*fileWriter),
 		maxRetries: uint32(conf.Meta.Retries),
+		prefixes:   newCachePrefixes(conf, m),
 	}
+	w.bufferSize.Store(int64(conf.Chunk.BufferSize))
 	go w.flushAll()
 	return w
 }
 
+func (w *dataWriter) UpdateBufferSize(size uint64) {
+	w.bufferSize.Store(int64(size))
+}
+
 func (w *dataWriter) flushAll() {
 	for {
 		w.Lock()
@@ -482,15 +613,17 @@ func (w *dataWriter) flushAll() {
 }
 
 func (w *dataWriter) Open(inode Ino, len uint64) FileWriter {
+	nocache := !w.prefixes.cached(inode)
 	w.Lock()
 	defer w.Unlock()
 	f, ok := w.files[inode]
 	if !ok {
 		f = &fileWriter{
-			w:      w,
-			inode:  inode,
-			length: len,
-			chunks: make(map[uint32]*chunkWriter),
+			w:       w,
+			inode:   inode,
+			length:  len,
+			chunks:  make(map[uint32]*chunkWriter),
+			nocache: nocache,
 		}
 		f.flushcond = utils.NewCond(f)
 		f.writecond = utils.NewCond(f)
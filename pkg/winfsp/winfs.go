@@ -70,6 +70,7 @@ type juice struct {
 	delayClose     int
 	enabledGetPath bool
 	disableSymlink bool
+	enableXattr    bool
 
 	attrCacheTimeout time.Duration
 }
@@ -147,6 +148,10 @@ func errorconv(err syscall.Errno) int {
 		return -fuse.ENAMETOOLONG
 	case syscall.ERROR_HANDLE_EOF:
 		return -fuse.ENODATA
+	case meta.ENOATTR:
+		return -fuse.ENOATTR
+	case syscall.ERANGE:
+		return -fuse.ERANGE
 	}
 
 	return -int(err)
@@ -226,8 +231,11 @@ func (j *juice) Rmdir(path string) (e int) {
 	return
 }
 
+// Symlink creates a symbolic link, which is shown as a reparse point by WinFsp.
 func (j *juice) Symlink(target string, newpath string) (e int) {
-	return -fuse.ENOSYS
+	if j.disableSymlink {
+		return -fuse.ENOSYS
+	}
 	ctx := j.newContext()
 	defer trace(target, newpath)(&e)
 	parent, err := j.fs.Open(ctx, path.Dir(newpath), 0)
@@ -237,6 +245,32 @@ func (j *juice) Symlink(target string, newpath string) (e int) {
 	}
 	_, errno := j.vfs.Symlink(ctx, target, parent.Inode(), path.Base(newpath))
 	e = errorconv(errno)
+	if e == 0 {
+		j.fs.InvalidateEntry(parent.Inode(), path.Base(newpath))
+	}
+	return
+}
+
+// Link creates a hard link to a file.
+func (j *juice) Link(oldpath string, newpath string) (e int) {
+	ctx := j.newContext()
+	defer trace(oldpath, newpath)(&e)
+	fi, err := j.fs.Lstat(ctx, oldpath)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	parent, err := j.fs.Open(ctx, path.Dir(newpath), 0)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	_, errno := j.vfs.Link(ctx, fi.Inode(), parent.Inode(), path.Base(newpath))
+	e = errorconv(errno)
+	if e == 0 {
+		j.fs.InvalidateEntry(parent.Inode(), path.Base(newpath))
+		j.invalidateAttrCache(fi.Inode())
+	}
 	return
 }
 
@@ -258,6 +292,80 @@ func (j *juice) Readlink(path string) (e int, target string) {
 	return
 }
 
+// Setxattr sets an extended attribute, which is shown as an NTFS extended attribute (EA) by WinFsp.
+func (j *juice) Setxattr(p string, name string, value []byte, flags int) (e int) {
+	if !j.enableXattr {
+		return -fuse.ENOSYS
+	}
+	ctx := j.newContext()
+	defer trace(p, name, len(value), flags)(&e)
+	fi, err := j.fs.Lstat(ctx, p)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	e = errorconv(j.vfs.SetXattr(ctx, fi.Inode(), name, value, uint32(flags)))
+	return
+}
+
+// Getxattr gets an extended attribute.
+func (j *juice) Getxattr(p string, name string) (e int, value []byte) {
+	if !j.enableXattr {
+		return -fuse.ENOSYS, nil
+	}
+	ctx := j.newContext()
+	defer trace(p, name)(&e)
+	fi, err := j.fs.Lstat(ctx, p)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	value, err = j.vfs.GetXattr(ctx, fi.Inode(), name, 0)
+	e = errorconv(err)
+	return
+}
+
+// Removexattr removes an extended attribute.
+func (j *juice) Removexattr(p string, name string) (e int) {
+	if !j.enableXattr {
+		return -fuse.ENOSYS
+	}
+	ctx := j.newContext()
+	defer trace(p, name)(&e)
+	fi, err := j.fs.Lstat(ctx, p)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	e = errorconv(j.vfs.RemoveXattr(ctx, fi.Inode(), name))
+	return
+}
+
+// Listxattr lists the extended attributes.
+func (j *juice) Listxattr(p string, fill func(name string) bool) (e int) {
+	if !j.enableXattr {
+		return -fuse.ENOSYS
+	}
+	ctx := j.newContext()
+	defer trace(p)(&e)
+	fi, err := j.fs.Lstat(ctx, p)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	names, err := j.vfs.ListXattr(ctx, fi.Inode(), 0)
+	if err != 0 {
+		e = errorconv(err)
+		return
+	}
+	for _, name := range strings.Split(string(names), "\x00") {
+		if name != "" && !fill(name) {
+			return -fuse.ERANGE
+		}
+	}
+	return
+}
+
 // Rename renames a file.
 func (j *juice) Rename(oldpath string, newpath string) (e int) {
 	ctx := j.newContext()
@@ -920,7 +1028,7 @@ func (j *juice) Getpath(p string, fh uint64) (e int, ret string) {
 	return
 }
 
-func Serve(v *vfs.VFS, fuseOpt string, asRoot bool, delayCloseSec int, showDotFiles bool, threadsCount int, caseSensitive bool, enabledGetPath bool) {
+func Serve(v *vfs.VFS, fuseOpt string, asRoot bool, delayCloseSec int, showDotFiles bool, threadsCount int, caseSensitive bool, enabledGetPath bool, enableSymlink bool, enableXattr bool) {
 	var jfs juice
 	conf := v.Conf
 	jfs.attrCacheTimeout = v.Conf.AttrTimeout
@@ -932,7 +1040,8 @@ func Serve(v *vfs.VFS, fuseOpt string, asRoot bool, delayCloseSec int, showDotFi
 	if err != nil {
 		logger.Fatalf("Initialize FileSystem failed: %s", err)
 	}
-	jfs.disableSymlink = os.Getenv("JUICEFS_ENABLE_SYMLINK") != "1"
+	jfs.disableSymlink = !enableSymlink && os.Getenv("JUICEFS_ENABLE_SYMLINK") != "1"
+	jfs.enableXattr = enableXattr
 	jfs.asRoot = asRoot
 	jfs.delayClose = delayCloseSec
 	host := fuse.NewFileSystemHost(&jfs)
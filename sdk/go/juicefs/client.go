@@ -0,0 +1,517 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package juicefs is the Go SDK of JuiceFS, it accesses a volume in the process without mounting it.
+//
+// The API of this package is stable: the exported names are not removed or changed incompatibly within
+// the same major version of JuiceFS, new ones may be added in minor versions. The other packages in
+// this repository (pkg/...) are the internal implementation and may be changed in any release, so they
+// should not be used by applications directly.
+//
+// A Client is safe for concurrent use. The methods take a context, which can carry the credentials
+// (added by WithCredentials) to check the permissions of the call, and is used to cancel the call. The
+// methods of File use the context set by WithContext.
+//
+//	c, err := juicefs.Open(&juicefs.Config{MetaURL: "redis://localhost:6379/1"})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	ctx := juicefs.WithCredentials(context.Background(), 1000, 1000)
+//	f, err := c.Create(ctx, "/hello.txt")
+//	...
+package juicefs
+
+import (
+	"context"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juicedata/juicefs/cmd"
+	"github.com/juicedata/juicefs/pkg/chunk"
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/version"
+	"github.com/juicedata/juicefs/pkg/vfs"
+)
+
+// Config is the configuration of a client, only MetaURL is required.
+type Config struct {
+	MetaURL  string // address of the metadata engine, e.g. redis://localhost:6379/1
+	Bucket   string // bucket to use instead of the one in the settings of the volume
+	Subdir   string // only the sub directory is accessible, as the root of the client
+	ReadOnly bool
+	NoBGJob  bool // don't run background jobs (cleanup of trash, deleted files, etc.) in this client
+
+	CacheDir      string // directories of the local cache separated by ':', "memory" by default
+	CacheSize     int64  // size of the local cache in bytes, 100 MiB by default
+	BufferSize    int64  // total size of the buffer for read and write in bytes, 300 MiB by default
+	MaxUploads    int    // number of concurrent uploads, 20 by default
+	Prefetch      *int   // number of blocks to prefetch (0 to disable), 1 by default
+	Writeback     bool   // upload the blocks in background (CacheDir should not be "memory")
+	UploadLimit   int64  // bandwidth limit for upload in Mbps, 0 for unlimited
+	DownloadLimit int64  // bandwidth limit for download in Mbps, 0 for unlimited
+
+	AttrTimeout     time.Duration // cache timeout of attributes, 1s by default
+	EntryTimeout    time.Duration // cache timeout of file entries, 1s by default
+	DirEntryTimeout time.Duration // cache timeout of directory entries, 1s by default
+
+	Umask *uint16 // umask for the created files and directories, 022 by default
+}
+
+// Stat is the information of a file returned by the Sys() of FileInfo, as a *Stat.
+type Stat struct {
+	Inode               uint64
+	Nlink               uint32
+	Uid                 uint32
+	Gid                 uint32
+	Rdev                uint32 // device number of a block or character device
+	Atime, Mtime, Ctime time.Time
+}
+
+type fileInfo struct {
+	iofs.FileInfo
+	st *Stat
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return fi.st
+}
+
+func newFileInfo(fi iofs.FileInfo) iofs.FileInfo {
+	fst, ok := fi.(*fs.FileStat)
+	if !ok {
+		return fi
+	}
+	a := fst.Attr()
+	return &fileInfo{fi, &Stat{
+		Inode: uint64(fst.Inode()),
+		Nlink: a.Nlink,
+		Uid:   a.Uid,
+		Gid:   a.Gid,
+		Rdev:  a.Rdev,
+		Atime: time.Unix(a.Atime, int64(a.Atimensec)),
+		Mtime: time.Unix(a.Mtime, int64(a.Mtimensec)),
+		Ctime: time.Unix(a.Ctime, int64(a.Ctimensec)),
+	}}
+}
+
+func newFileInfos(fis []iofs.FileInfo) []iofs.FileInfo {
+	for i, fi := range fis {
+		fis[i] = newFileInfo(fi)
+	}
+	return fis
+}
+
+// Client accesses a volume.
+type Client struct {
+	conf  *Config
+	umask uint16
+	m     meta.Meta
+	blob  object.ObjectStorage
+	fs    *fs.FileSystem
+}
+
+type credentialsKey struct{}
+
+type credentials struct {
+	uid  uint32
+	gids []uint32
+}
+
+// WithCredentials returns a context with the user and groups (the first one is the primary group) to access
+// the files. If no group is given, the primary group is the same as uid. The calls without credentials are
+// done as root.
+func WithCredentials(ctx context.Context, uid uint32, gids ...uint32) context.Context {
+	if len(gids) == 0 {
+		gids = []uint32{uid}
+	}
+	return context.WithValue(ctx, credentialsKey{}, &credentials{uid, gids})
+}
+
+func newContext(ctx context.Context) meta.Context {
+	if c, ok := ctx.Value(credentialsKey{}).(*credentials); ok {
+		return meta.WrapWithoutCancel(ctx, uint32(os.Getpid()), c.uid, c.gids)
+	}
+	return meta.WrapWithoutCancel(ctx, uint32(os.Getpid()), 0, []uint32{0})
+}
+
+// pathError returns the error of the operation on the path, or nil if it succeeds. The errors can be
+// checked by errors.Is, e.g. errors.Is(err, fs.ErrNotExist).
+func pathError(op, name string, eno syscall.Errno) error {
+	if eno == 0 {
+		return nil
+	}
+	return &iofs.PathError{Op: op, Path: name, Err: eno}
+}
+
+// Open connects to the volume and creates a client.
+func Open(conf *Config) (*Client, error) {
+	c := *conf
+	if c.MetaURL == "" {
+		return nil, fmt.Errorf("MetaURL is required")
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = "memory"
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = 100 << 20
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 300 << 20
+	}
+	if c.MaxUploads == 0 {
+		c.MaxUploads = 20
+	}
+	prefetch, umask := 1, uint16(022)
+	if c.Prefetch != nil {
+		prefetch = *c.Prefetch
+	}
+	if c.Umask != nil {
+		umask = *c.Umask
+	}
+	for _, t := range []*time.Duration{&c.AttrTimeout, &c.EntryTimeout, &c.DirEntryTimeout} {
+		if *t == 0 {
+			*t = time.Second
+		}
+	}
+
+	object.UserAgent = "JuiceFS-SDK " + version.Version()
+	metaConf := meta.DefaultConf()
+	metaConf.ReadOnly = c.ReadOnly
+	metaConf.NoBGJob = c.NoBGJob
+	metaConf.Subdir = c.Subdir
+	m := meta.NewClient(c.MetaURL, metaConf)
+	format, err := m.Load(true)
+	if err != nil {
+		return nil, fmt.Errorf("load setting: %s", err)
+	}
+	if st := m.Chroot(meta.Background(), metaConf.Subdir); st != 0 {
+		return nil, fmt.Errorf("chroot to %s: %s", metaConf.Subdir, st)
+	}
+	blob, err := cmd.NewReloadableStorage(format, m, func(f *meta.Format) {
+		if c.Bucket != "" {
+			f.Bucket = c.Bucket
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("object storage: %s", err)
+	}
+	chunkConf := &chunk.Config{
+		BlockSize:     format.BlockSize * 1024,
+		Compress:      format.Compression,
+		CompressDict:  format.CompressDict,
+		HashPrefix:    format.HashPrefix,
+		BlockChecksum: format.BlockChecksum,
+		GetTimeout:    time.Minute,
+		PutTimeout:    time.Minute,
+		MaxUpload:     c.MaxUploads,
+		MaxRetries:    10,
+		Writeback:     c.Writeback,
+		Prefetch:      prefetch,
+		BufferSize:    uint64(c.BufferSize),
+		UploadLimit:   c.UploadLimit * 1e6 / 8,
+		DownloadLimit: c.DownloadLimit * 1e6 / 8,
+
+		CacheDir:          c.CacheDir,
+		CacheSize:         uint64(c.CacheSize),
+		FreeSpace:         0.1,
+		CacheMode:         0600,
+		CacheFullBlock:    true,
+		CacheChecksum:     chunk.CsExtend,
+		CacheEviction:     chunk.Eviction2Random,
+		CacheScanInterval: time.Hour,
+		OSCache:           true,
+		AutoCreate:        true,
+	}
+	if chunkConf.UploadLimit == 0 {
+		chunkConf.UploadLimit = format.UploadLimit * 1e6 / 8
+	}
+	if chunkConf.DownloadLimit == 0 {
+		chunkConf.DownloadLimit = format.DownloadLimit * 1e6 / 8
+	}
+	chunkConf.SelfCheck(format.UUID)
+	store := chunk.NewCachedStore(blob, *chunkConf, nil)
+	m.OnMsg(meta.DeleteSlice, func(args ...interface{}) error {
+		return store.Remove(args[0].(uint64), int(args[1].(uint32)))
+	})
+	m.OnMsg(meta.CompactChunk, func(args ...interface{}) error {
+		return vfs.Compact(*chunkConf, store, args[0].([]meta.Slice), args[1].(uint64))
+	})
+	if err = m.NewSession(!c.NoBGJob); err != nil {
+		return nil, fmt.Errorf("new session: %s", err)
+	}
+	m.OnReload(func(f *meta.Format) {
+		store.UpdateLimit(f.UploadLimit, f.DownloadLimit)
+	})
+	vfsConf := &vfs.Config{
+		Meta:            metaConf,
+		Format:          *format,
+		Version:         version.Version(),
+		Chunk:           chunkConf,
+		AttrTimeout:     c.AttrTimeout,
+		EntryTimeout:    c.EntryTimeout,
+		DirEntryTimeout: c.DirEntryTimeout,
+		Subdir:          c.Subdir,
+		Pid:             os.Getpid(),
+		PPid:            os.Getppid(),
+	}
+	jfs, err := fs.NewFileSystem(vfsConf, m, store, nil)
+	if err != nil {
+		_ = m.CloseSession()
+		return nil, fmt.Errorf("initialize: %s", err)
+	}
+	return &Client{conf: &c, umask: umask, m: m, blob: blob, fs: jfs}, nil
+}
+
+// Close closes the session of the client, the opened files should be closed before it.
+func (c *Client) Close() error {
+	_ = c.fs.Close()
+	err := c.m.CloseSession()
+	object.Shutdown(c.blob)
+	return err
+}
+
+// Name returns the name of the volume.
+func (c *Client) Name() string {
+	return c.m.GetFormat().Name
+}
+
+func (c *Client) mode(perm iofs.FileMode) uint16 {
+	mode := uint16(perm.Perm())
+	if perm&iofs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if perm&iofs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if perm&iofs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// Stat returns the information of the file, following symlinks.
+func (c *Client) Stat(ctx context.Context, name string) (iofs.FileInfo, error) {
+	fi, eno := c.fs.Stat(newContext(ctx), name)
+	if eno != 0 {
+		return nil, pathError("stat", name, eno)
+	}
+	return newFileInfo(fi), nil
+}
+
+// Lstat returns the information of the file, without following symlinks.
+func (c *Client) Lstat(ctx context.Context, name string) (iofs.FileInfo, error) {
+	fi, eno := c.fs.Lstat(newContext(ctx), name)
+	if eno != 0 {
+		return nil, pathError("lstat", name, eno)
+	}
+	return newFileInfo(fi), nil
+}
+
+// Open opens the file or directory for reading.
+func (c *Client) Open(ctx context.Context, name string) (*File, error) {
+	return c.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// Create creates the file or truncates it if it exists, and opens it for reading and writing.
+func (c *Client) Create(ctx context.Context, name string) (*File, error) {
+	return c.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the file with the flags (os.O_RDONLY, os.O_WRONLY or os.O_RDWR, combined with os.O_CREATE,
+// os.O_EXCL, os.O_TRUNC and os.O_APPEND), perm (before umask) is used if the file is created.
+func (c *Client) OpenFile(ctx context.Context, name string, flag int, perm iofs.FileMode) (*File, error) {
+	mctx := newContext(ctx)
+	var mask uint32 = meta.MODE_MASK_R
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		mask = meta.MODE_MASK_W
+	case os.O_RDWR:
+		mask = meta.MODE_MASK_R | meta.MODE_MASK_W
+	}
+	var f *fs.File
+	var eno syscall.Errno = syscall.ENOENT
+	if flag&os.O_CREATE != 0 {
+		f, eno = c.fs.Create(mctx, name, c.mode(perm), c.umask)
+		if eno == 0 {
+			if mask != meta.MODE_MASK_W { // the created file is write only
+				_ = f.Close(mctx)
+				f, eno = c.fs.Open(mctx, name, mask)
+			}
+		} else if eno == syscall.EEXIST && flag&os.O_EXCL == 0 {
+			eno = syscall.ENOENT // open the existing one
+		}
+	}
+	if f == nil && eno == syscall.ENOENT {
+		f, eno = c.fs.Open(mctx, name, mask)
+		if eno == 0 && flag&os.O_TRUNC != 0 && mask&meta.MODE_MASK_W != 0 {
+			if eno = f.Truncate(mctx, 0); eno != 0 {
+				_ = f.Close(mctx)
+			}
+		}
+	}
+	if eno != 0 {
+		return nil, pathError("open", name, eno)
+	}
+	return &File{mu: &sync.Mutex{}, f: f, ctx: meta.WrapWithoutCancel(context.Background(), mctx.Pid(), mctx.Uid(), mctx.Gids()), name: name, flags: mask, append: flag&os.O_APPEND != 0}, nil
+}
+
+// ReadDir returns the entries in the directory.
+func (c *Client) ReadDir(ctx context.Context, name string) ([]iofs.FileInfo, error) {
+	mctx := newContext(ctx)
+	f, eno := c.fs.Open(mctx, name, 0)
+	if eno != 0 {
+		return nil, pathError("readdir", name, eno)
+	}
+	defer f.Close(mctx)
+	entries, eno := f.Readdir(mctx, 0)
+	if eno != 0 {
+		return nil, pathError("readdir", name, eno)
+	}
+	return newFileInfos(entries), nil
+}
+
+// Mkdir creates a directory, perm is masked by umask.
+func (c *Client) Mkdir(ctx context.Context, name string, perm iofs.FileMode) error {
+	return pathError("mkdir", name, c.fs.Mkdir(newContext(ctx), name, c.mode(perm), c.umask))
+}
+
+// MkdirAll creates a directory with all the missing parents.
+func (c *Client) MkdirAll(ctx context.Context, name string, perm iofs.FileMode) error {
+	return pathError("mkdir", name, c.fs.MkdirAll(newContext(ctx), name, c.mode(perm), c.umask))
+}
+
+// Remove removes a file or an empty directory.
+func (c *Client) Remove(ctx context.Context, name string) error {
+	return pathError("remove", name, c.fs.Delete(newContext(ctx), name))
+}
+
+// RemoveAll removes the file or directory with all its children, the removed files are moved into trash if
+// it's enabled.
+func (c *Client) RemoveAll(ctx context.Context, name string) error {
+	eno := c.fs.Rmr(newContext(ctx), name, false, meta.RmrDefaultThreads)
+	if eno == syscall.ENOENT {
+		return nil
+	}
+	return pathError("removeall", name, eno)
+}
+
+// Rename renames (moves) oldpath to newpath, newpath is replaced if it exists.
+func (c *Client) Rename(ctx context.Context, oldpath, newpath string) error {
+	return pathError("rename", oldpath, c.fs.Rename(newContext(ctx), oldpath, newpath, 0))
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (c *Client) Symlink(ctx context.Context, oldname, newname string) error {
+	return pathError("symlink", newname, c.fs.Symlink(newContext(ctx), oldname, newname))
+}
+
+// Readlink returns the target of the symbolic link.
+func (c *Client) Readlink(ctx context.Context, name string) (string, error) {
+	target, eno := c.fs.Readlink(newContext(ctx), name)
+	if eno != 0 {
+		return "", pathError("readlink", name, eno)
+	}
+	return string(target), nil
+}
+
+// Link creates newname as a hard link to oldname.
+func (c *Client) Link(ctx context.Context, oldname, newname string) error {
+	return pathError("link", newname, c.fs.Link(newContext(ctx), oldname, newname))
+}
+
+// Truncate changes the size of the file.
+func (c *Client) Truncate(ctx context.Context, name string, size int64) error {
+	if size < 0 {
+		return pathError("truncate", name, syscall.EINVAL)
+	}
+	return pathError("truncate", name, c.fs.Truncate(newContext(ctx), name, uint64(size)))
+}
+
+func (c *Client) setattr(ctx context.Context, op, name string, set func(mctx meta.Context, f *fs.File) syscall.Errno) error {
+	mctx := newContext(ctx)
+	f, eno := c.fs.Open(mctx, name, 0)
+	if eno != 0 {
+		return pathError(op, name, eno)
+	}
+	defer f.Close(mctx)
+	return pathError(op, name, set(mctx, f))
+}
+
+// Chmod changes the mode of the file.
+func (c *Client) Chmod(ctx context.Context, name string, mode iofs.FileMode) error {
+	return c.setattr(ctx, "chmod", name, func(mctx meta.Context, f *fs.File) syscall.Errno {
+		return f.Chmod(mctx, c.mode(mode))
+	})
+}
+
+// Chown changes the owner and group of the file.
+func (c *Client) Chown(ctx context.Context, name string, uid, gid uint32) error {
+	return c.setattr(ctx, "chown", name, func(mctx meta.Context, f *fs.File) syscall.Errno {
+		return f.Chown(mctx, uid, gid)
+	})
+}
+
+// Chtimes changes the access and modification times of the file.
+func (c *Client) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return c.setattr(ctx, "chtimes", name, func(mctx meta.Context, f *fs.File) syscall.Errno {
+		return f.Utime2(mctx, atime.Unix(), int64(atime.Nanosecond()), mtime.Unix(), int64(mtime.Nanosecond()))
+	})
+}
+
+// StatFS returns the total and available space of the volume in bytes.
+func (c *Client) StatFS(ctx context.Context) (total, avail uint64) {
+	return c.fs.StatFS(newContext(ctx))
+}
+
+// Walk walks the tree rooted at root like filepath.Walk, the paths are relative to the root of the client.
+func (c *Client) Walk(ctx context.Context, root string, fn func(name string, info iofs.FileInfo, err error) error) error {
+	info, err := c.Lstat(ctx, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = c.walk(ctx, root, info, fn)
+	}
+	if err == iofs.SkipDir || err == iofs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) walk(ctx context.Context, name string, info iofs.FileInfo, fn func(string, iofs.FileInfo, error) error) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+	entries, err := c.ReadDir(ctx, name)
+	if err1 := fn(name, info, err); err != nil || err1 != nil {
+		return err1
+	}
+	for _, e := range entries {
+		if err = c.walk(ctx, path.Join(name, e.Name()), e, fn); err != nil {
+			if !e.IsDir() || err != iofs.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package juicefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+func testClient(t *testing.T) *Client {
+	return testClientWith(t, &Config{})
+}
+
+func testClientWith(t *testing.T, conf *Config) *Client {
+	dir := t.TempDir()
+	metaUrl := "sqlite3://" + dir + "/jfs.db"
+	format := &meta.Format{
+		Name:      "test",
+		UUID:      "2b3f1a6e-5a0d-4c52-9f8e-6c1d0f7a3b21",
+		Storage:   "file",
+		Bucket:    dir + "/bucket/",
+		BlockSize: 1024,
+		TrashDays: 0,
+		DirStats:  true,
+	}
+	if err := meta.NewClient(metaUrl, nil).Init(format, false); err != nil {
+		t.Fatalf("format: %s", err)
+	}
+	conf.MetaURL = metaUrl
+	c, err := Open(conf)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	c := testClient(t)
+	ctx := context.Background()
+	if c.Name() != "test" {
+		t.Fatalf("name: %s", c.Name())
+	}
+
+	if err := c.MkdirAll(ctx, "/a/b", 0755); err != nil {
+		t.Fatalf("mkdirall: %s", err)
+	}
+	f, err := c.Create(ctx, "/a/b/file")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	data := bytes.Repeat([]byte("juicefs"), 300000) // 2 MiB in 3 blocks
+	if n, err := f.Write(data); err != nil || n != len(data) {
+		t.Fatalf("write: %d %s", n, err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("seek: %s", err)
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read: %d %s", len(got), err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	f, err = c.OpenFile(ctx, "/a/b/file", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open for append: %s", err)
+	}
+	if _, err = f.Write([]byte("end")); err != nil {
+		t.Fatalf("append: %s", err)
+	}
+	_ = f.Close()
+	f, err = c.Open(ctx, "/a/b/file")
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	buf := make([]byte, 10)
+	if n, err := f.ReadAt(buf, int64(len(data))-7); n != 10 || err != nil || string(buf) != "juicefsend" {
+		t.Fatalf("readat: %q %s", buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, int64(len(data))); n != 3 || err != io.EOF {
+		t.Fatalf("readat the end: %d %s", n, err)
+	}
+	if _, err = f.Write([]byte("x")); err == nil {
+		t.Fatalf("write into a read-only file should fail")
+	}
+	_ = f.Close()
+
+	if _, err = c.OpenFile(ctx, "/a/b/file", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, iofs.ErrExist) {
+		t.Fatalf("create an existing file exclusively: %v", err)
+	}
+	if _, err = c.Stat(ctx, "/a/none"); !errors.Is(err, iofs.ErrNotExist) {
+		t.Fatalf("stat a missing file: %v", err)
+	}
+
+	if err = c.Symlink(ctx, "b/file", "/a/link"); err != nil {
+		t.Fatalf("symlink: %s", err)
+	}
+	if target, err := c.Readlink(ctx, "/a/link"); err != nil || target != "b/file" {
+		t.Fatalf("readlink: %s %s", target, err)
+	}
+	if fi, err := c.Stat(ctx, "/a/link"); err != nil || fi.Size() != int64(len(data)+3) {
+		t.Fatalf("stat through symlink: %v %s", fi, err)
+	}
+	if fi, err := c.Lstat(ctx, "/a/link"); err != nil || fi.Mode()&iofs.ModeSymlink == 0 {
+		t.Fatalf("lstat symlink: %v %s", fi, err)
+	}
+	if err = c.Link(ctx, "/a/b/file", "/a/hard"); err != nil {
+		t.Fatalf("link: %s", err)
+	}
+	if err = c.Rename(ctx, "/a/hard", "/a/moved"); err != nil {
+		t.Fatalf("rename: %s", err)
+	}
+	if err = c.Truncate(ctx, "/a/moved", 7); err != nil {
+		t.Fatalf("truncate: %s", err)
+	}
+	if fi, err := c.Stat(ctx, "/a/b/file"); err != nil || fi.Size() != 7 {
+		t.Fatalf("stat truncated file: %v %s", fi, err)
+	}
+	if err = c.Chmod(ctx, "/a/moved", 0600|iofs.ModeSetgid); err != nil {
+		t.Fatalf("chmod: %s", err)
+	}
+	if fi, err := c.Stat(ctx, "/a/moved"); err != nil || fi.Mode().Perm() != 0600 || fi.Mode()&iofs.ModeSetgid == 0 {
+		t.Fatalf("stat after chmod: %v %s", fi, err)
+	}
+
+	entries, err := c.ReadDir(ctx, "/a")
+	if err != nil {
+		t.Fatalf("readdir: %s", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "b" || names[1] != "link" || names[2] != "moved" {
+		t.Fatalf("entries: %v", names)
+	}
+	var walked []string
+	if err = c.Walk(ctx, "/a", func(name string, info iofs.FileInfo, err error) error {
+		walked = append(walked, name)
+		return err
+	}); err != nil || len(walked) != 5 {
+		t.Fatalf("walk: %v %s", walked, err)
+	}
+
+	if err = c.Remove(ctx, "/a/b"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("remove a non-empty directory: %v", err)
+	}
+	if err = c.RemoveAll(ctx, "/a"); err != nil {
+		t.Fatalf("removeall: %s", err)
+	}
+	if err = c.RemoveAll(ctx, "/a"); err != nil {
+		t.Fatalf("removeall a missing directory: %s", err)
+	}
+	if total, avail := c.StatFS(ctx); total == 0 || avail == 0 {
+		t.Fatalf("statfs: %d %d", total, avail)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	c := testClient(t)
+	root := context.Background()
+	user := WithCredentials(root, 1000, 1000)
+	if err := c.Mkdir(root, "/private", 0700); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	if _, err := c.Create(user, "/private/file"); !errors.Is(err, iofs.ErrPermission) {
+		t.Fatalf("create in a private directory: %v", err)
+	}
+	if err := c.Chown(root, "/private", 1000, 1000); err != nil {
+		t.Fatalf("chown: %s", err)
+	}
+	f, err := c.Create(user, "/private/file")
+	if err != nil {
+		t.Fatalf("create as the owner: %s", err)
+	}
+	_ = f.Close()
+	fi, err := c.Stat(root, "/private/file")
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	if st, ok := fi.Sys().(*Stat); !ok || st.Uid != 1000 || st.Gid != 1000 || st.Nlink != 1 || fi.Mode().Perm() != 0644 {
+		t.Fatalf("created file: %+v", fi.Sys())
+	}
+	other := WithCredentials(root, 1001)
+	if _, err = c.OpenFile(other, "/private/file", os.O_RDONLY, 0); !errors.Is(err, iofs.ErrPermission) {
+		t.Fatalf("open as another user: %v", err)
+	}
+}
+
+func TestZeroConfig(t *testing.T) {
+	umask, prefetch := uint16(0), 0
+	c := testClientWith(t, &Config{Umask: &umask, Prefetch: &prefetch})
+	ctx := context.Background()
+	if err := c.Mkdir(ctx, "/shared", 0777); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	if fi, err := c.Stat(ctx, "/shared"); err != nil || fi.Mode().Perm() != 0777 {
+		t.Fatalf("mkdir with umask 0: %+v %v", fi, err)
+	}
+
+	f, err := c.Create(WithCredentials(ctx, 1000, 1000), "/shared/file")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	wf := f.WithContext(cctx)
+	if _, err = wf.Write([]byte("hello")); err != nil {
+		t.Fatalf("write with context: %s", err)
+	}
+	cancel()
+	if off, err := f.Seek(0, io.SeekCurrent); err != nil || off != 5 {
+		t.Fatalf("the offset should be shared: %d %v", off, err)
+	}
+	if fi, err := wf.Stat(); err != nil || fi.Sys().(*Stat).Uid != 1000 {
+		t.Fatalf("the credentials should be kept: %+v %v", fi, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package juicefs
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"sync"
+	"syscall"
+
+	"github.com/juicedata/juicefs/pkg/fs"
+	"github.com/juicedata/juicefs/pkg/meta"
+)
+
+// File is an opened file or directory, it implements io.Reader, io.ReaderAt, io.Writer, io.WriterAt,
+// io.Seeker and io.Closer. The calls are done with the credentials used to open it, and not canceled
+// unless a context is set by WithContext.
+type File struct {
+	mu     *sync.Mutex // serializes the appends
+	f      *fs.File
+	ctx    meta.Context
+	name   string
+	flags  uint32 // meta.MODE_MASK_R and meta.MODE_MASK_W
+	append bool
+}
+
+var (
+	_ io.ReadWriteSeeker = (*File)(nil)
+	_ io.ReaderAt        = (*File)(nil)
+	_ io.WriterAt        = (*File)(nil)
+	_ io.Closer          = (*File)(nil)
+)
+
+// WithContext returns the file that does the calls with ctx (to cancel them), it shares the offset and
+// the credentials with f.
+func (f *File) WithContext(ctx context.Context) *File {
+	nf := *f
+	nf.ctx = meta.WrapWithoutCancel(ctx, f.ctx.Pid(), f.ctx.Uid(), f.ctx.Gids())
+	return &nf
+}
+
+// Name returns the path used to open the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Stat returns the information of the file.
+func (f *File) Stat() (iofs.FileInfo, error) {
+	fi, err := f.f.Stat()
+	if err != nil {
+		return nil, f.error("stat", err)
+	}
+	return newFileInfo(fi), nil
+}
+
+// Read reads up to len(b) bytes from the current offset, it returns io.EOF at the end of the file.
+func (f *File) Read(b []byte) (int, error) {
+	if f.flags&meta.MODE_MASK_R == 0 {
+		return 0, f.errno("read", syscall.EBADF)
+	}
+	n, err := f.f.Read(f.ctx, b)
+	if err != nil && err != io.EOF {
+		err = f.error("read", err)
+	}
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the offset, it returns io.EOF if fewer bytes are read.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if f.flags&meta.MODE_MASK_R == 0 {
+		return 0, f.errno("read", syscall.EBADF)
+	}
+	var got int
+	for got < len(b) {
+		n, err := f.f.Pread(f.ctx, b[got:], off+int64(got))
+		got += n
+		if err != nil {
+			if err != io.EOF {
+				err = f.error("read", err)
+			}
+			return got, err
+		}
+		if n == 0 {
+			return got, io.EOF
+		}
+	}
+	return got, nil
+}
+
+// Write writes b at the current offset, or at the end of the file if it's opened with os.O_APPEND.
+func (f *File) Write(b []byte) (int, error) {
+	if f.flags&meta.MODE_MASK_W == 0 {
+		return 0, f.errno("write", syscall.EBADF)
+	}
+	if f.append {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, err := f.f.Seek(f.ctx, 0, io.SeekEnd); err != nil {
+			return 0, f.error("write", err)
+		}
+	}
+	n, eno := f.f.Write(f.ctx, b)
+	return n, f.errno("write", eno)
+}
+
+// WriteAt writes b at the offset.
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	if f.flags&meta.MODE_MASK_W == 0 || f.append {
+		return 0, f.errno("write", syscall.EBADF)
+	}
+	n, eno := f.f.Pwrite(f.ctx, b, off)
+	return n, f.errno("write", eno)
+}
+
+// Seek sets the offset for the next Read or Write.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	off, err := f.f.Seek(f.ctx, offset, whence)
+	if err != nil {
+		err = f.error("seek", err)
+	}
+	return off, err
+}
+
+// Truncate changes the size of the file.
+func (f *File) Truncate(size int64) error {
+	if size < 0 {
+		return f.errno("truncate", syscall.EINVAL)
+	}
+	if f.flags&meta.MODE_MASK_W == 0 {
+		return f.errno("truncate", syscall.EBADF)
+	}
+	return f.errno("truncate", f.f.Truncate(f.ctx, uint64(size)))
+}
+
+// Sync uploads the written data into object storage and persists the length of the file.
+func (f *File) Sync() error {
+	return f.errno("sync", f.f.Fsync(f.ctx))
+}
+
+// Readdir returns up to n entries (all of them if n <= 0) of the directory.
+func (f *File) Readdir(n int) ([]iofs.FileInfo, error) {
+	if n < 0 {
+		n = 0
+	}
+	entries, eno := f.f.Readdir(f.ctx, n)
+	if eno != 0 {
+		return nil, f.errno("readdir", eno)
+	}
+	return newFileInfos(entries), nil
+}
+
+// Close flushes the written data and closes the file.
+func (f *File) Close() error {
+	return f.errno("close", f.f.Close(f.ctx))
+}
+
+func (f *File) errno(op string, eno syscall.Errno) error {
+	return pathError(op, f.name, eno)
+}
+
+func (f *File) error(op string, err error) error {
+	if eno, ok := err.(syscall.Errno); ok {
+		return f.errno(op, eno)
+	}
+	return &iofs.PathError{Op: op, Path: f.name, Err: err}
+}
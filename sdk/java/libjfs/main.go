@@ -322,6 +322,7 @@ type javaConf struct {
 	OpenCache           string `json:"openCache"`
 	BackupMeta          string `json:"backupMeta"`
 	BackupSkipTrash     bool   `json:"backupSkipTrash"`
+	BackupMetaKeep      int    `json:"backupMetaKeep"`
 	Heartbeat           string `json:"heartbeat"`
 	CacheDir            string `json:"cacheDir"`
 	CacheSize           string `json:"cacheSize"`
@@ -616,6 +617,7 @@ func jfs_init(cname, cjsonConf, user, group, superuser, supergroup *C.char) int6
 		chunkConf := chunk.Config{
 			BlockSize:         format.BlockSize * 1024,
 			Compress:          format.Compression,
+			CompressDict:      format.CompressDict,
 			CacheDir:          jConf.CacheDir,
 			CacheMode:         0644, // all user can read cache
 			CacheSize:         utils.ParseBytesStr("cache-size", jConf.CacheSize, 'M'),
@@ -635,6 +637,7 @@ func jfs_init(cname, cjsonConf, user, group, superuser, supergroup *C.char) int6
 			Prefetch:          jConf.Prefetch,
 			Writeback:         jConf.Writeback,
 			HashPrefix:        format.HashPrefix,
+			BlockChecksum:     format.BlockChecksum,
 			GetTimeout:        utils.Duration(jConf.GetTimeout),
 			PutTimeout:        utils.Duration(jConf.PutTimeout),
 			BufferSize:        utils.ParseBytesStr("memory-size", jConf.MemorySize, 'M'),
@@ -685,9 +688,10 @@ func jfs_init(cname, cjsonConf, user, group, superuser, supergroup *C.char) int6
 			Subdir:          jConf.Subdir,
 			BackupMeta:      utils.Duration(jConf.BackupMeta),
 			BackupSkipTrash: jConf.BackupSkipTrash,
+			BackupMetaKeep:  jConf.BackupMetaKeep,
 		}
 		if !jConf.ReadOnly && !jConf.NoSession && !jConf.NoBGJob && conf.BackupMeta > 0 {
-			go vfs.Backup(m, blob, conf.BackupMeta, conf.BackupSkipTrash)
+			go vfs.Backup(m, blob, conf.BackupMeta, conf.BackupSkipTrash, conf.BackupMetaKeep)
 		}
 		if !jConf.NoUsageReport && !jConf.NoSession {
 			go usage.ReportUsage(m, "java-sdk "+version.Version())
@@ -426,6 +426,7 @@ public class JuiceFileSystemImpl extends FileSystem {
     obj.put("openCache", getConf(conf, "open-cache", "0.0"));
     obj.put("backupMeta", getConf(conf, "backup-meta", "3600"));
     obj.put("backupSkipTrash", Boolean.valueOf(getConf(conf, "backup-skip-trash", "false")));
+    obj.put("backupMetaKeep", Integer.valueOf(getConf(conf, "backup-meta-keep", "0")));
     obj.put("heartbeat", getConf(conf, "heartbeat", "12"));
     obj.put("attrTimeout", getConf(conf, "attr-cache", "0.0"));
     obj.put("entryTimeout", getConf(conf, "entry-cache", "0.0"));
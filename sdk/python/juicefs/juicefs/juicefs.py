@@ -105,6 +105,7 @@ class Client(object):
                  push_gateway="", push_interval="10", push_auth="", push_labels="", push_graphite="", push_remote_write="", 
                  push_remote_write_auth=""):
         self.lib = JuiceFSLib()
+        self.name = name
         kwargs = {}
         kwargs["meta"] = meta
         kwargs["bucket"] = bucket
@@ -500,11 +501,22 @@ class _File(object):
         return buf
 
     def readinto(self, buffer):
-        data = self.read(len(buffer))
-        if not data:
-            return 0
-        buffer[:len(data)] = data
-        return len(data)
+        """Read into a writable bytes-like object (bytearray, numpy array, etc.) directly,
+        returns the number of bytes read."""
+        self._check_closed()
+        if self.flag & MODE_READ == 0:
+            raise io.UnsupportedOperation('not readable')
+        m = memoryview(buffer).cast('B')
+        got = 0
+        while got < len(m):
+            n = min(len(m) - got, 4 << 20)
+            buf = (c_char * n).from_buffer(m[got:got+n])
+            n = self.lib.jfs_pread(c_int64(_tid()), c_int32(self.fd), buf, c_int32(n), c_int64(self.off+got))
+            if n == 0:
+                break
+            got += n
+        self.off += got
+        return got
 
     def write(self, data):
         """Write the string data to the file."""
@@ -20,6 +20,7 @@ import os
 from stat import S_ISDIR, S_ISLNK, S_ISREG
 
 from fsspec.spec import AbstractFileSystem, AbstractBufferedFile
+from fsspec.utils import infer_storage_options, stringify_path
 
 from .juicefs import Client
 
@@ -29,16 +30,38 @@ logger = logging.getLogger("fsspec.jfs")
 class JuiceFS(AbstractFileSystem):
     """
     A JuiceFS file system.
+
+    The files can be accessed by URLs like `jfs://myjfs/path/to/file`, the host of the URL is the name
+    of the volume, and the metadata engine is given by `meta` or the environment variable JUICEFS_META.
     """
     protocol = "jfs", "juicefs"
-    def __init__(self, name, auto_mkdir=False, **kwargs):
+    def __init__(self, name="", auto_mkdir=False, **kwargs):
         if self._cached:
             return
         super().__init__(**kwargs)
         self.auto_mkdir = auto_mkdir
         self.temppath = kwargs.pop("temppath", "/tmp")
+        if "meta" not in kwargs:
+            kwargs["meta"] = os.getenv("JUICEFS_META")
+        if not kwargs["meta"]:
+            raise ValueError("meta is required, or set it in the environment variable JUICEFS_META")
         self.fs = Client(name, **kwargs)
 
+    @classmethod
+    def _strip_protocol(cls, path):
+        path = stringify_path(path)
+        for protocol in cls.protocol:
+            if path.startswith(protocol + "://"):
+                # the host is the name of the volume
+                path = "/" + path[len(protocol) + 3:].partition("/")[2]
+                break
+        return path.rstrip("/") or cls.root_marker
+
+    @staticmethod
+    def _get_kwargs_from_urls(path):
+        host = infer_storage_options(path).get("host")
+        return {"name": host} if host else {}
+
     @property
     def fsid(self):
         return "jfs_" + self.fs.name
@@ -60,7 +83,8 @@ class JuiceFS(AbstractFileSystem):
         self.fs.rmdir(self._strip_protocol(path))
 
     def ls(self, path, detail=False, **kwargs):
-        infos = self.fs.listdir(self._strip_protocol(path), detail)
+        path = self._strip_protocol(path)
+        infos = self.fs.listdir(path, detail)
         if not detail:
             return infos
         stats = []
@@ -13,9 +13,13 @@ setup(
     packages=find_packages(where="."),
     include_package_data=True,
     install_requires=['six'],
+    extras_require={
+        'fsspec': ['fsspec'],
+    },
     entry_points={
         'fsspec.specs': [
-            'jfs = juicefs.JuiceFS',
+            'jfs = juicefs.spec:JuiceFS',
+            'juicefs = juicefs.spec:JuiceFS',
         ],
     },
 )
@@ -1,5 +1,6 @@
 import pytest
 
+import fsspec
 from fsspec import filesystem
 import fsspec.tests.abstract as abstract
 
@@ -28,3 +29,20 @@ class TestJuiceFSPut(abstract.AbstractPutTests, JuiceFSFixtures):
 
 class TestJuiceFSCopy(abstract.AbstractCopyTests, JuiceFSFixtures):
     pass
+
+
+def test_url():
+    assert JuiceFS._strip_protocol("jfs://test/a/b.csv") == "/a/b.csv"
+    assert JuiceFS._strip_protocol("juicefs://test/a/") == "/a"
+    assert JuiceFS._strip_protocol("/a/b") == "/a/b"
+    assert JuiceFS._get_kwargs_from_urls("jfs://test/a") == {"name": "test"}
+    assert JuiceFS._get_kwargs_from_urls("/a") == {}
+
+
+def test_open_url(tmpdir):
+    meta = os.getenv("JUICEFS_META", "redis://localhost")
+    with fsspec.open(f"jfs://test{tmpdir}/data.csv", "w", meta=meta, auto_mkdir=True) as f:
+        f.write("a,b\n1,2\n")
+    with fsspec.open(f"jfs://test{tmpdir}/data.csv", "rb", meta=meta) as f:
+        buf = bytearray(8)
+        assert f.readinto(buf) == 8 and bytes(buf) == b"a,b\n1,2\n"